@@ -6,6 +6,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/djylb/nps/lib/acmedns"
 	"github.com/djylb/nps/lib/common"
 	"github.com/djylb/nps/lib/conn"
 	"github.com/djylb/nps/lib/file"
@@ -195,6 +196,7 @@ func (s *Bridge) handleConfigNewHost(c *conn.Conn, client *file.Client, uuid str
 	if host.Location == "" {
 		host.Location = "/"
 	}
+	host.AutoSSLDNS = configHostAutoSSLDNS(host)
 	rollbackHost, ok := s.publishConfigHost(host, uuid)
 	if !ok {
 		return writeConfigAddFail(c) == nil
@@ -209,6 +211,18 @@ func (s *Bridge) handleConfigNewHost(c *conn.Conn, client *file.Client, uuid str
 	return true
 }
 
+// configHostAutoSSLDNS returns the DNS-01 setting a client may give its own
+// host: off or nothing. Providers are called by the server, so only
+// administrators can name them.
+func configHostAutoSSLDNS(host *file.Host) string {
+	spec, err := acmedns.Normalize(host.AutoSSLDNS)
+	if err != nil || (spec != "" && spec != acmedns.Off) {
+		logs.Warn("Ignore auto_ssl_dns %q of host %s from client config", host.AutoSSLDNS, host.Host)
+		return ""
+	}
+	return spec
+}
+
 func (s *Bridge) publishConfigHost(host *file.Host, uuid string) (func(), bool) {
 	if host == nil || host.Client == nil {
 		return nil, false
@@ -399,6 +413,7 @@ func newConfigTaskVariant(task *file.Tunnel, client *file.Client, ports []int, t
 		DestAclRules:  task.DestAclRules,
 		LocalPath:     task.LocalPath,
 		StripPre:      task.StripPre,
+		ForwardAuth:   task.ForwardAuth,
 		ReadOnly:      task.ReadOnly,
 		Socks5Proxy:   task.Socks5Proxy,
 		HttpProxy:     task.HttpProxy,
@@ -79,6 +79,20 @@ func assertBridgeEventBackpressureBounded(t *testing.T, start time.Time) {
 	}
 }
 
+func TestConfigHostAutoSSLDNSKeepsOnlyOff(t *testing.T) {
+	for spec, want := range map[string]string{
+		"":                               "",
+		" None ":                         "off",
+		"http://169.254.169.254/dns":     "",
+		"rfc2136://127.0.0.1:53?key=k":   "",
+		"exec:/bin/sh -c 'touch /tmp/x'": "",
+	} {
+		if got := configHostAutoSSLDNS(&file.Host{Host: "a.example.com", AutoSSLDNS: spec}); got != want {
+			t.Fatalf("configHostAutoSSLDNS(%q) = %q, want %q", spec, got, want)
+		}
+	}
+}
+
 func TestPublishNoStoreHostUpdateRollsBackWhenQueueIsFull(t *testing.T) {
 	oldTimeout := bridgeConfigEventEnqueueTimeout
 	bridgeConfigEventEnqueueTimeout = 20 * time.Millisecond
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/djylb/nps/lib/file"
+	"github.com/djylb/nps/lib/servercfg"
+	webservice "github.com/djylb/nps/web/service"
+)
+
+// offlineApplyRuntime lets "nps apply" reuse the management services while nps
+// is stopped: tunnels are only written to the store and start with the next
+// nps run instead of opening listeners in this process.
+type offlineApplyRuntime struct {
+	webservice.Runtime
+}
+
+func (offlineApplyRuntime) AddTunnel(*file.Tunnel) error {
+	return nil
+}
+
+func (offlineApplyRuntime) TunnelRunning(int) bool {
+	return false
+}
+
+func (offlineApplyRuntime) StartTunnel(id int) error {
+	return setOfflineTunnelStatus(id, true)
+}
+
+func (offlineApplyRuntime) StopTunnel(id int) error {
+	return setOfflineTunnelStatus(id, false)
+}
+
+func setOfflineTunnelStatus(id int, status bool) error {
+	if err := file.GetDb().UpdateTaskStatus(id, status); err != nil {
+		return err
+	}
+	if journal := file.CurrentChangeJournal(); journal != nil {
+		if tunnel, err := file.GetDb().GetTask(id); err == nil {
+			return journal.RecordTunnel(tunnel)
+		}
+	}
+	return nil
+}
+
+// runApply converges the stored configuration onto the desired state in
+// path. Like restore it edits the store directly, so stop nps first or send
+// the document to POST /api/system/apply on a running node. With dryRun the
+// plan is printed and nothing is written.
+func runApply(cfg *servercfg.Snapshot, path string, dryRun bool) error {
+	if strings.TrimSpace(path) == "" {
+		return errors.New("missing desired state file, use -f")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	state, err := webservice.ParseDesiredState(data)
+	if err != nil {
+		return err
+	}
+	configureServerStorage(cfg)
+	initializeServerStore()
+	if !dryRun {
+		configureChangeJournal(cfg)
+		configureRecycleBin(cfg)
+		configureRevisionHistory(cfg)
+	}
+	configureCertStore(cfg)
+	backend := webservice.DefaultBackend()
+	backend.Runtime = offlineApplyRuntime{Runtime: backend.Runtime}
+	services := webservice.BindDefaultServices(webservice.Services{Backend: backend}, func() *servercfg.Snapshot { return cfg })
+	result, err := services.Apply.Apply(webservice.ApplyInput{State: state, DryRun: dryRun})
+	if err != nil {
+		return err
+	}
+	printApplyResult(result)
+	if !dryRun && result.Applied > 0 {
+		file.GetDb().FlushToDisk()
+	}
+	if result.Failed > 0 {
+		return fmt.Errorf("%d change(s) failed", result.Failed)
+	}
+	return nil
+}
+
+func printApplyResult(result webservice.ApplyResult) {
+	marks := map[string]string{
+		webservice.ApplyActionCreate: "+",
+		webservice.ApplyActionUpdate: "~",
+		webservice.ApplyActionDelete: "-",
+	}
+	for _, change := range result.Changes {
+		line := fmt.Sprintf("%s %s %s", marks[change.Action], change.Resource, change.Key)
+		if len(change.Fields) > 0 {
+			line += " (" + strings.Join(change.Fields, ", ") + ")"
+		}
+		if change.Status != webservice.ApplyStatusPlanned && change.Status != webservice.ApplyStatusApplied {
+			line += " [" + change.Status + "]"
+		}
+		if change.Error != "" {
+			line += ": " + change.Error
+		}
+		fmt.Println(line)
+	}
+	if result.DryRun {
+		fmt.Printf("plan: %d to change, %d unchanged\n", len(result.Changes), result.Unchanged)
+		return
+	}
+	fmt.Printf("applied %d, failed %d, unchanged %d\n", result.Applied, result.Failed, result.Unchanged)
+}
@@ -30,11 +30,14 @@ import (
 )
 
 var (
-	logLevel string
-	confPath = flag.StringP("conf_path", "c", "", "Set Conf Path")
-	ver      = flag.BoolP("version", "v", false, "Show Current Version")
-	genTOTP  = flag.Bool("gen2fa", false, "Generate TOTP Secret")
-	getTOTP  = flag.String("get2fa", "", "Get TOTP Code")
+	logLevel  string
+	confPath  = flag.StringP("conf_path", "c", "", "Set Conf Path")
+	ver       = flag.BoolP("version", "v", false, "Show Current Version")
+	genTOTP   = flag.Bool("gen2fa", false, "Generate TOTP Secret")
+	getTOTP   = flag.String("get2fa", "", "Get TOTP Code")
+	atTime    = flag.String("at", "", "Restore point for the restore command (RFC 3339 or unix seconds)")
+	stateFile = flag.StringP("file", "f", "", "Desired state file (YAML or JSON) for the apply command")
+	dryRun    = flag.Bool("dry_run", false, "Print the apply plan without changing anything")
 )
 
 func main() {
@@ -126,6 +129,7 @@ func (p *nps) Start(s service.Service) error {
 func (p *nps) Stop(s service.Service) error {
 	_, _ = s.Status()
 	routers.StopManagedRuntime()
+	file.CurrentTrafficRecorder().Close()
 	p.signalExit()
 	if npsServiceInteractive() {
 		os.Exit(0)
@@ -329,6 +333,21 @@ func handleNPSServiceCommand(cmd string, svc service.Service, svcConfig *service
 			logs.Error("%v", err)
 		}
 		return true
+	case "migrate-store":
+		if err := runStoreMigration(servercfg.Current()); err != nil {
+			logs.Error("migrate store error: %v", err)
+		}
+		return true
+	case "restore":
+		if err := runJournalRestore(servercfg.Current(), *atTime); err != nil {
+			logs.Error("restore error: %v", err)
+		}
+		return true
+	case "apply":
+		if err := runApply(servercfg.Current(), *stateFile, *dryRun); err != nil {
+			logs.Error("apply error: %v", err)
+		}
+		return true
 	default:
 		return false
 	}
@@ -336,7 +355,14 @@ func handleNPSServiceCommand(cmd string, svc service.Service, svcConfig *service
 
 func run() {
 	cfg := servercfg.Current()
+	configureServerStorage(cfg)
 	file.MigrateLegacyData()
+	configureChangeJournal(cfg)
+	configureConfigSnapshots(cfg)
+	configureRecycleBin(cfg)
+	configureRevisionHistory(cfg)
+	configureCertStore(cfg)
+	configureTrafficSeries(cfg)
 
 	runMode := resolveServerRunMode(cfg)
 	warnLegacyManagedNodeMode(cfg, runMode)
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/djylb/nps/lib/common"
+	"github.com/djylb/nps/lib/file"
+	"github.com/djylb/nps/lib/logs"
+	"github.com/djylb/nps/lib/servercfg"
+)
+
+func persistenceOptionsFromConfig(cfg *servercfg.Snapshot) file.PersistenceOptions {
+	cfg = servercfg.Resolve(cfg)
+	return file.PersistenceOptions{
+		Backend: cfg.Storage.Backend,
+		Path:    cfg.Storage.Path,
+	}
+}
+
+func configureServerStorage(cfg *servercfg.Snapshot) {
+	options := persistenceOptionsFromConfig(cfg)
+	if err := file.ConfigurePersistence(options); err != nil {
+		logs.Error("invalid storage_backend %q: %v, using json files", options.Backend, err)
+		return
+	}
+	if current := file.CurrentPersistenceOptions(); current.Backend == file.PersistenceBackendEmbedded {
+		logs.Info("using embedded store %s", current.ResolveStorePath(common.GetRunPath()))
+	}
+}
+
+// runStoreMigration copies conf/*.json into the embedded store configured by
+// storage_path. It only reads the JSON files, so it is safe next to a running
+// nps; switch storage_backend=embedded and restart to start using the result.
+func runStoreMigration(cfg *servercfg.Snapshot) error {
+	result, err := file.MigrateJSONToEmbeddedStore(common.GetRunPath(), persistenceOptionsFromConfig(cfg))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("migrated %d users, %d clients, %d tunnels, %d hosts (global: %t) into %s\n",
+		result.Users, result.Clients, result.Tunnels, result.Hosts, result.Global, result.Path)
+	return nil
+}
+
+func changeJournalOptionsFromConfig(cfg *servercfg.Snapshot) file.ChangeJournalOptions {
+	cfg = servercfg.Resolve(cfg)
+	return file.ChangeJournalOptions{
+		Path:     cfg.Storage.JournalPath,
+		MaxBytes: int64(cfg.Storage.JournalMaxSize) << 20,
+		MaxFiles: cfg.Storage.JournalMaxFiles,
+	}
+}
+
+func configureChangeJournal(cfg *servercfg.Snapshot) {
+	if !servercfg.Resolve(cfg).Storage.JournalEnable {
+		return
+	}
+	journal, err := file.ConfigureChangeJournal(changeJournalOptionsFromConfig(cfg))
+	if err != nil {
+		logs.Error("open change journal error: %v, management changes will not be journaled", err)
+		return
+	}
+	logs.Info("change journal enabled at %s", journal.Dir())
+}
+
+func configArchiveOptionsFromConfig(cfg *servercfg.Snapshot) file.ConfigArchiveOptions {
+	cfg = servercfg.Resolve(cfg)
+	return file.ConfigArchiveOptions{
+		Path:     cfg.Storage.SnapshotPath,
+		Interval: time.Duration(cfg.Storage.SnapshotInterval) * time.Minute,
+		Keep:     cfg.Storage.SnapshotKeep,
+		MaxAge:   time.Duration(cfg.Storage.SnapshotMaxDays) * 24 * time.Hour,
+	}
+}
+
+// configureConfigSnapshots always enables on-demand snapshots through the
+// management API; snapshot_interval only controls the scheduler.
+func configureConfigSnapshots(cfg *servercfg.Snapshot) {
+	options := configArchiveOptionsFromConfig(cfg)
+	archiver, err := file.ConfigureConfigArchiver(options)
+	if err != nil {
+		logs.Error("open config snapshot dir error: %v, snapshots are disabled", err)
+		return
+	}
+	if options.Interval > 0 {
+		logs.Info("config snapshots every %s into %s", options.Interval, archiver.Dir())
+	}
+}
+
+// configureRecycleBin makes client, tunnel and host deletes recoverable for
+// trash_retention_days; 0 keeps deletes permanent.
+func configureRecycleBin(cfg *servercfg.Snapshot) {
+	cfg = servercfg.Resolve(cfg)
+	options := file.RecycleBinOptions{
+		Path:      cfg.Storage.TrashPath,
+		Retention: time.Duration(cfg.Storage.TrashRetentionDays) * 24 * time.Hour,
+	}
+	bin, err := file.ConfigureRecycleBin(options)
+	if err != nil {
+		logs.Error("open recycle bin error: %v, deletes will be permanent", err)
+		return
+	}
+	if bin != nil {
+		logs.Info("deleted clients, tunnels and hosts are kept for %s", bin.Retention())
+	}
+}
+
+// configureRevisionHistory keeps the last history_keep revisions of every
+// user, client, tunnel and host; 0 disables the history.
+func configureRevisionHistory(cfg *servercfg.Snapshot) {
+	cfg = servercfg.Resolve(cfg)
+	options := file.RevisionHistoryOptions{
+		Path: cfg.Storage.HistoryPath,
+		Keep: cfg.Storage.HistoryKeep,
+	}
+	history, err := file.ConfigureRevisionHistory(options)
+	if err != nil {
+		logs.Error("open revision history error: %v, past revisions will not be kept", err)
+		return
+	}
+	if history != nil {
+		logs.Info("keeping the last %d revisions of each resource in %s", history.Keep(), history.Dir())
+	}
+}
+
+// configureCertStore opens the named certificates that hosts reference by
+// cert_id.
+func configureCertStore(cfg *servercfg.Snapshot) {
+	cfg = servercfg.Resolve(cfg)
+	if _, err := file.ConfigureCertStore(file.CertStoreOptions{Path: cfg.Storage.CertStorePath}); err != nil {
+		logs.Error("open certificate store error: %v, hosts cannot use stored certificates", err)
+	}
+}
+
+// runJournalRestore rewrites the database as it was at the given time by
+// replaying the change journal. It edits the stored files directly, so stop
+// nps first or use POST /api/system/restore on a running node. The state it
+// replaces is checkpointed into the journal, so a restore can be undone by
+// restoring again.
+func runJournalRestore(cfg *servercfg.Snapshot, at string) error {
+	if !servercfg.Resolve(cfg).Storage.JournalEnable {
+		return file.ErrChangeJournalDisabled
+	}
+	point, err := file.ParseRestorePoint(at)
+	if err != nil {
+		return err
+	}
+	configureServerStorage(cfg)
+	options := changeJournalOptionsFromConfig(cfg)
+	snapshot, result, err := file.ReplayChangeJournal(options.ResolvePath(common.GetRunPath()), point)
+	if err != nil {
+		return err
+	}
+	journal, err := file.ConfigureChangeJournal(options)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = journal.Close() }()
+	if err := file.NewLocalStore().ImportConfigSnapshot(snapshot); err != nil {
+		return err
+	}
+	file.GetDb().FlushToDisk()
+	if err := journal.Checkpoint("restore"); err != nil {
+		return err
+	}
+	fmt.Printf("restored %d users, %d clients, %d tunnels, %d hosts as of %s (checkpoint %s + %d changes)\n",
+		len(snapshot.Users), len(snapshot.Clients), len(snapshot.Tunnels), len(snapshot.Hosts),
+		point.Format(time.RFC3339), time.Unix(0, result.CheckpointAt).Format(time.RFC3339), result.Entries)
+	return nil
+}
+
+// configureTrafficSeries starts the per-minute traffic sampler behind
+// /api/usage/series unless usage_series_enable is off.
+func configureTrafficSeries(cfg *servercfg.Snapshot) {
+	cfg = servercfg.Resolve(cfg)
+	if !cfg.Storage.UsageSeriesEnable {
+		return
+	}
+	recorder, err := file.ConfigureTrafficRecorder(file.TrafficSeriesOptions{
+		Path:            cfg.Storage.UsageSeriesPath,
+		MinuteRetention: time.Duration(cfg.Storage.UsageMinuteRetentionHours) * time.Hour,
+		HourRetention:   time.Duration(cfg.Storage.UsageHourRetentionDays) * 24 * time.Hour,
+		DayRetention:    time.Duration(cfg.Storage.UsageDayRetentionDays) * 24 * time.Hour,
+	})
+	if err != nil {
+		logs.Error("open traffic series error: %v, traffic history will not be recorded", err)
+		return
+	}
+	logs.Info("recording traffic time series in %s", recorder.Dir())
+}
@@ -0,0 +1,319 @@
+// Package acmedns sets the TXT records of ACME DNS-01 challenges, so
+// certificates can be issued without reachable ports 80 and 443 and for
+// wildcard names.
+//
+// A provider is written as one string:
+//
+//	rfc2136://[key-name:base64-secret@]server[:53][?alg=hmac-sha256&net=tcp]
+//	exec:/path/to/hook
+//	https://dns-hook.example.com/acme
+//
+// RFC 2136 sends dynamic updates, signed with TSIG when a key is given. An
+// exec hook is run as "hook present|cleanup <fqdn> <value>" with the record
+// in NPS_ACME_* environment variables. A webhook gets a JSON POST for each
+// record and must answer 2xx.
+package acmedns
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/djylb/nps/lib/common"
+	"github.com/libdns/libdns"
+	"github.com/miekg/dns"
+)
+
+// Off disables DNS-01 for a host when the server has a global provider.
+const Off = "off"
+
+// DefaultTimeout bounds one update, hook run or webhook call.
+const DefaultTimeout = 30 * time.Second
+
+// DefaultTTL is used for records without a TTL.
+const DefaultTTL = 60 * time.Second
+
+// maxOutputBytes bounds the hook output and webhook body kept in errors.
+const maxOutputBytes = 512
+
+// ErrInvalidProvider is returned for provider strings that cannot be used.
+var ErrInvalidProvider = errors.New("invalid acme dns provider")
+
+// Provider adds and removes challenge records. It satisfies the DNS provider
+// interface of certmagic.
+type Provider interface {
+	libdns.RecordAppender
+	libdns.RecordDeleter
+}
+
+// Action is the challenge step passed to hooks and webhooks.
+const (
+	ActionPresent = "present"
+	ActionCleanup = "cleanup"
+)
+
+// New returns the provider described by spec.
+func New(spec string) (Provider, error) {
+	spec = strings.TrimSpace(spec)
+	scheme, rest, ok := strings.Cut(spec, ":")
+	if !ok || rest == "" {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidProvider, spec)
+	}
+	switch strings.ToLower(scheme) {
+	case "rfc2136":
+		return newRFC2136(spec)
+	case "exec":
+		command := strings.TrimPrefix(rest, "//")
+		if strings.TrimSpace(command) == "" {
+			return nil, fmt.Errorf("%w: exec hook without a command", ErrInvalidProvider)
+		}
+		return &Exec{Command: command}, nil
+	case "http", "https":
+		u, err := url.Parse(spec)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidProvider, spec)
+		}
+		return &Webhook{URL: spec}, nil
+	}
+	return nil, fmt.Errorf("%w: unknown scheme %q", ErrInvalidProvider, scheme)
+}
+
+// Normalize returns spec trimmed, "" when empty, or Off for off, none and
+// false. Other values must describe a provider.
+func Normalize(spec string) (string, error) {
+	spec = strings.TrimSpace(spec)
+	switch strings.ToLower(spec) {
+	case "":
+		return "", nil
+	case Off, "none", "false", "0":
+		return Off, nil
+	}
+	if _, err := New(spec); err != nil {
+		return "", err
+	}
+	return spec, nil
+}
+
+// IsExec reports whether spec runs a local hook.
+func IsExec(spec string) bool {
+	scheme, _, _ := strings.Cut(strings.TrimSpace(spec), ":")
+	return strings.EqualFold(scheme, "exec")
+}
+
+// Record is one challenge record as passed to hooks and webhooks.
+type Record struct {
+	Action string `json:"action"`
+	Zone   string `json:"zone"`
+	Name   string `json:"name"`
+	FQDN   string `json:"fqdn"`
+	Type   string `json:"type"`
+	Value  string `json:"value"`
+	TTL    int    `json:"ttl"`
+}
+
+func newRecord(action, zone string, record libdns.Record) Record {
+	rr := record.RR()
+	ttl := rr.TTL
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	return Record{
+		Action: action,
+		Zone:   dns.Fqdn(zone),
+		Name:   rr.Name,
+		FQDN:   libdns.AbsoluteName(rr.Name, dns.Fqdn(zone)),
+		Type:   rr.Type,
+		Value:  rr.Data,
+		TTL:    int(ttl / time.Second),
+	}
+}
+
+// each calls fn for every record and returns the records that succeeded.
+func each(ctx context.Context, action, zone string, records []libdns.Record, fn func(context.Context, Record) error) ([]libdns.Record, error) {
+	done := make([]libdns.Record, 0, len(records))
+	for _, record := range records {
+		if err := fn(ctx, newRecord(action, zone, record)); err != nil {
+			return done, err
+		}
+		done = append(done, record)
+	}
+	return done, nil
+}
+
+// RFC2136 sends dynamic updates to the primary server of a zone.
+type RFC2136 struct {
+	Server    string
+	Net       string
+	KeyName   string
+	Secret    string
+	Algorithm string
+}
+
+func newRFC2136(spec string) (*RFC2136, error) {
+	u, err := url.Parse(spec)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidProvider, spec)
+	}
+	p := &RFC2136{Server: u.Host, Net: u.Query().Get("net"), Algorithm: dns.HmacSHA256}
+	if u.Port() == "" {
+		p.Server = net.JoinHostPort(u.Hostname(), "53")
+	}
+	switch p.Net {
+	case "", "udp", "tcp":
+	default:
+		return nil, fmt.Errorf("%w: net must be udp or tcp", ErrInvalidProvider)
+	}
+	if alg := u.Query().Get("alg"); alg != "" {
+		p.Algorithm = dns.Fqdn(strings.ToLower(alg))
+	}
+	switch p.Algorithm {
+	case dns.HmacSHA1, dns.HmacSHA224, dns.HmacSHA256, dns.HmacSHA384, dns.HmacSHA512:
+	default:
+		return nil, fmt.Errorf("%w: unsupported tsig algorithm %q", ErrInvalidProvider, u.Query().Get("alg"))
+	}
+	if u.User != nil {
+		p.KeyName = dns.Fqdn(strings.ToLower(u.User.Username()))
+		p.Secret, _ = u.User.Password()
+		if _, err := base64.StdEncoding.DecodeString(p.Secret); err != nil || p.Secret == "" {
+			return nil, fmt.Errorf("%w: tsig secret must be base64", ErrInvalidProvider)
+		}
+	}
+	return p, nil
+}
+
+// AppendRecords adds the TXT records to zone.
+func (p *RFC2136) AppendRecords(ctx context.Context, zone string, records []libdns.Record) ([]libdns.Record, error) {
+	return each(ctx, ActionPresent, zone, records, p.update)
+}
+
+// DeleteRecords removes the TXT records from zone.
+func (p *RFC2136) DeleteRecords(ctx context.Context, zone string, records []libdns.Record) ([]libdns.Record, error) {
+	return each(ctx, ActionCleanup, zone, records, p.update)
+}
+
+func (p *RFC2136) update(ctx context.Context, record Record) error {
+	if record.Type != "TXT" {
+		return fmt.Errorf("rfc2136: unsupported record type %q", record.Type)
+	}
+	rr := &dns.TXT{
+		Hdr: dns.RR_Header{Name: record.FQDN, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: uint32(record.TTL)},
+		Txt: []string{record.Value},
+	}
+	msg := new(dns.Msg)
+	msg.SetUpdate(record.Zone)
+	if record.Action == ActionPresent {
+		msg.Insert([]dns.RR{rr})
+	} else {
+		msg.Remove([]dns.RR{rr})
+	}
+	client := &dns.Client{Net: p.Net, Timeout: DefaultTimeout}
+	if p.KeyName != "" {
+		msg.SetTsig(p.KeyName, p.Algorithm, 300, time.Now().Unix())
+		client.TsigSecret = map[string]string{p.KeyName: p.Secret}
+	}
+	resp, _, err := client.ExchangeContext(ctx, msg, p.Server)
+	if err != nil {
+		return fmt.Errorf("rfc2136: update %s: %w", record.FQDN, err)
+	}
+	if resp.Rcode != dns.RcodeSuccess {
+		return fmt.Errorf("rfc2136: update %s: server answered %s", record.FQDN, dns.RcodeToString[resp.Rcode])
+	}
+	return nil
+}
+
+// Exec runs a local hook for each record.
+type Exec struct {
+	Command string
+}
+
+// AppendRecords runs the hook with the present action.
+func (p *Exec) AppendRecords(ctx context.Context, zone string, records []libdns.Record) ([]libdns.Record, error) {
+	return each(ctx, ActionPresent, zone, records, p.run)
+}
+
+// DeleteRecords runs the hook with the cleanup action.
+func (p *Exec) DeleteRecords(ctx context.Context, zone string, records []libdns.Record) ([]libdns.Record, error) {
+	return each(ctx, ActionCleanup, zone, records, p.run)
+}
+
+func (p *Exec) run(ctx context.Context, record Record) error {
+	ctx, cancel := context.WithTimeout(ctx, DefaultTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, common.ResolvePath(p.Command), record.Action, record.FQDN, record.Value)
+	cmd.Env = append(os.Environ(),
+		"NPS_ACME_ACTION="+record.Action,
+		"NPS_ACME_ZONE="+record.Zone,
+		"NPS_ACME_NAME="+record.Name,
+		"NPS_ACME_FQDN="+record.FQDN,
+		"NPS_ACME_TYPE="+record.Type,
+		"NPS_ACME_VALUE="+record.Value,
+		"NPS_ACME_TTL="+strconv.Itoa(record.TTL),
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("exec hook %s %s: %w: %s", record.Action, record.FQDN, err, truncate(out))
+	}
+	return nil
+}
+
+// Webhook posts each record as JSON to URL. Credentials in the URL are sent
+// as basic auth.
+type Webhook struct {
+	URL    string
+	Client *http.Client
+}
+
+// AppendRecords posts the records with the present action.
+func (p *Webhook) AppendRecords(ctx context.Context, zone string, records []libdns.Record) ([]libdns.Record, error) {
+	return each(ctx, ActionPresent, zone, records, p.post)
+}
+
+// DeleteRecords posts the records with the cleanup action.
+func (p *Webhook) DeleteRecords(ctx context.Context, zone string, records []libdns.Record) ([]libdns.Record, error) {
+	return each(ctx, ActionCleanup, zone, records, p.post)
+}
+
+func (p *Webhook) post(ctx context.Context, record Record) error {
+	body, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("webhook %s %s: %w", record.Action, record.FQDN, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := p.Client
+	if client == nil {
+		client = &http.Client{Timeout: DefaultTimeout}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook %s %s: %w", record.Action, record.FQDN, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	out, _ := io.ReadAll(io.LimitReader(resp.Body, maxOutputBytes))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook %s %s: status %d: %s", record.Action, record.FQDN, resp.StatusCode, truncate(out))
+	}
+	return nil
+}
+
+func truncate(out []byte) string {
+	out = bytes.TrimSpace(out)
+	if len(out) > maxOutputBytes {
+		out = out[:maxOutputBytes]
+	}
+	return string(out)
+}
@@ -0,0 +1,253 @@
+// Package authgate puts an OpenID Connect sign-in in front of domain hosts.
+//
+// A Gate holds the provider settings shared by every gated host. It builds
+// the authorization request (code flow with PKCE and a nonce), exchanges the
+// code on the callback, verifies the ID token against the provider keys and
+// signs the session cookies. Cookies are bound to the host they were issued
+// for.
+package authgate
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultSessionTTL is the session length without a configured one.
+const DefaultSessionTTL = 24 * time.Hour
+
+// loginTTL bounds the time between the redirect to the provider and the
+// callback.
+const loginTTL = 10 * time.Minute
+
+// ErrNotConfigured is returned when no provider is configured.
+var ErrNotConfigured = errors.New("auth gate provider is not configured")
+
+// Config are the provider settings of a Gate.
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	GroupsClaim  string
+	// CookieSecret signs the session cookies. Without one a random key is
+	// used and sessions end when the server restarts.
+	CookieSecret string
+	SessionTTL   time.Duration
+}
+
+// Enabled reports whether a provider is configured.
+func (c Config) Enabled() bool {
+	return c.Issuer != "" && c.ClientID != ""
+}
+
+func (c Config) equal(other Config) bool {
+	return c.Issuer == other.Issuer && c.ClientID == other.ClientID && c.ClientSecret == other.ClientSecret &&
+		strings.Join(c.Scopes, " ") == strings.Join(other.Scopes, " ") && c.GroupsClaim == other.GroupsClaim &&
+		c.CookieSecret == other.CookieSecret && c.SessionTTL == other.SessionTTL
+}
+
+// Identity is the signed-in user of a session.
+type Identity struct {
+	Subject string   `json:"sub"`
+	Email   string   `json:"email,omitempty"`
+	Name    string   `json:"name,omitempty"`
+	Groups  []string `json:"groups,omitempty"`
+	Host    string   `json:"host"`
+	Expires int64    `json:"exp"`
+}
+
+// Login is the state of a sign-in between the redirect to the provider and
+// the callback. It is kept in a signed cookie.
+type Login struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	ReturnTo string `json:"return_to"`
+	Host     string `json:"host"`
+	Expires  int64  `json:"exp"`
+}
+
+// Gate is safe for concurrent use.
+type Gate struct {
+	mu       sync.Mutex
+	cfg      Config
+	key      []byte
+	client   *http.Client
+	provider *provider
+}
+
+var defaultGate = NewGate(nil)
+
+// Default returns the process wide gate.
+func Default() *Gate {
+	return defaultGate
+}
+
+// NewGate returns an unconfigured gate that talks to the provider with
+// client, or a client with a 10 second timeout when nil.
+func NewGate(client *http.Client) *Gate {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Gate{client: client, key: randomKey()}
+}
+
+// Configure applies cfg. Provider metadata and keys are fetched again when
+// the issuer changes.
+func (g *Gate) Configure(cfg Config) {
+	cfg.Issuer = strings.TrimRight(strings.TrimSpace(cfg.Issuer), "/")
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "email", "profile"}
+	}
+	if cfg.GroupsClaim == "" {
+		cfg.GroupsClaim = "groups"
+	}
+	if cfg.SessionTTL <= 0 {
+		cfg.SessionTTL = DefaultSessionTTL
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.cfg.equal(cfg) {
+		return
+	}
+	if g.cfg.Issuer != cfg.Issuer || g.cfg.ClientSecret != cfg.ClientSecret {
+		g.provider = nil
+	}
+	if cfg.CookieSecret != g.cfg.CookieSecret {
+		if cfg.CookieSecret != "" {
+			sum := sha256.Sum256([]byte(cfg.CookieSecret))
+			g.key = sum[:]
+		} else {
+			g.key = randomKey()
+		}
+	}
+	g.cfg = cfg
+}
+
+// Config returns the applied settings.
+func (g *Gate) Config() Config {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.cfg
+}
+
+// NewLogin starts a sign-in for host that returns to returnTo afterwards.
+func (g *Gate) NewLogin(host, returnTo string, now time.Time) Login {
+	if !strings.HasPrefix(returnTo, "/") || strings.HasPrefix(returnTo, "//") {
+		returnTo = "/"
+	}
+	return Login{
+		State:    randomToken(),
+		Nonce:    randomToken(),
+		Verifier: randomToken() + randomToken(),
+		ReturnTo: returnTo,
+		Host:     host,
+		Expires:  now.Add(loginTTL).Unix(),
+	}
+}
+
+// EncodeLogin returns the cookie value of a sign-in.
+func (g *Gate) EncodeLogin(login Login) string {
+	return g.seal("login", login)
+}
+
+// DecodeLogin returns the sign-in of a cookie issued for host.
+func (g *Gate) DecodeLogin(value, host string, now time.Time) (*Login, bool) {
+	var login Login
+	if !g.open("login", value, &login) || login.Host != host || now.Unix() > login.Expires {
+		return nil, false
+	}
+	return &login, true
+}
+
+// EncodeSession returns the cookie value of a session.
+func (g *Gate) EncodeSession(identity Identity) string {
+	return g.seal("session", identity)
+}
+
+// DecodeSession returns the identity of a session cookie issued for host.
+func (g *Gate) DecodeSession(value, host string, now time.Time) (*Identity, bool) {
+	var identity Identity
+	if !g.open("session", value, &identity) || identity.Host != host || now.Unix() > identity.Expires {
+		return nil, false
+	}
+	return &identity, true
+}
+
+func (g *Gate) seal(purpose string, value any) string {
+	data, _ := json.Marshal(value)
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(g.sign(purpose, payload))
+}
+
+func (g *Gate) open(purpose, value string, out any) bool {
+	payload, signature, ok := strings.Cut(value, ".")
+	if !ok {
+		return false
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(sig, g.sign(purpose, payload)) {
+		return false
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	return err == nil && json.Unmarshal(data, out) == nil
+}
+
+func (g *Gate) sign(purpose, payload string) []byte {
+	g.mu.Lock()
+	key := g.key
+	g.mu.Unlock()
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write([]byte(purpose + ":" + payload))
+	return mac.Sum(nil)
+}
+
+// Allowed reports whether identity passes the allow lists of a host. Emails
+// are full addresses or domains written as "@example.com" or
+// "example.com"; groups are matched exactly. A user passes when either list
+// matches, and every signed-in user passes when both are empty.
+func Allowed(identity *Identity, emails, groups []string) bool {
+	if identity == nil {
+		return false
+	}
+	if len(emails) == 0 && len(groups) == 0 {
+		return true
+	}
+	if email := strings.ToLower(identity.Email); email != "" {
+		_, domain, _ := strings.Cut(email, "@")
+		for _, allowed := range emails {
+			allowed = strings.ToLower(allowed)
+			if allowed == email || strings.TrimPrefix(allowed, "@") == domain {
+				return true
+			}
+		}
+	}
+	for _, allowed := range groups {
+		for _, group := range identity.Groups {
+			if group == allowed {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func randomKey() []byte {
+	key := make([]byte, 32)
+	_, _ = rand.Read(key)
+	return key
+}
+
+func randomToken() string {
+	token := make([]byte, 24)
+	_, _ = rand.Read(token)
+	return base64.RawURLEncoding.EncodeToString(token)
+}
@@ -0,0 +1,271 @@
+package authgate
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stubIdP is a minimal OpenID provider. Codes map to the claims of the ID
+// token they are exchanged for.
+type stubIdP struct {
+	*httptest.Server
+	key    *rsa.PrivateKey
+	mu     sync.Mutex
+	codes  map[string]map[string]any
+	tokens int
+}
+
+func newStubIdP(t *testing.T) *stubIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	idp := &stubIdP{key: key, codes: make(map[string]map[string]any)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                 idp.URL,
+			"authorization_endpoint": idp.URL + "/authorize",
+			"token_endpoint":         idp.URL + "/token",
+			"jwks_uri":               idp.URL + "/keys",
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "k1",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		user, pass, _ := r.BasicAuth()
+		idp.mu.Lock()
+		claims, ok := idp.codes[r.PostFormValue("code")]
+		delete(idp.codes, r.PostFormValue("code"))
+		idp.tokens++
+		idp.mu.Unlock()
+		challenge := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+		if !ok || user != "nps" || pass != "secret" || claims["challenge"] != base64.RawURLEncoding.EncodeToString(challenge[:]) {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		delete(claims, "challenge")
+		_ = json.NewEncoder(w).Encode(map[string]string{"id_token": idp.sign(t, claims)})
+	})
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+func (idp *stubIdP) sign(t *testing.T, claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "k1"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, idp.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatalf("SignPKCS1v15() error = %v", err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// authorize plays the user signing in: it reads the authorization URL and
+// returns a code for claims, which get the nonce of the request.
+func (idp *stubIdP) authorize(t *testing.T, authURL string, claims map[string]any) string {
+	t.Helper()
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatalf("url.Parse(%q) error = %v", authURL, err)
+	}
+	query := parsed.Query()
+	if query.Get("client_id") != "nps" || query.Get("code_challenge_method") != "S256" {
+		t.Fatalf("authorization request = %v", query)
+	}
+	full := map[string]any{
+		"iss":       idp.URL,
+		"aud":       "nps",
+		"exp":       time.Now().Add(time.Hour).Unix(),
+		"nonce":     query.Get("nonce"),
+		"challenge": query.Get("code_challenge"),
+	}
+	for key, value := range claims {
+		full[key] = value
+	}
+	code := "code-" + query.Get("state")
+	idp.mu.Lock()
+	idp.codes[code] = full
+	idp.mu.Unlock()
+	return code
+}
+
+func newTestGate(idp *stubIdP) *Gate {
+	gate := NewGate(idp.Client())
+	gate.Configure(Config{Issuer: idp.URL + "/", ClientID: "nps", ClientSecret: "secret", CookieSecret: "cookie"})
+	return gate
+}
+
+func TestGateSignsInWithStubProvider(t *testing.T) {
+	idp := newStubIdP(t)
+	gate := newTestGate(idp)
+	now := time.Now()
+	login := gate.NewLogin("app.example.com", "/reports?q=1", now)
+	authURL, err := gate.AuthURL(context.Background(), login, "https://app.example.com/.nps/oauth2/callback")
+	if err != nil {
+		t.Fatalf("AuthURL() error = %v", err)
+	}
+	if !strings.HasPrefix(authURL, idp.URL+"/authorize?") || !strings.Contains(authURL, "state="+login.State) {
+		t.Fatalf("AuthURL() = %q", authURL)
+	}
+	code := idp.authorize(t, authURL, map[string]any{
+		"sub":            "u1",
+		"email":          "alice@example.com",
+		"email_verified": true,
+		"name":           "Alice",
+		"groups":         []string{"ops", "dev"},
+	})
+
+	decoded, ok := gate.DecodeLogin(gate.EncodeLogin(login), "app.example.com", now)
+	if !ok || decoded.ReturnTo != "/reports?q=1" {
+		t.Fatalf("DecodeLogin() = %+v, %v", decoded, ok)
+	}
+	identity, err := gate.Exchange(context.Background(), decoded, code, "https://app.example.com/.nps/oauth2/callback", now)
+	if err != nil {
+		t.Fatalf("Exchange() error = %v", err)
+	}
+	if identity.Subject != "u1" || identity.Email != "alice@example.com" || identity.Name != "Alice" || strings.Join(identity.Groups, ",") != "ops,dev" {
+		t.Fatalf("Exchange() identity = %+v", identity)
+	}
+
+	session := gate.EncodeSession(*identity)
+	if got, ok := gate.DecodeSession(session, "app.example.com", now); !ok || got.Email != identity.Email {
+		t.Fatalf("DecodeSession() = %+v, %v", got, ok)
+	}
+	if _, ok := gate.DecodeSession(session, "other.example.com", now); ok {
+		t.Fatal("DecodeSession() accepted a cookie of another host")
+	}
+	if _, ok := gate.DecodeSession(session, "app.example.com", now.Add(DefaultSessionTTL+time.Minute)); ok {
+		t.Fatal("DecodeSession() accepted an expired session")
+	}
+	if _, ok := gate.DecodeSession(session+"x", "app.example.com", now); ok {
+		t.Fatal("DecodeSession() accepted a tampered cookie")
+	}
+	if _, ok := NewGate(nil).DecodeSession(session, "app.example.com", now); ok {
+		t.Fatal("DecodeSession() accepted a cookie signed with another key")
+	}
+}
+
+func TestGateRejectsBadIDTokens(t *testing.T) {
+	idp := newStubIdP(t)
+	gate := newTestGate(idp)
+	now := time.Now()
+	redirect := "https://app.example.com/.nps/oauth2/callback"
+	for name, claims := range map[string]map[string]any{
+		"audience": {"sub": "u1", "aud": "other"},
+		"issuer":   {"sub": "u1", "iss": "https://evil.example.com"},
+		"expired":  {"sub": "u1", "exp": now.Add(-time.Hour).Unix()},
+		"nonce":    {"sub": "u1", "nonce": "replayed"},
+		"subject":  {"email": "alice@example.com"},
+	} {
+		login := gate.NewLogin("app.example.com", "/", now)
+		authURL, err := gate.AuthURL(context.Background(), login, redirect)
+		if err != nil {
+			t.Fatalf("AuthURL() error = %v", err)
+		}
+		code := idp.authorize(t, authURL, claims)
+		if _, err := gate.Exchange(context.Background(), &login, code, redirect, now); err == nil {
+			t.Fatalf("Exchange() with bad %s error = nil", name)
+		}
+	}
+
+	login := gate.NewLogin("app.example.com", "/", now)
+	authURL, _ := gate.AuthURL(context.Background(), login, redirect)
+	code := idp.authorize(t, authURL, map[string]any{"sub": "u1"})
+	login.Verifier = "wrong"
+	if _, err := gate.Exchange(context.Background(), &login, code, redirect, now); err == nil {
+		t.Fatal("Exchange() with a wrong PKCE verifier error = nil")
+	}
+}
+
+func TestGateUnverifiedEmailIsDropped(t *testing.T) {
+	idp := newStubIdP(t)
+	gate := newTestGate(idp)
+	now := time.Now()
+	redirect := "https://app.example.com/.nps/oauth2/callback"
+	for _, verified := range []any{false, "false", nil} {
+		login := gate.NewLogin("app.example.com", "//evil.example.com", now)
+		if login.ReturnTo != "/" {
+			t.Fatalf("NewLogin() ReturnTo = %q, want /", login.ReturnTo)
+		}
+		authURL, _ := gate.AuthURL(context.Background(), login, redirect)
+		claims := map[string]any{"sub": "u1", "email": "alice@example.com"}
+		if verified != nil {
+			claims["email_verified"] = verified
+		}
+		code := idp.authorize(t, authURL, claims)
+		identity, err := gate.Exchange(context.Background(), &login, code, redirect, now)
+		if err != nil {
+			t.Fatalf("Exchange() error = %v", err)
+		}
+		if identity.Email != "" {
+			t.Fatalf("Exchange() with email_verified %v kept email %q", verified, identity.Email)
+		}
+	}
+}
+
+func TestVerifySignatureRejectsUnknownAlgorithms(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	signed := []byte("header.payload")
+	digest := sha256.Sum256(signed)
+	signature, _ := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if !verifySignature("RS256", &key.PublicKey, signed, signature) {
+		t.Fatal("verifySignature(RS256) rejected a valid signature")
+	}
+	for _, alg := range []string{"", "R", "RS", "256", "XX256", "RS1024", "none"} {
+		if verifySignature(alg, &key.PublicKey, signed, signature) {
+			t.Fatalf("verifySignature(%q) = true", alg)
+		}
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	alice := &Identity{Email: "Alice@Example.com", Groups: []string{"ops"}}
+	for _, tc := range []struct {
+		emails, groups []string
+		want           bool
+	}{
+		{nil, nil, true},
+		{[]string{"alice@example.com"}, nil, true},
+		{[]string{"@example.com"}, nil, true},
+		{[]string{"example.com"}, nil, true},
+		{[]string{"ample.com", "bob@example.com"}, nil, false},
+		{[]string{"other.com"}, []string{"ops"}, true},
+		{nil, []string{"Ops"}, false},
+	} {
+		if got := Allowed(alice, tc.emails, tc.groups); got != tc.want {
+			t.Fatalf("Allowed(%v, %v) = %v, want %v", tc.emails, tc.groups, got, tc.want)
+		}
+	}
+	if Allowed(nil, nil, nil) {
+		t.Fatal("Allowed(nil) = true")
+	}
+}
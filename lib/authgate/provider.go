@@ -0,0 +1,410 @@
+package authgate
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// providerTTL is how long discovered metadata and keys are reused.
+const providerTTL = time.Hour
+
+// keyRefreshInterval bounds key fetches for tokens with an unknown key id.
+const keyRefreshInterval = time.Minute
+
+type provider struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	TokenAuthMethods      []string `json:"token_endpoint_auth_methods_supported"`
+	fetched               time.Time
+	keys                  map[string]crypto.PublicKey
+	keysFetched           time.Time
+}
+
+// AuthURL returns the provider URL that signs the user in and sends them
+// back to redirectURI.
+func (g *Gate) AuthURL(ctx context.Context, login Login, redirectURI string) (string, error) {
+	p, cfg, err := g.currentProvider(ctx)
+	if err != nil {
+		return "", err
+	}
+	challenge := sha256.Sum256([]byte(login.Verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {cfg.ClientID},
+		"redirect_uri":          {redirectURI},
+		"scope":                 {strings.Join(cfg.Scopes, " ")},
+		"state":                 {login.State},
+		"nonce":                 {login.Nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(p.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return p.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// Exchange redeems the code of a callback and returns the verified identity
+// of the ID token, bound to the host of login.
+func (g *Gate) Exchange(ctx context.Context, login *Login, code, redirectURI string, now time.Time) (*Identity, error) {
+	p, cfg, err := g.currentProvider(ctx)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"client_id":     {cfg.ClientID},
+		"code_verifier": {login.Verifier},
+	}
+	basic := cfg.ClientSecret != "" && (len(p.TokenAuthMethods) == 0 || slices.Contains(p.TokenAuthMethods, "client_secret_basic"))
+	if cfg.ClientSecret != "" && !basic {
+		form.Set("client_secret", cfg.ClientSecret)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if basic {
+		req.SetBasicAuth(url.QueryEscape(cfg.ClientID), url.QueryEscape(cfg.ClientSecret))
+	}
+	var token struct {
+		IDToken string `json:"id_token"`
+		Error   string `json:"error"`
+		Detail  string `json:"error_description"`
+	}
+	if err := g.fetchJSON(req, &token); err != nil {
+		return nil, fmt.Errorf("token request: %w", err)
+	}
+	if token.IDToken == "" {
+		return nil, fmt.Errorf("token response has no id_token: %s %s", token.Error, token.Detail)
+	}
+	claims, err := g.verifyIDToken(ctx, token.IDToken)
+	if err != nil {
+		return nil, err
+	}
+	return identityFromClaims(claims, cfg, p.Issuer, login, now)
+}
+
+func identityFromClaims(claims map[string]any, cfg Config, issuer string, login *Login, now time.Time) (*Identity, error) {
+	if iss, _ := claims["iss"].(string); strings.TrimRight(iss, "/") != strings.TrimRight(issuer, "/") {
+		return nil, fmt.Errorf("id token issuer %q does not match", iss)
+	}
+	if !audienceContains(claims["aud"], cfg.ClientID) {
+		return nil, errors.New("id token audience does not include the client id")
+	}
+	if exp, _ := claims["exp"].(float64); now.After(time.Unix(int64(exp), 0).Add(time.Minute)) {
+		return nil, errors.New("id token expired")
+	}
+	if nonce, _ := claims["nonce"].(string); nonce != login.Nonce {
+		return nil, errors.New("id token nonce does not match")
+	}
+	identity := &Identity{Host: login.Host, Expires: now.Add(cfg.SessionTTL).Unix()}
+	identity.Subject, _ = claims["sub"].(string)
+	if identity.Subject == "" {
+		return nil, errors.New("id token has no subject")
+	}
+	if emailVerified(claims["email_verified"]) {
+		identity.Email, _ = claims["email"].(string)
+	}
+	identity.Name, _ = claims["name"].(string)
+	if identity.Name == "" {
+		identity.Name, _ = claims["preferred_username"].(string)
+	}
+	switch groups := claims[cfg.GroupsClaim].(type) {
+	case string:
+		identity.Groups = []string{groups}
+	case []any:
+		for _, group := range groups {
+			if value, ok := group.(string); ok {
+				identity.Groups = append(identity.Groups, value)
+			}
+		}
+	}
+	return identity, nil
+}
+
+// emailVerified reports whether the provider vouched for the email. Some
+// providers send the claim as a string.
+func emailVerified(claim any) bool {
+	switch value := claim.(type) {
+	case bool:
+		return value
+	case string:
+		return strings.EqualFold(value, "true")
+	}
+	return false
+}
+
+func audienceContains(aud any, clientID string) bool {
+	switch value := aud.(type) {
+	case string:
+		return value == clientID
+	case []any:
+		for _, item := range value {
+			if item == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (g *Gate) currentProvider(ctx context.Context) (*provider, Config, error) {
+	g.mu.Lock()
+	cfg, p := g.cfg, g.provider
+	g.mu.Unlock()
+	if !cfg.Enabled() {
+		return nil, cfg, ErrNotConfigured
+	}
+	if p != nil && time.Since(p.fetched) < providerTTL {
+		return p, cfg, nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, cfg.Issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, cfg, err
+	}
+	fetched := &provider{}
+	if err := g.fetchJSON(req, fetched); err != nil {
+		return nil, cfg, fmt.Errorf("provider discovery: %w", err)
+	}
+	if strings.TrimRight(fetched.Issuer, "/") != cfg.Issuer {
+		return nil, cfg, fmt.Errorf("provider discovery returned issuer %q", fetched.Issuer)
+	}
+	if fetched.AuthorizationEndpoint == "" || fetched.TokenEndpoint == "" {
+		return nil, cfg, errors.New("provider discovery has no authorization or token endpoint")
+	}
+	fetched.fetched = time.Now()
+	g.mu.Lock()
+	if g.cfg.Issuer == cfg.Issuer {
+		g.provider = fetched
+	}
+	g.mu.Unlock()
+	return fetched, cfg, nil
+}
+
+func (g *Gate) fetchJSON(req *http.Request, out any) error {
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("status %d: %w", resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+func (g *Gate) verifyIDToken(ctx context.Context, raw string) (map[string]any, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("id token is not a JWS")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("id token header: %w", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("id token signature: %w", err)
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+	if strings.HasPrefix(header.Alg, "HS") {
+		cfg := g.Config()
+		if header.Alg != "HS256" || cfg.ClientSecret == "" {
+			return nil, fmt.Errorf("unsupported id token algorithm %q", header.Alg)
+		}
+		mac := hmac.New(sha256.New, []byte(cfg.ClientSecret))
+		_, _ = mac.Write(signed)
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return nil, errors.New("id token signature is invalid")
+		}
+	} else {
+		keys, err := g.signingKeys(ctx, header.Kid)
+		if err != nil {
+			return nil, err
+		}
+		verified := false
+		for _, key := range keys {
+			if verifySignature(header.Alg, key, signed, signature) {
+				verified = true
+				break
+			}
+		}
+		if !verified {
+			return nil, fmt.Errorf("id token signature is invalid (alg %q, kid %q)", header.Alg, header.Kid)
+		}
+	}
+	claims := make(map[string]any)
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("id token claims: %w", err)
+	}
+	return claims, nil
+}
+
+// signingKeys returns the key with kid, or every key when kid is empty.
+// Unknown ids refetch the key set, at most once per keyRefreshInterval.
+func (g *Gate) signingKeys(ctx context.Context, kid string) ([]crypto.PublicKey, error) {
+	p, _, err := g.currentProvider(ctx)
+	if err != nil {
+		return nil, err
+	}
+	g.mu.Lock()
+	keys, fetched := p.keys, p.keysFetched
+	g.mu.Unlock()
+	if _, ok := keys[kid]; (kid != "" && !ok || keys == nil) && time.Since(fetched) > keyRefreshInterval {
+		if keys, err = g.fetchKeys(ctx, p.JWKSURI); err != nil {
+			return nil, err
+		}
+		g.mu.Lock()
+		p.keys, p.keysFetched = keys, time.Now()
+		g.mu.Unlock()
+	}
+	if kid != "" {
+		if key, ok := keys[kid]; ok {
+			return []crypto.PublicKey{key}, nil
+		}
+		return nil, fmt.Errorf("unknown id token key %q", kid)
+	}
+	all := make([]crypto.PublicKey, 0, len(keys))
+	for _, key := range keys {
+		all = append(all, key)
+	}
+	return all, nil
+}
+
+func (g *Gate) fetchKeys(ctx context.Context, uri string) (map[string]crypto.PublicKey, error) {
+	if uri == "" {
+		return nil, errors.New("provider has no jwks_uri")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := g.fetchJSON(req, &set); err != nil {
+		return nil, fmt.Errorf("provider keys: %w", err)
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for index, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		kid := jwk.Kid
+		if kid == "" {
+			kid = fmt.Sprintf("#%d", index)
+		}
+		switch jwk.Kty {
+		case "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(jwk.N)
+			e, errE := base64.RawURLEncoding.DecodeString(jwk.E)
+			if errN != nil || errE != nil || len(e) == 0 || len(e) > 4 {
+				continue
+			}
+			keys[kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case "EC":
+			var curve elliptic.Curve
+			switch jwk.Crv {
+			case "P-256":
+				curve = elliptic.P256()
+			case "P-384":
+				curve = elliptic.P384()
+			default:
+				continue
+			}
+			x, errX := base64.RawURLEncoding.DecodeString(jwk.X)
+			y, errY := base64.RawURLEncoding.DecodeString(jwk.Y)
+			if errX != nil || errY != nil {
+				continue
+			}
+			keys[kid] = &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		}
+	}
+	return keys, nil
+}
+
+// signatureHashes are the asymmetric id token algorithms accepted.
+var signatureHashes = map[string]crypto.Hash{
+	"RS256": crypto.SHA256, "RS384": crypto.SHA384, "RS512": crypto.SHA512,
+	"PS256": crypto.SHA256, "PS384": crypto.SHA384, "PS512": crypto.SHA512,
+	"ES256": crypto.SHA256, "ES384": crypto.SHA384, "ES512": crypto.SHA512,
+}
+
+func verifySignature(alg string, key crypto.PublicKey, signed, signature []byte) bool {
+	hash, ok := signatureHashes[alg]
+	if !ok {
+		return false
+	}
+	h := hash.New()
+	_, _ = h.Write(signed)
+	digest := h.Sum(nil)
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		switch alg[:2] {
+		case "RS":
+			return rsa.VerifyPKCS1v15(pub, hash, digest, signature) == nil
+		case "PS":
+			return rsa.VerifyPSS(pub, hash, digest, signature, nil) == nil
+		}
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if alg[:2] != "ES" || len(signature) != 2*size {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		return ecdsa.Verify(pub, digest, r, s)
+	}
+	return false
+}
+
+func decodeSegment(segment string, out any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
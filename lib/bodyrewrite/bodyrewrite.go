@@ -0,0 +1,322 @@
+// Package bodyrewrite replaces text in streamed HTTP bodies of domain hosts.
+//
+// Rules are written one per line as a pattern and its replacement:
+//
+//	http://localhost:8080 => ${scheme}://${http_host}
+//	~localhost:\d+ => ${http_host}
+//	~*(src|href)="/app/ => $1="/
+//
+// A pattern is literal unless it starts with ~ (regular expression) or ~*
+// (case-insensitive regular expression). Regular expressions match within
+// one line and may refer to groups as $1 in the replacement. Placeholders
+// such as ${host} are expanded in replacements and literal patterns. Empty
+// lines and lines starting with # are ignored.
+package bodyrewrite
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"regexp"
+	"strings"
+
+	"github.com/djylb/nps/lib/logs"
+)
+
+// MaxLineBytes bounds the line a regular expression rule waits for. Longer
+// lines are rewritten in pieces of this size.
+const MaxLineBytes = 64 << 10
+
+const separator = "=>"
+
+// Rule is one compiled line of a RuleSet.
+type Rule struct {
+	Line        int
+	pattern     string
+	re          *regexp.Regexp
+	replacement string
+}
+
+// RuleSet is an immutable list of rules.
+type RuleSet struct {
+	source string
+	rules  []*Rule
+}
+
+// Parse compiles rule text. Errors name the line that failed.
+func Parse(raw string) (*RuleSet, error) {
+	set := &RuleSet{source: raw}
+	for index, line := range strings.Split(strings.ReplaceAll(raw, "\r\n", "\n"), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule, err := parseRule(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", index+1, err)
+		}
+		rule.Line = index + 1
+		set.rules = append(set.rules, rule)
+	}
+	return set, nil
+}
+
+// Compile parses rule text like Parse but skips lines that fail, logging
+// them, so a bad rule saved before validation cannot disable the others.
+func Compile(raw string) *RuleSet {
+	set := &RuleSet{source: raw}
+	for index, line := range strings.Split(strings.ReplaceAll(raw, "\r\n", "\n"), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule, err := parseRule(line)
+		if err != nil {
+			logs.Warn("ignore invalid body rewrite rule on line %d: %v", index+1, err)
+			continue
+		}
+		rule.Line = index + 1
+		set.rules = append(set.rules, rule)
+	}
+	return set
+}
+
+// Normalize returns rule text with blank lines and surrounding spaces
+// removed, or an error when a rule does not compile.
+func Normalize(raw string) (string, error) {
+	if _, err := Parse(raw); err != nil {
+		return "", err
+	}
+	lines := strings.Split(strings.ReplaceAll(raw, "\r\n", "\n"), "\n")
+	normalized := make([]string, 0, len(lines))
+	for _, line := range lines {
+		if line = strings.TrimSpace(line); line != "" {
+			normalized = append(normalized, line)
+		}
+	}
+	return strings.Join(normalized, "\n"), nil
+}
+
+// Source returns the text the set was parsed from.
+func (s *RuleSet) Source() string {
+	if s == nil {
+		return ""
+	}
+	return s.source
+}
+
+// Len returns the number of rules.
+func (s *RuleSet) Len() int {
+	if s == nil {
+		return 0
+	}
+	return len(s.rules)
+}
+
+func parseRule(line string) (*Rule, error) {
+	pattern, replacement, ok := strings.Cut(line, " "+separator+" ")
+	if !ok {
+		if pattern, ok = strings.CutSuffix(line, " "+separator); !ok {
+			return nil, fmt.Errorf("expected \"pattern %s replacement\"", separator)
+		}
+	}
+	rule := &Rule{pattern: strings.TrimSpace(pattern), replacement: strings.TrimSpace(replacement)}
+	expr, isRegexp := strings.CutPrefix(rule.pattern, "~")
+	if caseless, ok := strings.CutPrefix(expr, "*"); isRegexp && ok {
+		expr = "(?i)" + caseless
+	}
+	switch {
+	case rule.pattern == "":
+		return nil, errors.New("empty pattern")
+	case !isRegexp:
+		return rule, nil
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	if re.MatchString("") {
+		return nil, fmt.Errorf("pattern %q matches empty text", rule.pattern)
+	}
+	rule.re = re
+	return rule, nil
+}
+
+// NewWriter returns a writer that rewrites what is written to it and passes
+// it on to dst. vars expands placeholders and may be nil. Close must be
+// called to write the text held back for matches that span writes; it does
+// not close dst.
+func (s *RuleSet) NewWriter(dst io.Writer, vars *strings.Replacer) *Writer {
+	w := &Writer{dst: dst}
+	if s == nil {
+		return w
+	}
+	for _, rule := range s.rules {
+		st := &stage{re: rule.re, replacement: []byte(expand(vars, rule.replacement))}
+		if rule.re == nil {
+			st.literal = []byte(expand(vars, rule.pattern))
+		}
+		w.stages = append(w.stages, st)
+	}
+	return w
+}
+
+// Rewrite applies the rules to a whole body.
+func (s *RuleSet) Rewrite(body []byte, vars *strings.Replacer) []byte {
+	var out bytes.Buffer
+	w := s.NewWriter(&out, vars)
+	_, _ = w.Write(body)
+	_ = w.Close()
+	return out.Bytes()
+}
+
+func expand(vars *strings.Replacer, value string) string {
+	if vars == nil || !strings.Contains(value, "${") {
+		return value
+	}
+	return vars.Replace(value)
+}
+
+// Writer rewrites a stream. Text is only held back while it may still be
+// the start of a literal match, or, for regular expressions, until the end
+// of its line.
+type Writer struct {
+	dst    io.Writer
+	stages []*stage
+	closed bool
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, io.ErrClosedPipe
+	}
+	out := p
+	for _, st := range w.stages {
+		if out = st.push(out, false); len(out) == 0 {
+			return len(p), nil
+		}
+	}
+	if _, err := w.dst.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close writes the text held back by the rules.
+func (w *Writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	var out []byte
+	for _, st := range w.stages {
+		out = st.push(out, true)
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	_, err := w.dst.Write(out)
+	return err
+}
+
+type stage struct {
+	literal     []byte
+	re          *regexp.Regexp
+	replacement []byte
+	pending     []byte
+}
+
+func (st *stage) push(data []byte, final bool) []byte {
+	buf := data
+	if len(st.pending) > 0 {
+		buf = append(st.pending, data...)
+		st.pending = nil
+	}
+	if st.re != nil {
+		return st.pushRegexp(buf, final)
+	}
+	if len(st.literal) == 0 {
+		return buf
+	}
+	var out []byte
+	for {
+		index := bytes.Index(buf, st.literal)
+		if index < 0 {
+			break
+		}
+		out = append(out, buf[:index]...)
+		out = append(out, st.replacement...)
+		buf = buf[index+len(st.literal):]
+	}
+	if !final {
+		if keep := partialSuffix(buf, st.literal); keep > 0 {
+			st.pending = append([]byte(nil), buf[len(buf)-keep:]...)
+			buf = buf[:len(buf)-keep]
+		}
+	}
+	if out == nil {
+		return buf
+	}
+	return append(out, buf...)
+}
+
+// pushRegexp rewrites the complete lines of buf and holds the last one
+// back until it ends.
+func (st *stage) pushRegexp(buf []byte, final bool) []byte {
+	if !final {
+		cut := bytes.LastIndexByte(buf, '\n') + 1
+		if cut == 0 && len(buf) < MaxLineBytes {
+			st.pending = append([]byte(nil), buf...)
+			return nil
+		}
+		if cut == 0 {
+			cut = len(buf)
+		}
+		st.pending = append([]byte(nil), buf[cut:]...)
+		buf = buf[:cut]
+	}
+	var out []byte
+	for len(buf) > 0 {
+		line, rest, found := bytes.Cut(buf, []byte{'\n'})
+		out = append(out, st.re.ReplaceAll(line, st.replacement)...)
+		if found {
+			out = append(out, '\n')
+		}
+		buf = rest
+	}
+	return out
+}
+
+// partialSuffix returns the length of the longest end of buf that is the
+// start of literal.
+func partialSuffix(buf, literal []byte) int {
+	for keep := min(len(buf), len(literal)-1); keep > 0; keep-- {
+		if bytes.HasPrefix(literal, buf[len(buf)-keep:]) {
+			return keep
+		}
+	}
+	return 0
+}
+
+// TextContentType reports whether bodies of a Content-Type are rewritten:
+// text/*, JavaScript, JSON, XML, SVG and form data.
+func TextContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch {
+	case strings.HasPrefix(mediaType, "text/"),
+		strings.HasSuffix(mediaType, "+json"),
+		strings.HasSuffix(mediaType, "+xml"):
+		return true
+	}
+	switch mediaType {
+	case "application/javascript", "application/x-javascript", "application/ecmascript",
+		"application/json", "application/xml", "application/x-www-form-urlencoded":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+package bodyrewrite
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRewriteAcrossWrites(t *testing.T) {
+	set, err := Parse(`
+# backend links
+http://localhost:8080 => ${scheme}://${http_host}
+~*(src|href)="/app/ => $1="/
+~^debug=\w+$ =>
+`)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	vars := strings.NewReplacer("${scheme}", "https", "${http_host}", "app.example.com")
+	body := "<a HREF=\"/app/x\">http://localhost:8080/a</a>\ndebug=on\n<script src=\"/app/js\">fetch('http://localhost:8080')</script>"
+	want := "<a HREF=\"/x\">https://app.example.com/a</a>\n\n<script src=\"/js\">fetch('https://app.example.com')</script>"
+	if got := string(set.Rewrite([]byte(body), vars)); got != want {
+		t.Fatalf("Rewrite() = %q, want %q", got, want)
+	}
+
+	// The result does not depend on how the body is split.
+	for size := 1; size <= 7; size++ {
+		var out bytes.Buffer
+		w := set.NewWriter(&out, vars)
+		for rest := []byte(body); len(rest) > 0; {
+			n := min(size, len(rest))
+			if _, err := w.Write(rest[:n]); err != nil {
+				t.Fatalf("Write() error = %v", err)
+			}
+			rest = rest[n:]
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close() error = %v", err)
+		}
+		if out.String() != want {
+			t.Fatalf("writes of %d bytes = %q, want %q", size, out.String(), want)
+		}
+	}
+}
+
+func TestWriterHoldsBackOnlyPossibleMatches(t *testing.T) {
+	set, err := Parse("http://localhost:8080 => /")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	var out bytes.Buffer
+	w := set.NewWriter(&out, nil)
+	_, _ = w.Write([]byte("data: one\n\n"))
+	if out.String() != "data: one\n\n" {
+		t.Fatalf("streamed event = %q, want it written at once", out.String())
+	}
+	_, _ = w.Write([]byte("data: http://local"))
+	if out.String() != "data: one\n\ndata: " {
+		t.Fatalf("partial match = %q", out.String())
+	}
+	_, _ = w.Write([]byte("host:8080x"))
+	_ = w.Close()
+	if out.String() != "data: one\n\ndata: /x" {
+		t.Fatalf("completed match = %q", out.String())
+	}
+}
+
+func TestParseAndNormalize(t *testing.T) {
+	for _, raw := range []string{"no separator", "~( => x", "~a* => x", " => x", "~ => x"} {
+		if _, err := Parse(raw); err == nil {
+			t.Fatalf("Parse(%q) error = nil", raw)
+		}
+	}
+	if _, err := Parse("ok => x\nbad"); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("Parse() error = %v, want line 2", err)
+	}
+	if got := Compile("bad\na => b").Len(); got != 1 {
+		t.Fatalf("Compile() kept %d rules, want 1", got)
+	}
+	if got, err := Normalize("\n  a => b  \n\n~c => d\n"); err != nil || got != "a => b\n~c => d" {
+		t.Fatalf("Normalize() = %q, %v", got, err)
+	}
+	for contentType, want := range map[string]bool{
+		"text/html; charset=utf-8":          true,
+		"application/javascript":            true,
+		"application/vnd.api+json":          true,
+		"application/x-www-form-urlencoded": true,
+		"image/png":                         false,
+		"application/octet-stream":          false,
+		"":                                  false,
+	} {
+		if got := TextContentType(contentType); got != want {
+			t.Fatalf("TextContentType(%q) = %v, want %v", contentType, got, want)
+		}
+	}
+}
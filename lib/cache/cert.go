@@ -71,6 +71,15 @@ func (m *CertManager) Stop() {
 	})
 }
 
+// Forget drops the certificate cached under hash, so the next Get loads it
+// again.
+func (m *CertManager) Forget(hash string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cache.Remove(hash)
+	delete(m.loadMutexes, hash)
+}
+
 func parseExpire(cert *tls.Certificate) (time.Time, error) {
 	if len(cert.Certificate) == 0 {
 		return time.Time{}, errors.New("no x509 data")
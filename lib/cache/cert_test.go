@@ -168,3 +168,23 @@ func generateTestPEM(t *testing.T) (certPEM string, keyPEM string) {
 	keyBytes := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(priv)})
 	return string(certBytes), string(keyBytes)
 }
+
+func TestCertManagerForgetReloads(t *testing.T) {
+	certPEM, keyPEM := generateTestPEM(t)
+
+	m := NewCertManager(10, 0, 0)
+	defer m.Stop()
+
+	first, err := m.Get(certPEM, keyPEM, "text", "hash")
+	if err != nil {
+		t.Fatalf("first get failed: %v", err)
+	}
+	m.Forget("hash")
+	if _, err := m.Get("broken cert", "broken key", "text", "hash"); err == nil {
+		t.Fatal("expected forgotten entry to be loaded again")
+	}
+	second, err := m.Get(certPEM, keyPEM, "text", "hash")
+	if err != nil || second == first {
+		t.Fatalf("expected a fresh certificate, got %p (%v)", second, err)
+	}
+}
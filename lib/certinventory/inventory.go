@@ -0,0 +1,235 @@
+// Package certinventory describes the certificates served by domain hosts
+// and remembers how their renewals went. The HTTPS entry registers itself as
+// the Runtime that can look up, renew and reload certificates; without it
+// the inventory still describes manual certificates from their files.
+package certinventory
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sources of a certificate.
+const (
+	SourceManual  = "manual"
+	SourceACME    = "acme"
+	SourceDefault = "default"
+)
+
+// ErrUnavailable is returned when no HTTPS entry is running.
+var ErrUnavailable = errors.New("https entry is not running")
+
+// Info describes a leaf certificate.
+type Info struct {
+	Fingerprint string   `json:"fingerprint"`
+	Subject     string   `json:"subject"`
+	Issuer      string   `json:"issuer"`
+	DNSNames    []string `json:"dns_names"`
+	Serial      string   `json:"serial"`
+	NotBefore   int64    `json:"not_before"`
+	NotAfter    int64    `json:"not_after"`
+}
+
+// Describe returns the inventory fields of cert.
+func Describe(cert *x509.Certificate) Info {
+	sum := sha256.Sum256(cert.Raw)
+	names := slices.Clone(cert.DNSNames)
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	if len(names) == 0 && cert.Subject.CommonName != "" {
+		names = []string{cert.Subject.CommonName}
+	}
+	return Info{
+		Fingerprint: hex.EncodeToString(sum[:]),
+		Subject:     cert.Subject.String(),
+		Issuer:      cert.Issuer.String(),
+		DNSNames:    names,
+		Serial:      strings.ToUpper(new(big.Int).Set(cert.SerialNumber).Text(16)),
+		NotBefore:   cert.NotBefore.Unix(),
+		NotAfter:    cert.NotAfter.Unix(),
+	}
+}
+
+// ParsePEM returns the first certificate of a PEM bundle.
+func ParsePEM(content []byte) (*x509.Certificate, error) {
+	for {
+		var block *pem.Block
+		block, content = pem.Decode(content)
+		if block == nil {
+			return nil, errors.New("no certificate in PEM data")
+		}
+		if block.Type == "CERTIFICATE" {
+			return x509.ParseCertificate(block.Bytes)
+		}
+	}
+}
+
+// DaysLeft returns the whole days from now until notAfter, negative once it
+// has passed.
+func DaysLeft(notAfter int64, now time.Time) int {
+	left := notAfter - now.Unix()
+	days := left / int64(24*time.Hour/time.Second)
+	if left < 0 && left%int64(24*time.Hour/time.Second) != 0 {
+		days--
+	}
+	return int(days)
+}
+
+// Runtime is the HTTPS entry that serves the certificates.
+type Runtime interface {
+	// ACMECertificate returns the issued certificate that serves name.
+	ACMECertificate(name string) (*x509.Certificate, bool)
+	// RenewACME renews the certificate of name now, even if it is not due.
+	RenewACME(ctx context.Context, name string) error
+	// ReloadManual drops the cached manual certificate with hash, so the
+	// next handshake reads its files again.
+	ReloadManual(hash string)
+}
+
+// Attempt is the outcome of the last renewal of a certificate. Requested
+// attempts were asked for through the inventory, the others were run by the
+// certificate maintenance in the background.
+type Attempt struct {
+	At        int64  `json:"at"`
+	Error     string `json:"error,omitempty"`
+	Requested bool   `json:"requested,omitempty"`
+}
+
+// Inventory holds the runtime and the renewal attempts.
+type Inventory struct {
+	mu       sync.RWMutex
+	runtime  Runtime
+	attempts map[string]Attempt
+}
+
+var defaultInventory = New()
+
+// Default returns the process wide inventory.
+func Default() *Inventory {
+	return defaultInventory
+}
+
+// New returns an empty inventory.
+func New() *Inventory {
+	return &Inventory{attempts: make(map[string]Attempt)}
+}
+
+// SetRuntime registers the HTTPS entry. Passing nil unregisters current only
+// when it is still the registered one.
+func (i *Inventory) SetRuntime(runtime, current Runtime) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if runtime == nil && i.runtime != current {
+		return
+	}
+	i.runtime = runtime
+}
+
+func (i *Inventory) currentRuntime() Runtime {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return i.runtime
+}
+
+// ACMECertificate returns the issued certificate that serves name.
+func (i *Inventory) ACMECertificate(name string) (*x509.Certificate, bool) {
+	if runtime := i.currentRuntime(); runtime != nil {
+		return runtime.ACMECertificate(name)
+	}
+	return nil, false
+}
+
+// ACMEKey and ManualKey name the renewal attempts of a certificate.
+func ACMEKey(name string) string { return SourceACME + ":" + strings.ToLower(name) }
+
+func ManualKey(hash string) string { return SourceManual + ":" + hash }
+
+// RenewACME renews the certificate of name and records the attempt.
+func (i *Inventory) RenewACME(ctx context.Context, name string) error {
+	runtime := i.currentRuntime()
+	if runtime == nil {
+		return ErrUnavailable
+	}
+	err := runtime.RenewACME(ctx, name)
+	i.record(ACMEKey(name), time.Now(), err, true)
+	return err
+}
+
+// ReloadManual makes the HTTPS entry read a manual certificate again and
+// records the attempt. loadErr is the result of reading the files.
+func (i *Inventory) ReloadManual(hash string, loadErr error) error {
+	runtime := i.currentRuntime()
+	if runtime == nil {
+		return ErrUnavailable
+	}
+	if loadErr == nil {
+		runtime.ReloadManual(hash)
+	}
+	i.record(ManualKey(hash), time.Now(), loadErr, true)
+	return loadErr
+}
+
+// Record stores the outcome of a background renewal.
+func (i *Inventory) Record(key string, at time.Time, err error) {
+	i.record(key, at, err, false)
+}
+
+func (i *Inventory) record(key string, at time.Time, err error, requested bool) {
+	attempt := Attempt{At: at.Unix(), Requested: requested}
+	if err != nil {
+		attempt.Error = err.Error()
+	}
+	i.mu.Lock()
+	i.attempts[key] = attempt
+	i.mu.Unlock()
+}
+
+// LastAttempt returns the last renewal recorded under key.
+func (i *Inventory) LastAttempt(key string) (Attempt, bool) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	attempt, ok := i.attempts[key]
+	return attempt, ok
+}
+
+// Failures returns the failed attempts by key.
+func (i *Inventory) Failures() map[string]Attempt {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	failures := make(map[string]Attempt)
+	for key, attempt := range i.attempts {
+		if attempt.Error != "" {
+			failures[key] = attempt
+		}
+	}
+	return failures
+}
+
+// RecordEvent records the certmagic events that end an issuance.
+func (i *Inventory) RecordEvent(event string, data map[string]any) {
+	name, _ := data["identifier"].(string)
+	if name == "" {
+		return
+	}
+	switch event {
+	case "cert_obtained":
+		i.Record(ACMEKey(name), time.Now(), nil)
+	case "cert_failed":
+		err, _ := data["error"].(error)
+		if err == nil {
+			err = fmt.Errorf("%v", data["error"])
+		}
+		i.Record(ACMEKey(name), time.Now(), err)
+	}
+}
@@ -0,0 +1,109 @@
+package certinventory
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type runtimeStub struct {
+	renewed  []string
+	reloaded []string
+}
+
+func (s *runtimeStub) ACMECertificate(string) (*x509.Certificate, bool) { return nil, false }
+
+func (s *runtimeStub) RenewACME(_ context.Context, name string) error {
+	s.renewed = append(s.renewed, name)
+	return nil
+}
+
+func (s *runtimeStub) ReloadManual(hash string) { s.reloaded = append(s.reloaded, hash) }
+
+func TestDescribeAndParsePEM(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	notAfter := time.Unix(1800000000, 0)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(0xBEEF),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		DNSNames:     []string{"example.com", "*.example.com"},
+		IPAddresses:  []net.IP{net.ParseIP("192.0.2.1")},
+		NotBefore:    notAfter.Add(-90 * 24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	bundle := append(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: []byte("x")}), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	cert, err := ParsePEM(bundle)
+	if err != nil {
+		t.Fatalf("ParsePEM() error = %v", err)
+	}
+	info := Describe(cert)
+	if info.Serial != "BEEF" || info.Subject != "CN=example.com" || info.NotAfter != notAfter.Unix() || len(info.Fingerprint) != 64 ||
+		!reflect.DeepEqual(info.DNSNames, []string{"example.com", "*.example.com", "192.0.2.1"}) {
+		t.Fatalf("Describe() = %+v", info)
+	}
+	if _, err := ParsePEM([]byte("not pem")); err == nil {
+		t.Fatal("ParsePEM() accepted data without a certificate")
+	}
+
+	for left, want := range map[time.Duration]int{36 * time.Hour: 1, time.Hour: 0, 0: 0, -time.Hour: -1, -48 * time.Hour: -2} {
+		if got := DaysLeft(notAfter.Unix(), notAfter.Add(-left)); got != want {
+			t.Fatalf("DaysLeft(%v) = %d, want %d", left, got, want)
+		}
+	}
+}
+
+func TestInventoryRuntimeAndAttempts(t *testing.T) {
+	inventory := New()
+	if err := inventory.RenewACME(context.Background(), "example.com"); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("RenewACME() without runtime error = %v", err)
+	}
+
+	runtime := &runtimeStub{}
+	inventory.SetRuntime(runtime, nil)
+	inventory.SetRuntime(nil, &runtimeStub{})
+	if err := inventory.RenewACME(context.Background(), "Example.com"); err != nil {
+		t.Fatalf("RenewACME() error = %v", err)
+	}
+	if err := inventory.ReloadManual("hash", errors.New("key file not found")); err == nil {
+		t.Fatal("ReloadManual() dropped the load error")
+	}
+	if err := inventory.ReloadManual("hash", nil); err != nil || !reflect.DeepEqual(runtime.reloaded, []string{"hash"}) {
+		t.Fatalf("ReloadManual() = %v, reloaded %v", err, runtime.reloaded)
+	}
+	if attempt, ok := inventory.LastAttempt(ACMEKey("example.com")); !ok || attempt.Error != "" || !attempt.Requested {
+		t.Fatalf("ACME attempt = %+v, %v", attempt, ok)
+	}
+
+	inventory.RecordEvent("cert_failed", map[string]any{"identifier": "*.example.com", "error": errors.New("dns timeout")})
+	inventory.RecordEvent("cert_obtaining", map[string]any{"identifier": "other.example.com"})
+	failures := inventory.Failures()
+	if len(failures) != 1 || failures[ACMEKey("*.example.com")].Error != "dns timeout" || failures[ACMEKey("*.example.com")].Requested {
+		t.Fatalf("Failures() = %+v", failures)
+	}
+	inventory.RecordEvent("cert_obtained", map[string]any{"identifier": "*.example.com"})
+	if failures := inventory.Failures(); len(failures) != 0 {
+		t.Fatalf("Failures() after success = %+v", failures)
+	}
+
+	inventory.SetRuntime(nil, runtime)
+	if _, ok := inventory.ACMECertificate("example.com"); ok {
+		t.Fatal("ACMECertificate() answered without a runtime")
+	}
+}
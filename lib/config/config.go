@@ -318,6 +318,10 @@ func dealHost(s string) (*file.Host, error) {
 			h.Target.TargetStr = strings.ReplaceAll(value, ",", "\n")
 		case "proxy_protocol":
 			h.Target.ProxyProtocol = common.GetIntNoErrByStr(value)
+		case "balance":
+			h.Target.Balance = value
+		case "balance_key":
+			h.Target.BalanceKey = value
 		case "host_change":
 			h.HostChange = value
 		case "scheme":
@@ -338,6 +342,57 @@ func dealHost(s string) (*file.Host, error) {
 			h.AutoHttps = common.GetBoolByStr(value)
 		case "auto_cors":
 			h.AutoCORS = common.GetBoolByStr(value)
+		case "resp_compress":
+			h.RespCompress = common.GetBoolByStr(value)
+		case "resp_compress_types":
+			h.RespCompressTypes = value
+		case "resp_compress_min":
+			h.RespCompressMin = common.GetIntNoErrByStr(value)
+		case "cache":
+			h.Cache = common.GetBoolByStr(value)
+		case "cache_ttl":
+			h.CacheTTL = common.GetIntNoErrByStr(value)
+		case "req_limit":
+			h.ReqLimit = common.GetIntNoErrByStr(value)
+		case "req_limit_window":
+			h.ReqLimitWindow = common.GetIntNoErrByStr(value)
+		case "req_limit_key":
+			h.ReqLimitKey = value
+		case "req_limit_paths":
+			h.ReqLimitPaths = value
+		case "auth_gate":
+			h.AuthGate = common.GetBoolByStr(value)
+		case "auth_gate_emails":
+			h.AuthGateEmails = value
+		case "auth_gate_groups":
+			h.AuthGateGroups = value
+		case "forward_auth":
+			h.ForwardAuth = value
+		case "forward_auth_headers":
+			h.ForwardAuthHeaders = value
+		case "client_cert_ca":
+			h.ClientCertCA = value
+		case "client_cert_mode":
+			h.ClientCertMode = value
+		case "client_cert_subjects":
+			h.ClientCertSubjects = value
+		case "auto_ssl_dns":
+			h.AutoSSLDNS = value
+		case "custom_domain":
+			h.CustomDomain = common.GetBoolByStr(value)
+		case "tls_min_version":
+			h.TLSMinVersion = value
+		case "tls_cipher_profile":
+			h.TLSCipherProfile = value
+		case "tls_alpn":
+			h.TLSALPN = value
+		case "hsts":
+			h.HSTS = value
+		case "req_body_rewrite":
+			// Rules are one per line, so the key may be repeated.
+			h.ReqBodyRewrite = appendConfigLine(h.ReqBodyRewrite, value)
+		case "resp_body_rewrite":
+			h.RespBodyRewrite = appendConfigLine(h.RespBodyRewrite, value)
 		case "compat_mode":
 			h.CompatMode = common.GetBoolByStr(value)
 		case "redirect_url":
@@ -411,6 +466,10 @@ func dealTunnel(s string) (*file.Tunnel, error) {
 			t.Target.TargetStr = strings.ReplaceAll(value, ",", "\n")
 		case "proxy_protocol":
 			t.Target.ProxyProtocol = common.GetIntNoErrByStr(value)
+		case "balance":
+			t.Target.Balance = value
+		case "balance_key":
+			t.Target.BalanceKey = value
 		case "target_port":
 			t.Target.TargetStr = value
 		case "target_ip":
@@ -429,6 +488,8 @@ func dealTunnel(s string) (*file.Tunnel, error) {
 			t.LocalPath = value
 		case "strip_pre":
 			t.StripPre = value
+		case "forward_auth":
+			t.ForwardAuth = value
 		case "read_only":
 			t.ReadOnly = common.GetBoolByStr(value)
 		case "local_proxy":
@@ -468,6 +529,13 @@ func dealMultiUser(s string) map[string]string {
 	return multiUserMap
 }
 
+func appendConfigLine(lines, line string) string {
+	if lines == "" {
+		return line
+	}
+	return lines + "\n" + line
+}
+
 func loadMultiAccount(path string) (*file.MultiAccount, error) {
 	path = strings.TrimSpace(path)
 	if path == "" {
@@ -0,0 +1,194 @@
+package edgecache
+
+import (
+	"bufio"
+	"container/list"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/djylb/nps/lib/logs"
+)
+
+const (
+	diskFileSuffix  = ".cache"
+	diskMetaMaxSize = 1 << 20
+)
+
+var errDiskEntryCorrupt = errors.New("edgecache: corrupt disk entry")
+
+// diskItem indexes one entry file of the disk tier.
+type diskItem struct {
+	hostID int
+	key    string
+	path   string
+	file   string
+	size   int64
+}
+
+// diskTier keeps the index of the entry files in dir. The files themselves
+// are written and removed by the store without holding its lock.
+type diskTier struct {
+	dir   string
+	limit int64
+	size  int64
+	lru   *list.List
+	index map[string]*list.Element
+	seq   atomic.Uint64
+}
+
+// openDiskTier prepares dir for a new disk tier. Entries left over from a
+// previous run are removed, since their index is gone.
+func openDiskTier(dir string) *diskTier {
+	tier := &diskTier{
+		dir:   dir,
+		lru:   list.New(),
+		index: make(map[string]*list.Element),
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		logs.Warn("Edge cache disk tier %s unavailable: %v", dir, err)
+		return tier
+	}
+	tier.clear()
+	return tier
+}
+
+// clear removes every entry file in the directory of the tier.
+func (d *diskTier) clear() {
+	files, err := filepath.Glob(filepath.Join(d.dir, "*"+diskFileSuffix))
+	if err != nil {
+		return
+	}
+	removeDiskFiles(files)
+}
+
+func (d *diskTier) add(item *diskItem) {
+	d.index[item.key] = d.lru.PushFront(item)
+	d.size += item.size
+}
+
+// take removes key from the index and returns its item, or nil.
+func (d *diskTier) take(key string) *diskItem {
+	elem, ok := d.index[key]
+	if !ok {
+		return nil
+	}
+	return d.remove(elem)
+}
+
+func (d *diskTier) remove(elem *list.Element) *diskItem {
+	item := elem.Value.(*diskItem)
+	d.lru.Remove(elem)
+	delete(d.index, item.key)
+	d.size -= item.size
+	return item
+}
+
+// removeWhere removes the matching items and returns their files.
+func (d *diskTier) removeWhere(match func(*diskItem) bool) []string {
+	var files []string
+	for elem := d.lru.Front(); elem != nil; {
+		next := elem.Next()
+		if item := elem.Value.(*diskItem); match(item) {
+			files = append(files, d.remove(elem).file)
+		}
+		elem = next
+	}
+	return files
+}
+
+// evict removes the least recently used items until the tier fits and
+// returns them.
+func (d *diskTier) evict() []*diskItem {
+	var items []*diskItem
+	for d.size > d.limit {
+		elem := d.lru.Back()
+		if elem == nil {
+			break
+		}
+		items = append(items, d.remove(elem))
+	}
+	return items
+}
+
+// write stores entry in a new file and returns its name and size. Every
+// write uses a new name, so a file being removed never hides a newer copy.
+func (d *diskTier) write(entry *Entry) (string, int64, error) {
+	meta, err := json.Marshal(entry)
+	if err != nil {
+		return "", 0, err
+	}
+	sum := sha256.Sum256([]byte(entry.Key))
+	name := filepath.Join(d.dir, hex.EncodeToString(sum[:12])+"-"+strconv.FormatUint(d.seq.Add(1), 36)+diskFileSuffix)
+	file, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return "", 0, err
+	}
+	w := bufio.NewWriter(file)
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(meta)))
+	_, err = w.Write(length[:])
+	if err == nil {
+		_, err = w.Write(meta)
+	}
+	if err == nil {
+		_, err = w.Write(entry.Body)
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(name)
+		return "", 0, err
+	}
+	return name, int64(len(length) + len(meta) + len(entry.Body)), nil
+}
+
+// readDiskEntry loads an entry file: a 4-byte big-endian length, the JSON
+// metadata and the body.
+func readDiskEntry(name string) (*Entry, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = file.Close() }()
+	r := bufio.NewReader(file)
+	var length [4]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(length[:])
+	if size == 0 || size > diskMetaMaxSize {
+		return nil, errDiskEntryCorrupt
+	}
+	meta := make([]byte, size)
+	if _, err := io.ReadFull(r, meta); err != nil {
+		return nil, err
+	}
+	entry := new(Entry)
+	if err := json.Unmarshal(meta, entry); err != nil {
+		return nil, err
+	}
+	if entry.Body, err = io.ReadAll(r); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func removeDiskFiles(files []string) {
+	for _, name := range files {
+		if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+			logs.Warn("Remove edge cache file %s: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,571 @@
+// Package edgecache stores HTTP responses of domain hosts at the edge, so
+// repeated requests for static assets do not cross the client tunnel.
+//
+// Entries live in a memory tier and are demoted to an optional disk tier
+// when memory is full. Both tiers are bounded by size and evict the least
+// recently used entries first. HTTP semantics (freshness, validators) are
+// left to the caller; the store only keeps entries and their Vary headers.
+package edgecache
+
+import (
+	"container/list"
+	"net/http"
+	"net/textproto"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Options are the limits of a Store. A zero MemoryBytes keeps entries on
+// disk only; a zero DiskBytes or empty DiskPath disables the disk tier.
+type Options struct {
+	MemoryBytes    int64
+	DiskBytes      int64
+	DiskPath       string
+	MaxObjectBytes int64
+}
+
+func (o Options) diskEnabled() bool {
+	return o.DiskBytes > 0 && o.DiskPath != ""
+}
+
+// Enabled reports whether the options leave room for any entry.
+func (o Options) Enabled() bool {
+	return o.MemoryBytes > 0 || o.diskEnabled()
+}
+
+// Entry is a stored response. Entries are not modified once stored; to
+// refresh one, store a new entry under the same key.
+type Entry struct {
+	HostID   int         `json:"host_id"`
+	Key      string      `json:"key"`
+	Path     string      `json:"path"`
+	Status   int         `json:"status"`
+	Header   http.Header `json:"header"`
+	Body     []byte      `json:"-"`
+	StoredAt time.Time   `json:"stored_at"`
+	Expires  time.Time   `json:"expires"`
+}
+
+// Fresh reports whether the entry can be served without revalidation.
+func (e *Entry) Fresh(now time.Time) bool {
+	return now.Before(e.Expires)
+}
+
+// Age is the time since the entry was stored or last revalidated.
+func (e *Entry) Age(now time.Time) time.Duration {
+	if age := now.Sub(e.StoredAt); age > 0 {
+		return age
+	}
+	return 0
+}
+
+func (e *Entry) size() int64 {
+	size := int64(len(e.Body) + len(e.Key) + len(e.Path) + 64)
+	for name, values := range e.Header {
+		size += int64(len(name))
+		for _, value := range values {
+			size += int64(len(value))
+		}
+	}
+	return size
+}
+
+// Stats are the counters of one host.
+type Stats struct {
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+}
+
+type hostStats struct {
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+// varyRecord remembers the request headers a stored response varies on.
+// It lives as long as one of its variants is held by a tier, so URLs that
+// were evicted do not keep memory.
+type varyRecord struct {
+	hostID   int
+	path     string
+	names    []string
+	variants int
+}
+
+// demotedEntry is an entry evicted from memory on its way to disk. It
+// still counts as a variant of its record until it is written or dropped.
+type demotedEntry struct {
+	entry  *Entry
+	record *varyRecord
+}
+
+// Store is a two-tier response cache. It is safe for concurrent use.
+type Store struct {
+	mu      sync.Mutex
+	opts    Options
+	mem     *list.List
+	memIdx  map[string]*list.Element
+	memSize int64
+	disk    *diskTier
+	vary    map[string]*varyRecord
+
+	statsMu sync.RWMutex
+	stats   map[int]*hostStats
+}
+
+// NewStore returns a store with the given limits.
+func NewStore(opts Options) *Store {
+	s := &Store{
+		mem:    list.New(),
+		memIdx: make(map[string]*list.Element),
+		vary:   make(map[string]*varyRecord),
+		stats:  make(map[int]*hostStats),
+	}
+	s.Configure(opts)
+	return s
+}
+
+// Configure changes the limits of the store. Entries over the new limits
+// are evicted. Changing the disk path drops the old disk tier and clears
+// the new directory.
+func (s *Store) Configure(opts Options) {
+	s.mu.Lock()
+	if s.opts == opts {
+		s.mu.Unlock()
+		return
+	}
+	old := s.disk
+	if old != nil && (!opts.diskEnabled() || old.dir != opts.DiskPath) {
+		for elem := old.lru.Front(); elem != nil; elem = elem.Next() {
+			s.releaseKeyLocked(elem.Value.(*diskItem).key)
+		}
+		s.disk = nil
+	} else {
+		old = nil
+	}
+	if s.disk == nil && opts.diskEnabled() {
+		s.disk = openDiskTier(opts.DiskPath)
+	}
+	s.opts = opts
+	if s.disk != nil {
+		s.disk.limit = opts.DiskBytes
+	}
+	demoted := s.evictMemoryLocked()
+	removed := s.evictDiskLocked()
+	s.mu.Unlock()
+
+	if old != nil {
+		old.clear()
+	}
+	s.demote(demoted)
+	removeDiskFiles(removed)
+}
+
+// Options returns the current limits of the store.
+func (s *Store) Options() Options {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.opts
+}
+
+// MaxObjectBytes is the largest body the store accepts.
+func (s *Store) MaxObjectBytes() int64 {
+	return s.Options().MaxObjectBytes
+}
+
+// Lookup returns the entry stored for primary that matches the Vary headers
+// of the request, or nil.
+func (s *Store) Lookup(primary string, reqHeader http.Header) *Entry {
+	s.mu.Lock()
+	record, ok := s.vary[primary]
+	if !ok {
+		s.mu.Unlock()
+		return nil
+	}
+	key := variantKey(primary, record.names, reqHeader)
+	if elem, ok := s.memIdx[key]; ok {
+		s.mem.MoveToFront(elem)
+		entry := elem.Value.(*Entry)
+		s.mu.Unlock()
+		return entry
+	}
+	var file string
+	if s.disk != nil {
+		if elem, ok := s.disk.index[key]; ok {
+			s.disk.lru.MoveToFront(elem)
+			file = elem.Value.(*diskItem).file
+		}
+	}
+	memoryLimit := s.opts.MemoryBytes
+	s.mu.Unlock()
+	if file == "" {
+		return nil
+	}
+	// The file may be evicted before it is read, which is a miss.
+	entry, err := readDiskEntry(file)
+	if err != nil || entry.Key != key {
+		return nil
+	}
+	if entry.size() <= memoryLimit {
+		s.Put(entry)
+	}
+	return entry
+}
+
+// Store keeps entry under primary, varying on the named request headers.
+// It sets entry.Key and reports whether the entry was accepted.
+func (s *Store) Store(primary string, varyNames []string, reqHeader http.Header, entry *Entry) bool {
+	if entry == nil {
+		return false
+	}
+	names := canonicalVaryNames(varyNames)
+	entry.Key = variantKey(primary, names, reqHeader)
+	s.mu.Lock()
+	var removed []string
+	record := s.vary[primary]
+	if record != nil && !sameNames(record.names, names) {
+		removed = s.dropPrimaryLocked(primary)
+		record = nil
+	}
+	if record == nil {
+		record = &varyRecord{names: names}
+		s.vary[primary] = record
+	}
+	record.hostID, record.path = entry.HostID, entry.Path
+	s.mu.Unlock()
+	removeDiskFiles(removed)
+	if s.Put(entry) {
+		return true
+	}
+	s.mu.Lock()
+	if record.variants <= 0 && s.vary[primary] == record {
+		delete(s.vary, primary)
+	}
+	s.mu.Unlock()
+	return false
+}
+
+// Put keeps an entry whose Key is already set, replacing any entry with the
+// same key.
+func (s *Store) Put(entry *Entry) bool {
+	s.mu.Lock()
+	opts := s.opts
+	if !opts.Enabled() || (opts.MaxObjectBytes > 0 && int64(len(entry.Body)) > opts.MaxObjectBytes) {
+		s.mu.Unlock()
+		return false
+	}
+	var removed []string
+	// Counted before the old copy is released, so the record survives.
+	s.retainKeyLocked(entry.Key)
+	s.removeKeyLocked(entry.Key, &removed)
+	s.memIdx[entry.Key] = s.mem.PushFront(entry)
+	s.memSize += entry.size()
+	demoted := s.evictMemoryLocked()
+	s.mu.Unlock()
+
+	removeDiskFiles(removed)
+	s.demote(demoted)
+	return true
+}
+
+// Purge removes the entries of a host whose path starts with prefix. An
+// empty prefix or "/" removes every entry of the host. It returns the
+// number of removed entries.
+func (s *Store) Purge(hostID int, prefix string) int {
+	if prefix == "/" {
+		prefix = ""
+	}
+	match := func(id int, path string) bool {
+		return id == hostID && strings.HasPrefix(path, prefix)
+	}
+	s.mu.Lock()
+	count := 0
+	for elem := s.mem.Front(); elem != nil; {
+		next := elem.Next()
+		if entry := elem.Value.(*Entry); match(entry.HostID, entry.Path) {
+			s.removeMemoryLocked(elem)
+			count++
+		}
+		elem = next
+	}
+	var removed []string
+	if s.disk != nil {
+		removed = s.disk.removeWhere(func(item *diskItem) bool {
+			return match(item.hostID, item.path)
+		})
+		count += len(removed)
+	}
+	for primary, record := range s.vary {
+		if match(record.hostID, record.path) {
+			delete(s.vary, primary)
+		}
+	}
+	s.mu.Unlock()
+	removeDiskFiles(removed)
+	return count
+}
+
+// Usage returns the bytes held by the memory and disk tiers.
+func (s *Store) Usage() (memory, disk int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.disk != nil {
+		disk = s.disk.size
+	}
+	return s.memSize, disk
+}
+
+// RecordHit counts a request of host answered from the cache.
+func (s *Store) RecordHit(hostID int) {
+	s.hostStats(hostID).hits.Add(1)
+}
+
+// RecordMiss counts a cacheable request of host sent to the backend.
+func (s *Store) RecordMiss(hostID int) {
+	s.hostStats(hostID).misses.Add(1)
+}
+
+// Stats returns the counters of a host.
+func (s *Store) Stats(hostID int) Stats {
+	s.statsMu.RLock()
+	stats := s.stats[hostID]
+	s.statsMu.RUnlock()
+	if stats == nil {
+		return Stats{}
+	}
+	return Stats{Hits: stats.hits.Load(), Misses: stats.misses.Load()}
+}
+
+// ForgetHost removes the entries and counters of a deleted host.
+func (s *Store) ForgetHost(hostID int) {
+	s.Purge(hostID, "")
+	s.statsMu.Lock()
+	delete(s.stats, hostID)
+	s.statsMu.Unlock()
+}
+
+func (s *Store) hostStats(hostID int) *hostStats {
+	s.statsMu.RLock()
+	stats := s.stats[hostID]
+	s.statsMu.RUnlock()
+	if stats != nil {
+		return stats
+	}
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	if stats = s.stats[hostID]; stats == nil {
+		stats = &hostStats{}
+		s.stats[hostID] = stats
+	}
+	return stats
+}
+
+func (s *Store) removeKeyLocked(key string, removed *[]string) {
+	if elem, ok := s.memIdx[key]; ok {
+		s.removeMemoryLocked(elem)
+	}
+	if s.disk != nil {
+		if item := s.disk.take(key); item != nil {
+			s.releaseKeyLocked(item.key)
+			*removed = append(*removed, item.file)
+		}
+	}
+}
+
+func (s *Store) removeMemoryLocked(elem *list.Element) {
+	s.releaseKeyLocked(s.unlinkMemoryLocked(elem).Key)
+}
+
+// unlinkMemoryLocked removes elem from the memory tier but keeps it as a
+// variant of its record.
+func (s *Store) unlinkMemoryLocked(elem *list.Element) *Entry {
+	entry := elem.Value.(*Entry)
+	s.mem.Remove(elem)
+	delete(s.memIdx, entry.Key)
+	s.memSize -= entry.size()
+	return entry
+}
+
+// retainKeyLocked counts key as a variant of its record.
+func (s *Store) retainKeyLocked(key string) {
+	if record := s.vary[primaryOf(key)]; record != nil {
+		record.variants++
+	}
+}
+
+func (s *Store) releaseKeyLocked(key string) {
+	primary := primaryOf(key)
+	s.releaseLocked(primary, s.vary[primary])
+}
+
+// releaseLocked uncounts a variant of record and forgets the record with
+// its last variant.
+func (s *Store) releaseLocked(primary string, record *varyRecord) {
+	if record == nil {
+		return
+	}
+	record.variants--
+	if record.variants <= 0 && s.vary[primary] == record {
+		delete(s.vary, primary)
+	}
+}
+
+// dropPrimaryLocked removes all variants of primary, used when the Vary
+// header of a resource changes. It returns the disk files to remove.
+func (s *Store) dropPrimaryLocked(primary string) []string {
+	for key, elem := range s.memIdx {
+		if primaryOf(key) == primary {
+			s.removeMemoryLocked(elem)
+		}
+	}
+	delete(s.vary, primary)
+	if s.disk == nil {
+		return nil
+	}
+	return s.disk.removeWhere(func(item *diskItem) bool {
+		return primaryOf(item.key) == primary
+	})
+}
+
+// evictMemoryLocked removes the least recently used entries until the
+// memory tier fits. The removed entries are returned for the disk tier.
+func (s *Store) evictMemoryLocked() []demotedEntry {
+	var demoted []demotedEntry
+	for s.memSize > s.opts.MemoryBytes {
+		elem := s.mem.Back()
+		if elem == nil {
+			break
+		}
+		if s.disk == nil {
+			s.removeMemoryLocked(elem)
+			continue
+		}
+		entry := s.unlinkMemoryLocked(elem)
+		demoted = append(demoted, demotedEntry{entry: entry, record: s.vary[primaryOf(entry.Key)]})
+	}
+	return demoted
+}
+
+func (s *Store) evictDiskLocked() []string {
+	if s.disk == nil {
+		return nil
+	}
+	return s.releaseDiskLocked(s.disk.evict())
+}
+
+// releaseDiskLocked uncounts items removed from the disk tier and returns
+// their files.
+func (s *Store) releaseDiskLocked(items []*diskItem) []string {
+	files := make([]string, 0, len(items))
+	for _, item := range items {
+		s.releaseKeyLocked(item.key)
+		files = append(files, item.file)
+	}
+	return files
+}
+
+// demote writes entries evicted from memory to the disk tier. Files are
+// written without holding the lock; an entry stored again in the meantime
+// wins over its demoted copy.
+func (s *Store) demote(entries []demotedEntry) {
+	for _, demoted := range entries {
+		entry, primary := demoted.entry, primaryOf(demoted.entry.Key)
+		s.mu.Lock()
+		disk := s.disk
+		s.mu.Unlock()
+		file, size, err := "", int64(0), error(nil)
+		if disk != nil && entry.size() <= disk.limit {
+			file, size, err = disk.write(entry)
+		}
+		s.mu.Lock()
+		_, inMemory := s.memIdx[entry.Key]
+		known := s.vary[primary] == demoted.record
+		var removed []string
+		if file == "" || err != nil || s.disk != disk || inMemory || !known {
+			if file != "" && err == nil {
+				removed = []string{file}
+			}
+			s.releaseLocked(primary, demoted.record)
+		} else {
+			if item := disk.take(entry.Key); item != nil {
+				s.releaseKeyLocked(item.key)
+				removed = append(removed, item.file)
+			}
+			disk.add(&diskItem{hostID: entry.HostID, key: entry.Key, path: entry.Path, file: file, size: size})
+			removed = append(removed, s.releaseDiskLocked(disk.evict())...)
+		}
+		s.mu.Unlock()
+		removeDiskFiles(removed)
+	}
+}
+
+// variantKey is the storage key of the response to a request: the primary
+// key followed by the values of the request headers it varies on.
+func variantKey(primary string, names []string, reqHeader http.Header) string {
+	if len(names) == 0 {
+		return primary
+	}
+	var b strings.Builder
+	b.WriteString(primary)
+	for _, name := range names {
+		b.WriteByte(0)
+		b.WriteString(name)
+		b.WriteByte('=')
+		b.WriteString(strings.Join(reqHeader.Values(name), ","))
+	}
+	return b.String()
+}
+
+func primaryOf(key string) string {
+	primary, _, _ := strings.Cut(key, "\x00")
+	return primary
+}
+
+func canonicalVaryNames(names []string) []string {
+	var out []string
+	for _, value := range names {
+		for _, name := range strings.Split(value, ",") {
+			name = textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(name))
+			if name != "" && !containsName(out, name) {
+				out = append(out, name)
+			}
+		}
+	}
+	return out
+}
+
+func containsName(names []string, name string) bool {
+	for _, current := range names {
+		if current == name {
+			return true
+		}
+	}
+	return false
+}
+
+func sameNames(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+var (
+	defaultStoreOnce sync.Once
+	defaultStore     *Store
+)
+
+// Default returns the process-wide store used by the HTTP proxy. It starts
+// disabled until configured.
+func Default() *Store {
+	defaultStoreOnce.Do(func() {
+		defaultStore = NewStore(Options{})
+	})
+	return defaultStore
+}
@@ -0,0 +1,161 @@
+package edgecache
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testEntry(hostID int, path string, body string) *Entry {
+	now := time.Now()
+	return &Entry{
+		HostID:   hostID,
+		Path:     path,
+		Status:   http.StatusOK,
+		Header:   http.Header{"Content-Type": {"text/plain"}},
+		Body:     []byte(body),
+		StoredAt: now,
+		Expires:  now.Add(time.Minute),
+	}
+}
+
+func TestStoreLookupHonoursVary(t *testing.T) {
+	store := NewStore(Options{MemoryBytes: 1 << 20})
+	gzipReq := http.Header{"Accept-Encoding": {"gzip"}}
+	plainReq := http.Header{}
+
+	if !store.Store("a.example.com/app.js", []string{"accept-encoding"}, gzipReq, testEntry(1, "/app.js", "gzipped")) {
+		t.Fatal("Store() rejected the entry")
+	}
+	if got := store.Lookup("a.example.com/app.js", plainReq); got != nil {
+		t.Fatalf("Lookup() without Accept-Encoding = %q, want miss", got.Body)
+	}
+	store.Store("a.example.com/app.js", []string{"Accept-Encoding"}, plainReq, testEntry(1, "/app.js", "plain"))
+	if got := store.Lookup("a.example.com/app.js", gzipReq); got == nil || string(got.Body) != "gzipped" {
+		t.Fatalf("Lookup() gzip variant = %v", got)
+	}
+	if got := store.Lookup("a.example.com/app.js", plainReq); got == nil || string(got.Body) != "plain" {
+		t.Fatalf("Lookup() plain variant = %v", got)
+	}
+
+	// A response that stops varying replaces every variant.
+	store.Store("a.example.com/app.js", nil, plainReq, testEntry(1, "/app.js", "single"))
+	if got := store.Lookup("a.example.com/app.js", gzipReq); got == nil || string(got.Body) != "single" {
+		t.Fatalf("Lookup() after Vary change = %v", got)
+	}
+	if memory, _ := store.Usage(); memory != testEntry(1, "/app.js", "single").sizeWithKey("a.example.com/app.js") {
+		t.Fatalf("memory usage = %d, stale variants were kept", memory)
+	}
+}
+
+func TestStoreEvictsLeastRecentlyUsedAndRejectsLargeObjects(t *testing.T) {
+	entrySize := testEntry(1, "/a", strings.Repeat("x", 100)).sizeWithKey("h/a")
+	store := NewStore(Options{MemoryBytes: 2 * entrySize, MaxObjectBytes: 200})
+	for _, name := range []string{"a", "b"} {
+		store.Store("h/"+name, nil, nil, testEntry(1, "/"+name, strings.Repeat("x", 100)))
+	}
+	store.Lookup("h/a", nil)
+	store.Store("h/c", nil, nil, testEntry(1, "/c", strings.Repeat("x", 100)))
+	if store.Lookup("h/b", nil) != nil {
+		t.Fatal("least recently used entry was not evicted")
+	}
+	if store.Lookup("h/a", nil) == nil || store.Lookup("h/c", nil) == nil {
+		t.Fatal("recently used entries were evicted")
+	}
+	if store.Store("h/big", nil, nil, testEntry(1, "/big", strings.Repeat("x", 201))) {
+		t.Fatal("Store() accepted an object over MaxObjectBytes")
+	}
+}
+
+func TestStoreDemotesToDiskAndPurges(t *testing.T) {
+	dir := t.TempDir()
+	leftover := filepath.Join(dir, "old"+diskFileSuffix)
+	if err := os.WriteFile(leftover, []byte("stale"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	entrySize := testEntry(1, "/a", strings.Repeat("x", 100)).sizeWithKey("h/static/a")
+	store := NewStore(Options{MemoryBytes: entrySize, DiskBytes: 1 << 20, DiskPath: dir})
+	if _, err := os.Stat(leftover); !os.IsNotExist(err) {
+		t.Fatal("files of a previous run were not removed")
+	}
+
+	store.Store("h/static/a", nil, nil, testEntry(1, "/static/a", strings.Repeat("a", 100)))
+	store.Store("h/static/b", nil, nil, testEntry(1, "/static/b", strings.Repeat("b", 100)))
+	store.Store("h/api/c", nil, nil, testEntry(1, "/api/c", strings.Repeat("c", 100)))
+	store.Store("other/static/a", nil, nil, testEntry(2, "/static/a", "other host"))
+	if _, disk := store.Usage(); disk == 0 {
+		t.Fatal("evicted entries were not written to disk")
+	}
+
+	got := store.Lookup("h/static/a", http.Header{})
+	if got == nil || string(got.Body) != strings.Repeat("a", 100) || got.Header.Get("Content-Type") != "text/plain" {
+		t.Fatalf("Lookup() from disk = %+v", got)
+	}
+
+	if removed := store.Purge(1, "/static/"); removed != 2 {
+		t.Fatalf("Purge(1, /static/) removed %d entries, want 2", removed)
+	}
+	if store.Lookup("h/static/a", nil) != nil || store.Lookup("h/static/b", nil) != nil {
+		t.Fatal("purged entries are still served")
+	}
+	if store.Lookup("h/api/c", nil) == nil || store.Lookup("other/static/a", nil) == nil {
+		t.Fatal("Purge() removed entries outside the prefix or host")
+	}
+
+	store.Configure(Options{MemoryBytes: 1 << 20})
+	files, _ := filepath.Glob(filepath.Join(dir, "*"+diskFileSuffix))
+	if len(files) != 0 {
+		t.Fatalf("disabling the disk tier left %d files", len(files))
+	}
+}
+
+func TestStoreForgetsVaryOfEvictedURLs(t *testing.T) {
+	entrySize := testEntry(1, "/a", strings.Repeat("x", 100)).sizeWithKey("h/a?r=0000")
+	for name, opts := range map[string]Options{
+		"memory": {MemoryBytes: 4 * entrySize, MaxObjectBytes: 200},
+		"disk":   {MemoryBytes: 2 * entrySize, DiskBytes: 4 * entrySize, DiskPath: t.TempDir(), MaxObjectBytes: 200},
+	} {
+		store := NewStore(opts)
+		for i := 0; i < 1000; i++ {
+			primary := fmt.Sprintf("h/a?r=%04d", i)
+			store.Store(primary, []string{"Accept-Encoding"}, http.Header{}, testEntry(1, "/a", strings.Repeat("x", 100)))
+			store.Store(primary+"big", nil, nil, testEntry(1, "/a", strings.Repeat("x", 201)))
+		}
+		store.mu.Lock()
+		records, held := len(store.vary), len(store.memIdx)
+		if store.disk != nil {
+			held += len(store.disk.index)
+		}
+		store.mu.Unlock()
+		if records > held || records > 8 {
+			t.Fatalf("%s: %d vary records for %d held entries", name, records, held)
+		}
+		if store.Lookup("h/a?r=0999", http.Header{}) == nil {
+			t.Fatalf("%s: newest entry is not served", name)
+		}
+	}
+}
+
+func TestStoreStats(t *testing.T) {
+	store := NewStore(Options{MemoryBytes: 1 << 20})
+	store.RecordHit(3)
+	store.RecordHit(3)
+	store.RecordMiss(3)
+	if got := store.Stats(3); got != (Stats{Hits: 2, Misses: 1}) {
+		t.Fatalf("Stats(3) = %+v", got)
+	}
+	store.ForgetHost(3)
+	if got := store.Stats(3); got != (Stats{}) {
+		t.Fatalf("Stats(3) after ForgetHost = %+v", got)
+	}
+}
+
+func (e *Entry) sizeWithKey(key string) int64 {
+	clone := *e
+	clone.Key = key
+	return clone.size()
+}
@@ -411,6 +411,7 @@ func InitializeGlobalRuntime(glob *Glob) {
 		return
 	}
 	glob.CompileSourcePolicy()
+	glob.WAFRuleSet()
 }
 
 func RecompileAccessPoliciesIfLoaded() {
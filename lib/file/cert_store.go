@@ -0,0 +1,277 @@
+package file
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/djylb/nps/lib/common"
+)
+
+const DefaultCertStorePath = "conf/certs.json"
+
+var (
+	ErrCertStoreDisabled  = errors.New("certificate store is disabled")
+	ErrStoredCertNotFound = errors.New("stored certificate not found")
+	ErrStoredCertExists   = errors.New("stored certificate name already exists")
+
+	currentCertStore atomic.Pointer[CertStore]
+)
+
+// CertStoreOptions configures where the named certificates are kept.
+type CertStoreOptions struct {
+	Path string
+}
+
+// ResolvePath returns the absolute certificate store file for runPath.
+func (o CertStoreOptions) ResolvePath(runPath string) string {
+	path := strings.TrimSpace(o.Path)
+	if path == "" {
+		path = DefaultCertStorePath
+	}
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(runPath, path)
+}
+
+// StoredCert is a named certificate and key pair that hosts reference by ID
+// through Host.CertID. CertFile and KeyFile hold the PEM content;
+// CreatedAt and UpdatedAt are unix seconds.
+type StoredCert struct {
+	ID        int    `json:"id"`
+	Name      string `json:"name"`
+	Remark    string `json:"remark,omitempty"`
+	CertFile  string `json:"cert_file"`
+	KeyFile   string `json:"key_file"`
+	CreatedAt int64  `json:"created_at"`
+	UpdatedAt int64  `json:"updated_at"`
+}
+
+type certStoreFile struct {
+	NextID int           `json:"next_id"`
+	Certs  []*StoredCert `json:"certs"`
+}
+
+// CertStore keeps the named certificates in a JSON file next to the other
+// configuration files. Names are unique, case-insensitively.
+type CertStore struct {
+	mu     sync.Mutex
+	path   string
+	now    func() time.Time
+	nextID int
+	certs  []*StoredCert
+}
+
+// OpenCertStore loads the certificate store at path, creating an empty one
+// when the file does not exist yet.
+func OpenCertStore(path string) (*CertStore, error) {
+	s := &CertStore{path: path, now: time.Now}
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(data) > 0 {
+		var stored certStoreFile
+		if err := json.Unmarshal(data, &stored); err != nil {
+			return nil, fmt.Errorf("decode certificate store %s: %w", path, err)
+		}
+		s.nextID = stored.NextID
+		for _, cert := range stored.Certs {
+			if cert == nil {
+				continue
+			}
+			s.certs = append(s.certs, cert)
+			if cert.ID > s.nextID {
+				s.nextID = cert.ID
+			}
+		}
+	}
+	return s, nil
+}
+
+// ConfigureCertStore opens the certificate store described by options and
+// makes it the one returned by CurrentCertStore.
+func ConfigureCertStore(options CertStoreOptions) (*CertStore, error) {
+	path := options.ResolvePath(common.GetRunPath())
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	store, err := OpenCertStore(path)
+	if err != nil {
+		return nil, err
+	}
+	currentCertStore.Store(store)
+	return store, nil
+}
+
+// CurrentCertStore returns the active certificate store, or nil when none
+// has been configured.
+func CurrentCertStore() *CertStore {
+	return currentCertStore.Load()
+}
+
+// ReplaceCertStore swaps the active certificate store and returns the
+// previous one.
+func ReplaceCertStore(store *CertStore) *CertStore {
+	return currentCertStore.Swap(store)
+}
+
+func (s *CertStore) Path() string {
+	if s == nil {
+		return ""
+	}
+	return s.path
+}
+
+// List returns all stored certificates ordered by ID.
+func (s *CertStore) List() ([]StoredCert, error) {
+	if s == nil {
+		return nil, ErrCertStoreDisabled
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	items := make([]StoredCert, 0, len(s.certs))
+	for _, cert := range s.certs {
+		items = append(items, *cert)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].ID < items[j].ID })
+	return items, nil
+}
+
+// Get returns a copy of certificate id.
+func (s *CertStore) Get(id int) (StoredCert, error) {
+	if s == nil {
+		return StoredCert{}, ErrCertStoreDisabled
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	index := s.indexLocked(id)
+	if index < 0 {
+		return StoredCert{}, ErrStoredCertNotFound
+	}
+	return *s.certs[index], nil
+}
+
+// Create stores cert under a new ID and returns the stored copy.
+func (s *CertStore) Create(cert StoredCert) (StoredCert, error) {
+	if s == nil {
+		return StoredCert{}, ErrCertStoreDisabled
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cert.Name = strings.TrimSpace(cert.Name)
+	if s.nameTakenLocked(cert.Name, 0) {
+		return StoredCert{}, ErrStoredCertExists
+	}
+	now := s.now().Unix()
+	cert.ID = s.nextID + 1
+	cert.CreatedAt = now
+	cert.UpdatedAt = now
+	stored := cert
+	s.certs = append(s.certs, &stored)
+	s.nextID = cert.ID
+	if err := s.persistLocked(); err != nil {
+		s.certs = s.certs[:len(s.certs)-1]
+		s.nextID--
+		return StoredCert{}, err
+	}
+	return cert, nil
+}
+
+// Update replaces the name, remark and content of certificate cert.ID.
+func (s *CertStore) Update(cert StoredCert) (StoredCert, error) {
+	if s == nil {
+		return StoredCert{}, ErrCertStoreDisabled
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	index := s.indexLocked(cert.ID)
+	if index < 0 {
+		return StoredCert{}, ErrStoredCertNotFound
+	}
+	cert.Name = strings.TrimSpace(cert.Name)
+	if s.nameTakenLocked(cert.Name, cert.ID) {
+		return StoredCert{}, ErrStoredCertExists
+	}
+	previous := s.certs[index]
+	cert.CreatedAt = previous.CreatedAt
+	cert.UpdatedAt = s.now().Unix()
+	stored := cert
+	s.certs[index] = &stored
+	if err := s.persistLocked(); err != nil {
+		s.certs[index] = previous
+		return StoredCert{}, err
+	}
+	return cert, nil
+}
+
+// Delete removes certificate id and returns it.
+func (s *CertStore) Delete(id int) (StoredCert, error) {
+	if s == nil {
+		return StoredCert{}, ErrCertStoreDisabled
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	index := s.indexLocked(id)
+	if index < 0 {
+		return StoredCert{}, ErrStoredCertNotFound
+	}
+	previous := s.certs
+	removed := previous[index]
+	s.certs = append(append([]*StoredCert(nil), previous[:index]...), previous[index+1:]...)
+	if err := s.persistLocked(); err != nil {
+		s.certs = previous
+		return StoredCert{}, err
+	}
+	return *removed, nil
+}
+
+func (s *CertStore) indexLocked(id int) int {
+	for i, cert := range s.certs {
+		if cert.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *CertStore) nameTakenLocked(name string, exceptID int) bool {
+	for _, cert := range s.certs {
+		if cert.ID != exceptID && strings.EqualFold(cert.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *CertStore) persistLocked() (err error) {
+	certs := s.certs
+	if certs == nil {
+		certs = []*StoredCert{}
+	}
+	data, err := json.Marshal(certStoreFile{NextID: s.nextID, Certs: certs})
+	if err != nil {
+		return err
+	}
+	tmpPath := s.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o600); err != nil {
+		return fmt.Errorf("write certificate store %s: %w", tmpPath, err)
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(tmpPath)
+		}
+	}()
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return fmt.Errorf("replace certificate store %s: %w", s.path, err)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package file
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCertStoreCRUDAcrossReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "certs.json")
+	store, err := OpenCertStore(path)
+	if err != nil {
+		t.Fatalf("OpenCertStore() error = %v", err)
+	}
+	store.now = func() time.Time { return time.Unix(1_700_000_000, 0) }
+
+	wildcard, err := store.Create(StoredCert{Name: " wildcard ", CertFile: "cert-v1", KeyFile: "key-v1"})
+	if err != nil || wildcard.ID != 1 || wildcard.Name != "wildcard" || wildcard.CreatedAt != 1_700_000_000 {
+		t.Fatalf("Create() = %+v, %v", wildcard, err)
+	}
+	if _, err := store.Create(StoredCert{Name: "WILDCARD"}); !errors.Is(err, ErrStoredCertExists) {
+		t.Fatalf("Create(duplicate name) error = %v, want ErrStoredCertExists", err)
+	}
+	other, err := store.Create(StoredCert{Name: "other", CertFile: "c", KeyFile: "k"})
+	if err != nil || other.ID != 2 {
+		t.Fatalf("Create(other) = %+v, %v", other, err)
+	}
+	if _, err := store.Update(StoredCert{ID: other.ID, Name: "wildcard"}); !errors.Is(err, ErrStoredCertExists) {
+		t.Fatalf("Update(taken name) error = %v, want ErrStoredCertExists", err)
+	}
+
+	store.now = func() time.Time { return time.Unix(1_700_000_100, 0) }
+	wildcard.CertFile = "cert-v2"
+	updated, err := store.Update(wildcard)
+	if err != nil || updated.CreatedAt != 1_700_000_000 || updated.UpdatedAt != 1_700_000_100 {
+		t.Fatalf("Update() = %+v, %v", updated, err)
+	}
+	if _, err := store.Delete(other.ID); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+
+	reopened, err := OpenCertStore(path)
+	if err != nil {
+		t.Fatalf("OpenCertStore(reopen) error = %v", err)
+	}
+	items, err := reopened.List()
+	if err != nil || len(items) != 1 || items[0].CertFile != "cert-v2" || items[0].KeyFile != "key-v1" {
+		t.Fatalf("List() after reopen = %+v, %v", items, err)
+	}
+	if _, err := reopened.Get(other.ID); !errors.Is(err, ErrStoredCertNotFound) {
+		t.Fatalf("Get(deleted) error = %v, want ErrStoredCertNotFound", err)
+	}
+	if next, err := reopened.Create(StoredCert{Name: "next"}); err != nil || next.ID != 3 {
+		t.Fatalf("Create() after reopen = %+v, %v, want id 3", next, err)
+	}
+
+	var disabled *CertStore
+	if _, err := disabled.List(); !errors.Is(err, ErrCertStoreDisabled) {
+		t.Fatalf("nil store List() error = %v", err)
+	}
+}
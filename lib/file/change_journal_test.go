@@ -0,0 +1,247 @@
+package file
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type stepClock struct {
+	now time.Time
+}
+
+func (c *stepClock) Now() time.Time {
+	c.now = c.now.Add(time.Second)
+	return c.now
+}
+
+func newTestChangeJournal(t *testing.T, options ChangeJournalOptions, clock *stepClock) *ChangeJournal {
+	t.Helper()
+	dir := filepath.Join(t.TempDir(), "journal")
+	j := newChangeJournal(dir, options, func() *ConfigSnapshot {
+		return buildConfigSnapshot(GetDb())
+	})
+	j.now = clock.Now
+	if err := j.open(); err != nil {
+		t.Fatalf("open() error = %v", err)
+	}
+	if err := j.Checkpoint("startup"); err != nil {
+		t.Fatalf("Checkpoint() error = %v", err)
+	}
+	t.Cleanup(func() { _ = j.Close() })
+	return j
+}
+
+func TestChangeJournalReplaysToPointInTime(t *testing.T) {
+	resetStoreTestDB(t)
+	clock := &stepClock{now: time.Unix(1_700_000_000, 0)}
+
+	db := GetDb()
+	if err := db.NewClient(&Client{Id: 1, VerifyKey: "one", Remark: "first", Status: true, Cnf: &Config{}, Flow: &Flow{}}); err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	j := newTestChangeJournal(t, ChangeJournalOptions{}, clock)
+	checkpointAt := clock.now
+
+	client, _ := db.GetClient(1)
+	client.Remark = "renamed"
+	client.Revision++
+	if err := db.UpdateClient(client); err != nil {
+		t.Fatalf("UpdateClient() error = %v", err)
+	}
+	if err := j.RecordClient(client); err != nil {
+		t.Fatalf("RecordClient() error = %v", err)
+	}
+	renamedAt := clock.now
+
+	if err := db.NewClient(&Client{Id: 2, VerifyKey: "two", Status: true, Cnf: &Config{}, Flow: &Flow{}}); err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	second, _ := db.GetClient(2)
+	if err := j.RecordClient(second); err != nil {
+		t.Fatalf("RecordClient() error = %v", err)
+	}
+	if err := db.DelClient(1); err != nil {
+		t.Fatalf("DelClient() error = %v", err)
+	}
+	if err := j.RecordDelete(JournalResourceClient, 1); err != nil {
+		t.Fatalf("RecordDelete() error = %v", err)
+	}
+
+	snapshot, result, err := ReplayChangeJournal(j.Dir(), checkpointAt)
+	if err != nil {
+		t.Fatalf("ReplayChangeJournal(checkpoint) error = %v", err)
+	}
+	if len(snapshot.Clients) != 1 || snapshot.Clients[0].Remark != "first" || result.Entries != 0 {
+		t.Fatalf("replay at checkpoint = %+v (%+v), want only the original client", snapshot.Clients, result)
+	}
+
+	snapshot, result, err = ReplayChangeJournal(j.Dir(), renamedAt)
+	if err != nil {
+		t.Fatalf("ReplayChangeJournal(renamed) error = %v", err)
+	}
+	if len(snapshot.Clients) != 1 || snapshot.Clients[0].Remark != "renamed" || result.Entries != 1 {
+		t.Fatalf("replay after rename = %+v (%+v), want renamed client", snapshot.Clients, result)
+	}
+	if snapshot.Clients[0].Revision != client.Revision || snapshot.Clients[0].Revision == 0 {
+		t.Fatalf("replayed revision = %d, want %d", snapshot.Clients[0].Revision, client.Revision)
+	}
+
+	snapshot, _, err = ReplayChangeJournal(j.Dir(), clock.now)
+	if err != nil {
+		t.Fatalf("ReplayChangeJournal(latest) error = %v", err)
+	}
+	if len(snapshot.Clients) != 1 || snapshot.Clients[0].Id != 2 {
+		t.Fatalf("replay at latest = %+v, want only client 2", snapshot.Clients)
+	}
+
+	if _, _, err := ReplayChangeJournal(j.Dir(), checkpointAt.Add(-time.Hour)); !errors.Is(err, ErrRestorePointUnavailable) {
+		t.Fatalf("ReplayChangeJournal(before checkpoint) error = %v, want ErrRestorePointUnavailable", err)
+	}
+}
+
+func TestChangeJournalReplayKeepsNewestRevision(t *testing.T) {
+	resetStoreTestDB(t)
+	clock := &stepClock{now: time.Unix(1_700_000_000, 0)}
+	j := newTestChangeJournal(t, ChangeJournalOptions{}, clock)
+
+	newer := &User{Id: 3, Username: "tenant", Revision: 5, TotalFlow: &Flow{}}
+	older := &User{Id: 3, Username: "stale", Revision: 4, TotalFlow: &Flow{}}
+	if err := j.RecordUser(newer); err != nil {
+		t.Fatalf("RecordUser(newer) error = %v", err)
+	}
+	if err := j.RecordUser(older); err != nil {
+		t.Fatalf("RecordUser(older) error = %v", err)
+	}
+
+	snapshot, _, err := ReplayChangeJournal(j.Dir(), clock.now)
+	if err != nil {
+		t.Fatalf("ReplayChangeJournal() error = %v", err)
+	}
+	if len(snapshot.Users) != 1 || snapshot.Users[0].Username != "tenant" {
+		t.Fatalf("replayed users = %+v, want the revision 5 record", snapshot.Users)
+	}
+}
+
+func TestChangeJournalRotatesAndPrunesSegments(t *testing.T) {
+	resetStoreTestDB(t)
+	clock := &stepClock{now: time.Unix(1_700_000_000, 0)}
+	j := newTestChangeJournal(t, ChangeJournalOptions{MaxBytes: 1, MaxFiles: 3}, clock)
+
+	for i := 1; i <= 5; i++ {
+		glob := &Glob{EntryAclMode: AclBlacklist, EntryAclRules: "10.0.0." + string(rune('0'+i))}
+		if err := GetDb().SaveGlobal(glob); err != nil {
+			t.Fatalf("SaveGlobal(%d) error = %v", i, err)
+		}
+		if err := j.RecordGlobal(glob); err != nil {
+			t.Fatalf("RecordGlobal(%d) error = %v", i, err)
+		}
+	}
+	segments, err := listChangeJournalSegments(j.Dir())
+	if err != nil {
+		t.Fatalf("listChangeJournalSegments() error = %v", err)
+	}
+	if len(segments) != 3 {
+		t.Fatalf("segments = %d, want 3 after pruning", len(segments))
+	}
+
+	snapshot, _, err := ReplayChangeJournal(j.Dir(), clock.now)
+	if err != nil {
+		t.Fatalf("ReplayChangeJournal() error = %v", err)
+	}
+	if snapshot.Global == nil || snapshot.Global.EntryAclRules != "10.0.0.5" {
+		t.Fatalf("replayed global = %+v, want last recorded rules", snapshot.Global)
+	}
+}
+
+func TestChangeJournalCheckpointDoesNotCountTowardsSegmentSize(t *testing.T) {
+	clock := &stepClock{now: time.Unix(1_700_000_000, 0)}
+	dir := filepath.Join(t.TempDir(), "journal")
+	j := newChangeJournal(dir, ChangeJournalOptions{MaxBytes: 4 << 10, MaxFiles: 3}, func() *ConfigSnapshot {
+		return &ConfigSnapshot{Global: &Glob{EntryAclMode: AclBlacklist, EntryAclRules: strings.Repeat("10.0.0.1\n", 8<<10)}}
+	})
+	j.now = clock.Now
+	if err := j.open(); err != nil {
+		t.Fatalf("open() error = %v", err)
+	}
+	if err := j.Checkpoint("startup"); err != nil {
+		t.Fatalf("Checkpoint() error = %v", err)
+	}
+	t.Cleanup(func() { _ = j.Close() })
+
+	for id := 1; id <= 5; id++ {
+		if err := j.RecordDelete("host", id); err != nil {
+			t.Fatalf("RecordDelete(%d) error = %v", id, err)
+		}
+	}
+	segments, err := listChangeJournalSegments(dir)
+	if err != nil {
+		t.Fatalf("listChangeJournalSegments() error = %v", err)
+	}
+	if len(segments) != 1 {
+		t.Fatalf("segments = %d, want 1 while the changes stay below the limit", len(segments))
+	}
+	entries := 0
+	if err := readChangeJournalSegment(segments[0].path, func(ChangeJournalEntry) bool {
+		entries++
+		return true
+	}); err != nil {
+		t.Fatalf("readChangeJournalSegment() error = %v", err)
+	}
+	if entries != 6 {
+		t.Fatalf("segment entries = %d, want the checkpoint and 5 changes", entries)
+	}
+}
+
+func TestChangeJournalReopenContinuesSequenceAndSkipsTornTail(t *testing.T) {
+	resetStoreTestDB(t)
+	clock := &stepClock{now: time.Unix(1_700_000_000, 0)}
+	j := newTestChangeJournal(t, ChangeJournalOptions{}, clock)
+	if err := j.RecordGlobal(&Glob{EntryAclRules: "kept"}); err != nil {
+		t.Fatalf("RecordGlobal() error = %v", err)
+	}
+	keptAt := clock.now
+	lastSeq := j.seq
+	if err := j.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	segments, _ := listChangeJournalSegments(j.Dir())
+	f, err := os.OpenFile(segments[len(segments)-1].path, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		t.Fatalf("open segment: %v", err)
+	}
+	_, _ = f.WriteString(`{"seq":99,"at":1,"op":"put","resource":"glo`)
+	_ = f.Close()
+
+	reopened := newChangeJournal(j.Dir(), ChangeJournalOptions{}, nil)
+	reopened.now = clock.Now
+	if err := reopened.open(); err != nil {
+		t.Fatalf("open() error = %v", err)
+	}
+	if reopened.seq != lastSeq || reopened.lastAt != keptAt.UnixNano() {
+		t.Fatalf("reopened seq/lastAt = %d/%d, want %d/%d", reopened.seq, reopened.lastAt, lastSeq, keptAt.UnixNano())
+	}
+
+	snapshot, _, err := ReplayChangeJournal(j.Dir(), keptAt)
+	if err != nil {
+		t.Fatalf("ReplayChangeJournal() error = %v", err)
+	}
+	if snapshot.Global == nil || snapshot.Global.EntryAclRules != "kept" {
+		t.Fatalf("replayed global = %+v, want kept", snapshot.Global)
+	}
+}
+
+func TestParseRestorePoint(t *testing.T) {
+	if got, err := ParseRestorePoint("1700000000"); err != nil || !got.Equal(time.Unix(1_700_000_000, 0)) {
+		t.Fatalf("ParseRestorePoint(unix) = %v, %v", got, err)
+	}
+	if got, err := ParseRestorePoint("2023-11-14T22:13:20Z"); err != nil || !got.Equal(time.Unix(1_700_000_000, 0)) {
+		t.Fatalf("ParseRestorePoint(rfc3339) = %v, %v", got, err)
+	}
+	if _, err := ParseRestorePoint("yesterday"); err == nil {
+		t.Fatal("ParseRestorePoint(invalid) should fail")
+	}
+}
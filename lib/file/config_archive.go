@@ -0,0 +1,551 @@
+package file
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/djylb/nps/lib/common"
+	"github.com/djylb/nps/lib/logs"
+)
+
+const (
+	DefaultConfigArchivePath = "conf/snapshots"
+	DefaultConfigArchiveKeep = 7
+
+	configArchivePrefix      = "snapshot-"
+	configArchiveSuffix      = ".tar.gz"
+	configArchiveTimeLayout  = "20060102-150405.000"
+	configArchiveManifest    = "manifest.json"
+	configArchiveUsersFile   = "users.json"
+	configArchiveClientsFile = "clients.json"
+	configArchiveTasksFile   = "tasks.json"
+	configArchiveHostsFile   = "hosts.json"
+	configArchiveGlobalFile  = "global.json"
+)
+
+var (
+	ErrConfigArchiveDisabled = errors.New("config snapshots are disabled")
+	ErrConfigArchiveNotFound = errors.New("config snapshot not found")
+
+	currentConfigArchiver atomic.Pointer[ConfigArchiver]
+)
+
+// ConfigArchiveOptions configures where snapshot archives are written, how
+// often the scheduler takes one and how many are kept. Interval 0 disables
+// the scheduler but keeps manual snapshots available; Keep <= 0 falls back to
+// DefaultConfigArchiveKeep and MaxAge 0 disables age-based pruning.
+type ConfigArchiveOptions struct {
+	Path     string
+	Interval time.Duration
+	Keep     int
+	MaxAge   time.Duration
+}
+
+// ResolvePath returns the absolute snapshot directory for runPath.
+func (o ConfigArchiveOptions) ResolvePath(runPath string) string {
+	path := strings.TrimSpace(o.Path)
+	if path == "" {
+		path = DefaultConfigArchivePath
+	}
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(runPath, path)
+}
+
+// ConfigArchiveInfo describes one snapshot archive. CreatedAt is in unix
+// seconds; the record counts come from the archive manifest.
+type ConfigArchiveInfo struct {
+	Name      string `json:"name"`
+	Size      int64  `json:"size"`
+	CreatedAt int64  `json:"created_at"`
+	Source    string `json:"source,omitempty"`
+	Users     int    `json:"users"`
+	Clients   int    `json:"clients"`
+	Tunnels   int    `json:"tunnels"`
+	Hosts     int    `json:"hosts"`
+}
+
+type configArchiveManifestData struct {
+	CreatedAt int64  `json:"created_at"`
+	Source    string `json:"source,omitempty"`
+	Users     int    `json:"users"`
+	Clients   int    `json:"clients"`
+	Tunnels   int    `json:"tunnels"`
+	Hosts     int    `json:"hosts"`
+}
+
+// ConfigArchiver writes tar.gz snapshots of users/clients/tasks/hosts/global
+// in the same JSON format as conf/*.json, on a schedule and on demand, and
+// prunes old ones by count and age.
+type ConfigArchiver struct {
+	mu       sync.Mutex
+	dir      string
+	interval time.Duration
+	keep     int
+	maxAge   time.Duration
+	now      func() time.Time
+	db       func() *DbUtils
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// NewConfigArchiver returns an archiver for dir. It does not start the
+// scheduler; ConfigureConfigArchiver does that when Interval is set.
+func NewConfigArchiver(dir string, options ConfigArchiveOptions) *ConfigArchiver {
+	a := &ConfigArchiver{
+		dir:      dir,
+		interval: options.Interval,
+		keep:     options.Keep,
+		maxAge:   options.MaxAge,
+		now:      time.Now,
+		db:       GetDb,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if a.keep <= 0 {
+		a.keep = DefaultConfigArchiveKeep
+	}
+	if a.maxAge < 0 {
+		a.maxAge = 0
+	}
+	return a
+}
+
+// ConfigureConfigArchiver creates the snapshot directory, starts the
+// scheduler when options.Interval is positive and makes the archiver the one
+// returned by CurrentConfigArchiver, stopping any previous one.
+func ConfigureConfigArchiver(options ConfigArchiveOptions) (*ConfigArchiver, error) {
+	archiver := NewConfigArchiver(options.ResolvePath(common.GetRunPath()), options)
+	if err := os.MkdirAll(archiver.dir, 0o755); err != nil {
+		return nil, err
+	}
+	if archiver.interval > 0 {
+		go archiver.run()
+	} else {
+		close(archiver.done)
+	}
+	if previous := currentConfigArchiver.Swap(archiver); previous != nil {
+		previous.Close()
+	}
+	return archiver, nil
+}
+
+// CurrentConfigArchiver returns the active archiver, or nil when snapshots
+// are not configured.
+func CurrentConfigArchiver() *ConfigArchiver {
+	return currentConfigArchiver.Load()
+}
+
+// ReplaceConfigArchiver swaps the active archiver and returns the previous one.
+func ReplaceConfigArchiver(archiver *ConfigArchiver) *ConfigArchiver {
+	return currentConfigArchiver.Swap(archiver)
+}
+
+func (a *ConfigArchiver) Dir() string {
+	if a == nil {
+		return ""
+	}
+	return a.dir
+}
+
+// Close stops the scheduler and waits for a running snapshot to finish.
+func (a *ConfigArchiver) Close() {
+	if a == nil {
+		return
+	}
+	a.stopOnce.Do(func() { close(a.stop) })
+	<-a.done
+}
+
+func (a *ConfigArchiver) run() {
+	defer close(a.done)
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-a.stop:
+			return
+		case <-ticker.C:
+			if info, err := a.Create("schedule"); err != nil {
+				logs.Warn("scheduled config snapshot error: %v", err)
+			} else {
+				logs.Info("config snapshot %s written", info.Name)
+			}
+		}
+	}
+}
+
+// Create writes a new snapshot archive and prunes old ones. The JsonDb files
+// are flushed and serialized inside one deferred-persistence scope, so no
+// store write lands on disk while the archive is being taken and the live
+// conf/*.json files match the archive when it completes.
+func (a *ConfigArchiver) Create(source string) (ConfigArchiveInfo, error) {
+	if a == nil {
+		return ConfigArchiveInfo{}, ErrConfigArchiveDisabled
+	}
+	db := a.db()
+	if db == nil || db.JsonDb == nil {
+		return ConfigArchiveInfo{}, errors.New("store database is not initialized")
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := os.MkdirAll(a.dir, 0o755); err != nil {
+		return ConfigArchiveInfo{}, err
+	}
+	staging, err := os.MkdirTemp(a.dir, ".staging-")
+	if err != nil {
+		return ConfigArchiveInfo{}, err
+	}
+	defer func() { _ = os.RemoveAll(staging) }()
+
+	createdAt := a.now()
+	manifest := configArchiveManifestData{CreatedAt: createdAt.Unix(), Source: strings.TrimSpace(source)}
+	if err := db.WithDeferredPersistence(func() error {
+		db.FlushToDisk()
+		return writeConfigArchiveFiles(db.JsonDb, staging, &manifest)
+	}); err != nil {
+		return ConfigArchiveInfo{}, err
+	}
+
+	name := a.archiveNameLocked(createdAt)
+	path := filepath.Join(a.dir, name)
+	if err := writeConfigArchive(path, staging, manifest); err != nil {
+		return ConfigArchiveInfo{}, err
+	}
+	if _, err := a.pruneLocked(); err != nil {
+		logs.Warn("prune config snapshots in %s error: %v", a.dir, err)
+	}
+	info := configArchiveInfoFromManifest(name, manifest)
+	if stat, err := os.Stat(path); err == nil {
+		info.Size = stat.Size()
+	}
+	return info, nil
+}
+
+func (a *ConfigArchiver) archiveNameLocked(createdAt time.Time) string {
+	base := configArchivePrefix + createdAt.UTC().Format(configArchiveTimeLayout)
+	name := base + configArchiveSuffix
+	for i := 1; common.FileExists(filepath.Join(a.dir, name)); i++ {
+		name = fmt.Sprintf("%s-%d%s", base, i, configArchiveSuffix)
+	}
+	return name
+}
+
+// List returns the retained snapshots, newest first.
+func (a *ConfigArchiver) List() ([]ConfigArchiveInfo, error) {
+	if a == nil {
+		return nil, ErrConfigArchiveDisabled
+	}
+	return listConfigArchives(a.dir)
+}
+
+// Load reads the named snapshot back into a ConfigSnapshot ready for
+// ImportConfigSnapshot.
+func (a *ConfigArchiver) Load(name string) (*ConfigSnapshot, ConfigArchiveInfo, error) {
+	if a == nil {
+		return nil, ConfigArchiveInfo{}, ErrConfigArchiveDisabled
+	}
+	name = strings.TrimSpace(name)
+	if !isConfigArchiveName(name) {
+		return nil, ConfigArchiveInfo{}, ErrConfigArchiveNotFound
+	}
+	return loadConfigArchive(filepath.Join(a.dir, name))
+}
+
+// Prune removes snapshots beyond the retention count or older than MaxAge.
+// The newest snapshot is always kept.
+func (a *ConfigArchiver) Prune() ([]string, error) {
+	if a == nil {
+		return nil, ErrConfigArchiveDisabled
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.pruneLocked()
+}
+
+func (a *ConfigArchiver) pruneLocked() ([]string, error) {
+	archives, err := listConfigArchives(a.dir)
+	if err != nil {
+		return nil, err
+	}
+	var removed []string
+	cutoff := int64(0)
+	if a.maxAge > 0 {
+		cutoff = a.now().Add(-a.maxAge).Unix()
+	}
+	for i, archive := range archives {
+		if i == 0 {
+			continue
+		}
+		if i < a.keep && (cutoff == 0 || archive.CreatedAt >= cutoff) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(a.dir, archive.Name)); err != nil && !os.IsNotExist(err) {
+			return removed, err
+		}
+		removed = append(removed, archive.Name)
+	}
+	return removed, nil
+}
+
+func isConfigArchiveName(name string) bool {
+	return strings.HasPrefix(name, configArchivePrefix) &&
+		strings.HasSuffix(name, configArchiveSuffix) &&
+		filepath.Base(name) == name
+}
+
+func writeConfigArchiveFiles(db *JsonDb, dir string, manifest *configArchiveManifestData) error {
+	manifest.Users = countPersistableRecords(&db.Users)
+	manifest.Clients = countPersistableRecords(&db.Clients)
+	manifest.Tunnels = countPersistableRecords(&db.Tasks)
+	manifest.Hosts = countPersistableRecords(&db.Hosts)
+	if err := writeSyncMapToFile(&db.Users, filepath.Join(dir, configArchiveUsersFile)); err != nil {
+		return err
+	}
+	if err := writeSyncMapToFile(&db.Clients, filepath.Join(dir, configArchiveClientsFile)); err != nil {
+		return err
+	}
+	if err := writeSyncMapToFile(&db.Tasks, filepath.Join(dir, configArchiveTasksFile)); err != nil {
+		return err
+	}
+	if err := writeSyncMapToFile(&db.Hosts, filepath.Join(dir, configArchiveHostsFile)); err != nil {
+		return err
+	}
+	return writeGlobalToFile(db.Global, filepath.Join(dir, configArchiveGlobalFile))
+}
+
+func countPersistableRecords(m *sync.Map) int {
+	count := 0
+	m.Range(func(_, value interface{}) bool {
+		if isPersistableRecord(value) {
+			count++
+		}
+		return true
+	})
+	return count
+}
+
+// writeConfigArchive packs the staged JSON files behind a manifest entry and
+// renames the result into place, so a listed archive is always complete.
+func writeConfigArchive(path, staging string, manifest configArchiveManifestData) (err error) {
+	tmpPath := path + ".tmp"
+	out, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	cleanupTmp := true
+	defer func() {
+		_ = out.Close()
+		if cleanupTmp {
+			_ = os.Remove(tmpPath)
+		}
+	}()
+
+	gz := gzip.NewWriter(out)
+	tw := tar.NewWriter(gz)
+	modTime := time.Unix(manifest.CreatedAt, 0)
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	if err := writeConfigArchiveEntry(tw, configArchiveManifest, data, modTime); err != nil {
+		return err
+	}
+	for _, name := range []string{configArchiveUsersFile, configArchiveClientsFile, configArchiveTasksFile, configArchiveHostsFile, configArchiveGlobalFile} {
+		data, err := os.ReadFile(filepath.Join(staging, name))
+		if err != nil {
+			return err
+		}
+		if err := writeConfigArchiveEntry(tw, name, data, modTime); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	cleanupTmp = false
+	return nil
+}
+
+func writeConfigArchiveEntry(tw *tar.Writer, name string, data []byte, modTime time.Time) error {
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o600, Size: int64(len(data)), ModTime: modTime}); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+func listConfigArchives(dir string) ([]ConfigArchiveInfo, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	archives := make([]ConfigArchiveInfo, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || !isConfigArchiveName(entry.Name()) {
+			continue
+		}
+		info, err := readConfigArchiveInfo(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			logs.Warn("skip unreadable config snapshot %s: %v", entry.Name(), err)
+			continue
+		}
+		archives = append(archives, info)
+	}
+	sort.Slice(archives, func(i, j int) bool {
+		if archives[i].CreatedAt != archives[j].CreatedAt {
+			return archives[i].CreatedAt > archives[j].CreatedAt
+		}
+		return archives[i].Name > archives[j].Name
+	})
+	return archives, nil
+}
+
+func readConfigArchiveInfo(path string) (ConfigArchiveInfo, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return ConfigArchiveInfo{}, err
+	}
+	var manifest configArchiveManifestData
+	found := false
+	if err := readConfigArchiveEntries(path, func(name string, data []byte) (bool, error) {
+		if name != configArchiveManifest {
+			return true, nil
+		}
+		found = true
+		return false, json.Unmarshal(data, &manifest)
+	}); err != nil {
+		return ConfigArchiveInfo{}, err
+	}
+	if !found {
+		return ConfigArchiveInfo{}, errors.New("archive has no manifest")
+	}
+	info := configArchiveInfoFromManifest(filepath.Base(path), manifest)
+	info.Size = stat.Size()
+	return info, nil
+}
+
+func loadConfigArchive(path string) (*ConfigSnapshot, ConfigArchiveInfo, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ConfigArchiveInfo{}, ErrConfigArchiveNotFound
+		}
+		return nil, ConfigArchiveInfo{}, err
+	}
+	snapshot := &ConfigSnapshot{Global: &Glob{}}
+	var manifest configArchiveManifestData
+	seen := make(map[string]bool)
+	err = readConfigArchiveEntries(path, func(name string, data []byte) (bool, error) {
+		seen[name] = true
+		switch name {
+		case configArchiveManifest:
+			return true, json.Unmarshal(data, &manifest)
+		case configArchiveUsersFile:
+			return true, loadJsonFile(data, User{}, func(v interface{}) { snapshot.Users = append(snapshot.Users, v.(*User)) })
+		case configArchiveClientsFile:
+			return true, loadJsonFile(data, Client{}, func(v interface{}) { snapshot.Clients = append(snapshot.Clients, v.(*Client)) })
+		case configArchiveTasksFile:
+			return true, loadJsonFile(data, Tunnel{}, func(v interface{}) { snapshot.Tunnels = append(snapshot.Tunnels, v.(*Tunnel)) })
+		case configArchiveHostsFile:
+			return true, loadJsonFile(data, Host{}, func(v interface{}) { snapshot.Hosts = append(snapshot.Hosts, v.(*Host)) })
+		case configArchiveGlobalFile:
+			if len(data) == 0 || string(data) == "null" {
+				return true, nil
+			}
+			return true, json.Unmarshal(data, snapshot.Global)
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, ConfigArchiveInfo{}, err
+	}
+	for _, name := range []string{configArchiveManifest, configArchiveUsersFile, configArchiveClientsFile, configArchiveTasksFile, configArchiveHostsFile, configArchiveGlobalFile} {
+		if !seen[name] {
+			return nil, ConfigArchiveInfo{}, fmt.Errorf("archive is missing %s", name)
+		}
+	}
+	info := configArchiveInfoFromManifest(filepath.Base(path), manifest)
+	info.Size = stat.Size()
+	return snapshot, info, nil
+}
+
+// readConfigArchiveEntries calls fn for each regular file in the archive
+// until fn returns false or an error.
+func readConfigArchiveEntries(path string, fn func(name string, data []byte) (bool, error)) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+	gz, err := gzip.NewReader(in)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = gz.Close() }()
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return err
+		}
+		more, err := fn(header.Name, data)
+		if err != nil {
+			return fmt.Errorf("read %s: %w", header.Name, err)
+		}
+		if !more {
+			return nil
+		}
+	}
+}
+
+func configArchiveInfoFromManifest(name string, manifest configArchiveManifestData) ConfigArchiveInfo {
+	return ConfigArchiveInfo{
+		Name:      name,
+		CreatedAt: manifest.CreatedAt,
+		Source:    manifest.Source,
+		Users:     manifest.Users,
+		Clients:   manifest.Clients,
+		Tunnels:   manifest.Tunnels,
+		Hosts:     manifest.Hosts,
+	}
+}
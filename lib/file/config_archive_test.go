@@ -0,0 +1,120 @@
+package file
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestConfigArchiver(t *testing.T, options ConfigArchiveOptions, clock *stepClock) *ConfigArchiver {
+	t.Helper()
+	a := NewConfigArchiver(filepath.Join(t.TempDir(), "snapshots"), options)
+	a.now = clock.Now
+	close(a.done)
+	return a
+}
+
+func TestConfigArchiverCreatesRestorableSnapshot(t *testing.T) {
+	root := resetStoreTestDB(t)
+	clock := &stepClock{now: time.Unix(1_700_000_000, 0)}
+	db := GetDb()
+	if err := db.NewUser(&User{Id: 1, Username: "tenant", Password: "secret", Status: 1, TotalFlow: &Flow{}}); err != nil {
+		t.Fatalf("NewUser() error = %v", err)
+	}
+	if err := db.NewClient(&Client{Id: 2, OwnerUserID: 1, VerifyKey: "vk", Remark: "first", Status: true, Cnf: &Config{}, Flow: &Flow{}}); err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	if err := db.SaveGlobal(&Glob{EntryAclMode: AclBlacklist, EntryAclRules: "10.0.0.1"}); err != nil {
+		t.Fatalf("SaveGlobal() error = %v", err)
+	}
+	a := newTestConfigArchiver(t, ConfigArchiveOptions{}, clock)
+
+	info, err := a.Create("manual")
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if info.Users != 1 || info.Clients != 1 || info.Source != "manual" || info.Size == 0 {
+		t.Fatalf("Create() info = %+v, want one user and client from manual", info)
+	}
+	if _, err := os.Stat(filepath.Join(root, "conf", "clients.json")); err != nil {
+		t.Fatalf("Create() should flush the live JSON files: %v", err)
+	}
+
+	client, _ := db.GetClient(2)
+	client.Remark = "changed"
+	if err := db.UpdateClient(client); err != nil {
+		t.Fatalf("UpdateClient() error = %v", err)
+	}
+
+	archives, err := a.List()
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(archives) != 1 || archives[0].Name != info.Name || archives[0].Clients != 1 {
+		t.Fatalf("List() = %+v, want the created snapshot", archives)
+	}
+	snapshot, loaded, err := a.Load(info.Name)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if loaded.CreatedAt != info.CreatedAt {
+		t.Fatalf("Load() info = %+v, want %+v", loaded, info)
+	}
+	if err := NewLocalStore().ImportConfigSnapshot(snapshot); err != nil {
+		t.Fatalf("ImportConfigSnapshot() error = %v", err)
+	}
+	restored, err := db.GetClient(2)
+	if err != nil || restored.Remark != "first" {
+		t.Fatalf("restored client = %+v, %v, want remark first", restored, err)
+	}
+	if got := db.GetGlobal(); got == nil || got.EntryAclRules != "10.0.0.1" {
+		t.Fatalf("restored global = %+v, want saved rules", got)
+	}
+
+	if _, _, err := a.Load("../clients.json"); !errors.Is(err, ErrConfigArchiveNotFound) {
+		t.Fatalf("Load(traversal) error = %v, want ErrConfigArchiveNotFound", err)
+	}
+}
+
+func TestConfigArchiverPrunesByCountAndAge(t *testing.T) {
+	resetStoreTestDB(t)
+	clock := &stepClock{now: time.Unix(1_700_000_000, 0)}
+	a := newTestConfigArchiver(t, ConfigArchiveOptions{Keep: 3}, clock)
+
+	var names []string
+	for i := 0; i < 5; i++ {
+		info, err := a.Create("schedule")
+		if err != nil {
+			t.Fatalf("Create(%d) error = %v", i, err)
+		}
+		names = append(names, info.Name)
+	}
+	archives, err := a.List()
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(archives) != 3 || archives[0].Name != names[4] || archives[2].Name != names[2] {
+		t.Fatalf("List() = %+v, want the three newest", archives)
+	}
+
+	a.maxAge = time.Hour
+	clock.now = clock.now.Add(2 * time.Hour)
+	latest, err := a.Create("schedule")
+	if err != nil {
+		t.Fatalf("Create(late) error = %v", err)
+	}
+	archives, _ = a.List()
+	if len(archives) != 1 || archives[0].Name != latest.Name {
+		t.Fatalf("List() after age prune = %+v, want only %s", archives, latest.Name)
+	}
+
+	clock.now = clock.now.Add(48 * time.Hour)
+	if _, err := a.Prune(); err != nil {
+		t.Fatalf("Prune() error = %v", err)
+	}
+	if archives, _ = a.List(); len(archives) != 1 {
+		t.Fatalf("Prune() should keep the newest snapshot, got %+v", archives)
+	}
+}
@@ -8,6 +8,7 @@ import (
 
 	"github.com/djylb/nps/lib/common"
 	"github.com/djylb/nps/lib/index"
+	"github.com/djylb/nps/lib/logs"
 )
 
 type DbUtils struct {
@@ -198,7 +199,10 @@ func GetDb() *DbUtils {
 	dbMu.Lock()
 	defer dbMu.Unlock()
 	once.Do(func() {
-		jsonDb := NewJsonDb(common.GetRunPath())
+		jsonDb, err := NewJsonDbWithPersistence(common.GetRunPath(), CurrentPersistenceOptions())
+		if err != nil {
+			logs.Error("configure persistence backend error: %v, falling back to json files", err)
+		}
 		jsonDb.LoadUsers()
 		jsonDb.LoadClients()
 		jsonDb.LoadTasks()
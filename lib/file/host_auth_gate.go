@@ -0,0 +1,46 @@
+package file
+
+import (
+	"slices"
+	"strings"
+)
+
+// AuthGateEmailList parses a comma or newline separated list of allowed
+// emails and email domains ("alice@example.com", "@example.com").
+func AuthGateEmailList(value string) []string {
+	return authGateList(strings.ToLower(value))
+}
+
+// AuthGateGroupList parses a comma or newline separated list of allowed
+// groups. Groups keep their case.
+func AuthGateGroupList(value string) []string {
+	return authGateList(value)
+}
+
+// NormalizeAuthGateEmails returns the stored form of an email allow list.
+func NormalizeAuthGateEmails(value string) string {
+	return strings.Join(AuthGateEmailList(value), ",")
+}
+
+// NormalizeAuthGateGroups returns the stored form of a group allow list.
+func NormalizeAuthGateGroups(value string) string {
+	return strings.Join(AuthGateGroupList(value), ",")
+}
+
+func authGateList(value string) []string {
+	fields := strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == '\n' || r == '\r' || r == ' ' || r == '\t'
+	})
+	list := make([]string, 0, len(fields))
+	for _, field := range fields {
+		if !slices.Contains(list, field) {
+			list = append(list, field)
+		}
+	}
+	return list
+}
+
+func (h *Host) normalizeAuthGate() {
+	h.AuthGateEmails = NormalizeAuthGateEmails(h.AuthGateEmails)
+	h.AuthGateGroups = NormalizeAuthGateGroups(h.AuthGateGroups)
+}
@@ -0,0 +1,42 @@
+package file
+
+import (
+	"github.com/djylb/nps/lib/bodyrewrite"
+)
+
+// ReqBodyRuleSet returns the compiled request body rewrite rules of the host.
+func (h *Host) ReqBodyRuleSet() *bodyrewrite.RuleSet {
+	if h == nil {
+		return nil
+	}
+	h.RLock()
+	compiled, raw := h.reqBodyRules, h.ReqBodyRewrite
+	h.RUnlock()
+	if compiled != nil && compiled.Source() == raw {
+		return compiled
+	}
+	compiled = bodyrewrite.Compile(raw)
+	h.Lock()
+	h.reqBodyRules = compiled
+	h.Unlock()
+	return compiled
+}
+
+// RespBodyRuleSet returns the compiled response body rewrite rules of the
+// host.
+func (h *Host) RespBodyRuleSet() *bodyrewrite.RuleSet {
+	if h == nil {
+		return nil
+	}
+	h.RLock()
+	compiled, raw := h.respBodyRules, h.RespBodyRewrite
+	h.RUnlock()
+	if compiled != nil && compiled.Source() == raw {
+		return compiled
+	}
+	compiled = bodyrewrite.Compile(raw)
+	h.Lock()
+	h.respBodyRules = compiled
+	h.Unlock()
+	return compiled
+}
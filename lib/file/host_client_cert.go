@@ -0,0 +1,139 @@
+package file
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/djylb/nps/lib/common"
+)
+
+// Client certificate modes of a host. An empty mode does not ask clients
+// for a certificate.
+const (
+	ClientCertOptional = "optional"
+	ClientCertRequire  = "require"
+)
+
+// ErrInvalidClientCert is returned for unknown modes and CA bundles without
+// a usable certificate.
+var ErrInvalidClientCert = errors.New("invalid client certificate settings")
+
+type clientCertPool struct {
+	source string
+	pool   *x509.CertPool
+	err    error
+}
+
+// NormalizeClientCertMode returns the stored form of a client certificate
+// mode.
+func NormalizeClientCertMode(mode string) (string, error) {
+	switch mode = strings.ToLower(strings.TrimSpace(mode)); mode {
+	case "", "off", "none", "false":
+		return "", nil
+	case ClientCertOptional, ClientCertRequire:
+		return mode, nil
+	case "true", "on":
+		return ClientCertRequire, nil
+	}
+	return "", fmt.Errorf("%w: unknown mode %q", ErrInvalidClientCert, mode)
+}
+
+// ValidateClientCert checks the mode and CA bundle of a host. A mode needs a
+// CA bundle holding at least one certificate.
+func ValidateClientCert(mode, ca string) (string, error) {
+	mode, err := NormalizeClientCertMode(mode)
+	if err != nil {
+		return "", err
+	}
+	if mode == "" && strings.TrimSpace(ca) == "" {
+		return "", nil
+	}
+	if _, err := ParseClientCertCA(ca); err != nil {
+		return "", err
+	}
+	return mode, nil
+}
+
+// ParseClientCertCA parses a PEM bundle of CA certificates, given inline or
+// as a path like cert_file.
+func ParseClientCertCA(ca string) (*x509.CertPool, error) {
+	content, err := common.GetCertContent(strings.TrimSpace(ca), "CERTIFICATE")
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidClientCert, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM([]byte(content)) {
+		return nil, fmt.Errorf("%w: CA bundle holds no PEM certificate", ErrInvalidClientCert)
+	}
+	return pool, nil
+}
+
+// ClientCertPool returns the CA pool of the host. A bundle that no longer
+// loads yields an empty pool, so no client certificate is accepted.
+func (h *Host) ClientCertPool() *x509.CertPool {
+	if h == nil {
+		return nil
+	}
+	h.RLock()
+	cached, raw := h.clientCertPool, h.ClientCertCA
+	h.RUnlock()
+	if cached == nil || cached.source != raw {
+		cached = &clientCertPool{source: raw}
+		if cached.pool, cached.err = ParseClientCertCA(raw); cached.err != nil {
+			cached.pool = x509.NewCertPool()
+		}
+		h.Lock()
+		h.clientCertPool = cached
+		h.Unlock()
+	}
+	return cached.pool
+}
+
+// ClientCertFingerprint returns the hex SHA-256 of the DER certificate.
+func ClientCertFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// MatchClientCertSubject looks cert up in a subject list. Each line has the
+// form "name" or "name=user" like multi_account, where name is the common
+// name, a DNS or email SAN, or the SHA-256 fingerprint of the certificate.
+// The user defaults to the matched name. An empty list allows every
+// certificate under the common name.
+func MatchClientCertSubject(cert *x509.Certificate, subjects string) (string, bool) {
+	if cert == nil {
+		return "", false
+	}
+	list := parseMultiAccountContent(subjects)
+	if len(list) == 0 {
+		return cert.Subject.CommonName, true
+	}
+	names := []string{cert.Subject.CommonName, ClientCertFingerprint(cert)}
+	names = append(names, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+		for key, user := range list {
+			if !strings.EqualFold(key, name) {
+				continue
+			}
+			if user == "" {
+				user = name
+			}
+			return user, true
+		}
+	}
+	return "", false
+}
+
+func (h *Host) normalizeClientCert() {
+	if mode, err := NormalizeClientCertMode(h.ClientCertMode); err == nil {
+		h.ClientCertMode = mode
+	}
+}
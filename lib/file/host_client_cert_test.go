@@ -0,0 +1,93 @@
+package file
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func newClientCertTestBundle(t *testing.T) string {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test Device CA"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create CA: %v", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+func TestValidateClientCert(t *testing.T) {
+	bundle := newClientCertTestBundle(t)
+	for _, tc := range []struct {
+		mode, ca, want string
+		wantErr        bool
+	}{
+		{mode: "", ca: "", want: ""},
+		{mode: "off", ca: "", want: ""},
+		{mode: "Require", ca: bundle, want: ClientCertRequire},
+		{mode: "optional", ca: bundle, want: ClientCertOptional},
+		{mode: "require", ca: "", wantErr: true},
+		{mode: "require", ca: "-----BEGIN CERTIFICATE-----\nbroken\n-----END CERTIFICATE-----", wantErr: true},
+		{mode: "strict", ca: bundle, wantErr: true},
+	} {
+		got, err := ValidateClientCert(tc.mode, tc.ca)
+		if tc.wantErr {
+			if !errors.Is(err, ErrInvalidClientCert) {
+				t.Fatalf("ValidateClientCert(%q) error = %v, want ErrInvalidClientCert", tc.mode, err)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Fatalf("ValidateClientCert(%q) = %q, %v, want %q", tc.mode, got, err, tc.want)
+		}
+	}
+
+	host := &Host{ClientCertCA: "missing-ca.pem"}
+	if pool := host.ClientCertPool(); pool == nil || !pool.Equal(x509.NewCertPool()) {
+		t.Fatal("ClientCertPool() of a missing bundle should be empty")
+	}
+}
+
+func TestMatchClientCertSubject(t *testing.T) {
+	cert := &x509.Certificate{
+		Raw:            []byte("der"),
+		Subject:        pkix.Name{CommonName: "laptop-1"},
+		DNSNames:       []string{"laptop-1.corp.example.com"},
+		EmailAddresses: []string{"alice@example.com"},
+	}
+	for _, tc := range []struct {
+		subjects, user string
+		ok             bool
+	}{
+		{subjects: "", user: "laptop-1", ok: true},
+		{subjects: "# devices\nLAPTOP-1", user: "laptop-1", ok: true},
+		{subjects: "alice@example.com=alice", user: "alice", ok: true},
+		{subjects: "laptop-1.corp.example.com = ops", user: "ops", ok: true},
+		{subjects: ClientCertFingerprint(cert) + "=pinned", user: "pinned", ok: true},
+		{subjects: "laptop-2\nbob@example.com=bob", ok: false},
+	} {
+		user, ok := MatchClientCertSubject(cert, tc.subjects)
+		if ok != tc.ok || user != tc.user {
+			t.Fatalf("MatchClientCertSubject(%q) = %q, %v, want %q, %v", tc.subjects, user, ok, tc.user, tc.ok)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package file
+
+import (
+	"slices"
+	"strings"
+)
+
+// DefaultRespCompressMin is the smallest response compressed when a host
+// does not set RespCompressMin.
+const DefaultRespCompressMin = 1024
+
+// DefaultRespCompressTypes are compressed when a host does not set
+// RespCompressTypes.
+var DefaultRespCompressTypes = []string{
+	"text/*",
+	"application/javascript",
+	"application/json",
+	"application/ld+json",
+	"application/manifest+json",
+	"application/wasm",
+	"application/xml",
+	"application/xhtml+xml",
+	"application/rss+xml",
+	"application/atom+xml",
+	"image/svg+xml",
+}
+
+// RespCompressTypeList parses a comma or newline separated list of media
+// types such as "text/*,application/json". An empty list means the defaults.
+func RespCompressTypeList(value string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(value), func(r rune) bool {
+		return r == ',' || r == '\n' || r == '\r' || r == ' ' || r == '\t'
+	})
+	if len(fields) == 0 {
+		return DefaultRespCompressTypes
+	}
+	types := make([]string, 0, len(fields))
+	for _, field := range fields {
+		if !slices.Contains(types, field) {
+			types = append(types, field)
+		}
+	}
+	return types
+}
+
+// NormalizeRespCompressTypes returns the stored form of a media type list.
+func NormalizeRespCompressTypes(value string) string {
+	if strings.TrimSpace(value) == "" {
+		return ""
+	}
+	return strings.Join(RespCompressTypeList(value), ",")
+}
+
+func (h *Host) EffectiveRespCompressMin() int {
+	if h == nil || h.RespCompressMin <= 0 {
+		return DefaultRespCompressMin
+	}
+	return h.RespCompressMin
+}
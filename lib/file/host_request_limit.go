@@ -0,0 +1,154 @@
+package file
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/djylb/nps/lib/rate"
+)
+
+// Request limit keys of a host. The header key is stored as
+// "header:<Name>"; an empty key counts requests per client IP. Clients pick
+// the value of the header, so a header key only limits anything when a
+// trusted upstream sets or checks it; otherwise each request can carry a
+// new value and get its own bucket.
+const (
+	ReqLimitKeyIP     = "ip"
+	ReqLimitKeyPath   = "path"
+	ReqLimitKeyHeader = "header"
+)
+
+// DefaultReqLimitWindow is the window of a request limit without one, in
+// seconds.
+const DefaultReqLimitWindow = 1
+
+// NormalizeReqLimitKey returns the stored form of a request limit key.
+// Unknown keys and a header key without a name fall back to the client IP.
+func NormalizeReqLimitKey(key string) string {
+	kind, name, _ := strings.Cut(strings.TrimSpace(key), ":")
+	switch strings.ToLower(strings.TrimSpace(kind)) {
+	case ReqLimitKeyPath:
+		return ReqLimitKeyPath
+	case ReqLimitKeyHeader:
+		if name = strings.TrimSpace(name); name != "" {
+			return ReqLimitKeyHeader + ":" + http.CanonicalHeaderKey(name)
+		}
+	}
+	return ""
+}
+
+// ReqLimitPathList parses a comma or newline separated list of path
+// prefixes. Prefixes without a leading slash get one.
+func ReqLimitPathList(value string) []string {
+	fields := strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == '\n' || r == '\r' || r == ' ' || r == '\t'
+	})
+	paths := make([]string, 0, len(fields))
+	for _, field := range fields {
+		if !strings.HasPrefix(field, "/") {
+			field = "/" + field
+		}
+		if !slices.Contains(paths, field) {
+			paths = append(paths, field)
+		}
+	}
+	return paths
+}
+
+// NormalizeReqLimitPaths returns the stored form of a path prefix list.
+func NormalizeReqLimitPaths(value string) string {
+	return strings.Join(ReqLimitPathList(value), ",")
+}
+
+func (h *Host) normalizeRequestLimit() {
+	h.ReqLimit = max(h.ReqLimit, 0)
+	h.ReqLimitWindow = max(h.ReqLimitWindow, 0)
+	h.ReqLimitKey = NormalizeReqLimitKey(h.ReqLimitKey)
+	h.ReqLimitPaths = NormalizeReqLimitPaths(h.ReqLimitPaths)
+}
+
+// EffectiveReqLimitWindow is the request limit window of the host.
+func (h *Host) EffectiveReqLimitWindow() time.Duration {
+	if h == nil || h.ReqLimitWindow <= 0 {
+		return DefaultReqLimitWindow * time.Second
+	}
+	return time.Duration(h.ReqLimitWindow) * time.Second
+}
+
+// EnsureRuntimeRequestLimit applies the request limit of the host to its
+// runtime limiter.
+func (h *Host) EnsureRuntimeRequestLimit() {
+	if h == nil {
+		return
+	}
+	if h.ReqLimiter == nil {
+		h.ReqLimiter = rate.NewRequestLimiter(h.ReqLimit, h.EffectiveReqLimitWindow())
+		return
+	}
+	h.ReqLimiter.SetLimit(h.ReqLimit, h.EffectiveReqLimitWindow())
+}
+
+// RequestLimitKey returns the bucket of a request, or false when the host
+// has path prefixes and none of them matches. clientIP is used for the IP
+// key and for requests without the limit header.
+func (h *Host) RequestLimitKey(r *http.Request, clientIP string) (string, bool) {
+	if h == nil || r == nil || r.URL == nil {
+		return "", false
+	}
+	prefix := ""
+	if paths := ReqLimitPathList(h.ReqLimitPaths); len(paths) > 0 {
+		for _, candidate := range paths {
+			if strings.HasPrefix(r.URL.Path, candidate) && len(candidate) > len(prefix) {
+				prefix = candidate
+			}
+		}
+		if prefix == "" {
+			return "", false
+		}
+	}
+	kind, name, _ := strings.Cut(h.ReqLimitKey, ":")
+	switch kind {
+	case ReqLimitKeyPath:
+		if prefix == "" {
+			prefix = firstPathSegment(r.URL.Path)
+		}
+		return "path|" + prefix, true
+	case ReqLimitKeyHeader:
+		if value := strings.TrimSpace(r.Header.Get(name)); value != "" {
+			return prefix + "|header|" + value, true
+		}
+	}
+	return prefix + "|ip|" + clientIP, true
+}
+
+// AllowRequest takes a request token of the host. It returns false and the
+// time until the next token when the client is over the limit.
+func (h *Host) AllowRequest(r *http.Request, clientIP string, now time.Time) (bool, time.Duration) {
+	if h == nil || h.ReqLimit <= 0 || h.ReqLimiter == nil {
+		return true, 0
+	}
+	key, ok := h.RequestLimitKey(r, clientIP)
+	if !ok {
+		return true, 0
+	}
+	return h.ReqLimiter.Allow(key, now)
+}
+
+// RequestsLimited returns how many requests the host refused for being
+// over its request limit.
+func (h *Host) RequestsLimited() int64 {
+	if h == nil {
+		return 0
+	}
+	return h.ReqLimiter.Rejected()
+}
+
+func firstPathSegment(path string) string {
+	rest := strings.TrimPrefix(path, "/")
+	if index := strings.IndexByte(rest, '/'); index >= 0 {
+		return "/" + rest[:index]
+	}
+	return "/" + rest
+}
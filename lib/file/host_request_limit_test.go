@@ -0,0 +1,64 @@
+package file
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNormalizeRequestLimitFields(t *testing.T) {
+	host := &Host{ReqLimit: -1, ReqLimitWindow: -5, ReqLimitKey: " Header: x-api-key ", ReqLimitPaths: "api, /login\n/api"}
+	host.normalizeRequestLimit()
+	if host.ReqLimit != 0 || host.ReqLimitWindow != 0 || host.ReqLimitKey != "header:X-Api-Key" || host.ReqLimitPaths != "/api,/login" {
+		t.Fatalf("normalized = %d %d %q %q", host.ReqLimit, host.ReqLimitWindow, host.ReqLimitKey, host.ReqLimitPaths)
+	}
+	for key, want := range map[string]string{"": "", "ip": "", "PATH": "path", "header:": "", "cookie": ""} {
+		if got := NormalizeReqLimitKey(key); got != want {
+			t.Fatalf("NormalizeReqLimitKey(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestHostRequestLimitKeys(t *testing.T) {
+	tests := []struct {
+		name   string
+		host   *Host
+		path   string
+		apiKey string
+		key    string
+		ok     bool
+	}{
+		{name: "ip", host: &Host{}, path: "/a", key: "|ip|203.0.113.1", ok: true},
+		{name: "path segment", host: &Host{ReqLimitKey: "path"}, path: "/api/users", key: "path|/api", ok: true},
+		{name: "path prefix", host: &Host{ReqLimitKey: "path", ReqLimitPaths: "/api,/api/v2"}, path: "/api/v2/x", key: "path|/api/v2", ok: true},
+		{name: "unmatched prefix", host: &Host{ReqLimitPaths: "/login"}, path: "/static/app.js"},
+		{name: "header", host: &Host{ReqLimitKey: "header:X-Api-Key"}, path: "/", apiKey: "k1", key: "|header|k1", ok: true},
+		{name: "header fallback", host: &Host{ReqLimitKey: "header:X-Api-Key", ReqLimitPaths: "/login"}, path: "/login", key: "/login|ip|203.0.113.1", ok: true},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "http://example.com"+tt.path, nil)
+		if tt.apiKey != "" {
+			req.Header.Set("X-Api-Key", tt.apiKey)
+		}
+		key, ok := tt.host.RequestLimitKey(req, "203.0.113.1")
+		if key != tt.key || ok != tt.ok {
+			t.Fatalf("%s: RequestLimitKey() = %q, %v; want %q, %v", tt.name, key, ok, tt.key, tt.ok)
+		}
+	}
+}
+
+func TestInitializeHostRuntimeKeepsRequestLimiter(t *testing.T) {
+	host := &Host{ReqLimit: 1, ReqLimitWindow: 60}
+	InitializeHostRuntime(host)
+	now := time.Unix(1000, 0)
+	req := httptest.NewRequest("GET", "http://example.com/", nil)
+	host.AllowRequest(req, "203.0.113.1", now)
+	if ok, wait := host.AllowRequest(req, "203.0.113.1", now); ok || wait != time.Minute {
+		t.Fatalf("AllowRequest() over the limit = %v, %v; want false, 1m", ok, wait)
+	}
+	limiter := host.ReqLimiter
+	InitializeHostRuntime(host)
+	if host.ReqLimiter != limiter || host.RequestsLimited() != 1 {
+		t.Fatalf("re-initialization replaced the limiter or lost the counter: %d", host.RequestsLimited())
+	}
+}
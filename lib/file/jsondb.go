@@ -797,19 +797,8 @@ func writeSyncMapToFile(m *sync.Map, filePath string) (err error) {
 	first := true
 	var rangeErr error
 	m.Range(func(key, value interface{}) bool {
-		switch v := value.(type) {
-		case *Tunnel:
-			if v.NoStore {
-				return true
-			}
-		case *Host:
-			if v.NoStore {
-				return true
-			}
-		case *Client:
-			if v.NoStore {
-				return true
-			}
+		if !isPersistableRecord(value) {
+			return true
 		}
 
 		data, marshalErr := json.Marshal(value)
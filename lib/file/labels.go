@@ -0,0 +1,184 @@
+package file
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	MaxLabelsPerResource = 32
+	maxLabelKeyLength    = 63
+	maxLabelValueLength  = 63
+)
+
+var (
+	ErrInvalidLabel         = errors.New("invalid label")
+	ErrInvalidLabelSelector = errors.New("invalid label selector")
+)
+
+// NormalizeLabels validates labels and returns a trimmed copy, or nil when
+// there are none. Keys are letters, digits and "-", "_", ".", "/" and must
+// start and end with a letter or digit; values use the same characters
+// without "/" and may be empty.
+func NormalizeLabels(labels map[string]string) (map[string]string, error) {
+	if len(labels) == 0 {
+		return nil, nil
+	}
+	if len(labels) > MaxLabelsPerResource {
+		return nil, fmt.Errorf("%w: at most %d labels are allowed", ErrInvalidLabel, MaxLabelsPerResource)
+	}
+	normalized := make(map[string]string, len(labels))
+	for key, value := range labels {
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+		if !validLabelKey(key) {
+			return nil, fmt.Errorf("%w: key %q", ErrInvalidLabel, key)
+		}
+		if !validLabelValue(value) {
+			return nil, fmt.Errorf("%w: value %q for key %q", ErrInvalidLabel, value, key)
+		}
+		normalized[key] = value
+	}
+	return normalized, nil
+}
+
+func CloneLabels(labels map[string]string) map[string]string {
+	if len(labels) == 0 {
+		return nil
+	}
+	cloned := make(map[string]string, len(labels))
+	for key, value := range labels {
+		cloned[key] = value
+	}
+	return cloned
+}
+
+const (
+	labelRequirementEquals    = "="
+	labelRequirementNotEquals = "!="
+	labelRequirementExists    = "exists"
+	labelRequirementMissing   = "!exists"
+)
+
+type labelRequirement struct {
+	key   string
+	op    string
+	value string
+}
+
+// LabelSelector is a comma-separated list of requirements that must all hold:
+// "k=v" (or "k==v"), "k!=v", "k" for a key that is present and "!k" for one
+// that is absent. The zero value matches everything.
+type LabelSelector struct {
+	requirements []labelRequirement
+}
+
+func ParseLabelSelector(raw string) (LabelSelector, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return LabelSelector{}, nil
+	}
+	var selector LabelSelector
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		requirement, err := parseLabelRequirement(part)
+		if err != nil {
+			return LabelSelector{}, err
+		}
+		selector.requirements = append(selector.requirements, requirement)
+	}
+	return selector, nil
+}
+
+func parseLabelRequirement(part string) (labelRequirement, error) {
+	var requirement labelRequirement
+	switch {
+	case strings.Contains(part, "!="):
+		key, value, _ := strings.Cut(part, "!=")
+		requirement = labelRequirement{key: strings.TrimSpace(key), op: labelRequirementNotEquals, value: strings.TrimSpace(value)}
+	case strings.Contains(part, "=="):
+		key, value, _ := strings.Cut(part, "==")
+		requirement = labelRequirement{key: strings.TrimSpace(key), op: labelRequirementEquals, value: strings.TrimSpace(value)}
+	case strings.Contains(part, "="):
+		key, value, _ := strings.Cut(part, "=")
+		requirement = labelRequirement{key: strings.TrimSpace(key), op: labelRequirementEquals, value: strings.TrimSpace(value)}
+	case strings.HasPrefix(part, "!"):
+		requirement = labelRequirement{key: strings.TrimSpace(part[1:]), op: labelRequirementMissing}
+	default:
+		requirement = labelRequirement{key: part, op: labelRequirementExists}
+	}
+	if !validLabelKey(requirement.key) || !validLabelValue(requirement.value) {
+		return labelRequirement{}, fmt.Errorf("%w: %q", ErrInvalidLabelSelector, part)
+	}
+	return requirement, nil
+}
+
+func (s LabelSelector) Empty() bool {
+	return len(s.requirements) == 0
+}
+
+func (s LabelSelector) Matches(labels map[string]string) bool {
+	for _, requirement := range s.requirements {
+		value, ok := labels[requirement.key]
+		switch requirement.op {
+		case labelRequirementEquals:
+			if !ok || value != requirement.value {
+				return false
+			}
+		case labelRequirementNotEquals:
+			if ok && value == requirement.value {
+				return false
+			}
+		case labelRequirementExists:
+			if !ok {
+				return false
+			}
+		case labelRequirementMissing:
+			if ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (s LabelSelector) String() string {
+	parts := make([]string, 0, len(s.requirements))
+	for _, requirement := range s.requirements {
+		switch requirement.op {
+		case labelRequirementExists:
+			parts = append(parts, requirement.key)
+		case labelRequirementMissing:
+			parts = append(parts, "!"+requirement.key)
+		default:
+			parts = append(parts, requirement.key+requirement.op+requirement.value)
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+func validLabelKey(key string) bool {
+	return key != "" && len(key) <= maxLabelKeyLength && validLabelText(key, true)
+}
+
+func validLabelValue(value string) bool {
+	return value == "" || (len(value) <= maxLabelValueLength && validLabelText(value, false))
+}
+
+func validLabelText(text string, allowSlash bool) bool {
+	for i := 0; i < len(text); i++ {
+		ch := text[i]
+		alnum := ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9'
+		if alnum {
+			continue
+		}
+		if i == 0 || i == len(text)-1 {
+			return false
+		}
+		if ch != '-' && ch != '_' && ch != '.' && !(allowSlash && ch == '/') {
+			return false
+		}
+	}
+	return true
+}
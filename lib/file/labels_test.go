@@ -0,0 +1,62 @@
+package file
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestLabelSelectorMatchesAllRequirements(t *testing.T) {
+	selector, err := ParseLabelSelector(" env=staging, team!=billing ,region, !deprecated")
+	if err != nil {
+		t.Fatalf("ParseLabelSelector() error = %v", err)
+	}
+	if got := selector.String(); got != "env=staging,team!=billing,region,!deprecated" {
+		t.Fatalf("String() = %q", got)
+	}
+	cases := []struct {
+		labels map[string]string
+		want   bool
+	}{
+		{map[string]string{"env": "staging", "team": "payments", "region": "eu"}, true},
+		{map[string]string{"env": "staging", "region": ""}, true},
+		{map[string]string{"env": "prod", "region": "eu"}, false},
+		{map[string]string{"env": "staging", "team": "billing", "region": "eu"}, false},
+		{map[string]string{"env": "staging"}, false},
+		{map[string]string{"env": "staging", "region": "eu", "deprecated": "true"}, false},
+		{nil, false},
+	}
+	for _, tc := range cases {
+		if got := selector.Matches(tc.labels); got != tc.want {
+			t.Errorf("Matches(%v) = %v, want %v", tc.labels, got, tc.want)
+		}
+	}
+	if !(LabelSelector{}).Matches(nil) {
+		t.Fatal("empty selector should match everything")
+	}
+	if _, err := ParseLabelSelector("env=staging,,team"); !errors.Is(err, ErrInvalidLabelSelector) {
+		t.Fatalf("ParseLabelSelector(empty term) error = %v, want ErrInvalidLabelSelector", err)
+	}
+}
+
+func TestNormalizeLabelsTrimsAndValidates(t *testing.T) {
+	labels, err := NormalizeLabels(map[string]string{" app.kubernetes.io/name ": " web ", "tier": ""})
+	if err != nil {
+		t.Fatalf("NormalizeLabels() error = %v", err)
+	}
+	if labels["app.kubernetes.io/name"] != "web" || len(labels) != 2 {
+		t.Fatalf("NormalizeLabels() = %v", labels)
+	}
+	for _, bad := range []map[string]string{
+		{"": "x"},
+		{"-env": "x"},
+		{"env": "a b"},
+		{"env": "a/b"},
+	} {
+		if _, err := NormalizeLabels(bad); !errors.Is(err, ErrInvalidLabel) {
+			t.Errorf("NormalizeLabels(%v) error = %v, want ErrInvalidLabel", bad, err)
+		}
+	}
+	if labels, err := NormalizeLabels(map[string]string{}); err != nil || labels != nil {
+		t.Fatalf("NormalizeLabels(empty) = %v, %v, want nil", labels, err)
+	}
+}
@@ -103,6 +103,7 @@ func (g *Glob) applyJSONAlias(decoded *globJSONAlias) {
 	}
 	g.EntryAclMode = decoded.EntryAclMode
 	g.EntryAclRules = decoded.EntryAclRules
+	g.WafRules = decoded.WafRules
 }
 
 func cloneLegacyBlacklistImport(entries []string) []string {
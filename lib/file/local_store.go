@@ -400,6 +400,7 @@ func cloneUserForConfig(user *User) *User {
 		Status:             user.Status,
 		ExpireAt:           user.ExpireAt,
 		FlowLimit:          user.FlowLimit,
+		QuotaPeriod:        CloneQuotaPeriod(user.QuotaPeriod),
 		TotalFlow:          cloneFlowForConfig(user.TotalFlow),
 		MaxClients:         user.MaxClients,
 		MaxTunnels:         user.MaxTunnels,
@@ -410,6 +411,8 @@ func cloneUserForConfig(user *User) *User {
 		EntryAclRules:      user.EntryAclRules,
 		DestAclMode:        user.DestAclMode,
 		DestAclRules:       user.DestAclRules,
+		WafRules:           user.WafRules,
+		Labels:             CloneLabels(user.Labels),
 		Revision:           user.Revision,
 		UpdatedAt:          user.UpdatedAt,
 		TotalTraffic:       cloneTrafficStatsForConfig(user.TotalTraffic),
@@ -438,10 +441,12 @@ func cloneClientForConfig(client *Client) *Client {
 		Addr:             client.Addr,
 		LocalAddr:        client.LocalAddr,
 		Remark:           client.Remark,
+		Labels:           CloneLabels(client.Labels),
 		Status:           client.Status,
 		IsConnect:        client.IsConnect,
 		ExpireAt:         client.ExpireAt,
 		FlowLimit:        client.FlowLimit,
+		QuotaPeriod:      CloneQuotaPeriod(client.QuotaPeriod),
 		RateLimit:        client.RateLimit,
 		Flow:             cloneFlowForConfig(client.Flow),
 		ExportFlow:       client.ExportFlow,
@@ -481,6 +486,7 @@ func cloneTunnelForConfig(tunnel *Tunnel) *Tunnel {
 		Ports:          tunnel.Ports,
 		ExpireAt:       tunnel.ExpireAt,
 		FlowLimit:      tunnel.FlowLimit,
+		QuotaPeriod:    CloneQuotaPeriod(tunnel.QuotaPeriod),
 		RateLimit:      tunnel.RateLimit,
 		Flow:           cloneFlowForConfig(tunnel.Flow),
 		ServiceTraffic: cloneTrafficStatsForConfig(tunnel.ServiceTraffic),
@@ -488,6 +494,7 @@ func cloneTunnelForConfig(tunnel *Tunnel) *Tunnel {
 		NowConn:        tunnel.NowConn,
 		Password:       tunnel.Password,
 		Remark:         tunnel.Remark,
+		Labels:         CloneLabels(tunnel.Labels),
 		TargetAddr:     tunnel.TargetAddr,
 		TargetType:     tunnel.TargetType,
 		EntryAclMode:   tunnel.EntryAclMode,
@@ -500,6 +507,7 @@ func cloneTunnelForConfig(tunnel *Tunnel) *Tunnel {
 		Socks5Proxy:    tunnel.Socks5Proxy,
 		LocalPath:      tunnel.LocalPath,
 		StripPre:       tunnel.StripPre,
+		ForwardAuth:    tunnel.ForwardAuth,
 		ReadOnly:       tunnel.ReadOnly,
 		Target:         cloneTargetForConfig(tunnel.Target),
 		UserAuth:       cloneMultiAccountForConfig(tunnel.UserAuth),
@@ -516,44 +524,73 @@ func cloneHostForConfig(host *Host) *Host {
 	host.RLock()
 	defer host.RUnlock()
 	cloned := &Host{
-		Id:               host.Id,
-		Revision:         host.Revision,
-		UpdatedAt:        host.UpdatedAt,
-		Host:             host.Host,
-		HeaderChange:     host.HeaderChange,
-		RespHeaderChange: host.RespHeaderChange,
-		HostChange:       host.HostChange,
-		Location:         host.Location,
-		PathRewrite:      host.PathRewrite,
-		Remark:           host.Remark,
-		Scheme:           host.Scheme,
-		RedirectURL:      host.RedirectURL,
-		HttpsJustProxy:   host.HttpsJustProxy,
-		TlsOffload:       host.TlsOffload,
-		AutoSSL:          host.AutoSSL,
-		CertType:         host.CertType,
-		CertHash:         host.CertHash,
-		CertFile:         host.CertFile,
-		KeyFile:          host.KeyFile,
-		NoStore:          host.NoStore,
-		IsClose:          host.IsClose,
-		AutoHttps:        host.AutoHttps,
-		AutoCORS:         host.AutoCORS,
-		CompatMode:       host.CompatMode,
-		ExpireAt:         host.ExpireAt,
-		FlowLimit:        host.FlowLimit,
-		RateLimit:        host.RateLimit,
-		Flow:             cloneFlowForConfig(host.Flow),
-		ServiceTraffic:   cloneTrafficStatsForConfig(host.ServiceTraffic),
-		MaxConn:          host.MaxConn,
-		NowConn:          host.NowConn,
-		Client:           cloneClientForConfig(host.Client),
-		EntryAclMode:     host.EntryAclMode,
-		EntryAclRules:    host.EntryAclRules,
-		TargetIsHttps:    host.TargetIsHttps,
-		Target:           cloneTargetForConfig(host.Target),
-		UserAuth:         cloneMultiAccountForConfig(host.UserAuth),
-		MultiAccount:     cloneMultiAccountForConfig(host.MultiAccount),
+		Id:                 host.Id,
+		Revision:           host.Revision,
+		UpdatedAt:          host.UpdatedAt,
+		Host:               host.Host,
+		HeaderChange:       host.HeaderChange,
+		RespHeaderChange:   host.RespHeaderChange,
+		HostChange:         host.HostChange,
+		Location:           host.Location,
+		PathRewrite:        host.PathRewrite,
+		Remark:             host.Remark,
+		Labels:             CloneLabels(host.Labels),
+		Scheme:             host.Scheme,
+		RedirectURL:        host.RedirectURL,
+		HttpsJustProxy:     host.HttpsJustProxy,
+		TlsOffload:         host.TlsOffload,
+		AutoSSL:            host.AutoSSL,
+		CertType:           host.CertType,
+		CertHash:           host.CertHash,
+		CertFile:           host.CertFile,
+		KeyFile:            host.KeyFile,
+		NoStore:            host.NoStore,
+		IsClose:            host.IsClose,
+		AutoHttps:          host.AutoHttps,
+		AutoCORS:           host.AutoCORS,
+		RespCompress:       host.RespCompress,
+		RespCompressTypes:  host.RespCompressTypes,
+		RespCompressMin:    host.RespCompressMin,
+		Cache:              host.Cache,
+		CacheTTL:           host.CacheTTL,
+		ReqLimit:           host.ReqLimit,
+		ReqLimitWindow:     host.ReqLimitWindow,
+		ReqLimitKey:        host.ReqLimitKey,
+		ReqLimitPaths:      host.ReqLimitPaths,
+		ForwardAuth:        host.ForwardAuth,
+		ForwardAuthHeaders: host.ForwardAuthHeaders,
+		TLSMinVersion:      host.TLSMinVersion,
+		TLSCipherProfile:   host.TLSCipherProfile,
+		TLSALPN:            host.TLSALPN,
+		HSTS:               host.HSTS,
+		CustomDomain:       host.CustomDomain,
+		CertID:             host.CertID,
+		AutoSSLDNS:         host.AutoSSLDNS,
+		ReqBodyRewrite:     host.ReqBodyRewrite,
+		RespBodyRewrite:    host.RespBodyRewrite,
+		ClientCertCA:       host.ClientCertCA,
+		ClientCertMode:     host.ClientCertMode,
+		ClientCertSubjects: host.ClientCertSubjects,
+		AuthGate:           host.AuthGate,
+		AuthGateEmails:     host.AuthGateEmails,
+		AuthGateGroups:     host.AuthGateGroups,
+		WafRules:           host.WafRules,
+		CompatMode:         host.CompatMode,
+		ExpireAt:           host.ExpireAt,
+		FlowLimit:          host.FlowLimit,
+		QuotaPeriod:        CloneQuotaPeriod(host.QuotaPeriod),
+		RateLimit:          host.RateLimit,
+		Flow:               cloneFlowForConfig(host.Flow),
+		ServiceTraffic:     cloneTrafficStatsForConfig(host.ServiceTraffic),
+		MaxConn:            host.MaxConn,
+		NowConn:            host.NowConn,
+		Client:             cloneClientForConfig(host.Client),
+		EntryAclMode:       host.EntryAclMode,
+		EntryAclRules:      host.EntryAclRules,
+		TargetIsHttps:      host.TargetIsHttps,
+		Target:             cloneTargetForConfig(host.Target),
+		UserAuth:           cloneMultiAccountForConfig(host.UserAuth),
+		MultiAccount:       cloneMultiAccountForConfig(host.MultiAccount),
 	}
 	cloneHealthForConfig(&cloned.Health, &host.Health)
 	return cloned
@@ -568,6 +605,7 @@ func cloneGlobForConfig(glob *Glob) *Glob {
 	return &Glob{
 		EntryAclMode:  glob.EntryAclMode,
 		EntryAclRules: glob.EntryAclRules,
+		WafRules:      glob.WafRules,
 	}
 }
 
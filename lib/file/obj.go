@@ -6,8 +6,10 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/djylb/nps/lib/bodyrewrite"
 	"github.com/djylb/nps/lib/policy"
 	"github.com/djylb/nps/lib/rate"
+	"github.com/djylb/nps/lib/waf"
 )
 
 var (
@@ -46,6 +48,7 @@ type User struct {
 	Status             int
 	ExpireAt           int64
 	FlowLimit          int64
+	QuotaPeriod        *QuotaPeriod `json:",omitempty"`
 	TotalFlow          *Flow
 	MaxClients         int
 	MaxTunnels         int
@@ -56,6 +59,8 @@ type User struct {
 	EntryAclRules      string
 	DestAclMode        int
 	DestAclRules       string
+	WafRules           string            `json:",omitempty"`
+	Labels             map[string]string `json:",omitempty"`
 	Revision           int64
 	UpdatedAt          int64
 	ExpectedRevision   int64      `json:"-"`
@@ -66,6 +71,7 @@ type User struct {
 	sourcePolicy       *policy.SourceIPPolicy
 	destIPPolicy       *policy.SourceIPPolicy
 	destPolicy         *policy.DestinationPolicy
+	wafRules           *waf.RuleSet
 	sync.RWMutex
 }
 
@@ -93,10 +99,12 @@ type Client struct {
 	Addr              string
 	LocalAddr         string
 	Remark            string
+	Labels            map[string]string `json:",omitempty"`
 	Status            bool
 	IsConnect         bool
 	ExpireAt          int64
 	FlowLimit         int64
+	QuotaPeriod       *QuotaPeriod `json:",omitempty"`
 	RateLimit         int
 	Flow              *Flow
 	ExportFlow        int64
@@ -139,6 +147,7 @@ type Tunnel struct {
 	Ports            string
 	ExpireAt         int64
 	FlowLimit        int64
+	QuotaPeriod      *QuotaPeriod `json:",omitempty"`
 	RateLimit        int
 	Flow             *Flow
 	Rate             *rate.Rate `json:"-"`
@@ -148,6 +157,7 @@ type Tunnel struct {
 	NowConn          int32
 	Password         string
 	Remark           string
+	Labels           map[string]string `json:",omitempty"`
 	TargetAddr       string
 	TargetType       string
 	EntryAclMode     int
@@ -164,6 +174,7 @@ type Tunnel struct {
 	Socks5Proxy      bool
 	LocalPath        string
 	StripPre         string
+	ForwardAuth      string
 	ReadOnly         bool
 	Target           *Target
 	UserAuth         *MultiAccount
@@ -200,11 +211,13 @@ type Host struct {
 	Location           string
 	PathRewrite        string
 	Remark             string
+	Labels             map[string]string `json:",omitempty"`
 	Scheme             string
 	RedirectURL        string
 	HttpsJustProxy     bool
 	TlsOffload         bool
 	AutoSSL            bool
+	AutoSSLDNS         string
 	CertType           string
 	CertHash           string
 	CertFile           string
@@ -213,9 +226,36 @@ type Host struct {
 	IsClose            bool
 	AutoHttps          bool
 	AutoCORS           bool
+	RespCompress       bool
+	RespCompressTypes  string
+	RespCompressMin    int
+	Cache              bool
+	CacheTTL           int
+	ReqLimit           int
+	ReqLimitWindow     int
+	ReqLimitKey        string
+	ReqLimitPaths      string
+	ForwardAuth        string
+	ForwardAuthHeaders string
+	TLSMinVersion      string
+	TLSCipherProfile   string
+	TLSALPN            string
+	HSTS               string
+	CustomDomain       bool
+	CertID             int
+	ReqBodyRewrite     string
+	RespBodyRewrite    string
+	ClientCertCA       string
+	ClientCertMode     string
+	ClientCertSubjects string
+	AuthGate           bool
+	AuthGateEmails     string
+	AuthGateGroups     string
+	ReqLimiter         *rate.RequestLimiter `json:"-"`
 	CompatMode         bool
 	ExpireAt           int64
 	FlowLimit          int64
+	QuotaPeriod        *QuotaPeriod `json:",omitempty"`
 	RateLimit          int
 	Flow               *Flow
 	Rate               *rate.Rate `json:"-"`
@@ -226,7 +266,12 @@ type Host struct {
 	Client             *Client
 	EntryAclMode       int
 	EntryAclRules      string
+	WafRules           string `json:",omitempty"`
 	entryPolicy        *policy.SourceIPPolicy
+	wafRules           *waf.RuleSet
+	clientCertPool     *clientCertPool
+	reqBodyRules       *bodyrewrite.RuleSet
+	respBodyRules      *bodyrewrite.RuleSet
 	TargetIsHttps      bool
 	Target             *Target
 	UserAuth           *MultiAccount
@@ -244,7 +289,16 @@ type Target struct {
 	TargetArr       []string
 	LocalProxy      bool
 	ProxyProtocol   int
+	Balance         string `json:",omitempty"`
+	BalanceKey      string `json:",omitempty"`
 	targetArrSource string
+	weightSource    string
+	weights         map[string]int
+	current         map[string]int
+	active          map[string]int64
+	ring            []targetRingPoint
+	ringSource      string
+	failures        map[string]*targetFailureState
 	sync.RWMutex
 }
 
@@ -257,8 +311,10 @@ type MultiAccount struct {
 type Glob struct {
 	EntryAclMode      int
 	EntryAclRules     string
+	WafRules          string `json:",omitempty"`
 	legacyBlackIPList []string
 	sourcePolicy      *policy.SourceIPPolicy
+	wafRules          *waf.RuleSet
 	sync.RWMutex
 }
 
@@ -301,6 +357,10 @@ func (s *Flow) SetOnDelta(fn func(in, out int64)) {
 	s.Unlock()
 }
 
+func (s *Flow) Snapshot() (int64, int64) {
+	return flowSnapshot(s)
+}
+
 func flowSnapshot(s *Flow) (int64, int64) {
 	if s == nil {
 		return 0, 0
@@ -342,6 +402,15 @@ func (s *TrafficStats) Reset() {
 	atomic.StoreInt64(&s.ExportBytes, 0)
 }
 
+// Drain subtracts an earlier snapshot, keeping whatever was added since.
+func (s *TrafficStats) Drain(in, out int64) {
+	if s == nil {
+		return
+	}
+	atomic.AddInt64(&s.InletBytes, -in)
+	atomic.AddInt64(&s.ExportBytes, -out)
+}
+
 func rateSnapshot(m *rate.Meter) (int64, int64, int64) {
 	if m == nil {
 		return 0, 0, 0
@@ -10,6 +10,7 @@ import (
 
 	"github.com/djylb/nps/lib/common"
 	"github.com/djylb/nps/lib/crypt"
+	"github.com/djylb/nps/lib/forwardauth"
 	"github.com/djylb/nps/lib/rate"
 )
 
@@ -20,6 +21,7 @@ func InitializeTunnelRuntime(tunnel *Tunnel) {
 	tunnel.Lock()
 	tunnel.normalizeLifecycleFieldsLocked()
 	tunnel.Unlock()
+	tunnel.Target.normalizeBalance()
 	tunnel.EnsureRuntimeTraffic()
 	tunnel.EnsureRuntimeRate()
 }
@@ -114,7 +116,7 @@ func (t *Tunnel) ObserveServiceTraffic(in, out int64) error {
 	if expireAt := t.EffectiveExpireAt(); expireAt > 0 && time.Now().Unix() >= expireAt {
 		return errors.New("Task: time limit exceeded")
 	}
-	if flowLimit := t.EffectiveFlowLimitBytes(); flowLimit > 0 && total >= flowLimit {
+	if flowLimit := t.QuotaLimitBytes(); flowLimit > 0 && total >= flowLimit {
 		return errors.New("Task: flow limit exceeded")
 	}
 	return nil
@@ -348,6 +350,7 @@ func (t *Tunnel) SnapshotForUpdate() *Tunnel {
 		Mode:          t.Mode,
 		Password:      t.Password,
 		Remark:        t.Remark,
+		Labels:        CloneLabels(t.Labels),
 		TargetType:    t.TargetType,
 		EntryAclMode:  t.EntryAclMode,
 		EntryAclRules: t.EntryAclRules,
@@ -361,6 +364,7 @@ func (t *Tunnel) SnapshotForUpdate() *Tunnel {
 		destPolicy:    t.destPolicy,
 		LocalPath:     t.LocalPath,
 		StripPre:      t.StripPre,
+		ForwardAuth:   t.ForwardAuth,
 		ReadOnly:      t.ReadOnly,
 		Target:        t.Target,
 		UserAuth:      t.UserAuth,
@@ -375,6 +379,7 @@ func (t *Tunnel) Update(other *Tunnel) {
 	t.Mode = other.Mode
 	t.Password = other.Password
 	t.Remark = other.Remark
+	t.Labels = CloneLabels(other.Labels)
 	t.TargetType = other.TargetType
 	t.EntryAclMode = other.EntryAclMode
 	t.EntryAclRules = other.EntryAclRules
@@ -388,6 +393,7 @@ func (t *Tunnel) Update(other *Tunnel) {
 	t.destPolicy = other.destPolicy
 	t.LocalPath = other.LocalPath
 	t.StripPre = other.StripPre
+	t.ForwardAuth = other.ForwardAuth
 	t.ReadOnly = other.ReadOnly
 	t.Target = other.Target
 	t.UserAuth = other.UserAuth
@@ -402,6 +408,18 @@ func InitializeHostRuntime(host *Host) {
 	host.Lock()
 	host.normalizeLifecycleFieldsLocked()
 	host.Unlock()
+	host.Target.normalizeBalance()
+	host.RespCompressTypes = NormalizeRespCompressTypes(host.RespCompressTypes)
+	host.RespCompressMin = max(host.RespCompressMin, 0)
+	host.CacheTTL = max(host.CacheTTL, 0)
+	host.normalizeRequestLimit()
+	host.EnsureRuntimeRequestLimit()
+	host.normalizeAuthGate()
+	host.ForwardAuthHeaders = forwardauth.NormalizeHeaders(host.ForwardAuthHeaders)
+	host.normalizeClientCert()
+	host.WAFRuleSet()
+	host.ReqBodyRuleSet()
+	host.RespBodyRuleSet()
 	host.EnsureRuntimeTraffic()
 	host.EnsureRuntimeRate()
 }
@@ -496,7 +514,7 @@ func (h *Host) ObserveServiceTraffic(in, out int64) error {
 	if expireAt := h.EffectiveExpireAt(); expireAt > 0 && time.Now().Unix() >= expireAt {
 		return errors.New("Host: time limit exceeded")
 	}
-	if flowLimit := h.EffectiveFlowLimitBytes(); flowLimit > 0 && total >= flowLimit {
+	if flowLimit := h.QuotaLimitBytes(); flowLimit > 0 && total >= flowLimit {
 		return errors.New("Host: flow limit exceeded")
 	}
 	return nil
@@ -629,29 +647,58 @@ func (h *Host) SnapshotForUpdate() *Host {
 		return nil
 	}
 	snapshot := &Host{
-		HeaderChange:     h.HeaderChange,
-		RespHeaderChange: h.RespHeaderChange,
-		HostChange:       h.HostChange,
-		PathRewrite:      h.PathRewrite,
-		Remark:           h.Remark,
-		RedirectURL:      h.RedirectURL,
-		HttpsJustProxy:   h.HttpsJustProxy,
-		TlsOffload:       h.TlsOffload,
-		AutoSSL:          h.AutoSSL,
-		CertType:         h.CertType,
-		CertHash:         h.CertHash,
-		CertFile:         h.CertFile,
-		KeyFile:          h.KeyFile,
-		AutoHttps:        h.AutoHttps,
-		AutoCORS:         h.AutoCORS,
-		CompatMode:       h.CompatMode,
-		EntryAclMode:     h.EntryAclMode,
-		EntryAclRules:    h.EntryAclRules,
-		entryPolicy:      h.entryPolicy,
-		TargetIsHttps:    h.TargetIsHttps,
-		Target:           h.Target,
-		UserAuth:         h.UserAuth,
-		MultiAccount:     h.MultiAccount,
+		HeaderChange:       h.HeaderChange,
+		RespHeaderChange:   h.RespHeaderChange,
+		HostChange:         h.HostChange,
+		PathRewrite:        h.PathRewrite,
+		Remark:             h.Remark,
+		Labels:             CloneLabels(h.Labels),
+		RedirectURL:        h.RedirectURL,
+		HttpsJustProxy:     h.HttpsJustProxy,
+		TlsOffload:         h.TlsOffload,
+		AutoSSL:            h.AutoSSL,
+		CertType:           h.CertType,
+		CertHash:           h.CertHash,
+		CertFile:           h.CertFile,
+		KeyFile:            h.KeyFile,
+		AutoHttps:          h.AutoHttps,
+		AutoCORS:           h.AutoCORS,
+		RespCompress:       h.RespCompress,
+		RespCompressTypes:  h.RespCompressTypes,
+		RespCompressMin:    h.RespCompressMin,
+		Cache:              h.Cache,
+		CacheTTL:           h.CacheTTL,
+		ReqLimit:           h.ReqLimit,
+		ReqLimitWindow:     h.ReqLimitWindow,
+		ReqLimitKey:        h.ReqLimitKey,
+		ReqLimitPaths:      h.ReqLimitPaths,
+		ForwardAuth:        h.ForwardAuth,
+		ForwardAuthHeaders: h.ForwardAuthHeaders,
+		TLSMinVersion:      h.TLSMinVersion,
+		TLSCipherProfile:   h.TLSCipherProfile,
+		TLSALPN:            h.TLSALPN,
+		HSTS:               h.HSTS,
+		CustomDomain:       h.CustomDomain,
+		CertID:             h.CertID,
+		AutoSSLDNS:         h.AutoSSLDNS,
+		ReqBodyRewrite:     h.ReqBodyRewrite,
+		RespBodyRewrite:    h.RespBodyRewrite,
+		ClientCertCA:       h.ClientCertCA,
+		ClientCertMode:     h.ClientCertMode,
+		ClientCertSubjects: h.ClientCertSubjects,
+		AuthGate:           h.AuthGate,
+		AuthGateEmails:     h.AuthGateEmails,
+		AuthGateGroups:     h.AuthGateGroups,
+		WafRules:           h.WafRules,
+		CompatMode:         h.CompatMode,
+		EntryAclMode:       h.EntryAclMode,
+		EntryAclRules:      h.EntryAclRules,
+		entryPolicy:        h.entryPolicy,
+		wafRules:           h.wafRules,
+		TargetIsHttps:      h.TargetIsHttps,
+		Target:             h.Target,
+		UserAuth:           h.UserAuth,
+		MultiAccount:       h.MultiAccount,
 	}
 	copyRuntimeHealth(&snapshot.Health, &h.Health)
 	return snapshot
@@ -663,6 +710,7 @@ func (h *Host) Update(other *Host) {
 	h.HostChange = other.HostChange
 	h.PathRewrite = other.PathRewrite
 	h.Remark = other.Remark
+	h.Labels = CloneLabels(other.Labels)
 	h.RedirectURL = other.RedirectURL
 	h.HttpsJustProxy = other.HttpsJustProxy
 	h.TlsOffload = other.TlsOffload
@@ -673,10 +721,38 @@ func (h *Host) Update(other *Host) {
 	h.KeyFile = other.KeyFile
 	h.AutoHttps = other.AutoHttps
 	h.AutoCORS = other.AutoCORS
+	h.RespCompress = other.RespCompress
+	h.RespCompressTypes = other.RespCompressTypes
+	h.RespCompressMin = other.RespCompressMin
+	h.Cache = other.Cache
+	h.CacheTTL = other.CacheTTL
+	h.ReqLimit = other.ReqLimit
+	h.ReqLimitWindow = other.ReqLimitWindow
+	h.ReqLimitKey = other.ReqLimitKey
+	h.ReqLimitPaths = other.ReqLimitPaths
+	h.ForwardAuth = other.ForwardAuth
+	h.ForwardAuthHeaders = other.ForwardAuthHeaders
+	h.TLSMinVersion = other.TLSMinVersion
+	h.TLSCipherProfile = other.TLSCipherProfile
+	h.TLSALPN = other.TLSALPN
+	h.HSTS = other.HSTS
+	h.CustomDomain = other.CustomDomain
+	h.CertID = other.CertID
+	h.AutoSSLDNS = other.AutoSSLDNS
+	h.ReqBodyRewrite = other.ReqBodyRewrite
+	h.RespBodyRewrite = other.RespBodyRewrite
+	h.ClientCertCA = other.ClientCertCA
+	h.ClientCertMode = other.ClientCertMode
+	h.ClientCertSubjects = other.ClientCertSubjects
+	h.AuthGate = other.AuthGate
+	h.AuthGateEmails = other.AuthGateEmails
+	h.AuthGateGroups = other.AuthGateGroups
+	h.WafRules = other.WafRules
 	h.CompatMode = other.CompatMode
 	h.EntryAclMode = other.EntryAclMode
 	h.EntryAclRules = other.EntryAclRules
 	h.entryPolicy = other.entryPolicy
+	h.wafRules = other.wafRules
 	h.TargetIsHttps = other.TargetIsHttps
 	h.Target = other.Target
 	h.UserAuth = other.UserAuth
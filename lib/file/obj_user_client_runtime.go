@@ -71,6 +71,7 @@ func InitializeUserRuntime(user *User) {
 	user.EnsureRuntimeRate()
 	user.CompileSourcePolicy()
 	user.CompileDestACL()
+	user.WAFRuleSet()
 }
 
 func (u *User) TotalTrafficTotals() (int64, int64, int64) {
@@ -98,8 +99,8 @@ func (u *User) ObserveTotalTraffic(in, out int64) error {
 	if expireAt := u.ExpireAt; expireAt > 0 && time.Now().Unix() >= expireAt {
 		return errors.New("User: time limit exceeded")
 	}
-	if u.FlowLimit > 0 {
-		if total >= u.FlowLimit {
+	if flowLimit := u.QuotaLimitBytes(); flowLimit > 0 {
+		if total >= flowLimit {
 			return errors.New("User: flow limit exceeded")
 		}
 	}
@@ -564,7 +565,7 @@ func (s *Client) observeTraffic(bridge bool, in, out int64) error {
 	if expireAt := s.EffectiveExpireAt(); expireAt > 0 && time.Now().Unix() >= expireAt {
 		return errors.New("Client: time limit exceeded")
 	}
-	if flowLimit := s.EffectiveFlowLimitBytes(); flowLimit > 0 {
+	if flowLimit := s.QuotaLimitBytes(); flowLimit > 0 {
 		other := s.BridgeTraffic
 		if bridge {
 			other = s.ServiceTraffic
@@ -0,0 +1,377 @@
+package file
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	QuotaPeriodDaily   = "daily"
+	QuotaPeriodWeekly  = "weekly"
+	QuotaPeriodMonthly = "monthly"
+)
+
+var ErrInvalidQuotaPeriod = errors.New("invalid quota period")
+
+// QuotaPeriod turns the flow limit of a user, client, tunnel or host into a
+// recurring allowance. At every period boundary the traffic counters start
+// again from zero; with Rollover the unused part of the previous allowance is
+// kept in Carry and added to the limit of the new period.
+//
+// Day is the weekday (0 is Sunday) for weekly periods and the day of the
+// month for monthly ones; months shorter than Day reset on their last day.
+// Boundaries fall on midnight in Timezone, which defaults to UTC.
+type QuotaPeriod struct {
+	Period    string
+	Day       int    `json:",omitempty"`
+	Timezone  string `json:",omitempty"`
+	Rollover  bool   `json:",omitempty"`
+	Carry     int64  `json:",omitempty"`
+	LastReset int64  `json:",omitempty"`
+}
+
+// QuotaReset describes one finished period of a resource.
+type QuotaReset struct {
+	Resource    string `json:"resource"`
+	ID          int    `json:"id"`
+	PeriodStart int64  `json:"period_start"`
+	NextReset   int64  `json:"next_reset"`
+	InBytes     int64  `json:"in_bytes"`
+	OutBytes    int64  `json:"out_bytes"`
+	LimitBytes  int64  `json:"limit_bytes"`
+	CarryBytes  int64  `json:"carry_bytes"`
+}
+
+// NormalizeQuotaPeriod validates a plan and fills in its defaults. A nil plan
+// or an empty period means no plan. Carry and LastReset are kept as given.
+func NormalizeQuotaPeriod(plan *QuotaPeriod) (*QuotaPeriod, error) {
+	if plan == nil {
+		return nil, nil
+	}
+	normalized := *plan
+	normalized.Period = strings.ToLower(strings.TrimSpace(plan.Period))
+	normalized.Timezone = strings.TrimSpace(plan.Timezone)
+	switch normalized.Period {
+	case "":
+		return nil, nil
+	case QuotaPeriodDaily:
+		normalized.Day = 0
+	case QuotaPeriodWeekly:
+		if normalized.Day < 0 || normalized.Day > 6 {
+			return nil, fmt.Errorf("%w: weekly day must be 0-6", ErrInvalidQuotaPeriod)
+		}
+	case QuotaPeriodMonthly:
+		if normalized.Day == 0 {
+			normalized.Day = 1
+		}
+		if normalized.Day < 1 || normalized.Day > 31 {
+			return nil, fmt.Errorf("%w: monthly day must be 1-31", ErrInvalidQuotaPeriod)
+		}
+	default:
+		return nil, fmt.Errorf("%w: unknown period %q", ErrInvalidQuotaPeriod, plan.Period)
+	}
+	if normalized.Timezone != "" {
+		if _, err := time.LoadLocation(normalized.Timezone); err != nil {
+			return nil, fmt.Errorf("%w: timezone %q", ErrInvalidQuotaPeriod, normalized.Timezone)
+		}
+	}
+	if !normalized.Rollover || normalized.Carry < 0 {
+		normalized.Carry = 0
+	}
+	return &normalized, nil
+}
+
+func CloneQuotaPeriod(plan *QuotaPeriod) *QuotaPeriod {
+	if plan == nil {
+		return nil
+	}
+	cloned := *plan
+	return &cloned
+}
+
+func (p *QuotaPeriod) CarryBytes() int64 {
+	if p == nil || !p.Rollover || p.Carry < 0 {
+		return 0
+	}
+	return p.Carry
+}
+
+func (p *QuotaPeriod) location() *time.Location {
+	if p == nil || p.Timezone == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(p.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// PeriodStart returns the last boundary at or before now.
+func (p *QuotaPeriod) PeriodStart(now time.Time) time.Time {
+	if p == nil {
+		return time.Time{}
+	}
+	local := now.In(p.location())
+	year, month, day := local.Date()
+	midnight := time.Date(year, month, day, 0, 0, 0, 0, local.Location())
+	switch p.Period {
+	case QuotaPeriodWeekly:
+		back := (int(local.Weekday()) - p.Day + 7) % 7
+		return midnight.AddDate(0, 0, -back)
+	case QuotaPeriodMonthly:
+		start := quotaMonthBoundary(year, month, p.Day, local.Location())
+		if start.After(local) {
+			start = quotaMonthBoundary(year, month-1, p.Day, local.Location())
+		}
+		return start
+	default:
+		return midnight
+	}
+}
+
+// NextReset returns the first boundary after the period that starts at start.
+func (p *QuotaPeriod) NextReset(start time.Time) time.Time {
+	if p == nil {
+		return time.Time{}
+	}
+	local := start.In(p.location())
+	switch p.Period {
+	case QuotaPeriodWeekly:
+		return local.AddDate(0, 0, 7)
+	case QuotaPeriodMonthly:
+		return quotaMonthBoundary(local.Year(), local.Month()+1, p.Day, local.Location())
+	default:
+		return local.AddDate(0, 0, 1)
+	}
+}
+
+// Due reports whether a boundary has passed since the last reset.
+func (p *QuotaPeriod) Due(now time.Time) bool {
+	return p != nil && p.Period != "" && p.PeriodStart(now).Unix() > p.LastReset
+}
+
+func quotaMonthBoundary(year int, month time.Month, day int, loc *time.Location) time.Time {
+	if last := time.Date(year, month+1, 0, 0, 0, 0, 0, loc).Day(); day > last {
+		day = last
+	}
+	return time.Date(year, month, day, 0, 0, 0, 0, loc)
+}
+
+// roll closes the current period. used is the traffic counted against limit,
+// which already includes the previous carry.
+func (p *QuotaPeriod) roll(now time.Time, limit, used int64) (*QuotaPeriod, QuotaReset) {
+	next := *p
+	start := p.PeriodStart(now)
+	next.LastReset = start.Unix()
+	next.Carry = 0
+	if p.Rollover && limit > 0 {
+		base := limit - p.CarryBytes()
+		next.Carry = limit - used
+		if next.Carry > base {
+			next.Carry = base
+		}
+		if next.Carry < 0 {
+			next.Carry = 0
+		}
+	}
+	return &next, QuotaReset{
+		PeriodStart: next.LastReset,
+		NextReset:   p.NextReset(start).Unix(),
+		LimitBytes:  limit,
+		CarryBytes:  next.Carry,
+	}
+}
+
+// quotaLimit adds the carried allowance to a limit that is in force.
+func quotaLimit(limit int64, plan *QuotaPeriod) int64 {
+	if limit <= 0 {
+		return limit
+	}
+	return limit + plan.CarryBytes()
+}
+
+func drainTraffic(stats *TrafficStats, flow *Flow) (int64, int64) {
+	in, out, _ := stats.Snapshot()
+	stats.Drain(in, out)
+	flowIn, flowOut := flowSnapshot(flow)
+	flow.Sub(flowIn, flowOut)
+	return in, out
+}
+
+func (u *User) QuotaLimitBytes() int64 {
+	if u == nil {
+		return 0
+	}
+	u.RLock()
+	plan := u.QuotaPeriod
+	u.RUnlock()
+	return quotaLimit(u.FlowLimit, plan)
+}
+
+// ResetQuotaPeriod starts a new period when one is due. Traffic counted while
+// the reset runs is kept for the new period.
+func (u *User) ResetQuotaPeriod(now time.Time) (QuotaReset, bool) {
+	if u == nil {
+		return QuotaReset{}, false
+	}
+	u.EnsureRuntimeTraffic()
+	u.Lock()
+	defer u.Unlock()
+	if !u.QuotaPeriod.Due(now) {
+		return QuotaReset{}, false
+	}
+	if u.TotalFlow == nil {
+		u.TotalFlow = new(Flow)
+	}
+	limit := quotaLimit(u.FlowLimit, u.QuotaPeriod)
+	in, out := drainTraffic(u.TotalTraffic, u.TotalFlow)
+	plan, reset := u.QuotaPeriod.roll(now, limit, in+out)
+	u.QuotaPeriod = plan
+	reset.Resource, reset.ID, reset.InBytes, reset.OutBytes = JournalResourceUser, u.Id, in, out
+	return reset, true
+}
+
+func (s *Client) QuotaLimitBytes() int64 {
+	if s == nil {
+		return 0
+	}
+	if s.FlowLimit <= 0 && (s.Flow == nil || s.Flow.FlowLimit <= 0) {
+		// The owner's limit applies, together with the owner's carry.
+		if owner := s.OwnerUser(); owner != nil {
+			return owner.QuotaLimitBytes()
+		}
+		return 0
+	}
+	s.RLock()
+	plan := s.QuotaPeriod
+	s.RUnlock()
+	return quotaLimit(s.EffectiveFlowLimitBytes(), plan)
+}
+
+func (s *Client) ResetQuotaPeriod(now time.Time) (QuotaReset, bool) {
+	if s == nil {
+		return QuotaReset{}, false
+	}
+	s.EnsureRuntimeTraffic()
+	limit := s.QuotaLimitBytes()
+	s.Lock()
+	defer s.Unlock()
+	if !s.QuotaPeriod.Due(now) {
+		return QuotaReset{}, false
+	}
+	bridgeIn, bridgeOut, _ := s.BridgeTraffic.Snapshot()
+	s.BridgeTraffic.Drain(bridgeIn, bridgeOut)
+	in, out := drainTraffic(s.ServiceTraffic, s.Flow)
+	in, out = in+bridgeIn, out+bridgeOut
+	plan, reset := s.QuotaPeriod.roll(now, limit, in+out)
+	s.QuotaPeriod = plan
+	reset.Resource, reset.ID, reset.InBytes, reset.OutBytes = JournalResourceClient, s.Id, in, out
+	return reset, true
+}
+
+func (t *Tunnel) QuotaLimitBytes() int64 {
+	if t == nil {
+		return 0
+	}
+	t.RLock()
+	plan := t.QuotaPeriod
+	t.RUnlock()
+	return quotaLimit(t.EffectiveFlowLimitBytes(), plan)
+}
+
+func (t *Tunnel) ResetQuotaPeriod(now time.Time) (QuotaReset, bool) {
+	if t == nil {
+		return QuotaReset{}, false
+	}
+	t.EnsureRuntimeTraffic()
+	limit := t.QuotaLimitBytes()
+	t.Lock()
+	defer t.Unlock()
+	if !t.QuotaPeriod.Due(now) {
+		return QuotaReset{}, false
+	}
+	in, out := drainTraffic(t.ServiceTraffic, t.Flow)
+	plan, reset := t.QuotaPeriod.roll(now, limit, in+out)
+	t.QuotaPeriod = plan
+	reset.Resource, reset.ID, reset.InBytes, reset.OutBytes = JournalResourceTunnel, t.Id, in, out
+	return reset, true
+}
+
+func (h *Host) QuotaLimitBytes() int64 {
+	if h == nil {
+		return 0
+	}
+	h.RLock()
+	plan := h.QuotaPeriod
+	h.RUnlock()
+	return quotaLimit(h.EffectiveFlowLimitBytes(), plan)
+}
+
+func (h *Host) ResetQuotaPeriod(now time.Time) (QuotaReset, bool) {
+	if h == nil {
+		return QuotaReset{}, false
+	}
+	h.EnsureRuntimeTraffic()
+	limit := h.QuotaLimitBytes()
+	h.Lock()
+	defer h.Unlock()
+	if !h.QuotaPeriod.Due(now) {
+		return QuotaReset{}, false
+	}
+	in, out := drainTraffic(h.ServiceTraffic, h.Flow)
+	plan, reset := h.QuotaPeriod.roll(now, limit, in+out)
+	h.QuotaPeriod = plan
+	reset.Resource, reset.ID, reset.InBytes, reset.OutBytes = JournalResourceHost, h.Id, in, out
+	return reset, true
+}
+
+// ResetDueQuotaPeriods resets every stored resource whose period has ended and
+// persists the changed tables. The live records are reset in place so traffic
+// accounted concurrently is not lost.
+func (s *DbUtils) ResetDueQuotaPeriods(now time.Time) []QuotaReset {
+	if s == nil {
+		return nil
+	}
+	var resets []QuotaReset
+	users, clients, tunnels, hosts := false, false, false, false
+	s.RangeUsers(func(user *User) bool {
+		if reset, ok := user.ResetQuotaPeriod(now); ok {
+			resets, users = append(resets, reset), true
+		}
+		return true
+	})
+	s.RangeClients(func(client *Client) bool {
+		if reset, ok := client.ResetQuotaPeriod(now); ok {
+			resets, clients = append(resets, reset), true
+		}
+		return true
+	})
+	s.RangeTasks(func(tunnel *Tunnel) bool {
+		if reset, ok := tunnel.ResetQuotaPeriod(now); ok {
+			resets, tunnels = append(resets, reset), true
+		}
+		return true
+	})
+	s.RangeHosts(func(host *Host) bool {
+		if reset, ok := host.ResetQuotaPeriod(now); ok {
+			resets, hosts = append(resets, reset), true
+		}
+		return true
+	})
+	if users {
+		s.StoreUsers()
+	}
+	if clients {
+		s.StoreClients()
+	}
+	if tunnels {
+		s.StoreTasks()
+	}
+	if hosts {
+		s.StoreHosts()
+	}
+	return resets
+}
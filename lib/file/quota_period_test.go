@@ -0,0 +1,139 @@
+package file
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestQuotaPeriodBoundaries(t *testing.T) {
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		t.Skipf("time zone data unavailable: %v", err)
+	}
+	tests := []struct {
+		name      string
+		plan      QuotaPeriod
+		now       time.Time
+		wantStart time.Time
+		wantNext  time.Time
+	}{
+		{
+			name:      "daily in zone",
+			plan:      QuotaPeriod{Period: QuotaPeriodDaily, Timezone: "Asia/Shanghai"},
+			now:       time.Date(2026, 5, 10, 17, 30, 0, 0, time.UTC),
+			wantStart: time.Date(2026, 5, 11, 0, 0, 0, 0, shanghai),
+			wantNext:  time.Date(2026, 5, 12, 0, 0, 0, 0, shanghai),
+		},
+		{
+			name:      "weekly on monday",
+			plan:      QuotaPeriod{Period: QuotaPeriodWeekly, Day: 1},
+			now:       time.Date(2026, 5, 10, 12, 0, 0, 0, time.UTC),
+			wantStart: time.Date(2026, 5, 4, 0, 0, 0, 0, time.UTC),
+			wantNext:  time.Date(2026, 5, 11, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:      "monthly clamps to short month",
+			plan:      QuotaPeriod{Period: QuotaPeriodMonthly, Day: 31},
+			now:       time.Date(2026, 3, 15, 0, 0, 0, 0, time.UTC),
+			wantStart: time.Date(2026, 2, 28, 0, 0, 0, 0, time.UTC),
+			wantNext:  time.Date(2026, 3, 31, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:      "monthly before the day rolls back a year",
+			plan:      QuotaPeriod{Period: QuotaPeriodMonthly, Day: 15},
+			now:       time.Date(2026, 1, 3, 0, 0, 0, 0, time.UTC),
+			wantStart: time.Date(2025, 12, 15, 0, 0, 0, 0, time.UTC),
+			wantNext:  time.Date(2026, 1, 15, 0, 0, 0, 0, time.UTC),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := tt.plan.PeriodStart(tt.now)
+			if !start.Equal(tt.wantStart) {
+				t.Fatalf("PeriodStart() = %v, want %v", start, tt.wantStart)
+			}
+			if next := tt.plan.NextReset(start); !next.Equal(tt.wantNext) {
+				t.Fatalf("NextReset() = %v, want %v", next, tt.wantNext)
+			}
+		})
+	}
+
+	for _, plan := range []QuotaPeriod{
+		{Period: "yearly"},
+		{Period: QuotaPeriodWeekly, Day: 7},
+		{Period: QuotaPeriodMonthly, Day: 32},
+		{Period: QuotaPeriodDaily, Timezone: "Mars/Olympus"},
+	} {
+		if _, err := NormalizeQuotaPeriod(&plan); !errors.Is(err, ErrInvalidQuotaPeriod) {
+			t.Fatalf("NormalizeQuotaPeriod(%+v) error = %v, want ErrInvalidQuotaPeriod", plan, err)
+		}
+	}
+	if plan, err := NormalizeQuotaPeriod(&QuotaPeriod{Period: " Monthly "}); err != nil || plan.Period != QuotaPeriodMonthly || plan.Day != 1 {
+		t.Fatalf("NormalizeQuotaPeriod(monthly) = %+v, %v, want day 1", plan, err)
+	}
+}
+
+func TestResetDueQuotaPeriodsDrainsTrafficAndRollsOver(t *testing.T) {
+	resetStoreTestDB(t)
+	db := GetDb()
+	now := time.Date(2026, 6, 1, 0, 0, 30, 0, time.UTC)
+	lastPeriod := time.Date(2026, 5, 1, 0, 0, 0, 0, time.UTC).Unix()
+	if err := db.NewClient(&Client{
+		Id: 2, VerifyKey: "vk", Status: true, Cnf: &Config{}, Flow: &Flow{}, FlowLimit: 1000,
+		QuotaPeriod: &QuotaPeriod{Period: QuotaPeriodMonthly, Day: 1, Rollover: true, LastReset: lastPeriod},
+	}); err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	client, _ := db.GetClient(2)
+	if err := client.ObserveServiceTraffic(300, 100); err != nil {
+		t.Fatalf("ObserveServiceTraffic() error = %v", err)
+	}
+
+	resets := db.ResetDueQuotaPeriods(now)
+	if len(resets) != 1 {
+		t.Fatalf("ResetDueQuotaPeriods() = %+v, want one reset", resets)
+	}
+	reset := resets[0]
+	if reset.Resource != JournalResourceClient || reset.ID != 2 || reset.InBytes != 300 || reset.OutBytes != 100 ||
+		reset.CarryBytes != 600 || reset.PeriodStart != now.Truncate(time.Hour).Unix() ||
+		reset.NextReset != time.Date(2026, 7, 1, 0, 0, 0, 0, time.UTC).Unix() {
+		t.Fatalf("reset = %+v", reset)
+	}
+	if _, _, total := client.TotalTrafficTotals(); total != 0 {
+		t.Fatalf("traffic after reset = %d, want 0", total)
+	}
+	if limit := client.QuotaLimitBytes(); limit != 1600 || client.EffectiveFlowLimitBytes() != 1000 {
+		t.Fatalf("QuotaLimitBytes() = %d, EffectiveFlowLimitBytes() = %d, want 1600 and 1000", limit, client.EffectiveFlowLimitBytes())
+	}
+	if again := db.ResetDueQuotaPeriods(now.Add(time.Hour)); len(again) != 0 {
+		t.Fatalf("second ResetDueQuotaPeriods() = %+v, want none within the period", again)
+	}
+
+	// The carry never exceeds one period's limit and overuse leaves nothing.
+	if err := client.ObserveServiceTraffic(50, 0); err != nil {
+		t.Fatalf("ObserveServiceTraffic() error = %v", err)
+	}
+	resets = db.ResetDueQuotaPeriods(time.Date(2026, 7, 1, 0, 0, 0, 0, time.UTC))
+	if len(resets) != 1 || resets[0].LimitBytes != 1600 || resets[0].CarryBytes != 1000 {
+		t.Fatalf("July reset = %+v, want limit 1600 and carry 1000", resets)
+	}
+	if err := client.ObserveServiceTraffic(2500, 0); err == nil {
+		t.Fatal("ObserveServiceTraffic() past the carried limit should fail")
+	}
+	resets = db.ResetDueQuotaPeriods(time.Date(2026, 8, 1, 0, 0, 0, 0, time.UTC))
+	if len(resets) != 1 || resets[0].CarryBytes != 0 || client.QuotaLimitBytes() != 1000 {
+		t.Fatalf("August reset = %+v, want no carry", resets)
+	}
+}
+
+func TestTrafficStatsDrainKeepsConcurrentAdds(t *testing.T) {
+	stats := &TrafficStats{}
+	stats.Add(10, 20)
+	in, out, _ := stats.Snapshot()
+	stats.Add(1, 2)
+	stats.Drain(in, out)
+	if in, out, _ := stats.Snapshot(); in != 1 || out != 2 {
+		t.Fatalf("Snapshot() after Drain = %d/%d, want 1/2", in, out)
+	}
+}
@@ -0,0 +1,85 @@
+package file
+
+// QuotaUsage is the traffic and time budget of one resource at a moment.
+// LimitBytes includes any carried allowance; a zero LimitBytes or ExpireAt
+// means the resource has no such limit of its own.
+type QuotaUsage struct {
+	Resource   string
+	ID         int
+	UsedBytes  int64
+	LimitBytes int64
+	ExpireAt   int64
+}
+
+func (u *User) QuotaUsage() QuotaUsage {
+	if u == nil {
+		return QuotaUsage{}
+	}
+	_, _, used := u.TotalTrafficTotals()
+	return QuotaUsage{Resource: JournalResourceUser, ID: u.Id, UsedBytes: used, LimitBytes: u.QuotaLimitBytes(), ExpireAt: u.ExpireAt}
+}
+
+// QuotaUsage only reports the limits set on the client itself. Limits
+// inherited from the owner are measured against the owner's traffic and are
+// reported for the owner.
+func (s *Client) QuotaUsage() QuotaUsage {
+	if s == nil {
+		return QuotaUsage{}
+	}
+	_, _, used := s.TotalTrafficTotals()
+	usage := QuotaUsage{Resource: JournalResourceClient, ID: s.Id, UsedBytes: used}
+	if s.FlowLimit > 0 || (s.Flow != nil && s.Flow.FlowLimit > 0) {
+		usage.LimitBytes = s.QuotaLimitBytes()
+	}
+	if s.ExpireAt > 0 || (s.Flow != nil && !s.Flow.TimeLimit.IsZero()) {
+		usage.ExpireAt = s.EffectiveExpireAt()
+	}
+	return usage
+}
+
+func (t *Tunnel) QuotaUsage() QuotaUsage {
+	if t == nil {
+		return QuotaUsage{}
+	}
+	_, _, used := t.ServiceTrafficTotals()
+	return QuotaUsage{Resource: JournalResourceTunnel, ID: t.Id, UsedBytes: used, LimitBytes: t.QuotaLimitBytes(), ExpireAt: t.EffectiveExpireAt()}
+}
+
+func (h *Host) QuotaUsage() QuotaUsage {
+	if h == nil {
+		return QuotaUsage{}
+	}
+	_, _, used := h.ServiceTrafficTotals()
+	return QuotaUsage{Resource: JournalResourceHost, ID: h.Id, UsedBytes: used, LimitBytes: h.QuotaLimitBytes(), ExpireAt: h.EffectiveExpireAt()}
+}
+
+// QuotaUsages lists every stored resource that has a flow limit or an
+// expiry time.
+func (s *DbUtils) QuotaUsages() []QuotaUsage {
+	if s == nil {
+		return nil
+	}
+	var usages []QuotaUsage
+	add := func(usage QuotaUsage) {
+		if usage.LimitBytes > 0 || usage.ExpireAt > 0 {
+			usages = append(usages, usage)
+		}
+	}
+	s.RangeUsers(func(user *User) bool {
+		add(user.QuotaUsage())
+		return true
+	})
+	s.RangeClients(func(client *Client) bool {
+		add(client.QuotaUsage())
+		return true
+	})
+	s.RangeTasks(func(tunnel *Tunnel) bool {
+		add(tunnel.QuotaUsage())
+		return true
+	})
+	s.RangeHosts(func(host *Host) bool {
+		add(host.QuotaUsage())
+		return true
+	})
+	return usages
+}
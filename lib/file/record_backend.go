@@ -0,0 +1,429 @@
+package file
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/djylb/nps/lib/common"
+	"github.com/djylb/nps/lib/logs"
+)
+
+const (
+	PersistenceBackendJSON     = "json"
+	PersistenceBackendEmbedded = "embedded"
+	DefaultEmbeddedStorePath   = "conf/nps.db"
+
+	recordBucketUsers   = "users"
+	recordBucketClients = "clients"
+	recordBucketTasks   = "tasks"
+	recordBucketHosts   = "hosts"
+	recordBucketGlobal  = "global"
+	recordGlobalKey     = "global"
+)
+
+var (
+	ErrUnknownPersistenceBackend = errors.New("unknown persistence backend")
+	ErrEmbeddedStoreNotEmpty     = errors.New("embedded store already contains records")
+
+	persistenceOptionsMu sync.RWMutex
+	persistenceOptions   PersistenceOptions
+)
+
+// PersistenceOptions selects how JsonDb persists records. The zero value keeps
+// the historical whole-file JSON layout under conf/.
+type PersistenceOptions struct {
+	Backend string
+	Path    string
+}
+
+// PersistenceMigrationResult counts the records copied by a backend migration.
+type PersistenceMigrationResult struct {
+	Path    string `json:"path"`
+	Users   int    `json:"users"`
+	Clients int    `json:"clients"`
+	Tunnels int    `json:"tunnels"`
+	Hosts   int    `json:"hosts"`
+	Global  bool   `json:"global"`
+}
+
+func NormalizePersistenceBackend(name string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "json", "file":
+		return PersistenceBackendJSON, nil
+	case "embedded", "record", "db":
+		return PersistenceBackendEmbedded, nil
+	default:
+		return "", fmt.Errorf("%w: %q", ErrUnknownPersistenceBackend, name)
+	}
+}
+
+// ConfigurePersistence sets the backend used by the next GetDb initialization.
+func ConfigurePersistence(options PersistenceOptions) error {
+	backend, err := NormalizePersistenceBackend(options.Backend)
+	if err != nil {
+		return err
+	}
+	options.Backend = backend
+	options.Path = strings.TrimSpace(options.Path)
+	persistenceOptionsMu.Lock()
+	persistenceOptions = options
+	persistenceOptionsMu.Unlock()
+	return nil
+}
+
+func CurrentPersistenceOptions() PersistenceOptions {
+	persistenceOptionsMu.RLock()
+	defer persistenceOptionsMu.RUnlock()
+	return persistenceOptions
+}
+
+// ResolveStorePath returns the absolute embedded store path for runPath.
+func (o PersistenceOptions) ResolveStorePath(runPath string) string {
+	path := strings.TrimSpace(o.Path)
+	if path == "" {
+		path = DefaultEmbeddedStorePath
+	}
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(runPath, path)
+}
+
+// NewJsonDbWithPersistence builds a JsonDb whose records are stored through
+// the backend described by options.
+func NewJsonDbWithPersistence(runPath string, options PersistenceOptions) (*JsonDb, error) {
+	db := NewJsonDb(runPath)
+	backend, err := NormalizePersistenceBackend(options.Backend)
+	if err != nil {
+		return db, err
+	}
+	if backend == PersistenceBackendEmbedded {
+		db.persistence = newRecordStorePersistenceBackend(options.ResolveStorePath(runPath))
+	}
+	return db, nil
+}
+
+// recordStorePersistenceBackend keeps every user, client, tunnel, host and the
+// global record as its own entry in a recordStore. Store calls diff the
+// in-memory map against the last committed values and only append the records
+// that changed, so flushing one edited client no longer rewrites thousands.
+type recordStorePersistenceBackend struct {
+	path    string
+	mu      sync.Mutex
+	store   *recordStore
+	openErr error
+}
+
+func newRecordStorePersistenceBackend(path string) *recordStorePersistenceBackend {
+	return &recordStorePersistenceBackend{path: path}
+}
+
+func (b *recordStorePersistenceBackend) open(db *JsonDb) (*recordStore, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.store != nil {
+		return b.store, nil
+	}
+	if b.openErr != nil {
+		return nil, b.openErr
+	}
+	if !common.FileExists(b.path) && db != nil && jsonFilesHaveRecords(db) {
+		result, err := migrateJSONFilesToRecordStore(db, b.path)
+		if err != nil {
+			b.openErr = fmt.Errorf("seed embedded store from json files: %w", err)
+			return nil, b.openErr
+		}
+		logs.Info("seeded embedded store %s from json files: %d users, %d clients, %d tunnels, %d hosts",
+			result.Path, result.Users, result.Clients, result.Tunnels, result.Hosts)
+	}
+	store, err := openRecordStore(b.path)
+	if err != nil {
+		b.openErr = err
+		return nil, err
+	}
+	b.store = store
+	return store, nil
+}
+
+func (b *recordStorePersistenceBackend) LoadUsers(db *JsonDb) ([]*User, error) {
+	return loadRecordBucket[User](b, db, recordBucketUsers)
+}
+
+func (b *recordStorePersistenceBackend) LoadClients(db *JsonDb) ([]*Client, error) {
+	return loadRecordBucket[Client](b, db, recordBucketClients)
+}
+
+func (b *recordStorePersistenceBackend) LoadTasks(db *JsonDb) ([]*Tunnel, error) {
+	return loadRecordBucket[Tunnel](b, db, recordBucketTasks)
+}
+
+func (b *recordStorePersistenceBackend) LoadHosts(db *JsonDb) ([]*Host, error) {
+	return loadRecordBucket[Host](b, db, recordBucketHosts)
+}
+
+func (b *recordStorePersistenceBackend) LoadGlobal(db *JsonDb) (*Glob, error) {
+	store, err := b.open(db)
+	if err != nil {
+		return nil, err
+	}
+	global := &Glob{}
+	raw, ok := store.Bucket(recordBucketGlobal)[recordGlobalKey]
+	if ok && json.Unmarshal(raw, global) != nil {
+		global = &Glob{}
+	}
+	InitializeGlobalRuntime(global)
+	return global, nil
+}
+
+func (b *recordStorePersistenceBackend) StoreUsers(db *JsonDb) error {
+	return b.storeSyncMap(db, recordBucketUsers, &db.Users)
+}
+
+func (b *recordStorePersistenceBackend) StoreClients(db *JsonDb) error {
+	return b.storeSyncMap(db, recordBucketClients, &db.Clients)
+}
+
+func (b *recordStorePersistenceBackend) StoreTasks(db *JsonDb) error {
+	return b.storeSyncMap(db, recordBucketTasks, &db.Tasks)
+}
+
+func (b *recordStorePersistenceBackend) StoreHosts(db *JsonDb) error {
+	return b.storeSyncMap(db, recordBucketHosts, &db.Hosts)
+}
+
+func (b *recordStorePersistenceBackend) StoreGlobal(db *JsonDb) error {
+	store, err := b.open(db)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(db.Global)
+	if err != nil {
+		return fmt.Errorf("marshal global payload: %w", err)
+	}
+	if current, ok := store.Bucket(recordBucketGlobal)[recordGlobalKey]; ok && bytes.Equal(current, data) {
+		return nil
+	}
+	return store.Commit([]recordOp{{Bucket: recordBucketGlobal, Key: recordGlobalKey, Value: data}})
+}
+
+// storeSyncMap commits the records of m that differ from the stored bytes.
+// Records are edited in place through shared pointers (flow counters,
+// runtime fields, management updates), so there is no reliable dirty set and
+// every record is still marshaled on each flush; only the disk write is
+// incremental. BenchmarkEmbeddedStoreClients measures that cost.
+func (b *recordStorePersistenceBackend) storeSyncMap(db *JsonDb, bucket string, m *sync.Map) error {
+	store, err := b.open(db)
+	if err != nil {
+		return err
+	}
+	committed := store.Bucket(bucket)
+	seen := make(map[string]struct{}, len(committed))
+	ops := make([]recordOp, 0)
+	var rangeErr error
+	m.Range(func(key, value interface{}) bool {
+		id, ok := key.(int)
+		if !ok || !isPersistableRecord(value) {
+			return true
+		}
+		data, marshalErr := json.Marshal(value)
+		if marshalErr != nil {
+			rangeErr = fmt.Errorf("marshal %T: %w", value, marshalErr)
+			return false
+		}
+		recordKey := strconv.Itoa(id)
+		seen[recordKey] = struct{}{}
+		if current, ok := committed[recordKey]; ok && bytes.Equal(current, data) {
+			return true
+		}
+		ops = append(ops, recordOp{Bucket: bucket, Key: recordKey, Value: data})
+		return true
+	})
+	if rangeErr != nil {
+		return rangeErr
+	}
+	for _, recordKey := range sortedRecordKeys(committed) {
+		if _, ok := seen[recordKey]; !ok {
+			ops = append(ops, recordOp{Bucket: bucket, Key: recordKey, Delete: true})
+		}
+	}
+	return store.Commit(ops)
+}
+
+func loadRecordBucket[T any](b *recordStorePersistenceBackend, db *JsonDb, bucket string) ([]*T, error) {
+	store, err := b.open(db)
+	if err != nil {
+		return nil, err
+	}
+	records := store.Bucket(bucket)
+	loaded := make([]*T, 0, len(records))
+	for _, key := range sortedRecordKeys(records) {
+		value := new(T)
+		if err := json.Unmarshal(records[key], value); err != nil {
+			logs.Warn("skip undecodable %s record %s in %s: %v", bucket, key, b.path, err)
+			continue
+		}
+		loaded = append(loaded, value)
+	}
+	return loaded, nil
+}
+
+func isPersistableRecord(value interface{}) bool {
+	switch v := value.(type) {
+	case *Tunnel:
+		return !v.NoStore
+	case *Host:
+		return !v.NoStore
+	case *Client:
+		return !v.NoStore
+	}
+	return true
+}
+
+func jsonFilesHaveRecords(db *JsonDb) bool {
+	for _, path := range []string{db.UserFilePath, db.ClientFilePath, db.TaskFilePath, db.HostFilePath, db.GlobalFilePath} {
+		if info, err := os.Stat(path); err == nil && info.Size() > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// MigrateJSONToEmbeddedStore copies the JSON files under runPath into a new
+// embedded store. The JSON files are only read, so the migration can run next
+// to a live nps process; the store is written to a temp file and renamed into
+// place, and it refuses to overwrite a store that already holds records.
+func MigrateJSONToEmbeddedStore(runPath string, options PersistenceOptions) (PersistenceMigrationResult, error) {
+	return migrateJSONFilesToRecordStore(NewJsonDb(runPath), options.ResolveStorePath(runPath))
+}
+
+func migrateJSONFilesToRecordStore(db *JsonDb, storePath string) (PersistenceMigrationResult, error) {
+	result := PersistenceMigrationResult{Path: storePath}
+	if err := ensureRecordStoreEmpty(storePath); err != nil {
+		return result, err
+	}
+	ops := make([]recordOp, 0)
+	sources := []struct {
+		bucket string
+		path   string
+		count  *int
+		kind   interface{}
+	}{
+		{recordBucketUsers, db.UserFilePath, &result.Users, User{}},
+		{recordBucketClients, db.ClientFilePath, &result.Clients, Client{}},
+		{recordBucketTasks, db.TaskFilePath, &result.Tunnels, Tunnel{}},
+		{recordBucketHosts, db.HostFilePath, &result.Hosts, Host{}},
+	}
+	for _, source := range sources {
+		records, err := readJSONFileRecords(source.path, source.kind)
+		if err != nil {
+			return result, err
+		}
+		for _, key := range sortedRecordKeys(records) {
+			ops = append(ops, recordOp{Bucket: source.bucket, Key: key, Value: records[key]})
+		}
+		*source.count = len(records)
+	}
+	if data, err := os.ReadFile(db.GlobalFilePath); err == nil && len(bytes.TrimSpace(data)) > 0 && json.Valid(data) {
+		ops = append(ops, recordOp{Bucket: recordBucketGlobal, Key: recordGlobalKey, Value: bytes.TrimSpace(data)})
+		result.Global = true
+	} else if err != nil && !os.IsNotExist(err) {
+		return result, fmt.Errorf("read %s: %w", db.GlobalFilePath, err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(storePath), os.ModePerm); err != nil {
+		return result, fmt.Errorf("create record store dir %s: %w", filepath.Dir(storePath), err)
+	}
+	tmpPath := storePath + ".migrate"
+	if _, err := writeRecordStoreFile(tmpPath, 1, ops); err != nil {
+		return result, err
+	}
+	if err := os.Rename(tmpPath, storePath); err != nil {
+		_ = os.Remove(tmpPath)
+		return result, fmt.Errorf("install record store %s: %w", storePath, err)
+	}
+	syncRecordStoreDir(storePath)
+	return result, nil
+}
+
+func ensureRecordStoreEmpty(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if info.Size() > int64(len(recordStoreMagic)) {
+		return fmt.Errorf("%w: %s", ErrEmbeddedStoreNotEmpty, path)
+	}
+	return nil
+}
+
+// readJSONFileRecords returns the raw JSON of every record keyed by Id. Raw
+// bytes are kept whenever possible so legacy fields that only the load path
+// understands (for example client BlackIpList) survive the migration.
+func readJSONFileRecords(path string, kind interface{}) (map[string][]byte, error) {
+	records := make(map[string][]byte)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return records, nil
+		}
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return records, nil
+	}
+	var raws []json.RawMessage
+	if err := json.Unmarshal(data, &raws); err == nil {
+		for _, raw := range raws {
+			var ref struct{ Id int }
+			if json.Unmarshal(raw, &ref) != nil || ref.Id <= 0 {
+				continue
+			}
+			records[strconv.Itoa(ref.Id)] = append([]byte(nil), raw...)
+		}
+		return records, nil
+	}
+	var marshalErr error
+	loadSyncMapFromBytes(data, path, kind, func(value interface{}) {
+		if marshalErr != nil {
+			return
+		}
+		id := recordValueID(value)
+		if id <= 0 {
+			return
+		}
+		raw, err := json.Marshal(value)
+		if err != nil {
+			marshalErr = fmt.Errorf("marshal %T: %w", value, err)
+			return
+		}
+		records[strconv.Itoa(id)] = raw
+	})
+	if marshalErr != nil {
+		return nil, marshalErr
+	}
+	return records, nil
+}
+
+func recordValueID(value interface{}) int {
+	switch v := value.(type) {
+	case *User:
+		return v.Id
+	case *Client:
+		return v.Id
+	case *Tunnel:
+		return v.Id
+	case *Host:
+		return v.Id
+	}
+	return 0
+}
@@ -0,0 +1,214 @@
+package file
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newEmbeddedTestDb(t testing.TB, runPath string) *JsonDb {
+	t.Helper()
+	db, err := NewJsonDbWithPersistence(runPath, PersistenceOptions{Backend: PersistenceBackendEmbedded})
+	if err != nil {
+		t.Fatalf("NewJsonDbWithPersistence() error = %v", err)
+	}
+	return db
+}
+
+func TestEmbeddedPersistenceBackendRoundTrip(t *testing.T) {
+	oldIndexes := SnapshotRuntimeIndexes()
+	ReplaceRuntimeIndexes(NewRuntimeIndexes())
+	t.Cleanup(func() {
+		ReplaceRuntimeIndexes(oldIndexes)
+	})
+
+	runPath := t.TempDir()
+	db := newEmbeddedTestDb(t, runPath)
+	user := &User{Id: 3, Username: "tenant", Password: "secret", Status: 1, TotalFlow: &Flow{}}
+	client := &Client{Id: 9, OwnerUserID: 3, VerifyKey: "vk-9", Status: true, Cnf: &Config{}, Flow: &Flow{}}
+	hidden := &Client{Id: 10, VerifyKey: "vk-10", NoStore: true, Cnf: &Config{}, Flow: &Flow{}}
+	db.Users.Store(user.Id, user)
+	db.Clients.Store(client.Id, client)
+	db.Clients.Store(hidden.Id, hidden)
+	db.Tasks.Store(4, &Tunnel{Id: 4, Mode: "tcp", Port: 9000, Client: client, Flow: &Flow{}, Target: &Target{TargetStr: "127.0.0.1:22"}})
+	db.Hosts.Store(5, &Host{Id: 5, Host: "demo.example.com", Location: "/", Scheme: "all", Client: client, Flow: &Flow{}, Target: &Target{TargetStr: "127.0.0.1:80"}})
+	db.Global = &Glob{EntryAclMode: AclBlacklist, EntryAclRules: "10.0.0.1"}
+	db.StoreUsers()
+	db.StoreClients()
+	db.StoreTasks()
+	db.StoreHosts()
+	db.StoreGlobal()
+
+	if _, err := os.Stat(filepath.Join(runPath, "conf", "clients.json")); !os.IsNotExist(err) {
+		t.Fatalf("embedded backend should not write clients.json, stat err = %v", err)
+	}
+
+	reloaded := newEmbeddedTestDb(t, runPath)
+	reloaded.LoadUsers()
+	reloaded.LoadClients()
+	reloaded.LoadTasks()
+	reloaded.LoadHosts()
+	reloaded.LoadGlobal()
+
+	if got, ok := loadUserEntry(&reloaded.Users, 3); !ok || got.Username != "tenant" {
+		t.Fatalf("reloaded user = %+v, ok=%v", got, ok)
+	}
+	if got, ok := loadClientEntry(&reloaded.Clients, 9); !ok || got.VerifyKey != "vk-9" || got.OwnerUser() == nil {
+		t.Fatalf("reloaded client = %+v, ok=%v", got, ok)
+	}
+	if _, ok := loadClientEntry(&reloaded.Clients, 10); ok {
+		t.Fatal("NoStore client should not be persisted")
+	}
+	if got, ok := loadTaskEntry(&reloaded.Tasks, 4); !ok || got.Port != 9000 || got.Client == nil || got.Client.Id != 9 {
+		t.Fatalf("reloaded tunnel = %+v, ok=%v", got, ok)
+	}
+	if got, ok := loadHostEntry(&reloaded.Hosts, 5); !ok || got.Host != "demo.example.com" {
+		t.Fatalf("reloaded host = %+v, ok=%v", got, ok)
+	}
+	if reloaded.Global == nil || reloaded.Global.EntryAclRules != "10.0.0.1" {
+		t.Fatalf("reloaded global = %+v", reloaded.Global)
+	}
+}
+
+func TestEmbeddedPersistenceBackendOnlyWritesChangedRecords(t *testing.T) {
+	runPath := t.TempDir()
+	db := newEmbeddedTestDb(t, runPath)
+	for id := 1; id <= 50; id++ {
+		db.Clients.Store(id, &Client{Id: id, VerifyKey: "vk", Cnf: &Config{}, Flow: &Flow{}})
+	}
+	db.StoreClients()
+
+	backend := db.persistence.(*recordStorePersistenceBackend)
+	store, err := backend.open(db)
+	if err != nil {
+		t.Fatalf("open() error = %v", err)
+	}
+	sizeAfterFull := store.size
+	seqAfterFull := store.seq
+
+	db.StoreClients()
+	if store.seq != seqAfterFull || store.size != sizeAfterFull {
+		t.Fatalf("unchanged store should not append, seq %d->%d size %d->%d", seqAfterFull, store.seq, sizeAfterFull, store.size)
+	}
+
+	changed, _ := loadClientEntry(&db.Clients, 7)
+	changed.Remark = "edited"
+	db.Clients.Delete(8)
+	db.StoreClients()
+	if store.seq != seqAfterFull+1 {
+		t.Fatalf("seq = %d, want one new commit", store.seq)
+	}
+	if grown := store.size - sizeAfterFull; grown <= 0 || grown > 1024 {
+		t.Fatalf("single-record commit grew store by %d bytes, want a small delta", grown)
+	}
+	records := store.Bucket(recordBucketClients)
+	if len(records) != 49 {
+		t.Fatalf("stored clients = %d, want 49 after delete", len(records))
+	}
+}
+
+func BenchmarkEmbeddedStoreClients(b *testing.B) {
+	for _, size := range []int{100, 1000, 10000} {
+		b.Run(fmt.Sprintf("clients_%d", size), func(b *testing.B) {
+			db := newEmbeddedTestDb(b, b.TempDir())
+			for id := 1; id <= size; id++ {
+				db.Clients.Store(id, &Client{Id: id, VerifyKey: fmt.Sprintf("vk-%d", id), Cnf: &Config{}, Flow: &Flow{}})
+			}
+			db.StoreClients()
+			changed, _ := loadClientEntry(&db.Clients, 1)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				changed.Remark = fmt.Sprintf("edit-%d", i)
+				db.StoreClients()
+			}
+		})
+	}
+}
+
+func TestMigrateJSONToEmbeddedStoreCopiesRecordsAndRefusesOverwrite(t *testing.T) {
+	runPath := t.TempDir()
+	confDir := filepath.Join(runPath, "conf")
+	if err := os.MkdirAll(confDir, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	writeTestFile(t, filepath.Join(confDir, "users.json"), `[{"Id":1,"Username":"tenant"}]`)
+	writeTestFile(t, filepath.Join(confDir, "clients.json"), `[{"Id":2,"VerifyKey":"vk","BlackIpList":["1.1.1.1"]},{"Id":3,"VerifyKey":"vk3"}]`)
+	writeTestFile(t, filepath.Join(confDir, "tasks.json"), `[{"Id":4,"Mode":"tcp","Client":{"Id":2}}]`)
+	writeTestFile(t, filepath.Join(confDir, "global.json"), `{"EntryAclMode":2,"EntryAclRules":"8.8.8.8"}`)
+
+	result, err := MigrateJSONToEmbeddedStore(runPath, PersistenceOptions{Backend: PersistenceBackendEmbedded})
+	if err != nil {
+		t.Fatalf("MigrateJSONToEmbeddedStore() error = %v", err)
+	}
+	if result.Users != 1 || result.Clients != 2 || result.Tunnels != 1 || result.Hosts != 0 || !result.Global {
+		t.Fatalf("migration result = %+v", result)
+	}
+	if result.Path != filepath.Join(runPath, DefaultEmbeddedStorePath) {
+		t.Fatalf("migration path = %q", result.Path)
+	}
+
+	store, err := openRecordStore(result.Path)
+	if err != nil {
+		t.Fatalf("openRecordStore() error = %v", err)
+	}
+	raw := string(store.Bucket(recordBucketClients)["2"])
+	_ = store.Close()
+	if raw != `{"Id":2,"VerifyKey":"vk","BlackIpList":["1.1.1.1"]}` {
+		t.Fatalf("migrated client payload = %s, want raw legacy JSON preserved", raw)
+	}
+
+	if _, err := MigrateJSONToEmbeddedStore(runPath, PersistenceOptions{Backend: PersistenceBackendEmbedded}); !errors.Is(err, ErrEmbeddedStoreNotEmpty) {
+		t.Fatalf("second migration error = %v, want ErrEmbeddedStoreNotEmpty", err)
+	}
+}
+
+func TestEmbeddedPersistenceBackendSeedsFromJSONOnFirstOpen(t *testing.T) {
+	oldIndexes := SnapshotRuntimeIndexes()
+	ReplaceRuntimeIndexes(NewRuntimeIndexes())
+	t.Cleanup(func() {
+		ReplaceRuntimeIndexes(oldIndexes)
+	})
+
+	runPath := t.TempDir()
+	confDir := filepath.Join(runPath, "conf")
+	if err := os.MkdirAll(confDir, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	writeTestFile(t, filepath.Join(confDir, "clients.json"), `[{"Id":6,"VerifyKey":"seeded","Cnf":{},"Flow":{}}]`)
+
+	db := newEmbeddedTestDb(t, runPath)
+	db.LoadUsers()
+	db.LoadClients()
+	if got, ok := loadClientEntry(&db.Clients, 6); !ok || got.VerifyKey != "seeded" {
+		t.Fatalf("seeded client = %+v, ok=%v", got, ok)
+	}
+	if _, err := os.Stat(filepath.Join(runPath, DefaultEmbeddedStorePath)); err != nil {
+		t.Fatalf("embedded store should be created during seeding: %v", err)
+	}
+}
+
+func TestConfigurePersistenceRejectsUnknownBackend(t *testing.T) {
+	previous := CurrentPersistenceOptions()
+	t.Cleanup(func() {
+		_ = ConfigurePersistence(previous)
+	})
+	if err := ConfigurePersistence(PersistenceOptions{Backend: "mysql"}); !errors.Is(err, ErrUnknownPersistenceBackend) {
+		t.Fatalf("ConfigurePersistence(mysql) error = %v", err)
+	}
+	if err := ConfigurePersistence(PersistenceOptions{Backend: " Embedded ", Path: "/data/nps.db"}); err != nil {
+		t.Fatalf("ConfigurePersistence(embedded) error = %v", err)
+	}
+	if got := CurrentPersistenceOptions(); got.Backend != PersistenceBackendEmbedded || got.ResolveStorePath("/run") != "/data/nps.db" {
+		t.Fatalf("CurrentPersistenceOptions() = %+v", got)
+	}
+}
+
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("write %s: %v", path, err)
+	}
+}
@@ -0,0 +1,418 @@
+package file
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/djylb/nps/lib/logs"
+)
+
+const (
+	recordStoreMagic      = "NPSREC1\n"
+	recordFrameHeaderSize = 8
+	recordOpPut           = byte(1)
+	recordOpDelete        = byte(2)
+	recordCompactMinBytes = 4 << 20
+	recordCompactGarbage  = 2
+	recordMaxFramePayload = 1 << 30
+	recordStoreFileMode   = 0o600
+	recordStoreTempSuffix = ".compact"
+)
+
+var (
+	errRecordStoreClosed  = errors.New("record store is closed")
+	errRecordStoreCorrupt = errors.New("record store header is invalid")
+	recordCRCTable        = crc32.MakeTable(crc32.Castagnoli)
+)
+
+type recordOp struct {
+	Bucket string
+	Key    string
+	Value  []byte
+	Delete bool
+}
+
+// recordStore is a single-file, append-only record log. Every commit appends
+// one checksummed frame holding a batch of puts/deletes and is fsynced before
+// returning, so a crash can at worst leave a torn trailing frame that the next
+// open discards. Superseded records are reclaimed by rewriting the live set
+// into a fresh file and renaming it over the old one.
+type recordStore struct {
+	mu        sync.Mutex
+	path      string
+	file      *os.File
+	size      int64
+	liveBytes int64
+	seq       uint64
+	buckets   map[string]map[string][]byte
+}
+
+func openRecordStore(path string) (*recordStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, fmt.Errorf("create record store dir %s: %w", filepath.Dir(path), err)
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, recordStoreFileMode)
+	if err != nil {
+		return nil, fmt.Errorf("open record store %s: %w", path, err)
+	}
+	s := &recordStore{
+		path:    path,
+		file:    file,
+		buckets: make(map[string]map[string][]byte),
+	}
+	if err := s.replay(); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *recordStore) replay() error {
+	data, err := io.ReadAll(s.file)
+	if err != nil {
+		return fmt.Errorf("read record store %s: %w", s.path, err)
+	}
+	if len(data) == 0 {
+		if _, err := s.file.Write([]byte(recordStoreMagic)); err != nil {
+			return fmt.Errorf("write record store header %s: %w", s.path, err)
+		}
+		if err := s.file.Sync(); err != nil {
+			return fmt.Errorf("sync record store header %s: %w", s.path, err)
+		}
+		s.size = int64(len(recordStoreMagic))
+		return nil
+	}
+	if len(data) < len(recordStoreMagic) || string(data[:len(recordStoreMagic)]) != recordStoreMagic {
+		return fmt.Errorf("%w: %s", errRecordStoreCorrupt, s.path)
+	}
+	offset := len(recordStoreMagic)
+	for offset < len(data) {
+		payload, next, ok := readRecordFrame(data, offset)
+		if !ok {
+			break
+		}
+		seq, ops, err := decodeRecordFrame(payload)
+		if err != nil {
+			break
+		}
+		s.apply(ops)
+		s.seq = seq
+		offset = next
+	}
+	if offset < len(data) {
+		logs.Warn("record store %s has %d trailing bytes from an interrupted commit, truncating", s.path, len(data)-offset)
+		if err := s.file.Truncate(int64(offset)); err != nil {
+			return fmt.Errorf("truncate record store %s: %w", s.path, err)
+		}
+		if err := s.file.Sync(); err != nil {
+			return fmt.Errorf("sync record store %s: %w", s.path, err)
+		}
+	}
+	if _, err := s.file.Seek(int64(offset), io.SeekStart); err != nil {
+		return fmt.Errorf("seek record store %s: %w", s.path, err)
+	}
+	s.size = int64(offset)
+	return nil
+}
+
+func readRecordFrame(data []byte, offset int) ([]byte, int, bool) {
+	if len(data)-offset < recordFrameHeaderSize {
+		return nil, offset, false
+	}
+	length := binary.BigEndian.Uint32(data[offset:])
+	sum := binary.BigEndian.Uint32(data[offset+4:])
+	if length == 0 || length > recordMaxFramePayload {
+		return nil, offset, false
+	}
+	start := offset + recordFrameHeaderSize
+	end := start + int(length)
+	if end > len(data) || end < start {
+		return nil, offset, false
+	}
+	payload := data[start:end]
+	if crc32.Checksum(payload, recordCRCTable) != sum {
+		return nil, offset, false
+	}
+	return payload, end, true
+}
+
+func encodeRecordFrame(seq uint64, ops []recordOp) []byte {
+	var payload bytes.Buffer
+	var scratch [binary.MaxVarintLen64]byte
+	putUvarint := func(v uint64) {
+		n := binary.PutUvarint(scratch[:], v)
+		payload.Write(scratch[:n])
+	}
+	putBytes := func(b []byte) {
+		putUvarint(uint64(len(b)))
+		payload.Write(b)
+	}
+	putUvarint(seq)
+	putUvarint(uint64(len(ops)))
+	for _, op := range ops {
+		if op.Delete {
+			payload.WriteByte(recordOpDelete)
+		} else {
+			payload.WriteByte(recordOpPut)
+		}
+		putBytes([]byte(op.Bucket))
+		putBytes([]byte(op.Key))
+		if !op.Delete {
+			putBytes(op.Value)
+		}
+	}
+	frame := make([]byte, recordFrameHeaderSize+payload.Len())
+	binary.BigEndian.PutUint32(frame, uint32(payload.Len()))
+	binary.BigEndian.PutUint32(frame[4:], crc32.Checksum(payload.Bytes(), recordCRCTable))
+	copy(frame[recordFrameHeaderSize:], payload.Bytes())
+	return frame
+}
+
+func decodeRecordFrame(payload []byte) (uint64, []recordOp, error) {
+	reader := bytes.NewReader(payload)
+	readBytes := func() ([]byte, error) {
+		n, err := binary.ReadUvarint(reader)
+		if err != nil {
+			return nil, err
+		}
+		if n > uint64(reader.Len()) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(reader, buf); err != nil {
+			return nil, err
+		}
+		return buf, nil
+	}
+	seq, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return 0, nil, err
+	}
+	count, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return 0, nil, err
+	}
+	if count > uint64(len(payload)) {
+		return 0, nil, io.ErrUnexpectedEOF
+	}
+	ops := make([]recordOp, 0, count)
+	for i := uint64(0); i < count; i++ {
+		kind, err := reader.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		bucket, err := readBytes()
+		if err != nil {
+			return 0, nil, err
+		}
+		key, err := readBytes()
+		if err != nil {
+			return 0, nil, err
+		}
+		op := recordOp{Bucket: string(bucket), Key: string(key)}
+		switch kind {
+		case recordOpPut:
+			if op.Value, err = readBytes(); err != nil {
+				return 0, nil, err
+			}
+		case recordOpDelete:
+			op.Delete = true
+		default:
+			return 0, nil, fmt.Errorf("unknown record op %d", kind)
+		}
+		ops = append(ops, op)
+	}
+	return seq, ops, nil
+}
+
+func (s *recordStore) apply(ops []recordOp) {
+	for _, op := range ops {
+		bucket := s.buckets[op.Bucket]
+		if previous, ok := bucket[op.Key]; ok {
+			s.liveBytes -= int64(len(op.Bucket) + len(op.Key) + len(previous))
+		}
+		if op.Delete {
+			delete(bucket, op.Key)
+			continue
+		}
+		if bucket == nil {
+			bucket = make(map[string][]byte)
+			s.buckets[op.Bucket] = bucket
+		}
+		bucket[op.Key] = op.Value
+		s.liveBytes += int64(len(op.Bucket) + len(op.Key) + len(op.Value))
+	}
+}
+
+// Commit durably appends one batch. Either every op in the batch becomes
+// visible or, after a crash, none of them does. A failed compaction after
+// the append is returned as well; the batch itself is already stored.
+func (s *recordStore) Commit(ops []recordOp) error {
+	if len(ops) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return errRecordStoreClosed
+	}
+	frame := encodeRecordFrame(s.seq+1, ops)
+	if _, err := s.file.Write(frame); err != nil {
+		s.rollbackTail()
+		return fmt.Errorf("append record frame %s: %w", s.path, err)
+	}
+	if err := s.file.Sync(); err != nil {
+		s.rollbackTail()
+		return fmt.Errorf("sync record frame %s: %w", s.path, err)
+	}
+	s.seq++
+	s.size += int64(len(frame))
+	s.apply(ops)
+	if s.shouldCompact() {
+		if err := s.compactLocked(); err != nil {
+			return fmt.Errorf("compact record store %s: %w", s.path, err)
+		}
+	}
+	return nil
+}
+
+func (s *recordStore) rollbackTail() {
+	if s.file == nil {
+		return
+	}
+	_ = s.file.Truncate(s.size)
+	_, _ = s.file.Seek(s.size, io.SeekStart)
+}
+
+func (s *recordStore) shouldCompact() bool {
+	return s.size > recordCompactMinBytes && s.size > recordCompactGarbage*(s.liveBytes+int64(len(recordStoreMagic)))
+}
+
+// Compact rewrites the live records into a single frame.
+func (s *recordStore) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return errRecordStoreClosed
+	}
+	return s.compactLocked()
+}
+
+// compactLocked opens the rewritten file before renaming it over the live
+// one, so a failure leaves the store appending to the old file and nothing
+// can fail once the rename took place.
+func (s *recordStore) compactLocked() error {
+	tmpPath := s.path + recordStoreTempSuffix
+	size, err := writeRecordStoreFile(tmpPath, s.seq, s.snapshotOpsLocked())
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(tmpPath, os.O_RDWR, recordStoreFileMode)
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("reopen record store %s: %w", tmpPath, err)
+	}
+	if _, err := file.Seek(size, io.SeekStart); err != nil {
+		_ = file.Close()
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("seek record store %s: %w", tmpPath, err)
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		_ = file.Close()
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("replace record store %s: %w", s.path, err)
+	}
+	syncRecordStoreDir(s.path)
+	_ = s.file.Close()
+	s.file = file
+	s.size = size
+	return nil
+}
+
+func (s *recordStore) snapshotOpsLocked() []recordOp {
+	ops := make([]recordOp, 0)
+	for _, bucketName := range sortedRecordKeys(s.buckets) {
+		bucket := s.buckets[bucketName]
+		for _, key := range sortedRecordKeys(bucket) {
+			ops = append(ops, recordOp{Bucket: bucketName, Key: key, Value: bucket[key]})
+		}
+	}
+	return ops
+}
+
+// writeRecordStoreFile creates a complete store file holding the given ops as
+// a single frame. An empty op list produces a header-only store.
+func writeRecordStoreFile(path string, seq uint64, ops []recordOp) (int64, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, recordStoreFileMode)
+	if err != nil {
+		return 0, fmt.Errorf("create record store %s: %w", path, err)
+	}
+	defer func() { _ = file.Close() }()
+	size := int64(len(recordStoreMagic))
+	if _, err := file.Write([]byte(recordStoreMagic)); err != nil {
+		_ = os.Remove(path)
+		return 0, fmt.Errorf("write record store header %s: %w", path, err)
+	}
+	if len(ops) > 0 {
+		frame := encodeRecordFrame(seq, ops)
+		if _, err := file.Write(frame); err != nil {
+			_ = os.Remove(path)
+			return 0, fmt.Errorf("write record store frame %s: %w", path, err)
+		}
+		size += int64(len(frame))
+	}
+	if err := file.Sync(); err != nil {
+		_ = os.Remove(path)
+		return 0, fmt.Errorf("sync record store %s: %w", path, err)
+	}
+	return size, nil
+}
+
+func syncRecordStoreDir(path string) {
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return
+	}
+	_ = dir.Sync()
+	_ = dir.Close()
+}
+
+// Bucket returns a copy of the live values of one bucket keyed by record key.
+func (s *recordStore) Bucket(name string) map[string][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	bucket := s.buckets[name]
+	cloned := make(map[string][]byte, len(bucket))
+	for key, value := range bucket {
+		cloned[key] = value
+	}
+	return cloned
+}
+
+func (s *recordStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+func sortedRecordKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,172 @@
+package file
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRecordStoreCommitSurvivesReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nps.db")
+	store, err := openRecordStore(path)
+	if err != nil {
+		t.Fatalf("openRecordStore() error = %v", err)
+	}
+	if err := store.Commit([]recordOp{
+		{Bucket: "clients", Key: "1", Value: []byte(`{"Id":1}`)},
+		{Bucket: "clients", Key: "2", Value: []byte(`{"Id":2}`)},
+	}); err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+	if err := store.Commit([]recordOp{
+		{Bucket: "clients", Key: "1", Delete: true},
+		{Bucket: "clients", Key: "2", Value: []byte(`{"Id":2,"Remark":"updated"}`)},
+	}); err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+	_ = store.Close()
+
+	reopened, err := openRecordStore(path)
+	if err != nil {
+		t.Fatalf("reopen error = %v", err)
+	}
+	defer func() { _ = reopened.Close() }()
+	bucket := reopened.Bucket("clients")
+	if _, ok := bucket["1"]; ok {
+		t.Fatal("deleted record should stay deleted after reopen")
+	}
+	if got := string(bucket["2"]); got != `{"Id":2,"Remark":"updated"}` {
+		t.Fatalf("record 2 = %q, want updated payload", got)
+	}
+	if reopened.seq != 2 {
+		t.Fatalf("seq = %d, want 2", reopened.seq)
+	}
+}
+
+func TestRecordStoreDiscardsTornTrailingFrame(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nps.db")
+	store, err := openRecordStore(path)
+	if err != nil {
+		t.Fatalf("openRecordStore() error = %v", err)
+	}
+	if err := store.Commit([]recordOp{{Bucket: "hosts", Key: "5", Value: []byte(`{"Id":5}`)}}); err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+	_ = store.Close()
+	committedSize, _ := os.Stat(path)
+
+	torn := encodeRecordFrame(2, []recordOp{{Bucket: "hosts", Key: "6", Value: []byte(`{"Id":6}`)}})
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		t.Fatalf("open for append: %v", err)
+	}
+	_, _ = f.Write(torn[:len(torn)-3])
+	_ = f.Close()
+
+	reopened, err := openRecordStore(path)
+	if err != nil {
+		t.Fatalf("reopen error = %v", err)
+	}
+	defer func() { _ = reopened.Close() }()
+	bucket := reopened.Bucket("hosts")
+	if _, ok := bucket["6"]; ok {
+		t.Fatal("torn frame should not become visible")
+	}
+	if _, ok := bucket["5"]; !ok {
+		t.Fatal("committed frame should survive torn tail recovery")
+	}
+	info, _ := os.Stat(path)
+	if info.Size() != committedSize.Size() {
+		t.Fatalf("file size = %d, want truncated back to %d", info.Size(), committedSize.Size())
+	}
+	if err := reopened.Commit([]recordOp{{Bucket: "hosts", Key: "7", Value: []byte(`{"Id":7}`)}}); err != nil {
+		t.Fatalf("Commit() after recovery error = %v", err)
+	}
+}
+
+func TestRecordStoreRejectsForeignFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nps.db")
+	if err := os.WriteFile(path, []byte("[]\n"), 0o600); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	if _, err := openRecordStore(path); err == nil {
+		t.Fatal("openRecordStore() should reject files without the record store header")
+	}
+}
+
+func TestRecordStoreCompactKeepsLiveRecordsOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nps.db")
+	store, err := openRecordStore(path)
+	if err != nil {
+		t.Fatalf("openRecordStore() error = %v", err)
+	}
+	payload := bytes.Repeat([]byte("x"), 1024)
+	for i := 0; i < 32; i++ {
+		if err := store.Commit([]recordOp{{Bucket: "tasks", Key: "1", Value: payload}}); err != nil {
+			t.Fatalf("Commit() error = %v", err)
+		}
+	}
+	if err := store.Commit([]recordOp{{Bucket: "tasks", Key: "2", Value: []byte(`{"Id":2}`)}}); err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+	before, _ := os.Stat(path)
+	if err := store.Compact(); err != nil {
+		t.Fatalf("Compact() error = %v", err)
+	}
+	after, _ := os.Stat(path)
+	if after.Size() >= before.Size() {
+		t.Fatalf("compacted size = %d, want less than %d", after.Size(), before.Size())
+	}
+	if err := store.Commit([]recordOp{{Bucket: "tasks", Key: "3", Value: []byte(`{"Id":3}`)}}); err != nil {
+		t.Fatalf("Commit() after compact error = %v", err)
+	}
+	_ = store.Close()
+
+	reopened, err := openRecordStore(path)
+	if err != nil {
+		t.Fatalf("reopen error = %v", err)
+	}
+	defer func() { _ = reopened.Close() }()
+	bucket := reopened.Bucket("tasks")
+	if len(bucket) != 3 || !bytes.Equal(bucket["1"], payload) {
+		t.Fatalf("bucket after compact = %d records, want 3 with latest payload", len(bucket))
+	}
+}
+
+func TestRecordStoreReportsFailedCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nps.db")
+	store, err := openRecordStore(path)
+	if err != nil {
+		t.Fatalf("openRecordStore() error = %v", err)
+	}
+	defer func() { _ = store.Close() }()
+	// A directory in place of the temporary file makes the rewrite fail.
+	if err := os.Mkdir(path+recordStoreTempSuffix, 0o755); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+	payload := bytes.Repeat([]byte("x"), 1<<20)
+	var commitErr error
+	for i := 0; i < 8 && commitErr == nil; i++ {
+		commitErr = store.Commit([]recordOp{{Bucket: "tasks", Key: "1", Value: payload}})
+	}
+	if commitErr == nil {
+		t.Fatal("Commit() hid the failed compaction")
+	}
+	if err := os.Remove(path + recordStoreTempSuffix); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	if err := store.Commit([]recordOp{{Bucket: "tasks", Key: "2", Value: []byte(`{"Id":2}`)}}); err != nil {
+		t.Fatalf("Commit() after the failed compaction error = %v", err)
+	}
+	_ = store.Close()
+
+	reopened, err := openRecordStore(path)
+	if err != nil {
+		t.Fatalf("reopen error = %v", err)
+	}
+	defer func() { _ = reopened.Close() }()
+	if bucket := reopened.Bucket("tasks"); len(bucket) != 2 || !bytes.Equal(bucket["1"], payload) {
+		t.Fatalf("bucket after the failed compaction = %d records, want 2", len(bucket))
+	}
+}
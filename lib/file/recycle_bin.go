@@ -0,0 +1,376 @@
+package file
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/djylb/nps/lib/common"
+)
+
+const (
+	DefaultRecycleBinPath      = "conf/trash.json"
+	DefaultRecycleBinRetention = 7 * 24 * time.Hour
+
+	TrashResourceClient = "client"
+	TrashResourceTunnel = "tunnel"
+	TrashResourceHost   = "host"
+)
+
+var (
+	ErrRecycleBinDisabled = errors.New("recycle bin is disabled")
+	ErrTrashEntryNotFound = errors.New("trash entry not found")
+
+	currentRecycleBin atomic.Pointer[RecycleBin]
+)
+
+// RecycleBinOptions configures where deleted records are kept and for how
+// long. Retention <= 0 disables the recycle bin so deletes are permanent.
+type RecycleBinOptions struct {
+	Path      string
+	Retention time.Duration
+}
+
+// ResolvePath returns the absolute recycle bin file for runPath.
+func (o RecycleBinOptions) ResolvePath(runPath string) string {
+	path := strings.TrimSpace(o.Path)
+	if path == "" {
+		path = DefaultRecycleBinPath
+	}
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(runPath, path)
+}
+
+// TrashEntry is one deleted resource. A client entry carries the tunnels and
+// hosts that were deleted together with it; tunnel and host entries carry a
+// single record whose Client field keeps the owning client id. DeletedAt and
+// ExpiresAt are unix seconds.
+type TrashEntry struct {
+	ID         int       `json:"id"`
+	Resource   string    `json:"resource"`
+	ResourceID int       `json:"resource_id"`
+	Remark     string    `json:"remark,omitempty"`
+	DeletedAt  int64     `json:"deleted_at"`
+	ExpiresAt  int64     `json:"expires_at"`
+	Client     *Client   `json:"client,omitempty"`
+	Tunnels    []*Tunnel `json:"tunnels,omitempty"`
+	Hosts      []*Host   `json:"hosts,omitempty"`
+}
+
+type recycleBinFile struct {
+	NextID  int           `json:"next_id"`
+	Entries []*TrashEntry `json:"entries"`
+}
+
+// RecycleBin keeps deleted clients, tunnels and hosts in a JSON file until
+// they are restored, purged or expire. Expired entries are dropped whenever
+// the bin is read or written.
+type RecycleBin struct {
+	mu        sync.Mutex
+	path      string
+	retention time.Duration
+	now       func() time.Time
+	nextID    int
+	entries   []*TrashEntry
+}
+
+// OpenRecycleBin loads the recycle bin stored at path, creating an empty one
+// when the file does not exist yet.
+func OpenRecycleBin(path string, options RecycleBinOptions) (*RecycleBin, error) {
+	b := &RecycleBin{
+		path:      path,
+		retention: options.Retention,
+		now:       time.Now,
+	}
+	if b.retention <= 0 {
+		b.retention = DefaultRecycleBinRetention
+	}
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(data) > 0 {
+		var stored recycleBinFile
+		if err := json.Unmarshal(data, &stored); err != nil {
+			return nil, fmt.Errorf("decode recycle bin %s: %w", path, err)
+		}
+		b.nextID = stored.NextID
+		for _, entry := range stored.Entries {
+			if entry == nil {
+				continue
+			}
+			b.entries = append(b.entries, entry)
+			if entry.ID > b.nextID {
+				b.nextID = entry.ID
+			}
+		}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, err := b.purgeExpiredLocked(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// ConfigureRecycleBin opens the recycle bin described by options and makes it
+// the one returned by CurrentRecycleBin. A non-positive Retention clears the
+// current bin and returns nil.
+func ConfigureRecycleBin(options RecycleBinOptions) (*RecycleBin, error) {
+	if options.Retention <= 0 {
+		currentRecycleBin.Store(nil)
+		return nil, nil
+	}
+	path := options.ResolvePath(common.GetRunPath())
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	bin, err := OpenRecycleBin(path, options)
+	if err != nil {
+		return nil, err
+	}
+	currentRecycleBin.Store(bin)
+	return bin, nil
+}
+
+// CurrentRecycleBin returns the active recycle bin, or nil when deletes are
+// permanent.
+func CurrentRecycleBin() *RecycleBin {
+	return currentRecycleBin.Load()
+}
+
+// ReplaceRecycleBin swaps the active recycle bin and returns the previous one.
+func ReplaceRecycleBin(bin *RecycleBin) *RecycleBin {
+	return currentRecycleBin.Swap(bin)
+}
+
+func (b *RecycleBin) Retention() time.Duration {
+	if b == nil {
+		return 0
+	}
+	return b.retention
+}
+
+// TrashClient stores client together with the tunnels and hosts it owned.
+func (b *RecycleBin) TrashClient(client *Client, tunnels []*Tunnel, hosts []*Host) (TrashEntry, error) {
+	if client == nil {
+		return TrashEntry{}, ErrClientNotFound
+	}
+	entry := &TrashEntry{
+		Resource:   TrashResourceClient,
+		ResourceID: client.Id,
+		Client:     cloneClientForConfig(client),
+	}
+	entry.Remark = entry.Client.Remark
+	for _, tunnel := range tunnels {
+		if tunnel != nil {
+			entry.Tunnels = append(entry.Tunnels, cloneTunnelForConfig(tunnel))
+		}
+	}
+	for _, host := range hosts {
+		if host != nil {
+			entry.Hosts = append(entry.Hosts, cloneHostForConfig(host))
+		}
+	}
+	return b.put(entry)
+}
+
+// TrashTunnel stores a single deleted tunnel.
+func (b *RecycleBin) TrashTunnel(tunnel *Tunnel) (TrashEntry, error) {
+	if tunnel == nil {
+		return TrashEntry{}, ErrTaskNotFound
+	}
+	cloned := cloneTunnelForConfig(tunnel)
+	return b.put(&TrashEntry{
+		Resource:   TrashResourceTunnel,
+		ResourceID: cloned.Id,
+		Remark:     cloned.Remark,
+		Tunnels:    []*Tunnel{cloned},
+	})
+}
+
+// TrashHost stores a single deleted host.
+func (b *RecycleBin) TrashHost(host *Host) (TrashEntry, error) {
+	if host == nil {
+		return TrashEntry{}, ErrHostNotFound
+	}
+	cloned := cloneHostForConfig(host)
+	remark := cloned.Remark
+	if remark == "" {
+		remark = cloned.Host
+	}
+	return b.put(&TrashEntry{
+		Resource:   TrashResourceHost,
+		ResourceID: cloned.Id,
+		Remark:     remark,
+		Hosts:      []*Host{cloned},
+	})
+}
+
+func (b *RecycleBin) put(entry *TrashEntry) (TrashEntry, error) {
+	if b == nil {
+		return TrashEntry{}, ErrRecycleBinDisabled
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+	b.nextID++
+	entry.ID = b.nextID
+	entry.DeletedAt = now.Unix()
+	entry.ExpiresAt = now.Add(b.retention).Unix()
+	b.entries = append(b.entries, entry)
+	if _, err := b.purgeExpiredLocked(); err != nil {
+		return TrashEntry{}, err
+	}
+	if err := b.persistLocked(); err != nil {
+		b.entries = b.entries[:len(b.entries)-1]
+		return TrashEntry{}, err
+	}
+	return cloneTrashEntry(entry)
+}
+
+// List returns the entries that have not expired, oldest first.
+func (b *RecycleBin) List() ([]TrashEntry, error) {
+	if b == nil {
+		return nil, ErrRecycleBinDisabled
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, err := b.purgeExpiredLocked(); err != nil {
+		return nil, err
+	}
+	items := make([]TrashEntry, 0, len(b.entries))
+	for _, entry := range b.entries {
+		cloned, err := cloneTrashEntry(entry)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, cloned)
+	}
+	return items, nil
+}
+
+// Get returns a detached copy of entry id.
+func (b *RecycleBin) Get(id int) (TrashEntry, error) {
+	if b == nil {
+		return TrashEntry{}, ErrRecycleBinDisabled
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, err := b.purgeExpiredLocked(); err != nil {
+		return TrashEntry{}, err
+	}
+	index := b.indexLocked(id)
+	if index < 0 {
+		return TrashEntry{}, ErrTrashEntryNotFound
+	}
+	return cloneTrashEntry(b.entries[index])
+}
+
+// Remove deletes entry id from the bin and returns it. It is used both to
+// purge an entry and to take it out once it has been restored.
+func (b *RecycleBin) Remove(id int) (TrashEntry, error) {
+	if b == nil {
+		return TrashEntry{}, ErrRecycleBinDisabled
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	index := b.indexLocked(id)
+	if index < 0 {
+		return TrashEntry{}, ErrTrashEntryNotFound
+	}
+	previous := b.entries
+	entry := previous[index]
+	b.entries = append(append([]*TrashEntry(nil), previous[:index]...), previous[index+1:]...)
+	if err := b.persistLocked(); err != nil {
+		b.entries = previous
+		return TrashEntry{}, err
+	}
+	return cloneTrashEntry(entry)
+}
+
+// PurgeExpired drops entries past their expiry and returns how many went.
+func (b *RecycleBin) PurgeExpired() (int, error) {
+	if b == nil {
+		return 0, ErrRecycleBinDisabled
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.purgeExpiredLocked()
+}
+
+func (b *RecycleBin) indexLocked(id int) int {
+	for i, entry := range b.entries {
+		if entry.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (b *RecycleBin) purgeExpiredLocked() (int, error) {
+	now := b.now().Unix()
+	kept := b.entries[:0]
+	removed := 0
+	for _, entry := range b.entries {
+		if entry.ExpiresAt > 0 && entry.ExpiresAt <= now {
+			removed++
+			continue
+		}
+		kept = append(kept, entry)
+	}
+	for i := len(kept); i < len(b.entries); i++ {
+		b.entries[i] = nil
+	}
+	b.entries = kept
+	if removed == 0 {
+		return 0, nil
+	}
+	return removed, b.persistLocked()
+}
+
+func (b *RecycleBin) persistLocked() (err error) {
+	entries := b.entries
+	if entries == nil {
+		entries = []*TrashEntry{}
+	}
+	data, err := json.Marshal(recycleBinFile{NextID: b.nextID, Entries: entries})
+	if err != nil {
+		return err
+	}
+	tmpPath := b.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o600); err != nil {
+		return fmt.Errorf("write recycle bin %s: %w", tmpPath, err)
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(tmpPath)
+		}
+	}()
+	if err := os.Rename(tmpPath, b.path); err != nil {
+		return fmt.Errorf("replace recycle bin %s: %w", b.path, err)
+	}
+	return nil
+}
+
+// cloneTrashEntry returns a deep copy through the stored JSON form, so the
+// caller can hand the records to the store without sharing state with the bin.
+func cloneTrashEntry(entry *TrashEntry) (TrashEntry, error) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return TrashEntry{}, err
+	}
+	var cloned TrashEntry
+	if err := json.Unmarshal(data, &cloned); err != nil {
+		return TrashEntry{}, err
+	}
+	return cloned, nil
+}
@@ -0,0 +1,82 @@
+package file
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRecycleBinKeepsClientWithOwnedResourcesAcrossReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trash.json")
+	clock := &stepClock{now: time.Now()}
+	bin, err := OpenRecycleBin(path, RecycleBinOptions{Retention: time.Hour})
+	if err != nil {
+		t.Fatalf("OpenRecycleBin() error = %v", err)
+	}
+	bin.now = clock.Now
+
+	client := &Client{Id: 3, OwnerUserID: 1, ManagerUserIDs: []int{2, 4}, VerifyKey: "vk", Remark: "edge", Cnf: &Config{}, Flow: &Flow{}, EntryAclMode: AclBlacklist, EntryAclRules: "10.0.0.1"}
+	tunnel := &Tunnel{Id: 7, Port: 8080, Mode: "tcp", Client: client, Flow: &Flow{}, Target: &Target{TargetStr: "127.0.0.1:80"}}
+	host := &Host{Id: 9, Host: "a.example.com", Client: client, Flow: &Flow{}, Target: &Target{TargetStr: "127.0.0.1:80"}}
+	entry, err := bin.TrashClient(client, []*Tunnel{tunnel}, []*Host{host})
+	if err != nil {
+		t.Fatalf("TrashClient() error = %v", err)
+	}
+	if entry.ID != 1 || entry.Resource != TrashResourceClient || entry.ResourceID != 3 || entry.ExpiresAt-entry.DeletedAt != 3600 {
+		t.Fatalf("TrashClient() entry = %+v", entry)
+	}
+	client.Remark = "changed after delete"
+
+	reopened, err := OpenRecycleBin(path, RecycleBinOptions{Retention: time.Hour})
+	if err != nil {
+		t.Fatalf("OpenRecycleBin(reopen) error = %v", err)
+	}
+	reopened.now = func() time.Time { return clock.now }
+	stored, err := reopened.Get(entry.ID)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if stored.Client == nil || stored.Client.Remark != "edge" || len(stored.Client.ManagerUserIDs) != 2 || stored.Client.EntryAclRules != "10.0.0.1" {
+		t.Fatalf("stored client = %+v, want the deleted client state", stored.Client)
+	}
+	if len(stored.Tunnels) != 1 || stored.Tunnels[0].Port != 8080 || len(stored.Hosts) != 1 || stored.Hosts[0].Host != "a.example.com" {
+		t.Fatalf("stored resources = %+v / %+v", stored.Tunnels, stored.Hosts)
+	}
+
+	next, err := reopened.TrashHost(&Host{Id: 10, Host: "b.example.com", Client: client})
+	if err != nil || next.ID != 2 || next.Remark != "b.example.com" {
+		t.Fatalf("TrashHost() = %+v, %v, want id 2 after reopen", next, err)
+	}
+	if _, err := reopened.Remove(entry.ID); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	if _, err := reopened.Get(entry.ID); !errors.Is(err, ErrTrashEntryNotFound) {
+		t.Fatalf("Get(removed) error = %v, want ErrTrashEntryNotFound", err)
+	}
+}
+
+func TestRecycleBinDropsExpiredEntries(t *testing.T) {
+	clock := &stepClock{now: time.Unix(1_700_000_000, 0)}
+	bin, err := OpenRecycleBin(filepath.Join(t.TempDir(), "trash.json"), RecycleBinOptions{Retention: time.Minute})
+	if err != nil {
+		t.Fatalf("OpenRecycleBin() error = %v", err)
+	}
+	bin.now = clock.Now
+
+	if _, err := bin.TrashTunnel(&Tunnel{Id: 1, Mode: "tcp", Client: &Client{Id: 2}}); err != nil {
+		t.Fatalf("TrashTunnel() error = %v", err)
+	}
+	items, err := bin.List()
+	if err != nil || len(items) != 1 {
+		t.Fatalf("List() = %+v, %v, want one entry", items, err)
+	}
+
+	clock.now = clock.now.Add(2 * time.Minute)
+	if removed, err := bin.PurgeExpired(); err != nil || removed != 1 {
+		t.Fatalf("PurgeExpired() = %d, %v, want 1", removed, err)
+	}
+	if items, _ = bin.List(); len(items) != 0 {
+		t.Fatalf("List() after expiry = %+v, want empty", items)
+	}
+}
@@ -0,0 +1,498 @@
+package file
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/djylb/nps/lib/common"
+)
+
+const (
+	DefaultRevisionHistoryPath = "conf/history"
+	DefaultRevisionHistoryKeep = 20
+)
+
+var (
+	ErrRevisionHistoryDisabled = errors.New("revision history is disabled")
+	ErrRevisionNotFound        = errors.New("revision not found")
+
+	currentRevisionHistory atomic.Pointer[RevisionHistory]
+)
+
+// RevisionHistoryOptions configures where past revisions are kept and how
+// many are kept per record. Keep <= 0 disables the history.
+type RevisionHistoryOptions struct {
+	Path string
+	Keep int
+}
+
+// ResolvePath returns the absolute history directory for runPath.
+func (o RevisionHistoryOptions) ResolvePath(runPath string) string {
+	path := strings.TrimSpace(o.Path)
+	if path == "" {
+		path = DefaultRevisionHistoryPath
+	}
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(runPath, path)
+}
+
+// RevisionActor identifies who made a change through the management API.
+type RevisionActor struct {
+	Kind      string `json:"kind"`
+	SubjectID string `json:"subject_id,omitempty"`
+	Username  string `json:"username,omitempty"`
+	IsAdmin   bool   `json:"is_admin"`
+}
+
+// RevisionAttribution describes the request behind one or more revisions.
+type RevisionAttribution struct {
+	Action    string         `json:"action"`
+	Actor     *RevisionActor `json:"actor,omitempty"`
+	NodeID    string         `json:"node_id,omitempty"`
+	RequestID string         `json:"request_id,omitempty"`
+	Source    string         `json:"source,omitempty"`
+}
+
+// RevisionEntry is one stored version of a record. Record holds the record in
+// the same shape as a ConfigSnapshot item and is empty for the entry that
+// marks a delete. RecordedAt is unix seconds.
+type RevisionEntry struct {
+	Revision   int64 `json:"revision"`
+	UpdatedAt  int64 `json:"updated_at"`
+	RecordedAt int64 `json:"recorded_at"`
+	Deleted    bool  `json:"deleted,omitempty"`
+	RevisionAttribution
+	Record json.RawMessage `json:"record,omitempty"`
+}
+
+// RevisionFieldChange is one field that differs between two revisions. Field
+// is the dotted path in the stored record. Secret fields only report that
+// they changed.
+type RevisionFieldChange struct {
+	Field    string      `json:"field"`
+	Before   interface{} `json:"before,omitempty"`
+	After    interface{} `json:"after,omitempty"`
+	Redacted bool        `json:"redacted,omitempty"`
+}
+
+// RevisionHistory keeps the last revisions of every user, client, tunnel and
+// host in one small JSON file per record. Entries are written by the same
+// store hooks as the change journal and attributed afterwards by the
+// management API, which is the layer that knows the actor. The API names
+// the exact revision its mutation wrote, so other writers are never credited
+// to it.
+type RevisionHistory struct {
+	mu   sync.Mutex
+	dir  string
+	keep int
+	now  func() time.Time
+}
+
+// OpenRevisionHistory uses dir for history files, creating it when needed.
+func OpenRevisionHistory(dir string, options RevisionHistoryOptions) (*RevisionHistory, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	keep := options.Keep
+	if keep <= 0 {
+		keep = DefaultRevisionHistoryKeep
+	}
+	return &RevisionHistory{dir: dir, keep: keep, now: time.Now}, nil
+}
+
+// ConfigureRevisionHistory opens the history described by options and makes
+// it the one returned by CurrentRevisionHistory. A non-positive Keep clears
+// the current history and returns nil.
+func ConfigureRevisionHistory(options RevisionHistoryOptions) (*RevisionHistory, error) {
+	if options.Keep <= 0 {
+		currentRevisionHistory.Store(nil)
+		return nil, nil
+	}
+	history, err := OpenRevisionHistory(options.ResolvePath(common.GetRunPath()), options)
+	if err != nil {
+		return nil, err
+	}
+	currentRevisionHistory.Store(history)
+	return history, nil
+}
+
+// CurrentRevisionHistory returns the active history, or nil when disabled.
+func CurrentRevisionHistory() *RevisionHistory {
+	return currentRevisionHistory.Load()
+}
+
+// ReplaceRevisionHistory swaps the active history and returns the previous one.
+func ReplaceRevisionHistory(history *RevisionHistory) *RevisionHistory {
+	return currentRevisionHistory.Swap(history)
+}
+
+func (h *RevisionHistory) Dir() string {
+	if h == nil {
+		return ""
+	}
+	return h.dir
+}
+
+func (h *RevisionHistory) Keep() int {
+	if h == nil {
+		return 0
+	}
+	return h.keep
+}
+
+func (h *RevisionHistory) RecordUser(user *User) error {
+	if h == nil || user == nil {
+		return nil
+	}
+	cloned := cloneUserForConfig(user)
+	return h.recordPut(JournalResourceUser, cloned.Id, cloned.Revision, cloned.UpdatedAt, cloned)
+}
+
+func (h *RevisionHistory) RecordClient(client *Client) error {
+	if h == nil || client == nil || !isPersistableRecord(client) {
+		return nil
+	}
+	cloned := cloneClientForConfig(client)
+	return h.recordPut(JournalResourceClient, cloned.Id, cloned.Revision, cloned.UpdatedAt, cloned)
+}
+
+func (h *RevisionHistory) RecordTunnel(tunnel *Tunnel) error {
+	if h == nil || tunnel == nil || !isPersistableRecord(tunnel) {
+		return nil
+	}
+	cloned := cloneTunnelForConfig(tunnel)
+	cloned.Client = revisionClientRef(cloned.Client)
+	return h.recordPut(JournalResourceTunnel, cloned.Id, cloned.Revision, cloned.UpdatedAt, cloned)
+}
+
+func (h *RevisionHistory) RecordHost(host *Host) error {
+	if h == nil || host == nil || !isPersistableRecord(host) {
+		return nil
+	}
+	cloned := cloneHostForConfig(host)
+	cloned.Client = revisionClientRef(cloned.Client)
+	return h.recordPut(JournalResourceHost, cloned.Id, cloned.Revision, cloned.UpdatedAt, cloned)
+}
+
+// RecordDelete marks the record as deleted. Earlier revisions stay so the
+// history still shows what the record looked like and who removed it.
+func (h *RevisionHistory) RecordDelete(resource string, id int) error {
+	if h == nil || id <= 0 {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	entries, err := h.readLocked(resource, id)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 || entries[len(entries)-1].Deleted {
+		return nil
+	}
+	last := entries[len(entries)-1]
+	entries = append(entries, RevisionEntry{
+		Revision:   last.Revision,
+		UpdatedAt:  h.now().Unix(),
+		RecordedAt: h.now().Unix(),
+		Deleted:    true,
+	})
+	return h.writeLocked(resource, id, entries)
+}
+
+func (h *RevisionHistory) recordPut(resource string, id int, revision, updatedAt int64, value interface{}) error {
+	if id <= 0 {
+		return nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	entries, err := h.readLocked(resource, id)
+	if err != nil {
+		return err
+	}
+	if n := len(entries); n > 0 && !entries[n-1].Deleted && entries[n-1].Revision == revision {
+		// Same revision re-read after a runtime call: refresh the stored
+		// record but keep the attribution already on it.
+		if bytes.Equal(entries[n-1].Record, data) {
+			return nil
+		}
+		entries[n-1].Record = data
+		return h.writeLocked(resource, id, entries)
+	}
+	entries = append(entries, RevisionEntry{
+		Revision:   revision,
+		UpdatedAt:  updatedAt,
+		RecordedAt: h.now().Unix(),
+		Record:     data,
+	})
+	if len(entries) > h.keep {
+		entries = append([]RevisionEntry(nil), entries[len(entries)-h.keep:]...)
+	}
+	return h.writeLocked(resource, id, entries)
+}
+
+// RevisionRef names the revision a management request wrote for a record.
+type RevisionRef struct {
+	ID       int
+	Revision int64
+}
+
+// Attribute stamps attribution on the newest entry of a record at revision,
+// which is the revision the request that just finished wrote. For a delete
+// that is the delete marker. Entries written by anything else, such as
+// client config sync or a concurrent request, are left as they are.
+func (h *RevisionHistory) Attribute(resource string, ref RevisionRef, attribution RevisionAttribution) error {
+	if h == nil || ref.ID <= 0 || ref.Revision <= 0 {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	entries, err := h.readLocked(resource, ref.ID)
+	if err != nil {
+		return err
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].Revision != ref.Revision {
+			continue
+		}
+		if entries[i].Action != "" {
+			return nil
+		}
+		entries[i].RevisionAttribution = attribution
+		return h.writeLocked(resource, ref.ID, entries)
+	}
+	return nil
+}
+
+// List returns the kept entries of a record, oldest first.
+func (h *RevisionHistory) List(resource string, id int) ([]RevisionEntry, error) {
+	if h == nil {
+		return nil, ErrRevisionHistoryDisabled
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.readLocked(resource, id)
+}
+
+// Get returns the stored version of a record at revision.
+func (h *RevisionHistory) Get(resource string, id int, revision int64) (RevisionEntry, error) {
+	entries, err := h.List(resource, id)
+	if err != nil {
+		return RevisionEntry{}, err
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].Revision == revision && !entries[i].Deleted {
+			return entries[i], nil
+		}
+	}
+	return RevisionEntry{}, ErrRevisionNotFound
+}
+
+func (h *RevisionHistory) path(resource string, id int) string {
+	return filepath.Join(h.dir, resource, strconv.Itoa(id)+".json")
+}
+
+func (h *RevisionHistory) readLocked(resource string, id int) ([]RevisionEntry, error) {
+	data, err := os.ReadFile(h.path(resource, id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var entries []RevisionEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("decode revision history %s %d: %w", resource, id, err)
+	}
+	return entries, nil
+}
+
+func (h *RevisionHistory) writeLocked(resource string, id int, entries []RevisionEntry) (err error) {
+	path := h.path(resource, id)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o600); err != nil {
+		return fmt.Errorf("write revision history %s: %w", tmpPath, err)
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(tmpPath)
+		}
+	}()
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("replace revision history %s: %w", path, err)
+	}
+	return nil
+}
+
+// revisionClientRef keeps only the identity of the owning client, so a
+// tunnel or host revision does not change whenever its client does.
+func revisionClientRef(client *Client) *Client {
+	if client == nil {
+		return nil
+	}
+	return &Client{Id: client.Id, VerifyKey: client.VerifyKey}
+}
+
+// revisionNoiseFields are counters and connection state that move without a
+// management change and would drown the real differences.
+var revisionNoiseFields = map[string]bool{
+	"Revision":              true,
+	"UpdatedAt":             true,
+	"ExportFlow":            true,
+	"InletFlow":             true,
+	"Flow.ExportFlow":       true,
+	"Flow.InletFlow":        true,
+	"TotalFlow":             true,
+	"TotalTraffic":          true,
+	"BridgeTraffic":         true,
+	"ServiceTraffic":        true,
+	"QuotaPeriod.Carry":     true,
+	"QuotaPeriod.LastReset": true,
+	"NowConn":               true,
+	"IsConnect":             true,
+	"RunStatus":             true,
+	"Addr":                  true,
+	"LocalAddr":             true,
+	"Version":               true,
+	"LastOnlineTime":        true,
+	"HealthNextTime":        true,
+	"HealthMap":             true,
+	"HealthRemoveArr":       true,
+	"Target.TargetArr":      true,
+}
+
+// revisionSecretFields never have their values shown in a diff.
+var revisionSecretFields = map[string]bool{
+	"Password":     true,
+	"TOTPSecret":   true,
+	"Cnf.P":        true,
+	"UserAuth":     true,
+	"MultiAccount": true,
+}
+
+// DiffRevisionRecords lists the fields that differ between two stored
+// records, sorted by field. A nil before compares against an empty record.
+func DiffRevisionRecords(before, after json.RawMessage) ([]RevisionFieldChange, error) {
+	left, err := flattenRevisionRecord(before)
+	if err != nil {
+		return nil, err
+	}
+	right, err := flattenRevisionRecord(after)
+	if err != nil {
+		return nil, err
+	}
+	fields := make(map[string]struct{}, len(left)+len(right))
+	for field := range left {
+		fields[field] = struct{}{}
+	}
+	for field := range right {
+		fields[field] = struct{}{}
+	}
+	changes := make([]RevisionFieldChange, 0)
+	for field := range fields {
+		oldValue, newValue := left[field], right[field]
+		if jsonValuesEqual(oldValue, newValue) {
+			continue
+		}
+		change := RevisionFieldChange{Field: field}
+		if revisionFieldMatches(revisionSecretFields, field) {
+			change.Redacted = true
+		} else {
+			change.Before, change.After = oldValue, newValue
+		}
+		changes = append(changes, change)
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes, nil
+}
+
+func flattenRevisionRecord(data json.RawMessage) (map[string]interface{}, error) {
+	flat := make(map[string]interface{})
+	if len(data) == 0 {
+		return flat, nil
+	}
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+	var walk func(prefix string, value interface{})
+	walk = func(prefix string, value interface{}) {
+		if prefix != "" && revisionFieldMatches(revisionNoiseFields, prefix) {
+			return
+		}
+		if object, ok := value.(map[string]interface{}); ok && len(object) > 0 {
+			for key, child := range object {
+				if prefix != "" {
+					key = prefix + "." + key
+				}
+				walk(key, child)
+			}
+			return
+		}
+		if prefix != "" && !isEmptyJSONValue(value) {
+			flat[prefix] = value
+		}
+	}
+	walk("", value)
+	return flat, nil
+}
+
+// revisionFieldMatches reports whether field or one of its parents is in set.
+func revisionFieldMatches(set map[string]bool, field string) bool {
+	for {
+		if set[field] {
+			return true
+		}
+		index := strings.LastIndexByte(field, '.')
+		if index < 0 {
+			return false
+		}
+		field = field[:index]
+	}
+}
+
+func isEmptyJSONValue(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case string:
+		return v == ""
+	case bool:
+		return !v
+	case float64:
+		return v == 0
+	case []interface{}:
+		return len(v) == 0
+	case map[string]interface{}:
+		return len(v) == 0
+	}
+	return false
+}
+
+func jsonValuesEqual(left, right interface{}) bool {
+	leftData, _ := json.Marshal(left)
+	rightData, _ := json.Marshal(right)
+	return bytes.Equal(leftData, rightData)
+}
@@ -0,0 +1,111 @@
+package file
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRevisionHistoryKeepsAttributedRevisionsAndDeletes(t *testing.T) {
+	history, err := OpenRevisionHistory(t.TempDir(), RevisionHistoryOptions{Keep: 3})
+	if err != nil {
+		t.Fatalf("OpenRevisionHistory() error = %v", err)
+	}
+	client := &Client{Id: 2, VerifyKey: "vk", Remark: "edge"}
+	host := &Host{Id: 5, Host: "a.example.com", Client: client, Flow: &Flow{}, Target: &Target{TargetStr: "10.0.0.1:80"}, Revision: 1}
+	if err := history.RecordHost(host); err != nil {
+		t.Fatalf("RecordHost() error = %v", err)
+	}
+	admin := RevisionAttribution{Action: "create", Actor: &RevisionActor{Kind: "admin", Username: "admin", IsAdmin: true}, RequestID: "req-1"}
+	if err := history.Attribute(JournalResourceHost, RevisionRef{ID: 5, Revision: 1}, admin); err != nil {
+		t.Fatalf("Attribute() error = %v", err)
+	}
+
+	host.Target.TargetStr = "10.0.0.2:80"
+	host.Flow.ExportFlow = 4096
+	host.Revision = 2
+	if err := history.RecordHost(host); err != nil {
+		t.Fatalf("RecordHost(rev 2) error = %v", err)
+	}
+	host.Remark = "same revision, refreshed by a runtime call"
+	if err := history.RecordHost(host); err != nil {
+		t.Fatalf("RecordHost(rev 2 again) error = %v", err)
+	}
+	if err := history.Attribute(JournalResourceHost, RevisionRef{ID: 5, Revision: 2}, RevisionAttribution{Action: "update", Actor: &RevisionActor{Kind: "user", Username: "bob"}}); err != nil {
+		t.Fatalf("Attribute(update) error = %v", err)
+	}
+
+	entries, err := history.List(JournalResourceHost, 5)
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("List() = %+v, %v, want 2 entries", entries, err)
+	}
+	if entries[0].Action != "create" || entries[0].RequestID != "req-1" || entries[1].Action != "update" || entries[1].Actor.Username != "bob" {
+		t.Fatalf("attribution = %+v / %+v", entries[0].RevisionAttribution, entries[1].RevisionAttribution)
+	}
+	changes, err := DiffRevisionRecords(entries[0].Record, entries[1].Record)
+	if err != nil {
+		t.Fatalf("DiffRevisionRecords() error = %v", err)
+	}
+	if len(changes) != 2 || changes[0].Field != "Remark" || changes[1].Field != "Target.TargetStr" ||
+		changes[1].Before != "10.0.0.1:80" || changes[1].After != "10.0.0.2:80" {
+		t.Fatalf("DiffRevisionRecords() = %+v, want remark and target only", changes)
+	}
+
+	// Revision 3 comes from client config sync; a later request must not be
+	// credited with it.
+	host.Revision = 3
+	if err := history.RecordHost(host); err != nil {
+		t.Fatalf("RecordHost(rev 3) error = %v", err)
+	}
+	if err := history.Attribute(JournalResourceHost, RevisionRef{ID: 5, Revision: 2}, RevisionAttribution{Action: "update", Actor: &RevisionActor{Kind: "user", Username: "eve"}}); err != nil {
+		t.Fatalf("Attribute(rev 2 again) error = %v", err)
+	}
+	if err := history.RecordDelete(JournalResourceHost, 5); err != nil {
+		t.Fatalf("RecordDelete() error = %v", err)
+	}
+	if err := history.Attribute(JournalResourceHost, RevisionRef{ID: 5, Revision: 3}, RevisionAttribution{Action: "delete"}); err != nil {
+		t.Fatalf("Attribute(delete) error = %v", err)
+	}
+	entries, _ = history.List(JournalResourceHost, 5)
+	if len(entries) != 4 {
+		t.Fatalf("List() after sync and delete = %d entries, want 4", len(entries))
+	}
+	if entries[1].Actor.Username != "bob" || entries[2].Action != "" || !entries[3].Deleted || entries[3].Action != "delete" {
+		t.Fatalf("attribution after sync and delete = %+v / %+v / %+v", entries[1].RevisionAttribution, entries[2].RevisionAttribution, entries[3].RevisionAttribution)
+	}
+	if _, err := history.Get(JournalResourceHost, 5, 2); err != nil {
+		t.Fatalf("Get(rev 2 after delete) error = %v", err)
+	}
+	if _, err := history.Get(JournalResourceHost, 5, 9); !errors.Is(err, ErrRevisionNotFound) {
+		t.Fatalf("Get(missing) error = %v, want ErrRevisionNotFound", err)
+	}
+
+	for revision := int64(3); revision <= 6; revision++ {
+		host.Revision = revision
+		if err := history.RecordHost(host); err != nil {
+			t.Fatalf("RecordHost(rev %d) error = %v", revision, err)
+		}
+	}
+	entries, _ = history.List(JournalResourceHost, 5)
+	if len(entries) != 3 || entries[0].Revision != 4 || entries[2].Revision != 6 {
+		t.Fatalf("List() after trim = %+v, want revisions 4..6", entries)
+	}
+}
+
+func TestDiffRevisionRecordsRedactsSecrets(t *testing.T) {
+	changes, err := DiffRevisionRecords(
+		[]byte(`{"Password":"old","Cnf":{"U":"a","P":"x"},"NowConn":1}`),
+		[]byte(`{"Password":"new","Cnf":{"U":"b","P":"y"},"NowConn":3}`),
+	)
+	if err != nil {
+		t.Fatalf("DiffRevisionRecords() error = %v", err)
+	}
+	if len(changes) != 3 {
+		t.Fatalf("DiffRevisionRecords() = %+v, want Cnf.P, Cnf.U and Password", changes)
+	}
+	for _, change := range changes {
+		secret := change.Field != "Cnf.U"
+		if change.Redacted != secret || (secret && (change.Before != nil || change.After != nil)) {
+			t.Fatalf("change %+v, redacted should be %v", change, secret)
+		}
+	}
+}
@@ -384,6 +384,7 @@ func (t *Tunnel) runtimeRouteViewLocked() *Tunnel {
 		Ports:              t.Ports,
 		ExpireAt:           t.ExpireAt,
 		FlowLimit:          t.FlowLimit,
+		QuotaPeriod:        t.QuotaPeriod,
 		RateLimit:          t.RateLimit,
 		Flow:               t.Flow,
 		Rate:               t.Rate,
@@ -393,6 +394,7 @@ func (t *Tunnel) runtimeRouteViewLocked() *Tunnel {
 		NowConn:            t.runtimeConnValue(),
 		Password:           t.Password,
 		Remark:             t.Remark,
+		Labels:             t.Labels,
 		TargetAddr:         t.TargetAddr,
 		TargetType:         t.TargetType,
 		EntryAclMode:       t.EntryAclMode,
@@ -409,6 +411,7 @@ func (t *Tunnel) runtimeRouteViewLocked() *Tunnel {
 		Socks5Proxy:        t.Socks5Proxy,
 		LocalPath:          t.LocalPath,
 		StripPre:           t.StripPre,
+		ForwardAuth:        t.ForwardAuth,
 		ReadOnly:           t.ReadOnly,
 		Target:             t.Target,
 		UserAuth:           t.UserAuth,
@@ -598,6 +601,7 @@ func (h *Host) runtimeRouteViewLocked() *Host {
 		Location:           h.Location,
 		PathRewrite:        h.PathRewrite,
 		Remark:             h.Remark,
+		Labels:             h.Labels,
 		Scheme:             h.Scheme,
 		RedirectURL:        h.RedirectURL,
 		HttpsJustProxy:     h.HttpsJustProxy,
@@ -611,9 +615,38 @@ func (h *Host) runtimeRouteViewLocked() *Host {
 		IsClose:            h.IsClose,
 		AutoHttps:          h.AutoHttps,
 		AutoCORS:           h.AutoCORS,
+		RespCompress:       h.RespCompress,
+		RespCompressTypes:  h.RespCompressTypes,
+		RespCompressMin:    h.RespCompressMin,
+		Cache:              h.Cache,
+		CacheTTL:           h.CacheTTL,
+		ReqLimit:           h.ReqLimit,
+		ReqLimitWindow:     h.ReqLimitWindow,
+		ReqLimitKey:        h.ReqLimitKey,
+		ReqLimitPaths:      h.ReqLimitPaths,
+		ForwardAuth:        h.ForwardAuth,
+		ForwardAuthHeaders: h.ForwardAuthHeaders,
+		TLSMinVersion:      h.TLSMinVersion,
+		TLSCipherProfile:   h.TLSCipherProfile,
+		TLSALPN:            h.TLSALPN,
+		HSTS:               h.HSTS,
+		CustomDomain:       h.CustomDomain,
+		CertID:             h.CertID,
+		AutoSSLDNS:         h.AutoSSLDNS,
+		ReqBodyRewrite:     h.ReqBodyRewrite,
+		RespBodyRewrite:    h.RespBodyRewrite,
+		ClientCertCA:       h.ClientCertCA,
+		ClientCertMode:     h.ClientCertMode,
+		ClientCertSubjects: h.ClientCertSubjects,
+		AuthGate:           h.AuthGate,
+		AuthGateEmails:     h.AuthGateEmails,
+		AuthGateGroups:     h.AuthGateGroups,
+		WafRules:           h.WafRules,
+		ReqLimiter:         h.ReqLimiter,
 		CompatMode:         h.CompatMode,
 		ExpireAt:           h.ExpireAt,
 		FlowLimit:          h.FlowLimit,
+		QuotaPeriod:        h.QuotaPeriod,
 		RateLimit:          h.RateLimit,
 		Flow:               h.Flow,
 		Rate:               h.Rate,
@@ -625,6 +658,7 @@ func (h *Host) runtimeRouteViewLocked() *Host {
 		EntryAclMode:       h.EntryAclMode,
 		EntryAclRules:      h.EntryAclRules,
 		entryPolicy:        h.entryPolicy,
+		wafRules:           h.wafRules,
 		TargetIsHttps:      h.TargetIsHttps,
 		Target:             h.Target,
 		UserAuth:           h.UserAuth,
@@ -752,7 +786,11 @@ func normalizedTargetSource(targetStr string) string {
 }
 
 func parseNormalizedTargetEntries(normalized string) []string {
-	return common.TrimArr(strings.Split(normalized, "\n"))
+	entries := common.TrimArr(strings.Split(normalized, "\n"))
+	for i, entry := range entries {
+		entries[i], _ = splitTargetWeight(entry)
+	}
+	return entries
 }
 
 func normalizeRuntimeTunnelOwnerSnapshot(update *Tunnel) {
@@ -842,14 +880,19 @@ func (s *Target) refreshConfiguredTargetsLocked() {
 }
 
 func (s *Target) GetRandomTarget() (string, error) {
-	return s.getNextTarget("")
+	return s.getNextTarget(TargetHint{})
 }
 
 func (s *Target) GetRouteTarget(routeKey string) (string, error) {
-	return s.getNextTarget(routeKey)
+	return s.getNextTarget(TargetHint{RouteKey: routeKey})
+}
+
+// PickTarget picks a target with the configured balance strategy.
+func (s *Target) PickTarget(hint TargetHint) (string, error) {
+	return s.getNextTarget(hint)
 }
 
-func (s *Target) getNextTarget(routeKey string) (string, error) {
+func (s *Target) getNextTarget(hint TargetHint) (string, error) {
 	if s == nil {
 		return "", errors.New("all inward-bending targets are offline")
 	}
@@ -864,15 +907,7 @@ func (s *Target) getNextTarget(routeKey string) (string, error) {
 	if len(s.TargetArr) == 0 {
 		return "", errors.New("all inward-bending targets are offline")
 	}
-
-	if s.nowIndex < 0 {
-		s.nowIndex = routeTargetOffset(routeKey, len(s.TargetArr)) - 1
-	}
-	if s.nowIndex >= len(s.TargetArr)-1 {
-		s.nowIndex = -1
-	}
-	s.nowIndex++
-	return s.TargetArr[s.nowIndex], nil
+	return s.pickLocked(hint), nil
 }
 
 func routeTargetOffset(routeKey string, size int) int {
@@ -909,6 +944,8 @@ func cloneTargetSnapshot(target *Target) *Target {
 		TargetArr:       append([]string(nil), target.TargetArr...),
 		LocalProxy:      target.LocalProxy,
 		ProxyProtocol:   target.ProxyProtocol,
+		Balance:         target.Balance,
+		BalanceKey:      target.BalanceKey,
 		targetArrSource: target.targetArrSource,
 	}
 }
@@ -0,0 +1,309 @@
+package file
+
+import (
+	"hash/fnv"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Target balance strategies. The empty strategy is round-robin; entries with
+// a weight suffix such as "127.0.0.1:80#w=3" make it weighted.
+const (
+	TargetBalanceRoundRobin = "round_robin"
+	TargetBalanceLeastConn  = "least_conn"
+	TargetBalanceHashIP     = "hash_ip"
+	TargetBalanceHashHeader = "hash_header"
+	TargetBalanceHashCookie = "hash_cookie"
+)
+
+const (
+	targetWeightMax        = 100
+	targetRingPointsWeight = 40
+)
+
+// TargetHint carries what the balance strategies look at when a target is
+// picked. Request is nil outside HTTP.
+type TargetHint struct {
+	RouteKey   string
+	RemoteAddr string
+	Request    *http.Request
+}
+
+type targetRingPoint struct {
+	hash uint32
+	addr string
+}
+
+// NormalizeTargetBalance returns the stored form of a strategy and its key.
+// Unknown strategies fall back to round-robin and the header and cookie
+// strategies without a name fall back to hashing the client IP.
+func NormalizeTargetBalance(balance, key string) (string, string) {
+	balance = strings.ToLower(strings.TrimSpace(balance))
+	key = strings.TrimSpace(key)
+	switch balance {
+	case TargetBalanceLeastConn, TargetBalanceHashIP:
+		return balance, ""
+	case TargetBalanceHashHeader:
+		if key == "" {
+			return TargetBalanceHashIP, ""
+		}
+		return balance, http.CanonicalHeaderKey(key)
+	case TargetBalanceHashCookie:
+		if key == "" {
+			return TargetBalanceHashIP, ""
+		}
+		return balance, key
+	default:
+		return "", ""
+	}
+}
+
+func (s *Target) normalizeBalance() {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	s.Balance, s.BalanceKey = NormalizeTargetBalance(s.Balance, s.BalanceKey)
+	s.Unlock()
+}
+
+// splitTargetWeight separates the "#w=N" suffix of a target entry.
+func splitTargetWeight(entry string) (string, int) {
+	index := strings.LastIndex(entry, "#")
+	if index < 0 {
+		return entry, 1
+	}
+	suffix := strings.ToLower(strings.TrimSpace(entry[index+1:]))
+	if !strings.HasPrefix(suffix, "w=") {
+		return entry, 1
+	}
+	weight, err := strconv.Atoi(strings.TrimPrefix(suffix, "w="))
+	if err != nil {
+		return entry, 1
+	}
+	weight = max(1, min(weight, targetWeightMax))
+	return strings.TrimSpace(entry[:index]), weight
+}
+
+func parseTargetWeights(normalized string) map[string]int {
+	weights := make(map[string]int)
+	for _, entry := range strings.Split(normalized, "\n") {
+		if addr, weight := splitTargetWeight(strings.TrimSpace(entry)); weight > 1 {
+			weights[addr] = weight
+		}
+	}
+	return weights
+}
+
+func (s *Target) ensureWeightsLocked() {
+	if s.weights == nil || s.weightSource != s.targetArrSource {
+		s.weights = parseTargetWeights(s.targetArrSource)
+		s.weightSource = s.targetArrSource
+	}
+}
+
+func (s *Target) weightLocked(addr string) int {
+	s.ensureWeightsLocked()
+	if weight, ok := s.weights[addr]; ok {
+		return weight
+	}
+	return 1
+}
+
+func (s *Target) weightedLocked() bool {
+	s.ensureWeightsLocked()
+	return len(s.weights) > 0
+}
+
+// AcquireTarget counts a connection to addr for the least-connections
+// strategy. The returned func ends it and may be called more than once.
+func (s *Target) AcquireTarget(addr string) func() {
+	if s == nil || addr == "" {
+		return func() {}
+	}
+	s.Lock()
+	if s.Balance != TargetBalanceLeastConn {
+		s.Unlock()
+		return func() {}
+	}
+	if s.active == nil {
+		s.active = make(map[string]int64)
+	}
+	s.active[addr]++
+	s.Unlock()
+	released := false
+	return func() {
+		s.Lock()
+		defer s.Unlock()
+		if released {
+			return
+		}
+		released = true
+		if s.active[addr] <= 1 {
+			delete(s.active, addr)
+			return
+		}
+		s.active[addr]--
+	}
+}
+
+// ActiveTargetConns reports the connections counted by AcquireTarget.
+func (s *Target) ActiveTargetConns(addr string) int64 {
+	if s == nil {
+		return 0
+	}
+	s.RLock()
+	defer s.RUnlock()
+	return s.active[addr]
+}
+
+func (s *Target) pickLocked(hint TargetHint) string {
+	return s.pickFromLocked(s.availableTargetsLocked(time.Now()), hint)
+}
+
+func (s *Target) pickFromLocked(addrs []string, hint TargetHint) string {
+	switch s.Balance {
+	case TargetBalanceLeastConn:
+		return s.pickLeastConnLocked(addrs)
+	case TargetBalanceHashIP, TargetBalanceHashHeader, TargetBalanceHashCookie:
+		if key := s.hashKey(hint); key != "" {
+			return s.pickHashLocked(addrs, key)
+		}
+	}
+	if s.weightedLocked() {
+		return s.pickWeightedLocked(addrs)
+	}
+	return s.pickRoundRobinLocked(addrs, hint.RouteKey)
+}
+
+func (s *Target) pickRoundRobinLocked(addrs []string, routeKey string) string {
+	if s.nowIndex < 0 {
+		s.nowIndex = routeTargetOffset(routeKey, len(addrs)) - 1
+	}
+	if s.nowIndex >= len(addrs)-1 {
+		s.nowIndex = -1
+	}
+	s.nowIndex++
+	return addrs[s.nowIndex]
+}
+
+// pickWeightedLocked is the smooth weighted round-robin used by nginx: it
+// spreads the picks of a heavy target instead of sending them in a burst.
+func (s *Target) pickWeightedLocked(addrs []string) string {
+	if s.current == nil {
+		s.current = make(map[string]int)
+	}
+	total, best := 0, ""
+	for _, addr := range addrs {
+		weight := s.weightLocked(addr)
+		total += weight
+		s.current[addr] += weight
+		if best == "" || s.current[addr] > s.current[best] {
+			best = addr
+		}
+	}
+	s.current[best] -= total
+	if len(s.current) > len(addrs) {
+		for addr := range s.current {
+			if !containsTargetAddr(addrs, addr) {
+				delete(s.current, addr)
+			}
+		}
+	}
+	return best
+}
+
+// pickLeastConnLocked picks the target with the fewest active connections
+// per unit of weight, starting after the last pick so ties rotate.
+func (s *Target) pickLeastConnLocked(addrs []string) string {
+	size := len(addrs)
+	start := s.nowIndex + 1
+	bestIndex := -1
+	var bestActive int64
+	bestWeight := 1
+	for i := 0; i < size; i++ {
+		index := (start + i) % size
+		if index < 0 {
+			index += size
+		}
+		addr := addrs[index]
+		active, weight := s.active[addr], s.weightLocked(addr)
+		if bestIndex < 0 || active*int64(bestWeight) < bestActive*int64(weight) {
+			bestIndex, bestActive, bestWeight = index, active, weight
+		}
+	}
+	s.nowIndex = bestIndex
+	return addrs[bestIndex]
+}
+
+func (s *Target) pickHashLocked(addrs []string, key string) string {
+	source := strings.Join(addrs, "\n") + "\x00" + s.targetArrSource
+	if s.ring == nil || s.ringSource != source {
+		s.ring = buildTargetRing(addrs, s.weightLocked)
+		s.ringSource = source
+	}
+	hash := targetHash(key)
+	index := sort.Search(len(s.ring), func(i int) bool { return s.ring[i].hash >= hash })
+	if index == len(s.ring) {
+		index = 0
+	}
+	return s.ring[index].addr
+}
+
+// buildTargetRing places every target on a hash ring. Removing an unhealthy
+// target only moves the keys that were on it.
+func buildTargetRing(addrs []string, weight func(string) int) []targetRingPoint {
+	ring := make([]targetRingPoint, 0, len(addrs)*targetRingPointsWeight)
+	for _, addr := range addrs {
+		points := weight(addr) * targetRingPointsWeight
+		for i := 0; i < points; i++ {
+			ring = append(ring, targetRingPoint{hash: targetHash(addr + "#" + strconv.Itoa(i)), addr: addr})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool { return ring[i].hash < ring[j].hash })
+	return ring
+}
+
+func targetHash(key string) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return h.Sum32()
+}
+
+// hashKey returns the value hashed by the hash strategies. A missing header
+// or cookie falls back to the client IP.
+func (s *Target) hashKey(hint TargetHint) string {
+	if hint.Request != nil {
+		switch s.Balance {
+		case TargetBalanceHashHeader:
+			if value := strings.TrimSpace(hint.Request.Header.Get(s.BalanceKey)); value != "" {
+				return value
+			}
+		case TargetBalanceHashCookie:
+			if cookie, err := hint.Request.Cookie(s.BalanceKey); err == nil && cookie.Value != "" {
+				return cookie.Value
+			}
+		}
+	}
+	remote := strings.TrimSpace(hint.RemoteAddr)
+	if remote == "" && hint.Request != nil {
+		remote = strings.TrimSpace(hint.Request.RemoteAddr)
+	}
+	if host, _, err := net.SplitHostPort(remote); err == nil {
+		return host
+	}
+	return remote
+}
+
+func containsTargetAddr(addrs []string, addr string) bool {
+	for _, item := range addrs {
+		if item == addr {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,135 @@
+package file
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestTargetWeightedRoundRobinSpreadsPicks(t *testing.T) {
+	target := &Target{TargetStr: "alpha:80#w=3\nbeta:81"}
+	var picks []string
+	for i := 0; i < 8; i++ {
+		addr, err := target.GetRandomTarget()
+		if err != nil {
+			t.Fatalf("GetRandomTarget() error = %v", err)
+		}
+		picks = append(picks, addr)
+	}
+	want := []string{"alpha:80", "alpha:80", "beta:81", "alpha:80", "alpha:80", "alpha:80", "beta:81", "alpha:80"}
+	for i := range want {
+		if picks[i] != want[i] {
+			t.Fatalf("picks = %v, want %v", picks, want)
+		}
+	}
+	if count := target.TargetCount(); count != 2 {
+		t.Fatalf("TargetCount() = %d, want 2", count)
+	}
+}
+
+func TestTargetLeastConnPrefersIdleTargets(t *testing.T) {
+	target := &Target{TargetStr: "alpha:80\nbeta:81#w=2", Balance: TargetBalanceLeastConn}
+	first, _ := target.GetRandomTarget()
+	releaseFirst := target.AcquireTarget(first)
+	second, _ := target.GetRandomTarget()
+	if second == first {
+		t.Fatalf("second pick = %q, want the idle target", second)
+	}
+	releaseSecond := target.AcquireTarget(second)
+	// beta carries twice the weight, so one connection on it counts as half.
+	third, _ := target.GetRandomTarget()
+	if third != "beta:81" {
+		t.Fatalf("third pick = %q, want beta:81", third)
+	}
+	releaseFirst()
+	releaseFirst()
+	if active := target.ActiveTargetConns(first); active != 0 {
+		t.Fatalf("ActiveTargetConns(%q) after release = %d, want 0", first, active)
+	}
+	releaseSecond()
+}
+
+func TestTargetConsistentHashStaysStickyAcrossHealthChanges(t *testing.T) {
+	target := &Target{TargetStr: "alpha:80\nbeta:81\ngamma:82", Balance: TargetBalanceHashCookie, BalanceKey: "sid"}
+	pick := func(sid string) string {
+		t.Helper()
+		request, _ := http.NewRequest(http.MethodGet, "http://example.com/", nil)
+		request.RemoteAddr = "198.51.100.7:5000"
+		request.AddCookie(&http.Cookie{Name: "sid", Value: sid})
+		addr, err := target.PickTarget(TargetHint{Request: request})
+		if err != nil {
+			t.Fatalf("PickTarget() error = %v", err)
+		}
+		return addr
+	}
+
+	before := make(map[string]string)
+	for i := 0; i < 64; i++ {
+		sid := "session-" + strconv.Itoa(i)
+		before[sid] = pick(sid)
+		if again := pick(sid); again != before[sid] {
+			t.Fatalf("pick(%s) = %q then %q, want sticky", sid, before[sid], again)
+		}
+	}
+
+	var removed []string
+	if !applyRuntimeTargetHealthLocked(target, &removed, "beta:81", false) {
+		t.Fatal("health removal of beta:81 was not applied")
+	}
+	for sid, addr := range before {
+		got := pick(sid)
+		if got == "beta:81" {
+			t.Fatalf("pick(%s) = beta:81 after it was removed", sid)
+		}
+		if addr != "beta:81" && got != addr {
+			t.Fatalf("pick(%s) moved from %q to %q, only keys on beta:81 should move", sid, addr, got)
+		}
+	}
+	applyRuntimeTargetHealthLocked(target, &removed, "beta:81", true)
+	for sid, addr := range before {
+		if got := pick(sid); got != addr {
+			t.Fatalf("pick(%s) = %q after beta:81 recovered, want %q", sid, got, addr)
+		}
+	}
+
+	// Without the cookie the client IP is hashed.
+	request, _ := http.NewRequest(http.MethodGet, "http://example.com/", nil)
+	request.RemoteAddr = "198.51.100.7:5000"
+	byIP, _ := target.PickTarget(TargetHint{Request: request})
+	if other, _ := target.PickTarget(TargetHint{RemoteAddr: "198.51.100.7:6000"}); other != byIP {
+		t.Fatalf("PickTarget(ip) = %q and %q, want the same target for one IP", byIP, other)
+	}
+}
+
+func TestTargetWeightSuffixIsStrippedForHealthChecks(t *testing.T) {
+	target := &Target{TargetStr: "alpha:80#w=5\nbeta:81"}
+	var removed []string
+	if !applyRuntimeTargetHealthLocked(target, &removed, "alpha:80", false) {
+		t.Fatal("health removal of alpha:80 was not applied")
+	}
+	for i := 0; i < 3; i++ {
+		if addr, _ := target.GetRandomTarget(); addr != "beta:81" {
+			t.Fatalf("GetRandomTarget() = %q, want beta:81 while alpha is down", addr)
+		}
+	}
+}
+
+func TestNormalizeTargetBalance(t *testing.T) {
+	tests := []struct {
+		balance, key         string
+		wantBalance, wantKey string
+	}{
+		{"", "", "", ""},
+		{"Round_Robin", "", "", ""},
+		{"weird", "x", "", ""},
+		{" LEAST_CONN ", "ignored", TargetBalanceLeastConn, ""},
+		{"hash_header", "x-user-id", TargetBalanceHashHeader, "X-User-Id"},
+		{"hash_cookie", "", TargetBalanceHashIP, ""},
+	}
+	for _, tt := range tests {
+		balance, key := NormalizeTargetBalance(tt.balance, tt.key)
+		if balance != tt.wantBalance || key != tt.wantKey {
+			t.Fatalf("NormalizeTargetBalance(%q, %q) = %q, %q, want %q, %q", tt.balance, tt.key, balance, key, tt.wantBalance, tt.wantKey)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package file
+
+import "time"
+
+type targetFailureState struct {
+	failures     int
+	ejectedUntil time.Time
+}
+
+// ReportTargetFailure records a failed request to addr. Once threshold
+// consecutive failures are reached the target is ejected for cooldown and
+// left out of selection. A target that fails again right after its cooldown
+// is ejected again at once, like a half-open circuit breaker. It reports
+// whether this failure ejected the target.
+func (s *Target) ReportTargetFailure(addr string, threshold int, cooldown time.Duration) bool {
+	if s == nil || addr == "" || threshold <= 0 || cooldown <= 0 {
+		return false
+	}
+	now := time.Now()
+	s.Lock()
+	defer s.Unlock()
+	if s.failures == nil {
+		s.failures = make(map[string]*targetFailureState)
+	}
+	state := s.failures[addr]
+	if state == nil {
+		state = &targetFailureState{}
+		s.failures[addr] = state
+	}
+	if now.Before(state.ejectedUntil) {
+		return false
+	}
+	state.failures++
+	if state.failures < threshold {
+		return false
+	}
+	state.ejectedUntil = now.Add(cooldown)
+	return true
+}
+
+// ReportTargetSuccess clears the failures recorded for addr.
+func (s *Target) ReportTargetSuccess(addr string) {
+	if s == nil || addr == "" {
+		return
+	}
+	s.RLock()
+	_, failed := s.failures[addr]
+	s.RUnlock()
+	if !failed {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	delete(s.failures, addr)
+}
+
+// TargetEjected reports whether addr is currently ejected.
+func (s *Target) TargetEjected(addr string) bool {
+	if s == nil {
+		return false
+	}
+	s.RLock()
+	defer s.RUnlock()
+	state := s.failures[addr]
+	return state != nil && time.Now().Before(state.ejectedUntil)
+}
+
+// EjectedTargets lists the targets that are currently ejected.
+func (s *Target) EjectedTargets() []string {
+	if s == nil {
+		return nil
+	}
+	now := time.Now()
+	s.RLock()
+	defer s.RUnlock()
+	var ejected []string
+	for _, addr := range s.TargetArr {
+		if state := s.failures[addr]; state != nil && now.Before(state.ejectedUntil) {
+			ejected = append(ejected, addr)
+		}
+	}
+	return ejected
+}
+
+// PickRetryTarget picks a target other than the ones already tried for a
+// request, using the configured balance strategy. Ejected targets are only
+// picked when nothing else is left.
+func (s *Target) PickRetryTarget(hint TargetHint, tried []string) (string, bool) {
+	if s == nil {
+		return "", false
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.refreshConfiguredTargetsLocked()
+	var untried []string
+	for _, addr := range s.availableTargetsLocked(time.Now()) {
+		if !containsTargetAddr(tried, addr) {
+			untried = append(untried, addr)
+		}
+	}
+	if len(untried) == 0 {
+		for _, addr := range s.TargetArr {
+			if !containsTargetAddr(tried, addr) {
+				untried = append(untried, addr)
+			}
+		}
+	}
+	switch len(untried) {
+	case 0:
+		return "", false
+	case 1:
+		return untried[0], true
+	}
+	return s.pickFromLocked(untried, hint), true
+}
+
+// availableTargetsLocked leaves the ejected targets out of TargetArr. When
+// every target is ejected all of them are returned, so a host never fails
+// only because of passive checks.
+func (s *Target) availableTargetsLocked(now time.Time) []string {
+	if len(s.failures) == 0 {
+		return s.TargetArr
+	}
+	var available []string
+	for i, addr := range s.TargetArr {
+		state := s.failures[addr]
+		if state == nil || !now.Before(state.ejectedUntil) {
+			if available != nil {
+				available = append(available, addr)
+			}
+			continue
+		}
+		if available == nil {
+			available = append(make([]string, 0, len(s.TargetArr)), s.TargetArr[:i]...)
+		}
+	}
+	if len(available) == 0 {
+		return s.TargetArr
+	}
+	return available
+}
@@ -0,0 +1,54 @@
+package file
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTargetEjectionSkipsFailingTargetUntilCooldown(t *testing.T) {
+	target := &Target{TargetStr: "alpha:80\nbeta:81\ngamma:82", Balance: TargetBalanceHashIP}
+	if target.ReportTargetFailure("beta:81", 2, time.Minute) {
+		t.Fatal("beta:81 ejected after one failure, threshold is two")
+	}
+	target.ReportTargetSuccess("beta:81")
+	target.ReportTargetFailure("beta:81", 2, time.Minute)
+	if target.TargetEjected("beta:81") {
+		t.Fatal("a success should reset the failure count")
+	}
+	if !target.ReportTargetFailure("beta:81", 2, time.Minute) {
+		t.Fatal("beta:81 was not ejected after two consecutive failures")
+	}
+	for i := 0; i < 32; i++ {
+		addr, _ := target.PickTarget(TargetHint{RemoteAddr: "198.51.100." + strconv.Itoa(i) + ":4000"})
+		if addr == "beta:81" {
+			t.Fatal("ejected beta:81 was picked")
+		}
+	}
+	if got := target.EjectedTargets(); len(got) != 1 || got[0] != "beta:81" {
+		t.Fatalf("EjectedTargets() = %v, want [beta:81]", got)
+	}
+
+	next, ok := target.PickRetryTarget(TargetHint{}, []string{"alpha:80"})
+	if !ok || next != "gamma:82" {
+		t.Fatalf("PickRetryTarget() = %q, %v, want gamma:82", next, ok)
+	}
+	if next, ok := target.PickRetryTarget(TargetHint{}, []string{"alpha:80", "gamma:82"}); !ok || next != "beta:81" {
+		t.Fatalf("PickRetryTarget() with only the ejected target left = %q, %v, want beta:81", next, ok)
+	}
+	if _, ok := target.PickRetryTarget(TargetHint{}, []string{"alpha:80", "beta:81", "gamma:82"}); ok {
+		t.Fatal("PickRetryTarget() found a target after every target was tried")
+	}
+
+	// The cooldown is over: the target is picked again, and one more failure
+	// ejects it at once.
+	target.Lock()
+	target.failures["beta:81"].ejectedUntil = time.Now().Add(-time.Second)
+	target.Unlock()
+	if target.TargetEjected("beta:81") {
+		t.Fatal("beta:81 still ejected after its cooldown")
+	}
+	if !target.ReportTargetFailure("beta:81", 2, time.Minute) {
+		t.Fatal("a failure right after the cooldown should eject beta:81 again")
+	}
+}
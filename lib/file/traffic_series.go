@@ -0,0 +1,581 @@
+package file
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/djylb/nps/lib/common"
+	"github.com/djylb/nps/lib/logs"
+)
+
+const (
+	DefaultTrafficSeriesPath = "conf/usage"
+
+	TrafficResolutionMinute = "minute"
+	TrafficResolutionHour   = "hour"
+	TrafficResolutionDay    = "day"
+
+	DefaultTrafficMinuteRetention = 24 * time.Hour
+	DefaultTrafficHourRetention   = 31 * 24 * time.Hour
+	DefaultTrafficDayRetention    = 400 * 24 * time.Hour
+)
+
+var (
+	ErrTrafficSeriesDisabled = errors.New("traffic series are disabled")
+
+	currentTrafficRecorder atomic.Pointer[TrafficRecorder]
+)
+
+// TrafficSeriesOptions configures where traffic buckets are written and how
+// long each resolution is kept. Non-positive retentions fall back to the
+// defaults.
+type TrafficSeriesOptions struct {
+	Path            string
+	MinuteRetention time.Duration
+	HourRetention   time.Duration
+	DayRetention    time.Duration
+}
+
+// ResolvePath returns the absolute series directory for runPath.
+func (o TrafficSeriesOptions) ResolvePath(runPath string) string {
+	path := strings.TrimSpace(o.Path)
+	if path == "" {
+		path = DefaultTrafficSeriesPath
+	}
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(runPath, path)
+}
+
+// TrafficPoint is the traffic of one resource in one bucket. Time is the
+// bucket start in unix seconds; Connections is the highest number of open
+// connections seen in the bucket.
+type TrafficPoint struct {
+	Time        int64  `json:"t"`
+	Resource    string `json:"r"`
+	ID          int    `json:"id"`
+	InBytes     int64  `json:"in"`
+	OutBytes    int64  `json:"out"`
+	Connections int32  `json:"conns"`
+}
+
+// TrafficSeriesQuery selects points of one resolution in [From, To). An
+// empty IDs selects every id of Resource.
+type TrafficSeriesQuery struct {
+	Resolution string
+	Resource   string
+	IDs        []int
+	From       int64
+	To         int64
+}
+
+type trafficKey struct {
+	resource string
+	id       int
+}
+
+type trafficCounter struct {
+	in  int64
+	out int64
+}
+
+// TrafficRecorder samples the running traffic totals of every user, client,
+// tunnel and host once a minute and stores the differences as minute
+// buckets. Hour and day buckets are accumulated in memory and appended when
+// the hour or day ends and on Close, so after a crash the current hour is
+// only available at minute resolution.
+type TrafficRecorder struct {
+	mu        sync.Mutex
+	dir       string
+	retention map[string]time.Duration
+	now       func() time.Time
+	db        func() *DbUtils
+
+	last    map[trafficKey]trafficCounter
+	sampled bool
+	rollups map[string]*trafficRollup
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+type trafficRollup struct {
+	start  int64
+	points map[trafficKey]*TrafficPoint
+}
+
+// NewTrafficRecorder returns a recorder for dir without starting the sampler.
+func NewTrafficRecorder(dir string, options TrafficSeriesOptions) *TrafficRecorder {
+	r := &TrafficRecorder{
+		dir: dir,
+		retention: map[string]time.Duration{
+			TrafficResolutionMinute: options.MinuteRetention,
+			TrafficResolutionHour:   options.HourRetention,
+			TrafficResolutionDay:    options.DayRetention,
+		},
+		now:  time.Now,
+		db:   GetDb,
+		last: make(map[trafficKey]trafficCounter),
+		rollups: map[string]*trafficRollup{
+			TrafficResolutionHour: {},
+			TrafficResolutionDay:  {},
+		},
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	defaults := map[string]time.Duration{
+		TrafficResolutionMinute: DefaultTrafficMinuteRetention,
+		TrafficResolutionHour:   DefaultTrafficHourRetention,
+		TrafficResolutionDay:    DefaultTrafficDayRetention,
+	}
+	for resolution, retention := range r.retention {
+		if retention <= 0 {
+			r.retention[resolution] = defaults[resolution]
+		}
+	}
+	return r
+}
+
+// ConfigureTrafficRecorder creates the series directory, takes the first
+// sample as the baseline, starts the sampler and makes the recorder the one
+// returned by CurrentTrafficRecorder, closing any previous one.
+func ConfigureTrafficRecorder(options TrafficSeriesOptions) (*TrafficRecorder, error) {
+	recorder := NewTrafficRecorder(options.ResolvePath(common.GetRunPath()), options)
+	if err := os.MkdirAll(recorder.dir, 0o755); err != nil {
+		return nil, err
+	}
+	if err := recorder.Sample(); err != nil {
+		return nil, err
+	}
+	go recorder.run()
+	if previous := currentTrafficRecorder.Swap(recorder); previous != nil {
+		previous.Close()
+	}
+	return recorder, nil
+}
+
+// CurrentTrafficRecorder returns the active recorder, or nil when traffic
+// series are disabled.
+func CurrentTrafficRecorder() *TrafficRecorder {
+	return currentTrafficRecorder.Load()
+}
+
+// ReplaceTrafficRecorder swaps the active recorder and returns the previous one.
+func ReplaceTrafficRecorder(recorder *TrafficRecorder) *TrafficRecorder {
+	return currentTrafficRecorder.Swap(recorder)
+}
+
+func (r *TrafficRecorder) Dir() string {
+	if r == nil {
+		return ""
+	}
+	return r.dir
+}
+
+// Close stops the sampler, records the traffic since the last sample and
+// appends the unfinished hour and day buckets.
+func (r *TrafficRecorder) Close() {
+	if r == nil {
+		return
+	}
+	r.stopOnce.Do(func() {
+		close(r.stop)
+		<-r.done
+		if err := r.Sample(); err != nil {
+			logs.Warn("final traffic sample error: %v", err)
+		}
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		for _, resolution := range []string{TrafficResolutionHour, TrafficResolutionDay} {
+			if err := r.flushRollupLocked(resolution); err != nil {
+				logs.Warn("flush %s traffic buckets error: %v", resolution, err)
+			}
+		}
+	})
+}
+
+func (r *TrafficRecorder) run() {
+	defer close(r.done)
+	// Sample on minute boundaries so every minute bucket covers one minute.
+	wait := time.Until(r.now().Truncate(time.Minute).Add(time.Minute))
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-timer.C:
+			if err := r.Sample(); err != nil {
+				logs.Warn("traffic sample error: %v", err)
+			}
+			timer.Reset(time.Until(r.now().Truncate(time.Minute).Add(time.Minute)))
+		}
+	}
+}
+
+// Sample reads the running totals and records the traffic since the previous
+// sample in the minute bucket that is ending. The first sample only sets the
+// baseline. Totals that went down, for example after a traffic reset, count
+// from zero.
+func (r *TrafficRecorder) Sample() error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	bucket := now.Add(-time.Second).Truncate(time.Minute).Unix()
+	points := make([]TrafficPoint, 0)
+	seen := make(map[trafficKey]struct{})
+	observe := func(resource string, id int, in, out int64, conns int32) {
+		key := trafficKey{resource: resource, id: id}
+		seen[key] = struct{}{}
+		previous, known := r.last[key]
+		r.last[key] = trafficCounter{in: in, out: out}
+		// Resources seen for the first time carry totals restored from the
+		// store, so they only set a baseline as well.
+		if !r.sampled || !known {
+			return
+		}
+		if in < previous.in || out < previous.out {
+			previous = trafficCounter{}
+		}
+		point := TrafficPoint{Time: bucket, Resource: resource, ID: id, InBytes: in - previous.in, OutBytes: out - previous.out, Connections: conns}
+		if point.InBytes != 0 || point.OutBytes != 0 || point.Connections != 0 {
+			points = append(points, point)
+		}
+	}
+	if db := r.db(); db != nil {
+		db.RangeUsers(func(user *User) bool {
+			in, out, _ := user.TotalTrafficTotals()
+			observe(JournalResourceUser, user.Id, in, out, atomic.LoadInt32(&user.NowConn))
+			return true
+		})
+		db.RangeClients(func(client *Client) bool {
+			if client.NoStore {
+				return true
+			}
+			in, out, _ := client.TotalTrafficTotals()
+			observe(JournalResourceClient, client.Id, in, out, atomic.LoadInt32(&client.NowConn))
+			return true
+		})
+		db.RangeTasks(func(tunnel *Tunnel) bool {
+			if tunnel.NoStore {
+				return true
+			}
+			in, out, _ := tunnel.ServiceTrafficTotals()
+			observe(JournalResourceTunnel, tunnel.Id, in, out, tunnel.runtimeConnValue())
+			return true
+		})
+		db.RangeHosts(func(host *Host) bool {
+			if host.NoStore {
+				return true
+			}
+			in, out, _ := host.ServiceTrafficTotals()
+			observe(JournalResourceHost, host.Id, in, out, host.runtimeConnValue())
+			return true
+		})
+	}
+	for key := range r.last {
+		if _, ok := seen[key]; !ok {
+			delete(r.last, key)
+		}
+	}
+	first := !r.sampled
+	r.sampled = true
+	if first {
+		return r.pruneLocked()
+	}
+	if err := r.appendLocked(TrafficResolutionMinute, points); err != nil {
+		return err
+	}
+	for _, resolution := range []string{TrafficResolutionHour, TrafficResolutionDay} {
+		if err := r.rollupLocked(resolution, bucket, points); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *TrafficRecorder) rollupLocked(resolution string, bucket int64, points []TrafficPoint) error {
+	rollup := r.rollups[resolution]
+	start := trafficBucketStart(resolution, bucket)
+	if rollup.points != nil && rollup.start != start {
+		if err := r.flushRollupLocked(resolution); err != nil {
+			return err
+		}
+		if resolution == TrafficResolutionHour {
+			if err := r.pruneLocked(); err != nil {
+				logs.Warn("prune traffic series error: %v", err)
+			}
+		}
+	}
+	if rollup.points == nil {
+		rollup.start = start
+		rollup.points = make(map[trafficKey]*TrafficPoint)
+	}
+	for _, point := range points {
+		key := trafficKey{resource: point.Resource, id: point.ID}
+		current := rollup.points[key]
+		if current == nil {
+			current = &TrafficPoint{Time: start, Resource: point.Resource, ID: point.ID}
+			rollup.points[key] = current
+		}
+		mergeTrafficPoint(current, point)
+	}
+	return nil
+}
+
+func (r *TrafficRecorder) flushRollupLocked(resolution string) error {
+	rollup := r.rollups[resolution]
+	if len(rollup.points) == 0 {
+		rollup.points = nil
+		return nil
+	}
+	points := make([]TrafficPoint, 0, len(rollup.points))
+	for _, point := range rollup.points {
+		points = append(points, *point)
+	}
+	rollup.points = nil
+	return r.appendLocked(resolution, points)
+}
+
+func (r *TrafficRecorder) appendLocked(resolution string, points []TrafficPoint) error {
+	if len(points) == 0 {
+		return nil
+	}
+	// Points are grouped by file, which only changes when a batch crosses a
+	// period boundary.
+	byPath := make(map[string][]TrafficPoint)
+	for _, point := range points {
+		path := r.periodPath(resolution, point.Time)
+		byPath[path] = append(byPath[path], point)
+	}
+	for path, batch := range byPath {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return err
+		}
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+		if err != nil {
+			return err
+		}
+		writer := bufio.NewWriter(file)
+		encoder := json.NewEncoder(writer)
+		for _, point := range batch {
+			if err = encoder.Encode(point); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = writer.Flush()
+		}
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("append traffic series %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// Query returns the points matching query sorted by resource, id and time,
+// with rows of the same bucket merged. The unfinished hour and day buckets
+// are included from memory.
+func (r *TrafficRecorder) Query(query TrafficSeriesQuery) ([]TrafficPoint, error) {
+	if r == nil {
+		return nil, ErrTrafficSeriesDisabled
+	}
+	if _, ok := r.retention[query.Resolution]; !ok {
+		return nil, fmt.Errorf("unknown traffic resolution %q", query.Resolution)
+	}
+	ids := make(map[int]struct{}, len(query.IDs))
+	for _, id := range query.IDs {
+		ids[id] = struct{}{}
+	}
+	match := func(point TrafficPoint) bool {
+		if point.Resource != query.Resource || point.Time < query.From || (query.To > 0 && point.Time >= query.To) {
+			return false
+		}
+		if len(ids) == 0 {
+			return true
+		}
+		_, ok := ids[point.ID]
+		return ok
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	type bucketKey struct {
+		id   int
+		time int64
+	}
+	merged := make(map[bucketKey]*TrafficPoint)
+	add := func(point TrafficPoint) {
+		if !match(point) {
+			return
+		}
+		key := bucketKey{id: point.ID, time: point.Time}
+		if current := merged[key]; current != nil {
+			mergeTrafficPoint(current, point)
+			return
+		}
+		merged[key] = &point
+	}
+	paths, err := r.periodPathsLocked(query.Resolution, query.From, query.To)
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		if err := readTrafficPoints(path, add); err != nil {
+			return nil, err
+		}
+	}
+	if rollup := r.rollups[query.Resolution]; rollup != nil {
+		for _, point := range rollup.points {
+			add(*point)
+		}
+	}
+	points := make([]TrafficPoint, 0, len(merged))
+	for _, point := range merged {
+		points = append(points, *point)
+	}
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].ID != points[j].ID {
+			return points[i].ID < points[j].ID
+		}
+		return points[i].Time < points[j].Time
+	})
+	return points, nil
+}
+
+func readTrafficPoints(path string, fn func(TrafficPoint)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer func() { _ = file.Close() }()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var point TrafficPoint
+		// A line cut short by a crash is skipped like the journal does.
+		if json.Unmarshal(scanner.Bytes(), &point) == nil && point.Resource != "" {
+			fn(point)
+		}
+	}
+	return scanner.Err()
+}
+
+// pruneLocked removes period files that ended before their retention.
+func (r *TrafficRecorder) pruneLocked() error {
+	now := r.now()
+	for resolution, retention := range r.retention {
+		dir := filepath.Join(r.dir, resolution)
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		for _, entry := range entries {
+			start, ok := parseTrafficPeriod(resolution, entry.Name())
+			if !ok {
+				continue
+			}
+			if trafficPeriodEnd(resolution, start).Before(now.Add(-retention)) {
+				if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (r *TrafficRecorder) periodPathsLocked(resolution string, from, to int64) ([]string, error) {
+	dir := filepath.Join(r.dir, resolution)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	paths := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		start, ok := parseTrafficPeriod(resolution, entry.Name())
+		if !ok {
+			continue
+		}
+		if (to > 0 && start.Unix() >= to) || trafficPeriodEnd(resolution, start).Unix() <= from {
+			continue
+		}
+		paths = append(paths, filepath.Join(dir, entry.Name()))
+	}
+	return paths, nil
+}
+
+func (r *TrafficRecorder) periodPath(resolution string, bucket int64) string {
+	layout := trafficPeriodLayouts[resolution]
+	return filepath.Join(r.dir, resolution, time.Unix(bucket, 0).UTC().Format(layout)+".jsonl")
+}
+
+// Minute buckets are kept in one file per UTC day, hour buckets per month
+// and day buckets per year, so retention can drop whole files.
+var trafficPeriodLayouts = map[string]string{
+	TrafficResolutionMinute: "2006-01-02",
+	TrafficResolutionHour:   "2006-01",
+	TrafficResolutionDay:    "2006",
+}
+
+func parseTrafficPeriod(resolution, name string) (time.Time, bool) {
+	layout, ok := trafficPeriodLayouts[resolution]
+	if !ok || !strings.HasSuffix(name, ".jsonl") {
+		return time.Time{}, false
+	}
+	start, err := time.ParseInLocation(layout, strings.TrimSuffix(name, ".jsonl"), time.UTC)
+	return start, err == nil
+}
+
+func trafficPeriodEnd(resolution string, start time.Time) time.Time {
+	switch resolution {
+	case TrafficResolutionMinute:
+		return start.AddDate(0, 0, 1)
+	case TrafficResolutionHour:
+		return start.AddDate(0, 1, 0)
+	default:
+		return start.AddDate(1, 0, 0)
+	}
+}
+
+// trafficBucketStart returns the UTC hour or day bucket a minute falls in.
+func trafficBucketStart(resolution string, minute int64) int64 {
+	t := time.Unix(minute, 0).UTC()
+	if resolution == TrafficResolutionDay {
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC).Unix()
+	}
+	return t.Truncate(time.Hour).Unix()
+}
+
+func mergeTrafficPoint(dst *TrafficPoint, src TrafficPoint) {
+	dst.InBytes += src.InBytes
+	dst.OutBytes += src.OutBytes
+	if src.Connections > dst.Connections {
+		dst.Connections = src.Connections
+	}
+}
@@ -0,0 +1,75 @@
+package file
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTrafficRecorderRecordsMinuteDeltasAndRollsUp(t *testing.T) {
+	resetStoreTestDB(t)
+	now := time.Date(2026, 3, 31, 23, 58, 0, 0, time.UTC)
+	db := GetDb()
+	if err := db.NewClient(&Client{Id: 2, VerifyKey: "vk", Status: true, Cnf: &Config{}, Flow: &Flow{}}); err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	client, _ := db.GetClient(2)
+	recorder := NewTrafficRecorder(filepath.Join(t.TempDir(), "usage"), TrafficSeriesOptions{})
+	recorder.now = func() time.Time { return now }
+	close(recorder.done)
+	step := func(in, out int64) {
+		t.Helper()
+		if err := client.ObserveServiceTraffic(in, out); err != nil {
+			t.Fatalf("ObserveServiceTraffic() error = %v", err)
+		}
+		now = now.Add(time.Minute)
+		if err := recorder.Sample(); err != nil {
+			t.Fatalf("Sample() error = %v", err)
+		}
+	}
+
+	if err := client.ObserveServiceTraffic(1000, 1000); err != nil {
+		t.Fatalf("ObserveServiceTraffic() error = %v", err)
+	}
+	if err := recorder.Sample(); err != nil {
+		t.Fatalf("Sample(baseline) error = %v", err)
+	}
+	step(10, 20)
+	client.ResetTraffic()
+	step(5, 0)
+	step(1, 1)
+
+	minutes, err := recorder.Query(TrafficSeriesQuery{Resolution: TrafficResolutionMinute, Resource: JournalResourceClient, IDs: []int{2}})
+	if err != nil {
+		t.Fatalf("Query(minute) error = %v", err)
+	}
+	if len(minutes) != 3 || minutes[0].InBytes != 10 || minutes[0].OutBytes != 20 || minutes[1].InBytes != 5 ||
+		minutes[0].Time != time.Date(2026, 3, 31, 23, 58, 0, 0, time.UTC).Unix() {
+		t.Fatalf("minute points = %+v, want 10/20, reset to 5/0, then 1/1", minutes)
+	}
+	if _, err := os.Stat(filepath.Join(recorder.Dir(), TrafficResolutionHour, "2026-03.jsonl")); err != nil {
+		t.Fatalf("finished hour was not written: %v", err)
+	}
+
+	days, err := recorder.Query(TrafficSeriesQuery{Resolution: TrafficResolutionDay, Resource: JournalResourceClient, From: time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC).Unix()})
+	if err != nil {
+		t.Fatalf("Query(day) error = %v", err)
+	}
+	if len(days) != 2 || days[0].InBytes != 15 || days[0].OutBytes != 20 || days[1].InBytes != 1 {
+		t.Fatalf("day points = %+v, want March 15/20 and unfinished April 1/1", days)
+	}
+
+	// Old minute files go once the recorder rolls past the retention.
+	now = now.Add(3 * 24 * time.Hour)
+	step(0, 0)
+	step(1, 0)
+	if _, err := os.Stat(filepath.Join(recorder.Dir(), TrafficResolutionMinute, "2026-03-31.jsonl")); !os.IsNotExist(err) {
+		t.Fatalf("expired minute file still present, stat error = %v", err)
+	}
+	recorder.Close()
+	hours, err := recorder.Query(TrafficSeriesQuery{Resolution: TrafficResolutionHour, Resource: JournalResourceClient})
+	if err != nil || len(hours) != 3 {
+		t.Fatalf("Query(hour) after Close = %+v, %v, want three hours", hours, err)
+	}
+}
@@ -0,0 +1,59 @@
+package file
+
+import (
+	"github.com/djylb/nps/lib/waf"
+)
+
+// WAFRuleSet returns the compiled firewall rules of the user.
+func (u *User) WAFRuleSet() *waf.RuleSet {
+	if u == nil {
+		return nil
+	}
+	u.RLock()
+	compiled, raw := u.wafRules, u.WafRules
+	u.RUnlock()
+	if compiled != nil && compiled.Source() == raw {
+		return compiled
+	}
+	compiled = waf.Compile(raw)
+	u.Lock()
+	u.wafRules = compiled
+	u.Unlock()
+	return compiled
+}
+
+// WAFRuleSet returns the compiled firewall rules of the host.
+func (h *Host) WAFRuleSet() *waf.RuleSet {
+	if h == nil {
+		return nil
+	}
+	h.RLock()
+	compiled, raw := h.wafRules, h.WafRules
+	h.RUnlock()
+	if compiled != nil && compiled.Source() == raw {
+		return compiled
+	}
+	compiled = waf.Compile(raw)
+	h.Lock()
+	h.wafRules = compiled
+	h.Unlock()
+	return compiled
+}
+
+// WAFRuleSet returns the compiled global firewall rules.
+func (g *Glob) WAFRuleSet() *waf.RuleSet {
+	if g == nil {
+		return nil
+	}
+	g.RLock()
+	compiled, raw := g.wafRules, g.WafRules
+	g.RUnlock()
+	if compiled != nil && compiled.Source() == raw {
+		return compiled
+	}
+	compiled = waf.Compile(raw)
+	g.Lock()
+	g.wafRules = compiled
+	g.Unlock()
+	return compiled
+}
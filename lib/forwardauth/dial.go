@@ -0,0 +1,85 @@
+package forwardauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/djylb/nps/lib/common"
+)
+
+// ErrBlockedAddress is returned when an endpoint resolves to a loopback,
+// private or link-local address that the allow list does not name.
+var ErrBlockedAddress = errors.New("forward auth endpoint resolves to an internal address")
+
+// allowList holds the internal endpoints nps.conf allows.
+type allowList struct {
+	raw string
+	acl *common.ProxyACL
+}
+
+// SetAllow sets the hosts, IPs and CIDRs, comma or newline separated, that
+// may be reached although they are internal. Endpoints are set by users, so
+// everything else must be a public address.
+func (c *Client) SetAllow(raw string) {
+	if current := c.allow.Load(); current != nil && current.raw == raw {
+		return
+	}
+	c.allow.Store(&allowList{raw: raw, acl: common.ParseProxyACL(strings.ReplaceAll(raw, ",", "\n"))})
+}
+
+func (c *Client) allowed(host string) bool {
+	list := c.allow.Load()
+	return list != nil && list.acl.Allows(host)
+}
+
+// dial resolves the endpoint itself and dials the checked address, so a
+// name cannot resolve to another address between the check and the dial.
+func (c *Client) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{Timeout: DefaultTimeout, KeepAlive: 30 * time.Second}
+	if c.allowed(host) {
+		return dialer.DialContext(ctx, network, addr)
+	}
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range addrs {
+		if ip = ip.Unmap(); internalAddress(ip) && !c.allowed(ip.String()) {
+			return nil, fmt.Errorf("%w: %s is %s", ErrBlockedAddress, host, ip)
+		}
+	}
+	var lastErr error
+	for _, ip := range addrs {
+		conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip.Unmap().String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no address for %s", host)
+	}
+	return nil, lastErr
+}
+
+func internalAddress(ip netip.Addr) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsUnspecified()
+}
+
+func newTransport(c *Client) http.RoundTripper {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = c.dial
+	return transport
+}
@@ -0,0 +1,178 @@
+// Package forwardauth asks an external service whether a proxied request
+// may pass, like the auth_request module of nginx or the forward auth of
+// Traefik. The service gets the headers of the original request and
+// answers 2xx to allow it; any other answer is relayed to the client.
+package forwardauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultTimeout bounds one check.
+const DefaultTimeout = 5 * time.Second
+
+// maxBodyBytes bounds the denial body relayed to the client.
+const maxBodyBytes = 64 << 10
+
+// ErrInvalidEndpoint is returned for endpoints that are not absolute http or
+// https URLs.
+var ErrInvalidEndpoint = errors.New("forward auth endpoint must be an http or https URL")
+
+// Headers of the original request that are not sent to the service.
+var skippedHeaders = []string{
+	"Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization", "Proxy-Connection",
+	"Te", "Trailer", "Transfer-Encoding", "Upgrade", "Content-Length", "X-Forwarded-User",
+}
+
+// Request describes what is checked. Header holds the headers of the
+// original request and may be nil for SOCKS5 connections. User and
+// Password are the proxy credentials of the HTTP proxy and SOCKS5 modes.
+type Request struct {
+	Method   string
+	Scheme   string
+	Host     string
+	URI      string
+	ClientIP string
+	Header   http.Header
+	User     string
+	Password string
+}
+
+// Response is the answer of the service.
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// Allowed reports whether the service allowed the request.
+func (r *Response) Allowed() bool {
+	return r != nil && r.StatusCode >= 200 && r.StatusCode < 300
+}
+
+// Client sends checks. It never follows redirects, so the service can send
+// the user to its login page.
+type Client struct {
+	http  *http.Client
+	allow atomic.Pointer[allowList]
+}
+
+var defaultClient = NewClient(nil)
+
+// Default returns the process wide client.
+func Default() *Client {
+	return defaultClient
+}
+
+// NewClient returns a client using transport. When nil, the client dials
+// public addresses only, plus those of SetAllow.
+func NewClient(transport http.RoundTripper) *Client {
+	c := &Client{http: &http.Client{
+		Timeout: DefaultTimeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}}
+	if transport == nil {
+		transport = newTransport(c)
+	}
+	c.http.Transport = transport
+	return c
+}
+
+// Check sends req to endpoint.
+func (c *Client) Check(ctx context.Context, endpoint string, req Request) (*Response, error) {
+	sub, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range req.Header {
+		if !slices.Contains(skippedHeaders, http.CanonicalHeaderKey(name)) {
+			sub.Header[name] = slices.Clone(values)
+		}
+	}
+	setHeader(sub.Header, "X-Forwarded-Method", req.Method)
+	setHeader(sub.Header, "X-Forwarded-Proto", req.Scheme)
+	setHeader(sub.Header, "X-Forwarded-Host", req.Host)
+	setHeader(sub.Header, "X-Forwarded-Uri", req.URI)
+	setHeader(sub.Header, "X-Forwarded-For", req.ClientIP)
+	setHeader(sub.Header, "X-Original-Method", req.Method)
+	if req.Scheme != "" && req.Host != "" {
+		sub.Header.Set("X-Original-URL", req.Scheme+"://"+req.Host+req.URI)
+	}
+	if req.User != "" || req.Password != "" {
+		setHeader(sub.Header, "X-Forwarded-User", req.User)
+		sub.SetBasicAuth(req.User, req.Password)
+	}
+	resp, err := c.http.Do(sub)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBodyBytes))
+	if err != nil {
+		return nil, fmt.Errorf("read forward auth response: %w", err)
+	}
+	return &Response{StatusCode: resp.StatusCode, Header: resp.Header, Body: body}, nil
+}
+
+func setHeader(header http.Header, name, value string) {
+	if value == "" {
+		header.Del(name)
+		return
+	}
+	header.Set(name, value)
+}
+
+// CopyHeaders replaces the named headers of dst with those of src. Names
+// missing from src are removed from dst, so clients cannot send them.
+func CopyHeaders(dst, src http.Header, names []string) {
+	for _, name := range names {
+		dst.Del(name)
+		for _, value := range src.Values(name) {
+			dst.Add(name, value)
+		}
+	}
+}
+
+// NormalizeEndpoint returns the stored form of an endpoint. An empty
+// endpoint disables forward auth.
+func NormalizeEndpoint(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", nil
+	}
+	parsed, err := url.Parse(raw)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return "", fmt.Errorf("%w: %q", ErrInvalidEndpoint, raw)
+	}
+	return parsed.String(), nil
+}
+
+// HeaderList parses a comma or space separated list of header names.
+func HeaderList(value string) []string {
+	fields := strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == '\n' || r == '\r' || r == ' ' || r == '\t'
+	})
+	names := make([]string, 0, len(fields))
+	for _, field := range fields {
+		if name := http.CanonicalHeaderKey(field); !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// NormalizeHeaders returns the stored form of a header name list.
+func NormalizeHeaders(value string) string {
+	return strings.Join(HeaderList(value), ",")
+}
@@ -0,0 +1,131 @@
+package forwardauth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newLoopbackClient returns a client that may reach test servers.
+func newLoopbackClient() *Client {
+	client := NewClient(nil)
+	client.SetAllow("127.0.0.1, ::1")
+	return client
+}
+
+func TestCheckSendsOriginalRequest(t *testing.T) {
+	var got *http.Request
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Clone(context.Background())
+		if r.Header.Get("Cookie") != "session=ok" {
+			w.Header().Set("Location", "https://auth.example.com/login")
+			w.WriteHeader(http.StatusFound)
+			return
+		}
+		w.Header().Set("Remote-User", "alice")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer service.Close()
+
+	client := newLoopbackClient()
+	header := http.Header{}
+	header.Set("Cookie", "session=ok")
+	header.Set("Proxy-Authorization", "Basic secret")
+	header.Set("X-Forwarded-User", "forged")
+	resp, err := client.Check(context.Background(), service.URL+"/verify", Request{
+		Method:   http.MethodPost,
+		Scheme:   "https",
+		Host:     "app.example.com",
+		URI:      "/admin?x=1",
+		ClientIP: "198.51.100.7",
+		Header:   header,
+	})
+	if err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if !resp.Allowed() || resp.Header.Get("Remote-User") != "alice" {
+		t.Fatalf("Check() = %d %v", resp.StatusCode, resp.Header)
+	}
+	if got.Method != http.MethodGet || got.URL.Path != "/verify" {
+		t.Fatalf("subrequest = %s %s", got.Method, got.URL)
+	}
+	for name, want := range map[string]string{
+		"X-Forwarded-Method":  "POST",
+		"X-Forwarded-Proto":   "https",
+		"X-Forwarded-Host":    "app.example.com",
+		"X-Forwarded-Uri":     "/admin?x=1",
+		"X-Forwarded-For":     "198.51.100.7",
+		"X-Original-Url":      "https://app.example.com/admin?x=1",
+		"Proxy-Authorization": "",
+		"X-Forwarded-User":    "",
+	} {
+		if value := got.Header.Get(name); value != want {
+			t.Fatalf("subrequest %s = %q, want %q", name, value, want)
+		}
+	}
+
+	// Redirects are relayed, not followed.
+	resp, err = client.Check(context.Background(), service.URL, Request{Method: http.MethodGet})
+	if err != nil || resp.Allowed() || resp.StatusCode != http.StatusFound || resp.Header.Get("Location") == "" {
+		t.Fatalf("Check() without cookie = %+v, %v", resp, err)
+	}
+}
+
+func TestCheckPassesProxyCredentials(t *testing.T) {
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != "bob" || pass != "pw" || r.Header.Get("X-Forwarded-User") != "bob" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer service.Close()
+
+	client := newLoopbackClient()
+	if resp, err := client.Check(context.Background(), service.URL, Request{Method: http.MethodConnect, User: "bob", Password: "pw"}); err != nil || !resp.Allowed() {
+		t.Fatalf("Check() with credentials = %+v, %v", resp, err)
+	}
+	if resp, err := client.Check(context.Background(), service.URL, Request{Method: http.MethodConnect, User: "bob", Password: "bad"}); err != nil || resp.Allowed() {
+		t.Fatalf("Check() with bad credentials = %+v, %v", resp, err)
+	}
+}
+
+func TestCheckRefusesInternalAddresses(t *testing.T) {
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer service.Close()
+
+	client := NewClient(nil)
+	for _, endpoint := range []string{service.URL, "http://localhost:1/", "http://[::1]:1/", "http://169.254.169.254/latest/meta-data/", "http://10.0.0.1:1/"} {
+		if _, err := client.Check(context.Background(), endpoint, Request{Method: http.MethodGet}); !errors.Is(err, ErrBlockedAddress) {
+			t.Fatalf("Check(%s) error = %v, want ErrBlockedAddress", endpoint, err)
+		}
+	}
+	client.SetAllow("127.0.0.0/8")
+	if resp, err := client.Check(context.Background(), service.URL, Request{Method: http.MethodGet}); err != nil || !resp.Allowed() {
+		t.Fatalf("Check() of an allowed address = %+v, %v", resp, err)
+	}
+}
+
+func TestCopyHeadersAndNormalize(t *testing.T) {
+	dst := http.Header{"Remote-User": {"forged"}, "Remote-Email": {"forged@example.com"}}
+	CopyHeaders(dst, http.Header{"Remote-User": {"alice"}}, HeaderList("remote-user, Remote-Email"))
+	if dst.Get("Remote-User") != "alice" || dst.Get("Remote-Email") != "" {
+		t.Fatalf("CopyHeaders() = %v", dst)
+	}
+	if got := NormalizeHeaders("remote-user remote-groups,Remote-User"); got != "Remote-User,Remote-Groups" {
+		t.Fatalf("NormalizeHeaders() = %q", got)
+	}
+	for _, raw := range []string{"auth.example.com/verify", "ftp://auth.example.com", "http://"} {
+		if _, err := NormalizeEndpoint(raw); err == nil {
+			t.Fatalf("NormalizeEndpoint(%q) error = nil", raw)
+		}
+	}
+	if got, err := NormalizeEndpoint(" http://authelia:9091/api/verify "); err != nil || got != "http://authelia:9091/api/verify" {
+		t.Fatalf("NormalizeEndpoint() = %q, %v", got, err)
+	}
+}
@@ -0,0 +1,191 @@
+// Package ondemand approves certificates that are issued during the TLS
+// handshake for domains nps was not told about, like the on-demand TLS of
+// Caddy. A domain is approved by an allow list or by an ask endpoint, and
+// the certificates ordered for one domain are rate limited.
+package ondemand
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/djylb/nps/lib/common"
+)
+
+// DefaultTimeout bounds one ask.
+const DefaultTimeout = 5 * time.Second
+
+// denyTTL is how long a refusal of the ask endpoint is remembered, so
+// handshakes for a refused domain do not reach it each time.
+const denyTTL = time.Minute
+
+// maxTrackedNames bounds the domains remembered before expired ones are
+// dropped.
+const maxTrackedNames = 4096
+
+var (
+	// ErrNotApproved is returned for domains neither the allow list nor the
+	// ask endpoint approved.
+	ErrNotApproved = errors.New("domain not approved for on-demand certificate")
+	// ErrRateLimited is returned when a domain ordered too many
+	// certificates within the window.
+	ErrRateLimited = errors.New("certificate issuance rate limited")
+)
+
+// Policy holds the approval and rate limit settings. Ask is an http or
+// https URL asked with GET ?domain=<name>, any 2xx approves. Allow holds
+// ACL entries of domains approved without asking. Limit certificates may be
+// ordered for one domain per Window; zero disables the limit.
+type Policy struct {
+	Ask    string
+	Allow  string
+	Limit  int
+	Window time.Duration
+}
+
+// Gate approves domains and counts their orders.
+type Gate struct {
+	http   *http.Client
+	now    func() time.Time
+	mu     sync.Mutex
+	denied map[string]time.Time
+	issued map[string]*issueWindow
+}
+
+type issueWindow struct {
+	reset time.Time
+	count int
+}
+
+var defaultGate = NewGate(nil)
+
+// Default returns the process wide gate.
+func Default() *Gate {
+	return defaultGate
+}
+
+// NewGate returns a gate asking over transport, or the default transport
+// when nil.
+func NewGate(transport http.RoundTripper) *Gate {
+	return &Gate{
+		http: &http.Client{
+			Transport: transport,
+			Timeout:   DefaultTimeout,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		now:    time.Now,
+		denied: make(map[string]time.Time),
+		issued: make(map[string]*issueWindow),
+	}
+}
+
+// Approve reports whether a certificate may be obtained for name. Without
+// an allow list entry or an ask endpoint nothing is approved.
+func (g *Gate) Approve(ctx context.Context, policy Policy, name string) error {
+	name = normalizeName(name)
+	if name == "" {
+		return ErrNotApproved
+	}
+	if acl := common.ParseProxyACL(strings.ReplaceAll(policy.Allow, ",", "\n")); acl.Enabled() && acl.Allows(name) {
+		return nil
+	}
+	if strings.TrimSpace(policy.Ask) == "" {
+		return fmt.Errorf("%w: %s", ErrNotApproved, name)
+	}
+	now := g.now()
+	g.mu.Lock()
+	until, denied := g.denied[name]
+	g.mu.Unlock()
+	if denied && now.Before(until) {
+		return fmt.Errorf("%w: %s", ErrNotApproved, name)
+	}
+	approved, err := g.ask(ctx, policy.Ask, name)
+	if err != nil {
+		return fmt.Errorf("ask %s: %w", name, err)
+	}
+	if approved {
+		g.mu.Lock()
+		delete(g.denied, name)
+		g.mu.Unlock()
+		return nil
+	}
+	g.mu.Lock()
+	g.pruneLocked(now)
+	g.denied[name] = now.Add(denyTTL)
+	g.mu.Unlock()
+	return fmt.Errorf("%w: %s", ErrNotApproved, name)
+}
+
+func (g *Gate) ask(ctx context.Context, endpoint, name string) (bool, error) {
+	parsed, err := url.Parse(strings.TrimSpace(endpoint))
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return false, fmt.Errorf("ask endpoint must be an http or https URL")
+	}
+	query := parsed.Query()
+	query.Set("domain", name)
+	parsed.RawQuery = query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, parsed.String(), nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := g.http.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4<<10))
+	return resp.StatusCode >= 200 && resp.StatusCode < 300, nil
+}
+
+// Issue counts an order for name and fails once the domain ordered Limit
+// certificates within Window.
+func (g *Gate) Issue(policy Policy, name string) error {
+	name = normalizeName(name)
+	if policy.Limit <= 0 || policy.Window <= 0 || name == "" {
+		return nil
+	}
+	now := g.now()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	window, ok := g.issued[name]
+	if !ok || !now.Before(window.reset) {
+		g.pruneLocked(now)
+		window = &issueWindow{reset: now.Add(policy.Window)}
+		g.issued[name] = window
+	}
+	if window.count >= policy.Limit {
+		retry := window.reset.Sub(now).Round(time.Second)
+		return fmt.Errorf("%w: %s ordered %d certificates, retry in %s", ErrRateLimited, name, window.count, retry)
+	}
+	window.count++
+	return nil
+}
+
+// pruneLocked drops expired entries once many domains are remembered.
+func (g *Gate) pruneLocked(now time.Time) {
+	if len(g.denied)+len(g.issued) < maxTrackedNames {
+		return
+	}
+	for name, until := range g.denied {
+		if !now.Before(until) {
+			delete(g.denied, name)
+		}
+	}
+	for name, window := range g.issued {
+		if !now.Before(window.reset) {
+			delete(g.issued, name)
+		}
+	}
+}
+
+func normalizeName(name string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+}
@@ -0,0 +1,82 @@
+package ondemand
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGateApproveAllowListAndAsk(t *testing.T) {
+	var asks atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		asks.Add(1)
+		if r.URL.Query().Get("token") != "t" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Query().Get("domain") == "shop.customer.net" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	gate := NewGate(nil)
+	now := time.Unix(1_700_000_000, 0)
+	gate.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	if err := gate.Approve(ctx, Policy{}, "shop.customer.net"); !errors.Is(err, ErrNotApproved) {
+		t.Fatalf("Approve() without approver error = %v, want ErrNotApproved", err)
+	}
+	if err := gate.Approve(ctx, Policy{Allow: "*.trusted.io, other.net"}, "a.trusted.io"); err != nil {
+		t.Fatalf("Approve(allow listed) error = %v", err)
+	}
+
+	policy := Policy{Ask: server.URL + "/ask?token=t"}
+	if err := gate.Approve(ctx, policy, "Shop.Customer.net."); err != nil {
+		t.Fatalf("Approve(asked) error = %v", err)
+	}
+	if err := gate.Approve(ctx, policy, "evil.example"); !errors.Is(err, ErrNotApproved) {
+		t.Fatalf("Approve(refused) error = %v, want ErrNotApproved", err)
+	}
+	if err := gate.Approve(ctx, policy, "evil.example"); !errors.Is(err, ErrNotApproved) || asks.Load() != 2 {
+		t.Fatalf("Approve(refused again) error = %v, asks = %d, want the cached refusal", err, asks.Load())
+	}
+	now = now.Add(denyTTL)
+	_ = gate.Approve(ctx, policy, "evil.example")
+	if asks.Load() != 3 {
+		t.Fatalf("asks = %d after the refusal expired, want 3", asks.Load())
+	}
+}
+
+func TestGateIssueLimitsPerDomain(t *testing.T) {
+	gate := NewGate(nil)
+	now := time.Unix(1_700_000_000, 0)
+	gate.now = func() time.Time { return now }
+	policy := Policy{Limit: 2, Window: time.Hour}
+
+	for i := 0; i < 2; i++ {
+		if err := gate.Issue(policy, "a.customer.net"); err != nil {
+			t.Fatalf("Issue(#%d) error = %v", i, err)
+		}
+	}
+	if err := gate.Issue(policy, "A.customer.net"); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("Issue(over limit) error = %v, want ErrRateLimited", err)
+	}
+	if err := gate.Issue(policy, "b.customer.net"); err != nil {
+		t.Fatalf("Issue(other domain) error = %v", err)
+	}
+	now = now.Add(time.Hour)
+	if err := gate.Issue(policy, "a.customer.net"); err != nil {
+		t.Fatalf("Issue(next window) error = %v", err)
+	}
+	if err := gate.Issue(Policy{}, "a.customer.net"); err != nil {
+		t.Fatalf("Issue(no limit) error = %v", err)
+	}
+}
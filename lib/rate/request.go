@@ -0,0 +1,162 @@
+package rate
+
+import (
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxRequestBuckets bounds the keys a RequestLimiter tracks. Past it, idle
+// buckets are dropped first and the least recently used ones when that is
+// not enough, so a flood of distinct keys cannot grow memory without limit
+// or hand the clients that are being limited a fresh burst.
+const maxRequestBuckets = 1 << 16
+
+// requestBucketEviction is how many buckets are dropped at once when the
+// map is full of active ones, so the sort is not repeated for every key.
+const requestBucketEviction = maxRequestBuckets / 8
+
+// RequestLimiter allows up to limit requests per window for each key with a
+// token bucket: a key may burst limit requests and then gets one more every
+// window/limit. It is safe for concurrent use.
+type RequestLimiter struct {
+	mu        sync.Mutex
+	limit     int
+	window    time.Duration
+	buckets   map[string]*requestBucket
+	lastSweep time.Time
+	rejected  atomic.Int64
+}
+
+type requestBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewRequestLimiter returns a limiter for limit requests per window. A
+// limit <= 0 allows every request.
+func NewRequestLimiter(limit int, window time.Duration) *RequestLimiter {
+	l := &RequestLimiter{}
+	l.SetLimit(limit, window)
+	return l
+}
+
+// SetLimit changes the limit. Buckets start over when it differs from the
+// current one; the rejected counter is kept.
+func (l *RequestLimiter) SetLimit(limit int, window time.Duration) {
+	if l == nil {
+		return
+	}
+	if limit < 0 {
+		limit = 0
+	}
+	if window <= 0 {
+		window = time.Second
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.limit == limit && l.window == window && l.buckets != nil {
+		return
+	}
+	l.limit = limit
+	l.window = window
+	l.buckets = make(map[string]*requestBucket)
+}
+
+// Limit returns the configured requests per window.
+func (l *RequestLimiter) Limit() (int, time.Duration) {
+	if l == nil {
+		return 0, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limit, l.window
+}
+
+// Allow takes a token for key. When none is left it returns false and the
+// time until the next token.
+func (l *RequestLimiter) Allow(key string, now time.Time) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.limit <= 0 {
+		return true, 0
+	}
+	capacity := float64(l.limit)
+	perToken := l.window / time.Duration(l.limit)
+	bucket := l.buckets[key]
+	if bucket == nil {
+		l.makeRoomLocked(now)
+		bucket = &requestBucket{tokens: capacity, last: now}
+		l.buckets[key] = bucket
+	} else if elapsed := now.Sub(bucket.last); elapsed > 0 {
+		bucket.tokens = min(capacity, bucket.tokens+float64(elapsed)/float64(perToken))
+		bucket.last = now
+	}
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+	l.rejected.Add(1)
+	wait := time.Duration((1 - bucket.tokens) * float64(perToken))
+	return false, max(wait, time.Millisecond)
+}
+
+// Rejected returns how many requests were refused.
+func (l *RequestLimiter) Rejected() int64 {
+	if l == nil {
+		return 0
+	}
+	return l.rejected.Load()
+}
+
+// ResetRejected clears the rejected counter.
+func (l *RequestLimiter) ResetRejected() {
+	if l != nil {
+		l.rejected.Store(0)
+	}
+}
+
+// Clone returns a limiter with the same limit and counter but fresh
+// buckets.
+func (l *RequestLimiter) Clone() *RequestLimiter {
+	if l == nil {
+		return nil
+	}
+	limit, window := l.Limit()
+	cloned := NewRequestLimiter(limit, window)
+	cloned.rejected.Store(l.rejected.Load())
+	return cloned
+}
+
+// makeRoomLocked drops buckets that refilled completely, which behave like
+// new ones, once per window or when the map is full. A map that is still
+// full loses its least recently used buckets.
+func (l *RequestLimiter) makeRoomLocked(now time.Time) {
+	full := len(l.buckets) >= maxRequestBuckets
+	if !full && now.Sub(l.lastSweep) < l.window {
+		return
+	}
+	l.lastSweep = now
+	for key, bucket := range l.buckets {
+		if now.Sub(bucket.last) >= l.window {
+			delete(l.buckets, key)
+		}
+	}
+	if len(l.buckets) < maxRequestBuckets {
+		return
+	}
+	keys := make([]string, 0, len(l.buckets))
+	for key := range l.buckets {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b string) int {
+		return l.buckets[a].last.Compare(l.buckets[b].last)
+	})
+	for _, key := range keys[:requestBucketEviction] {
+		delete(l.buckets, key)
+	}
+}
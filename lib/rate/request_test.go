@@ -0,0 +1,94 @@
+package rate
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRequestLimiterBurstsAndRefills(t *testing.T) {
+	limiter := NewRequestLimiter(3, 3*time.Second)
+	now := time.Unix(1000, 0)
+	for i := 0; i < 3; i++ {
+		if ok, _ := limiter.Allow("203.0.113.1", now); !ok {
+			t.Fatalf("request %d within the burst was refused", i+1)
+		}
+	}
+	ok, wait := limiter.Allow("203.0.113.1", now)
+	if ok || wait != time.Second {
+		t.Fatalf("Allow() over the limit = %v, %v; want false, 1s", ok, wait)
+	}
+	if ok, _ := limiter.Allow("203.0.113.2", now); !ok {
+		t.Fatal("another key shared the bucket")
+	}
+	if ok, _ := limiter.Allow("203.0.113.1", now.Add(time.Second)); !ok {
+		t.Fatal("token was not refilled after window/limit")
+	}
+	if got := limiter.Rejected(); got != 1 {
+		t.Fatalf("Rejected() = %d, want 1", got)
+	}
+}
+
+func TestRequestLimiterSetLimitAndClone(t *testing.T) {
+	limiter := NewRequestLimiter(1, time.Minute)
+	now := time.Unix(1000, 0)
+	limiter.Allow("k", now)
+	limiter.Allow("k", now)
+
+	limiter.SetLimit(1, time.Minute)
+	if ok, _ := limiter.Allow("k", now); ok {
+		t.Fatal("SetLimit() with the same limit reset the buckets")
+	}
+	limiter.SetLimit(2, time.Minute)
+	if ok, _ := limiter.Allow("k", now); !ok {
+		t.Fatal("SetLimit() with a new limit kept the old buckets")
+	}
+
+	cloned := limiter.Clone()
+	if limit, window := cloned.Limit(); limit != 2 || window != time.Minute || cloned.Rejected() != 2 {
+		t.Fatalf("Clone() = %d/%v rejected %d", limit, window, cloned.Rejected())
+	}
+	limiter.SetLimit(0, 0)
+	for i := 0; i < 10; i++ {
+		if ok, _ := limiter.Allow("k", now); !ok {
+			t.Fatal("limit 0 refused a request")
+		}
+	}
+}
+
+func TestRequestLimiterDropsIdleBuckets(t *testing.T) {
+	limiter := NewRequestLimiter(1, time.Second)
+	now := time.Unix(1000, 0)
+	limiter.Allow("a", now)
+	limiter.Allow("b", now.Add(2*time.Second))
+	limiter.mu.Lock()
+	_, kept := limiter.buckets["a"]
+	limiter.mu.Unlock()
+	if kept {
+		t.Fatal("idle bucket was not dropped")
+	}
+}
+
+func TestRequestLimiterEvictsOldestBucketsWhenFull(t *testing.T) {
+	limiter := NewRequestLimiter(1, time.Hour)
+	now := time.Unix(1000, 0)
+	limiter.Allow("limited", now.Add(time.Minute))
+	limiter.mu.Lock()
+	limiter.lastSweep = now.Add(time.Minute)
+	for i := 1; i < maxRequestBuckets; i++ {
+		limiter.buckets["k"+strconv.Itoa(i)] = &requestBucket{last: now.Add(time.Duration(i) * time.Millisecond)}
+	}
+	limiter.mu.Unlock()
+
+	limiter.Allow("new", now.Add(2*time.Minute))
+	limiter.mu.Lock()
+	_, oldest := limiter.buckets["k1"]
+	count := len(limiter.buckets)
+	limiter.mu.Unlock()
+	if oldest || count != maxRequestBuckets-requestBucketEviction+1 {
+		t.Fatalf("full map kept the oldest bucket = %v with %d buckets", oldest, count)
+	}
+	if ok, _ := limiter.Allow("limited", now.Add(2*time.Minute)); ok {
+		t.Fatal("a full map reset the bucket of a limited key")
+	}
+}
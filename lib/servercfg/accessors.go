@@ -5,6 +5,7 @@ import (
 	"encoding/base64"
 	"net"
 	"net/url"
+	"slices"
 	"strings"
 	"time"
 
@@ -13,6 +14,9 @@ import (
 
 const defaultProxyResponseHeaderTimeout = 100 * time.Second
 const defaultProxySSLCacheIdleTimeout = 60 * time.Minute
+const defaultProxyOIDCSessionTTL = 24 * time.Hour
+const defaultProxyPassiveCooldown = 30 * time.Second
+const defaultProxyCacheMaxObjectBytes = 8 << 20
 
 // Resolve returns the provided snapshot or falls back to the current runtime snapshot.
 func Resolve(cfg *Snapshot) *Snapshot {
@@ -46,6 +50,76 @@ func (cfg *Snapshot) ProxyResponseHeaderTimeout() time.Duration {
 	return timeout
 }
 
+// ProxyPassiveFailureThreshold is the number of consecutive failures after
+// which an HTTP backend target is ejected. Zero disables passive checks.
+func (cfg *Snapshot) ProxyPassiveFailureThreshold() int {
+	threshold := Resolve(cfg).Proxy.PassiveFailures
+	if threshold < 0 {
+		return 0
+	}
+	return threshold
+}
+
+func (cfg *Snapshot) ProxyPassiveCooldown() time.Duration {
+	cooldown := time.Duration(Resolve(cfg).Proxy.PassiveCooldown) * time.Second
+	if cooldown <= 0 {
+		return defaultProxyPassiveCooldown
+	}
+	return cooldown
+}
+
+// ProxyCacheMemoryBytes is the size limit of the in-memory tier of the
+// edge response cache.
+func (cfg *Snapshot) ProxyCacheMemoryBytes() int64 {
+	return megabytes(Resolve(cfg).Proxy.CacheMemoryMB)
+}
+
+// ProxyCacheDiskBytes is the size limit of the disk tier of the edge
+// response cache. Zero disables the disk tier.
+func (cfg *Snapshot) ProxyCacheDiskBytes() int64 {
+	return megabytes(Resolve(cfg).Proxy.CacheDiskMB)
+}
+
+// ProxyCacheMaxObjectBytes is the largest response body the edge cache
+// stores.
+func (cfg *Snapshot) ProxyCacheMaxObjectBytes() int64 {
+	size := megabytes(Resolve(cfg).Proxy.CacheMaxObjectMB)
+	if size <= 0 {
+		return defaultProxyCacheMaxObjectBytes
+	}
+	return size
+}
+
+func megabytes(value int) int64 {
+	if value <= 0 {
+		return 0
+	}
+	return int64(value) << 20
+}
+
+// ProxyOIDCScopes returns the scopes requested from the auth gate provider.
+func (cfg *Snapshot) ProxyOIDCScopes() []string {
+	scopes := strings.FieldsFunc(Resolve(cfg).Proxy.OIDC.Scopes, func(r rune) bool {
+		return r == ',' || r == ' '
+	})
+	if len(scopes) == 0 {
+		return []string{"openid", "email", "profile"}
+	}
+	if !slices.Contains(scopes, "openid") {
+		scopes = append([]string{"openid"}, scopes...)
+	}
+	return scopes
+}
+
+// ProxyOIDCSessionTTL is how long an auth gate session lasts.
+func (cfg *Snapshot) ProxyOIDCSessionTTL() time.Duration {
+	hours := Resolve(cfg).Proxy.OIDC.SessionHours
+	if hours <= 0 {
+		return defaultProxyOIDCSessionTTL
+	}
+	return time.Duration(hours) * time.Hour
+}
+
 func (cfg *Snapshot) ProxySSLCacheMaxEntries() int {
 	maxEntries := Resolve(cfg).Proxy.SSL.CacheMax
 	if maxEntries < 0 {
@@ -70,6 +144,44 @@ func (cfg *Snapshot) ProxySSLCacheIdleInterval() time.Duration {
 	return idle
 }
 
+// ProxySSLDNSResolvers returns the resolvers used to find zones and to check
+// that DNS-01 challenge records have propagated.
+func (cfg *Snapshot) ProxySSLDNSResolvers() []string {
+	return strings.FieldsFunc(Resolve(cfg).Proxy.SSL.DNSResolvers, func(r rune) bool {
+		return r == ',' || r == ' '
+	})
+}
+
+// ProxySSLDNSPropagationTimeout is how long DNS-01 waits for its records to
+// appear. A negative value skips the check.
+func (cfg *Snapshot) ProxySSLDNSPropagationTimeout() time.Duration {
+	timeout := Resolve(cfg).Proxy.SSL.DNSPropagation
+	if timeout < 0 {
+		return -1
+	}
+	return time.Duration(timeout) * time.Second
+}
+
+// ProxySSLDNSPropagationDelay is how long DNS-01 waits before checking its
+// records.
+func (cfg *Snapshot) ProxySSLDNSPropagationDelay() time.Duration {
+	return time.Duration(max(Resolve(cfg).Proxy.SSL.DNSDelay, 0)) * time.Second
+}
+
+// ProxySSLDNSTTL is the TTL of DNS-01 challenge records.
+func (cfg *Snapshot) ProxySSLDNSTTL() time.Duration {
+	ttl := Resolve(cfg).Proxy.SSL.DNSTTL
+	if ttl <= 0 {
+		return 60 * time.Second
+	}
+	return time.Duration(ttl) * time.Second
+}
+
+// ProxySSLIssueWindow is the window of ssl_issue_limit.
+func (cfg *Snapshot) ProxySSLIssueWindow() time.Duration {
+	return time.Duration(max(Resolve(cfg).Proxy.SSL.IssueWindow, 0)) * time.Second
+}
+
 func (cfg *Snapshot) AllowLocalProxyEnabled() bool {
 	return Resolve(cfg).Feature.AllowLocalProxy
 }
@@ -1,6 +1,8 @@
 package servercfg
 
 import (
+	"slices"
+	"strings"
 	"testing"
 	"time"
 )
@@ -63,6 +65,63 @@ func TestProxyResponseHeaderTimeoutNormalizesNonPositiveValues(t *testing.T) {
 	}
 }
 
+func TestProxyPassiveHealthAccessorsNormalizeValues(t *testing.T) {
+	cfg := &Snapshot{Proxy: ProxyConfig{PassiveFailures: -1, PassiveCooldown: 0}}
+	if got := cfg.ProxyPassiveFailureThreshold(); got != 0 {
+		t.Fatalf("ProxyPassiveFailureThreshold() with negative = %d, want 0", got)
+	}
+	if got := cfg.ProxyPassiveCooldown(); got != defaultProxyPassiveCooldown {
+		t.Fatalf("ProxyPassiveCooldown() with zero = %v, want %v", got, defaultProxyPassiveCooldown)
+	}
+	cfg.Proxy.PassiveFailures, cfg.Proxy.PassiveCooldown = 5, 12
+	if got := cfg.ProxyPassiveFailureThreshold(); got != 5 {
+		t.Fatalf("ProxyPassiveFailureThreshold() = %d, want 5", got)
+	}
+	if got := cfg.ProxyPassiveCooldown(); got != 12*time.Second {
+		t.Fatalf("ProxyPassiveCooldown() = %v, want 12s", got)
+	}
+}
+
+func TestProxyEdgeCacheAccessorsNormalizeValues(t *testing.T) {
+	cfg := &Snapshot{Proxy: ProxyConfig{CacheMemoryMB: -1, CacheDiskMB: 0, CacheMaxObjectMB: 0}}
+	if got := cfg.ProxyCacheMemoryBytes(); got != 0 {
+		t.Fatalf("ProxyCacheMemoryBytes() with negative = %d, want 0", got)
+	}
+	if got := cfg.ProxyCacheDiskBytes(); got != 0 {
+		t.Fatalf("ProxyCacheDiskBytes() with zero = %d, want 0", got)
+	}
+	if got := cfg.ProxyCacheMaxObjectBytes(); got != defaultProxyCacheMaxObjectBytes {
+		t.Fatalf("ProxyCacheMaxObjectBytes() with zero = %d, want %d", got, defaultProxyCacheMaxObjectBytes)
+	}
+	cfg.Proxy.CacheMemoryMB, cfg.Proxy.CacheDiskMB, cfg.Proxy.CacheMaxObjectMB = 16, 256, 2
+	if got := cfg.ProxyCacheMemoryBytes(); got != 16<<20 {
+		t.Fatalf("ProxyCacheMemoryBytes() = %d, want %d", got, 16<<20)
+	}
+	if got := cfg.ProxyCacheDiskBytes(); got != 256<<20 {
+		t.Fatalf("ProxyCacheDiskBytes() = %d, want %d", got, 256<<20)
+	}
+	if got := cfg.ProxyCacheMaxObjectBytes(); got != 2<<20 {
+		t.Fatalf("ProxyCacheMaxObjectBytes() = %d, want %d", got, 2<<20)
+	}
+}
+
+func TestProxyOIDCAccessorsNormalizeValues(t *testing.T) {
+	cfg := &Snapshot{Proxy: ProxyConfig{OIDC: OIDCConfig{Scopes: "email, groups", SessionHours: 0}}}
+	if got := strings.Join(cfg.ProxyOIDCScopes(), " "); got != "openid email groups" {
+		t.Fatalf("ProxyOIDCScopes() = %q, want %q", got, "openid email groups")
+	}
+	if got := cfg.ProxyOIDCSessionTTL(); got != defaultProxyOIDCSessionTTL {
+		t.Fatalf("ProxyOIDCSessionTTL() with zero = %s, want %s", got, defaultProxyOIDCSessionTTL)
+	}
+	cfg.Proxy.OIDC.Scopes, cfg.Proxy.OIDC.SessionHours = "", 8
+	if got := strings.Join(cfg.ProxyOIDCScopes(), " "); got != "openid email profile" {
+		t.Fatalf("ProxyOIDCScopes() with empty = %q", got)
+	}
+	if got := cfg.ProxyOIDCSessionTTL(); got != 8*time.Hour {
+		t.Fatalf("ProxyOIDCSessionTTL() = %s, want 8h", got)
+	}
+}
+
 func TestProxySSLCacheAccessorsNormalizeNegativeValues(t *testing.T) {
 	cfg := &Snapshot{
 		Proxy: ProxyConfig{
@@ -84,3 +143,13 @@ func TestProxySSLCacheAccessorsNormalizeNegativeValues(t *testing.T) {
 		t.Fatalf("ProxySSLCacheIdleInterval() = %v, want %v", got, defaultProxySSLCacheIdleTimeout)
 	}
 }
+
+func TestProxySSLDNSAccessors(t *testing.T) {
+	cfg := &Snapshot{Proxy: ProxyConfig{SSL: SSLConfig{DNSResolvers: "127.0.0.1:5353, 1.1.1.1", DNSPropagation: -5, DNSDelay: -1}}}
+	if got := cfg.ProxySSLDNSResolvers(); !slices.Equal(got, []string{"127.0.0.1:5353", "1.1.1.1"}) {
+		t.Fatalf("ProxySSLDNSResolvers() = %v", got)
+	}
+	if cfg.ProxySSLDNSPropagationTimeout() != -1 || cfg.ProxySSLDNSPropagationDelay() != 0 || cfg.ProxySSLDNSTTL() != time.Minute {
+		t.Fatalf("timeout = %v delay = %v ttl = %v", cfg.ProxySSLDNSPropagationTimeout(), cfg.ProxySSLDNSPropagationDelay(), cfg.ProxySSLDNSTTL())
+	}
+}
@@ -54,6 +54,9 @@ func buildRuntimeConfig(r valueReader) RuntimeConfig {
 		NodeIdempotencyTTL:        r.intDefault(300, namespacedKeys("runtime", "node_idempotency_ttl_seconds")...),
 		NodeTrafficReportInterval: nodeTrafficReportInterval,
 		NodeTrafficReportStep:     nodeTrafficReportStep,
+		NodeQuotaAlertPercents:    parseAlertThresholds(r.stringValue(namespacedKeys("runtime", "node_quota_alert_percents")...), 1, 100),
+		NodeExpireAlertDays:       parseAlertThresholds(r.stringValue(namespacedKeys("runtime", "node_expire_alert_days")...), 0, 3650),
+		NodeCertAlertDays:         parseAlertThresholds(r.stringDefault(defaultNodeCertAlertDays, namespacedKeys("runtime", "node_cert_alert_days")...), 0, 3650),
 	}
 	if cfg.RunMode == "" {
 		cfg.RunMode = "standalone"
@@ -74,6 +77,71 @@ func buildRuntimeConfig(r valueReader) RuntimeConfig {
 	return cfg
 }
 
+func buildStorageConfig(r valueReader) StorageConfig {
+	cfg := StorageConfig{
+		Backend: strings.ToLower(strings.TrimSpace(r.stringDefault("json", namespacedKeys("storage", "storage_backend")...))),
+		Path:    strings.TrimSpace(r.stringValue(namespacedKeys("storage", "storage_path")...)),
+
+		JournalEnable:   r.boolDefault(true, namespacedKeys("storage", "journal_enable")...),
+		JournalPath:     strings.TrimSpace(r.stringValue(namespacedKeys("storage", "journal_path")...)),
+		JournalMaxSize:  r.intDefault(16, namespacedKeys("storage", "journal_max_size")...),
+		JournalMaxFiles: r.intDefault(10, namespacedKeys("storage", "journal_max_files")...),
+
+		SnapshotPath:     strings.TrimSpace(r.stringValue(namespacedKeys("storage", "snapshot_path")...)),
+		SnapshotInterval: r.intDefault(0, namespacedKeys("storage", "snapshot_interval")...),
+		SnapshotKeep:     r.intDefault(7, namespacedKeys("storage", "snapshot_keep")...),
+		SnapshotMaxDays:  r.intDefault(0, namespacedKeys("storage", "snapshot_max_days")...),
+
+		TrashPath:          strings.TrimSpace(r.stringValue(namespacedKeys("storage", "trash_path")...)),
+		TrashRetentionDays: r.intDefault(7, namespacedKeys("storage", "trash_retention_days")...),
+
+		HistoryPath: strings.TrimSpace(r.stringValue(namespacedKeys("storage", "history_path")...)),
+		HistoryKeep: r.intDefault(20, namespacedKeys("storage", "history_keep")...),
+
+		CertStorePath: strings.TrimSpace(r.stringValue(namespacedKeys("storage", "cert_store_path")...)),
+
+		UsageSeriesEnable:         r.boolDefault(true, namespacedKeys("storage", "usage_series_enable")...),
+		UsageSeriesPath:           strings.TrimSpace(r.stringValue(namespacedKeys("storage", "usage_series_path")...)),
+		UsageMinuteRetentionHours: r.intDefault(24, namespacedKeys("storage", "usage_minute_retention_hours")...),
+		UsageHourRetentionDays:    r.intDefault(31, namespacedKeys("storage", "usage_hour_retention_days")...),
+		UsageDayRetentionDays:     r.intDefault(400, namespacedKeys("storage", "usage_day_retention_days")...),
+	}
+	if cfg.Backend == "" {
+		cfg.Backend = "json"
+	}
+	if cfg.JournalMaxSize <= 0 {
+		cfg.JournalMaxSize = 16
+	}
+	if cfg.JournalMaxFiles <= 0 {
+		cfg.JournalMaxFiles = 10
+	}
+	if cfg.SnapshotInterval < 0 {
+		cfg.SnapshotInterval = 0
+	}
+	if cfg.SnapshotKeep <= 0 {
+		cfg.SnapshotKeep = 7
+	}
+	if cfg.SnapshotMaxDays < 0 {
+		cfg.SnapshotMaxDays = 0
+	}
+	if cfg.TrashRetentionDays < 0 {
+		cfg.TrashRetentionDays = 0
+	}
+	if cfg.HistoryKeep < 0 {
+		cfg.HistoryKeep = 0
+	}
+	if cfg.UsageMinuteRetentionHours <= 0 {
+		cfg.UsageMinuteRetentionHours = 24
+	}
+	if cfg.UsageHourRetentionDays <= 0 {
+		cfg.UsageHourRetentionDays = 31
+	}
+	if cfg.UsageDayRetentionDays <= 0 {
+		cfg.UsageDayRetentionDays = 400
+	}
+	return cfg
+}
+
 func buildP2PConfig(r valueReader) P2PConfig {
 	return P2PConfig{
 		ProbeExtraReply:           r.boolDefault(true, "p2p_probe_extra_reply"),
@@ -307,18 +375,51 @@ func buildProxyConfig(r valueReader) ProxyConfig {
 		ErrorPageTimeLimit: r.stringValue("error_page_time_limit", "proxy_error_page_time_limit"),
 		ErrorPageFlowLimit: r.stringValue("error_page_flow_limit", "proxy_error_page_flow_limit"),
 		ErrorAlways:        r.boolDefault(false, "error_always", "proxy_error_always"),
+		PassiveFailures:    r.intDefault(3, "http_proxy_passive_failures", "proxy_passive_failures"),
+		PassiveCooldown:    r.intDefault(30, "http_proxy_passive_cooldown", "proxy_passive_cooldown"),
+		RetryIdempotent:    r.boolDefault(true, "http_proxy_retry_idempotent", "proxy_retry_idempotent"),
+		CacheMemoryMB:      r.intDefault(64, "http_proxy_cache_memory_mb", "proxy_cache_memory_mb"),
+		CacheDiskMB:        r.intDefault(0, "http_proxy_cache_disk_mb", "proxy_cache_disk_mb"),
+		CachePath:          strings.TrimSpace(r.stringDefault("cache", "http_proxy_cache_path", "proxy_cache_path")),
+		CacheMaxObjectMB:   r.intDefault(8, "http_proxy_cache_max_object_mb", "proxy_cache_max_object_mb"),
 		BridgeHTTP3:        r.boolDefault(true, "bridge_http3", "proxy_bridge_http3"),
 		ForceAutoSSL:       r.boolDefault(false, "force_auto_ssl", "proxy_force_auto_ssl"),
+		ForwardAuthAllow:   r.stringValue("forward_auth_allow", "proxy_forward_auth_allow"),
 		SSL: SSLConfig{
 			Email:           r.stringValue("ssl_email", "proxy_ssl_email"),
 			CA:              r.stringDefault("LetsEncrypt", "ssl_ca", "proxy_ssl_ca"),
 			Path:            r.stringDefault("ssl", "ssl_path", "proxy_ssl_path"),
 			ZeroSSLAPI:      r.stringValue("ssl_zerossl_api", "proxy_ssl_zerossl_api"),
+			DNSProvider:     strings.TrimSpace(r.stringValue("ssl_dns_provider", "proxy_ssl_dns_provider")),
+			DNSResolvers:    r.stringValue("ssl_dns_resolvers", "proxy_ssl_dns_resolvers"),
+			DNSPropagation:  r.intDefault(120, "ssl_dns_propagation_timeout", "proxy_ssl_dns_propagation_timeout"),
+			DNSDelay:        r.intDefault(0, "ssl_dns_propagation_delay", "proxy_ssl_dns_propagation_delay"),
+			DNSTTL:          r.intDefault(60, "ssl_dns_ttl", "proxy_ssl_dns_ttl"),
+			Ask:             strings.TrimSpace(r.stringValue("ssl_ask", "proxy_ssl_ask")),
+			AskAllow:        r.stringValue("ssl_ask_allow", "proxy_ssl_ask_allow"),
+			IssueLimit:      r.intDefault(3, "ssl_issue_limit", "proxy_ssl_issue_limit"),
+			IssueWindow:     r.intDefault(3600, "ssl_issue_window", "proxy_ssl_issue_window"),
+			TLSMinVersion:   r.stringValue("tls_min_version", "proxy_tls_min_version"),
+			TLSProfile:      r.stringValue("tls_cipher_profile", "proxy_tls_cipher_profile"),
+			TLSALPN:         r.stringValue("tls_alpn", "proxy_tls_alpn"),
+			OCSPStapling:    r.boolDefault(false, "tls_ocsp_stapling", "proxy_tls_ocsp_stapling"),
+			HSTSMaxAge:      r.intDefault(0, "hsts_max_age", "proxy_hsts_max_age"),
+			HSTSSubdomains:  r.boolDefault(false, "hsts_include_subdomains", "proxy_hsts_include_subdomains"),
+			HSTSPreload:     r.boolDefault(false, "hsts_preload", "proxy_hsts_preload"),
 			DefaultCertFile: r.stringValue("https_default_cert_file", "proxy_ssl_default_cert_file"),
 			DefaultKeyFile:  r.stringValue("https_default_key_file", "proxy_ssl_default_key_file"),
 			CacheMax:        r.intDefault(0, "ssl_cache_max", "proxy_ssl_cache_max"),
 			CacheReload:     r.intDefault(0, "ssl_cache_reload", "proxy_ssl_cache_reload"),
 			CacheIdle:       r.intDefault(60, "ssl_cache_idle", "proxy_ssl_cache_idle"),
 		},
+		OIDC: OIDCConfig{
+			Issuer:       strings.TrimSpace(r.stringValue("oidc_issuer", "proxy_oidc_issuer")),
+			ClientID:     strings.TrimSpace(r.stringValue("oidc_client_id", "proxy_oidc_client_id")),
+			ClientSecret: r.stringValue("oidc_client_secret", "proxy_oidc_client_secret"),
+			Scopes:       r.stringDefault("openid email profile", "oidc_scopes", "proxy_oidc_scopes"),
+			GroupsClaim:  strings.TrimSpace(r.stringDefault("groups", "oidc_groups_claim", "proxy_oidc_groups_claim")),
+			CookieSecret: r.stringValue("oidc_cookie_secret", "proxy_oidc_cookie_secret"),
+			SessionHours: r.intDefault(24, "oidc_session_hours", "proxy_oidc_session_hours"),
+		},
 	}
 }
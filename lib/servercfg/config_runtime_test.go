@@ -1,6 +1,7 @@
 package servercfg
 
 import (
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -165,6 +166,9 @@ func TestRuntimeProtocolTuningNormalization(t *testing.T) {
 		"node_idempotency_ttl_seconds=2",
 		"node_traffic_report_interval_seconds=0",
 		"node_traffic_report_step_bytes=512",
+		"node_quota_alert_percents=95, 80%,abc,150,80,100",
+		"node_expire_alert_days=7,0,1,-1",
+		"node_cert_alert_days=14,3",
 	}, "\n")+"\n")
 
 	if err := Load(path); err != nil {
@@ -187,6 +191,27 @@ func TestRuntimeProtocolTuningNormalization(t *testing.T) {
 	if cfg.Runtime.NodeTrafficReportStepBytes() != 1024 {
 		t.Fatalf("NodeTrafficReportStepBytes() = %d, want 1024", cfg.Runtime.NodeTrafficReportStepBytes())
 	}
+	if got := cfg.Runtime.NodeQuotaAlertPercents; !reflect.DeepEqual(got, []int{80, 95, 100}) {
+		t.Fatalf("NodeQuotaAlertPercents = %v, want [80 95 100]", got)
+	}
+	if got := cfg.Runtime.NodeExpireAlertDays; !reflect.DeepEqual(got, []int{0, 1, 7}) {
+		t.Fatalf("NodeExpireAlertDays = %v, want [0 1 7]", got)
+	}
+	if got := cfg.Runtime.NodeCertAlertDays; !reflect.DeepEqual(got, []int{3, 14}) {
+		t.Fatalf("NodeCertAlertDays = %v, want [3 14]", got)
+	}
+
+	path = writeConfig(t, "nps.conf", "node_cert_alert_days=off\n")
+	if err := Load(path); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got := Current().Runtime.NodeCertAlertDays; len(got) != 0 {
+		t.Fatalf("NodeCertAlertDays with off = %v, want none", got)
+	}
+	resetTestState(t)
+	if got := Current().Runtime.NodeCertAlertDays; !reflect.DeepEqual(got, []int{0, 1, 7, 30}) {
+		t.Fatalf("default NodeCertAlertDays = %v, want [0 1 7 30]", got)
+	}
 }
 
 func TestDisconnectTimeoutAccessorNormalizesNonPositiveValues(t *testing.T) {
@@ -295,3 +320,113 @@ func TestStandaloneTokenTTLAndOriginNormalization(t *testing.T) {
 		t.Fatal("StandaloneAllowsOrigin() = true for unexpected origin")
 	}
 }
+
+func TestStorageSettingsDefaultToJSONAndReadOverrides(t *testing.T) {
+	resetTestState(t)
+
+	if cfg := Current(); cfg.Storage.Backend != "json" || cfg.Storage.Path != "" {
+		t.Fatalf("Current().Storage defaults = %+v, want json backend without path", cfg.Storage)
+	}
+
+	path := writeConfig(t, "nps.conf", "storage_backend= Embedded \nstorage_path=/var/lib/nps/nps.db\n")
+	if err := Load(path); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	cfg := Current()
+	if cfg.Storage.Backend != "embedded" {
+		t.Fatalf("Current().Storage.Backend = %q, want embedded", cfg.Storage.Backend)
+	}
+	if cfg.Storage.Path != "/var/lib/nps/nps.db" {
+		t.Fatalf("Current().Storage.Path = %q, want /var/lib/nps/nps.db", cfg.Storage.Path)
+	}
+}
+
+func TestJournalSettingsDefaultToEnabledAndReadOverrides(t *testing.T) {
+	resetTestState(t)
+
+	if cfg := Current(); !cfg.Storage.JournalEnable || cfg.Storage.JournalMaxSize != 16 || cfg.Storage.JournalMaxFiles != 10 {
+		t.Fatalf("Current().Storage journal defaults = %+v", cfg.Storage)
+	}
+
+	path := writeConfig(t, "nps.conf", "journal_enable=false\njournal_path=/var/lib/nps/journal\njournal_max_size=4\njournal_max_files=0\n")
+	if err := Load(path); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	cfg := Current()
+	if cfg.Storage.JournalEnable || cfg.Storage.JournalPath != "/var/lib/nps/journal" || cfg.Storage.JournalMaxSize != 4 {
+		t.Fatalf("Current().Storage journal overrides = %+v", cfg.Storage)
+	}
+	if cfg.Storage.JournalMaxFiles != 10 {
+		t.Fatalf("Current().Storage.JournalMaxFiles = %d, want default 10 for non-positive values", cfg.Storage.JournalMaxFiles)
+	}
+}
+
+func TestSnapshotSettingsDefaultToManualAndReadOverrides(t *testing.T) {
+	resetTestState(t)
+
+	if cfg := Current(); cfg.Storage.SnapshotInterval != 0 || cfg.Storage.SnapshotKeep != 7 || cfg.Storage.SnapshotMaxDays != 0 {
+		t.Fatalf("Current().Storage snapshot defaults = %+v", cfg.Storage)
+	}
+
+	path := writeConfig(t, "nps.conf", "snapshot_path=/var/backups/nps\nsnapshot_interval=60\nsnapshot_keep=-1\nsnapshot_max_days=14\n")
+	if err := Load(path); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	cfg := Current()
+	if cfg.Storage.SnapshotPath != "/var/backups/nps" || cfg.Storage.SnapshotInterval != 60 || cfg.Storage.SnapshotMaxDays != 14 {
+		t.Fatalf("Current().Storage snapshot overrides = %+v", cfg.Storage)
+	}
+	if cfg.Storage.SnapshotKeep != 7 {
+		t.Fatalf("Current().Storage.SnapshotKeep = %d, want default 7 for non-positive values", cfg.Storage.SnapshotKeep)
+	}
+}
+
+func TestTrashSettingsDefaultToSevenDaysAndAllowDisable(t *testing.T) {
+	resetTestState(t)
+
+	if cfg := Current(); cfg.Storage.TrashRetentionDays != 7 || cfg.Storage.TrashPath != "" {
+		t.Fatalf("Current().Storage trash defaults = %+v", cfg.Storage)
+	}
+
+	path := writeConfig(t, "nps.conf", "trash_path=data/trash.json\ntrash_retention_days=0\n")
+	if err := Load(path); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg := Current(); cfg.Storage.TrashPath != "data/trash.json" || cfg.Storage.TrashRetentionDays != 0 {
+		t.Fatalf("Current().Storage trash overrides = %+v", cfg.Storage)
+	}
+}
+
+func TestHistorySettingsDefaultToTwentyRevisions(t *testing.T) {
+	resetTestState(t)
+
+	if cfg := Current(); cfg.Storage.HistoryKeep != 20 || cfg.Storage.HistoryPath != "" {
+		t.Fatalf("Current().Storage history defaults = %+v", cfg.Storage)
+	}
+
+	path := writeConfig(t, "nps.conf", "history_path=data/history\nhistory_keep=-1\n")
+	if err := Load(path); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg := Current(); cfg.Storage.HistoryPath != "data/history" || cfg.Storage.HistoryKeep != 0 {
+		t.Fatalf("Current().Storage history overrides = %+v", cfg.Storage)
+	}
+}
+
+func TestUsageSeriesSettingsNormalizeRetention(t *testing.T) {
+	resetTestState(t)
+
+	if cfg := Current(); !cfg.Storage.UsageSeriesEnable || cfg.Storage.UsageMinuteRetentionHours != 24 ||
+		cfg.Storage.UsageHourRetentionDays != 31 || cfg.Storage.UsageDayRetentionDays != 400 {
+		t.Fatalf("Current().Storage usage series defaults = %+v", cfg.Storage)
+	}
+
+	path := writeConfig(t, "nps.conf", "usage_series_enable=false\nusage_series_path=data/usage\nusage_minute_retention_hours=0\nusage_day_retention_days=730\n")
+	if err := Load(path); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg := Current(); cfg.Storage.UsageSeriesEnable || cfg.Storage.UsageSeriesPath != "data/usage" ||
+		cfg.Storage.UsageMinuteRetentionHours != 24 || cfg.Storage.UsageDayRetentionDays != 730 {
+		t.Fatalf("Current().Storage usage series overrides = %+v", cfg.Storage)
+	}
+}
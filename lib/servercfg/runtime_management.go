@@ -2,6 +2,7 @@ package servercfg
 
 import (
 	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -50,6 +51,30 @@ func (cfg RuntimeConfig) NodeTrafficReportStepBytes() int64 {
 	}
 }
 
+// parseAlertThresholds reads a comma separated list of thresholds, dropping
+// values outside [min, max] and duplicates. The result is sorted ascending.
+// defaultNodeCertAlertDays reports certificate expiry unless turned off, as
+// an expired manual certificate is otherwise noticed only by visitors.
+const defaultNodeCertAlertDays = "30,7,1,0"
+
+func parseAlertThresholds(value string, min, max int) []int {
+	var thresholds []int
+	seen := make(map[int]struct{})
+	for _, item := range splitAndTrimCSV(value) {
+		threshold, err := strconv.Atoi(strings.TrimSuffix(item, "%"))
+		if err != nil || threshold < min || threshold > max {
+			continue
+		}
+		if _, ok := seen[threshold]; ok {
+			continue
+		}
+		seen[threshold] = struct{}{}
+		thresholds = append(thresholds, threshold)
+	}
+	sort.Ints(thresholds)
+	return thresholds
+}
+
 func normalizeRuntimeLimit(value, fallback, min, max int) int {
 	if value <= 0 {
 		value = fallback
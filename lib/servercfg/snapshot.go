@@ -10,6 +10,7 @@ type Snapshot struct {
 	Feature  FeatureConfig
 	Security SecurityConfig
 	Runtime  RuntimeConfig
+	Storage  StorageConfig
 	Network  NetworkConfig
 	Bridge   BridgeConfig
 	Proxy    ProxyConfig
@@ -114,6 +115,38 @@ type RuntimeConfig struct {
 	NodeIdempotencyTTL        int
 	NodeTrafficReportInterval int
 	NodeTrafficReportStep     int64
+	NodeQuotaAlertPercents    []int
+	NodeExpireAlertDays       []int
+	NodeCertAlertDays         []int
+}
+
+type StorageConfig struct {
+	Backend string
+	Path    string
+
+	JournalEnable   bool
+	JournalPath     string
+	JournalMaxSize  int
+	JournalMaxFiles int
+
+	SnapshotPath     string
+	SnapshotInterval int
+	SnapshotKeep     int
+	SnapshotMaxDays  int
+
+	TrashPath          string
+	TrashRetentionDays int
+
+	HistoryPath string
+	HistoryKeep int
+
+	CertStorePath string
+
+	UsageSeriesEnable         bool
+	UsageSeriesPath           string
+	UsageMinuteRetentionHours int
+	UsageHourRetentionDays    int
+	UsageDayRetentionDays     int
 }
 
 type ManagementPlatformConfig struct {
@@ -217,9 +250,18 @@ type ProxyConfig struct {
 	ErrorPageTimeLimit string
 	ErrorPageFlowLimit string
 	ErrorAlways        bool
+	PassiveFailures    int
+	PassiveCooldown    int
+	RetryIdempotent    bool
+	CacheMemoryMB      int
+	CacheDiskMB        int
+	CachePath          string
+	CacheMaxObjectMB   int
 	BridgeHTTP3        bool
 	ForceAutoSSL       bool
+	ForwardAuthAllow   string
 	SSL                SSLConfig
+	OIDC               OIDCConfig
 }
 
 type SSLConfig struct {
@@ -227,6 +269,22 @@ type SSLConfig struct {
 	CA              string
 	Path            string
 	ZeroSSLAPI      string
+	DNSProvider     string
+	DNSResolvers    string
+	DNSPropagation  int
+	DNSDelay        int
+	DNSTTL          int
+	Ask             string
+	AskAllow        string
+	IssueLimit      int
+	IssueWindow     int
+	TLSMinVersion   string
+	TLSProfile      string
+	TLSALPN         string
+	OCSPStapling    bool
+	HSTSMaxAge      int
+	HSTSSubdomains  bool
+	HSTSPreload     bool
 	DefaultCertFile string
 	DefaultKeyFile  string
 	CacheMax        int
@@ -234,6 +292,16 @@ type SSLConfig struct {
 	CacheIdle       int
 }
 
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Scopes       string
+	GroupsClaim  string
+	CookieSecret string
+	SessionHours int
+}
+
 type P2PConfig struct {
 	ProbeExtraReply           bool
 	ForcePredictOnRestricted  bool
@@ -288,6 +356,7 @@ func buildSnapshot(values map[string]any) *Snapshot {
 	cfg.Feature = buildFeatureConfig(r)
 	cfg.Security = buildSecurityConfig(r)
 	cfg.Runtime = buildRuntimeConfig(r)
+	cfg.Storage = buildStorageConfig(r)
 	cfg.Network = buildNetworkConfig(r, cfg.Web)
 	cfg.Bridge = buildBridgeConfig(r, cfg.Network)
 	cfg.Proxy = buildProxyConfig(r)
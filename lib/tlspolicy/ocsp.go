@@ -0,0 +1,155 @@
+package tlspolicy
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// ocspTimeout bounds one request to a responder.
+const ocspTimeout = 10 * time.Second
+
+// ocspRetry is how long a failed request is not repeated.
+const ocspRetry = 10 * time.Minute
+
+// ocspMaxResponse bounds the response read from a responder.
+const ocspMaxResponse = 1 << 20
+
+// Stapler staples OCSP responses to certificates that nps does not manage
+// itself. Responses are fetched in the background and refreshed halfway
+// through their validity, so handshakes never wait for a responder.
+type Stapler struct {
+	http    *http.Client
+	now     func() time.Time
+	mu      sync.Mutex
+	entries map[[32]byte]*ocspEntry
+}
+
+type ocspEntry struct {
+	response []byte
+	expire   time.Time
+	refresh  time.Time
+	fetching bool
+}
+
+var defaultStapler = NewStapler(nil)
+
+// DefaultStapler returns the process wide stapler.
+func DefaultStapler() *Stapler {
+	return defaultStapler
+}
+
+// NewStapler returns a stapler using transport, or the default transport
+// when nil.
+func NewStapler(transport http.RoundTripper) *Stapler {
+	return &Stapler{
+		http:    &http.Client{Transport: transport, Timeout: ocspTimeout},
+		now:     time.Now,
+		entries: make(map[[32]byte]*ocspEntry),
+	}
+}
+
+// Staple returns cert with the cached response of its leaf, or cert itself
+// until one was fetched. Chains without the issuer cannot be stapled.
+func (s *Stapler) Staple(cert *tls.Certificate) *tls.Certificate {
+	if s == nil || cert == nil || len(cert.Certificate) < 2 || len(cert.OCSPStaple) > 0 {
+		return cert
+	}
+	key := sha256.Sum256(cert.Certificate[0])
+	now := s.now()
+	s.mu.Lock()
+	entry, ok := s.entries[key]
+	if !ok {
+		entry = &ocspEntry{}
+		s.entries[key] = entry
+	}
+	if !entry.fetching && !now.Before(entry.refresh) {
+		entry.fetching = true
+		go s.update(entry, cert.Certificate[0], cert.Certificate[1])
+	}
+	response := entry.response
+	if !now.Before(entry.expire) {
+		response = nil
+	}
+	s.mu.Unlock()
+	if response == nil {
+		return cert
+	}
+	stapled := *cert
+	stapled.OCSPStaple = response
+	return &stapled
+}
+
+func (s *Stapler) update(entry *ocspEntry, leafDER, issuerDER []byte) {
+	raw, resp, err := s.fetch(leafDER, issuerDER)
+	now := s.now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry.fetching = false
+	if err != nil {
+		entry.refresh = now.Add(ocspRetry)
+		return
+	}
+	entry.response = raw
+	entry.expire = resp.NextUpdate
+	entry.refresh = resp.ThisUpdate.Add(resp.NextUpdate.Sub(resp.ThisUpdate) / 2)
+	if resp.NextUpdate.IsZero() {
+		entry.expire = now.Add(24 * time.Hour)
+		entry.refresh = now.Add(time.Hour)
+	}
+}
+
+func (s *Stapler) fetch(leafDER, issuerDER []byte) ([]byte, *ocsp.Response, error) {
+	leaf, err := x509.ParseCertificate(leafDER)
+	if err != nil {
+		return nil, nil, err
+	}
+	issuer, err := x509.ParseCertificate(issuerDER)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(leaf.OCSPServer) == 0 {
+		return nil, nil, errors.New("certificate has no ocsp responder")
+	}
+	body, err := ocsp.CreateRequest(leaf, issuer, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), ocspTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, leaf.OCSPServer[0], bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Content-Type", "application/ocsp-request")
+	httpResp, err := s.http.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("ocsp responder answered %d", httpResp.StatusCode)
+	}
+	raw, err := io.ReadAll(io.LimitReader(httpResp.Body, ocspMaxResponse))
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := ocsp.ParseResponseForCert(raw, leaf, issuer)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.Status != ocsp.Good {
+		return nil, nil, fmt.Errorf("ocsp status of %s is %d", leaf.Subject.CommonName, resp.Status)
+	}
+	return raw, resp, nil
+}
@@ -0,0 +1,259 @@
+// Package tlspolicy holds the TLS settings of the HTTPS entry: the minimum
+// version, a cipher suite profile, the ALPN protocols and the HSTS header.
+// The profiles follow the modern, intermediate and old configurations of
+// the Mozilla server side TLS guidelines.
+package tlspolicy
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Cipher suite profiles.
+const (
+	ProfileModern       = "modern"
+	ProfileIntermediate = "intermediate"
+	ProfileCompat       = "compat"
+)
+
+// HSTSOff turns off an HSTS header set globally.
+const HSTSOff = "off"
+
+// hstsPreloadMinAge is the shortest max-age the preload list accepts.
+const hstsPreloadMinAge = 31536000
+
+// ErrInvalidPolicy is returned for settings that cannot be applied.
+var ErrInvalidPolicy = errors.New("invalid tls policy")
+
+var versions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// intermediateSuites are the ECDHE AEAD suites of TLS 1.2. TLS 1.3 suites
+// cannot be configured.
+var intermediateSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+}
+
+// Policy is the stored form of the settings. Empty fields keep the
+// defaults of Go, or of the global policy for hosts.
+type Policy struct {
+	MinVersion    string
+	CipherProfile string
+	ALPN          string
+	HSTS          string
+}
+
+// Normalize validates p and returns its stored form.
+func Normalize(p Policy) (Policy, error) {
+	var err error
+	if p.MinVersion, err = NormalizeVersion(p.MinVersion); err != nil {
+		return Policy{}, err
+	}
+	if p.CipherProfile, err = NormalizeProfile(p.CipherProfile); err != nil {
+		return Policy{}, err
+	}
+	if p.ALPN, err = NormalizeALPN(p.ALPN); err != nil {
+		return Policy{}, err
+	}
+	if p.HSTS, err = NormalizeHSTS(p.HSTS); err != nil {
+		return Policy{}, err
+	}
+	return p, nil
+}
+
+// NormalizeVersion accepts 1.0 to 1.3, optionally prefixed with TLS.
+func NormalizeVersion(value string) (string, error) {
+	value = strings.TrimSpace(strings.ToLower(value))
+	value = strings.TrimSpace(strings.TrimPrefix(strings.TrimPrefix(value, "tls"), "v"))
+	if value == "" {
+		return "", nil
+	}
+	if _, ok := versions[value]; !ok {
+		return "", fmt.Errorf("%w: unknown tls version %q", ErrInvalidPolicy, value)
+	}
+	return value, nil
+}
+
+// NormalizeProfile accepts modern, intermediate and compat.
+func NormalizeProfile(value string) (string, error) {
+	switch value = strings.TrimSpace(strings.ToLower(value)); value {
+	case "", ProfileModern, ProfileIntermediate, ProfileCompat:
+		return value, nil
+	}
+	return "", fmt.Errorf("%w: unknown cipher profile %q", ErrInvalidPolicy, value)
+}
+
+// NormalizeALPN accepts a comma or space separated list of h2 and
+// http/1.1, in order of preference.
+func NormalizeALPN(value string) (string, error) {
+	protos := make([]string, 0, 2)
+	for _, proto := range strings.FieldsFunc(strings.ToLower(value), func(r rune) bool { return r == ',' || r == ' ' }) {
+		if proto != "h2" && proto != "http/1.1" {
+			return "", fmt.Errorf("%w: unsupported alpn protocol %q", ErrInvalidPolicy, proto)
+		}
+		if !slices.Contains(protos, proto) {
+			protos = append(protos, proto)
+		}
+	}
+	return strings.Join(protos, ","), nil
+}
+
+// NormalizeHSTS accepts off or the directives of a Strict-Transport-Security
+// header. Preload needs includeSubDomains and a max-age of at least a year.
+func NormalizeHSTS(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" || strings.EqualFold(value, HSTSOff) {
+		return strings.ToLower(value), nil
+	}
+	maxAge := -1
+	var subdomains, preload bool
+	for _, directive := range strings.FieldsFunc(value, func(r rune) bool { return r == ';' || r == ',' }) {
+		name, arg, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "max-age":
+			age, err := strconv.Atoi(strings.Trim(strings.TrimSpace(arg), `"`))
+			if err != nil || age < 0 {
+				return "", fmt.Errorf("%w: invalid hsts max-age %q", ErrInvalidPolicy, arg)
+			}
+			maxAge = age
+		case "includesubdomains":
+			subdomains = true
+		case "preload":
+			preload = true
+		case "":
+		default:
+			return "", fmt.Errorf("%w: unknown hsts directive %q", ErrInvalidPolicy, name)
+		}
+	}
+	if maxAge < 0 {
+		return "", fmt.Errorf("%w: hsts needs max-age", ErrInvalidPolicy)
+	}
+	if preload && (!subdomains || maxAge < hstsPreloadMinAge) {
+		return "", fmt.Errorf("%w: hsts preload needs includeSubDomains and max-age of at least %d", ErrInvalidPolicy, hstsPreloadMinAge)
+	}
+	if maxAge == 0 {
+		// Tells browsers to forget an earlier header.
+		return "max-age=0", nil
+	}
+	return HSTS(maxAge, subdomains, preload), nil
+}
+
+// HSTS builds a Strict-Transport-Security value. A max-age of zero or less
+// returns an empty value.
+func HSTS(maxAge int, includeSubdomains, preload bool) string {
+	if maxAge <= 0 {
+		return ""
+	}
+	value := "max-age=" + strconv.Itoa(maxAge)
+	if includeSubdomains {
+		value += "; includeSubDomains"
+	}
+	if preload {
+		value += "; preload"
+	}
+	return value
+}
+
+// Merge returns p with its empty fields taken from base.
+func (p Policy) Merge(base Policy) Policy {
+	if p.MinVersion == "" {
+		p.MinVersion = base.MinVersion
+	}
+	if p.CipherProfile == "" {
+		p.CipherProfile = base.CipherProfile
+	}
+	if p.ALPN == "" {
+		p.ALPN = base.ALPN
+	}
+	if p.HSTS == "" {
+		p.HSTS = base.HSTS
+	}
+	return p
+}
+
+// ChangesConfig reports whether Apply changes a tls.Config.
+func (p Policy) ChangesConfig() bool {
+	return p.MinVersion != "" || p.CipherProfile != "" || p.ALPN != ""
+}
+
+// Apply sets the minimum version, cipher suites and ALPN protocols of
+// config. A profile raises the minimum version unless one is set. The ALPN
+// list replaces h2 and http/1.1 only, so h3 and acme-tls/1 stay.
+func (p Policy) Apply(config *tls.Config) {
+	if config == nil {
+		return
+	}
+	switch p.CipherProfile {
+	case ProfileModern:
+		config.MinVersion = tls.VersionTLS13
+	case ProfileIntermediate:
+		config.MinVersion = tls.VersionTLS12
+		config.CipherSuites = slices.Clone(intermediateSuites)
+	case ProfileCompat:
+		config.MinVersion = tls.VersionTLS10
+		config.CipherSuites = compatSuites()
+	}
+	if version, ok := versions[p.MinVersion]; ok {
+		config.MinVersion = version
+	}
+	if p.ALPN != "" {
+		config.NextProtos = replaceALPN(config.NextProtos, strings.Split(p.ALPN, ","))
+	}
+}
+
+// HSTSHeader returns the Strict-Transport-Security value to send, if any.
+func (p Policy) HSTSHeader() string {
+	if p.HSTS == HSTSOff {
+		return ""
+	}
+	return p.HSTS
+}
+
+// compatSuites are the suites Go enables by default plus RSA key exchange
+// with AES, which old clients without ECDHE need. RC4 and 3DES stay off.
+func compatSuites() []uint16 {
+	suites := make([]uint16, 0, 16)
+	for _, suite := range tls.CipherSuites() {
+		if !slices.Contains(suite.SupportedVersions, tls.VersionTLS13) {
+			suites = append(suites, suite.ID)
+		}
+	}
+	return append(suites,
+		tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_RSA_WITH_AES_128_CBC_SHA,
+		tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+	)
+}
+
+func replaceALPN(current, protos []string) []string {
+	next := make([]string, 0, len(current)+len(protos))
+	replaced := false
+	for _, proto := range current {
+		if proto != "h2" && proto != "http/1.1" {
+			next = append(next, proto)
+			continue
+		}
+		if !replaced {
+			next = append(next, protos...)
+			replaced = true
+		}
+	}
+	if !replaced {
+		next = append(next, protos...)
+	}
+	return next
+}
@@ -0,0 +1,156 @@
+package tlspolicy
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+func TestNormalizePolicy(t *testing.T) {
+	got, err := Normalize(Policy{
+		MinVersion:    " TLS1.2 ",
+		CipherProfile: "Intermediate",
+		ALPN:          "http/1.1, h2 http/1.1",
+		HSTS:          "max-age=63072000;includesubdomains; Preload",
+	})
+	want := Policy{MinVersion: "1.2", CipherProfile: "intermediate", ALPN: "http/1.1,h2", HSTS: "max-age=63072000; includeSubDomains; preload"}
+	if err != nil || got != want {
+		t.Fatalf("Normalize() = %+v, %v, want %+v", got, err, want)
+	}
+	for _, invalid := range []Policy{
+		{MinVersion: "1.4"},
+		{CipherProfile: "legacy"},
+		{ALPN: "h3"},
+		{HSTS: "includeSubDomains"},
+		{HSTS: "max-age=600; includeSubDomains; preload"},
+		{HSTS: "max-age=31536000; preload"},
+	} {
+		if _, err := Normalize(invalid); !errors.Is(err, ErrInvalidPolicy) {
+			t.Fatalf("Normalize(%+v) error = %v, want ErrInvalidPolicy", invalid, err)
+		}
+	}
+	if got, _ := NormalizeHSTS("OFF"); got != HSTSOff {
+		t.Fatalf("NormalizeHSTS(OFF) = %q", got)
+	}
+}
+
+func TestPolicyApplyAndMerge(t *testing.T) {
+	global := Policy{CipherProfile: ProfileIntermediate, HSTS: "max-age=600"}
+	host := Policy{MinVersion: "1.3", ALPN: "http/1.1", HSTS: HSTSOff}.Merge(global)
+	config := &tls.Config{NextProtos: []string{"h3", "h2", "http/1.1", "acme-tls/1"}}
+	host.Apply(config)
+	if config.MinVersion != tls.VersionTLS13 || len(config.CipherSuites) != len(intermediateSuites) {
+		t.Fatalf("Apply() min = %x suites = %v", config.MinVersion, config.CipherSuites)
+	}
+	if !slices.Equal(config.NextProtos, []string{"h3", "http/1.1", "acme-tls/1"}) {
+		t.Fatalf("Apply() NextProtos = %v", config.NextProtos)
+	}
+	if host.HSTSHeader() != "" || global.HSTSHeader() != "max-age=600" {
+		t.Fatalf("HSTSHeader() host = %q global = %q", host.HSTSHeader(), global.HSTSHeader())
+	}
+
+	compat := &tls.Config{}
+	Policy{CipherProfile: ProfileCompat}.Apply(compat)
+	if compat.MinVersion != tls.VersionTLS10 || !slices.Contains(compat.CipherSuites, tls.TLS_RSA_WITH_AES_128_GCM_SHA256) {
+		t.Fatalf("compat profile = %x %v", compat.MinVersion, compat.CipherSuites)
+	}
+	if (Policy{HSTS: "max-age=600"}).ChangesConfig() {
+		t.Fatal("HSTS alone must not change the tls config")
+	}
+}
+
+func TestStaplerFetchesInBackground(t *testing.T) {
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, _ := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	ca, _ := x509.ParseCertificate(caDER)
+
+	var requests atomic.Int32
+	responder := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		body, _ := io.ReadAll(r.Body)
+		req, err := ocsp.ParseRequest(body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		now := time.Now()
+		resp, _ := ocsp.CreateResponse(ca, ca, ocsp.Response{
+			Status:       ocsp.Good,
+			SerialNumber: req.SerialNumber,
+			ThisUpdate:   now.Add(-time.Minute),
+			NextUpdate:   now.Add(time.Hour),
+		}, caKey)
+		_, _ = w.Write(resp)
+	}))
+	defer responder.Close()
+
+	leafKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	leafDER, _ := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "a.example.com"},
+		DNSNames:     []string{"a.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		OCSPServer:   []string{responder.URL},
+	}, ca, &leafKey.PublicKey, caKey)
+	cert := &tls.Certificate{Certificate: [][]byte{leafDER, caDER}, PrivateKey: crypto.Signer(leafKey)}
+
+	stapler := NewStapler(nil)
+	if got := stapler.Staple(cert); len(got.OCSPStaple) != 0 {
+		t.Fatal("first handshake must not wait for the responder")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	var stapled *tls.Certificate
+	for time.Now().Before(deadline) {
+		if stapled = stapler.Staple(cert); len(stapled.OCSPStaple) > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if stapled == nil || len(stapled.OCSPStaple) == 0 || len(cert.OCSPStaple) != 0 {
+		t.Fatal("Staple() did not staple the fetched response")
+	}
+	if parsed, err := ocsp.ParseResponseForCert(stapled.OCSPStaple, mustParse(t, leafDER), ca); err != nil || parsed.Status != ocsp.Good {
+		t.Fatalf("stapled response = %+v, %v", parsed, err)
+	}
+	if requests.Load() != 1 {
+		t.Fatalf("responder requests = %d, want 1 until the refresh time", requests.Load())
+	}
+	if got := stapler.Staple(&tls.Certificate{Certificate: [][]byte{leafDER}}); got.OCSPStaple != nil || !bytes.Equal(got.Certificate[0], leafDER) {
+		t.Fatal("a chain without the issuer must be left alone")
+	}
+}
+
+func mustParse(t *testing.T, der []byte) *x509.Certificate {
+	t.Helper()
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("ParseCertificate() error = %v", err)
+	}
+	return cert
+}
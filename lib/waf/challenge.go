@@ -0,0 +1,67 @@
+package waf
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ChallengeCookie holds the proof a browser computes on a challenge page.
+const ChallengeCookie = "nps_waf_pass"
+
+// ChallengeBits is how many leading zero bits the SHA-256 of a proof must
+// have. Each bit doubles the work; 16 takes a browser well under a second.
+const ChallengeBits = 16
+
+// challengeKey signs challenge tokens. It changes on restart, which only
+// makes clients solve the challenge again.
+var challengeKey = func() []byte {
+	key := make([]byte, 32)
+	_, _ = rand.Read(key)
+	return key
+}()
+
+// ChallengeToken returns the token a challenge page hands to a client of a
+// host on the day of now. The token alone does not pass the challenge: the
+// cookie must be token + "." + nonce, where the SHA-256 of that string
+// starts with ChallengeBits zero bits. Proofs stay valid until the end of
+// the next day.
+func ChallengeToken(clientIP, host string, now time.Time) string {
+	return challengeToken(clientIP, host, now.Unix()/86400)
+}
+
+func challengeToken(clientIP, host string, day int64) string {
+	mac := hmac.New(sha256.New, challengeKey)
+	_, _ = mac.Write([]byte(clientIP + "|" + host + "|" + strconv.FormatInt(day, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ChallengePassed reports whether the request carries a valid proof.
+func ChallengePassed(r *http.Request, clientIP, host string, now time.Time) bool {
+	cookie, err := r.Cookie(ChallengeCookie)
+	if err != nil {
+		return false
+	}
+	token, nonce, ok := strings.Cut(cookie.Value, ".")
+	if !ok || nonce == "" || len(nonce) > 20 || !proofSolved(cookie.Value) {
+		return false
+	}
+	day := now.Unix() / 86400
+	for _, candidate := range []int64{day, day - 1} {
+		if hmac.Equal([]byte(token), []byte(challengeToken(clientIP, host, candidate))) {
+			return true
+		}
+	}
+	return false
+}
+
+func proofSolved(proof string) bool {
+	sum := sha256.Sum256([]byte(proof))
+	return binary.BigEndian.Uint32(sum[:4])>>(32-ChallengeBits) == 0
+}
@@ -0,0 +1,420 @@
+// Package waf implements the request firewall rules of domain hosts.
+//
+// Rules are written one per line as an action followed by conditions that
+// must all match:
+//
+//	block path~^/(\.env|wp-admin|wp-login\.php)
+//	challenge ua~(?i)(curl|python-requests) method=GET,HEAD
+//	tag:scanner header:X-Scanner*=acunetix
+//	log body>10M ip=geoip:cn
+//	allow ip=10.0.0.0/8
+//
+// Empty lines and lines starting with # are ignored.
+package waf
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/djylb/nps/lib/logs"
+	"github.com/djylb/nps/lib/policy"
+)
+
+// Action is what a matching rule does with a request.
+type Action string
+
+// Actions of a rule. Block ends the evaluation, and so does allow, which
+// lets the request through without the remaining rules. Challenge is
+// remembered while the later rules still run, so a block after it applies
+// to clients that already passed the challenge; log and tag only record
+// the match.
+const (
+	ActionAllow     Action = "allow"
+	ActionBlock     Action = "block"
+	ActionChallenge Action = "challenge"
+	ActionLog       Action = "log"
+	ActionTag       Action = "tag"
+)
+
+// Rule is one compiled line of a RuleSet.
+type Rule struct {
+	Line   int
+	Text   string
+	Action Action
+	Tag    string
+	conds  []condition
+}
+
+// RuleSet is an immutable list of rules.
+type RuleSet struct {
+	source string
+	rules  []*Rule
+}
+
+type condition struct {
+	field   string
+	header  string
+	op      string
+	values  []string
+	re      *regexp.Regexp
+	size    int64
+	sources *policy.SourceIPPolicy
+}
+
+var operators = []string{"!=", "!~", "^=", "*=", "=", "~", ">", "<"}
+
+// Parse compiles rule text. Errors name the line that failed.
+func Parse(raw string) (*RuleSet, error) {
+	set := &RuleSet{source: raw}
+	for index, line := range strings.Split(strings.ReplaceAll(raw, "\r\n", "\n"), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule, err := parseRule(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", index+1, err)
+		}
+		rule.Line = index + 1
+		set.rules = append(set.rules, rule)
+	}
+	return set, nil
+}
+
+// Compile parses rule text like Parse but skips lines that fail, logging
+// them, so a bad rule saved before validation cannot disable the others.
+func Compile(raw string) *RuleSet {
+	set := &RuleSet{source: raw}
+	for index, line := range strings.Split(strings.ReplaceAll(raw, "\r\n", "\n"), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule, err := parseRule(line)
+		if err != nil {
+			logs.Warn("ignore invalid waf rule on line %d: %v", index+1, err)
+			continue
+		}
+		rule.Line = index + 1
+		set.rules = append(set.rules, rule)
+	}
+	return set
+}
+
+// Normalize returns rule text with blank lines and surrounding spaces
+// removed, or an error when a rule does not compile.
+func Normalize(raw string) (string, error) {
+	if _, err := Parse(raw); err != nil {
+		return "", err
+	}
+	lines := strings.Split(strings.ReplaceAll(raw, "\r\n", "\n"), "\n")
+	normalized := make([]string, 0, len(lines))
+	for _, line := range lines {
+		if line = strings.TrimSpace(line); line != "" {
+			normalized = append(normalized, line)
+		}
+	}
+	return strings.Join(normalized, "\n"), nil
+}
+
+// Source returns the text the set was parsed from.
+func (s *RuleSet) Source() string {
+	if s == nil {
+		return ""
+	}
+	return s.source
+}
+
+// Len returns the number of rules.
+func (s *RuleSet) Len() int {
+	if s == nil {
+		return 0
+	}
+	return len(s.rules)
+}
+
+func parseRule(line string) (*Rule, error) {
+	tokens, err := splitTokens(line)
+	if err != nil {
+		return nil, err
+	}
+	rule := &Rule{Text: line}
+	action, tag, _ := strings.Cut(tokens[0], ":")
+	rule.Action = Action(strings.ToLower(action))
+	switch rule.Action {
+	case ActionAllow, ActionBlock, ActionChallenge, ActionLog:
+		if tag != "" {
+			return nil, fmt.Errorf("action %q takes no argument", action)
+		}
+	case ActionTag:
+		if tag = strings.TrimSpace(tag); tag == "" || strings.ContainsAny(tag, ", ") {
+			return nil, errors.New("tag needs a name without commas or spaces")
+		}
+		rule.Tag = tag
+	default:
+		return nil, fmt.Errorf("unknown action %q", tokens[0])
+	}
+	if len(tokens) == 1 {
+		return nil, errors.New("rule has no condition")
+	}
+	for _, token := range tokens[1:] {
+		cond, err := parseCondition(token)
+		if err != nil {
+			return nil, err
+		}
+		rule.conds = append(rule.conds, cond)
+	}
+	return rule, nil
+}
+
+// splitTokens splits on spaces; a value may be wrapped in double quotes to
+// keep its spaces, as in ua*="Go-http-client 1.1".
+func splitTokens(line string) ([]string, error) {
+	var tokens []string
+	var current strings.Builder
+	quoted := false
+	for _, r := range line {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case !quoted && (r == ' ' || r == '\t'):
+			if current.Len() > 0 {
+				tokens = append(tokens, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if quoted {
+		return nil, errors.New("unterminated quote")
+	}
+	if current.Len() > 0 {
+		tokens = append(tokens, current.String())
+	}
+	return tokens, nil
+}
+
+func parseCondition(token string) (condition, error) {
+	index := strings.IndexAny(token, "!^*=~<>")
+	if index <= 0 {
+		return condition{}, fmt.Errorf("condition %q has no operator", token)
+	}
+	cond := condition{field: strings.ToLower(token[:index])}
+	rest := token[index:]
+	for _, op := range operators {
+		if strings.HasPrefix(rest, op) {
+			cond.op = op
+			break
+		}
+	}
+	if cond.op == "" {
+		return condition{}, fmt.Errorf("condition %q has an unknown operator", token)
+	}
+	value := rest[len(cond.op):]
+	if name, ok := strings.CutPrefix(cond.field, "header:"); ok {
+		if name == "" {
+			return condition{}, fmt.Errorf("condition %q needs a header name", token)
+		}
+		cond.field, cond.header = "header", http.CanonicalHeaderKey(token[len("header:"):index])
+	}
+	switch cond.field {
+	case "method":
+		if cond.op != "=" && cond.op != "!=" {
+			return condition{}, fmt.Errorf("method only supports = and !=")
+		}
+		cond.values = splitList(strings.ToUpper(value))
+	case "ip":
+		if cond.op != "=" && cond.op != "!=" {
+			return condition{}, fmt.Errorf("ip only supports = and !=")
+		}
+		cond.values = splitList(value)
+		cond.sources = policy.CompileSourceIPPolicy(policy.ModeWhitelist, cond.values, policy.Options{})
+	case "body":
+		if cond.op != ">" && cond.op != "<" {
+			return condition{}, fmt.Errorf("body only supports > and <")
+		}
+		size, err := parseSize(value)
+		if err != nil {
+			return condition{}, err
+		}
+		cond.size = size
+	case "path", "query", "host", "ua", "header":
+		switch cond.op {
+		case "~", "!~":
+			re, err := regexp.Compile(value)
+			if err != nil {
+				return condition{}, fmt.Errorf("condition %q: %v", token, err)
+			}
+			cond.re = re
+		case ">", "<":
+			return condition{}, fmt.Errorf("%s does not support %s", cond.field, cond.op)
+		default:
+			cond.values = []string{value}
+		}
+	default:
+		return condition{}, fmt.Errorf("unknown field %q", token[:index])
+	}
+	if len(cond.values) == 0 && (cond.field == "method" || cond.field == "ip") {
+		return condition{}, fmt.Errorf("condition %q has no value", token)
+	}
+	return cond, nil
+}
+
+func splitList(value string) []string {
+	var values []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			values = append(values, item)
+		}
+	}
+	return values
+}
+
+func parseSize(value string) (int64, error) {
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(strings.ToUpper(value), "K"):
+		multiplier = 1 << 10
+	case strings.HasSuffix(strings.ToUpper(value), "M"):
+		multiplier = 1 << 20
+	case strings.HasSuffix(strings.ToUpper(value), "G"):
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		value = value[:len(value)-1]
+	}
+	size, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("invalid body size %q", value)
+	}
+	return size * multiplier, nil
+}
+
+// Result is the outcome of Evaluate. Action is ActionBlock when a rule
+// stopped the request, ActionChallenge when a challenge matched and no
+// block followed, and empty otherwise.
+type Result struct {
+	Action Action
+	Rule   *Rule
+	Logged []*Rule
+	Tags   []string
+	// BodyLimit is set when a block rule only missed because the request
+	// body has no declared length; the caller should cap the body there.
+	BodyLimit int64
+}
+
+// Evaluate runs the rule sets in order against a request from clientIP.
+func Evaluate(r *http.Request, clientIP string, sets ...*RuleSet) Result {
+	var result Result
+	for _, set := range sets {
+		if set == nil {
+			continue
+		}
+		for _, rule := range set.rules {
+			matched, bodyLimit := rule.match(r, clientIP)
+			if !matched {
+				if bodyLimit > 0 && rule.Action == ActionBlock && (result.BodyLimit == 0 || bodyLimit < result.BodyLimit) {
+					result.BodyLimit = bodyLimit
+				}
+				continue
+			}
+			switch rule.Action {
+			case ActionAllow:
+				return result
+			case ActionBlock:
+				result.Action, result.Rule = rule.Action, rule
+				return result
+			case ActionChallenge:
+				if result.Action == "" {
+					result.Action, result.Rule = rule.Action, rule
+				}
+			case ActionLog:
+				result.Logged = append(result.Logged, rule)
+			case ActionTag:
+				if !slices.Contains(result.Tags, rule.Tag) {
+					result.Tags = append(result.Tags, rule.Tag)
+				}
+			}
+		}
+	}
+	return result
+}
+
+// match reports whether every condition holds. When only a body > N
+// condition failed because the body length is unknown, it returns N.
+func (rule *Rule) match(r *http.Request, clientIP string) (bool, int64) {
+	var bodyLimit int64
+	for _, cond := range rule.conds {
+		if cond.field == "body" && cond.op == ">" && r.ContentLength < 0 {
+			bodyLimit = cond.size
+			continue
+		}
+		if !cond.match(r, clientIP) {
+			return false, 0
+		}
+	}
+	if bodyLimit > 0 {
+		return false, bodyLimit
+	}
+	return true, 0
+}
+
+func (cond condition) match(r *http.Request, clientIP string) bool {
+	switch cond.field {
+	case "method":
+		return slices.Contains(cond.values, r.Method) == (cond.op == "=")
+	case "ip":
+		ip := clientIP
+		if host, _, err := net.SplitHostPort(ip); err == nil {
+			ip = host
+		}
+		return cond.sources.AllowsAddr(ip) == (cond.op == "=")
+	case "body":
+		if r.ContentLength < 0 {
+			return false
+		}
+		if cond.op == ">" {
+			return r.ContentLength > cond.size
+		}
+		return r.ContentLength < cond.size
+	}
+	var value string
+	switch cond.field {
+	case "path":
+		value = r.URL.Path
+	case "query":
+		value = r.URL.RawQuery
+		if unescaped, err := url.QueryUnescape(value); err == nil {
+			value = unescaped
+		}
+	case "host":
+		value = r.Host
+	case "ua":
+		value = r.UserAgent()
+	case "header":
+		value = strings.Join(r.Header.Values(cond.header), ", ")
+	}
+	switch cond.op {
+	case "=":
+		return value == cond.values[0]
+	case "!=":
+		return value != cond.values[0]
+	case "^=":
+		return strings.HasPrefix(value, cond.values[0])
+	case "*=":
+		return strings.Contains(value, cond.values[0])
+	case "~":
+		return cond.re.MatchString(value)
+	case "!~":
+		return !cond.re.MatchString(value)
+	}
+	return false
+}
@@ -0,0 +1,148 @@
+package waf
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRejectsInvalidRules(t *testing.T) {
+	for _, raw := range []string{
+		"deny path~/x",
+		"block",
+		"block path",
+		"block path>10",
+		"block body=10",
+		"block path~(",
+		"block color=red",
+		"tag: path=/",
+		`block ua*="unterminated`,
+	} {
+		if _, err := Parse(raw); err == nil {
+			t.Fatalf("Parse(%q) error = nil", raw)
+		}
+	}
+	_, err := Parse("# scanners\n\nblock path^=/.env\nlog method=TRACE x")
+	if err == nil || !strings.Contains(err.Error(), "line 4") {
+		t.Fatalf("Parse() error = %v, want line 4", err)
+	}
+	if set := Compile("block path^=/.env\nbogus\nlog method=TRACE"); set.Len() != 2 {
+		t.Fatalf("Compile() kept %d rules, want 2", set.Len())
+	}
+}
+
+func TestEvaluateActionsInOrder(t *testing.T) {
+	global, err := Parse(`block path~^/(\.env|wp-admin)
+allow ip=10.0.0.0/8
+tag:bot ua~(?i)bot
+log method=POST,PUT query*="union select"`)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	host, err := Parse("challenge ua*=curl\nblock header:X-Api-Key!~^k- path^=/api/")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	request := func(method, target, ua string) *http.Request {
+		req := httptest.NewRequest(method, target, nil)
+		req.Header.Set("User-Agent", ua)
+		return req
+	}
+
+	if result := Evaluate(request(http.MethodGet, "http://example.com/wp-admin/", "Mozilla"), "203.0.113.1", global, host); result.Action != ActionBlock || result.Rule.Line != 1 {
+		t.Fatalf("scanner path = %+v", result)
+	}
+	if result := Evaluate(request(http.MethodGet, "http://example.com/", "curl/8"), "10.1.2.3", global, host); result.Action != "" {
+		t.Fatalf("allow rule did not stop evaluation: %+v", result)
+	}
+	result := Evaluate(request(http.MethodPost, "http://example.com/?q=1%20union%20select", "GoodBot"), "203.0.113.1", global, host)
+	if result.Action != "" || len(result.Logged) != 1 || len(result.Tags) != 1 || result.Tags[0] != "bot" {
+		t.Fatalf("log and tag = %+v", result)
+	}
+	if result := Evaluate(request(http.MethodGet, "http://example.com/", "curl/8"), "203.0.113.1", global, host); result.Action != ActionChallenge {
+		t.Fatalf("user agent challenge = %+v", result)
+	}
+	req := request(http.MethodGet, "http://example.com/api/items", "Mozilla")
+	if result := Evaluate(req, "203.0.113.1", global, host); result.Action != ActionBlock {
+		t.Fatalf("missing api key = %+v", result)
+	}
+	req.Header.Set("X-Api-Key", "k-123")
+	if result := Evaluate(req, "203.0.113.1", global, host); result.Action != "" {
+		t.Fatalf("valid api key = %+v", result)
+	}
+}
+
+func TestEvaluateBodySize(t *testing.T) {
+	set, err := Parse("block body>1K method=POST")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/upload", strings.NewReader(strings.Repeat("a", 2048)))
+	if result := Evaluate(req, "203.0.113.1", set); result.Action != ActionBlock {
+		t.Fatalf("declared large body = %+v", result)
+	}
+	req.ContentLength = -1
+	if result := Evaluate(req, "203.0.113.1", set); result.Action != "" || result.BodyLimit != 1024 {
+		t.Fatalf("unknown body length = %+v, want a 1024 byte limit", result)
+	}
+	req.Method = http.MethodGet
+	if result := Evaluate(req, "203.0.113.1", set); result.BodyLimit != 0 {
+		t.Fatalf("body limit set although another condition failed: %+v", result)
+	}
+}
+
+func TestEvaluateBlocksAfterChallenge(t *testing.T) {
+	set, err := Parse("challenge ua*=curl\nblock path^=/admin")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/admin", nil)
+	req.Header.Set("User-Agent", "curl/8")
+	now := time.Now()
+	req.AddCookie(&http.Cookie{Name: ChallengeCookie, Value: solveChallenge(ChallengeToken("203.0.113.1", "example.com", now))})
+	if !ChallengePassed(req, "203.0.113.1", "example.com", now) {
+		t.Fatal("solved challenge was refused")
+	}
+	if result := Evaluate(req, "203.0.113.1", set); result.Action != ActionBlock || result.Rule.Line != 2 {
+		t.Fatalf("block after a challenge = %+v", result)
+	}
+	req.URL.Path = "/"
+	if result := Evaluate(req, "203.0.113.1", set); result.Action != ActionChallenge || result.Rule.Line != 1 {
+		t.Fatalf("challenge = %+v", result)
+	}
+}
+
+func TestChallengeProof(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	token := ChallengeToken("203.0.113.1", "example.com", now)
+	proof := solveChallenge(token)
+	newRequest := func(value string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+		req.AddCookie(&http.Cookie{Name: ChallengeCookie, Value: value})
+		return req
+	}
+	if !ChallengePassed(newRequest(proof), "203.0.113.1", "example.com", now.Add(24*time.Hour)) {
+		t.Fatal("proof from the previous day was refused")
+	}
+	if ChallengePassed(newRequest(proof), "203.0.113.2", "example.com", now) || ChallengePassed(newRequest(proof), "203.0.113.1", "example.com", now.Add(48*time.Hour)) {
+		t.Fatal("proof was accepted for another client or after it expired")
+	}
+	unsolved := token + ".0"
+	if proofSolved(unsolved) {
+		unsolved = token + ".1"
+	}
+	if ChallengePassed(newRequest(token), "203.0.113.1", "example.com", now) || ChallengePassed(newRequest(unsolved), "203.0.113.1", "example.com", now) {
+		t.Fatal("token without a proof of work was accepted")
+	}
+}
+
+func solveChallenge(token string) string {
+	for nonce := 0; ; nonce++ {
+		if proof := token + "." + strconv.Itoa(nonce); proofSolved(proof) {
+			return proof
+		}
+	}
+}
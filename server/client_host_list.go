@@ -384,7 +384,7 @@ func hostFlowRemain(host *file.Host, asc bool) int64 {
 	if host == nil {
 		return 0
 	}
-	limit := host.EffectiveFlowLimitBytes()
+	limit := host.QuotaLimitBytes()
 	if limit == 0 {
 		if asc {
 			return math.MaxInt64
@@ -406,7 +406,7 @@ func clientFlowRemain(current *file.Client, asc bool) int64 {
 	if current == nil {
 		return 0
 	}
-	limit := current.EffectiveFlowLimitBytes()
+	limit := current.QuotaLimitBytes()
 	if limit == 0 {
 		if asc {
 			return math.MaxInt64
@@ -477,10 +477,12 @@ func snapshotClientForList(client *file.Client) *file.Client {
 		Addr:             client.Addr,
 		LocalAddr:        client.LocalAddr,
 		Remark:           client.Remark,
+		Labels:           file.CloneLabels(client.Labels),
 		Status:           client.Status,
 		IsConnect:        client.IsConnect,
 		ExpireAt:         client.ExpireAt,
 		FlowLimit:        client.FlowLimit,
+		QuotaPeriod:      file.CloneQuotaPeriod(client.QuotaPeriod),
 		RateLimit:        client.RateLimit,
 		Flow:             cloneFlowForList(client.Flow),
 		ExportFlow:       client.ExportFlow,
@@ -520,6 +522,7 @@ func snapshotOwnerUserForList(user *file.User) *file.User {
 		Status:             user.Status,
 		ExpireAt:           user.ExpireAt,
 		FlowLimit:          user.FlowLimit,
+		QuotaPeriod:        file.CloneQuotaPeriod(user.QuotaPeriod),
 		TotalFlow:          cloneFlowForList(user.TotalFlow),
 		TotalTraffic:       cloneTrafficStatsForList(user.TotalTraffic),
 		MaxClients:         user.MaxClients,
@@ -531,6 +534,8 @@ func snapshotOwnerUserForList(user *file.User) *file.User {
 		EntryAclRules:      user.EntryAclRules,
 		DestAclMode:        user.DestAclMode,
 		DestAclRules:       user.DestAclRules,
+		WafRules:           user.WafRules,
+		Labels:             file.CloneLabels(user.Labels),
 		Revision:           user.Revision,
 		UpdatedAt:          user.UpdatedAt,
 		NowConn:            user.NowConn,
@@ -556,6 +561,7 @@ func snapshotTunnelForList(tunnel *file.Tunnel) *file.Tunnel {
 		Ports:          tunnel.Ports,
 		ExpireAt:       tunnel.ExpireAt,
 		FlowLimit:      tunnel.FlowLimit,
+		QuotaPeriod:    file.CloneQuotaPeriod(tunnel.QuotaPeriod),
 		RateLimit:      tunnel.RateLimit,
 		Flow:           cloneFlowForList(tunnel.Flow),
 		Rate:           tunnel.Rate.Clone(),
@@ -564,6 +570,7 @@ func snapshotTunnelForList(tunnel *file.Tunnel) *file.Tunnel {
 		NowConn:        tunnel.NowConn,
 		Password:       tunnel.Password,
 		Remark:         tunnel.Remark,
+		Labels:         file.CloneLabels(tunnel.Labels),
 		TargetAddr:     tunnel.TargetAddr,
 		TargetType:     tunnel.TargetType,
 		EntryAclMode:   tunnel.EntryAclMode,
@@ -577,6 +584,7 @@ func snapshotTunnelForList(tunnel *file.Tunnel) *file.Tunnel {
 		Socks5Proxy:    tunnel.Socks5Proxy,
 		LocalPath:      tunnel.LocalPath,
 		StripPre:       tunnel.StripPre,
+		ForwardAuth:    tunnel.ForwardAuth,
 		ReadOnly:       tunnel.ReadOnly,
 		Target:         cloneTargetForList(tunnel.Target),
 		UserAuth:       cloneMultiAccountForList(tunnel.UserAuth),
@@ -591,46 +599,76 @@ func snapshotHostForList(host *file.Host) *file.Host {
 		return nil
 	}
 	cloned := &file.Host{
-		Id:               host.Id,
-		Revision:         host.Revision,
-		UpdatedAt:        host.UpdatedAt,
-		Host:             host.Host,
-		HeaderChange:     host.HeaderChange,
-		RespHeaderChange: host.RespHeaderChange,
-		HostChange:       host.HostChange,
-		Location:         host.Location,
-		PathRewrite:      host.PathRewrite,
-		Remark:           host.Remark,
-		Scheme:           host.Scheme,
-		RedirectURL:      host.RedirectURL,
-		HttpsJustProxy:   host.HttpsJustProxy,
-		TlsOffload:       host.TlsOffload,
-		AutoSSL:          host.AutoSSL,
-		CertType:         host.CertType,
-		CertHash:         host.CertHash,
-		CertFile:         host.CertFile,
-		KeyFile:          host.KeyFile,
-		NoStore:          host.NoStore,
-		IsClose:          host.IsClose,
-		AutoHttps:        host.AutoHttps,
-		AutoCORS:         host.AutoCORS,
-		CompatMode:       host.CompatMode,
-		ExpireAt:         host.ExpireAt,
-		FlowLimit:        host.FlowLimit,
-		RateLimit:        host.RateLimit,
-		Flow:             cloneFlowForList(host.Flow),
-		Rate:             host.Rate.Clone(),
-		ServiceTraffic:   cloneTrafficStatsForList(host.ServiceTraffic),
-		ServiceMeter:     host.ServiceMeter.Clone(),
-		MaxConn:          host.MaxConn,
-		NowConn:          host.NowConn,
-		Client:           snapshotClientForList(host.Client),
-		EntryAclMode:     host.EntryAclMode,
-		EntryAclRules:    host.EntryAclRules,
-		TargetIsHttps:    host.TargetIsHttps,
-		Target:           cloneTargetForList(host.Target),
-		UserAuth:         cloneMultiAccountForList(host.UserAuth),
-		MultiAccount:     cloneMultiAccountForList(host.MultiAccount),
+		Id:                 host.Id,
+		Revision:           host.Revision,
+		UpdatedAt:          host.UpdatedAt,
+		Host:               host.Host,
+		HeaderChange:       host.HeaderChange,
+		RespHeaderChange:   host.RespHeaderChange,
+		HostChange:         host.HostChange,
+		Location:           host.Location,
+		PathRewrite:        host.PathRewrite,
+		Remark:             host.Remark,
+		Labels:             file.CloneLabels(host.Labels),
+		Scheme:             host.Scheme,
+		RedirectURL:        host.RedirectURL,
+		HttpsJustProxy:     host.HttpsJustProxy,
+		TlsOffload:         host.TlsOffload,
+		AutoSSL:            host.AutoSSL,
+		CertType:           host.CertType,
+		CertHash:           host.CertHash,
+		CertFile:           host.CertFile,
+		KeyFile:            host.KeyFile,
+		NoStore:            host.NoStore,
+		IsClose:            host.IsClose,
+		AutoHttps:          host.AutoHttps,
+		AutoCORS:           host.AutoCORS,
+		RespCompress:       host.RespCompress,
+		RespCompressTypes:  host.RespCompressTypes,
+		RespCompressMin:    host.RespCompressMin,
+		Cache:              host.Cache,
+		CacheTTL:           host.CacheTTL,
+		ReqLimit:           host.ReqLimit,
+		ReqLimitWindow:     host.ReqLimitWindow,
+		ReqLimitKey:        host.ReqLimitKey,
+		ReqLimitPaths:      host.ReqLimitPaths,
+		ForwardAuth:        host.ForwardAuth,
+		ForwardAuthHeaders: host.ForwardAuthHeaders,
+		TLSMinVersion:      host.TLSMinVersion,
+		TLSCipherProfile:   host.TLSCipherProfile,
+		TLSALPN:            host.TLSALPN,
+		HSTS:               host.HSTS,
+		CustomDomain:       host.CustomDomain,
+		CertID:             host.CertID,
+		AutoSSLDNS:         host.AutoSSLDNS,
+		ReqBodyRewrite:     host.ReqBodyRewrite,
+		RespBodyRewrite:    host.RespBodyRewrite,
+		ClientCertCA:       host.ClientCertCA,
+		ClientCertMode:     host.ClientCertMode,
+		ClientCertSubjects: host.ClientCertSubjects,
+		AuthGate:           host.AuthGate,
+		AuthGateEmails:     host.AuthGateEmails,
+		AuthGateGroups:     host.AuthGateGroups,
+		WafRules:           host.WafRules,
+		ReqLimiter:         host.ReqLimiter.Clone(),
+		CompatMode:         host.CompatMode,
+		ExpireAt:           host.ExpireAt,
+		FlowLimit:          host.FlowLimit,
+		QuotaPeriod:        file.CloneQuotaPeriod(host.QuotaPeriod),
+		RateLimit:          host.RateLimit,
+		Flow:               cloneFlowForList(host.Flow),
+		Rate:               host.Rate.Clone(),
+		ServiceTraffic:     cloneTrafficStatsForList(host.ServiceTraffic),
+		ServiceMeter:       host.ServiceMeter.Clone(),
+		MaxConn:            host.MaxConn,
+		NowConn:            host.NowConn,
+		Client:             snapshotClientForList(host.Client),
+		EntryAclMode:       host.EntryAclMode,
+		EntryAclRules:      host.EntryAclRules,
+		TargetIsHttps:      host.TargetIsHttps,
+		Target:             cloneTargetForList(host.Target),
+		UserAuth:           cloneMultiAccountForList(host.UserAuth),
+		MultiAccount:       cloneMultiAccountForList(host.MultiAccount),
 	}
 	copyHealthForList(&cloned.Health, &host.Health)
 	return cloned
@@ -684,6 +722,8 @@ func cloneTargetForList(target *file.Target) *file.Target {
 		TargetArr:     append([]string(nil), target.TargetArr...),
 		LocalProxy:    target.LocalProxy,
 		ProxyProtocol: target.ProxyProtocol,
+		Balance:       target.Balance,
+		BalanceKey:    target.BalanceKey,
 	}
 }
 
@@ -980,7 +1020,7 @@ func tunnelFlowRemain(tunnel *file.Tunnel, asc bool) int64 {
 	if tunnel == nil {
 		return 0
 	}
-	limit := tunnel.EffectiveFlowLimitBytes()
+	limit := tunnel.QuotaLimitBytes()
 	if limit == 0 {
 		if asc {
 			return math.MaxInt64
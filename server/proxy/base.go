@@ -10,6 +10,7 @@ import (
 	"github.com/djylb/nps/lib/conn"
 	"github.com/djylb/nps/lib/file"
 	"github.com/djylb/nps/lib/logs"
+	"github.com/djylb/nps/lib/waf"
 )
 
 var errProxyAccessDenied = errors.New("proxy access denied")
@@ -143,6 +144,10 @@ func (s *BaseServer) IsHostSourceAccessDenied(host *file.Host, ipPort string) bo
 	return s.runtimeContext().accessPolicy.IsHostSourceAccessDenied(host, ipPort)
 }
 
+func (s *BaseServer) HostWAFRules(host *file.Host) []*waf.RuleSet {
+	return s.runtimeContext().accessPolicy.HostWAFRules(host)
+}
+
 func (s *BaseServer) OpenBridgeLink(clientID int, link *conn.Link, task *file.Tunnel) (net.Conn, error) {
 	if s == nil || isNilProxyRuntimeValue(s.linkOpener) {
 		return nil, errProxyBridgeUnavailable
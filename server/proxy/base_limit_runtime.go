@@ -170,7 +170,7 @@ func checkProxyResourceLifecycle(tunnel *file.Tunnel, host *file.Host, nowUnix i
 		if expireAt := tunnel.EffectiveExpireAt(); expireAt > 0 && expireAt <= nowUnix {
 			return errProxyServiceAccessExpired
 		}
-		if flowLimit := tunnel.EffectiveFlowLimitBytes(); flowLimit > 0 {
+		if flowLimit := tunnel.QuotaLimitBytes(); flowLimit > 0 {
 			_, _, total := tunnel.ServiceTrafficTotals()
 			if total >= flowLimit {
 				return errProxyTrafficLimitExceeded
@@ -180,7 +180,7 @@ func checkProxyResourceLifecycle(tunnel *file.Tunnel, host *file.Host, nowUnix i
 		if expireAt := host.EffectiveExpireAt(); expireAt > 0 && expireAt <= nowUnix {
 			return errProxyServiceAccessExpired
 		}
-		if flowLimit := host.EffectiveFlowLimitBytes(); flowLimit > 0 {
+		if flowLimit := host.QuotaLimitBytes(); flowLimit > 0 {
 			_, _, total := host.ServiceTrafficTotals()
 			if total >= flowLimit {
 				return errProxyTrafficLimitExceeded
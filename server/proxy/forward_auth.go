@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	"context"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/djylb/nps/lib/common"
+	"github.com/djylb/nps/lib/file"
+	"github.com/djylb/nps/lib/forwardauth"
+	"github.com/djylb/nps/lib/logs"
+	"github.com/djylb/nps/lib/servercfg"
+)
+
+// allowTunnelForwardAuth asks the forward auth endpoint of a proxy tunnel
+// whether the client may use it. Tunnels without an endpoint always pass.
+func allowTunnelForwardAuth(task *file.Tunnel, req forwardauth.Request) bool {
+	if task == nil || task.ForwardAuth == "" {
+		return true
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), forwardauth.DefaultTimeout)
+	defer cancel()
+	client := forwardauth.Default()
+	client.SetAllow(servercfg.Current().Proxy.ForwardAuthAllow)
+	resp, err := client.Check(ctx, task.ForwardAuth, req)
+	if err != nil {
+		logs.Warn("Forward auth of tunnel id %d failed: %v", task.Id, err)
+		return false
+	}
+	if !resp.Allowed() {
+		logs.Debug("Forward auth of tunnel id %d refused user %q with %d", task.Id, req.User, resp.StatusCode)
+		return false
+	}
+	return true
+}
+
+// authorizeTunnelHTTPProxyForwardAuth checks the Proxy-Authorization
+// credentials of an HTTP proxy request with the forward auth endpoint.
+func authorizeTunnelHTTPProxyForwardAuth(task *file.Tunnel, ingress tunnelHTTPProxyIngress) bool {
+	if task == nil || task.ForwardAuth == "" {
+		return true
+	}
+	r := ingress.request
+	user, password, ok := proxyBasicCredentials(r)
+	if !ok {
+		return false
+	}
+	scheme := "http"
+	if r.Method == http.MethodConnect {
+		scheme = "https"
+	}
+	return allowTunnelForwardAuth(task, forwardauth.Request{
+		Method:   r.Method,
+		Scheme:   scheme,
+		Host:     r.Host,
+		URI:      r.URL.RequestURI(),
+		ClientIP: common.GetIpByAddr(ingress.remoteAddr),
+		Header:   r.Header,
+		User:     user,
+		Password: password,
+	})
+}
+
+// allowSocks5ForwardAuth checks SOCKS5 credentials with the forward auth
+// endpoint of the tunnel.
+func allowSocks5ForwardAuth(task *file.Tunnel, c net.Conn, user, password string) bool {
+	clientIP := ""
+	if c != nil && c.RemoteAddr() != nil {
+		clientIP = common.GetIpByAddr(c.RemoteAddr().String())
+	}
+	return allowTunnelForwardAuth(task, forwardauth.Request{
+		Method:   http.MethodConnect,
+		Scheme:   "socks5",
+		ClientIP: clientIP,
+		User:     user,
+		Password: password,
+	})
+}
+
+func proxyBasicCredentials(r *http.Request) (string, string, bool) {
+	if r == nil {
+		return "", "", false
+	}
+	scheme, encoded, ok := strings.Cut(r.Header.Get("Proxy-Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Basic") {
+		return "", "", false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return "", "", false
+	}
+	return strings.Cut(string(decoded), ":")
+}
@@ -0,0 +1,104 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newForwardAuthTestService(t *testing.T) *httptest.Server {
+	t.Helper()
+	loadProxyRuntimeTestConfig(t, map[string]any{"forward_auth_allow": "127.0.0.1"})
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != "demo" || pass != "secret" || r.Header.Get("X-Forwarded-User") != "demo" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(service.Close)
+	return service
+}
+
+func TestTunnelModeServerSocks5ForwardAuthChecksCredentials(t *testing.T) {
+	service := newForwardAuthTestService(t)
+	for _, tc := range []struct {
+		password string
+		status   byte
+	}{
+		{"secret", authSuccess},
+		{"wrongpw", authFailure},
+	} {
+		task := newTestSocks5Task(1080, "mixProxy")
+		task.ForwardAuth = service.URL
+		if !socks5NeedsAuth(task) {
+			t.Fatal("socks5NeedsAuth() = false with a forward auth endpoint")
+		}
+		server := NewTunnelModeServer(ProcessMix, failBridge{}, task)
+		serverSide, clientSide := net.Pipe()
+		done := make(chan struct{})
+		go func() {
+			server.handleConn(serverSide)
+			close(done)
+		}()
+
+		if _, err := clientSide.Write([]byte{5, 1, UserPassAuth}); err != nil {
+			t.Fatalf("write greeting: %v", err)
+		}
+		negotiation := make([]byte, 2)
+		if _, err := io.ReadFull(clientSide, negotiation); err != nil || !bytes.Equal(negotiation, []byte{5, UserPassAuth}) {
+			t.Fatalf("negotiation reply = %v, %v", negotiation, err)
+		}
+		authPacket := append([]byte{userAuthVersion, 4, 'd', 'e', 'm', 'o', byte(len(tc.password))}, tc.password...)
+		if _, err := clientSide.Write(authPacket); err != nil {
+			t.Fatalf("write auth packet: %v", err)
+		}
+		authReply := make([]byte, 2)
+		if _, err := io.ReadFull(clientSide, authReply); err != nil {
+			t.Fatalf("read auth reply: %v", err)
+		}
+		if !bytes.Equal(authReply, []byte{userAuthVersion, tc.status}) {
+			t.Fatalf("auth reply with password %q = %v, want status %d", tc.password, authReply, tc.status)
+		}
+		_ = clientSide.Close()
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+			t.Fatal("server did not finish forward auth handling in time")
+		}
+	}
+}
+
+func TestAuthorizeTunnelHTTPProxyForwardAuthUsesProxyCredentials(t *testing.T) {
+	service := newForwardAuthTestService(t)
+	task := newTestSocks5Task(8080, "mixProxy")
+	task.ForwardAuth = service.URL
+
+	newIngress := func(user, pass string) tunnelHTTPProxyIngress {
+		req := httptest.NewRequest(http.MethodConnect, "http://example.com:443", nil)
+		if user != "" {
+			req.SetBasicAuth(user, pass)
+			req.Header.Set("Proxy-Authorization", req.Header.Get("Authorization"))
+			req.Header.Del("Authorization")
+		}
+		return tunnelHTTPProxyIngress{targetAddr: "example.com:443", request: req, remoteAddr: "203.0.113.5:4000"}
+	}
+	if !authorizeTunnelHTTPProxyForwardAuth(task, newIngress("demo", "secret")) {
+		t.Fatal("valid proxy credentials were refused")
+	}
+	if authorizeTunnelHTTPProxyForwardAuth(task, newIngress("demo", "wrong")) {
+		t.Fatal("invalid proxy credentials were allowed")
+	}
+	if authorizeTunnelHTTPProxyForwardAuth(task, newIngress("", "")) {
+		t.Fatal("request without proxy credentials was allowed")
+	}
+	task.ForwardAuth = ""
+	if !authorizeTunnelHTTPProxyForwardAuth(task, newIngress("", "")) {
+		t.Fatal("tunnel without forward auth refused the request")
+	}
+}
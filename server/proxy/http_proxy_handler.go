@@ -89,6 +89,9 @@ func authorizeTunnelHTTPProxyIngress(s *TunnelModeServer, c *conn.Conn, task *fi
 	if err := s.Auth(ingress.request, nil, task.Client.Cnf.U, task.Client.Cnf.P, task.MultiAccount, task.UserAuth); err != nil {
 		return closeTunnelHTTPProxyConn(c, common.ProxyAuthRequiredBytes, err)
 	}
+	if !authorizeTunnelHTTPProxyForwardAuth(task, ingress) {
+		return closeTunnelHTTPProxyConn(c, common.ProxyAuthRequiredBytes, errProxyUnauthorized)
+	}
 	if s.IsClientDestinationAccessDenied(task.Client, task, ingress.targetAddr) {
 		return closeTunnelHTTPProxyConn(c, tunnelHTTPProxyForbiddenResponse, errTunnelHTTPProxyDestinationDenied)
 	}
@@ -0,0 +1,198 @@
+package httpproxy
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/djylb/nps/lib/authgate"
+	"github.com/djylb/nps/lib/common"
+	"github.com/djylb/nps/lib/file"
+	"github.com/djylb/nps/lib/logs"
+)
+
+// Paths of the auth gate on every gated host. They are answered by nps and
+// never reach the backend.
+const (
+	authGateCallbackPath = "/.nps/oauth2/callback"
+	authGateLogoutPath   = "/.nps/oauth2/logout"
+)
+
+const (
+	authGateSessionCookie = "nps_auth"
+	authGateLoginCookie   = "nps_auth_login"
+)
+
+// authGateIdentityHeaders are set from the session for the backend. Values
+// sent by the client are always removed.
+var authGateIdentityHeaders = []string{"X-Auth-User", "X-Auth-Email", "X-Auth-Name", "X-Auth-Groups"}
+
+// authGate returns the process wide gate with the provider of the current
+// server config.
+func (s *HttpServer) authGate() *authgate.Gate {
+	cfg := s.currentConfig()
+	oidc := cfg.Proxy.OIDC
+	gate := authgate.Default()
+	gate.Configure(authgate.Config{
+		Issuer:       oidc.Issuer,
+		ClientID:     oidc.ClientID,
+		ClientSecret: oidc.ClientSecret,
+		Scopes:       cfg.ProxyOIDCScopes(),
+		GroupsClaim:  oidc.GroupsClaim,
+		CookieSecret: oidc.CookieSecret,
+		SessionTTL:   cfg.ProxyOIDCSessionTTL(),
+	})
+	return gate
+}
+
+// applyHTTPProxyAuthGate signs users of a gated host in through the OIDC
+// provider. It returns true when the request may go on to the backend, with
+// the identity headers set.
+func (s *HttpServer) applyHTTPProxyAuthGate(w http.ResponseWriter, r *http.Request, host *file.Host, isHTTPOnlyRequest bool) bool {
+	if !host.AuthGate {
+		return true
+	}
+	for _, name := range authGateIdentityHeaders {
+		r.Header.Del(name)
+	}
+	gate := s.authGate()
+	if !gate.Config().Enabled() {
+		logs.Warn("Auth gate of host id %d has no OIDC provider, set oidc_issuer and oidc_client_id", host.Id)
+		http.Error(w, "503 Service Unavailable", http.StatusServiceUnavailable)
+		return false
+	}
+	hostname := common.RemovePortFromHost(r.Host)
+	secure := s.httpProxyRequestScheme(r, isHTTPOnlyRequest) == "https"
+	switch r.URL.Path {
+	case authGateCallbackPath:
+		s.handleAuthGateCallback(w, r, gate, hostname, isHTTPOnlyRequest)
+		return false
+	case authGateLogoutPath:
+		setAuthGateCookie(w, authGateSessionCookie, "", -1, secure)
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = w.Write([]byte("Signed out\n"))
+		return false
+	}
+
+	now := time.Now()
+	if cookie, err := r.Cookie(authGateSessionCookie); err == nil {
+		if identity, ok := gate.DecodeSession(cookie.Value, hostname, now); ok {
+			if !authgate.Allowed(identity, file.AuthGateEmailList(host.AuthGateEmails), file.AuthGateGroupList(host.AuthGateGroups)) {
+				logs.Info("Auth gate denied %s (%s) on host id %d", identity.Subject, identity.Email, host.Id)
+				http.Error(w, "403 Forbidden", http.StatusForbidden)
+				return false
+			}
+			setAuthGateIdentityHeaders(r, identity)
+			return true
+		}
+	}
+
+	if (r.Method != http.MethodGet && r.Method != http.MethodHead) || !strings.Contains(r.Header.Get("Accept"), "text/html") {
+		http.Error(w, "401 Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	login := gate.NewLogin(hostname, r.URL.RequestURI(), now)
+	authURL, err := gate.AuthURL(r.Context(), login, s.authGateRedirectURI(r, isHTTPOnlyRequest))
+	if err != nil {
+		logs.Warn("Auth gate of host id %d cannot reach the OIDC provider: %v", host.Id, err)
+		http.Error(w, "502 Bad Gateway", http.StatusBadGateway)
+		return false
+	}
+	setAuthGateCookie(w, authGateLoginCookie, gate.EncodeLogin(login), 0, secure)
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, authURL, http.StatusFound)
+	return false
+}
+
+func (s *HttpServer) handleAuthGateCallback(w http.ResponseWriter, r *http.Request, gate *authgate.Gate, hostname string, isHTTPOnlyRequest bool) {
+	secure := s.httpProxyRequestScheme(r, isHTTPOnlyRequest) == "https"
+	query := r.URL.Query()
+	if providerErr := query.Get("error"); providerErr != "" {
+		logs.Info("Auth gate sign-in on %s failed: %s %s", hostname, providerErr, query.Get("error_description"))
+		http.Error(w, "403 Forbidden", http.StatusForbidden)
+		return
+	}
+	cookie, err := r.Cookie(authGateLoginCookie)
+	if err != nil {
+		http.Error(w, "400 Bad Request: sign-in expired, reload the page", http.StatusBadRequest)
+		return
+	}
+	now := time.Now()
+	login, ok := gate.DecodeLogin(cookie.Value, hostname, now)
+	if !ok || query.Get("state") != login.State || query.Get("code") == "" {
+		http.Error(w, "400 Bad Request: sign-in expired, reload the page", http.StatusBadRequest)
+		return
+	}
+	identity, err := gate.Exchange(r.Context(), login, query.Get("code"), s.authGateRedirectURI(r, isHTTPOnlyRequest), now)
+	if err != nil {
+		logs.Warn("Auth gate sign-in on %s failed: %v", hostname, err)
+		http.Error(w, "502 Bad Gateway", http.StatusBadGateway)
+		return
+	}
+	setAuthGateCookie(w, authGateLoginCookie, "", -1, secure)
+	setAuthGateCookie(w, authGateSessionCookie, gate.EncodeSession(*identity), int(time.Until(time.Unix(identity.Expires, 0)).Seconds()), secure)
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, login.ReturnTo, http.StatusFound)
+}
+
+// authGateRedirectURI is the callback URL of the host as the browser sees it.
+func (s *HttpServer) authGateRedirectURI(r *http.Request, isHTTPOnlyRequest bool) string {
+	return s.httpProxyRequestScheme(r, isHTTPOnlyRequest) + "://" + r.Host + authGateCallbackPath
+}
+
+// httpProxyRequestScheme returns the scheme the client used. A forwarded
+// scheme is only taken from the HTTP-only pass or a trusted proxy.
+func (s *HttpServer) httpProxyRequestScheme(r *http.Request, isHTTPOnlyRequest bool) string {
+	if s.trustsHTTPProxyForwarding(r, isHTTPOnlyRequest) {
+		if proto := strings.ToLower(strings.TrimSpace(strings.Split(r.Header.Get("X-Forwarded-Proto"), ",")[0])); proto == "http" || proto == "https" {
+			return proto
+		}
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+func setAuthGateCookie(w http.ResponseWriter, name, value string, maxAge int, secure bool) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		Secure:   secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// setAuthGateIdentityHeaders passes the identity to the backend and keeps
+// the gate cookies from it.
+func setAuthGateIdentityHeaders(r *http.Request, identity *authgate.Identity) {
+	for name, value := range map[string]string{
+		"X-Auth-User":   identity.Subject,
+		"X-Auth-Email":  identity.Email,
+		"X-Auth-Name":   identity.Name,
+		"X-Auth-Groups": strings.Join(identity.Groups, ","),
+	} {
+		// Claims come from the provider; drop control characters so they
+		// cannot break the header block.
+		if value = strings.Map(dropControlRune, value); value != "" {
+			r.Header.Set(name, value)
+		}
+	}
+	cookies := r.Cookies()
+	r.Header.Del("Cookie")
+	for _, cookie := range cookies {
+		if cookie.Name != authGateSessionCookie && cookie.Name != authGateLoginCookie {
+			r.AddCookie(cookie)
+		}
+	}
+}
+
+func dropControlRune(r rune) rune {
+	if r < 0x20 || r == 0x7f {
+		return -1
+	}
+	return r
+}
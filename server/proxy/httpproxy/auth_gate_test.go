@@ -0,0 +1,156 @@
+package httpproxy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/djylb/nps/lib/file"
+)
+
+// newAuthGateStubIdP serves discovery and a token endpoint issuing HS256 ID
+// tokens. The authorization code is the nonce of the sign-in.
+func newAuthGateStubIdP(t *testing.T, claims map[string]any) *httptest.Server {
+	t.Helper()
+	var idp *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.URL,
+			"authorization_endpoint": idp.URL + "/authorize",
+			"token_endpoint":         idp.URL + "/token",
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		full := map[string]any{"iss": idp.URL, "aud": "nps", "exp": time.Now().Add(time.Hour).Unix(), "nonce": r.PostFormValue("code")}
+		for key, value := range claims {
+			full[key] = value
+		}
+		header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256"}`))
+		payload, _ := json.Marshal(full)
+		signed := header + "." + base64.RawURLEncoding.EncodeToString(payload)
+		mac := hmac.New(sha256.New, []byte("secret"))
+		_, _ = mac.Write([]byte(signed))
+		token := signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+		_ = json.NewEncoder(w).Encode(map[string]string{"id_token": token})
+	})
+	idp = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+func TestApplyHTTPProxyAuthGateSignsInAndForwardsIdentity(t *testing.T) {
+	idp := newAuthGateStubIdP(t, map[string]any{"sub": "u1", "email": "alice@example.com", "email_verified": true, "groups": []string{"ops"}})
+	loadHTTPProxyTestConfig(t, map[string]any{
+		"oidc_issuer":        idp.URL,
+		"oidc_client_id":     "nps",
+		"oidc_client_secret": "secret",
+		"oidc_cookie_secret": "cookie",
+	})
+	server := &HttpServer{HttpProxy: &HttpProxy{}}
+	host := &file.Host{Id: 71, AuthGate: true, AuthGateEmails: "@example.com"}
+	file.InitializeHostRuntime(host)
+
+	// API clients get 401 instead of a redirect.
+	api := httptest.NewRequest(http.MethodPost, "http://app.example.com/api", nil)
+	recorder := httptest.NewRecorder()
+	if server.applyHTTPProxyAuthGate(recorder, api, host, false) || recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("unauthenticated API request = %d, want 401", recorder.Code)
+	}
+
+	page := httptest.NewRequest(http.MethodGet, "http://app.example.com/reports?q=1", nil)
+	page.Header.Set("Accept", "text/html")
+	recorder = httptest.NewRecorder()
+	if server.applyHTTPProxyAuthGate(recorder, page, host, false) || recorder.Code != http.StatusFound {
+		t.Fatalf("unauthenticated page = %d, want 302", recorder.Code)
+	}
+	location, err := url.Parse(recorder.Header().Get("Location"))
+	if err != nil || !strings.HasPrefix(location.String(), idp.URL+"/authorize") {
+		t.Fatalf("redirect = %q", recorder.Header().Get("Location"))
+	}
+	if got := location.Query().Get("redirect_uri"); got != "http://app.example.com"+authGateCallbackPath {
+		t.Fatalf("redirect_uri = %q", got)
+	}
+	loginCookie := recorder.Result().Cookies()[0]
+
+	callback := httptest.NewRequest(http.MethodGet, "http://app.example.com"+authGateCallbackPath+"?code="+
+		url.QueryEscape(location.Query().Get("nonce"))+"&state="+url.QueryEscape(location.Query().Get("state")), nil)
+	callback.AddCookie(loginCookie)
+	recorder = httptest.NewRecorder()
+	if server.applyHTTPProxyAuthGate(recorder, callback, host, false) || recorder.Code != http.StatusFound {
+		t.Fatalf("callback = %d %s, want 302", recorder.Code, recorder.Body.String())
+	}
+	if got := recorder.Header().Get("Location"); got != "/reports?q=1" {
+		t.Fatalf("callback redirect = %q", got)
+	}
+	var session *http.Cookie
+	for _, cookie := range recorder.Result().Cookies() {
+		if cookie.Name == authGateSessionCookie {
+			session = cookie
+		}
+	}
+	if session == nil || !session.HttpOnly {
+		t.Fatalf("callback cookies = %v", recorder.Result().Cookies())
+	}
+
+	signedIn := httptest.NewRequest(http.MethodGet, "http://app.example.com/reports", nil)
+	signedIn.AddCookie(session)
+	signedIn.AddCookie(&http.Cookie{Name: "app", Value: "1"})
+	signedIn.Header.Set("X-Auth-Email", "forged@example.com")
+	if !server.applyHTTPProxyAuthGate(httptest.NewRecorder(), signedIn, host, false) {
+		t.Fatal("signed-in request was refused")
+	}
+	if signedIn.Header.Get("X-Auth-Email") != "alice@example.com" || signedIn.Header.Get("X-Auth-User") != "u1" || signedIn.Header.Get("X-Auth-Groups") != "ops" {
+		t.Fatalf("identity headers = %v", signedIn.Header)
+	}
+	if got := signedIn.Header.Get("Cookie"); got != "app=1" {
+		t.Fatalf("backend Cookie = %q, want app=1", got)
+	}
+
+	// The session is valid, but the identity is outside the allow lists.
+	host.AuthGateEmails, host.AuthGateGroups = "other.com", "admins"
+	denied := httptest.NewRequest(http.MethodGet, "http://app.example.com/", nil)
+	denied.AddCookie(session)
+	recorder = httptest.NewRecorder()
+	if server.applyHTTPProxyAuthGate(recorder, denied, host, false) || recorder.Code != http.StatusForbidden {
+		t.Fatalf("disallowed identity = %d, want 403", recorder.Code)
+	}
+
+	// Sessions are bound to the host they were issued for.
+	other := httptest.NewRequest(http.MethodGet, "http://other.example.com/", nil)
+	other.AddCookie(session)
+	recorder = httptest.NewRecorder()
+	if server.applyHTTPProxyAuthGate(recorder, other, host, false) || recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("session of another host = %d, want 401", recorder.Code)
+	}
+}
+
+func TestApplyHTTPProxyAuthGateRejectsBadCallbacks(t *testing.T) {
+	idp := newAuthGateStubIdP(t, map[string]any{"sub": "u1"})
+	loadHTTPProxyTestConfig(t, map[string]any{"oidc_issuer": idp.URL, "oidc_client_id": "nps", "oidc_client_secret": "secret"})
+	server := &HttpServer{HttpProxy: &HttpProxy{}}
+	host := &file.Host{Id: 72, AuthGate: true}
+
+	callback := httptest.NewRequest(http.MethodGet, "http://app.example.com"+authGateCallbackPath+"?code=x&state=y", nil)
+	recorder := httptest.NewRecorder()
+	if server.applyHTTPProxyAuthGate(recorder, callback, host, false) || recorder.Code != http.StatusBadRequest {
+		t.Fatalf("callback without a sign-in = %d, want 400", recorder.Code)
+	}
+
+	loadHTTPProxyTestConfig(t, map[string]any{})
+	recorder = httptest.NewRecorder()
+	if server.applyHTTPProxyAuthGate(recorder, httptest.NewRequest(http.MethodGet, "http://app.example.com/", nil), host, false) ||
+		recorder.Code != http.StatusServiceUnavailable {
+		t.Fatalf("gate without a provider = %d, want 503", recorder.Code)
+	}
+	if !server.applyHTTPProxyAuthGate(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://app.example.com/", nil), &file.Host{}, false) {
+		t.Fatal("host without the gate was refused")
+	}
+}
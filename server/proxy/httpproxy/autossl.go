@@ -0,0 +1,220 @@
+package httpproxy
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/certmagic"
+	"github.com/djylb/nps/lib/acmedns"
+	"github.com/djylb/nps/lib/common"
+	"github.com/djylb/nps/lib/file"
+	"github.com/djylb/nps/lib/logs"
+	"github.com/djylb/nps/lib/servercfg"
+)
+
+// autoSSLWildcardRetry is how long a wildcard certificate that could not be
+// obtained is not asked for again. Handshakes meanwhile get certificates
+// for their own name.
+const autoSSLWildcardRetry = 10 * time.Minute
+
+// autoSSLDNS issues certificates over DNS-01 with one provider. Both configs
+// share the certificate cache of the proxy: managed obtains wildcard
+// certificates and renews everything this provider issued, onDemand issues
+// single names during the handshake.
+type autoSSLDNS struct {
+	managed   *certmagic.Config
+	onDemand  *certmagic.Config
+	wildcards sync.Map // name -> *autoSSLWildcard
+}
+
+type autoSSLWildcard struct {
+	mu    sync.Mutex
+	ready bool
+	retry time.Time
+}
+
+// setupAutoSSL prepares the ACME configs. Certificates issued over DNS-01
+// live in the same cache, which renews each with the config of its host.
+func (s *HttpProxy) setupAutoSSL(cfg *servercfg.Snapshot) {
+	certmagic.Default.Logger = logs.ZapLogger
+	certmagic.DefaultACME.Agreed = true
+	certmagic.DefaultACME.Email = cfg.Proxy.SSL.Email
+	switch strings.ToLower(cfg.Proxy.SSL.CA) {
+	case "letsencrypt", "le", "prod", "production":
+		certmagic.DefaultACME.CA = certmagic.LetsEncryptProductionCA
+	case "zerossl", "zero", "zs":
+		certmagic.DefaultACME.CA = certmagic.ZeroSSLProductionCA
+	case "googletrust", "google", "goog":
+		certmagic.DefaultACME.CA = certmagic.GoogleTrustProductionCA
+	default:
+		certmagic.DefaultACME.CA = certmagic.LetsEncryptStagingCA
+	}
+	certmagic.Default.Storage = &certmagic.FileStorage{
+		Path: common.ResolvePath(cfg.Proxy.SSL.Path),
+	}
+	s.magicCache = certmagic.NewCache(certmagic.CacheOptions{
+		GetConfigForCert: s.autoSSLConfigForCert,
+		Logger:           logs.ZapLogger,
+	})
+	s.Magic = certmagic.New(s.magicCache, certmagic.Config{OnDemand: s.autoSSLOnDemand(), OnEvent: s.onAutoSSLEvent})
+	if certmagic.DefaultACME.CA == certmagic.ZeroSSLProductionCA {
+		s.Magic.Issuers = []certmagic.Issuer{
+			&certmagic.ZeroSSLIssuer{
+				APIKey: cfg.Proxy.SSL.ZeroSSLAPI,
+			},
+		}
+	}
+	s.Acme = certmagic.NewACMEIssuer(s.Magic, certmagic.DefaultACME)
+}
+
+func (s *HttpProxy) autoSSLOnDemand() *certmagic.OnDemandConfig {
+	return &certmagic.OnDemandConfig{
+		DecisionFunc: s.decideAutoSSLHost,
+	}
+}
+
+// hostAutoSSLDNS returns the DNS-01 provider of host: its own auto_ssl_dns,
+// or ssl_dns_provider unless the host turned DNS-01 off.
+func (s *HttpProxy) hostAutoSSLDNS(host *file.Host) string {
+	if host == nil {
+		return ""
+	}
+	switch spec := strings.TrimSpace(host.AutoSSLDNS); {
+	case strings.EqualFold(spec, acmedns.Off):
+		return ""
+	case acmedns.IsExec(spec):
+		// Hooks run on this server, so only nps.conf may name them.
+		logs.Warn("ignore exec auto_ssl_dns of host %s", host.Host)
+	case spec != "":
+		return spec
+	}
+	return s.currentConfig().Proxy.SSL.DNSProvider
+}
+
+// autoSSLEnabled reports whether certificates of host are issued here.
+// HTTP-01 and TLS-ALPN-01 need the standard ports, DNS-01 works on any.
+func (s *HttpProxy) autoSSLEnabled(host *file.Host) bool {
+	if host == nil || !host.AutoSSL {
+		return false
+	}
+	if s.HttpPort == 80 || s.HttpsPort == 443 || s.currentConfig().Proxy.ForceAutoSSL {
+		return true
+	}
+	return s.hostAutoSSLDNS(host) != ""
+}
+
+// autoSSLDNSFor returns the configs of a provider, creating them once.
+func (s *HttpProxy) autoSSLDNSFor(spec string) (*autoSSLDNS, error) {
+	s.autoSSLMu.Lock()
+	defer s.autoSSLMu.Unlock()
+	if dns, ok := s.autoSSLDNS[spec]; ok {
+		return dns, nil
+	}
+	if s.magicCache == nil {
+		return nil, fmt.Errorf("auto ssl is not set up")
+	}
+	provider, err := acmedns.New(spec)
+	if err != nil {
+		return nil, err
+	}
+	cfg := s.currentConfig()
+	template := certmagic.DefaultACME
+	template.DisableHTTPChallenge = true
+	template.DisableTLSALPNChallenge = true
+	template.DNS01Solver = &certmagic.DNS01Solver{
+		DNSManager: certmagic.DNSManager{
+			DNSProvider:        provider,
+			TTL:                cfg.ProxySSLDNSTTL(),
+			PropagationDelay:   cfg.ProxySSLDNSPropagationDelay(),
+			PropagationTimeout: cfg.ProxySSLDNSPropagationTimeout(),
+			Resolvers:          cfg.ProxySSLDNSResolvers(),
+			Logger:             logs.ZapLogger,
+		},
+	}
+	dns := &autoSSLDNS{
+		managed:  certmagic.New(s.magicCache, certmagic.Config{OnEvent: s.onAutoSSLEvent}),
+		onDemand: certmagic.New(s.magicCache, certmagic.Config{OnDemand: s.autoSSLOnDemand(), OnEvent: s.onAutoSSLEvent}),
+	}
+	// New falls back to the on-demand settings of certmagic.Default.
+	dns.managed.OnDemand = nil
+	dns.managed.Issuers = []certmagic.Issuer{certmagic.NewACMEIssuer(dns.managed, template)}
+	dns.onDemand.Issuers = []certmagic.Issuer{certmagic.NewACMEIssuer(dns.onDemand, template)}
+	if s.autoSSLDNS == nil {
+		s.autoSSLDNS = make(map[string]*autoSSLDNS)
+	}
+	s.autoSSLDNS[spec] = dns
+	return dns, nil
+}
+
+// getAutoSSLCertificate serves the certificates of auto SSL hosts. Hosts
+// with a DNS-01 provider get them from its configs, and wildcard hosts get
+// one certificate for the whole wildcard.
+func (s *HttpProxy) getAutoSSLCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	var host *file.Host
+	if hello.ServerName != "" {
+		host, _ = s.currentDB().FindCertByHost(hello.ServerName)
+	}
+	spec := s.autoSSLDNSForName(host, hello.ServerName)
+	if spec == "" {
+		return s.Magic.GetCertificate(hello)
+	}
+	dns, err := s.autoSSLDNSFor(spec)
+	if err != nil {
+		return nil, fmt.Errorf("auto ssl dns provider of %s: %w", host.Host, err)
+	}
+	if strings.HasPrefix(host.Host, "*.") {
+		dns.ensureWildcard(hello.Context(), host.Host)
+	}
+	return dns.onDemand.GetCertificate(hello)
+}
+
+// ensureWildcard loads or obtains the certificate of a wildcard name once.
+// Concurrent handshakes wait for the first instead of ordering again.
+func (d *autoSSLDNS) ensureWildcard(ctx context.Context, name string) {
+	value, _ := d.wildcards.LoadOrStore(name, &autoSSLWildcard{})
+	wildcard := value.(*autoSSLWildcard)
+	wildcard.mu.Lock()
+	defer wildcard.mu.Unlock()
+	if wildcard.ready || time.Now().Before(wildcard.retry) {
+		return
+	}
+	if err := d.managed.ManageSync(ctx, []string{name}); err != nil {
+		logs.Warn("Obtain wildcard certificate %s: %v", name, err)
+		if ctx.Err() == nil {
+			wildcard.retry = time.Now().Add(autoSSLWildcardRetry)
+		}
+		return
+	}
+	wildcard.ready = true
+}
+
+// autoSSLConfigForCert picks the config that renews a cached certificate.
+func (s *HttpProxy) autoSSLConfigForCert(cert certmagic.Certificate) (*certmagic.Config, error) {
+	s.autoSSLMu.Lock()
+	for _, dns := range s.autoSSLDNS {
+		for _, name := range cert.Names {
+			if _, ok := dns.wildcards.Load(name); ok {
+				s.autoSSLMu.Unlock()
+				return dns.managed, nil
+			}
+		}
+	}
+	s.autoSSLMu.Unlock()
+	for _, name := range cert.Names {
+		host, err := s.currentDB().FindCertByHost(name)
+		if err != nil || host == nil {
+			continue
+		}
+		if spec := s.autoSSLDNSForName(host, name); spec != "" {
+			if dns, err := s.autoSSLDNSFor(spec); err == nil {
+				return dns.managed, nil
+			}
+		}
+		break
+	}
+	return s.Magic, nil
+}
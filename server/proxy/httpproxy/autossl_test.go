@@ -0,0 +1,110 @@
+package httpproxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caddyserver/certmagic"
+	"github.com/djylb/nps/lib/file"
+	"github.com/djylb/nps/lib/ondemand"
+)
+
+func TestAutoSSLDNSProviderSelection(t *testing.T) {
+	const global = "rfc2136://127.0.0.1:5353"
+	loadHTTPProxyTestConfig(t, map[string]any{"ssl_dns_provider": global, "ssl_path": t.TempDir()})
+	wildcard := &file.Host{Host: "*.example.com", AutoSSL: true}
+	db := &httpProxyDBStub{certHost: wildcard}
+	s := &HttpProxy{HttpPort: 8080, HttpsPort: 8443, dbRoot: func() httpProxyDB { return db }}
+	s.setupAutoSSL(s.currentConfig())
+	defer func() { _ = s.Close() }()
+
+	for spec, want := range map[string]string{
+		"":                             global,
+		"Off":                          "",
+		"exec:/bin/true":               global,
+		"https://hook.example.com/dns": "https://hook.example.com/dns",
+	} {
+		if got := s.hostAutoSSLDNS(&file.Host{AutoSSL: true, AutoSSLDNS: spec}); got != want {
+			t.Fatalf("hostAutoSSLDNS(%q) = %q, want %q", spec, got, want)
+		}
+	}
+	// DNS-01 does not need the standard ports.
+	if !s.autoSSLEnabled(wildcard) || s.autoSSLEnabled(&file.Host{AutoSSL: true, AutoSSLDNS: "off"}) {
+		t.Fatal("autoSSLEnabled() ignored the DNS-01 provider")
+	}
+
+	dns, err := s.autoSSLDNSFor(global)
+	if err != nil {
+		t.Fatalf("autoSSLDNSFor() error = %v", err)
+	}
+	if again, _ := s.autoSSLDNSFor(global); again != dns {
+		t.Fatal("autoSSLDNSFor() built the provider twice")
+	}
+	issuer, ok := dns.managed.Issuers[0].(*certmagic.ACMEIssuer)
+	if !ok || issuer.DNS01Solver == nil || !issuer.DisableHTTPChallenge || !issuer.DisableTLSALPNChallenge {
+		t.Fatalf("managed issuer = %+v", dns.managed.Issuers[0])
+	}
+	if dns.managed.OnDemand != nil || dns.onDemand.OnDemand == nil {
+		t.Fatal("managed config must obtain ahead of time and onDemand config during handshakes")
+	}
+
+	dns.wildcards.Store("*.example.com", &autoSSLWildcard{})
+	if cfg, _ := s.autoSSLConfigForCert(certmagic.Certificate{Names: []string{"*.example.com"}}); cfg != dns.managed {
+		t.Fatal("wildcard certificate is not renewed over DNS-01")
+	}
+	db.certHost = &file.Host{Host: "plain.example.com", AutoSSL: true, AutoSSLDNS: "off"}
+	if cfg, _ := s.autoSSLConfigForCert(certmagic.Certificate{Names: []string{"plain.example.com"}}); cfg != s.Magic {
+		t.Fatal("host without DNS-01 is not renewed with the default config")
+	}
+}
+
+func TestAutoSSLCustomDomainApprovalAndIssueLimit(t *testing.T) {
+	ask := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("domain") != "shop.customer.net" {
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer ask.Close()
+	loadHTTPProxyTestConfig(t, map[string]any{
+		"ssl_dns_provider": "rfc2136://127.0.0.1:5353",
+		"ssl_ask":          ask.URL,
+		"ssl_ask_allow":    "*.partner.io",
+		"ssl_issue_limit":  1,
+	})
+	gate := ondemand.NewGate(nil)
+	previous := httpProxyOnDemandGate
+	httpProxyOnDemandGate = func() *ondemand.Gate { return gate }
+	t.Cleanup(func() { httpProxyOnDemandGate = previous })
+
+	catchAll := &file.Host{Host: "app.saas.io", AutoSSL: true, CustomDomain: true}
+	s := &HttpProxy{HttpPort: 80, HttpsPort: 443, dbRoot: func() httpProxyDB { return &httpProxyDBStub{certHost: catchAll} }}
+	ctx := context.Background()
+	for name, approved := range map[string]bool{
+		"app.saas.io":       true,
+		"shop.customer.net": true,
+		"a.partner.io":      true,
+		"evil.example":      false,
+	} {
+		if err := s.decideAutoSSLHost(ctx, name); (err == nil) != approved {
+			t.Fatalf("decideAutoSSLHost(%q) error = %v, want approved %v", name, err, approved)
+		}
+	}
+	if s.autoSSLDNSForName(catchAll, "shop.customer.net") != "" || s.autoSSLDNSForName(catchAll, "app.saas.io") == "" {
+		t.Fatal("customer domains must not be issued over DNS-01")
+	}
+
+	obtain := map[string]any{"identifier": "shop.customer.net"}
+	if err := s.onAutoSSLEvent(ctx, "cert_obtaining", obtain); err != nil {
+		t.Fatalf("first order error = %v", err)
+	}
+	if err := s.onAutoSSLEvent(ctx, "cert_obtaining", obtain); !errors.Is(err, ondemand.ErrRateLimited) {
+		t.Fatalf("second order error = %v, want ErrRateLimited", err)
+	}
+	renewal := map[string]any{"identifier": "shop.customer.net", "renewal": true}
+	if err := s.onAutoSSLEvent(ctx, "cert_obtaining", renewal); err != nil {
+		t.Fatalf("renewal error = %v, want renewals not limited", err)
+	}
+}
@@ -181,11 +181,11 @@ func TestHttpServerResolveBackendSelectionUsesBridgeRouteSelector(t *testing.T)
 		Target: &file.Target{TargetStr: "127.0.0.1:80\n127.0.0.1:81"},
 	}
 
-	first, err := server.resolveBackendSelection(host)
+	first, err := server.resolveBackendSelection(host, file.TargetHint{})
 	if err != nil {
 		t.Fatalf("resolveBackendSelection() error = %v", err)
 	}
-	second, err := server.resolveBackendSelection(host)
+	second, err := server.resolveBackendSelection(host, file.TargetHint{})
 	if err != nil {
 		t.Fatalf("resolveBackendSelection() second error = %v", err)
 	}
@@ -259,7 +259,7 @@ func TestHttpServerResolveBackendSelectionRejectsMalformedHost(t *testing.T) {
 				},
 			}
 
-			_, err := server.resolveBackendSelection(tt.host)
+			_, err := server.resolveBackendSelection(tt.host, file.TargetHint{})
 			if !errors.Is(err, errHTTPProxyInvalidBackend) {
 				t.Fatalf("resolveBackendSelection() error = %v, want %v", err, errHTTPProxyInvalidBackend)
 			}
@@ -777,7 +777,7 @@ func TestHttpsServerResolveHTTPSProxyBackendUsesSelectedRuntimeRoute(t *testing.
 		Target: &file.Target{TargetStr: "127.0.0.1:8443"},
 	})
 
-	resolved, err := server.resolveHTTPSProxyBackend(host)
+	resolved, err := server.resolveHTTPSProxyBackend(host, nil)
 	if err != nil {
 		t.Fatalf("resolveHTTPSProxyBackend() error = %v", err)
 	}
@@ -820,7 +820,7 @@ func TestHttpsServerResolveHTTPSProxyBackendReleasesLeaseOnTargetError(t *testin
 		Target: &file.Target{},
 	}
 
-	_, err := server.resolveHTTPSProxyBackend(host)
+	_, err := server.resolveHTTPSProxyBackend(host, nil)
 	if err == nil {
 		t.Fatal("resolveHTTPSProxyBackend() error = nil, want target selection failure")
 	}
@@ -0,0 +1,170 @@
+package httpproxy
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/djylb/nps/lib/bodyrewrite"
+	"github.com/djylb/nps/lib/common"
+	"github.com/djylb/nps/lib/file"
+)
+
+// maxReqBodyRewriteBuffer bounds request bodies that are rewritten in memory
+// and sent with a new Content-Length. Larger bodies and bodies of unknown
+// length are streamed to the backend chunked.
+const maxReqBodyRewriteBuffer = 1 << 20
+
+// bodyRewriteVars expands the placeholders of body rewrite rules for r.
+func (s *HttpServer) bodyRewriteVars(r *http.Request, isHTTPOnlyRequest bool) *strings.Replacer {
+	scheme := s.httpProxyRequestScheme(r, isHTTPOnlyRequest)
+	return strings.NewReplacer(
+		"${scheme}", scheme,
+		"${host}", common.RemovePortFromHost(r.Host),
+		"${http_host}", r.Host,
+		"${scheme_host}", scheme+"://"+r.Host,
+	)
+}
+
+// rewritableBody reports whether a body with these headers is text that has
+// not been compressed.
+func rewritableBody(header http.Header) bool {
+	if encoding := header.Get("Content-Encoding"); encoding != "" && !strings.EqualFold(encoding, "identity") {
+		return false
+	}
+	return bodyrewrite.TextContentType(header.Get("Content-Type"))
+}
+
+// applyHTTPProxyRequestBodyRewrite rewrites the text body of r with the
+// req_body_rewrite rules of host. It returns false when the body could not
+// be read and an error was written.
+func (s *HttpServer) applyHTTPProxyRequestBodyRewrite(w http.ResponseWriter, r *http.Request, host *file.Host, vars *strings.Replacer) bool {
+	rules := host.ReqBodyRuleSet()
+	if rules.Len() == 0 || r.Body == nil || r.Body == http.NoBody || !rewritableBody(r.Header) {
+		return true
+	}
+	if r.ContentLength >= 0 && r.ContentLength <= maxReqBodyRewriteBuffer {
+		body, err := io.ReadAll(r.Body)
+		_ = r.Body.Close()
+		if err != nil {
+			http.Error(w, "400 Bad Request", http.StatusBadRequest)
+			return false
+		}
+		body = rules.Rewrite(body, vars)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		r.ContentLength = int64(len(body))
+		r.Header.Set("Content-Length", strconv.Itoa(len(body)))
+		return true
+	}
+	src := r.Body
+	reader, writer := io.Pipe()
+	go func() {
+		rewriter := rules.NewWriter(writer, vars)
+		_, err := io.Copy(rewriter, src)
+		if err == nil {
+			err = rewriter.Close()
+		}
+		_ = src.Close()
+		_ = writer.CloseWithError(err)
+	}()
+	r.Body = reader
+	r.ContentLength = -1
+	r.Header.Del("Content-Length")
+	return true
+}
+
+// newBodyRewriteResponseWriter wraps w so that text responses of host are
+// rewritten with its resp_body_rewrite rules. It returns w unchanged when no
+// rule applies to the request. The returned func must be called once the
+// response is complete.
+func newBodyRewriteResponseWriter(w http.ResponseWriter, r *http.Request, host *file.Host, vars *strings.Replacer) (http.ResponseWriter, func()) {
+	rules := host.RespBodyRuleSet()
+	if rules.Len() == 0 || r == nil || r.Method == http.MethodHead {
+		return w, func() {}
+	}
+	rw := &bodyRewriteResponseWriter{ResponseWriter: w, rules: rules, vars: vars}
+	return rw, rw.finish
+}
+
+// bodyRewriteResponseWriter decides at WriteHeader whether a response is
+// rewritten. Rewritten text goes on to the wrapped writer, which may still
+// compress it.
+type bodyRewriteResponseWriter struct {
+	http.ResponseWriter
+	rules       *bodyrewrite.RuleSet
+	vars        *strings.Replacer
+	rewriter    *bodyrewrite.Writer
+	wroteHeader bool
+	finished    bool
+}
+
+func (w *bodyRewriteResponseWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	if status >= 100 && status < 200 && status != http.StatusSwitchingProtocols {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	w.wroteHeader = true
+	if w.eligible(status) {
+		header := w.Header()
+		header.Del("Content-Length")
+		if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			header.Set("ETag", "W/"+etag)
+		}
+		w.rewriter = w.rules.NewWriter(w.ResponseWriter, w.vars)
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *bodyRewriteResponseWriter) eligible(status int) bool {
+	switch {
+	case status < http.StatusOK, status == http.StatusNoContent, status == http.StatusNotModified, status == http.StatusPartialContent:
+		return false
+	case w.Header().Get("Content-Range") != "":
+		return false
+	}
+	return rewritableBody(w.Header())
+}
+
+func (w *bodyRewriteResponseWriter) Write(p []byte) (int, error) {
+	if w.finished {
+		return 0, net.ErrClosed
+	}
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.rewriter != nil {
+		return w.rewriter.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush passes on what the rules do not hold back for a possible match.
+func (w *bodyRewriteResponseWriter) Flush() {
+	if w.finished {
+		return
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *bodyRewriteResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// finish writes the text the rules still hold back.
+func (w *bodyRewriteResponseWriter) finish() {
+	if w.finished {
+		return
+	}
+	w.finished = true
+	if w.rewriter != nil {
+		_ = w.rewriter.Close()
+	}
+}
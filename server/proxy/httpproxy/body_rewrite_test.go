@@ -0,0 +1,108 @@
+package httpproxy
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/djylb/nps/lib/file"
+)
+
+func TestBodyRewriteResponseWriterRewritesBeforeCompression(t *testing.T) {
+	loadHTTPProxyTestConfig(t, map[string]any{})
+	server := &HttpServer{HttpProxy: &HttpProxy{}}
+	host := &file.Host{RespBodyRewrite: "http://localhost:8080 => ${scheme}://${http_host}"}
+	req := httptest.NewRequest(http.MethodGet, "http://app.example.com:8081/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	recorder := httptest.NewRecorder()
+	compressed, finishCompress := newCompressResponseWriter(recorder, req, host)
+	w, finishRewrite := newBodyRewriteResponseWriter(compressed, req, host, server.bodyRewriteVars(req, false))
+	w.Header().Set("Content-Type", "text/html")
+	w.Header().Set("Content-Length", "4000")
+	w.Header().Set("ETag", `"v1"`)
+	w.WriteHeader(http.StatusOK)
+	page := strings.Repeat(`<a href="http://localhost:8080/x">x</a>`, 100)
+	// Split the page inside a match.
+	_, _ = io.WriteString(w, page[:25])
+	_, _ = io.WriteString(w, page[25:])
+	finishRewrite()
+	finishCompress()
+
+	resp := recorder.Result()
+	if resp.Header.Get("Content-Encoding") != "gzip" || resp.Header.Get("Content-Length") != "" || resp.Header.Get("ETag") != `W/"v1"` {
+		t.Fatalf("headers = %v", resp.Header)
+	}
+	reader, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatalf("gzip.NewReader() error = %v", err)
+	}
+	body, _ := io.ReadAll(reader)
+	if want := strings.Repeat(`<a href="http://app.example.com:8081/x">x</a>`, 100); string(body) != want {
+		t.Fatalf("body = %.120q...", body)
+	}
+}
+
+func TestBodyRewriteResponseWriterSkipsIneligibleResponses(t *testing.T) {
+	host := &file.Host{RespBodyRewrite: "a => b"}
+	for name, setup := range map[string]func(http.Header) int{
+		"binary": func(h http.Header) int { h.Set("Content-Type", "image/png"); return http.StatusOK },
+		"encoded": func(h http.Header) int {
+			h.Set("Content-Type", "text/plain")
+			h.Set("Content-Encoding", "br")
+			return http.StatusOK
+		},
+		"partial": func(h http.Header) int { h.Set("Content-Type", "text/plain"); return http.StatusPartialContent },
+	} {
+		recorder := httptest.NewRecorder()
+		w, finish := newBodyRewriteResponseWriter(recorder, httptest.NewRequest(http.MethodGet, "/", nil), host, nil)
+		w.Header().Set("Content-Length", "3")
+		w.WriteHeader(setup(w.Header()))
+		_, _ = io.WriteString(w, "aaa")
+		finish()
+		if recorder.Body.String() != "aaa" || recorder.Header().Get("Content-Length") != "3" {
+			t.Fatalf("%s: body = %q headers = %v", name, recorder.Body.String(), recorder.Header())
+		}
+	}
+	recorder := httptest.NewRecorder()
+	if w, _ := newBodyRewriteResponseWriter(recorder, httptest.NewRequest(http.MethodGet, "/", nil), &file.Host{}, nil); w != recorder {
+		t.Fatal("host without rules got a wrapped writer")
+	}
+}
+
+func TestApplyHTTPProxyRequestBodyRewrite(t *testing.T) {
+	server := &HttpServer{HttpProxy: &HttpProxy{}}
+	host := &file.Host{ReqBodyRewrite: "https://${http_host} => http://localhost:8080"}
+	vars := strings.NewReplacer("${http_host}", "app.example.com")
+
+	small := httptest.NewRequest(http.MethodPost, "http://app.example.com/save", strings.NewReader(`{"url":"https://app.example.com/a"}`))
+	small.Header.Set("Content-Type", "application/json")
+	if !server.applyHTTPProxyRequestBodyRewrite(httptest.NewRecorder(), small, host, vars) {
+		t.Fatal("small body was refused")
+	}
+	body, _ := io.ReadAll(small.Body)
+	if string(body) != `{"url":"http://localhost:8080/a"}` || small.ContentLength != int64(len(body)) || small.Header.Get("Content-Length") != "33" {
+		t.Fatalf("small body = %q length %d header %q", body, small.ContentLength, small.Header.Get("Content-Length"))
+	}
+
+	streamed := httptest.NewRequest(http.MethodPost, "http://app.example.com/save", io.NopCloser(strings.NewReader("u=https://app.example.com")))
+	streamed.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	streamed.ContentLength = -1
+	if !server.applyHTTPProxyRequestBodyRewrite(httptest.NewRecorder(), streamed, host, vars) {
+		t.Fatal("streamed body was refused")
+	}
+	body, _ = io.ReadAll(streamed.Body)
+	if string(body) != "u=http://localhost:8080" || streamed.ContentLength != -1 {
+		t.Fatalf("streamed body = %q length %d", body, streamed.ContentLength)
+	}
+
+	upload := httptest.NewRequest(http.MethodPost, "http://app.example.com/upload", strings.NewReader("https://app.example.com"))
+	upload.Header.Set("Content-Type", "application/octet-stream")
+	server.applyHTTPProxyRequestBodyRewrite(httptest.NewRecorder(), upload, host, vars)
+	if body, _ = io.ReadAll(upload.Body); string(body) != "https://app.example.com" {
+		t.Fatalf("binary body = %q, want it unchanged", body)
+	}
+}
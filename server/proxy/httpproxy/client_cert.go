@@ -0,0 +1,78 @@
+package httpproxy
+
+import (
+	"crypto/x509"
+	"net/http"
+	"strings"
+
+	"github.com/djylb/nps/lib/file"
+	"github.com/djylb/nps/lib/logs"
+)
+
+// clientCertHeaders describe the client certificate to the backend. Values
+// sent by the client are always removed.
+var clientCertHeaders = []string{
+	"X-Client-Cert-Subject", "X-Client-Cert-Issuer", "X-Client-Cert-Serial",
+	"X-Client-Cert-Fingerprint", "X-Client-Cert-User",
+}
+
+// applyHTTPProxyClientCert checks the client certificate of a host with a
+// client_cert_mode and passes it to the backend. It returns true when the
+// request may go on.
+func (s *HttpServer) applyHTTPProxyClientCert(w http.ResponseWriter, r *http.Request, host *file.Host) bool {
+	if host.ClientCertMode == "" {
+		return true
+	}
+	for _, name := range clientCertHeaders {
+		r.Header.Del(name)
+	}
+	cert := verifiedClientCert(r, host)
+	if cert == nil {
+		if host.ClientCertMode != file.ClientCertRequire {
+			return true
+		}
+		logs.Debug("Host id %d refused %s without a client certificate", host.Id, r.RemoteAddr)
+		http.Error(w, "403 Forbidden: client certificate required", http.StatusForbidden)
+		return false
+	}
+	user, ok := file.MatchClientCertSubject(cert, host.ClientCertSubjects)
+	if !ok {
+		logs.Info("Host id %d refused client certificate %q", host.Id, cert.Subject.String())
+		http.Error(w, "403 Forbidden", http.StatusForbidden)
+		return false
+	}
+	for name, value := range map[string]string{
+		"X-Client-Cert-Subject":     cert.Subject.String(),
+		"X-Client-Cert-Issuer":      cert.Issuer.String(),
+		"X-Client-Cert-Serial":      strings.ToUpper(cert.SerialNumber.Text(16)),
+		"X-Client-Cert-Fingerprint": file.ClientCertFingerprint(cert),
+		"X-Client-Cert-User":        user,
+	} {
+		if value = strings.Map(dropControlRune, value); value != "" {
+			r.Header.Set(name, value)
+		}
+	}
+	return true
+}
+
+// verifiedClientCert returns the client certificate of r when it chains to
+// the CA bundle of host. The TLS session may have been set up for another
+// host name on the same port, so the chain is checked again here.
+func verifiedClientCert(r *http.Request, host *file.Host) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return nil
+	}
+	leaf := r.TLS.PeerCertificates[0]
+	intermediates := x509.NewCertPool()
+	for _, cert := range r.TLS.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	if _, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         host.ClientCertPool(),
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}); err != nil {
+		return nil
+	}
+	return leaf
+}
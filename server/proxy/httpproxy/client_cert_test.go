@@ -0,0 +1,160 @@
+package httpproxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/djylb/nps/lib/file"
+)
+
+// newClientCertTestCA returns a CA bundle in PEM and a client certificate
+// issued by it.
+func newClientCertTestCA(t *testing.T, commonName string) (string, tls.Certificate) {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate CA key: %v", err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test Device CA"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("create CA: %v", err)
+	}
+	ca, _ := x509.ParseCertificate(caDER)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate client key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:   big.NewInt(0x2a),
+		Subject:        pkix.Name{CommonName: commonName, Organization: []string{"Example"}},
+		EmailAddresses: []string{"alice@example.com"},
+		NotBefore:      time.Now().Add(-time.Minute),
+		NotAfter:       time.Now().Add(time.Hour),
+		KeyUsage:       x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("create client certificate: %v", err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})
+	return string(caPEM), tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestWithHostClientCertRequiresCertificateAtHandshake(t *testing.T) {
+	caPEM, clientCert := newClientCertTestCA(t, "laptop-1")
+	certPEM, keyPEM := generateHTTPSProxyTestPEM(t)
+	serverCert, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
+	if err != nil {
+		t.Fatalf("server key pair: %v", err)
+	}
+	shared := &tls.Config{Certificates: []tls.Certificate{serverCert}}
+	host := &file.Host{Id: 91, ClientCertCA: caPEM, ClientCertMode: file.ClientCertRequire}
+
+	config := withHostClientCert(shared, host, true)
+	if config == shared || shared.ClientAuth != tls.NoClientCert {
+		t.Fatal("withHostClientCert() changed the shared config")
+	}
+	if config.ClientAuth != tls.RequireAndVerifyClientCert || !config.SessionTicketsDisabled {
+		t.Fatalf("withHostClientCert() = %v, tickets disabled %v", config.ClientAuth, config.SessionTicketsDisabled)
+	}
+	if got := withHostClientCert(shared, &file.Host{}, true); got != shared {
+		t.Fatal("host without client_cert_mode got a new config")
+	}
+
+	handshake := func(certs []tls.Certificate) error {
+		serverConn, clientConn := net.Pipe()
+		defer serverConn.Close()
+		defer clientConn.Close()
+		done := make(chan error, 1)
+		go func() {
+			done <- tls.Server(serverConn, config).Handshake()
+		}()
+		client := tls.Client(clientConn, &tls.Config{InsecureSkipVerify: true, Certificates: certs})
+		_ = client.Handshake()
+		_ = clientConn.Close()
+		return <-done
+	}
+	if err := handshake(nil); err == nil {
+		t.Fatal("handshake without a client certificate succeeded")
+	}
+	if err := handshake([]tls.Certificate{clientCert}); err != nil {
+		t.Fatalf("handshake with a client certificate: %v", err)
+	}
+}
+
+func TestApplyHTTPProxyClientCertForwardsIdentity(t *testing.T) {
+	caPEM, clientCert := newClientCertTestCA(t, "laptop-1")
+	_, otherCert := newClientCertTestCA(t, "laptop-1")
+	server := &HttpServer{HttpProxy: &HttpProxy{}}
+	host := &file.Host{Id: 92, ClientCertCA: caPEM, ClientCertMode: file.ClientCertRequire, ClientCertSubjects: "alice@example.com=alice\nlaptop-9"}
+
+	request := func(cert *tls.Certificate) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "https://admin.example.com/", nil)
+		r.Header.Set("X-Client-Cert-User", "forged")
+		r.TLS = &tls.ConnectionState{}
+		if cert != nil {
+			r.TLS.PeerCertificates = []*x509.Certificate{cert.Leaf}
+		}
+		return r
+	}
+
+	allowed := request(&clientCert)
+	if !server.applyHTTPProxyClientCert(httptest.NewRecorder(), allowed, host) {
+		t.Fatal("certificate of the CA was refused")
+	}
+	for name, want := range map[string]string{
+		"X-Client-Cert-Subject":     "CN=laptop-1,O=Example",
+		"X-Client-Cert-Issuer":      "CN=Test Device CA",
+		"X-Client-Cert-Serial":      "2A",
+		"X-Client-Cert-Fingerprint": file.ClientCertFingerprint(clientCert.Leaf),
+		"X-Client-Cert-User":        "alice",
+	} {
+		if got := allowed.Header.Get(name); got != want {
+			t.Fatalf("%s = %q, want %q", name, got, want)
+		}
+	}
+
+	// A certificate of another CA, as when the TLS session was set up for
+	// another host name, and a missing certificate are both refused.
+	for _, cert := range []*tls.Certificate{&otherCert, nil} {
+		recorder := httptest.NewRecorder()
+		if server.applyHTTPProxyClientCert(recorder, request(cert), host) || recorder.Code != http.StatusForbidden {
+			t.Fatalf("request with certificate %v = %d, want 403", cert != nil, recorder.Code)
+		}
+	}
+
+	host.ClientCertSubjects = "laptop-9"
+	recorder := httptest.NewRecorder()
+	if server.applyHTTPProxyClientCert(recorder, request(&clientCert), host) || recorder.Code != http.StatusForbidden {
+		t.Fatalf("subject outside the list = %d, want 403", recorder.Code)
+	}
+
+	host.ClientCertMode = file.ClientCertOptional
+	anonymous := request(nil)
+	if !server.applyHTTPProxyClientCert(httptest.NewRecorder(), anonymous, host) || anonymous.Header.Get("X-Client-Cert-User") != "" {
+		t.Fatalf("optional mode without a certificate: headers %v", anonymous.Header)
+	}
+}
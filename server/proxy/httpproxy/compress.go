@@ -0,0 +1,307 @@
+package httpproxy
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/djylb/nps/lib/file"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	compressEncodingGzip   = "gzip"
+	compressEncodingBrotli = "br"
+	compressEncodingZstd   = "zstd"
+
+	// zstd content coding limits the window to 8 MiB (RFC 9659).
+	compressZstdWindowSize = 1 << 22
+
+	// compressBrotliLevel trades ratio for speed; the higher levels are
+	// meant for precompressed static files, not responses on the fly.
+	compressBrotliLevel = 4
+)
+
+// compressEncodings lists the supported content codings in order of
+// preference when the client accepts several with the same weight.
+var compressEncodings = []string{compressEncodingZstd, compressEncodingBrotli, compressEncodingGzip}
+
+var (
+	gzipWriterPool = sync.Pool{New: func() any {
+		w, _ := gzip.NewWriterLevel(io.Discard, gzip.DefaultCompression)
+		return w
+	}}
+	zstdWriterPool = sync.Pool{New: func() any {
+		w, _ := zstd.NewWriter(nil,
+			zstd.WithEncoderLevel(zstd.SpeedDefault),
+			zstd.WithEncoderConcurrency(1),
+			zstd.WithWindowSize(compressZstdWindowSize),
+			zstd.WithLowerEncoderMem(true))
+		return w
+	}}
+	brotliWriterPool = sync.Pool{New: func() any {
+		return brotli.NewWriterLevel(io.Discard, compressBrotliLevel)
+	}}
+)
+
+type compressEncoder interface {
+	io.Writer
+	Flush() error
+	Close() error
+}
+
+// negotiateCompressEncoding picks the content coding for a response from the
+// Accept-Encoding header of the request. It returns "" when the client
+// accepts none of the supported codings.
+func negotiateCompressEncoding(acceptEncoding string) string {
+	if strings.TrimSpace(acceptEncoding) == "" {
+		return ""
+	}
+	weights := make(map[string]float64)
+	wildcard := -1.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		weight := 1.0
+		for _, param := range strings.Split(params, ";") {
+			key, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if ok && strings.EqualFold(strings.TrimSpace(key), "q") {
+				if q, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+					weight = q
+				}
+			}
+		}
+		if name == "*" {
+			wildcard = weight
+			continue
+		}
+		weights[name] = weight
+	}
+	best, bestWeight := "", 0.0
+	for _, encoding := range compressEncodings {
+		weight, ok := weights[encoding]
+		if !ok {
+			weight = max(wildcard, 0)
+		}
+		if weight > bestWeight {
+			best, bestWeight = encoding, weight
+		}
+	}
+	return best
+}
+
+// compressibleContentType reports whether a response Content-Type is in the
+// host allowlist. Entries are media types or "type/*" wildcards.
+func compressibleContentType(contentType string, types []string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, allowed := range types {
+		if prefix, ok := strings.CutSuffix(allowed, "/*"); ok {
+			if strings.HasPrefix(mediaType, prefix+"/") {
+				return true
+			}
+			continue
+		}
+		if mediaType == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// newCompressResponseWriter wraps w so that responses of host are compressed
+// when the client accepts it and the backend did not compress them itself.
+// Hosts with response body rewrite rules compress too, since their backend
+// responses are decoded for the rules.
+// It returns w unchanged when compression does not apply to the request.
+// The returned func must be called once the response is complete.
+func newCompressResponseWriter(w http.ResponseWriter, r *http.Request, host *file.Host) (http.ResponseWriter, func()) {
+	if host == nil || (!host.RespCompress && host.RespBodyRewrite == "") || r == nil || r.Method == http.MethodHead {
+		return w, func() {}
+	}
+	encoding := negotiateCompressEncoding(r.Header.Get("Accept-Encoding"))
+	if encoding == "" {
+		return w, func() {}
+	}
+	cw := &compressResponseWriter{
+		ResponseWriter: w,
+		encoding:       encoding,
+		types:          file.RespCompressTypeList(host.RespCompressTypes),
+		minSize:        host.EffectiveRespCompressMin(),
+	}
+	return cw, cw.finish
+}
+
+// compressResponseWriter decides at WriteHeader whether a response is
+// compressed. Responses of unknown length are buffered until the minimum
+// size is reached. Compressed bytes are written to the wrapped writer, so
+// rate limiting and traffic accounting see what goes over the wire.
+type compressResponseWriter struct {
+	http.ResponseWriter
+	encoding    string
+	types       []string
+	minSize     int
+	status      int
+	wroteHeader bool
+	buffering   bool
+	pending     []byte
+	encoder     compressEncoder
+	finished    bool
+}
+
+func (w *compressResponseWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	if status >= 100 && status < 200 && status != http.StatusSwitchingProtocols {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	w.wroteHeader = true
+	w.status = status
+	if !w.eligible(status) {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if length := w.Header().Get("Content-Length"); length != "" {
+		if size, err := strconv.ParseInt(length, 10, 64); err == nil && size < int64(w.minSize) {
+			w.ResponseWriter.WriteHeader(status)
+			return
+		}
+		w.start()
+		return
+	}
+	w.buffering = true
+}
+
+func (w *compressResponseWriter) eligible(status int) bool {
+	header := w.Header()
+	switch {
+	case status < http.StatusOK, status == http.StatusNoContent, status == http.StatusNotModified, status == http.StatusPartialContent:
+		return false
+	case header.Get("Content-Encoding") != "" && !strings.EqualFold(header.Get("Content-Encoding"), "identity"):
+		return false
+	case header.Get("Content-Range") != "":
+		return false
+	case strings.Contains(strings.ToLower(header.Get("Cache-Control")), "no-transform"):
+		return false
+	}
+	return compressibleContentType(header.Get("Content-Type"), w.types)
+}
+
+func (w *compressResponseWriter) start() {
+	header := w.Header()
+	header.Del("Content-Length")
+	header.Del("Accept-Ranges")
+	header.Set("Content-Encoding", w.encoding)
+	header.Add("Vary", "Accept-Encoding")
+	if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		header.Set("ETag", "W/"+etag)
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	switch w.encoding {
+	case compressEncodingZstd:
+		encoder := zstdWriterPool.Get().(*zstd.Encoder)
+		encoder.Reset(w.ResponseWriter)
+		w.encoder = encoder
+	case compressEncodingBrotli:
+		encoder := brotliWriterPool.Get().(*brotli.Writer)
+		encoder.Reset(w.ResponseWriter)
+		w.encoder = encoder
+	default:
+		encoder := gzipWriterPool.Get().(*gzip.Writer)
+		encoder.Reset(w.ResponseWriter)
+		w.encoder = encoder
+	}
+}
+
+func (w *compressResponseWriter) Write(p []byte) (int, error) {
+	if w.finished {
+		return 0, net.ErrClosed
+	}
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	switch {
+	case w.encoder != nil:
+		return w.encoder.Write(p)
+	case w.buffering:
+		w.pending = append(w.pending, p...)
+		if len(w.pending) < w.minSize {
+			return len(p), nil
+		}
+		w.buffering = false
+		w.start()
+		if _, err := w.encoder.Write(w.pending); err != nil {
+			return 0, err
+		}
+		w.pending = nil
+		return len(p), nil
+	default:
+		return w.ResponseWriter.Write(p)
+	}
+}
+
+// Flush commits a buffered response to compression, so streamed responses
+// keep flowing to the client.
+func (w *compressResponseWriter) Flush() {
+	if w.finished {
+		return
+	}
+	if w.buffering {
+		w.buffering = false
+		w.start()
+		_, _ = w.encoder.Write(w.pending)
+		w.pending = nil
+	}
+	if w.encoder != nil {
+		_ = w.encoder.Flush()
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *compressResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// finish writes what is still buffered and ends the compressed stream.
+func (w *compressResponseWriter) finish() {
+	if w.finished {
+		return
+	}
+	w.finished = true
+	if w.buffering {
+		w.buffering = false
+		w.Header().Set("Content-Length", strconv.Itoa(len(w.pending)))
+		w.ResponseWriter.WriteHeader(w.status)
+		_, _ = w.ResponseWriter.Write(w.pending)
+		w.pending = nil
+		return
+	}
+	if w.encoder == nil {
+		return
+	}
+	_ = w.encoder.Close()
+	switch encoder := w.encoder.(type) {
+	case *zstd.Encoder:
+		encoder.Reset(nil)
+		zstdWriterPool.Put(encoder)
+	case *brotli.Writer:
+		encoder.Reset(io.Discard)
+		brotliWriterPool.Put(encoder)
+	case *gzip.Writer:
+		encoder.Reset(io.Discard)
+		gzipWriterPool.Put(encoder)
+	}
+	w.encoder = nil
+}
@@ -0,0 +1,170 @@
+package httpproxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/djylb/nps/lib/file"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestNegotiateCompressEncoding(t *testing.T) {
+	tests := map[string]string{
+		"":                            "",
+		"br":                          "br",
+		"gzip":                        "gzip",
+		"gzip, deflate, br, zstd":     "zstd",
+		"gzip, deflate, br":           "br",
+		"gzip;q=1, zstd;q=0.5":        "gzip",
+		"zstd;q=0, gzip":              "gzip",
+		"*":                           "zstd",
+		"*;q=0":                       "",
+		"identity, *;q=0.2, zstd;q=0": "br",
+		"deflate":                     "",
+	}
+	for header, want := range tests {
+		if got := negotiateCompressEncoding(header); got != want {
+			t.Fatalf("negotiateCompressEncoding(%q) = %q, want %q", header, got, want)
+		}
+	}
+}
+
+func TestCompressResponseWriterCompressesThroughLimitedWriter(t *testing.T) {
+	host := &file.Host{RespCompress: true}
+	body := strings.Repeat("<p>hello from a slow uplink</p>\n", 200)
+
+	for _, encoding := range []string{"gzip", "br", "zstd"} {
+		recorder := httptest.NewRecorder()
+		var observed int64
+		limited := wrapResponseWriterWithLimiter(recorder, nil, func(size int64) error {
+			observed += size
+			return nil
+		})
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+		req.Header.Set("Accept-Encoding", encoding)
+
+		w, finish := newCompressResponseWriter(limited, req, host)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("ETag", `"v1"`)
+		w.WriteHeader(http.StatusOK)
+		_, _ = io.WriteString(w, body[:100])
+		_, _ = io.WriteString(w, body[100:])
+		finish()
+
+		resp := recorder.Result()
+		if got := resp.Header.Get("Content-Encoding"); got != encoding {
+			t.Fatalf("%s: Content-Encoding = %q", encoding, got)
+		}
+		if got := resp.Header.Get("Vary"); got != "Accept-Encoding" {
+			t.Fatalf("%s: Vary = %q, want Accept-Encoding", encoding, got)
+		}
+		if got := resp.Header.Get("ETag"); got != `W/"v1"` {
+			t.Fatalf("%s: ETag = %q, want weak", encoding, got)
+		}
+		compressed := recorder.Body.Bytes()
+		if int64(len(compressed)) != observed {
+			t.Fatalf("%s: observed %d bytes, wrote %d", encoding, observed, len(compressed))
+		}
+		if len(compressed) >= len(body)/4 {
+			t.Fatalf("%s: compressed %d of %d bytes, want a large reduction", encoding, len(compressed), len(body))
+		}
+		if got := decompressTestBody(t, encoding, compressed); got != body {
+			t.Fatalf("%s: decompressed body differs", encoding)
+		}
+	}
+}
+
+func TestCompressResponseWriterSkipsIneligibleResponses(t *testing.T) {
+	host := &file.Host{RespCompress: true, RespCompressTypes: "application/json"}
+	large := strings.Repeat("x", 4096)
+	tests := []struct {
+		name        string
+		contentType string
+		encoding    string
+		length      bool
+		body        string
+	}{
+		{name: "type not allowed", contentType: "text/html", body: large},
+		{name: "already compressed", contentType: "application/json", encoding: "br", body: large},
+		{name: "below min size", contentType: "application/json", body: `{"ok":true}`},
+		{name: "below min size with length", contentType: "application/json", length: true, body: `{"ok":true}`},
+	}
+	for _, tt := range tests {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		w, finish := newCompressResponseWriter(recorder, req, host)
+		w.Header().Set("Content-Type", tt.contentType)
+		if tt.encoding != "" {
+			w.Header().Set("Content-Encoding", tt.encoding)
+		}
+		if tt.length {
+			w.Header().Set("Content-Length", "11")
+		}
+		_, _ = io.WriteString(w, tt.body)
+		finish()
+		if got := recorder.Header().Get("Content-Encoding"); got != tt.encoding {
+			t.Fatalf("%s: Content-Encoding = %q, want %q", tt.name, got, tt.encoding)
+		}
+		if got := recorder.Body.String(); got != tt.body {
+			t.Fatalf("%s: body was changed", tt.name)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	recorder := httptest.NewRecorder()
+	if w, _ := newCompressResponseWriter(recorder, req, host); w != http.ResponseWriter(recorder) {
+		t.Fatal("request without Accept-Encoding should not be wrapped")
+	}
+}
+
+func TestCompressResponseWriterFlushCommitsStream(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/events", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w, finish := newCompressResponseWriter(recorder, req, &file.Host{RespCompress: true})
+	w.Header().Set("Content-Type", "text/event-stream")
+	_, _ = io.WriteString(w, "data: 1\n\n")
+	w.(http.Flusher).Flush()
+	if !recorder.Flushed || recorder.Body.Len() == 0 {
+		t.Fatal("Flush() did not send the buffered event")
+	}
+	_, _ = io.WriteString(w, "data: 2\n\n")
+	finish()
+	if got := decompressTestBody(t, "gzip", recorder.Body.Bytes()); got != "data: 1\n\ndata: 2\n\n" {
+		t.Fatalf("stream body = %q", got)
+	}
+}
+
+func decompressTestBody(t *testing.T, encoding string, data []byte) string {
+	t.Helper()
+	var reader io.Reader
+	switch encoding {
+	case "gzip":
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("gzip.NewReader() error = %v", err)
+		}
+		reader = gz
+	case "br":
+		reader = brotli.NewReader(bytes.NewReader(data))
+	case "zstd":
+		zr, err := zstd.NewReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("zstd.NewReader() error = %v", err)
+		}
+		defer zr.Close()
+		reader = zr
+	}
+	out, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("decompress %s error = %v", encoding, err)
+	}
+	return string(out)
+}
@@ -0,0 +1,422 @@
+package httpproxy
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/djylb/nps/lib/common"
+	"github.com/djylb/nps/lib/edgecache"
+	"github.com/djylb/nps/lib/file"
+)
+
+const (
+	edgeCacheStatusHeader = "X-Cache"
+	edgeCacheHit          = "HIT"
+	edgeCacheMiss         = "MISS"
+	edgeCacheRevalidated  = "REVALIDATED"
+)
+
+// edgeCacheStore returns the shared edge cache with the limits of the
+// current server config.
+func (s *HttpServer) edgeCacheStore() *edgecache.Store {
+	cfg := s.currentConfig()
+	store := edgecache.Default()
+	opts := edgecache.Options{
+		MemoryBytes:    cfg.ProxyCacheMemoryBytes(),
+		DiskBytes:      cfg.ProxyCacheDiskBytes(),
+		MaxObjectBytes: cfg.ProxyCacheMaxObjectBytes(),
+	}
+	if opts.DiskBytes > 0 && cfg.Proxy.CachePath != "" {
+		opts.DiskPath = common.ResolvePath(cfg.Proxy.CachePath)
+	}
+	store.Configure(opts)
+	return store
+}
+
+// edgeCacheRequest is the cache state of one proxied request. The key and
+// request headers are taken before path rewriting and header changes, so
+// entries are shared by requests the client sees as equal.
+type edgeCacheRequest struct {
+	store     *edgecache.Store
+	hostID    int
+	hostTTL   time.Duration
+	primary   string
+	path      string
+	reqHeader http.Header
+	method    string
+	lookup    bool
+	stale     *edgecache.Entry
+	// conditional holds the validators of the client while a stale entry is
+	// revalidated with the validators of the entry.
+	conditional http.Header
+}
+
+// newEdgeCacheRequest returns the cache state of r, or nil when the host
+// does not cache or the request must go to the backend.
+func (s *HttpServer) newEdgeCacheRequest(r *http.Request, host *file.Host) *edgeCacheRequest {
+	if host == nil || !host.Cache || host.AuthGate || host.ForwardAuth != "" || host.ClientCertMode != "" || r == nil || r.URL == nil {
+		return nil
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return nil
+	}
+	if r.Header.Get("Range") != "" || r.Header.Get("Authorization") != "" || r.Header.Get("Upgrade") != "" {
+		return nil
+	}
+	directives := parseCacheControl(r.Header.Values("Cache-Control"))
+	if _, ok := directives["no-store"]; ok {
+		return nil
+	}
+	store := s.edgeCacheStore()
+	if !store.Options().Enabled() {
+		return nil
+	}
+	_, noCache := directives["no-cache"]
+	if strings.EqualFold(strings.TrimSpace(r.Header.Get("Pragma")), "no-cache") {
+		noCache = true
+	}
+	hostTTL := time.Duration(host.CacheTTL) * time.Second
+	if r.Header.Get("Cookie") != "" {
+		// The answer may depend on the session; only the backend can say
+		// it is shared.
+		hostTTL = 0
+	}
+	return &edgeCacheRequest{
+		store:     store,
+		hostID:    host.Id,
+		hostTTL:   hostTTL,
+		primary:   strings.ToLower(r.Host) + r.URL.RequestURI(),
+		path:      r.URL.Path,
+		reqHeader: r.Header.Clone(),
+		method:    r.Method,
+		lookup:    !noCache,
+	}
+}
+
+// serve answers r from the cache when a fresh entry exists. For a stale
+// entry with validators it turns r into a conditional request, so the
+// backend can confirm the entry without sending the body again.
+func (c *edgeCacheRequest) serve(w http.ResponseWriter, r *http.Request, decorate func(*http.Response)) bool {
+	if c == nil || !c.lookup {
+		return false
+	}
+	entry := c.store.Lookup(c.primary, c.reqHeader)
+	if entry == nil {
+		return false
+	}
+	now := time.Now()
+	if entry.Fresh(now) {
+		c.store.RecordHit(c.hostID)
+		writeEdgeCacheEntry(w, r, entry, now, edgeCacheHit, decorate)
+		return true
+	}
+	etag, lastModified := entry.Header.Get("ETag"), entry.Header.Get("Last-Modified")
+	if etag == "" && lastModified == "" {
+		return false
+	}
+	c.stale = entry
+	c.conditional = http.Header{}
+	for _, name := range []string{"If-None-Match", "If-Modified-Since"} {
+		if value := r.Header.Get(name); value != "" {
+			c.conditional.Set(name, value)
+		}
+		r.Header.Del(name)
+	}
+	if etag != "" {
+		r.Header.Set("If-None-Match", etag)
+	}
+	if lastModified != "" {
+		r.Header.Set("If-Modified-Since", lastModified)
+	}
+	return false
+}
+
+// modifyResponse stores cacheable backend responses and turns the answer
+// to a revalidation back into a full response. It runs before the host
+// response header changes, so entries hold what the backend sent.
+func (c *edgeCacheRequest) modifyResponse(resp *http.Response) {
+	if c == nil || resp == nil {
+		return
+	}
+	now := time.Now()
+	if c.stale != nil && resp.StatusCode == http.StatusNotModified {
+		c.store.RecordHit(c.hostID)
+		entry := c.refresh(resp.Header, now)
+		resp.StatusCode = entry.Status
+		resp.Status = ""
+		resp.Header = entry.Header.Clone()
+		setEdgeCacheAge(resp.Header, entry, now)
+		resp.Header.Set(edgeCacheStatusHeader, edgeCacheRevalidated)
+		if edgeCacheNotModified(c.conditional, resp.Header) {
+			resp.StatusCode = http.StatusNotModified
+			resp.Header.Del("Content-Length")
+			replaceResponseBody(resp, nil)
+			return
+		}
+		replaceResponseBody(resp, entry.Body)
+		return
+	}
+	c.store.RecordMiss(c.hostID)
+	resp.Header.Set(edgeCacheStatusHeader, edgeCacheMiss)
+	if c.method != http.MethodGet {
+		return
+	}
+	ttl, ok := edgeCacheFreshness(resp, now, c.hostTTL)
+	if !ok {
+		return
+	}
+	limit := c.store.MaxObjectBytes()
+	if resp.ContentLength > limit {
+		return
+	}
+	header := resp.Header.Clone()
+	header.Del(edgeCacheStatusHeader)
+	age := upstreamAge(header)
+	status := resp.StatusCode
+	vary := header.Values("Vary")
+	resp.Body = &edgeCacheBody{
+		ReadCloser: resp.Body,
+		limit:      limit,
+		expected:   resp.ContentLength,
+		done: func(body []byte) {
+			stored := time.Now()
+			c.store.Store(c.primary, vary, c.reqHeader, &edgecache.Entry{
+				HostID:   c.hostID,
+				Path:     c.path,
+				Status:   status,
+				Header:   header,
+				Body:     body,
+				StoredAt: stored.Add(-age),
+				Expires:  stored.Add(ttl),
+			})
+		},
+	}
+}
+
+// refresh stores the stale entry again with the headers of a 304 response.
+func (c *edgeCacheRequest) refresh(header http.Header, now time.Time) *edgecache.Entry {
+	merged := c.stale.Header.Clone()
+	for name, values := range header {
+		switch http.CanonicalHeaderKey(name) {
+		case "Content-Length", "Content-Encoding", "Transfer-Encoding", edgeCacheStatusHeader:
+			continue
+		}
+		merged[name] = append([]string(nil), values...)
+	}
+	ttl, ok := edgeCacheFreshness(&http.Response{StatusCode: c.stale.Status, Header: merged}, now, c.hostTTL)
+	if !ok {
+		ttl = 0
+	}
+	entry := &edgecache.Entry{
+		HostID:   c.hostID,
+		Path:     c.path,
+		Status:   c.stale.Status,
+		Header:   merged,
+		Body:     c.stale.Body,
+		StoredAt: now.Add(-upstreamAge(header)),
+		Expires:  now.Add(ttl),
+	}
+	c.store.Store(c.primary, merged.Values("Vary"), c.reqHeader, entry)
+	return entry
+}
+
+// writeEdgeCacheEntry answers a request from a stored entry, applying the
+// same response decoration as a proxied response.
+func writeEdgeCacheEntry(w http.ResponseWriter, r *http.Request, entry *edgecache.Entry, now time.Time, status string, decorate func(*http.Response)) {
+	resp := &http.Response{
+		StatusCode: entry.Status,
+		Header:     entry.Header.Clone(),
+		Request:    r,
+	}
+	setEdgeCacheAge(resp.Header, entry, now)
+	resp.Header.Set(edgeCacheStatusHeader, status)
+	if decorate != nil {
+		decorate(resp)
+	}
+	header := w.Header()
+	for name, values := range resp.Header {
+		header[name] = values
+	}
+	if edgeCacheNotModified(r.Header, header) {
+		header.Del("Content-Length")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	header.Set("Content-Length", strconv.Itoa(len(entry.Body)))
+	w.WriteHeader(resp.StatusCode)
+	if r.Method != http.MethodHead {
+		_, _ = w.Write(entry.Body)
+	}
+}
+
+func setEdgeCacheAge(header http.Header, entry *edgecache.Entry, now time.Time) {
+	header.Set("Age", strconv.FormatInt(int64(entry.Age(now)/time.Second), 10))
+}
+
+func replaceResponseBody(resp *http.Response, body []byte) {
+	if resp.Body != nil {
+		_ = resp.Body.Close()
+	}
+	if body == nil {
+		resp.Body = http.NoBody
+		resp.ContentLength = 0
+		return
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+}
+
+// edgeCacheNotModified evaluates the client validators against a response
+// header (RFC 9110 13.1). If-None-Match takes precedence.
+func edgeCacheNotModified(conditional, header http.Header) bool {
+	if len(conditional) == 0 {
+		return false
+	}
+	if match := conditional.Get("If-None-Match"); match != "" {
+		etag := header.Get("ETag")
+		if etag == "" {
+			return false
+		}
+		for _, candidate := range strings.Split(match, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+	since, err := http.ParseTime(conditional.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	modified, err := http.ParseTime(header.Get("Last-Modified"))
+	return err == nil && !modified.After(since)
+}
+
+// edgeCacheFreshness reports whether resp may be stored and for how long it
+// is fresh. Backend directives win; the host TTL only applies to 200
+// responses without any. Responses with validators but no lifetime are
+// stored for revalidation.
+func edgeCacheFreshness(resp *http.Response, now time.Time, hostTTL time.Duration) (time.Duration, bool) {
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNonAuthoritativeInfo, http.StatusMovedPermanently,
+		http.StatusPermanentRedirect, http.StatusNotFound, http.StatusGone:
+	default:
+		return 0, false
+	}
+	header := resp.Header
+	if header.Get("Set-Cookie") != "" || header.Get("Content-Range") != "" {
+		return 0, false
+	}
+	for _, value := range header.Values("Vary") {
+		if strings.Contains(value, "*") {
+			return 0, false
+		}
+	}
+	directives := parseCacheControl(header.Values("Cache-Control"))
+	if _, ok := directives["no-store"]; ok {
+		return 0, false
+	}
+	if _, ok := directives["private"]; ok {
+		return 0, false
+	}
+	validators := header.Get("ETag") != "" || header.Get("Last-Modified") != ""
+	if _, ok := directives["no-cache"]; ok {
+		return 0, validators
+	}
+	ttl, explicit := explicitFreshness(header, directives, now)
+	if !explicit {
+		if hostTTL <= 0 || resp.StatusCode != http.StatusOK {
+			return 0, validators
+		}
+		ttl = hostTTL
+	}
+	ttl -= upstreamAge(header)
+	if ttl <= 0 {
+		return 0, validators
+	}
+	return ttl, true
+}
+
+func explicitFreshness(header http.Header, directives map[string]string, now time.Time) (time.Duration, bool) {
+	for _, name := range []string{"s-maxage", "max-age"} {
+		if value, ok := directives[name]; ok {
+			seconds, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || seconds < 0 {
+				return 0, true
+			}
+			return time.Duration(seconds) * time.Second, true
+		}
+	}
+	if value := header.Get("Expires"); value != "" {
+		expires, err := http.ParseTime(value)
+		if err != nil {
+			return 0, true
+		}
+		date, err := http.ParseTime(header.Get("Date"))
+		if err != nil {
+			date = now
+		}
+		return expires.Sub(date), true
+	}
+	return 0, false
+}
+
+func upstreamAge(header http.Header) time.Duration {
+	seconds, err := strconv.ParseInt(strings.TrimSpace(header.Get("Age")), 10, 64)
+	if err != nil || seconds <= 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// parseCacheControl returns the directives of Cache-Control header values
+// with lower-case names and unquoted arguments.
+func parseCacheControl(values []string) map[string]string {
+	directives := make(map[string]string)
+	for _, value := range values {
+		for _, part := range strings.Split(value, ",") {
+			name, arg, _ := strings.Cut(strings.TrimSpace(part), "=")
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name != "" {
+				directives[name] = strings.Trim(strings.TrimSpace(arg), `"`)
+			}
+		}
+	}
+	return directives
+}
+
+// edgeCacheBody passes a backend body through and hands a copy to done
+// once it was read completely within limit.
+type edgeCacheBody struct {
+	io.ReadCloser
+	buf      bytes.Buffer
+	limit    int64
+	expected int64
+	overflow bool
+	done     func([]byte)
+}
+
+func (b *edgeCacheBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 && !b.overflow {
+		if int64(b.buf.Len()+n) > b.limit {
+			b.overflow = true
+			b.buf = bytes.Buffer{}
+		} else {
+			b.buf.Write(p[:n])
+		}
+	}
+	if err == io.EOF && !b.overflow && b.done != nil {
+		if b.expected < 0 || int64(b.buf.Len()) == b.expected {
+			b.done(b.buf.Bytes())
+		}
+		b.done = nil
+	}
+	return n, err
+}
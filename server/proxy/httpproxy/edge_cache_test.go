@@ -0,0 +1,196 @@
+package httpproxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/djylb/nps/lib/edgecache"
+	"github.com/djylb/nps/lib/file"
+)
+
+func newEdgeCacheTestServer(t *testing.T, hostID int) *HttpServer {
+	t.Helper()
+	loadHTTPProxyTestConfig(t, map[string]any{
+		"http_proxy_cache_memory_mb":     4,
+		"http_proxy_cache_max_object_mb": 1,
+	})
+	t.Cleanup(func() {
+		edgecache.Default().ForgetHost(hostID)
+		edgecache.Default().Configure(edgecache.Options{})
+	})
+	return &HttpServer{HttpProxy: &HttpProxy{}}
+}
+
+func backendTestResponse(req *http.Request, status int, header http.Header, body string) *http.Response {
+	return &http.Response{
+		StatusCode:    status,
+		Header:        header,
+		Body:          io.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+func TestEdgeCacheStoresAndServesFreshResponse(t *testing.T) {
+	server := newEdgeCacheTestServer(t, 51)
+	host := &file.Host{Id: 51, Cache: true}
+	newRequest := func() *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "http://Static.Example.com/app.js?v=1", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		return req
+	}
+
+	req := newRequest()
+	cache := server.newEdgeCacheRequest(req, host)
+	if cache.serve(httptest.NewRecorder(), req, nil) {
+		t.Fatal("empty cache served a response")
+	}
+	resp := backendTestResponse(req, http.StatusOK, http.Header{
+		"Cache-Control": {"public, max-age=60"},
+		"Etag":          {`"v1"`},
+		"Vary":          {"Accept-Encoding"},
+		"Content-Type":  {"application/javascript"},
+	}, "console.log(1)")
+	cache.modifyResponse(resp)
+	if got := resp.Header.Get("X-Cache"); got != "MISS" {
+		t.Fatalf("X-Cache on miss = %q", got)
+	}
+	if body, _ := io.ReadAll(resp.Body); string(body) != "console.log(1)" {
+		t.Fatalf("proxied body = %q", body)
+	}
+
+	req = newRequest()
+	cache = server.newEdgeCacheRequest(req, host)
+	recorder := httptest.NewRecorder()
+	decorated := false
+	if !cache.serve(recorder, req, func(resp *http.Response) { decorated = resp.Request == req }) {
+		t.Fatal("fresh entry was not served")
+	}
+	if recorder.Code != http.StatusOK || recorder.Body.String() != "console.log(1)" || recorder.Header().Get("X-Cache") != "HIT" {
+		t.Fatalf("hit = %d %q X-Cache=%q", recorder.Code, recorder.Body.String(), recorder.Header().Get("X-Cache"))
+	}
+	if !decorated {
+		t.Fatal("hit skipped the host response decoration")
+	}
+
+	// Another Accept-Encoding is another variant.
+	req = newRequest()
+	req.Header.Del("Accept-Encoding")
+	if server.newEdgeCacheRequest(req, host).serve(httptest.NewRecorder(), req, nil) {
+		t.Fatal("entry was served to a request with a different Vary header")
+	}
+
+	// The client validators are answered from the cache.
+	req = newRequest()
+	req.Header.Set("If-None-Match", `"v1"`)
+	recorder = httptest.NewRecorder()
+	if !server.newEdgeCacheRequest(req, host).serve(recorder, req, nil) || recorder.Code != http.StatusNotModified {
+		t.Fatalf("conditional hit status = %d, want 304", recorder.Code)
+	}
+	if stats := edgecache.Default().Stats(51); stats.Hits != 2 || stats.Misses != 1 {
+		t.Fatalf("stats = %+v, want 2 hits and 1 miss", stats)
+	}
+}
+
+func TestEdgeCacheRevalidatesStaleEntry(t *testing.T) {
+	server := newEdgeCacheTestServer(t, 52)
+	host := &file.Host{Id: 52, Cache: true}
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/logo.svg", nil)
+	cache := server.newEdgeCacheRequest(req, host)
+	resp := backendTestResponse(req, http.StatusOK, http.Header{
+		"Cache-Control": {"no-cache"},
+		"Etag":          {`"logo-1"`},
+	}, "<svg/>")
+	cache.modifyResponse(resp)
+	_, _ = io.ReadAll(resp.Body)
+
+	req = httptest.NewRequest(http.MethodGet, "http://example.com/logo.svg", nil)
+	cache = server.newEdgeCacheRequest(req, host)
+	if cache.serve(httptest.NewRecorder(), req, nil) {
+		t.Fatal("no-cache entry was served without revalidation")
+	}
+	if got := req.Header.Get("If-None-Match"); got != `"logo-1"` {
+		t.Fatalf("revalidation If-None-Match = %q", got)
+	}
+	notModified := backendTestResponse(req, http.StatusNotModified, http.Header{"Etag": {`"logo-1"`}}, "")
+	cache.modifyResponse(notModified)
+	body, _ := io.ReadAll(notModified.Body)
+	if notModified.StatusCode != http.StatusOK || string(body) != "<svg/>" || notModified.Header.Get("X-Cache") != "REVALIDATED" {
+		t.Fatalf("revalidated response = %d %q X-Cache=%q", notModified.StatusCode, body, notModified.Header.Get("X-Cache"))
+	}
+}
+
+func TestEdgeCacheBypassesPrivateAndUnsafeRequests(t *testing.T) {
+	server := newEdgeCacheTestServer(t, 53)
+	host := &file.Host{Id: 53, Cache: true}
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodPost, "http://example.com/", nil),
+		func() *http.Request {
+			req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+			req.Header.Set("Authorization", "Bearer x")
+			return req
+		}(),
+		func() *http.Request {
+			req := httptest.NewRequest(http.MethodGet, "http://example.com/video", nil)
+			req.Header.Set("Range", "bytes=0-99")
+			return req
+		}(),
+	} {
+		if server.newEdgeCacheRequest(req, host) != nil {
+			t.Fatalf("%s %v was cacheable", req.Method, req.Header)
+		}
+	}
+	if server.newEdgeCacheRequest(httptest.NewRequest(http.MethodGet, "http://example.com/", nil), &file.Host{Id: 53}) != nil {
+		t.Fatal("request to a host without cache was cacheable")
+	}
+
+	// A request with a cookie only falls back to the host TTL when the
+	// backend marks the response as shared.
+	ttlHost := &file.Host{Id: 53, Cache: true, CacheTTL: 60}
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/account", nil)
+	req.Header.Set("Cookie", "session=a")
+	cache := server.newEdgeCacheRequest(req, ttlHost)
+	resp := backendTestResponse(req, http.StatusOK, http.Header{}, "user a")
+	cache.modifyResponse(resp)
+	_, _ = io.ReadAll(resp.Body)
+	req = httptest.NewRequest(http.MethodGet, "http://example.com/account", nil)
+	req.Header.Set("Cookie", "session=b")
+	if server.newEdgeCacheRequest(req, ttlHost).serve(httptest.NewRecorder(), req, nil) {
+		t.Fatal("response to a request with a cookie was cached by the host ttl")
+	}
+}
+
+func TestEdgeCacheFreshness(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name    string
+		status  int
+		header  http.Header
+		hostTTL time.Duration
+		ttl     time.Duration
+		ok      bool
+	}{
+		{name: "s-maxage wins", status: 200, header: http.Header{"Cache-Control": {"max-age=10, s-maxage=30"}}, ttl: 30 * time.Second, ok: true},
+		{name: "age is subtracted", status: 200, header: http.Header{"Cache-Control": {"max-age=60"}, "Age": {"20"}}, ttl: 40 * time.Second, ok: true},
+		{name: "expires", status: 200, header: http.Header{"Date": {now.Format(http.TimeFormat)}, "Expires": {now.Add(time.Hour).Format(http.TimeFormat)}}, ttl: time.Hour, ok: true},
+		{name: "host ttl fallback", status: 200, header: http.Header{}, hostTTL: time.Minute, ttl: time.Minute, ok: true},
+		{name: "host ttl only for 200", status: 404, header: http.Header{}, hostTTL: time.Minute},
+		{name: "private", status: 200, header: http.Header{"Cache-Control": {"private, max-age=60"}}},
+		{name: "no-store", status: 200, header: http.Header{"Cache-Control": {"no-store"}}},
+		{name: "set-cookie", status: 200, header: http.Header{"Cache-Control": {"max-age=60"}, "Set-Cookie": {"a=b"}}},
+		{name: "vary star", status: 200, header: http.Header{"Cache-Control": {"max-age=60"}, "Vary": {"*"}}},
+		{name: "uncacheable status", status: 500, header: http.Header{"Cache-Control": {"max-age=60"}}},
+		{name: "validator only", status: 200, header: http.Header{"Last-Modified": {now.Format(http.TimeFormat)}}, ok: true},
+	}
+	for _, tt := range tests {
+		ttl, ok := edgeCacheFreshness(&http.Response{StatusCode: tt.status, Header: tt.header}, now, tt.hostTTL)
+		if ttl != tt.ttl || ok != tt.ok {
+			t.Fatalf("%s: edgeCacheFreshness() = %v, %v; want %v, %v", tt.name, ttl, ok, tt.ttl, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package httpproxy
+
+import (
+	"net/http"
+
+	"github.com/djylb/nps/lib/file"
+	"github.com/djylb/nps/lib/forwardauth"
+	"github.com/djylb/nps/lib/logs"
+)
+
+// forwardAuthDenyHeaders are copied from a refusal of the auth service to
+// the client, so it can send the user to its login page.
+var forwardAuthDenyHeaders = []string{"Location", "WWW-Authenticate", "Set-Cookie", "Content-Type", "Cache-Control"}
+
+// applyHTTPProxyForwardAuth asks the forward auth endpoint of the host about
+// the request. Allowed requests get the configured response headers of the
+// service; refusals are relayed to the client as they are.
+func (s *HttpServer) applyHTTPProxyForwardAuth(w http.ResponseWriter, r *http.Request, host *file.Host, isHTTPOnlyRequest bool) bool {
+	if host.ForwardAuth == "" {
+		return true
+	}
+	client := forwardauth.Default()
+	client.SetAllow(s.currentConfig().Proxy.ForwardAuthAllow)
+	resp, err := client.Check(r.Context(), host.ForwardAuth, forwardauth.Request{
+		Method:   r.Method,
+		Scheme:   s.httpProxyRequestScheme(r, isHTTPOnlyRequest),
+		Host:     r.Host,
+		URI:      r.URL.RequestURI(),
+		ClientIP: s.httpProxyClientIP(r, isHTTPOnlyRequest),
+		Header:   r.Header,
+	})
+	if err != nil {
+		logs.Warn("Forward auth of host id %d failed: %v", host.Id, err)
+		http.Error(w, "502 Bad Gateway", http.StatusBadGateway)
+		return false
+	}
+	if resp.Allowed() {
+		forwardauth.CopyHeaders(r.Header, resp.Header, forwardauth.HeaderList(host.ForwardAuthHeaders))
+		return true
+	}
+	for _, name := range forwardAuthDenyHeaders {
+		for _, value := range resp.Header.Values(name) {
+			w.Header().Add(name, value)
+		}
+	}
+	status := resp.StatusCode
+	if status < http.StatusMultipleChoices {
+		status = http.StatusForbidden
+	}
+	logs.Debug("Forward auth of host id %d refused %s %s with %d", host.Id, r.Method, r.URL.Path, resp.StatusCode)
+	w.WriteHeader(status)
+	_, _ = w.Write(resp.Body)
+	return false
+}
@@ -0,0 +1,74 @@
+package httpproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/djylb/nps/lib/file"
+)
+
+func TestApplyHTTPProxyForwardAuthAllowsAndRelaysRefusals(t *testing.T) {
+	loadHTTPProxyTestConfig(t, map[string]any{"forward_auth_allow": "127.0.0.1"})
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Forwarded-Uri") != "/admin?x=1" || r.Header.Get("X-Forwarded-Host") != "app.example.com" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if cookie, err := r.Cookie("authelia_session"); err != nil || cookie.Value != "ok" {
+			w.Header().Set("Location", "https://auth.example.com/?rd=https://app.example.com/admin")
+			w.WriteHeader(http.StatusFound)
+			return
+		}
+		w.Header().Set("Remote-User", "alice")
+		w.Header().Set("Remote-Groups", "admins")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer service.Close()
+	server := &HttpServer{HttpProxy: &HttpProxy{}}
+	host := &file.Host{Id: 81, ForwardAuth: service.URL + "/api/verify", ForwardAuthHeaders: "remote-user"}
+	file.InitializeHostRuntime(host)
+
+	req := httptest.NewRequest(http.MethodGet, "http://app.example.com/admin?x=1", nil)
+	recorder := httptest.NewRecorder()
+	if server.applyHTTPProxyForwardAuth(recorder, req, host, false) {
+		t.Fatal("request without a session was allowed")
+	}
+	if recorder.Code != http.StatusFound || recorder.Header().Get("Location") == "" {
+		t.Fatalf("refusal = %d Location=%q", recorder.Code, recorder.Header().Get("Location"))
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "http://app.example.com/admin?x=1", nil)
+	req.AddCookie(&http.Cookie{Name: "authelia_session", Value: "ok"})
+	req.Header.Set("Remote-User", "forged")
+	if !server.applyHTTPProxyForwardAuth(httptest.NewRecorder(), req, host, false) {
+		t.Fatal("request with a session was refused")
+	}
+	if req.Header.Get("Remote-User") != "alice" || req.Header.Get("Remote-Groups") != "" {
+		t.Fatalf("upstream headers = %v, want only Remote-User copied", req.Header)
+	}
+
+	host.ForwardAuth = "http://127.0.0.1:1/verify"
+	recorder = httptest.NewRecorder()
+	if server.applyHTTPProxyForwardAuth(recorder, httptest.NewRequest(http.MethodGet, "http://app.example.com/", nil), host, false) ||
+		recorder.Code != http.StatusBadGateway {
+		t.Fatalf("unreachable auth service = %d, want 502", recorder.Code)
+	}
+}
+
+func TestApplyHTTPProxyForwardAuthRefusesInternalEndpoints(t *testing.T) {
+	loadHTTPProxyTestConfig(t, map[string]any{})
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer service.Close()
+	server := &HttpServer{HttpProxy: &HttpProxy{}}
+	host := &file.Host{Id: 82, ForwardAuth: service.URL + "/api/verify"}
+	file.InitializeHostRuntime(host)
+
+	recorder := httptest.NewRecorder()
+	if server.applyHTTPProxyForwardAuth(recorder, httptest.NewRequest(http.MethodGet, "http://app.example.com/", nil), host, false) ||
+		recorder.Code != http.StatusBadGateway {
+		t.Fatalf("loopback auth service without forward_auth_allow = %d, want 502", recorder.Code)
+	}
+}
@@ -226,6 +226,22 @@ func (s *HttpServer) handleProxy(w http.ResponseWriter, r *http.Request) {
 	if s.redirectHTTPProxyToHTTPS(w, r, host, isHTTPOnlyRequest) {
 		return
 	}
+	if !s.applyHTTPProxyClientCert(w, r, host) {
+		return
+	}
+	if !s.applyHTTPProxyWAF(w, r, host, isHTTPOnlyRequest) {
+		return
+	}
+	if !s.allowHTTPProxyRequestRate(w, r, host, isHTTPOnlyRequest) {
+		return
+	}
+	if !s.applyHTTPProxyAuthGate(w, r, host, isHTTPOnlyRequest) {
+		return
+	}
+	if !s.applyHTTPProxyForwardAuth(w, r, host, isHTTPOnlyRequest) {
+		return
+	}
+	cache := s.newEdgeCacheRequest(r, host)
 	s.applyHTTPProxyPathRewrite(r, host)
 
 	lease, err := s.CheckFlowAndConnNum(host.Client, nil, host)
@@ -251,6 +267,7 @@ func (s *HttpServer) handleProxy(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	r = resolved.request
+	defer resolved.backend.host.Target.AcquireTarget(resolved.backend.selection.targetAddr)()
 
 	// WebSocket
 	if r.Method == http.MethodConnect || r.Header.Get("Upgrade") != "" || r.Header.Get(":protocol") != "" {
@@ -260,8 +277,35 @@ func (s *HttpServer) handleProxy(w http.ResponseWriter, r *http.Request) {
 	}
 	serviceLimiter := s.ServiceRateLimiter(resolved.backend.host.Client, nil, resolved.backend.host)
 	r, w = applyHTTPProxyServiceAccounting(r, w, resolved.backend.host, resolved.backend.routeRuntime, serviceLimiter)
-
-	tr := s.transportForBackend(resolved.backend.host, resolved.backend.selection)
+	w, finishCompress := newCompressResponseWriter(w, r, resolved.backend.host)
+	defer finishCompress()
+	bodyRewriteVars := s.bodyRewriteVars(r, isHTTPOnlyRequest)
+	w, finishBodyRewrite := newBodyRewriteResponseWriter(w, r, resolved.backend.host, bodyRewriteVars)
+	defer finishBodyRewrite()
+
+	decorate := func(resp *http.Response) {
+		// CORS
+		if resolved.backend.host.AutoCORS {
+			origin := resp.Request.Header.Get("Origin")
+			if origin != "" && resp.Header.Get("Access-Control-Allow-Origin") == "" {
+				logs.Debug("ModifyResponse: setting CORS headers for origin=%s", origin)
+				resp.Header.Set("Access-Control-Allow-Origin", origin)
+				resp.Header.Set("Access-Control-Allow-Credentials", "true")
+			}
+		}
+		// H3
+		if s.Http3Port > 0 && r.TLS != nil && !resolved.backend.host.HttpsJustProxy && !resolved.backend.host.TlsOffload && !resolved.backend.host.CompatMode {
+			resp.Header.Set("Alt-Svc", `h3=":`+s.Http3PortStr+`"; ma=86400`)
+		}
+		s.setHSTSHeader(resp.Header, r, resolved.backend.host)
+		s.ChangeResponseHeader(resp, resolved.backend.host.RespHeaderChange)
+	}
+	if cache.serve(w, r, decorate) {
+		return
+	}
+	if !s.applyHTTPProxyRequestBodyRewrite(w, r, resolved.backend.host, bodyRewriteVars) {
+		return
+	}
 
 	rp := &httputil.ReverseProxy{
 		Director: func(req *http.Request) {
@@ -272,30 +316,23 @@ func (s *HttpServer) handleProxy(w http.ResponseWriter, r *http.Request) {
 				req.URL.Scheme = "http"
 			}
 			//logs.Debug("Director: set req.URL.Scheme=%s, req.URL.Host=%s", req.URL.Scheme, req.URL.Host)
+			if resolved.backend.host.RespBodyRewrite != "" {
+				// Let the transport ask for gzip and decode it, so the
+				// rules see plain text.
+				req.Header.Del("Accept-Encoding")
+			}
 			s.ChangeHostAndHeader(req, resolved.backend.host.HostChange, resolved.backend.host.HeaderChange, isHTTPOnlyRequest)
 			req.URL.Host = r.Host
 			if isHTTPOnlyRequest {
 				req.Header["X-Forwarded-For"] = nil
 			}
 		},
-		Transport: tr,
+		Transport: s.newBackendRoundTripper(resolved.backend),
 		//FlushInterval: 100 * time.Millisecond,
 		BufferPool: common.BufPoolCopy,
 		ModifyResponse: func(resp *http.Response) error {
-			// CORS
-			if resolved.backend.host.AutoCORS {
-				origin := resp.Request.Header.Get("Origin")
-				if origin != "" && resp.Header.Get("Access-Control-Allow-Origin") == "" {
-					logs.Debug("ModifyResponse: setting CORS headers for origin=%s", origin)
-					resp.Header.Set("Access-Control-Allow-Origin", origin)
-					resp.Header.Set("Access-Control-Allow-Credentials", "true")
-				}
-			}
-			// H3
-			if s.Http3Port > 0 && r.TLS != nil && !resolved.backend.host.HttpsJustProxy && !resolved.backend.host.TlsOffload && !resolved.backend.host.CompatMode {
-				resp.Header.Set("Alt-Svc", `h3=":`+s.Http3PortStr+`"; ma=86400`)
-			}
-			s.ChangeResponseHeader(resp, resolved.backend.host.RespHeaderChange)
+			cache.modifyResponse(resp)
+			decorate(resp)
 			return nil
 		},
 		ErrorHandler: func(rw http.ResponseWriter, req *http.Request, err error) {
@@ -447,6 +484,7 @@ func (s *HttpServer) writeProxyError(rw http.ResponseWriter, req *http.Request,
 func (s *HttpServer) handleResolvedWebsocket(w http.ResponseWriter, resolved resolvedHTTPProxyServeRequest, isHttpOnlyRequest bool) {
 	r := resolved.request
 	netConn, err := s.dialResolvedBackendContext(r.Context(), resolved.backend)
+	s.observeBackendResult(resolved.backend.host, resolved.backend.selection, backendAttemptFailed(r, err, nil))
 	if err != nil {
 		if !errors.Is(err, errHTTPProxyDestinationDenied) {
 			logs.Info("handleWebsocket: connection to target %s failed: %v", resolved.backend.selection.targetAddr, err)
@@ -119,12 +119,13 @@ func validateHTTPBackendHost(host *file.Host) error {
 	}
 }
 
-func (s *HttpServer) resolveBackendSelection(host *file.Host) (backendSelection, error) {
+func (s *HttpServer) resolveBackendSelection(host *file.Host, hint file.TargetHint) (backendSelection, error) {
 	if err := validateHTTPBackendHost(host); err != nil {
 		return backendSelection{}, err
 	}
 	routeUUID := s.SelectClientRouteUUID(host.Client, host.RuntimeRouteUUID())
-	targetAddr, err := host.Target.GetRouteTarget(routeUUID)
+	hint.RouteKey = routeUUID
+	targetAddr, err := host.Target.PickTarget(hint)
 	if err != nil {
 		return backendSelection{}, err
 	}
@@ -200,7 +201,9 @@ func (s *HttpServer) resolveHTTPProxyBackendState(ctx context.Context, request *
 	if err := validateHTTPBackendHost(host); err != nil {
 		return ctx, resolvedHTTPProxyBackend{}, err
 	}
-	selection, err := resolveHTTPBackendSelection(ctx, fallbackSelection, host, s.resolveBackendSelection)
+	selection, err := resolveHTTPBackendSelection(ctx, fallbackSelection, host, func(host *file.Host) (backendSelection, error) {
+		return s.resolveBackendSelection(host, file.TargetHint{RemoteAddr: resolveHTTPProxyRemoteAddr(ctx, request), Request: request})
+	})
 	if err != nil {
 		return ctx, resolvedHTTPProxyBackend{}, err
 	}
@@ -0,0 +1,139 @@
+package httpproxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/djylb/nps/lib/file"
+	"github.com/djylb/nps/lib/logs"
+)
+
+const backendRetryDrainLimit = 64 << 10
+
+// backendRoundTripper sends a request to the selected target and reports
+// the outcome for passive health checking. When the target fails and the
+// request is safe to send again, it is retried on another target.
+type backendRoundTripper struct {
+	server   *HttpServer
+	resolved resolvedHTTPProxyBackend
+}
+
+func (s *HttpServer) newBackendRoundTripper(resolved resolvedHTTPProxyBackend) http.RoundTripper {
+	return &backendRoundTripper{server: s, resolved: resolved}
+}
+
+func (t *backendRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	host, selection := t.resolved.host, t.resolved.selection
+	retry := t.server.currentConfig().Proxy.RetryIdempotent && retryableBackendRequest(req)
+	tried := []string{selection.targetAddr}
+	var release func()
+	for {
+		resp, err := t.server.transportForBackend(host, selection).RoundTrip(req)
+		if err != nil {
+			t.server.removeBackendTransport(host.Id, selection)
+		}
+		failed := backendAttemptFailed(req, err, resp)
+		t.server.observeBackendResult(host, selection, failed)
+		if !failed || !retry {
+			return releaseWithResponse(resp, release), err
+		}
+		next, ok := host.Target.PickRetryTarget(file.TargetHint{
+			RouteKey:   selection.routeUUID,
+			RemoteAddr: t.resolved.remoteAddr,
+			Request:    req,
+		}, tried)
+		if !ok {
+			return releaseWithResponse(resp, release), err
+		}
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, backendRetryDrainLimit))
+			_ = resp.Body.Close()
+		}
+		if release != nil {
+			release()
+		}
+		logs.Info("Retry %s request of host %d on target %s after target %s failed", req.Method, host.Id, next, selection.targetAddr)
+		tried = append(tried, next)
+		selection.targetAddr = next
+		release = host.Target.AcquireTarget(next)
+		req = req.WithContext(withHTTPProxyBackendSelection(req.Context(), selection))
+	}
+}
+
+// observeBackendResult feeds the passive health check of the host targets.
+func (s *HttpServer) observeBackendResult(host *file.Host, selection backendSelection, failed bool) {
+	if host == nil || host.Target == nil {
+		return
+	}
+	if !failed {
+		host.Target.ReportTargetSuccess(selection.targetAddr)
+		return
+	}
+	cfg := s.currentConfig()
+	threshold := cfg.ProxyPassiveFailureThreshold()
+	if threshold <= 0 || host.Target.TargetCount() < 2 {
+		return
+	}
+	cooldown := cfg.ProxyPassiveCooldown()
+	if host.Target.ReportTargetFailure(selection.targetAddr, threshold, cooldown) {
+		logs.Warn("Eject target %s of host %d for %v after %d consecutive failures", selection.targetAddr, host.Id, cooldown, threshold)
+		s.removeBackendTransport(host.Id, selection)
+	}
+}
+
+// backendAttemptFailed reports whether the target is to blame for the
+// outcome of a request: it could not be reached, timed out or answered with
+// a 5xx status. A client that went away does not count against the target.
+func backendAttemptFailed(req *http.Request, err error, resp *http.Response) bool {
+	if err != nil {
+		if req != nil && req.Context().Err() != nil {
+			return false
+		}
+		if errors.Is(err, context.Canceled) || errors.Is(err, errHTTPProxyDestinationDenied) || errors.Is(err, errHTTPProxyInvalidBackend) {
+			return false
+		}
+		return true
+	}
+	return resp != nil && resp.StatusCode >= http.StatusInternalServerError
+}
+
+// retryableBackendRequest reports whether a request can be sent to another
+// target: its method is idempotent and it has no body to replay.
+func retryableBackendRequest(req *http.Request) bool {
+	if req == nil || (req.Body != nil && req.Body != http.NoBody) {
+		return false
+	}
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	default:
+		return false
+	}
+}
+
+// releaseWithResponse ends the connection count of a retried target once
+// the response body is closed.
+func releaseWithResponse(resp *http.Response, release func()) *http.Response {
+	if release == nil {
+		return resp
+	}
+	if resp == nil || resp.Body == nil {
+		release()
+		return resp
+	}
+	resp.Body = &releaseOnCloseBody{ReadCloser: resp.Body, release: release}
+	return resp
+}
+
+type releaseOnCloseBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releaseOnCloseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
@@ -0,0 +1,171 @@
+package httpproxy
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/djylb/nps/lib/conn"
+	"github.com/djylb/nps/lib/file"
+	"github.com/djylb/nps/lib/index"
+	serverproxy "github.com/djylb/nps/server/proxy"
+)
+
+// passiveBridgeStub refuses links to dead targets and answers every request
+// on the other targets with the target address.
+type passiveBridgeStub struct {
+	httpBackendBridgeStub
+	mu    sync.Mutex
+	dead  map[string]bool
+	links []string
+}
+
+func (s *passiveBridgeStub) SendLinkInfo(_ int, link *conn.Link, _ *file.Tunnel) (net.Conn, error) {
+	s.mu.Lock()
+	s.links = append(s.links, link.Host)
+	dead := s.dead[link.Host]
+	s.mu.Unlock()
+	if dead {
+		return nil, errors.New("connection refused")
+	}
+	serverSide, peerSide := net.Pipe()
+	go func() {
+		defer func() { _ = peerSide.Close() }()
+		reader := bufio.NewReader(peerSide)
+		for {
+			req, err := http.ReadRequest(reader)
+			if err != nil {
+				return
+			}
+			_, _ = io.Copy(io.Discard, req.Body)
+			resp := &http.Response{
+				StatusCode:    http.StatusOK,
+				ProtoMajor:    1,
+				ProtoMinor:    1,
+				Header:        make(http.Header),
+				ContentLength: int64(len(link.Host)),
+				Body:          io.NopCloser(strings.NewReader(link.Host)),
+			}
+			if err := resp.Write(peerSide); err != nil {
+				return
+			}
+		}
+	}()
+	return serverSide, nil
+}
+
+func (s *passiveBridgeStub) linkCount(addr string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	total := 0
+	for _, link := range s.links {
+		if link == addr {
+			total++
+		}
+	}
+	return total
+}
+
+func TestBackendRoundTripperRetriesAndEjectsFailingTarget(t *testing.T) {
+	loadHTTPProxyTestConfig(t, map[string]any{
+		"http_proxy_passive_failures": 2,
+		"http_proxy_passive_cooldown": 60,
+	})
+	bridge := &passiveBridgeStub{dead: map[string]bool{"dead:80": true}}
+	server := &HttpServer{
+		HttpProxy: &HttpProxy{
+			BaseServer:     serverproxy.NewBaseServer(bridge, nil),
+			HttpProxyCache: index.NewAnyIntIndex(),
+		},
+	}
+	host := &file.Host{
+		Id:     12,
+		Client: &file.Client{Id: 4, Cnf: &file.Config{}, Flow: &file.Flow{}},
+		Flow:   &file.Flow{},
+		Target: &file.Target{TargetStr: "dead:80\nlive:81"},
+	}
+	send := func(method string, body io.Reader) (*http.Response, error) {
+		t.Helper()
+		req, err := http.NewRequest(method, "http://example.com/", body)
+		if err != nil {
+			t.Fatalf("NewRequest() error = %v", err)
+		}
+		req.RemoteAddr = "203.0.113.9:5000"
+		if body == nil {
+			req.Body = nil
+		}
+		ctx, resolved, err := server.resolveHTTPProxyBackendState(req.Context(), req, host, backendSelection{})
+		if err != nil {
+			t.Fatalf("resolveHTTPProxyBackendState() error = %v", err)
+		}
+		return server.newBackendRoundTripper(resolved).RoundTrip(req.WithContext(ctx))
+	}
+	readBody := func(resp *http.Response) string {
+		t.Helper()
+		defer func() { _ = resp.Body.Close() }()
+		data, _ := io.ReadAll(resp.Body)
+		return string(data)
+	}
+
+	// Round-robin starts on the dead target; the GET is retried on the live one.
+	resp, err := send(http.MethodGet, nil)
+	if err != nil {
+		t.Fatalf("first GET error = %v", err)
+	}
+	if got := readBody(resp); got != "live:81" {
+		t.Fatalf("first GET body = %q, want live:81", got)
+	}
+	if host.Target.TargetEjected("dead:80") {
+		t.Fatal("dead:80 ejected after one failure, threshold is two")
+	}
+
+	// A POST with a body is not replayed.
+	if _, err := send(http.MethodGet, nil); err != nil {
+		t.Fatalf("second GET error = %v", err)
+	}
+	if _, err := send(http.MethodPost, strings.NewReader("payload")); err == nil {
+		t.Fatal("POST to the dead target succeeded, want the dial error")
+	}
+	if !host.Target.TargetEjected("dead:80") {
+		t.Fatal("dead:80 was not ejected after two consecutive failures")
+	}
+
+	// While ejected the dead target is not dialed at all.
+	dialed := bridge.linkCount("dead:80")
+	for i := 0; i < 4; i++ {
+		resp, err := send(http.MethodPost, strings.NewReader("payload"))
+		if err != nil {
+			t.Fatalf("POST while dead:80 is ejected error = %v", err)
+		}
+		if got := readBody(resp); got != "live:81" {
+			t.Fatalf("POST body = %q, want live:81", got)
+		}
+	}
+	if got := bridge.linkCount("dead:80"); got != dialed {
+		t.Fatalf("dead:80 dialed %d more times while ejected", got-dialed)
+	}
+}
+
+func TestBackendAttemptFailedIgnoresClientCancellation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "http://example.com/", nil)
+	if !backendAttemptFailed(req, errors.New("dial tcp: connection refused"), nil) {
+		t.Fatal("dial error was not counted as a failure")
+	}
+	if !backendAttemptFailed(req, nil, &http.Response{StatusCode: http.StatusBadGateway}) {
+		t.Fatal("502 response was not counted as a failure")
+	}
+	if backendAttemptFailed(req, nil, &http.Response{StatusCode: http.StatusNotFound}) {
+		t.Fatal("404 response was counted as a failure")
+	}
+	cancel()
+	if backendAttemptFailed(req, context.Canceled, nil) {
+		t.Fatal("client cancellation was counted as a failure")
+	}
+}
@@ -39,6 +39,9 @@ type HttpProxy struct {
 	FlowLimitErrorContent []byte
 	Magic                 *certmagic.Config
 	Acme                  *certmagic.ACMEIssuer
+	magicCache            *certmagic.Cache
+	autoSSLMu             sync.Mutex
+	autoSSLDNS            map[string]*autoSSLDNS
 	configRoot            func() *servercfg.Snapshot
 	dbRoot                func() httpProxyDB
 	closeRuntimeHook      func() error
@@ -139,7 +142,10 @@ func NewHttpProxyWithRoots(baseServer *proxy.BaseServer, httpPort, httpsPort, ht
 	return httpProxy
 }
 
-func (s *HttpProxy) decideAutoSSLHost(name string) error {
+// decideAutoSSLHost allows certificates for names of auto SSL hosts. Names
+// a custom domain host only serves through its wildcard must be approved
+// by the allow list or the ask endpoint first.
+func (s *HttpProxy) decideAutoSSLHost(ctx context.Context, name string) error {
 	h, err := s.currentDB().FindCertByHost(name)
 	if err != nil || h == nil {
 		return fmt.Errorf("unknown host %q", name)
@@ -147,6 +153,9 @@ func (s *HttpProxy) decideAutoSSLHost(name string) error {
 	if !h.AutoSSL {
 		return fmt.Errorf("AutoSSL disabled for %q", name)
 	}
+	if customerDomain(h, name) {
+		return httpProxyOnDemandGate().Approve(ctx, s.onDemandPolicy(), name)
+	}
 	return nil
 }
 
@@ -172,36 +181,7 @@ func (s *HttpProxy) Start() error {
 		s.Http3Bridge = cfg.Proxy.BridgeHTTP3
 	}
 
-	certmagic.Default.Logger = logs.ZapLogger
-	certmagic.DefaultACME.Agreed = true
-	certmagic.DefaultACME.Email = cfg.Proxy.SSL.Email
-	switch strings.ToLower(cfg.Proxy.SSL.CA) {
-	case "letsencrypt", "le", "prod", "production":
-		certmagic.DefaultACME.CA = certmagic.LetsEncryptProductionCA
-	case "zerossl", "zero", "zs":
-		certmagic.DefaultACME.CA = certmagic.ZeroSSLProductionCA
-	case "googletrust", "google", "goog":
-		certmagic.DefaultACME.CA = certmagic.GoogleTrustProductionCA
-	default:
-		certmagic.DefaultACME.CA = certmagic.LetsEncryptStagingCA
-	}
-	certmagic.Default.Storage = &certmagic.FileStorage{
-		Path: common.ResolvePath(cfg.Proxy.SSL.Path),
-	}
-	s.Magic = certmagic.NewDefault()
-	if certmagic.DefaultACME.CA == certmagic.ZeroSSLProductionCA {
-		s.Magic.Issuers = []certmagic.Issuer{
-			&certmagic.ZeroSSLIssuer{
-				APIKey: cfg.Proxy.SSL.ZeroSSLAPI,
-			},
-		}
-	}
-	s.Magic.OnDemand = &certmagic.OnDemandConfig{
-		DecisionFunc: func(ctx context.Context, name string) error {
-			return s.decideAutoSSLHost(name)
-		},
-	}
-	s.Acme = certmagic.NewACMEIssuer(s.Magic, certmagic.DefaultACME)
+	s.setupAutoSSL(cfg)
 
 	if err := s.initHTTPServer(); err != nil {
 		_ = s.Close()
@@ -364,6 +344,9 @@ func (s *HttpProxy) Close() error {
 	if s.HttpProxyCache != nil {
 		s.HttpProxyCache.Clear()
 	}
+	if s.magicCache != nil {
+		s.magicCache.Stop()
+	}
 	return nil
 }
 
@@ -16,6 +16,7 @@ import (
 	"time"
 
 	"github.com/djylb/nps/lib/cache"
+	"github.com/djylb/nps/lib/certinventory"
 	"github.com/djylb/nps/lib/common"
 	"github.com/djylb/nps/lib/conn"
 	"github.com/djylb/nps/lib/crypt"
@@ -122,13 +123,32 @@ func (s *HttpsServer) tlsConfigForCertificate(cert *tls.Certificate, nextProtos
 		return nil
 	}
 	config := &tls.Config{
-		Certificates: []tls.Certificate{*cert},
+		Certificates: []tls.Certificate{*s.stapleOCSP(cert)},
 	}
 	config.NextProtos = nextProtos
 	config.SetSessionTicketKeys(s.ticketKeys)
 	return config
 }
 
+// withHostClientCert asks clients of the host for a certificate issued by
+// its CA bundle. Shared configs are cloned first. Session resumption is off
+// on such hosts, so a ticket issued for another host cannot skip the check.
+func withHostClientCert(config *tls.Config, host *file.Host, shared bool) *tls.Config {
+	if config == nil || host == nil || host.ClientCertMode == "" {
+		return config
+	}
+	if shared {
+		config = config.Clone()
+	}
+	config.ClientCAs = host.ClientCertPool()
+	config.ClientAuth = tls.VerifyClientCertIfGiven
+	if host.ClientCertMode == file.ClientCertRequire {
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	config.SessionTicketsDisabled = true
+	return config
+}
+
 func resolveProxySSLCacheConfig(cfg *servercfg.Snapshot) proxySSLCacheConfig {
 	return proxySSLCacheConfig{
 		maxEntries:     cfg.ProxySSLCacheMaxEntries(),
@@ -172,9 +192,16 @@ func NewHttpsServer(httpServer *HttpServer, l net.Listener) *HttpsServer {
 	https.ticketKeys = append(https.ticketKeys, key)
 
 	https.certMagicTls = https.Magic.TLSConfig()
+	https.certMagicTls.GetCertificate = https.getAutoSSLCertificate
 	https.certMagicTls.NextProtos = append(https.tlsNextProtos, https.certMagicTls.NextProtos...)
 	https.certMagicTls.SetSessionTicketKeys(https.ticketKeys)
 
+	if _, err := https.globalTLSPolicy(); err != nil {
+		logs.Warn("Ignore the TLS policy of nps.conf: %v", err)
+	}
+
+	certinventory.Default().SetRuntime(https, nil)
+
 	go func() {
 		if err := https.httpsServer.Serve(https.httpsServeListener); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			logs.Error("HTTPS server exit: %v", err)
@@ -226,9 +253,10 @@ func (s *HttpsServer) Start() error {
 		}
 
 		var tlsConfig *tls.Config
-		if host.AutoSSL && (s.HttpPort == 80 || s.HttpsPort == 443 || s.currentConfig().Proxy.ForceAutoSSL) {
+		if s.autoSSLEnabled(host) {
 			logs.Debug("Auto SSL is enabled")
-			tlsConfig = s.certMagicTls
+			tlsConfig = s.withHostTLSPolicy(s.certMagicTls, host, true)
+			tlsConfig = withHostClientCert(tlsConfig, host, tlsConfig == s.certMagicTls)
 		} else {
 			cert, err := s.loadHostedCertificate(serverName, host)
 			if err != nil {
@@ -236,7 +264,8 @@ func (s *HttpsServer) Start() error {
 				s.handleHttpsProxy(host, c, rb, serverName)
 				return
 			}
-			tlsConfig = s.tlsConfigForCertificate(cert, s.tlsNextProtos)
+			tlsConfig = s.withHostTLSPolicy(s.tlsConfigForCertificate(cert, s.tlsNextProtos), host, false)
+			tlsConfig = withHostClientCert(tlsConfig, host, false)
 		}
 
 		acceptConn := conn.NewConn(c).SetRb(rb)
@@ -313,7 +342,7 @@ func (s *HttpsServer) writeHTTPSRedirectResponse(c net.Conn, redirectURL string)
 }
 
 func (s *HttpsServer) handleHttpsProxy(host *file.Host, c net.Conn, rb []byte, sni string) {
-	resolved, err := s.resolveHTTPSProxyBackend(host)
+	resolved, err := s.resolveHTTPSProxyBackend(host, c.RemoteAddr())
 	if errors.Is(err, errHTTPProxyInvalidBackend) {
 		logs.Warn("Reject malformed HTTPS backend for %q: %v", sni, err)
 		_ = c.Close()
@@ -338,7 +367,7 @@ func (s *HttpsServer) handleHttpsProxy(host *file.Host, c net.Conn, rb []byte, s
 }
 
 func (s *HttpsServer) handleTlsProxy(host *file.Host, tlsConn net.Conn, sni string) {
-	resolved, err := s.resolveHTTPSProxyBackend(host)
+	resolved, err := s.resolveHTTPSProxyBackend(host, tlsConn.RemoteAddr())
 	if errors.Is(err, errHTTPProxyInvalidBackend) {
 		logs.Warn("Reject malformed TLS backend for %q: %v", sni, err)
 		_ = tlsConn.Close()
@@ -362,7 +391,7 @@ func (s *HttpsServer) handleTlsProxy(host *file.Host, tlsConn net.Conn, sni stri
 	_ = s.DealClient(conn.NewConn(tlsConn), resolved.host.Client, resolved.targetAddr, nil, common.CONN_TCP, nil, []*file.Flow{resolved.host.Flow, resolved.host.Client.Flow}, resolved.host.Target.ProxyProtocol, resolved.host.Target.LocalProxy, resolved.task)
 }
 
-func (s *HttpsServer) resolveHTTPSProxyBackend(host *file.Host) (resolvedHTTPSProxyBackend, error) {
+func (s *HttpsServer) resolveHTTPSProxyBackend(host *file.Host, remote net.Addr) (resolvedHTTPSProxyBackend, error) {
 	resolved := resolvedHTTPSProxyBackend{
 		host: host.SelectRuntimeRoute(),
 	}
@@ -375,13 +404,21 @@ func (s *HttpsServer) resolveHTTPSProxyBackend(host *file.Host) (resolvedHTTPSPr
 		return resolved, err
 	}
 
-	resolved.targetAddr, err = resolved.host.Target.GetRandomTarget()
+	hint := file.TargetHint{}
+	if remote != nil {
+		hint.RemoteAddr = remote.String()
+	}
+	resolved.targetAddr, err = resolved.host.Target.PickTarget(hint)
 	if err != nil {
 		lease.Release()
 		return resolved, err
 	}
 	resolved.task = file.NewTunnelByHost(resolved.host, s.HttpsPort)
-	resolved.release = lease.Release
+	done := resolved.host.Target.AcquireTarget(resolved.targetAddr)
+	resolved.release = func() {
+		done()
+		lease.Release()
+	}
 	return resolved, nil
 }
 
@@ -395,6 +432,7 @@ func (s *HttpsServer) Close() error {
 	if s.httpsServeListener != nil {
 		_ = s.httpsServeListener.Close()
 	}
+	certinventory.Default().SetRuntime(nil, s)
 	if s.cert != nil {
 		s.cert.Stop()
 	}
@@ -809,8 +847,8 @@ func (s *Http3Server) GetConfigForClient(info *tls.ClientHelloInfo) (*tls.Config
 		return nil, nil
 	}
 
-	if host.AutoSSL && (s.HttpPort == 80 || s.HttpsPort == 443 || s.currentConfig().Proxy.ForceAutoSSL) {
-		return s.certMagicTls, nil
+	if s.autoSSLEnabled(host) {
+		return withHostClientCert(s.certMagicTls, host, true), nil
 	}
 
 	cert, err := s.loadHostedCertificate(info.ServerName, host)
@@ -822,6 +860,6 @@ func (s *Http3Server) GetConfigForClient(info *tls.ClientHelloInfo) (*tls.Config
 	if s.Http3Bridge {
 		nextProtos = s.http3NextProtos
 	}
-	config := s.tlsConfigForCertificate(cert, nextProtos)
+	config := withHostClientCert(s.tlsConfigForCertificate(cert, nextProtos), host, false)
 	return config, nil
 }
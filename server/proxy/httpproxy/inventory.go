@@ -0,0 +1,91 @@
+package httpproxy
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+
+	"github.com/caddyserver/certmagic"
+	"github.com/djylb/nps/lib/certinventory"
+)
+
+var _ certinventory.Runtime = (*HttpsServer)(nil)
+
+// autoSSLEvent records the end of every issuance and renewal, including the
+// ones certmagic runs in the background.
+func autoSSLEvent(_ context.Context, event string, data map[string]any) error {
+	certinventory.Default().RecordEvent(event, data)
+	return nil
+}
+
+// autoSSLConfigForName returns the config that issues certificates for name.
+func (s *HttpProxy) autoSSLConfigForName(name string) *certmagic.Config {
+	host, err := s.currentDB().FindCertByHost(name)
+	if err == nil && host != nil {
+		if spec := s.autoSSLDNSForName(host, name); spec != "" {
+			if dns, err := s.autoSSLDNSFor(spec); err == nil {
+				return dns.managed
+			}
+		}
+	}
+	return s.Magic
+}
+
+// cachedAutoSSLCertificate returns the cached certificate that serves name
+// and expires last, loading it from storage when no handshake did yet.
+func (s *HttpProxy) cachedAutoSSLCertificate(ctx context.Context, name string) (certmagic.Certificate, bool) {
+	if s.magicCache == nil || s.Magic == nil {
+		return certmagic.Certificate{}, false
+	}
+	certs := s.magicCache.AllMatchingCertificates(name)
+	if len(certs) == 0 {
+		if cert, err := s.autoSSLConfigForName(name).CacheManagedCertificate(ctx, name); err == nil {
+			certs = append(certs, cert)
+		}
+	}
+	var best certmagic.Certificate
+	for _, cert := range certs {
+		if cert.Leaf != nil && (best.Leaf == nil || cert.Leaf.NotAfter.After(best.Leaf.NotAfter)) {
+			best = cert
+		}
+	}
+	return best, best.Leaf != nil
+}
+
+// ACMECertificate returns the issued certificate that serves name.
+func (s *HttpsServer) ACMECertificate(name string) (*x509.Certificate, bool) {
+	cert, ok := s.cachedAutoSSLCertificate(context.Background(), name)
+	return cert.Leaf, ok
+}
+
+// RenewACME renews the certificate that serves name, or obtains one when
+// none was issued yet, and puts it in the cache.
+func (s *HttpsServer) RenewACME(ctx context.Context, name string) error {
+	if s.magicCache == nil || s.Magic == nil {
+		return errors.New("auto ssl is not set up")
+	}
+	cert, ok := s.cachedAutoSSLCertificate(ctx, name)
+	if !ok {
+		cfg := s.autoSSLConfigForName(name)
+		if err := cfg.ObtainCertSync(ctx, name); err != nil {
+			return err
+		}
+		_, err := cfg.CacheManagedCertificate(ctx, name)
+		return err
+	}
+	cfg, _ := s.autoSSLConfigForCert(cert)
+	subject := cert.Names[0]
+	if err := cfg.RenewCertSync(ctx, subject, true); err != nil {
+		return err
+	}
+	s.magicCache.Remove([]string{cert.Hash()})
+	_, err := cfg.CacheManagedCertificate(ctx, subject)
+	return err
+}
+
+// ReloadManual drops the cached manual certificate with hash.
+func (s *HttpsServer) ReloadManual(hash string) {
+	if s.cert != nil {
+		s.cert.Forget(hash)
+	}
+}
@@ -0,0 +1,52 @@
+package httpproxy
+
+import (
+	"context"
+	"strings"
+
+	"github.com/djylb/nps/lib/file"
+	"github.com/djylb/nps/lib/logs"
+	"github.com/djylb/nps/lib/ondemand"
+)
+
+var httpProxyOnDemandGate = ondemand.Default
+
+// customerDomain reports whether name reaches a custom domain host through
+// its wildcard instead of its own name. Such names belong to customers and
+// need approval before a certificate is ordered.
+func customerDomain(host *file.Host, name string) bool {
+	return host != nil && host.CustomDomain && !strings.EqualFold(host.Host, strings.TrimSuffix(name, "."))
+}
+
+// autoSSLDNSForName returns the DNS-01 provider that issues name. Customer
+// domains are not in our zones, so they always use HTTP-01 or TLS-ALPN-01.
+func (s *HttpProxy) autoSSLDNSForName(host *file.Host, name string) string {
+	if customerDomain(host, name) {
+		return ""
+	}
+	return s.hostAutoSSLDNS(host)
+}
+
+func (s *HttpProxy) onDemandPolicy() ondemand.Policy {
+	cfg := s.currentConfig()
+	return ondemand.Policy{
+		Ask:    cfg.Proxy.SSL.Ask,
+		Allow:  cfg.Proxy.SSL.AskAllow,
+		Limit:  cfg.Proxy.SSL.IssueLimit,
+		Window: cfg.ProxySSLIssueWindow(),
+	}
+}
+
+// onAutoSSLEvent rate limits new orders per domain before recording the
+// event. Renewals are not limited.
+func (s *HttpProxy) onAutoSSLEvent(ctx context.Context, event string, data map[string]any) error {
+	if event == "cert_obtaining" && data["renewal"] != true {
+		if name, _ := data["identifier"].(string); name != "" {
+			if err := httpProxyOnDemandGate().Issue(s.onDemandPolicy(), name); err != nil {
+				logs.Warn("Obtain certificate %s: %v", name, err)
+				return err
+			}
+		}
+	}
+	return autoSSLEvent(ctx, event, data)
+}
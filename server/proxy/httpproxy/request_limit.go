@@ -0,0 +1,56 @@
+package httpproxy
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/djylb/nps/lib/common"
+	"github.com/djylb/nps/lib/conn"
+	"github.com/djylb/nps/lib/file"
+	"github.com/djylb/nps/lib/logs"
+)
+
+// httpProxyClientIP returns the client of a request for the request limit
+// and firewall rules of a host. Forwarded headers are only trusted from the
+// HTTP-only pass or a trusted proxy, so clients cannot pick their own IP.
+func (s *HttpServer) httpProxyClientIP(r *http.Request, isHTTPOnlyRequest bool) string {
+	if s.trustsHTTPProxyForwarding(r, isHTTPOnlyRequest) {
+		return conn.GetRealIP(r, "")
+	}
+	return remoteIP(r)
+}
+
+// trustsHTTPProxyForwarding reports whether the forwarded headers of a
+// request come from the HTTP-only pass or a trusted proxy.
+func (s *HttpServer) trustsHTTPProxyForwarding(r *http.Request, isHTTPOnlyRequest bool) bool {
+	auth := s.currentConfig().Auth
+	return isHTTPOnlyRequest || (auth.AllowXRealIP && common.IsTrustedProxy(auth.TrustedProxyIPs, remoteIP(r)))
+}
+
+func remoteIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+// allowHTTPProxyRequestRate enforces the request limit of the host and
+// answers 429 with Retry-After when the client is over it.
+func (s *HttpServer) allowHTTPProxyRequestRate(w http.ResponseWriter, r *http.Request, host *file.Host, isHTTPOnlyRequest bool) bool {
+	if host.ReqLimit <= 0 {
+		return true
+	}
+	clientIP := s.httpProxyClientIP(r, isHTTPOnlyRequest)
+	ok, wait := host.AllowRequest(r, clientIP, time.Now())
+	if ok {
+		return true
+	}
+	retryAfter := int64((wait + time.Second - 1) / time.Second)
+	w.Header().Set("Retry-After", strconv.FormatInt(retryAfter, 10))
+	http.Error(w, "429 Too Many Requests", http.StatusTooManyRequests)
+	logs.Debug("Request limit exceeded, host id %d, client %s, path %s", host.Id, clientIP, r.URL.Path)
+	return false
+}
@@ -0,0 +1,46 @@
+package httpproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/djylb/nps/lib/file"
+)
+
+func TestAllowHTTPProxyRequestRateAnswersTooManyRequests(t *testing.T) {
+	loadHTTPProxyTestConfig(t, map[string]any{})
+	server := &HttpServer{HttpProxy: &HttpProxy{}}
+	host := &file.Host{Id: 61, ReqLimit: 2, ReqLimitWindow: 10}
+	file.InitializeHostRuntime(host)
+
+	newRequest := func(remote string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+		req.RemoteAddr = remote
+		req.Header.Set("X-Forwarded-For", "198.51.100.7")
+		return req
+	}
+	for i := 0; i < 2; i++ {
+		if !server.allowHTTPProxyRequestRate(httptest.NewRecorder(), newRequest("203.0.113.1:1000"), host, false) {
+			t.Fatalf("request %d within the limit was refused", i+1)
+		}
+	}
+	recorder := httptest.NewRecorder()
+	if server.allowHTTPProxyRequestRate(recorder, newRequest("203.0.113.1:1001"), host, false) {
+		t.Fatal("request over the limit was allowed")
+	}
+	if recorder.Code != http.StatusTooManyRequests || recorder.Header().Get("Retry-After") != "5" {
+		t.Fatalf("refusal = %d Retry-After=%q", recorder.Code, recorder.Header().Get("Retry-After"))
+	}
+	if host.RequestsLimited() != 1 {
+		t.Fatalf("RequestsLimited() = %d, want 1", host.RequestsLimited())
+	}
+
+	// The forwarded address is only used for a trusted request.
+	if !server.allowHTTPProxyRequestRate(httptest.NewRecorder(), newRequest("203.0.113.2:1000"), host, false) {
+		t.Fatal("another client shared the bucket")
+	}
+	if got := server.httpProxyClientIP(newRequest("203.0.113.2:1000"), true); got != "198.51.100.7" {
+		t.Fatalf("httpProxyClientIP() for the HTTP-only pass = %q", got)
+	}
+}
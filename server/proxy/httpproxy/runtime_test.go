@@ -1,6 +1,7 @@
 package httpproxy
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
@@ -302,7 +303,7 @@ func TestHttpProxyAutoSSLDecisionRejectsNilHost(t *testing.T) {
 		return &httpProxyDBStub{}
 	})
 
-	err := httpProxy.decideAutoSSLHost("nil.example.com")
+	err := httpProxy.decideAutoSSLHost(context.Background(), "nil.example.com")
 	if err == nil || !strings.Contains(err.Error(), `unknown host "nil.example.com"`) {
 		t.Fatalf("DecisionFunc() err = %v, want unknown host error", err)
 	}
@@ -0,0 +1,73 @@
+package httpproxy
+
+import (
+	"crypto/tls"
+	"net/http"
+
+	"github.com/djylb/nps/lib/file"
+	"github.com/djylb/nps/lib/tlspolicy"
+)
+
+// globalTLSPolicy returns the TLS policy of nps.conf.
+func (s *HttpProxy) globalTLSPolicy() (tlspolicy.Policy, error) {
+	ssl := s.currentConfig().Proxy.SSL
+	return tlspolicy.Normalize(tlspolicy.Policy{
+		MinVersion:    ssl.TLSMinVersion,
+		CipherProfile: ssl.TLSProfile,
+		ALPN:          ssl.TLSALPN,
+		HSTS:          tlspolicy.HSTS(ssl.HSTSMaxAge, ssl.HSTSSubdomains, ssl.HSTSPreload),
+	})
+}
+
+// hostTLSPolicy returns the TLS policy of host, filling the settings it
+// leaves empty from nps.conf. An invalid global policy is ignored; it is
+// reported when the HTTPS server starts.
+func (s *HttpProxy) hostTLSPolicy(host *file.Host) tlspolicy.Policy {
+	global, err := s.globalTLSPolicy()
+	if err != nil {
+		global = tlspolicy.Policy{}
+	}
+	if host == nil {
+		return global
+	}
+	return tlspolicy.Policy{
+		MinVersion:    host.TLSMinVersion,
+		CipherProfile: host.TLSCipherProfile,
+		ALPN:          host.TLSALPN,
+		HSTS:          host.HSTS,
+	}.Merge(global)
+}
+
+// withHostTLSPolicy applies the TLS policy of host to config. Shared
+// configs are cloned first.
+func (s *HttpProxy) withHostTLSPolicy(config *tls.Config, host *file.Host, shared bool) *tls.Config {
+	policy := s.hostTLSPolicy(host)
+	if config == nil || !policy.ChangesConfig() {
+		return config
+	}
+	if shared {
+		config = config.Clone()
+	}
+	policy.Apply(config)
+	return config
+}
+
+// stapleOCSP staples the OCSP response of a manual certificate when
+// tls_ocsp_stapling is on. Certificates issued here are stapled by
+// certmagic.
+func (s *HttpProxy) stapleOCSP(cert *tls.Certificate) *tls.Certificate {
+	if !s.currentConfig().Proxy.SSL.OCSPStapling {
+		return cert
+	}
+	return tlspolicy.DefaultStapler().Staple(cert)
+}
+
+// setHSTSHeader adds the HSTS header of host to responses sent over TLS.
+func (s *HttpProxy) setHSTSHeader(header http.Header, r *http.Request, host *file.Host) {
+	if r.TLS == nil {
+		return
+	}
+	if value := s.hostTLSPolicy(host).HSTSHeader(); value != "" {
+		header.Set("Strict-Transport-Security", value)
+	}
+}
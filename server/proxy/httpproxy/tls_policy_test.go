@@ -0,0 +1,50 @@
+package httpproxy
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/djylb/nps/lib/file"
+)
+
+func TestHostTLSPolicyOverridesGlobal(t *testing.T) {
+	loadHTTPProxyTestConfig(t, map[string]any{
+		"tls_cipher_profile":      "intermediate",
+		"hsts_max_age":            31536000,
+		"hsts_include_subdomains": true,
+		"hsts_preload":            true,
+	})
+	s := &HttpProxy{}
+	shared := &tls.Config{NextProtos: []string{"h2", "http/1.1", "acme-tls/1"}}
+
+	global := s.withHostTLSPolicy(shared, &file.Host{Host: "a.example.com"}, true)
+	if global == shared || global.MinVersion != tls.VersionTLS12 || len(global.CipherSuites) == 0 || shared.MinVersion != 0 {
+		t.Fatalf("global policy config = %+v, shared = %+v", global, shared)
+	}
+	host := &file.Host{Host: "b.example.com", TLSMinVersion: "1.3", TLSALPN: "http/1.1", HSTS: "off"}
+	config := s.withHostTLSPolicy(shared, host, true)
+	if config.MinVersion != tls.VersionTLS13 || !slices.Equal(config.NextProtos, []string{"http/1.1", "acme-tls/1"}) {
+		t.Fatalf("host policy config MinVersion = %x NextProtos = %v", config.MinVersion, config.NextProtos)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "https://a.example.com/", nil)
+	req.TLS = &tls.ConnectionState{}
+	header := http.Header{}
+	s.setHSTSHeader(header, req, &file.Host{Host: "a.example.com"})
+	if got := header.Get("Strict-Transport-Security"); got != "max-age=31536000; includeSubDomains; preload" {
+		t.Fatalf("global HSTS = %q", got)
+	}
+	header = http.Header{}
+	s.setHSTSHeader(header, req, host)
+	if header.Get("Strict-Transport-Security") != "" {
+		t.Fatal("host with hsts=off must not send HSTS")
+	}
+	header = http.Header{}
+	s.setHSTSHeader(header, httptest.NewRequest(http.MethodGet, "http://a.example.com/", nil), &file.Host{Host: "a.example.com"})
+	if header.Get("Strict-Transport-Security") != "" {
+		t.Fatal("HSTS must not be sent over plain HTTP")
+	}
+}
@@ -0,0 +1,96 @@
+package httpproxy
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/djylb/nps/lib/common"
+	"github.com/djylb/nps/lib/file"
+	"github.com/djylb/nps/lib/logs"
+	"github.com/djylb/nps/lib/waf"
+)
+
+// wafTagHeader carries the tags of matching tag rules to the backend.
+const wafTagHeader = "X-NPS-WAF-Tags"
+
+// wafChallengePage searches for a nonce whose SHA-256 together with the
+// token has the required zero bits and stores the proof as the cookie.
+// The hash is written out in the page because crypto.subtle only exists in
+// secure contexts.
+const wafChallengePage = `<!DOCTYPE html>
+<html><head><meta charset="utf-8"><meta name="robots" content="noindex"><title>Checking your browser</title></head>
+<body><noscript>Please enable JavaScript to continue.</noscript>
+<script>(function(){var t="%s",b=%d,K=[],I=[],p=2,n=0,i,c;
+function f(x){return(x-Math.floor(x))*4294967296|0}
+function r(x,n){return(x>>>n)|(x<<(32-n))}
+for(;n<64;p++){for(i=2;i*i<=p;i++)if(p%%i==0)break;if(i*i>p){if(n<8)I[n]=f(Math.pow(p,1/2));K[n++]=f(Math.pow(p,1/3))}}
+function h(s){var H=I.slice(0),w=[],l=s.length*8,i,j,W,o,a,e,t1,t2;
+s+="\x80";while((s.length&63)!=56)s+="\x00";
+for(i=0;i<s.length;i++)w[i>>2]|=s.charCodeAt(i)<<((3-i&3)*8);
+w.push(l/4294967296|0,l|0);
+for(j=0;j<w.length;j+=16){W=w.slice(j,j+16);o=H.slice(0);
+for(i=0;i<64;i++){a=H[0];e=H[4];
+if(i>15){t1=W[i-15];t2=W[i-2];W[i]=W[i-16]+(r(t1,7)^r(t1,18)^t1>>>3)+W[i-7]+(r(t2,17)^r(t2,19)^t2>>>10)|0}
+t1=H[7]+(r(e,6)^r(e,11)^r(e,25))+(e&H[5]^~e&H[6])+K[i]+W[i]|0;
+t2=(r(a,2)^r(a,13)^r(a,22))+(a&H[1]^a&H[2]^H[1]&H[2])|0;
+H.unshift(t1+t2|0);H[4]=H[4]+t1|0;H.pop()}
+for(i=0;i<8;i++)H[i]=H[i]+o[i]|0}
+return H[0]>>>0}
+for(c=0;h(t+"."+c)>>>(32-b);c++);
+document.cookie="%s="+t+"."+c+"; path=/; max-age=86400; SameSite=Lax%s";location.reload()})()</script>
+</body></html>`
+
+// applyHTTPProxyWAF runs the global, user and host firewall rules. Blocked
+// and challenged requests are answered here and return false.
+func (s *HttpServer) applyHTTPProxyWAF(w http.ResponseWriter, r *http.Request, host *file.Host, isHTTPOnlyRequest bool) bool {
+	sets := s.HostWAFRules(host)
+	active := false
+	for _, set := range sets {
+		active = active || set.Len() > 0
+	}
+	if !active {
+		return true
+	}
+	clientIP := s.httpProxyClientIP(r, isHTTPOnlyRequest)
+	result := waf.Evaluate(r, clientIP, sets...)
+	for _, rule := range result.Logged {
+		logs.Info("WAF rule matched, host id %d, client %s, %s %s, rule %q", host.Id, clientIP, r.Method, r.URL.Path, rule.Text)
+	}
+	r.Header.Del(wafTagHeader)
+	if len(result.Tags) > 0 {
+		r.Header.Set(wafTagHeader, strings.Join(result.Tags, ","))
+	}
+	if result.BodyLimit > 0 && r.Body != nil && r.Body != http.NoBody {
+		r.Body = http.MaxBytesReader(w, r.Body, result.BodyLimit)
+	}
+	switch result.Action {
+	case waf.ActionBlock:
+		logs.Warn("WAF blocked request, host id %d, client %s, %s %s, rule %q", host.Id, clientIP, r.Method, r.URL.Path, result.Rule.Text)
+		http.Error(w, "403 Forbidden", http.StatusForbidden)
+		return false
+	case waf.ActionChallenge:
+		hostname := common.RemovePortFromHost(r.Host)
+		now := time.Now()
+		if waf.ChallengePassed(r, clientIP, hostname, now) {
+			// Block rules after the challenge were already evaluated.
+			return true
+		}
+		logs.Debug("WAF challenged request, host id %d, client %s, %s %s, rule %q", host.Id, clientIP, r.Method, r.URL.Path, result.Rule.Text)
+		writeWAFChallenge(w, r, waf.ChallengeToken(clientIP, hostname, now))
+		return false
+	}
+	return true
+}
+
+func writeWAFChallenge(w http.ResponseWriter, r *http.Request, token string) {
+	secure := ""
+	if r.TLS != nil {
+		secure = "; Secure"
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusForbidden)
+	_, _ = fmt.Fprintf(w, wafChallengePage, token, waf.ChallengeBits, waf.ChallengeCookie, secure)
+}
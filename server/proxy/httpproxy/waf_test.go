@@ -0,0 +1,81 @@
+package httpproxy
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/djylb/nps/lib/file"
+	"github.com/djylb/nps/lib/waf"
+)
+
+func TestApplyHTTPProxyWAFBlocksChallengesAndTags(t *testing.T) {
+	loadHTTPProxyTestConfig(t, map[string]any{})
+	server := &HttpServer{HttpProxy: &HttpProxy{}}
+	host := &file.Host{Id: 71, WafRules: "block path^=/.env\nchallenge ua*=curl\nblock path^=/admin\ntag:api path^=/api/"}
+	file.InitializeHostRuntime(host)
+
+	newRequest := func(path, ua string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "http://example.com"+path, nil)
+		req.RemoteAddr = "203.0.113.1:1000"
+		req.Header.Set("User-Agent", ua)
+		req.Header.Set(wafTagHeader, "spoofed")
+		return req
+	}
+
+	recorder := httptest.NewRecorder()
+	if server.applyHTTPProxyWAF(recorder, newRequest("/.env", "Mozilla"), host, false) || recorder.Code != http.StatusForbidden {
+		t.Fatalf("scanner path status = %d", recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	if server.applyHTTPProxyWAF(recorder, newRequest("/", "curl/8"), host, false) {
+		t.Fatal("challenged request was passed through")
+	}
+	body := recorder.Body.String()
+	start := strings.Index(body, `var t="`)
+	if recorder.Code != http.StatusForbidden || start < 0 {
+		t.Fatalf("challenge = %d %q", recorder.Code, body)
+	}
+	token := strings.SplitN(body[start+len(`var t="`):], `"`, 2)[0]
+	req := newRequest("/", "curl/8")
+	req.AddCookie(&http.Cookie{Name: waf.ChallengeCookie, Value: token})
+	if server.applyHTTPProxyWAF(httptest.NewRecorder(), req, host, false) {
+		t.Fatal("token copied from the page passed the challenge")
+	}
+	proof := solveWAFChallenge(token)
+	req = newRequest("/", "curl/8")
+	req.AddCookie(&http.Cookie{Name: waf.ChallengeCookie, Value: proof})
+	if !server.applyHTTPProxyWAF(httptest.NewRecorder(), req, host, false) {
+		t.Fatal("request with a solved challenge was refused")
+	}
+	recorder = httptest.NewRecorder()
+	req = newRequest("/admin", "curl/8")
+	req.AddCookie(&http.Cookie{Name: waf.ChallengeCookie, Value: proof})
+	if server.applyHTTPProxyWAF(recorder, req, host, false) || recorder.Code != http.StatusForbidden || strings.Contains(recorder.Body.String(), "<script>") {
+		t.Fatalf("block rule after a passed challenge = %d %q", recorder.Code, recorder.Body.String())
+	}
+
+	req = newRequest("/api/items", "Mozilla")
+	if !server.applyHTTPProxyWAF(httptest.NewRecorder(), req, host, false) || req.Header.Get(wafTagHeader) != "api" {
+		t.Fatalf("tagged request header = %q", req.Header.Get(wafTagHeader))
+	}
+	req = newRequest("/", "Mozilla")
+	if !server.applyHTTPProxyWAF(httptest.NewRecorder(), req, host, false) || req.Header.Get(wafTagHeader) != "" {
+		t.Fatalf("client supplied %s was forwarded: %q", wafTagHeader, req.Header.Get(wafTagHeader))
+	}
+}
+
+func solveWAFChallenge(token string) string {
+	for nonce := 0; ; nonce++ {
+		proof := token + "." + strconv.Itoa(nonce)
+		sum := sha256.Sum256([]byte(proof))
+		if binary.BigEndian.Uint32(sum[:4])>>(32-waf.ChallengeBits) == 0 {
+			return proof
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/djylb/nps/lib/file"
 	"github.com/djylb/nps/lib/rate"
 	"github.com/djylb/nps/lib/servercfg"
+	"github.com/djylb/nps/lib/waf"
 )
 
 type proxyRuntimeContext struct {
@@ -162,6 +163,10 @@ type proxyGlobalAccessSource interface {
 	AllowsSourceAddr(string) bool
 }
 
+type proxyGlobalWAFSource interface {
+	WAFRuleSet() *waf.RuleSet
+}
+
 type proxyAccessPolicyRuntime struct {
 	users         proxyClientUserRuntime
 	currentGlobal func() proxyGlobalAccessSource
@@ -209,6 +214,22 @@ func (r proxyAccessPolicyRuntime) IsHostSourceAccessDenied(host *file.Host, ipPo
 		IsHostSourceDenied(host, ipPort)
 }
 
+// HostWAFRules returns the global, user and host firewall rules of a host
+// in evaluation order.
+func (r proxyAccessPolicyRuntime) HostWAFRules(host *file.Host) []*waf.RuleSet {
+	if host == nil {
+		return nil
+	}
+	sets := make([]*waf.RuleSet, 0, 3)
+	if global, ok := r.globalSource().(proxyGlobalWAFSource); ok {
+		sets = append(sets, global.WAFRuleSet())
+	}
+	if user := r.users.Resolve(host.Client); user != nil {
+		sets = append(sets, user.WAFRuleSet())
+	}
+	return append(sets, host.WAFRuleSet())
+}
+
 func (r proxyAccessPolicyRuntime) globalSource() proxyGlobalAccessSource {
 	if r.currentGlobal == nil {
 		return nil
@@ -242,9 +263,9 @@ func (r proxyUserQuotaRuntime) Check(client *file.Client, nowUnix int64) error {
 	if user.ExpireAt > 0 && user.ExpireAt <= nowUnix {
 		return errProxyServiceAccessExpired
 	}
-	if user.FlowLimit > 0 {
+	if flowLimit := user.QuotaLimitBytes(); flowLimit > 0 {
 		_, _, total := user.TotalTrafficTotals()
-		if total >= user.FlowLimit {
+		if total >= flowLimit {
 			return errProxyTrafficLimitExceeded
 		}
 	}
@@ -284,7 +305,7 @@ func (proxyClientLifecycleRuntime) CheckLifecycle(client *file.Client, nowUnix i
 	if expireAt := client.EffectiveExpireAt(); expireAt > 0 && expireAt <= nowUnix {
 		return errProxyServiceAccessExpired
 	}
-	if flowLimit := client.EffectiveFlowLimitBytes(); flowLimit > 0 {
+	if flowLimit := client.QuotaLimitBytes(); flowLimit > 0 {
 		_, _, total := client.TotalTrafficTotals()
 		if total >= flowLimit {
 			return errProxyTrafficLimitExceeded
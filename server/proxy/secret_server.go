@@ -181,7 +181,11 @@ func resolveSecretRoute(task *file.Tunnel, lk *conn.Link, allowSecretLocal bool)
 		return "", "", false, errSecretTargetRequired
 	}
 	if strings.TrimSpace(task.Target.TargetStr) != "" {
-		host, err := task.Target.GetRandomTarget()
+		hint := file.TargetHint{}
+		if lk != nil {
+			hint.RemoteAddr = lk.RemoteAddr
+		}
+		host, err := task.Target.PickTarget(hint)
 		if err != nil {
 			return "", "", false, err
 		}
@@ -26,7 +26,9 @@ func (s *TunnelModeServer) SocksAuth(c net.Conn) error {
 		return err
 	}
 
-	valid := tunnelProxyAuthPolicy(task).CheckCredentials(username, password)
+	policy := tunnelProxyAuthPolicy(task)
+	valid := (!policy.RequiresAuth() || policy.CheckCredentials(username, password)) &&
+		allowSocks5ForwardAuth(task, c, username, password)
 	status := authFailure
 	if valid {
 		status = authSuccess
@@ -81,7 +83,7 @@ func (s *TunnelModeServer) negotiateSocks5Method(c net.Conn, methods []byte) err
 }
 
 func socks5NeedsAuth(task *file.Tunnel) bool {
-	return tunnelProxyAuthPolicy(task).RequiresAuth()
+	return tunnelProxyAuthPolicy(task).RequiresAuth() || (task != nil && task.ForwardAuth != "")
 }
 
 func supportsSocks5Method(methods []byte, method byte) bool {
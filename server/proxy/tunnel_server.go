@@ -440,6 +440,7 @@ func ProcessTunnel(c *conn.Conn, s *TunnelModeServer) error {
 	if err != nil {
 		return err
 	}
+	defer task.Target.AcquireTarget(targetAddr)()
 	return s.DealClient(c, task.Client, targetAddr, nil, common.CONN_TCP, nil, []*file.Flow{task.Flow, task.Client.Flow}, task.Target.ProxyProtocol, task.Target.LocalProxy, task)
 }
 
@@ -451,7 +452,11 @@ func (s *TunnelModeServer) prepareTunnelRouteTarget(c *conn.Conn) (*file.Tunnel,
 		}
 		return nil, "", err
 	}
-	targetAddr, err := task.Target.GetRandomTarget()
+	hint := file.TargetHint{}
+	if c != nil {
+		hint.RemoteAddr = c.RemoteAddr().String()
+	}
+	targetAddr, err := task.Target.PickTarget(hint)
 	if err == nil {
 		return task, targetAddr, nil
 	}
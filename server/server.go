@@ -241,7 +241,7 @@ func shouldDisconnectUserOwnedClient(now int64, client *file.Client) bool {
 		return true
 	}
 	_, _, total := user.TotalTrafficTotals()
-	return trafficLimitReached(user.FlowLimit, total)
+	return trafficLimitReached(user.QuotaLimitBytes(), total)
 }
 
 func shouldDisconnectStandaloneClient(now int64, client *file.Client) bool {
@@ -249,7 +249,7 @@ func shouldDisconnectStandaloneClient(now int64, client *file.Client) bool {
 		return true
 	}
 	_, _, total := client.TotalTrafficTotals()
-	return trafficLimitReached(client.EffectiveFlowLimitBytes(), total)
+	return trafficLimitReached(client.QuotaLimitBytes(), total)
 }
 
 var errRuntimeBridgeUnavailable = errors.New("runtime bridge unavailable")
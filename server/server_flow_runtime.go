@@ -150,7 +150,8 @@ func (c runtimeFlowCoordinator) addClientTrafficFromFlow(clients map[int]*file.C
 	if flow == nil {
 		return
 	}
-	c.addClientTraffic(clients, clientID, flow.InletFlow, flow.ExportFlow)
+	inlet, export := flow.Snapshot()
+	c.addClientTraffic(clients, clientID, inlet, export)
 }
 
 func (c runtimeFlowCoordinator) addClientTraffic(clients map[int]*file.Client, clientID int, inlet, export int64) {
@@ -85,20 +85,32 @@ func ProtectedActionCatalog(app *App) []ActionSpec {
 		{Resource: "system", Action: "status", Method: http.MethodGet, Path: "/api/system/status", Protected: true, Visible: nodeActionVisibleByScope(func(scope webservice.NodeAccessScope) bool { return scope.CanViewStatus() }), Handler: app.NodeStatus},
 		{Resource: "system", Action: "changes", Method: http.MethodGet, Path: "/api/system/changes", Protected: true},
 		{Resource: "system", Action: "usage_snapshot", Method: http.MethodGet, Path: "/api/system/usage-snapshot", Protected: true, Visible: nodeActionVisibleByScope(func(scope webservice.NodeAccessScope) bool { return scope.CanViewUsage() }), Handler: app.NodeUsageSnapshot},
+		{Resource: "usage", Action: "series", Method: http.MethodGet, Path: "/api/usage/series", Protected: true, Visible: nodeActionVisibleByScope(func(scope webservice.NodeAccessScope) bool { return scope.CanViewUsage() }), Handler: app.NodeUsageSeries},
 		{Resource: "system", Action: "export", Method: http.MethodGet, Path: "/api/system/export", Protected: true, Visible: nodeActionVisibleByScope(func(scope webservice.NodeAccessScope) bool { return scope.CanExportConfig() }), Handler: app.NodeConfig},
 		{Resource: "system", Action: "import", Method: http.MethodPost, Path: "/api/system/import", Protected: true, Visible: nodeActionVisibleByScope(func(scope webservice.NodeAccessScope) bool { return scope.CanExportConfig() })},
+		{Resource: "system", Action: "restore", Method: http.MethodPost, Path: "/api/system/restore", Protected: true, Visible: nodeActionVisibleByScope(func(scope webservice.NodeAccessScope) bool { return scope.CanExportConfig() })},
+		{Resource: "system", Action: "apply", Method: http.MethodPost, Path: "/api/system/apply", Permission: webservice.PermissionManagementAdmin, Protected: true, Handler: app.NodeApplyConfig},
+		{Resource: "system_snapshots", Action: "list", Method: http.MethodGet, Path: "/api/system/snapshots", Protected: true, Visible: nodeActionVisibleByScope(func(scope webservice.NodeAccessScope) bool { return scope.CanExportConfig() })},
+		{Resource: "system_snapshots", Action: "create", Method: http.MethodPost, Path: "/api/system/snapshots/actions/create", Protected: true, Visible: nodeActionVisibleByScope(func(scope webservice.NodeAccessScope) bool { return scope.CanExportConfig() })},
+		{Resource: "system_snapshots", Action: "restore", Method: http.MethodPost, Path: "/api/system/snapshots/actions/restore", Protected: true, Visible: nodeActionVisibleByScope(func(scope webservice.NodeAccessScope) bool { return scope.CanExportConfig() })},
 		{Resource: "system", Action: "sync", Method: http.MethodPost, Path: "/api/system/actions/sync", Protected: true, Visible: nodeActionVisibleByScope(func(scope webservice.NodeAccessScope) bool { return scope.CanSync() }), Handler: app.NodeSync},
 		{Resource: "traffic", Action: "write", Method: http.MethodPost, Path: "/api/traffic", Protected: true, Visible: nodeActionVisibleByScope(func(scope webservice.NodeAccessScope) bool { return scope.CanWriteTraffic() }), Handler: app.NodeTraffic},
 		{Resource: "tunnels", Action: "list", Method: http.MethodGet, Path: "/api/tunnels", Permission: webservice.PermissionTunnelsRead, ClientScope: true, Protected: true, Handler: app.NodeTunnels},
 		{Resource: "tunnels", Action: "create", Method: http.MethodPost, Path: "/api/tunnels", Permission: webservice.PermissionTunnelsCreate, ClientScope: true, Protected: true, Handler: app.NodeCreateTunnel},
+		{Resource: "tunnels", Action: "bulk", Method: http.MethodPost, Path: "/api/tunnels/actions/bulk", Permission: webservice.PermissionManagementAdmin, Protected: true, Handler: app.NodeBulkTunnels},
 		{Resource: "hosts", Action: "list", Method: http.MethodGet, Path: "/api/hosts", Permission: webservice.PermissionHostsRead, ClientScope: true, Protected: true, Handler: app.NodeHosts},
 		{Resource: "hosts", Action: "create", Method: http.MethodPost, Path: "/api/hosts", Permission: webservice.PermissionHostsCreate, ClientScope: true, Protected: true, Handler: app.NodeCreateHost},
+		{Resource: "hosts", Action: "bulk", Method: http.MethodPost, Path: "/api/hosts/actions/bulk", Permission: webservice.PermissionManagementAdmin, Protected: true, Handler: app.NodeBulkHosts},
 		{Resource: "tunnels", Action: "read", Method: http.MethodGet, Path: "/api/tunnels/{id}", Permission: webservice.PermissionTunnelsRead, Ownership: ActionOwnershipTunnel, Protected: true, Handler: app.NodeTunnel},
 		{Resource: "tunnels", Action: "update", Method: http.MethodPost, Path: "/api/tunnels/{id}/actions/update", Permission: webservice.PermissionTunnelsUpdate, Ownership: ActionOwnershipTunnel, Protected: true, Handler: app.NodeUpdateTunnel},
 		{Resource: "tunnels", Action: "start", Method: http.MethodPost, Path: "/api/tunnels/{id}/actions/start", Permission: webservice.PermissionTunnelsControl, Ownership: ActionOwnershipTunnel, Protected: true, Handler: app.NodeStartTunnel},
 		{Resource: "tunnels", Action: "stop", Method: http.MethodPost, Path: "/api/tunnels/{id}/actions/stop", Permission: webservice.PermissionTunnelsControl, Ownership: ActionOwnershipTunnel, Protected: true, Handler: app.NodeStopTunnel},
 		{Resource: "tunnels", Action: "clear", Method: http.MethodPost, Path: "/api/tunnels/{id}/actions/clear", Permission: webservice.PermissionTunnelsControl, Ownership: ActionOwnershipTunnel, Protected: true, Handler: app.NodeClearTunnel},
 		{Resource: "tunnels", Action: "delete", Method: http.MethodPost, Path: "/api/tunnels/{id}/actions/delete", Permission: webservice.PermissionTunnelsDelete, Ownership: ActionOwnershipTunnel, Protected: true, Handler: app.NodeDeleteTunnel},
+		{Resource: "tunnels", Action: "history", Method: http.MethodGet, Path: "/api/tunnels/{id}/history", Permission: webservice.PermissionManagementAdmin, Protected: true, Handler: app.NodeTunnelHistory},
+		{Resource: "tunnels", Action: "revert", Method: http.MethodPost, Path: "/api/tunnels/{id}/actions/revert", Permission: webservice.PermissionManagementAdmin, Protected: true, Handler: app.NodeRevertTunnel},
+		{Resource: "tunnels", Action: "quota", Method: http.MethodGet, Path: "/api/tunnels/{id}/quota", Permission: webservice.PermissionTunnelsRead, Ownership: ActionOwnershipTunnel, Protected: true, Handler: app.NodeTunnelQuota},
+		{Resource: "tunnels", Action: "set_quota", Method: http.MethodPost, Path: "/api/tunnels/{id}/actions/quota", Permission: webservice.PermissionManagementAdmin, Protected: true, Handler: app.NodeSetTunnelQuota},
 		{Resource: "hosts", Action: "read", Method: http.MethodGet, Path: "/api/hosts/{id}", Permission: webservice.PermissionHostsRead, Ownership: ActionOwnershipHost, Protected: true, Handler: app.NodeHost},
 		{Resource: "hosts", Action: "cert_suggestion", Method: http.MethodGet, Path: "/api/hosts/cert-suggestion", Permission: webservice.PermissionHostsRead, Protected: true, Handler: app.NodeHostCertSuggestion},
 		{Resource: "hosts", Action: "update", Method: http.MethodPost, Path: "/api/hosts/{id}/actions/update", Permission: webservice.PermissionHostsUpdate, Ownership: ActionOwnershipHost, Protected: true, Handler: app.NodeUpdateHost},
@@ -106,6 +118,18 @@ func ProtectedActionCatalog(app *App) []ActionSpec {
 		{Resource: "hosts", Action: "stop", Method: http.MethodPost, Path: "/api/hosts/{id}/actions/stop", Permission: webservice.PermissionHostsControl, Ownership: ActionOwnershipHost, Protected: true, Handler: app.NodeStopHost},
 		{Resource: "hosts", Action: "clear", Method: http.MethodPost, Path: "/api/hosts/{id}/actions/clear", Permission: webservice.PermissionHostsControl, Ownership: ActionOwnershipHost, Protected: true, Handler: app.NodeClearHost},
 		{Resource: "hosts", Action: "delete", Method: http.MethodPost, Path: "/api/hosts/{id}/actions/delete", Permission: webservice.PermissionHostsDelete, Ownership: ActionOwnershipHost, Protected: true, Handler: app.NodeDeleteHost},
+		{Resource: "hosts", Action: "history", Method: http.MethodGet, Path: "/api/hosts/{id}/history", Permission: webservice.PermissionManagementAdmin, Protected: true, Handler: app.NodeHostHistory},
+		{Resource: "hosts", Action: "revert", Method: http.MethodPost, Path: "/api/hosts/{id}/actions/revert", Permission: webservice.PermissionManagementAdmin, Protected: true, Handler: app.NodeRevertHost},
+		{Resource: "hosts", Action: "quota", Method: http.MethodGet, Path: "/api/hosts/{id}/quota", Permission: webservice.PermissionHostsRead, Ownership: ActionOwnershipHost, Protected: true, Handler: app.NodeHostQuota},
+		{Resource: "hosts", Action: "purge_cache", Method: http.MethodPost, Path: "/api/hosts/{id}/actions/purge-cache", Permission: webservice.PermissionHostsControl, Ownership: ActionOwnershipHost, Protected: true, Handler: app.NodePurgeHostCache},
+		{Resource: "hosts", Action: "set_quota", Method: http.MethodPost, Path: "/api/hosts/{id}/actions/quota", Permission: webservice.PermissionManagementAdmin, Protected: true, Handler: app.NodeSetHostQuota},
+		{Resource: "certificates", Action: "list", Method: http.MethodGet, Path: "/api/certificates", Permission: webservice.PermissionHostsRead, Protected: true, Handler: app.NodeCertificates},
+		{Resource: "certificates", Action: "renew", Method: http.MethodPost, Path: "/api/certificates/actions/renew", Permission: webservice.PermissionHostsControl, Protected: true, Handler: app.NodeRenewCertificate},
+		{Resource: "cert_store", Action: "list", Method: http.MethodGet, Path: "/api/cert-store", Permission: webservice.PermissionManagementAdmin, Protected: true, Handler: app.NodeCertStore},
+		{Resource: "cert_store", Action: "create", Method: http.MethodPost, Path: "/api/cert-store", Permission: webservice.PermissionManagementAdmin, Protected: true, Handler: app.NodeCreateStoredCert},
+		{Resource: "cert_store", Action: "read", Method: http.MethodGet, Path: "/api/cert-store/{id}", Permission: webservice.PermissionManagementAdmin, Protected: true, Handler: app.NodeStoredCert},
+		{Resource: "cert_store", Action: "update", Method: http.MethodPost, Path: "/api/cert-store/{id}/actions/update", Permission: webservice.PermissionManagementAdmin, Protected: true, Handler: app.NodeUpdateStoredCert},
+		{Resource: "cert_store", Action: "delete", Method: http.MethodPost, Path: "/api/cert-store/{id}/actions/delete", Permission: webservice.PermissionManagementAdmin, Protected: true, Handler: app.NodeDeleteStoredCert},
 		{Resource: "clients", Action: "list", Method: http.MethodGet, Path: "/api/clients", Permission: webservice.PermissionClientsRead, Protected: true, Handler: app.NodeClients},
 		{Resource: "clients", Action: "qrcode", Method: http.MethodGet, Path: "/api/tools/qrcode", Permission: webservice.PermissionClientsRead, Protected: true, Handler: app.NodeClientQRCode},
 		{Resource: "clients", Action: "qrcode_generate", Method: http.MethodPost, Path: "/api/tools/qrcode", Permission: webservice.PermissionClientsRead, Protected: true, Handler: app.NodeClientQRCode},
@@ -113,24 +137,36 @@ func ProtectedActionCatalog(app *App) []ActionSpec {
 		{Resource: "clients", Action: "connections", Method: http.MethodGet, Path: "/api/clients/{id}/connections", Permission: webservice.PermissionClientsRead, Ownership: ActionOwnershipClient, Protected: true, Handler: app.NodeClientConnections},
 		{Resource: "clients", Action: "clear_all", Method: http.MethodPost, Path: "/api/clients/actions/clear", Permission: webservice.PermissionClientsStatus, Protected: true, Handler: app.NodeClearClients},
 		{Resource: "clients", Action: "kick", Method: http.MethodPost, Path: "/api/clients/actions/kick", Permission: webservice.PermissionClientsStatus, Protected: true, Handler: app.NodeKick},
+		{Resource: "clients", Action: "bulk", Method: http.MethodPost, Path: "/api/clients/actions/bulk", Permission: webservice.PermissionManagementAdmin, Protected: true, Handler: app.NodeBulkClients},
 		{Resource: "clients", Action: "ping", Method: http.MethodPost, Path: "/api/clients/{id}/actions/ping", Permission: webservice.PermissionClientsRead, Ownership: ActionOwnershipClient, Protected: true, Handler: app.NodePingClient},
 		{Resource: "clients", Action: "read", Method: http.MethodGet, Path: "/api/clients/{id}", Permission: webservice.PermissionClientsRead, Ownership: ActionOwnershipClient, Protected: true, Handler: app.NodeClient},
 		{Resource: "clients", Action: "update", Method: http.MethodPost, Path: "/api/clients/{id}/actions/update", Permission: webservice.PermissionClientsUpdate, Ownership: ActionOwnershipClient, Protected: true, Handler: app.NodeUpdateClient},
 		{Resource: "clients", Action: "clear", Method: http.MethodPost, Path: "/api/clients/{id}/actions/clear", Permission: webservice.PermissionClientsStatus, Ownership: ActionOwnershipClient, Protected: true, Handler: app.NodeClearClient},
 		{Resource: "clients", Action: "status", Method: http.MethodPost, Path: "/api/clients/{id}/actions/status", Permission: webservice.PermissionClientsStatus, Ownership: ActionOwnershipClient, Protected: true, Handler: app.NodeSetClientStatus},
 		{Resource: "clients", Action: "delete", Method: http.MethodPost, Path: "/api/clients/{id}/actions/delete", Permission: webservice.PermissionClientsDelete, Ownership: ActionOwnershipClient, Protected: true, Handler: app.NodeDeleteClient},
+		{Resource: "clients", Action: "history", Method: http.MethodGet, Path: "/api/clients/{id}/history", Permission: webservice.PermissionManagementAdmin, Protected: true, Handler: app.NodeClientHistory},
+		{Resource: "clients", Action: "revert", Method: http.MethodPost, Path: "/api/clients/{id}/actions/revert", Permission: webservice.PermissionManagementAdmin, Protected: true, Handler: app.NodeRevertClient},
+		{Resource: "clients", Action: "quota", Method: http.MethodGet, Path: "/api/clients/{id}/quota", Permission: webservice.PermissionClientsRead, Ownership: ActionOwnershipClient, Protected: true, Handler: app.NodeClientQuota},
+		{Resource: "clients", Action: "set_quota", Method: http.MethodPost, Path: "/api/clients/{id}/actions/quota", Permission: webservice.PermissionManagementAdmin, Protected: true, Handler: app.NodeSetClientQuota},
 		{Resource: "users", Action: "list", Method: http.MethodGet, Path: "/api/users", Permission: webservice.PermissionManagementAdmin, Protected: true, Handler: app.NodeUsers},
 		{Resource: "users", Action: "read", Method: http.MethodGet, Path: "/api/users/{id}", Permission: webservice.PermissionManagementAdmin, Protected: true, Handler: app.NodeUser},
 		{Resource: "users", Action: "create", Method: http.MethodPost, Path: "/api/users", Permission: webservice.PermissionManagementAdmin, Protected: true, Handler: app.NodeCreateUser},
 		{Resource: "users", Action: "update", Method: http.MethodPost, Path: "/api/users/{id}/actions/update", Permission: webservice.PermissionManagementAdmin, Protected: true, Handler: app.NodeUpdateUser},
 		{Resource: "users", Action: "status", Method: http.MethodPost, Path: "/api/users/{id}/actions/status", Permission: webservice.PermissionManagementAdmin, Protected: true, Handler: app.NodeSetUserStatus},
 		{Resource: "users", Action: "delete", Method: http.MethodPost, Path: "/api/users/{id}/actions/delete", Permission: webservice.PermissionManagementAdmin, Protected: true, Handler: app.NodeDeleteUser},
+		{Resource: "users", Action: "history", Method: http.MethodGet, Path: "/api/users/{id}/history", Permission: webservice.PermissionManagementAdmin, Protected: true, Handler: app.NodeUserHistory},
+		{Resource: "users", Action: "revert", Method: http.MethodPost, Path: "/api/users/{id}/actions/revert", Permission: webservice.PermissionManagementAdmin, Protected: true, Handler: app.NodeRevertUser},
+		{Resource: "users", Action: "quota", Method: http.MethodGet, Path: "/api/users/{id}/quota", Permission: webservice.PermissionManagementAdmin, Protected: true, Handler: app.NodeUserQuota},
+		{Resource: "users", Action: "set_quota", Method: http.MethodPost, Path: "/api/users/{id}/actions/quota", Permission: webservice.PermissionManagementAdmin, Protected: true, Handler: app.NodeSetUserQuota},
 		{Resource: "settings_global", Action: "read", Method: http.MethodGet, Path: "/api/settings/global", Permission: webservice.PermissionGlobalManage, Protected: true, Handler: app.NodeGlobal},
 		{Resource: "settings_global", Action: "update", Method: http.MethodPost, Path: "/api/settings/global/actions/update", Permission: webservice.PermissionGlobalManage, Protected: true, Handler: app.NodeUpdateGlobal},
 		{Resource: "security_bans", Action: "list", Method: http.MethodGet, Path: "/api/security/bans", Permission: webservice.PermissionGlobalManage, Protected: true, Handler: app.NodeBanList},
 		{Resource: "security_bans", Action: "delete", Method: http.MethodPost, Path: "/api/security/bans/actions/delete", Permission: webservice.PermissionGlobalManage, Protected: true, Handler: app.NodeUnban},
 		{Resource: "security_bans", Action: "delete_all", Method: http.MethodPost, Path: "/api/security/bans/actions/delete_all", Permission: webservice.PermissionGlobalManage, Protected: true, Handler: app.NodeUnbanAll},
 		{Resource: "security_bans", Action: "clean", Method: http.MethodPost, Path: "/api/security/bans/actions/clean", Permission: webservice.PermissionGlobalManage, Protected: true, Handler: app.NodeBanClean},
+		{Resource: "trash", Action: "list", Method: http.MethodGet, Path: "/api/trash", Permission: webservice.PermissionManagementAdmin, Protected: true, Handler: app.NodeTrash},
+		{Resource: "trash", Action: "restore", Method: http.MethodPost, Path: "/api/trash/actions/restore", Permission: webservice.PermissionManagementAdmin, Protected: true, Handler: app.NodeRestoreTrash},
+		{Resource: "trash", Action: "purge", Method: http.MethodPost, Path: "/api/trash/actions/purge", Permission: webservice.PermissionManagementAdmin, Protected: true, Handler: app.NodePurgeTrash},
 		{Resource: "callbacks_queue", Action: "list", Method: http.MethodGet, Path: "/api/callbacks/queue", Protected: true, Visible: nodeActionVisibleCallbackQueue(func(scope webservice.NodeAccessScope) bool { return scope.CanViewCallbackQueue() })},
 		{Resource: "callbacks_queue", Action: "replay", Method: http.MethodPost, Path: "/api/callbacks/queue/actions/replay", Protected: true, Visible: nodeActionVisibleCallbackQueue(func(scope webservice.NodeAccessScope) bool { return scope.CanManageCallbackQueue() })},
 		{Resource: "callbacks_queue", Action: "clear", Method: http.MethodPost, Path: "/api/callbacks/queue/actions/clear", Protected: true, Visible: nodeActionVisibleCallbackQueue(func(scope webservice.NodeAccessScope) bool { return scope.CanManageCallbackQueue() })},
@@ -399,6 +435,7 @@ type NodeRouteCatalog struct {
 	Operations          string
 	Global              string
 	BanList             string
+	Trash               string
 	Users               string
 	Clients             string
 	ClientsConnections  string
@@ -407,8 +444,15 @@ type NodeRouteCatalog struct {
 	Tunnels             string
 	Hosts               string
 	HostCertSuggestion  string
+	Certificates        string
+	CertStore           string
 	Config              string
 	ConfigImport        string
+	ConfigRestore       string
+	ConfigApply         string
+	ConfigSnapshots     string
+	ConfigSnapshotNew   string
+	ConfigSnapshotLoad  string
 	Status              string
 	Changes             string
 	CallbackQueue       string
@@ -417,6 +461,7 @@ type NodeRouteCatalog struct {
 	Webhooks            string
 	RealtimeSubs        string
 	UsageSnapshot       string
+	UsageSeries         string
 	Batch               string
 	Traffic             string
 	Kick                string
@@ -458,6 +503,7 @@ func NodeRouteCatalogForPrefix(baseURL, prefix string) NodeRouteCatalog {
 		Operations:          joinBase(baseURL, prefix+"/system/operations"),
 		Global:              joinBase(baseURL, prefix+"/settings/global"),
 		BanList:             joinBase(baseURL, prefix+"/security/bans"),
+		Trash:               joinBase(baseURL, prefix+"/trash"),
 		Users:               joinBase(baseURL, prefix+"/users"),
 		Clients:             joinBase(baseURL, prefix+"/clients"),
 		ClientsConnections:  joinBase(baseURL, prefix+"/clients/:id/connections"),
@@ -466,8 +512,15 @@ func NodeRouteCatalogForPrefix(baseURL, prefix string) NodeRouteCatalog {
 		Tunnels:             joinBase(baseURL, prefix+"/tunnels"),
 		Hosts:               joinBase(baseURL, prefix+"/hosts"),
 		HostCertSuggestion:  joinBase(baseURL, prefix+"/hosts/cert-suggestion"),
+		Certificates:        joinBase(baseURL, prefix+"/certificates"),
+		CertStore:           joinBase(baseURL, prefix+"/cert-store"),
 		Config:              joinBase(baseURL, prefix+"/system/export"),
 		ConfigImport:        joinBase(baseURL, prefix+"/system/import"),
+		ConfigRestore:       joinBase(baseURL, prefix+"/system/restore"),
+		ConfigApply:         joinBase(baseURL, prefix+"/system/apply"),
+		ConfigSnapshots:     joinBase(baseURL, prefix+"/system/snapshots"),
+		ConfigSnapshotNew:   joinBase(baseURL, prefix+"/system/snapshots/actions/create"),
+		ConfigSnapshotLoad:  joinBase(baseURL, prefix+"/system/snapshots/actions/restore"),
 		Status:              joinBase(baseURL, prefix+"/system/status"),
 		Changes:             joinBase(baseURL, prefix+"/system/changes"),
 		CallbackQueue:       joinBase(baseURL, prefix+"/callbacks/queue"),
@@ -476,6 +529,7 @@ func NodeRouteCatalogForPrefix(baseURL, prefix string) NodeRouteCatalog {
 		Webhooks:            joinBase(baseURL, prefix+"/webhooks"),
 		RealtimeSubs:        joinBase(baseURL, prefix+"/realtime/subscriptions"),
 		UsageSnapshot:       joinBase(baseURL, prefix+"/system/usage-snapshot"),
+		UsageSeries:         joinBase(baseURL, prefix+"/usage/series"),
 		Batch:               joinBase(baseURL, prefix+"/batch"),
 		Traffic:             joinBase(baseURL, prefix+"/traffic"),
 		Kick:                joinBase(baseURL, prefix+"/clients/actions/kick"),
@@ -497,6 +551,9 @@ func (r NodeRouteCatalog) ApplyRuntimeConfig(dst map[string]interface{}) {
 	dst["operations"] = r.Operations
 	dst["settings_global"] = r.Global
 	dst["security_bans"] = r.BanList
+	dst["trash"] = r.Trash
+	dst["certificates"] = r.Certificates
+	dst["cert_store"] = r.CertStore
 	dst["users"] = r.Users
 	dst["clients"] = r.Clients
 	dst["clients_connections"] = r.ClientsConnections
@@ -507,6 +564,11 @@ func (r NodeRouteCatalog) ApplyRuntimeConfig(dst map[string]interface{}) {
 	dst["host_cert_suggestion"] = r.HostCertSuggestion
 	dst["system_export"] = r.Config
 	dst["system_import"] = r.ConfigImport
+	dst["system_restore"] = r.ConfigRestore
+	dst["system_apply"] = r.ConfigApply
+	dst["system_snapshots"] = r.ConfigSnapshots
+	dst["system_snapshots_create"] = r.ConfigSnapshotNew
+	dst["system_snapshots_restore"] = r.ConfigSnapshotLoad
 	dst["status"] = r.Status
 	dst["changes"] = r.Changes
 	dst["callbacks_queue"] = r.CallbackQueue
@@ -515,6 +577,7 @@ func (r NodeRouteCatalog) ApplyRuntimeConfig(dst map[string]interface{}) {
 	dst["webhooks"] = r.Webhooks
 	dst["realtime_subscriptions"] = r.RealtimeSubs
 	dst["usage_snapshot"] = r.UsageSnapshot
+	dst["usage_series"] = r.UsageSeries
 	dst["batch"] = r.Batch
 	dst["traffic"] = r.Traffic
 	dst["clients_kick"] = r.Kick
@@ -536,6 +599,9 @@ func (r NodeRouteCatalog) ApplyDiscoveryRoutes(dst *ManagementRoutes) {
 	dst.Operations = r.Operations
 	dst.SettingsGlobal = r.Global
 	dst.SecurityBans = r.BanList
+	dst.Trash = r.Trash
+	dst.Certificates = r.Certificates
+	dst.CertStore = r.CertStore
 	dst.Users = r.Users
 	dst.Clients = r.Clients
 	dst.ClientsConnections = r.ClientsConnections
@@ -546,6 +612,11 @@ func (r NodeRouteCatalog) ApplyDiscoveryRoutes(dst *ManagementRoutes) {
 	dst.HostCertSuggestion = r.HostCertSuggestion
 	dst.SystemExport = r.Config
 	dst.SystemImport = r.ConfigImport
+	dst.SystemRestore = r.ConfigRestore
+	dst.SystemApply = r.ConfigApply
+	dst.SystemSnapshots = r.ConfigSnapshots
+	dst.SystemSnapshotCreate = r.ConfigSnapshotNew
+	dst.SystemSnapshotRestore = r.ConfigSnapshotLoad
 	dst.Status = r.Status
 	dst.Changes = r.Changes
 	dst.CallbacksQueue = r.CallbackQueue
@@ -554,6 +625,7 @@ func (r NodeRouteCatalog) ApplyDiscoveryRoutes(dst *ManagementRoutes) {
 	dst.Webhooks = r.Webhooks
 	dst.RealtimeSubscriptions = r.RealtimeSubs
 	dst.UsageSnapshot = r.UsageSnapshot
+	dst.UsageSeries = r.UsageSeries
 	dst.Batch = r.Batch
 	dst.Traffic = r.Traffic
 	dst.ClientsKick = r.Kick
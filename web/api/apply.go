@@ -0,0 +1,76 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/djylb/nps/lib/file"
+	webservice "github.com/djylb/nps/web/service"
+)
+
+type nodeApplyConfigRequest struct {
+	DryRun bool            `json:"dry_run"`
+	State  json.RawMessage `json:"state"`
+}
+
+type nodeApplyConfigPayload struct {
+	DryRun    bool                     `json:"dry_run"`
+	Applied   int                      `json:"applied"`
+	Failed    int                      `json:"failed"`
+	Unchanged int                      `json:"unchanged"`
+	Changes   []webservice.ApplyChange `json:"changes"`
+}
+
+func (a *App) NodeApplyConfig(c Context) {
+	var body nodeApplyConfigRequest
+	if !decodeCanonicalJSONObject(c, &body) {
+		return
+	}
+	if len(body.State) == 0 || string(body.State) == "null" {
+		respondMissingRequestField(c, "state")
+		return
+	}
+	state, err := webservice.ParseDesiredState(body.State)
+	if err != nil {
+		respondManagementError(c, http.StatusBadRequest, err)
+		return
+	}
+	result, err := a.Services.Apply.Apply(webservice.ApplyInput{State: state, DryRun: body.DryRun})
+	if err != nil {
+		respondManagementError(c, nodeMutationErrorStatus(err), err)
+		return
+	}
+	payload := nodeApplyConfigPayload{
+		DryRun:    result.DryRun,
+		Applied:   result.Applied,
+		Failed:    result.Failed,
+		Unchanged: result.Unchanged,
+		Changes:   result.Changes,
+	}
+	if payload.Changes == nil {
+		payload.Changes = []webservice.ApplyChange{}
+	}
+	if !result.DryRun && len(result.Changes) > 0 {
+		for _, change := range result.Changes {
+			if change.Status == webservice.ApplyStatusApplied {
+				refs := make([]file.RevisionRef, 0, len(change.Revisions))
+				for _, revision := range change.Revisions {
+					refs = append(refs, file.RevisionRef{ID: change.ID, Revision: revision})
+				}
+				a.attributeRevisions(c, change.Resource, "apply_"+change.Action, refs...)
+			}
+		}
+		a.Emit(c, Event{
+			Name:     "config.applied",
+			Resource: "system",
+			Action:   "apply",
+			Fields: map[string]interface{}{
+				"applied":   result.Applied,
+				"failed":    result.Failed,
+				"unchanged": result.Unchanged,
+			},
+		})
+	}
+	respondManagementData(c, http.StatusOK, payload, managementResponseMeta(c, time.Now().Unix(), a.runtimeIdentity().ConfigEpoch()))
+}
@@ -132,6 +132,7 @@ type ManagementRoutes struct {
 	Operations            string `json:"operations,omitempty"`
 	SettingsGlobal        string `json:"settings_global,omitempty"`
 	SecurityBans          string `json:"security_bans,omitempty"`
+	Trash                 string `json:"trash,omitempty"`
 	Users                 string `json:"users,omitempty"`
 	Clients               string `json:"clients,omitempty"`
 	ClientsConnections    string `json:"clients_connections,omitempty"`
@@ -140,8 +141,15 @@ type ManagementRoutes struct {
 	Tunnels               string `json:"tunnels,omitempty"`
 	Hosts                 string `json:"hosts,omitempty"`
 	HostCertSuggestion    string `json:"host_cert_suggestion,omitempty"`
+	Certificates          string `json:"certificates,omitempty"`
+	CertStore             string `json:"cert_store,omitempty"`
 	SystemExport          string `json:"system_export,omitempty"`
 	SystemImport          string `json:"system_import,omitempty"`
+	SystemRestore         string `json:"system_restore,omitempty"`
+	SystemApply           string `json:"system_apply,omitempty"`
+	SystemSnapshots       string `json:"system_snapshots,omitempty"`
+	SystemSnapshotCreate  string `json:"system_snapshots_create,omitempty"`
+	SystemSnapshotRestore string `json:"system_snapshots_restore,omitempty"`
 	Status                string `json:"status,omitempty"`
 	Changes               string `json:"changes,omitempty"`
 	CallbacksQueue        string `json:"callbacks_queue,omitempty"`
@@ -150,6 +158,7 @@ type ManagementRoutes struct {
 	Webhooks              string `json:"webhooks,omitempty"`
 	RealtimeSubscriptions string `json:"realtime_subscriptions,omitempty"`
 	UsageSnapshot         string `json:"usage_snapshot,omitempty"`
+	UsageSeries           string `json:"usage_series,omitempty"`
 	Batch                 string `json:"batch,omitempty"`
 	Traffic               string `json:"traffic,omitempty"`
 	ClientsKick           string `json:"clients_kick,omitempty"`
@@ -394,6 +403,7 @@ func applyVisibleManagementDiscoveryRoutes(routes *ManagementRoutes, direct Node
 		{path: direct.Operations, clear: func(routes *ManagementRoutes) { routes.Operations = "" }},
 		{path: direct.Global, clear: func(routes *ManagementRoutes) { routes.SettingsGlobal = "" }},
 		{path: direct.BanList, clear: func(routes *ManagementRoutes) { routes.SecurityBans = "" }},
+		{path: direct.Trash, clear: func(routes *ManagementRoutes) { routes.Trash = "" }},
 		{path: direct.Users, clear: func(routes *ManagementRoutes) { routes.Users = "" }},
 		{path: direct.Clients, clear: func(routes *ManagementRoutes) { routes.Clients = "" }},
 		{path: direct.ClientsConnections, clear: func(routes *ManagementRoutes) { routes.ClientsConnections = "" }},
@@ -402,14 +412,22 @@ func applyVisibleManagementDiscoveryRoutes(routes *ManagementRoutes, direct Node
 		{path: direct.Tunnels, clear: func(routes *ManagementRoutes) { routes.Tunnels = "" }},
 		{path: direct.Hosts, clear: func(routes *ManagementRoutes) { routes.Hosts = "" }},
 		{path: direct.HostCertSuggestion, clear: func(routes *ManagementRoutes) { routes.HostCertSuggestion = "" }},
+		{path: direct.Certificates, clear: func(routes *ManagementRoutes) { routes.Certificates = "" }},
+		{path: direct.CertStore, clear: func(routes *ManagementRoutes) { routes.CertStore = "" }},
 		{path: direct.Config, clear: func(routes *ManagementRoutes) { routes.SystemExport = "" }},
 		{path: direct.ConfigImport, clear: func(routes *ManagementRoutes) { routes.SystemImport = "" }},
+		{path: direct.ConfigRestore, clear: func(routes *ManagementRoutes) { routes.SystemRestore = "" }},
+		{path: direct.ConfigApply, clear: func(routes *ManagementRoutes) { routes.SystemApply = "" }},
+		{path: direct.ConfigSnapshots, clear: func(routes *ManagementRoutes) { routes.SystemSnapshots = "" }},
+		{path: direct.ConfigSnapshotNew, clear: func(routes *ManagementRoutes) { routes.SystemSnapshotCreate = "" }},
+		{path: direct.ConfigSnapshotLoad, clear: func(routes *ManagementRoutes) { routes.SystemSnapshotRestore = "" }},
 		{path: direct.Status, clear: func(routes *ManagementRoutes) { routes.Status = "" }},
 		{path: direct.CallbackQueue, clear: func(routes *ManagementRoutes) { routes.CallbacksQueue = "" }},
 		{path: direct.CallbackQueueReplay, clear: func(routes *ManagementRoutes) { routes.CallbacksQueueReplay = "" }},
 		{path: direct.CallbackQueueClear, clear: func(routes *ManagementRoutes) { routes.CallbacksQueueClear = "" }},
 		{path: direct.Webhooks, clear: func(routes *ManagementRoutes) { routes.Webhooks = "" }},
 		{path: direct.UsageSnapshot, clear: func(routes *ManagementRoutes) { routes.UsageSnapshot = "" }},
+		{path: direct.UsageSeries, clear: func(routes *ManagementRoutes) { routes.UsageSeries = "" }},
 		{path: direct.Traffic, clear: func(routes *ManagementRoutes) { routes.Traffic = "" }},
 		{path: direct.Kick, clear: func(routes *ManagementRoutes) { routes.ClientsKick = "" }},
 		{path: direct.Sync, clear: func(routes *ManagementRoutes) { routes.SystemSync = "" }},
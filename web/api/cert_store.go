@@ -0,0 +1,122 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/djylb/nps/lib/file"
+	webservice "github.com/djylb/nps/web/service"
+)
+
+type nodeStoredCertRequest struct {
+	Name     string `json:"name"`
+	Remark   string `json:"remark"`
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+}
+
+type nodeStoredCertMutationPayload struct {
+	Action string                       `json:"action"`
+	Item   webservice.StoredCertPayload `json:"item"`
+}
+
+func (a *App) NodeCertStore(c Context) {
+	items, err := a.Services.CertStore.List()
+	if err != nil {
+		respondManagementError(c, nodeMutationErrorStatus(err), err)
+		return
+	}
+	offset, limit, returned, hasMore := nodeListPagination(0, 0, len(items), len(items))
+	respondNodeResourceData(c, nodeResourceListPayload{
+		ConfigEpoch: a.runtimeIdentity().ConfigEpoch(),
+		GeneratedAt: time.Now().Unix(),
+		Offset:      offset,
+		Limit:       limit,
+		Returned:    returned,
+		Total:       len(items),
+		HasMore:     hasMore,
+		Items:       items,
+	}, nil)
+}
+
+func (a *App) NodeStoredCert(c Context) {
+	item, err := a.Services.CertStore.Get(requestIntValue(c, "id"))
+	if err != nil {
+		respondManagementError(c, nodeMutationErrorStatus(err), err)
+		return
+	}
+	respondNodeResourceData(c, nodeResourceItemPayload{
+		ConfigEpoch: a.runtimeIdentity().ConfigEpoch(),
+		GeneratedAt: time.Now().Unix(),
+		Item:        item,
+	}, nil)
+}
+
+func (a *App) NodeCreateStoredCert(c Context) {
+	var body nodeStoredCertRequest
+	if !decodeCanonicalJSONObject(c, &body) {
+		return
+	}
+	item, err := a.Services.CertStore.Create(body.input(0))
+	a.respondStoredCertMutation(c, "cert_store.created", "create", item, err)
+}
+
+// NodeUpdateStoredCert replaces a stored certificate and the content of the
+// hosts that reference it.
+func (a *App) NodeUpdateStoredCert(c Context) {
+	var body nodeStoredCertRequest
+	if !decodeCanonicalJSONObject(c, &body) {
+		return
+	}
+	item, err := a.Services.CertStore.Update(body.input(requestIntValue(c, "id")))
+	if err == nil {
+		a.attributeRevisions(c, file.JournalResourceHost, "update", item.Revisions...)
+	}
+	a.respondStoredCertMutation(c, "cert_store.updated", "update", item, err)
+}
+
+func (a *App) NodeDeleteStoredCert(c Context) {
+	item, err := a.Services.CertStore.Delete(requestIntValue(c, "id"))
+	a.respondStoredCertMutation(c, "cert_store.deleted", "delete", item, err)
+}
+
+func (a *App) respondStoredCertMutation(c Context, eventName, action string, item webservice.StoredCertPayload, err error) {
+	if err != nil {
+		respondManagementError(c, nodeMutationErrorStatus(err), err)
+		return
+	}
+	a.Emit(c, Event{
+		Name:     eventName,
+		Resource: "cert_store",
+		Action:   action,
+		Fields:   nodeStoredCertEventFields(item),
+	})
+	respondManagementData(c, http.StatusOK, nodeStoredCertMutationPayload{
+		Action: action,
+		Item:   item,
+	}, managementResponseMeta(c, time.Now().Unix(), a.runtimeIdentity().ConfigEpoch()))
+}
+
+func (body nodeStoredCertRequest) input(id int) webservice.SaveStoredCertInput {
+	return webservice.SaveStoredCertInput{
+		ID:       id,
+		Name:     body.Name,
+		Remark:   body.Remark,
+		CertFile: body.CertFile,
+		KeyFile:  body.KeyFile,
+	}
+}
+
+func nodeStoredCertEventFields(item webservice.StoredCertPayload) map[string]interface{} {
+	hosts := make([]string, 0, len(item.Hosts))
+	for _, host := range item.Hosts {
+		hosts = append(hosts, host.Host)
+	}
+	return map[string]interface{}{
+		"id":          item.ID,
+		"name":        item.Name,
+		"fingerprint": item.Fingerprint,
+		"not_after":   item.NotAfter,
+		"hosts":       hosts,
+	}
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	webservice "github.com/djylb/nps/web/service"
+)
+
+type nodeRenewCertificateRequest struct {
+	ID string `json:"id"`
+}
+
+func (a *App) NodeCertificates(c Context) {
+	items, err := a.Services.Certificates.List(webservice.CertificateListInput{
+		Scope: a.nodeActorAccessFromContext(c).scope,
+	})
+	if err != nil {
+		respondManagementError(c, nodeMutationErrorStatus(err), err)
+		return
+	}
+	offset, limit, returned, hasMore := nodeListPagination(0, 0, len(items), len(items))
+	respondNodeResourceData(c, nodeResourceListPayload{
+		ConfigEpoch: a.runtimeIdentity().ConfigEpoch(),
+		GeneratedAt: time.Now().Unix(),
+		Offset:      offset,
+		Limit:       limit,
+		Returned:    returned,
+		Total:       len(items),
+		HasMore:     hasMore,
+		Items:       items,
+	}, nil)
+}
+
+func (a *App) NodeRenewCertificate(c Context) {
+	var body nodeRenewCertificateRequest
+	if !decodeCanonicalJSONObject(c, &body) {
+		return
+	}
+	payload, err := a.Services.Certificates.Renew(webservice.RenewCertificateInput{
+		Scope: a.nodeActorAccessFromContext(c).scope,
+		ID:    body.ID,
+	})
+	if errors.Is(err, webservice.ErrCertificateRenewFailed) {
+		a.emitNodeResourceMutationEvent(c, "certificate.renew_failed", "certificate", "renew", nodeCertificateEventFields(body.ID, payload, err))
+	}
+	if err != nil {
+		respondManagementError(c, nodeMutationErrorStatus(err), err)
+		return
+	}
+	a.emitNodeResourceMutationEvent(c, "certificate.renewed", "certificate", "renew", nodeCertificateEventFields(body.ID, payload, nil))
+	respondManagementData(c, http.StatusOK, payload, managementResponseMeta(c, time.Now().Unix(), a.runtimeIdentity().ConfigEpoch()))
+}
+
+func nodeCertificateEventFields(id string, payload webservice.CertificatePayload, err error) map[string]interface{} {
+	hosts := make([]string, 0, len(payload.Hosts))
+	for _, host := range payload.Hosts {
+		hosts = append(hosts, host.Host)
+	}
+	fields := map[string]interface{}{
+		"id":        id,
+		"source":    payload.Source,
+		"hosts":     hosts,
+		"not_after": payload.NotAfter,
+	}
+	if payload.ID != "" && payload.ID != id {
+		fields["renewed_id"] = payload.ID
+	}
+	if err != nil {
+		fields["error"] = err.Error()
+	}
+	return fields
+}
@@ -11,53 +11,54 @@ import (
 )
 
 type nodeClientResourcePayload struct {
-	ID                     int    `json:"id"`
-	OwnerUserID            int    `json:"owner_user_id"`
-	ManagerUserIDs         []int  `json:"manager_user_ids,omitempty"`
-	VerifyKey              string `json:"verify_key"`
-	Mode                   string `json:"mode,omitempty"`
-	Addr                   string `json:"addr,omitempty"`
-	LocalAddr              string `json:"local_addr,omitempty"`
-	Remark                 string `json:"remark"`
-	Status                 bool   `json:"status"`
-	IsConnect              bool   `json:"is_connect"`
-	NoStore                bool   `json:"no_store"`
-	ExpireAt               int64  `json:"expire_at"`
-	FlowLimitTotalBytes    int64  `json:"flow_limit_total_bytes"`
-	RateLimitTotalBps      int    `json:"rate_limit_total_bps"`
-	MaxConnections         int    `json:"max_connections"`
-	NowConn                int    `json:"now_conn"`
-	MaxTunnelNum           int    `json:"max_tunnel_num"`
-	ConfigConnAllow        bool   `json:"config_conn_allow"`
-	Version                string `json:"version,omitempty"`
-	SourceType             string `json:"source_type,omitempty"`
-	SourcePlatformID       string `json:"source_platform_id,omitempty"`
-	SourceActorID          string `json:"source_actor_id,omitempty"`
-	Revision               int64  `json:"revision"`
-	UpdatedAt              int64  `json:"updated_at"`
-	BridgeInBytes          int64  `json:"bridge_in_bytes"`
-	BridgeOutBytes         int64  `json:"bridge_out_bytes"`
-	BridgeTotalBytes       int64  `json:"bridge_total_bytes"`
-	ServiceInBytes         int64  `json:"service_in_bytes"`
-	ServiceOutBytes        int64  `json:"service_out_bytes"`
-	ServiceTotalBytes      int64  `json:"service_total_bytes"`
-	TotalInBytes           int64  `json:"total_in_bytes"`
-	TotalOutBytes          int64  `json:"total_out_bytes"`
-	TotalBytes             int64  `json:"total_bytes"`
-	BridgeNowRateInBps     int64  `json:"bridge_now_rate_in_bps"`
-	BridgeNowRateOutBps    int64  `json:"bridge_now_rate_out_bps"`
-	BridgeNowRateTotalBps  int64  `json:"bridge_now_rate_total_bps"`
-	ServiceNowRateInBps    int64  `json:"service_now_rate_in_bps"`
-	ServiceNowRateOutBps   int64  `json:"service_now_rate_out_bps"`
-	ServiceNowRateTotalBps int64  `json:"service_now_rate_total_bps"`
-	TotalNowRateInBps      int64  `json:"total_now_rate_in_bps"`
-	TotalNowRateOutBps     int64  `json:"total_now_rate_out_bps"`
-	TotalNowRateTotalBps   int64  `json:"total_now_rate_total_bps"`
-	EntryACLMode           int    `json:"entry_acl_mode"`
-	EntryACLRules          string `json:"entry_acl_rules,omitempty"`
-	CreateTime             string `json:"create_time,omitempty"`
-	LastOnlineTime         string `json:"last_online_time,omitempty"`
-	ConnectionCount        int    `json:"connection_count"`
+	ID                     int               `json:"id"`
+	OwnerUserID            int               `json:"owner_user_id"`
+	ManagerUserIDs         []int             `json:"manager_user_ids,omitempty"`
+	VerifyKey              string            `json:"verify_key"`
+	Mode                   string            `json:"mode,omitempty"`
+	Addr                   string            `json:"addr,omitempty"`
+	LocalAddr              string            `json:"local_addr,omitempty"`
+	Remark                 string            `json:"remark"`
+	Labels                 map[string]string `json:"labels,omitempty"`
+	Status                 bool              `json:"status"`
+	IsConnect              bool              `json:"is_connect"`
+	NoStore                bool              `json:"no_store"`
+	ExpireAt               int64             `json:"expire_at"`
+	FlowLimitTotalBytes    int64             `json:"flow_limit_total_bytes"`
+	RateLimitTotalBps      int               `json:"rate_limit_total_bps"`
+	MaxConnections         int               `json:"max_connections"`
+	NowConn                int               `json:"now_conn"`
+	MaxTunnelNum           int               `json:"max_tunnel_num"`
+	ConfigConnAllow        bool              `json:"config_conn_allow"`
+	Version                string            `json:"version,omitempty"`
+	SourceType             string            `json:"source_type,omitempty"`
+	SourcePlatformID       string            `json:"source_platform_id,omitempty"`
+	SourceActorID          string            `json:"source_actor_id,omitempty"`
+	Revision               int64             `json:"revision"`
+	UpdatedAt              int64             `json:"updated_at"`
+	BridgeInBytes          int64             `json:"bridge_in_bytes"`
+	BridgeOutBytes         int64             `json:"bridge_out_bytes"`
+	BridgeTotalBytes       int64             `json:"bridge_total_bytes"`
+	ServiceInBytes         int64             `json:"service_in_bytes"`
+	ServiceOutBytes        int64             `json:"service_out_bytes"`
+	ServiceTotalBytes      int64             `json:"service_total_bytes"`
+	TotalInBytes           int64             `json:"total_in_bytes"`
+	TotalOutBytes          int64             `json:"total_out_bytes"`
+	TotalBytes             int64             `json:"total_bytes"`
+	BridgeNowRateInBps     int64             `json:"bridge_now_rate_in_bps"`
+	BridgeNowRateOutBps    int64             `json:"bridge_now_rate_out_bps"`
+	BridgeNowRateTotalBps  int64             `json:"bridge_now_rate_total_bps"`
+	ServiceNowRateInBps    int64             `json:"service_now_rate_in_bps"`
+	ServiceNowRateOutBps   int64             `json:"service_now_rate_out_bps"`
+	ServiceNowRateTotalBps int64             `json:"service_now_rate_total_bps"`
+	TotalNowRateInBps      int64             `json:"total_now_rate_in_bps"`
+	TotalNowRateOutBps     int64             `json:"total_now_rate_out_bps"`
+	TotalNowRateTotalBps   int64             `json:"total_now_rate_total_bps"`
+	EntryACLMode           int               `json:"entry_acl_mode"`
+	EntryACLRules          string            `json:"entry_acl_rules,omitempty"`
+	CreateTime             string            `json:"create_time,omitempty"`
+	LastOnlineTime         string            `json:"last_online_time,omitempty"`
+	ConnectionCount        int               `json:"connection_count"`
 	Config                 struct {
 		User     string `json:"user,omitempty"`
 		Compress bool   `json:"compress"`
@@ -316,7 +317,12 @@ func (a *App) listNodeClients(c Context, access nodeActorAccess) ([]nodeClientRe
 	if !ok {
 		return nil, 0, nil
 	}
-	result := a.Services.Clients.List(nodeListQueryFromContext(c).clientsInput(visibility))
+	query := nodeListQueryFromContext(c)
+	selector, err := parseNodeLabelSelector(query.Selector)
+	if err != nil {
+		return nil, 0, err
+	}
+	result := a.Services.Clients.List(query.clientsInput(visibility, selector))
 	items := a.sanitizeNodeClients(access.actor, access.scope, result.Rows)
 	return items, adjustedNodeListTotal(result.Total, len(result.Rows), len(items)), nil
 }
@@ -355,6 +361,7 @@ func newNodeClientResource(client *file.Client) nodeClientResourcePayload {
 	payload.Addr = client.Addr
 	payload.LocalAddr = client.LocalAddr
 	payload.Remark = client.Remark
+	payload.Labels = file.CloneLabels(client.Labels)
 	payload.Status = client.Status
 	payload.IsConnect = client.IsConnect
 	payload.NoStore = client.NoStore
@@ -517,6 +524,7 @@ func (a *App) NodeCreateClient(c Context) {
 			ManagerUserIDs:  managerUserIDs,
 			VKey:            body.VerifyKey,
 			Remark:          body.Remark,
+			Labels:          nodeMutationLabelsValue(body.Labels),
 			User:            body.Username,
 			Password:        nodeMutationStringValue(body.Password),
 			Compress:        body.Compress,
@@ -578,6 +586,8 @@ func (a *App) NodeUpdateClient(c Context) {
 			ManagerUserIDs:          managerUserIDs,
 			VKey:                    body.VerifyKey,
 			Remark:                  body.Remark,
+			Labels:                  nodeMutationLabelsValue(body.Labels),
+			LabelsSpecified:         body.Labels != nil,
 			User:                    body.Username,
 			Password:                nodeMutationStringValue(body.Password),
 			PasswordProvided:        body.Password != nil,
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/djylb/nps/lib/file"
+	webservice "github.com/djylb/nps/web/service"
+)
+
+type nodeRevertRevisionRequest struct {
+	Revision         int64 `json:"revision"`
+	ExpectedRevision int64 `json:"expected_revision"`
+}
+
+type nodeRevertRevisionPayload struct {
+	Resource string `json:"resource"`
+	ID       int    `json:"id"`
+	From     int64  `json:"from_revision"`
+	Revision int64  `json:"revision"`
+}
+
+func (a *App) NodeUserHistory(c Context)   { a.nodeResourceHistory(c, file.JournalResourceUser) }
+func (a *App) NodeClientHistory(c Context) { a.nodeResourceHistory(c, file.JournalResourceClient) }
+func (a *App) NodeTunnelHistory(c Context) { a.nodeResourceHistory(c, file.JournalResourceTunnel) }
+func (a *App) NodeHostHistory(c Context)   { a.nodeResourceHistory(c, file.JournalResourceHost) }
+
+func (a *App) NodeRevertUser(c Context)   { a.nodeRevertResource(c, file.JournalResourceUser) }
+func (a *App) NodeRevertClient(c Context) { a.nodeRevertResource(c, file.JournalResourceClient) }
+func (a *App) NodeRevertTunnel(c Context) { a.nodeRevertResource(c, file.JournalResourceTunnel) }
+func (a *App) NodeRevertHost(c Context)   { a.nodeRevertResource(c, file.JournalResourceHost) }
+
+func (a *App) nodeResourceHistory(c Context, resource string) {
+	items, err := a.Services.History.List(resource, requestIntValue(c, "id"))
+	if err != nil {
+		respondManagementError(c, nodeMutationErrorStatus(err), err)
+		return
+	}
+	offset, limit, returned, hasMore := nodeListPagination(0, 0, len(items), len(items))
+	respondNodeResourceData(c, nodeResourceListPayload{
+		ConfigEpoch: a.runtimeIdentity().ConfigEpoch(),
+		GeneratedAt: time.Now().Unix(),
+		Offset:      offset,
+		Limit:       limit,
+		Returned:    returned,
+		Total:       len(items),
+		HasMore:     hasMore,
+		Items:       items,
+	}, nil)
+}
+
+func (a *App) nodeRevertResource(c Context, resource string) {
+	var body nodeRevertRevisionRequest
+	if !decodeCanonicalJSONObject(c, &body) {
+		return
+	}
+	if body.Revision <= 0 {
+		respondMissingRequestField(c, "revision")
+		return
+	}
+	result, err := a.Services.History.Revert(webservice.RevertRevisionInput{
+		Resource:         resource,
+		ID:               requestIntValue(c, "id"),
+		Revision:         body.Revision,
+		ExpectedRevision: body.ExpectedRevision,
+	})
+	if err != nil {
+		respondManagementError(c, nodeMutationErrorStatus(err), err)
+		return
+	}
+	a.emitNodeResourceMutationEvent(c, resource+".reverted", resource, "revert", map[string]interface{}{
+		"id":            result.ID,
+		"from_revision": result.From,
+		"revision":      result.Revision,
+	})
+	respondManagementData(c, http.StatusOK, nodeRevertRevisionPayload{
+		Resource: result.Resource,
+		ID:       result.ID,
+		From:     result.From,
+		Revision: result.Revision,
+	}, managementResponseMeta(c, time.Now().Unix(), a.runtimeIdentity().ConfigEpoch()))
+}
+
+// attributeRevisions stamps the actor and request metadata of c on the
+// revisions the current request wrote.
+func (a *App) attributeRevisions(c Context, resource, action string, refs ...file.RevisionRef) {
+	if a == nil || a.Services.History == nil || c == nil || len(refs) == 0 {
+		return
+	}
+	attribution := file.RevisionAttribution{Action: strings.TrimSpace(action)}
+	if actor := c.Actor(); actor != nil {
+		attribution.Actor = &file.RevisionActor{
+			Kind:      actor.Kind,
+			SubjectID: actor.SubjectID,
+			Username:  actor.Username,
+			IsAdmin:   actor.IsAdmin,
+		}
+	}
+	metadata := c.Metadata()
+	attribution.NodeID = metadata.NodeID
+	attribution.RequestID = metadata.RequestID
+	attribution.Source = metadata.Source
+	a.Services.History.Attribute(strings.TrimSpace(resource), refs, attribution)
+}
@@ -0,0 +1,34 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	webservice "github.com/djylb/nps/web/service"
+)
+
+type nodePurgeHostCacheRequest struct {
+	Path string `json:"path"`
+}
+
+func (a *App) NodePurgeHostCache(c Context) {
+	var body nodePurgeHostCacheRequest
+	if !decodeCanonicalJSONObject(c, &body) {
+		return
+	}
+	payload, err := a.Services.HostCache.Purge(webservice.PurgeHostCacheInput{
+		Scope:  a.nodeActorAccessFromContext(c).scope,
+		HostID: requestIntValue(c, "id"),
+		Path:   body.Path,
+	})
+	if err != nil {
+		respondManagementError(c, nodeMutationErrorStatus(err), err)
+		return
+	}
+	a.emitNodeResourceMutationEvent(c, "host.cache_purged", "host", "purge_cache", map[string]interface{}{
+		"id":     payload.HostID,
+		"path":   payload.Path,
+		"purged": payload.Purged,
+	})
+	respondManagementData(c, http.StatusOK, payload, managementResponseMeta(c, time.Now().Unix(), a.runtimeIdentity().ConfigEpoch()))
+}
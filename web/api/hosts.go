@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/djylb/nps/lib/common"
+	"github.com/djylb/nps/lib/edgecache"
 	"github.com/djylb/nps/lib/file"
 	webservice "github.com/djylb/nps/web/service"
 )
@@ -23,6 +24,7 @@ type nodeHostResourcePayload struct {
 	Location            string                     `json:"location,omitempty"`
 	PathRewrite         string                     `json:"path_rewrite,omitempty"`
 	Remark              string                     `json:"remark,omitempty"`
+	Labels              map[string]string          `json:"labels,omitempty"`
 	Scheme              string                     `json:"scheme,omitempty"`
 	RedirectURL         string                     `json:"redirect_url,omitempty"`
 	HttpsJustProxy      bool                       `json:"https_just_proxy"`
@@ -35,6 +37,33 @@ type nodeHostResourcePayload struct {
 	IsClose             bool                       `json:"is_close"`
 	AutoHttps           bool                       `json:"auto_https"`
 	AutoCORS            bool                       `json:"auto_cors"`
+	RespCompress        bool                       `json:"resp_compress"`
+	RespCompressTypes   string                     `json:"resp_compress_types,omitempty"`
+	RespCompressMin     int                        `json:"resp_compress_min,omitempty"`
+	Cache               bool                       `json:"cache"`
+	CacheTTL            int                        `json:"cache_ttl,omitempty"`
+	ReqLimit            int                        `json:"req_limit"`
+	ReqLimitWindow      int                        `json:"req_limit_window,omitempty"`
+	ReqLimitKey         string                     `json:"req_limit_key,omitempty"`
+	ReqLimitPaths       string                     `json:"req_limit_paths,omitempty"`
+	ForwardAuth         string                     `json:"forward_auth,omitempty"`
+	ForwardAuthHeaders  string                     `json:"forward_auth_headers,omitempty"`
+	TLSMinVersion       string                     `json:"tls_min_version,omitempty"`
+	TLSCipherProfile    string                     `json:"tls_cipher_profile,omitempty"`
+	TLSALPN             string                     `json:"tls_alpn,omitempty"`
+	HSTS                string                     `json:"hsts,omitempty"`
+	CustomDomain        bool                       `json:"custom_domain"`
+	CertID              int                        `json:"cert_id,omitempty"`
+	AutoSSLDNS          string                     `json:"auto_ssl_dns,omitempty"`
+	ReqBodyRewrite      string                     `json:"req_body_rewrite,omitempty"`
+	RespBodyRewrite     string                     `json:"resp_body_rewrite,omitempty"`
+	ClientCertCA        string                     `json:"client_cert_ca,omitempty"`
+	ClientCertMode      string                     `json:"client_cert_mode,omitempty"`
+	ClientCertSubjects  string                     `json:"client_cert_subjects,omitempty"`
+	AuthGate            bool                       `json:"auth_gate"`
+	AuthGateEmails      string                     `json:"auth_gate_emails,omitempty"`
+	AuthGateGroups      string                     `json:"auth_gate_groups,omitempty"`
+	WAFRules            string                     `json:"waf_rules,omitempty"`
 	CompatMode          bool                       `json:"compat_mode"`
 	EntryACLMode        int                        `json:"entry_acl_mode"`
 	EntryACLRules       string                     `json:"entry_acl_rules,omitempty"`
@@ -52,7 +81,12 @@ type nodeHostResourcePayload struct {
 	ServiceInBytes      int64                      `json:"service_in_bytes"`
 	ServiceOutBytes     int64                      `json:"service_out_bytes"`
 	ServiceTotalBytes   int64                      `json:"service_total_bytes"`
+	CacheHits           uint64                     `json:"cache_hits"`
+	CacheMisses         uint64                     `json:"cache_misses"`
+	ReqLimited          int64                      `json:"req_limited"`
 	ProxyProtocol       int                        `json:"proxy_protocol"`
+	Balance             string                     `json:"balance,omitempty"`
+	BalanceKey          string                     `json:"balance_key,omitempty"`
 	Auth                string                     `json:"auth,omitempty"`
 }
 
@@ -65,6 +99,8 @@ type nodeHostCertSuggestionPayload struct {
 	KeyFile        string `json:"key_file,omitempty"`
 	CanApplyToForm bool   `json:"can_apply_to_form"`
 	IsClose        bool   `json:"is_close"`
+	StoredCertID   int    `json:"stored_cert_id,omitempty"`
+	StoredCertName string `json:"stored_cert_name,omitempty"`
 }
 
 func (a *App) NodeHosts(c Context) {
@@ -134,6 +170,13 @@ func (a *App) NodeHostCertSuggestion(c Context) {
 				payload.KeyFile = match.KeyFile
 			}
 		}
+		// Only administrators can bind a host to a stored certificate.
+		if access.allows(a, webservice.PermissionManagementAdmin) && a.Services.CertStore != nil {
+			if stored, ok := a.Services.CertStore.Match(hostName); ok {
+				payload.StoredCertID = stored.ID
+				payload.StoredCertName = stored.Name
+			}
+		}
 	}
 	respondNodeResourceData(c, nodeResourceItemPayload{
 		ConfigEpoch: a.runtimeIdentity().ConfigEpoch(),
@@ -157,7 +200,7 @@ func (a *App) scopedNodeHostsWithAccess(access nodeActorAccess) ([]*file.Host, e
 	if !ok {
 		return nil, nil
 	}
-	rows, _ := a.Services.Index.ListHosts((nodeListQuery{}).hostsInput(visibility))
+	rows, _ := a.Services.Index.ListHosts((nodeListQuery{}).hostsInput(visibility, file.LabelSelector{}))
 	return rows, nil
 }
 
@@ -196,7 +239,12 @@ func (a *App) listNodeHosts(c Context, access nodeActorAccess) ([]nodeHostResour
 	if !ok {
 		return nil, 0, nil
 	}
-	rows, total := a.Services.Index.ListHosts(nodeListQueryFromContext(c).hostsInput(visibility))
+	query := nodeListQueryFromContext(c)
+	selector, err := parseNodeLabelSelector(query.Selector)
+	if err != nil {
+		return nil, 0, err
+	}
+	rows, total := a.Services.Index.ListHosts(query.hostsInput(visibility, selector))
 	items := a.sanitizeNodeHosts(access.actor, access.scope, rows)
 	return items, adjustedNodeListTotal(total, len(rows), len(items)), nil
 }
@@ -254,6 +302,7 @@ func (a *App) nodeHostResource(host *file.Host) nodeHostResourcePayload {
 	payload.Location = host.Location
 	payload.PathRewrite = host.PathRewrite
 	payload.Remark = host.Remark
+	payload.Labels = file.CloneLabels(host.Labels)
 	payload.Scheme = host.Scheme
 	payload.RedirectURL = host.RedirectURL
 	payload.HttpsJustProxy = host.HttpsJustProxy
@@ -266,6 +315,33 @@ func (a *App) nodeHostResource(host *file.Host) nodeHostResourcePayload {
 	payload.IsClose = host.IsClose
 	payload.AutoHttps = host.AutoHttps
 	payload.AutoCORS = host.AutoCORS
+	payload.RespCompress = host.RespCompress
+	payload.RespCompressTypes = host.RespCompressTypes
+	payload.RespCompressMin = host.RespCompressMin
+	payload.Cache = host.Cache
+	payload.CacheTTL = host.CacheTTL
+	payload.ReqLimit = host.ReqLimit
+	payload.ReqLimitWindow = host.ReqLimitWindow
+	payload.ReqLimitKey = host.ReqLimitKey
+	payload.ReqLimitPaths = host.ReqLimitPaths
+	payload.ForwardAuth = host.ForwardAuth
+	payload.ForwardAuthHeaders = host.ForwardAuthHeaders
+	payload.TLSMinVersion = host.TLSMinVersion
+	payload.TLSCipherProfile = host.TLSCipherProfile
+	payload.TLSALPN = host.TLSALPN
+	payload.HSTS = host.HSTS
+	payload.CustomDomain = host.CustomDomain
+	payload.CertID = host.CertID
+	payload.AutoSSLDNS = host.AutoSSLDNS
+	payload.ReqBodyRewrite = host.ReqBodyRewrite
+	payload.RespBodyRewrite = host.RespBodyRewrite
+	payload.ClientCertCA = host.ClientCertCA
+	payload.ClientCertMode = host.ClientCertMode
+	payload.ClientCertSubjects = host.ClientCertSubjects
+	payload.AuthGate = host.AuthGate
+	payload.AuthGateEmails = host.AuthGateEmails
+	payload.AuthGateGroups = host.AuthGateGroups
+	payload.WAFRules = host.WafRules
 	payload.CompatMode = host.CompatMode
 	payload.EntryACLMode = host.EntryAclMode
 	payload.EntryACLRules = host.EntryAclRules
@@ -278,6 +354,8 @@ func (a *App) nodeHostResource(host *file.Host) nodeHostResourcePayload {
 	if host.Target != nil {
 		payload.Target = host.Target.TargetStr
 		payload.ProxyProtocol = host.Target.ProxyProtocol
+		payload.Balance = host.Target.Balance
+		payload.BalanceKey = host.Target.BalanceKey
 		payload.LocalProxy = host.Target.LocalProxy
 	}
 	if host.UserAuth != nil {
@@ -285,6 +363,9 @@ func (a *App) nodeHostResource(host *file.Host) nodeHostResourcePayload {
 	}
 	payload.ServiceInBytes, payload.ServiceOutBytes, payload.ServiceTotalBytes = host.ServiceTrafficTotals()
 	payload.NowRateInBps, payload.NowRateOutBps, payload.NowRateTotalBps = host.ServiceRateTotals()
+	cacheStats := edgecache.Default().Stats(host.Id)
+	payload.CacheHits, payload.CacheMisses = cacheStats.Hits, cacheStats.Misses
+	payload.ReqLimited = host.RequestsLimited()
 	return payload
 }
 
@@ -326,35 +407,65 @@ func (a *App) NodeCreateHost(c Context) {
 		AllowUserLocal:  a.currentConfig().Feature.AllowUserLocal,
 	}, webservice.AddHostRequest{
 		HostWriteRequest: webservice.HostWriteRequest{
-			ClientID:       effectiveClientID,
-			Host:           body.Host,
-			Target:         body.Target,
-			ProxyProtocol:  body.ProxyProtocol,
-			LocalProxy:     body.LocalProxy,
-			Auth:           body.Auth,
-			Header:         body.Header,
-			RespHeader:     body.RespHeader,
-			HostChange:     body.HostChange,
-			Remark:         body.Remark,
-			Location:       body.Location,
-			PathRewrite:    body.PathRewrite,
-			RedirectURL:    body.RedirectURL,
-			FlowLimit:      body.FlowLimitTotalBytes,
-			TimeLimit:      body.ExpireAt,
-			RateLimit:      webservice.ManagementRateLimitFromBps(body.RateLimitTotalBps),
-			MaxConnections: body.MaxConnections,
-			EntryACLMode:   body.EntryACLMode,
-			EntryACLRules:  body.EntryACLRules,
-			Scheme:         body.Scheme,
-			HTTPSJustProxy: body.HTTPSJustProxy,
-			TLSOffload:     body.TLSOffload,
-			AutoSSL:        body.AutoSSL,
-			KeyFile:        body.KeyFile,
-			CertFile:       body.CertFile,
-			AutoHTTPS:      body.AutoHTTPS,
-			AutoCORS:       body.AutoCORS,
-			CompatMode:     body.CompatMode,
-			TargetIsHTTPS:  body.TargetIsHTTPS,
+			ClientID:           effectiveClientID,
+			Host:               body.Host,
+			Target:             body.Target,
+			ProxyProtocol:      body.ProxyProtocol,
+			Balance:            body.Balance,
+			BalanceKey:         body.BalanceKey,
+			LocalProxy:         body.LocalProxy,
+			Auth:               body.Auth,
+			Header:             body.Header,
+			RespHeader:         body.RespHeader,
+			HostChange:         body.HostChange,
+			Remark:             body.Remark,
+			Labels:             nodeMutationLabelsValue(body.Labels),
+			Location:           body.Location,
+			PathRewrite:        body.PathRewrite,
+			RedirectURL:        body.RedirectURL,
+			FlowLimit:          body.FlowLimitTotalBytes,
+			TimeLimit:          body.ExpireAt,
+			RateLimit:          webservice.ManagementRateLimitFromBps(body.RateLimitTotalBps),
+			MaxConnections:     body.MaxConnections,
+			EntryACLMode:       body.EntryACLMode,
+			EntryACLRules:      body.EntryACLRules,
+			Scheme:             body.Scheme,
+			HTTPSJustProxy:     body.HTTPSJustProxy,
+			TLSOffload:         body.TLSOffload,
+			AutoSSL:            body.AutoSSL,
+			KeyFile:            body.KeyFile,
+			CertFile:           body.CertFile,
+			AutoHTTPS:          body.AutoHTTPS,
+			AutoCORS:           body.AutoCORS,
+			RespCompress:       body.RespCompress,
+			RespCompressTypes:  body.RespCompressTypes,
+			RespCompressMin:    body.RespCompressMin,
+			Cache:              body.Cache,
+			CacheTTL:           body.CacheTTL,
+			ReqLimit:           body.ReqLimit,
+			ReqLimitWindow:     body.ReqLimitWindow,
+			ReqLimitKey:        body.ReqLimitKey,
+			ReqLimitPaths:      body.ReqLimitPaths,
+			ForwardAuth:        body.ForwardAuth,
+			ForwardAuthHeaders: body.ForwardAuthHeaders,
+			TLSMinVersion:      body.TLSMinVersion,
+			TLSCipherProfile:   body.TLSCipherProfile,
+			TLSALPN:            body.TLSALPN,
+			HSTS:               body.HSTS,
+			CustomDomain:       body.CustomDomain,
+			CertID:             body.CertID,
+			AutoSSLDNS:         body.AutoSSLDNS,
+			ReqBodyRewrite:     body.ReqBodyRewrite,
+			RespBodyRewrite:    body.RespBodyRewrite,
+			ClientCertCA:       body.ClientCertCA,
+			ClientCertMode:     body.ClientCertMode,
+			ClientCertSubjects: body.ClientCertSubjects,
+			AuthGate:           body.AuthGate,
+			AuthGateEmails:     body.AuthGateEmails,
+			AuthGateGroups:     body.AuthGateGroups,
+			WAFRules:           body.WAFRules,
+			CompatMode:         body.CompatMode,
+			TargetIsHTTPS:      body.TargetIsHTTPS,
 		},
 	}))
 	if err != nil {
@@ -403,35 +514,66 @@ func (a *App) NodeUpdateHost(c Context) {
 		ID:               id,
 		ExpectedRevision: body.ExpectedRevision,
 		HostWriteRequest: webservice.HostWriteRequest{
-			ClientID:       body.ClientID,
-			Host:           body.Host,
-			Target:         body.Target,
-			ProxyProtocol:  body.ProxyProtocol,
-			LocalProxy:     body.LocalProxy,
-			Auth:           body.Auth,
-			Header:         body.Header,
-			RespHeader:     body.RespHeader,
-			HostChange:     body.HostChange,
-			Remark:         body.Remark,
-			Location:       body.Location,
-			PathRewrite:    body.PathRewrite,
-			RedirectURL:    body.RedirectURL,
-			FlowLimit:      body.FlowLimitTotalBytes,
-			TimeLimit:      body.ExpireAt,
-			RateLimit:      webservice.ManagementRateLimitFromBps(body.RateLimitTotalBps),
-			MaxConnections: body.MaxConnections,
-			EntryACLMode:   body.EntryACLMode,
-			EntryACLRules:  body.EntryACLRules,
-			Scheme:         body.Scheme,
-			HTTPSJustProxy: body.HTTPSJustProxy,
-			TLSOffload:     body.TLSOffload,
-			AutoSSL:        body.AutoSSL,
-			KeyFile:        body.KeyFile,
-			CertFile:       body.CertFile,
-			AutoHTTPS:      body.AutoHTTPS,
-			AutoCORS:       body.AutoCORS,
-			CompatMode:     body.CompatMode,
-			TargetIsHTTPS:  body.TargetIsHTTPS,
+			ClientID:           body.ClientID,
+			Host:               body.Host,
+			Target:             body.Target,
+			ProxyProtocol:      body.ProxyProtocol,
+			Balance:            body.Balance,
+			BalanceKey:         body.BalanceKey,
+			LocalProxy:         body.LocalProxy,
+			Auth:               body.Auth,
+			Header:             body.Header,
+			RespHeader:         body.RespHeader,
+			HostChange:         body.HostChange,
+			Remark:             body.Remark,
+			Labels:             nodeMutationLabelsValue(body.Labels),
+			LabelsSpecified:    body.Labels != nil,
+			Location:           body.Location,
+			PathRewrite:        body.PathRewrite,
+			RedirectURL:        body.RedirectURL,
+			FlowLimit:          body.FlowLimitTotalBytes,
+			TimeLimit:          body.ExpireAt,
+			RateLimit:          webservice.ManagementRateLimitFromBps(body.RateLimitTotalBps),
+			MaxConnections:     body.MaxConnections,
+			EntryACLMode:       body.EntryACLMode,
+			EntryACLRules:      body.EntryACLRules,
+			Scheme:             body.Scheme,
+			HTTPSJustProxy:     body.HTTPSJustProxy,
+			TLSOffload:         body.TLSOffload,
+			AutoSSL:            body.AutoSSL,
+			KeyFile:            body.KeyFile,
+			CertFile:           body.CertFile,
+			AutoHTTPS:          body.AutoHTTPS,
+			AutoCORS:           body.AutoCORS,
+			RespCompress:       body.RespCompress,
+			RespCompressTypes:  body.RespCompressTypes,
+			RespCompressMin:    body.RespCompressMin,
+			Cache:              body.Cache,
+			CacheTTL:           body.CacheTTL,
+			ReqLimit:           body.ReqLimit,
+			ReqLimitWindow:     body.ReqLimitWindow,
+			ReqLimitKey:        body.ReqLimitKey,
+			ReqLimitPaths:      body.ReqLimitPaths,
+			ForwardAuth:        body.ForwardAuth,
+			ForwardAuthHeaders: body.ForwardAuthHeaders,
+			TLSMinVersion:      body.TLSMinVersion,
+			TLSCipherProfile:   body.TLSCipherProfile,
+			TLSALPN:            body.TLSALPN,
+			HSTS:               body.HSTS,
+			CustomDomain:       body.CustomDomain,
+			CertID:             body.CertID,
+			AutoSSLDNS:         body.AutoSSLDNS,
+			ReqBodyRewrite:     body.ReqBodyRewrite,
+			RespBodyRewrite:    body.RespBodyRewrite,
+			ClientCertCA:       body.ClientCertCA,
+			ClientCertMode:     body.ClientCertMode,
+			ClientCertSubjects: body.ClientCertSubjects,
+			AuthGate:           body.AuthGate,
+			AuthGateEmails:     body.AuthGateEmails,
+			AuthGateGroups:     body.AuthGateGroups,
+			WAFRules:           body.WAFRules,
+			CompatMode:         body.CompatMode,
+			TargetIsHTTPS:      body.TargetIsHTTPS,
 		},
 		ResetFlow:               body.ResetFlow,
 		SyncCertToMatchingHosts: body.SyncCertToMatchingHosts,
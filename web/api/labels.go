@@ -0,0 +1,104 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/djylb/nps/lib/file"
+	webservice "github.com/djylb/nps/web/service"
+)
+
+type nodeBulkActionRequest struct {
+	Selector     string            `json:"selector"`
+	Action       string            `json:"action"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	RemoveLabels []string          `json:"remove_labels,omitempty"`
+}
+
+type nodeBulkActionPayload struct {
+	Resource  string                         `json:"resource"`
+	Action    string                         `json:"action"`
+	Selector  string                         `json:"selector"`
+	Matched   []int                          `json:"matched"`
+	Succeeded []int                          `json:"succeeded"`
+	Failed    []webservice.BulkActionFailure `json:"failed"`
+}
+
+func (a *App) NodeBulkClients(c Context) {
+	a.nodeBulkAction(c, webservice.BulkResourceClients, "client")
+}
+
+func (a *App) NodeBulkTunnels(c Context) {
+	a.nodeBulkAction(c, webservice.BulkResourceTunnels, "tunnel")
+}
+
+func (a *App) NodeBulkHosts(c Context) {
+	a.nodeBulkAction(c, webservice.BulkResourceHosts, "host")
+}
+
+func (a *App) nodeBulkAction(c Context, resource, eventResource string) {
+	var body nodeBulkActionRequest
+	if !decodeCanonicalJSONObject(c, &body) {
+		return
+	}
+	if strings.TrimSpace(body.Selector) == "" {
+		respondMissingRequestField(c, "selector")
+		return
+	}
+	if strings.TrimSpace(body.Action) == "" {
+		respondMissingRequestField(c, "action")
+		return
+	}
+	selector, err := parseNodeLabelSelector(body.Selector)
+	if err != nil {
+		respondManagementError(c, http.StatusBadRequest, err)
+		return
+	}
+	result, err := a.Services.Labels.Bulk(webservice.BulkActionInput{
+		Resource:     resource,
+		Action:       body.Action,
+		Selector:     selector,
+		SetLabels:    body.Labels,
+		RemoveLabels: body.RemoveLabels,
+	})
+	if err != nil {
+		respondManagementError(c, nodeMutationErrorStatus(err), err)
+		return
+	}
+	payload := newNodeBulkActionPayload(result, selector)
+	a.attributeRevisions(c, eventResource, "bulk_"+result.Action, result.Revisions...)
+	a.Emit(c, Event{
+		Name:     eventResource + ".bulk_" + result.Action,
+		Resource: eventResource,
+		Action:   "bulk_" + result.Action,
+		Fields: map[string]interface{}{
+			"selector":  payload.Selector,
+			"matched":   len(payload.Matched),
+			"succeeded": payload.Succeeded,
+			"failed":    len(payload.Failed),
+		},
+	})
+	respondManagementData(c, http.StatusOK, payload, managementResponseMeta(c, time.Now().Unix(), a.runtimeIdentity().ConfigEpoch()))
+}
+
+func newNodeBulkActionPayload(result webservice.BulkActionResult, selector file.LabelSelector) nodeBulkActionPayload {
+	payload := nodeBulkActionPayload{
+		Resource:  result.Resource,
+		Action:    result.Action,
+		Selector:  selector.String(),
+		Matched:   result.Matched,
+		Succeeded: result.Succeeded,
+		Failed:    result.Failed,
+	}
+	if payload.Matched == nil {
+		payload.Matched = []int{}
+	}
+	if payload.Succeeded == nil {
+		payload.Succeeded = []int{}
+	}
+	if payload.Failed == nil {
+		payload.Failed = []webservice.BulkActionFailure{}
+	}
+	return payload
+}
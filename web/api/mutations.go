@@ -11,44 +11,47 @@ import (
 )
 
 type nodeUserWriteRequest struct {
-	Username            string  `json:"username"`
-	Password            *string `json:"password,omitempty"`
-	TOTPSecret          *string `json:"totp_secret,omitempty"`
-	ExpectedRevision    int64   `json:"expected_revision,omitempty"`
-	Status              *bool   `json:"status,omitempty"`
-	ExpireAt            string  `json:"expire_at"`
-	FlowLimitTotalBytes int64   `json:"flow_limit_total_bytes"`
-	MaxClients          int     `json:"max_clients"`
-	MaxTunnels          int     `json:"max_tunnels"`
-	MaxHosts            int     `json:"max_hosts"`
-	MaxConnections      int     `json:"max_connections"`
-	RateLimitTotalBps   int     `json:"rate_limit_total_bps"`
-	ResetFlow           bool    `json:"reset_flow"`
-	EntryACLMode        int     `json:"entry_acl_mode"`
-	EntryACLRules       string  `json:"entry_acl_rules"`
-	DestACLMode         int     `json:"dest_acl_mode"`
-	DestACLRules        string  `json:"dest_acl_rules"`
+	Username            string             `json:"username"`
+	Password            *string            `json:"password,omitempty"`
+	TOTPSecret          *string            `json:"totp_secret,omitempty"`
+	ExpectedRevision    int64              `json:"expected_revision,omitempty"`
+	Status              *bool              `json:"status,omitempty"`
+	ExpireAt            string             `json:"expire_at"`
+	FlowLimitTotalBytes int64              `json:"flow_limit_total_bytes"`
+	MaxClients          int                `json:"max_clients"`
+	MaxTunnels          int                `json:"max_tunnels"`
+	MaxHosts            int                `json:"max_hosts"`
+	MaxConnections      int                `json:"max_connections"`
+	RateLimitTotalBps   int                `json:"rate_limit_total_bps"`
+	ResetFlow           bool               `json:"reset_flow"`
+	EntryACLMode        int                `json:"entry_acl_mode"`
+	EntryACLRules       string             `json:"entry_acl_rules"`
+	DestACLMode         int                `json:"dest_acl_mode"`
+	DestACLRules        string             `json:"dest_acl_rules"`
+	WAFRules            string             `json:"waf_rules"`
+	Labels              *map[string]string `json:"labels,omitempty"`
 }
 
 type nodeClientWriteRequest struct {
-	OwnerUserID         *int    `json:"owner_user_id,omitempty"`
-	ManagerUserIDs      *[]int  `json:"manager_user_ids,omitempty"`
-	VerifyKey           string  `json:"verify_key"`
-	ExpectedRevision    int64   `json:"expected_revision,omitempty"`
-	Remark              string  `json:"remark"`
-	Username            string  `json:"username"`
-	Password            *string `json:"password,omitempty"`
-	Compress            bool    `json:"compress"`
-	Crypt               bool    `json:"crypt"`
-	ConfigConnAllow     bool    `json:"config_conn_allow"`
-	RateLimitTotalBps   int     `json:"rate_limit_total_bps"`
-	MaxConnections      int     `json:"max_connections"`
-	MaxTunnelNum        int     `json:"max_tunnel_num"`
-	FlowLimitTotalBytes int64   `json:"flow_limit_total_bytes"`
-	ExpireAt            string  `json:"expire_at"`
-	EntryACLMode        int     `json:"entry_acl_mode"`
-	EntryACLRules       string  `json:"entry_acl_rules"`
-	ResetFlow           bool    `json:"reset_flow"`
+	OwnerUserID         *int               `json:"owner_user_id,omitempty"`
+	ManagerUserIDs      *[]int             `json:"manager_user_ids,omitempty"`
+	VerifyKey           string             `json:"verify_key"`
+	ExpectedRevision    int64              `json:"expected_revision,omitempty"`
+	Remark              string             `json:"remark"`
+	Labels              *map[string]string `json:"labels,omitempty"`
+	Username            string             `json:"username"`
+	Password            *string            `json:"password,omitempty"`
+	Compress            bool               `json:"compress"`
+	Crypt               bool               `json:"crypt"`
+	ConfigConnAllow     bool               `json:"config_conn_allow"`
+	RateLimitTotalBps   int                `json:"rate_limit_total_bps"`
+	MaxConnections      int                `json:"max_connections"`
+	MaxTunnelNum        int                `json:"max_tunnel_num"`
+	FlowLimitTotalBytes int64              `json:"flow_limit_total_bytes"`
+	ExpireAt            string             `json:"expire_at"`
+	EntryACLMode        int                `json:"entry_acl_mode"`
+	EntryACLRules       string             `json:"entry_acl_rules"`
+	ResetFlow           bool               `json:"reset_flow"`
 }
 
 type nodeStatusActionRequest struct {
@@ -69,71 +72,106 @@ type nodeClearClientsRequest struct {
 }
 
 type nodeTunnelWriteRequest struct {
-	ClientID            int    `json:"client_id"`
-	ExpectedRevision    int64  `json:"expected_revision,omitempty"`
-	Port                int    `json:"port"`
-	ServerIP            string `json:"server_ip"`
-	Mode                string `json:"mode"`
-	TargetType          string `json:"target_type"`
-	Target              string `json:"target"`
-	ProxyProtocol       int    `json:"proxy_protocol"`
-	LocalProxy          bool   `json:"local_proxy"`
-	Auth                string `json:"auth"`
-	Remark              string `json:"remark"`
-	Password            string `json:"password"`
-	LocalPath           string `json:"local_path"`
-	StripPre            string `json:"strip_pre"`
-	EnableHTTP          bool   `json:"enable_http"`
-	EnableSocks5        bool   `json:"enable_socks5"`
-	EntryACLMode        int    `json:"entry_acl_mode"`
-	EntryACLRules       string `json:"entry_acl_rules"`
-	DestACLMode         int    `json:"dest_acl_mode"`
-	DestACLRules        string `json:"dest_acl_rules"`
-	FlowLimitTotalBytes int64  `json:"flow_limit_total_bytes"`
-	ExpireAt            string `json:"expire_at"`
-	RateLimitTotalBps   int    `json:"rate_limit_total_bps"`
-	MaxConnections      int    `json:"max_connections"`
-	ResetFlow           bool   `json:"reset_flow"`
+	ClientID            int                `json:"client_id"`
+	ExpectedRevision    int64              `json:"expected_revision,omitempty"`
+	Port                int                `json:"port"`
+	ServerIP            string             `json:"server_ip"`
+	Mode                string             `json:"mode"`
+	TargetType          string             `json:"target_type"`
+	Target              string             `json:"target"`
+	ProxyProtocol       int                `json:"proxy_protocol"`
+	Balance             string             `json:"balance"`
+	BalanceKey          string             `json:"balance_key"`
+	LocalProxy          bool               `json:"local_proxy"`
+	Auth                string             `json:"auth"`
+	Remark              string             `json:"remark"`
+	Labels              *map[string]string `json:"labels,omitempty"`
+	Password            string             `json:"password"`
+	LocalPath           string             `json:"local_path"`
+	StripPre            string             `json:"strip_pre"`
+	ForwardAuth         string             `json:"forward_auth"`
+	EnableHTTP          bool               `json:"enable_http"`
+	EnableSocks5        bool               `json:"enable_socks5"`
+	EntryACLMode        int                `json:"entry_acl_mode"`
+	EntryACLRules       string             `json:"entry_acl_rules"`
+	DestACLMode         int                `json:"dest_acl_mode"`
+	DestACLRules        string             `json:"dest_acl_rules"`
+	FlowLimitTotalBytes int64              `json:"flow_limit_total_bytes"`
+	ExpireAt            string             `json:"expire_at"`
+	RateLimitTotalBps   int                `json:"rate_limit_total_bps"`
+	MaxConnections      int                `json:"max_connections"`
+	ResetFlow           bool               `json:"reset_flow"`
 }
 
 type nodeHostWriteRequest struct {
-	ClientID                int    `json:"client_id"`
-	ExpectedRevision        int64  `json:"expected_revision,omitempty"`
-	Host                    string `json:"host"`
-	Target                  string `json:"target"`
-	ProxyProtocol           int    `json:"proxy_protocol"`
-	LocalProxy              bool   `json:"local_proxy"`
-	Auth                    string `json:"auth"`
-	Header                  string `json:"header"`
-	RespHeader              string `json:"resp_header"`
-	HostChange              string `json:"host_change"`
-	Remark                  string `json:"remark"`
-	Location                string `json:"location"`
-	PathRewrite             string `json:"path_rewrite"`
-	RedirectURL             string `json:"redirect_url"`
-	FlowLimitTotalBytes     int64  `json:"flow_limit_total_bytes"`
-	ExpireAt                string `json:"expire_at"`
-	RateLimitTotalBps       int    `json:"rate_limit_total_bps"`
-	MaxConnections          int    `json:"max_connections"`
-	ResetFlow               bool   `json:"reset_flow"`
-	EntryACLMode            int    `json:"entry_acl_mode"`
-	EntryACLRules           string `json:"entry_acl_rules"`
-	Scheme                  string `json:"scheme"`
-	HTTPSJustProxy          bool   `json:"https_just_proxy"`
-	TLSOffload              bool   `json:"tls_offload"`
-	AutoSSL                 bool   `json:"auto_ssl"`
-	KeyFile                 string `json:"key_file"`
-	CertFile                string `json:"cert_file"`
-	AutoHTTPS               bool   `json:"auto_https"`
-	AutoCORS                bool   `json:"auto_cors"`
-	CompatMode              bool   `json:"compat_mode"`
-	TargetIsHTTPS           bool   `json:"target_is_https"`
-	SyncCertToMatchingHosts bool   `json:"sync_cert_to_matching_hosts"`
+	ClientID                int                `json:"client_id"`
+	ExpectedRevision        int64              `json:"expected_revision,omitempty"`
+	Host                    string             `json:"host"`
+	Target                  string             `json:"target"`
+	ProxyProtocol           int                `json:"proxy_protocol"`
+	Balance                 string             `json:"balance"`
+	BalanceKey              string             `json:"balance_key"`
+	LocalProxy              bool               `json:"local_proxy"`
+	Auth                    string             `json:"auth"`
+	Header                  string             `json:"header"`
+	RespHeader              string             `json:"resp_header"`
+	HostChange              string             `json:"host_change"`
+	Remark                  string             `json:"remark"`
+	Labels                  *map[string]string `json:"labels,omitempty"`
+	Location                string             `json:"location"`
+	PathRewrite             string             `json:"path_rewrite"`
+	RedirectURL             string             `json:"redirect_url"`
+	FlowLimitTotalBytes     int64              `json:"flow_limit_total_bytes"`
+	ExpireAt                string             `json:"expire_at"`
+	RateLimitTotalBps       int                `json:"rate_limit_total_bps"`
+	MaxConnections          int                `json:"max_connections"`
+	ResetFlow               bool               `json:"reset_flow"`
+	EntryACLMode            int                `json:"entry_acl_mode"`
+	EntryACLRules           string             `json:"entry_acl_rules"`
+	Scheme                  string             `json:"scheme"`
+	HTTPSJustProxy          bool               `json:"https_just_proxy"`
+	TLSOffload              bool               `json:"tls_offload"`
+	AutoSSL                 bool               `json:"auto_ssl"`
+	KeyFile                 string             `json:"key_file"`
+	CertFile                string             `json:"cert_file"`
+	AutoHTTPS               bool               `json:"auto_https"`
+	AutoCORS                bool               `json:"auto_cors"`
+	RespCompress            bool               `json:"resp_compress"`
+	RespCompressTypes       string             `json:"resp_compress_types,omitempty"`
+	RespCompressMin         int                `json:"resp_compress_min,omitempty"`
+	Cache                   bool               `json:"cache"`
+	CacheTTL                int                `json:"cache_ttl,omitempty"`
+	ReqLimit                int                `json:"req_limit"`
+	ReqLimitWindow          int                `json:"req_limit_window,omitempty"`
+	ReqLimitKey             string             `json:"req_limit_key,omitempty"`
+	ReqLimitPaths           string             `json:"req_limit_paths,omitempty"`
+	ForwardAuth             string             `json:"forward_auth,omitempty"`
+	ForwardAuthHeaders      string             `json:"forward_auth_headers,omitempty"`
+	TLSMinVersion           string             `json:"tls_min_version,omitempty"`
+	TLSCipherProfile        string             `json:"tls_cipher_profile,omitempty"`
+	TLSALPN                 string             `json:"tls_alpn,omitempty"`
+	HSTS                    string             `json:"hsts,omitempty"`
+	CustomDomain            bool               `json:"custom_domain"`
+	CertID                  int                `json:"cert_id,omitempty"`
+	AutoSSLDNS              string             `json:"auto_ssl_dns,omitempty"`
+	ReqBodyRewrite          string             `json:"req_body_rewrite,omitempty"`
+	RespBodyRewrite         string             `json:"resp_body_rewrite,omitempty"`
+	ClientCertCA            string             `json:"client_cert_ca,omitempty"`
+	ClientCertMode          string             `json:"client_cert_mode,omitempty"`
+	ClientCertSubjects      string             `json:"client_cert_subjects,omitempty"`
+	AuthGate                bool               `json:"auth_gate"`
+	AuthGateEmails          string             `json:"auth_gate_emails,omitempty"`
+	AuthGateGroups          string             `json:"auth_gate_groups,omitempty"`
+	WAFRules                string             `json:"waf_rules,omitempty"`
+	CompatMode              bool               `json:"compat_mode"`
+	TargetIsHTTPS           bool               `json:"target_is_https"`
+	SyncCertToMatchingHosts bool               `json:"sync_cert_to_matching_hosts"`
 }
 
 type nodeGlobalUpdateRequest struct {
-	EntryACLMode  int    `json:"entry_acl_mode"`
-	EntryACLRules string `json:"entry_acl_rules"`
+	EntryACLMode  int     `json:"entry_acl_mode"`
+	EntryACLRules string  `json:"entry_acl_rules"`
+	WAFRules      *string `json:"waf_rules"`
 }
 
 type nodeKickRequest struct {
@@ -172,6 +210,13 @@ func nodeMutationStringValue(value *string) string {
 	return *value
 }
 
+func nodeMutationLabelsValue(value *map[string]string) map[string]string {
+	if value == nil {
+		return nil
+	}
+	return *value
+}
+
 func nodeResourceMutationFields(id int, resourceFields, overrides map[string]interface{}) map[string]interface{} {
 	fields := map[string]interface{}{"id": id}
 	mergeEventFieldMap(fields, resourceFields)
@@ -195,6 +240,10 @@ func (a *App) emitNodeResourceMutationEvent(c Context, eventName, resource, acti
 	if a == nil {
 		return
 	}
+	if id, ok := fields["id"].(int); ok {
+		revision, _ := fields["revision"].(int64)
+		a.attributeRevisions(c, resource, action, file.RevisionRef{ID: id, Revision: revision})
+	}
 	a.Emit(c, Event{
 		Name:     strings.TrimSpace(eventName),
 		Resource: strings.TrimSpace(resource),
@@ -241,9 +290,23 @@ func nodeMutationErrorStatus(err error) int {
 	case errors.Is(err, webservice.ErrUserNotFound),
 		errors.Is(err, webservice.ErrClientNotFound),
 		errors.Is(err, webservice.ErrTunnelNotFound),
-		errors.Is(err, webservice.ErrHostNotFound):
+		errors.Is(err, webservice.ErrHostNotFound),
+		errors.Is(err, webservice.ErrTrashEntryNotFound),
+		errors.Is(err, webservice.ErrRevisionNotFound),
+		errors.Is(err, webservice.ErrCertificateNotFound),
+		errors.Is(err, webservice.ErrStoredCertificateNotFound):
 		return http.StatusNotFound
+	case errors.Is(err, webservice.ErrTrashDisabled),
+		errors.Is(err, webservice.ErrHistoryDisabled),
+		errors.Is(err, webservice.ErrUsageSeriesDisabled),
+		errors.Is(err, webservice.ErrCertStoreDisabled):
+		return http.StatusNotImplemented
+	case errors.Is(err, webservice.ErrCertificateRenewUnavailable):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, webservice.ErrCertificateRenewFailed):
+		return http.StatusBadGateway
 	case errors.Is(err, webservice.ErrClientVKeyDuplicate),
+		errors.Is(err, webservice.ErrTrashRestoreTaken),
 		errors.Is(err, webservice.ErrClientLimitExceeded),
 		errors.Is(err, webservice.ErrClientRateLimitExceeded),
 		errors.Is(err, webservice.ErrClientConnLimitExceeded),
@@ -252,13 +315,30 @@ func nodeMutationErrorStatus(err error) int {
 		errors.Is(err, webservice.ErrPortUnavailable),
 		errors.Is(err, webservice.ErrTunnelLimitExceeded),
 		errors.Is(err, webservice.ErrHostLimitExceeded),
-		errors.Is(err, webservice.ErrClientResourceLimitExceeded):
+		errors.Is(err, webservice.ErrClientResourceLimitExceeded),
+		errors.Is(err, webservice.ErrStoredCertificateExists),
+		errors.Is(err, webservice.ErrStoredCertificateInUse):
 		return http.StatusConflict
 	case errors.Is(err, webservice.ErrReservedUsername),
 		errors.Is(err, webservice.ErrUserUsernameRequired),
 		errors.Is(err, webservice.ErrUserPasswordRequired),
 		errors.Is(err, webservice.ErrClientModifyFailed),
-		errors.Is(err, webservice.ErrModeRequired):
+		errors.Is(err, webservice.ErrModeRequired),
+		errors.Is(err, webservice.ErrInvalidLabels),
+		errors.Is(err, webservice.ErrLabelSelectorRequired),
+		errors.Is(err, webservice.ErrBulkActionUnsupported),
+		errors.Is(err, webservice.ErrDesiredStateInvalid),
+		errors.Is(err, webservice.ErrUsageQueryInvalid),
+		errors.Is(err, webservice.ErrQuotaPlanInvalid),
+		errors.Is(err, webservice.ErrInvalidHostCachePath),
+		errors.Is(err, webservice.ErrInvalidWAFRules),
+		errors.Is(err, webservice.ErrInvalidForwardAuth),
+		errors.Is(err, webservice.ErrInvalidClientCert),
+		errors.Is(err, webservice.ErrInvalidBodyRewrite),
+		errors.Is(err, webservice.ErrInvalidAutoSSLDNS),
+		errors.Is(err, webservice.ErrInvalidTLSPolicy),
+		errors.Is(err, webservice.ErrInvalidStoredCertificate),
+		errors.Is(err, webservice.ErrCertificateSANMismatch):
 		return http.StatusBadRequest
 	default:
 		return http.StatusInternalServerError
@@ -100,6 +100,7 @@ type nodeReadContext struct {
 type nodeGlobalResourcePayload struct {
 	EntryACLMode  int    `json:"entry_acl_mode"`
 	EntryACLRules string `json:"entry_acl_rules,omitempty"`
+	WAFRules      string `json:"waf_rules,omitempty"`
 }
 
 type nodeBanListMutationPayload struct {
@@ -355,6 +356,7 @@ func (a *App) NodeGlobal(c Context) {
 	if global != nil {
 		payload.EntryACLMode = global.EntryAclMode
 		payload.EntryACLRules = global.EntryAclRules
+		payload.WAFRules = global.WafRules
 	}
 	respondNodeResourceData(c, nodeResourceItemPayload{
 		ConfigEpoch: a.runtimeIdentity().ConfigEpoch(),
@@ -371,6 +373,7 @@ func (a *App) NodeUpdateGlobal(c Context) {
 	if err := a.Services.Globals.Save(webservice.SaveGlobalInput{
 		EntryACLMode:  body.EntryACLMode,
 		EntryACLRules: body.EntryACLRules,
+		WAFRules:      body.WAFRules,
 	}); err != nil {
 		respondNodeMutationData(c, nodeResourceMutationPayload{}, err)
 		return
@@ -380,6 +383,7 @@ func (a *App) NodeUpdateGlobal(c Context) {
 	if global != nil {
 		payload.EntryACLMode = global.EntryAclMode
 		payload.EntryACLRules = global.EntryAclRules
+		payload.WAFRules = global.WafRules
 	}
 	a.Emit(c, Event{
 		Name:     "global.updated",
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/djylb/nps/lib/file"
+	webservice "github.com/djylb/nps/web/service"
+)
+
+type nodeSetQuotaPlanRequest struct {
+	Period   string `json:"period"`
+	Day      int    `json:"day"`
+	Timezone string `json:"timezone"`
+	Rollover bool   `json:"rollover"`
+}
+
+func (a *App) NodeUserQuota(c Context)   { a.nodeResourceQuota(c, file.JournalResourceUser) }
+func (a *App) NodeClientQuota(c Context) { a.nodeResourceQuota(c, file.JournalResourceClient) }
+func (a *App) NodeTunnelQuota(c Context) { a.nodeResourceQuota(c, file.JournalResourceTunnel) }
+func (a *App) NodeHostQuota(c Context)   { a.nodeResourceQuota(c, file.JournalResourceHost) }
+
+func (a *App) NodeSetUserQuota(c Context)   { a.nodeSetResourceQuota(c, file.JournalResourceUser) }
+func (a *App) NodeSetClientQuota(c Context) { a.nodeSetResourceQuota(c, file.JournalResourceClient) }
+func (a *App) NodeSetTunnelQuota(c Context) { a.nodeSetResourceQuota(c, file.JournalResourceTunnel) }
+func (a *App) NodeSetHostQuota(c Context)   { a.nodeSetResourceQuota(c, file.JournalResourceHost) }
+
+func (a *App) nodeResourceQuota(c Context, resource string) {
+	payload, err := a.Services.QuotaPlans.Get(webservice.QuotaPlanInput{
+		Scope:    a.nodeActorAccessFromContext(c).scope,
+		Resource: resource,
+		ID:       requestIntValue(c, "id"),
+	})
+	if err != nil {
+		respondManagementError(c, nodeMutationErrorStatus(err), err)
+		return
+	}
+	respondManagementData(c, http.StatusOK, payload, managementResponseMeta(c, time.Now().Unix(), a.runtimeIdentity().ConfigEpoch()))
+}
+
+func (a *App) nodeSetResourceQuota(c Context, resource string) {
+	var body nodeSetQuotaPlanRequest
+	if !decodeCanonicalJSONObject(c, &body) {
+		return
+	}
+	payload, err := a.Services.QuotaPlans.Set(webservice.SetQuotaPlanInput{
+		Scope:    a.nodeActorAccessFromContext(c).scope,
+		Resource: resource,
+		ID:       requestIntValue(c, "id"),
+		Period:   body.Period,
+		Day:      body.Day,
+		Timezone: body.Timezone,
+		Rollover: body.Rollover,
+	})
+	if err != nil {
+		respondManagementError(c, nodeMutationErrorStatus(err), err)
+		return
+	}
+	a.emitNodeResourceMutationEvent(c, resource+".quota_updated", resource, "quota", map[string]interface{}{
+		"id":         payload.ID,
+		"revision":   payload.Revision,
+		"period":     payload.Period,
+		"day":        payload.Day,
+		"timezone":   payload.Timezone,
+		"rollover":   payload.Rollover,
+		"next_reset": payload.NextReset,
+	})
+	respondManagementData(c, http.StatusOK, payload, managementResponseMeta(c, time.Now().Unix(), a.runtimeIdentity().ConfigEpoch()))
+}
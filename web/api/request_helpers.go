@@ -320,6 +320,62 @@ func managementErrorCode(err error) string {
 		return "client_modify_failed"
 	case errors.Is(err, webservice.ErrModeRequired):
 		return "mode_required"
+	case errors.Is(err, webservice.ErrTrashDisabled):
+		return "trash_disabled"
+	case errors.Is(err, webservice.ErrTrashEntryNotFound):
+		return "trash_entry_not_found"
+	case errors.Is(err, webservice.ErrTrashRestoreTaken):
+		return "trash_restore_conflict"
+	case errors.Is(err, webservice.ErrHistoryDisabled):
+		return "history_disabled"
+	case errors.Is(err, webservice.ErrRevisionNotFound):
+		return "revision_not_found"
+	case errors.Is(err, webservice.ErrUsageSeriesDisabled):
+		return "usage_series_disabled"
+	case errors.Is(err, webservice.ErrUsageQueryInvalid):
+		return "invalid_usage_query"
+	case errors.Is(err, webservice.ErrQuotaPlanInvalid):
+		return "invalid_quota_plan"
+	case errors.Is(err, webservice.ErrInvalidHostCachePath):
+		return "invalid_cache_path"
+	case errors.Is(err, webservice.ErrInvalidWAFRules):
+		return "invalid_waf_rules"
+	case errors.Is(err, webservice.ErrInvalidForwardAuth):
+		return "invalid_forward_auth"
+	case errors.Is(err, webservice.ErrInvalidClientCert):
+		return "invalid_client_cert"
+	case errors.Is(err, webservice.ErrInvalidBodyRewrite):
+		return "invalid_body_rewrite"
+	case errors.Is(err, webservice.ErrInvalidAutoSSLDNS):
+		return "invalid_auto_ssl_dns"
+	case errors.Is(err, webservice.ErrInvalidTLSPolicy):
+		return "invalid_tls_policy"
+	case errors.Is(err, webservice.ErrCertificateNotFound):
+		return "certificate_not_found"
+	case errors.Is(err, webservice.ErrCertificateRenewUnavailable):
+		return "certificate_renew_unavailable"
+	case errors.Is(err, webservice.ErrCertificateRenewFailed):
+		return "certificate_renew_failed"
+	case errors.Is(err, webservice.ErrCertStoreDisabled):
+		return "cert_store_disabled"
+	case errors.Is(err, webservice.ErrStoredCertificateNotFound):
+		return "stored_certificate_not_found"
+	case errors.Is(err, webservice.ErrStoredCertificateExists):
+		return "stored_certificate_exists"
+	case errors.Is(err, webservice.ErrStoredCertificateInUse):
+		return "stored_certificate_in_use"
+	case errors.Is(err, webservice.ErrInvalidStoredCertificate):
+		return "invalid_stored_certificate"
+	case errors.Is(err, webservice.ErrCertificateSANMismatch):
+		return "certificate_san_mismatch"
+	case errors.Is(err, webservice.ErrInvalidLabels):
+		return "invalid_labels"
+	case errors.Is(err, webservice.ErrLabelSelectorRequired):
+		return "selector_required"
+	case errors.Is(err, webservice.ErrBulkActionUnsupported):
+		return "unsupported_bulk_action"
+	case errors.Is(err, webservice.ErrDesiredStateInvalid):
+		return "invalid_desired_state"
 	default:
 		return "request_failed"
 	}
@@ -0,0 +1,166 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/djylb/nps/lib/file"
+	webservice "github.com/djylb/nps/web/service"
+)
+
+type nodeTrashActionRequest struct {
+	ID int `json:"id"`
+}
+
+type nodeTrashItemPayload struct {
+	ID         int    `json:"id"`
+	Resource   string `json:"resource"`
+	ResourceID int    `json:"resource_id"`
+	ClientID   int    `json:"client_id,omitempty"`
+	Remark     string `json:"remark,omitempty"`
+	DeletedAt  int64  `json:"deleted_at"`
+	ExpiresAt  int64  `json:"expires_at"`
+	TunnelIDs  []int  `json:"tunnel_ids,omitempty"`
+	HostIDs    []int  `json:"host_ids,omitempty"`
+}
+
+type nodeTrashMutationPayload struct {
+	Action    string                        `json:"action"`
+	Item      nodeTrashItemPayload          `json:"item"`
+	ClientID  int                           `json:"client_id,omitempty"`
+	TunnelIDs []int                         `json:"tunnel_ids,omitempty"`
+	HostIDs   []int                         `json:"host_ids,omitempty"`
+	Skipped   []webservice.TrashRestoreSkip `json:"skipped,omitempty"`
+}
+
+func (a *App) NodeTrash(c Context) {
+	entries, err := a.Services.Trash.List()
+	if err != nil {
+		respondManagementError(c, nodeMutationErrorStatus(err), err)
+		return
+	}
+	items := make([]nodeTrashItemPayload, 0, len(entries))
+	for _, entry := range entries {
+		items = append(items, nodeTrashItem(entry))
+	}
+	offset, limit, returned, hasMore := nodeListPagination(0, 0, len(items), len(items))
+	respondNodeResourceData(c, nodeResourceListPayload{
+		ConfigEpoch: a.runtimeIdentity().ConfigEpoch(),
+		GeneratedAt: time.Now().Unix(),
+		Offset:      offset,
+		Limit:       limit,
+		Returned:    returned,
+		Total:       len(items),
+		HasMore:     hasMore,
+		Items:       items,
+	}, nil)
+}
+
+func (a *App) NodeRestoreTrash(c Context) {
+	id, ok := decodeNodeTrashActionID(c)
+	if !ok {
+		return
+	}
+	result, err := a.Services.Trash.Restore(id)
+	if err != nil {
+		respondManagementError(c, nodeMutationErrorStatus(err), err)
+		return
+	}
+	item := nodeTrashItem(result.Entry)
+	fields := nodeTrashEventFields(item)
+	fields["tunnel_ids"] = result.TunnelIDs
+	fields["host_ids"] = result.HostIDs
+	if len(result.Skipped) > 0 {
+		fields["skipped"] = len(result.Skipped)
+	}
+	for resource, refs := range result.Revisions {
+		a.attributeRevisions(c, resource, "restore", refs...)
+	}
+	a.Emit(c, Event{
+		Name:     "trash.restored",
+		Resource: "trash",
+		Action:   "restore",
+		Fields:   fields,
+	})
+	respondManagementData(c, http.StatusOK, nodeTrashMutationPayload{
+		Action:    "restore",
+		Item:      item,
+		ClientID:  result.ClientID,
+		TunnelIDs: result.TunnelIDs,
+		HostIDs:   result.HostIDs,
+		Skipped:   result.Skipped,
+	}, managementResponseMeta(c, time.Now().Unix(), a.runtimeIdentity().ConfigEpoch()))
+}
+
+func (a *App) NodePurgeTrash(c Context) {
+	id, ok := decodeNodeTrashActionID(c)
+	if !ok {
+		return
+	}
+	entry, err := a.Services.Trash.Purge(id)
+	if err != nil {
+		respondManagementError(c, nodeMutationErrorStatus(err), err)
+		return
+	}
+	item := nodeTrashItem(entry)
+	a.Emit(c, Event{
+		Name:     "trash.purged",
+		Resource: "trash",
+		Action:   "purge",
+		Fields:   nodeTrashEventFields(item),
+	})
+	respondManagementData(c, http.StatusOK, nodeTrashMutationPayload{
+		Action: "purge",
+		Item:   item,
+	}, managementResponseMeta(c, time.Now().Unix(), a.runtimeIdentity().ConfigEpoch()))
+}
+
+func decodeNodeTrashActionID(c Context) (int, bool) {
+	var body nodeTrashActionRequest
+	if !decodeCanonicalJSONObject(c, &body) {
+		return 0, false
+	}
+	if body.ID <= 0 {
+		respondMissingRequestField(c, "id")
+		return 0, false
+	}
+	return body.ID, true
+}
+
+// nodeTrashItem summarizes an entry without the stored records, which carry
+// verify keys and passwords that the list should not echo back.
+func nodeTrashItem(entry file.TrashEntry) nodeTrashItemPayload {
+	item := nodeTrashItemPayload{
+		ID:         entry.ID,
+		Resource:   entry.Resource,
+		ResourceID: entry.ResourceID,
+		Remark:     entry.Remark,
+		DeletedAt:  entry.DeletedAt,
+		ExpiresAt:  entry.ExpiresAt,
+	}
+	if entry.Client != nil {
+		item.ClientID = entry.Client.Id
+	}
+	for _, tunnel := range entry.Tunnels {
+		item.TunnelIDs = append(item.TunnelIDs, tunnel.Id)
+		if item.ClientID == 0 && tunnel.Client != nil {
+			item.ClientID = tunnel.Client.Id
+		}
+	}
+	for _, host := range entry.Hosts {
+		item.HostIDs = append(item.HostIDs, host.Id)
+		if item.ClientID == 0 && host.Client != nil {
+			item.ClientID = host.Client.Id
+		}
+	}
+	return item
+}
+
+func nodeTrashEventFields(item nodeTrashItemPayload) map[string]interface{} {
+	return map[string]interface{}{
+		"id":          item.ID,
+		"resource":    item.Resource,
+		"resource_id": item.ResourceID,
+		"client_id":   item.ClientID,
+	}
+}
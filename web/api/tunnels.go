@@ -21,12 +21,14 @@ type nodeTunnelResourcePayload struct {
 	Status              bool                       `json:"status"`
 	RunStatus           bool                       `json:"run_status"`
 	Remark              string                     `json:"remark,omitempty"`
+	Labels              map[string]string          `json:"labels,omitempty"`
 	TargetType          string                     `json:"target_type,omitempty"`
 	Target              string                     `json:"target,omitempty"`
 	LocalProxy          bool                       `json:"local_proxy"`
 	Password            string                     `json:"password,omitempty"`
 	LocalPath           string                     `json:"local_path,omitempty"`
 	StripPre            string                     `json:"strip_pre,omitempty"`
+	ForwardAuth         string                     `json:"forward_auth,omitempty"`
 	ExpireAt            int64                      `json:"expire_at"`
 	FlowLimitTotalBytes int64                      `json:"flow_limit_total_bytes"`
 	RateLimitTotalBps   int                        `json:"rate_limit_total_bps"`
@@ -47,6 +49,8 @@ type nodeTunnelResourcePayload struct {
 	DestACLMode         int                        `json:"dest_acl_mode"`
 	DestACLRules        string                     `json:"dest_acl_rules,omitempty"`
 	ProxyProtocol       int                        `json:"proxy_protocol"`
+	Balance             string                     `json:"balance,omitempty"`
+	BalanceKey          string                     `json:"balance_key,omitempty"`
 	Auth                string                     `json:"auth,omitempty"`
 }
 
@@ -100,7 +104,12 @@ func (a *App) listNodeTunnels(c Context, access nodeActorAccess) ([]nodeTunnelRe
 	if !ok {
 		return nil, 0, nil
 	}
-	rows, total := a.Services.Index.ListTunnels(nodeListQueryFromContext(c).tunnelsInput(visibility))
+	query := nodeListQueryFromContext(c)
+	selector, err := parseNodeLabelSelector(query.Selector)
+	if err != nil {
+		return nil, 0, err
+	}
+	rows, total := a.Services.Index.ListTunnels(query.tunnelsInput(visibility, selector))
 	items := a.sanitizeNodeTunnels(access.actor, access.scope, rows)
 	return items, adjustedNodeListTotal(total, len(rows), len(items)), nil
 }
@@ -138,10 +147,12 @@ func (a *App) nodeTunnelResource(tunnel *file.Tunnel) nodeTunnelResourcePayload
 	payload.Status = tunnel.Status
 	payload.RunStatus = tunnel.RunStatus
 	payload.Remark = tunnel.Remark
+	payload.Labels = file.CloneLabels(tunnel.Labels)
 	payload.TargetType = tunnel.TargetType
 	payload.Password = tunnel.Password
 	payload.LocalPath = tunnel.LocalPath
 	payload.StripPre = tunnel.StripPre
+	payload.ForwardAuth = tunnel.ForwardAuth
 	payload.ExpireAt = tunnel.EffectiveExpireAt()
 	payload.FlowLimitTotalBytes = tunnel.EffectiveFlowLimitBytes()
 	payload.RateLimitTotalBps = webservice.ManagementRateLimitToBps(tunnel.RateLimit)
@@ -158,6 +169,8 @@ func (a *App) nodeTunnelResource(tunnel *file.Tunnel) nodeTunnelResourcePayload
 	if tunnel.Target != nil {
 		payload.Target = tunnel.Target.TargetStr
 		payload.ProxyProtocol = tunnel.Target.ProxyProtocol
+		payload.Balance = tunnel.Target.Balance
+		payload.BalanceKey = tunnel.Target.BalanceKey
 		payload.LocalProxy = tunnel.Target.LocalProxy
 	}
 	if tunnel.UserAuth != nil {
@@ -231,12 +244,16 @@ func (a *App) NodeCreateTunnel(c Context) {
 			TargetType:     body.TargetType,
 			Target:         body.Target,
 			ProxyProtocol:  body.ProxyProtocol,
+			Balance:        body.Balance,
+			BalanceKey:     body.BalanceKey,
 			LocalProxy:     body.LocalProxy,
 			Auth:           body.Auth,
 			Remark:         body.Remark,
+			Labels:         nodeMutationLabelsValue(body.Labels),
 			Password:       body.Password,
 			LocalPath:      body.LocalPath,
 			StripPre:       body.StripPre,
+			ForwardAuth:    body.ForwardAuth,
 			EnableHTTP:     body.EnableHTTP,
 			EnableSocks5:   body.EnableSocks5,
 			EntryACLMode:   body.EntryACLMode,
@@ -286,29 +303,34 @@ func (a *App) NodeUpdateTunnel(c Context) {
 		ID:               id,
 		ExpectedRevision: body.ExpectedRevision,
 		TunnelWriteRequest: webservice.TunnelWriteRequest{
-			ClientID:       body.ClientID,
-			Port:           body.Port,
-			ServerIP:       body.ServerIP,
-			Mode:           body.Mode,
-			TargetType:     body.TargetType,
-			Target:         body.Target,
-			ProxyProtocol:  body.ProxyProtocol,
-			LocalProxy:     body.LocalProxy,
-			Auth:           body.Auth,
-			Remark:         body.Remark,
-			Password:       body.Password,
-			LocalPath:      body.LocalPath,
-			StripPre:       body.StripPre,
-			EnableHTTP:     body.EnableHTTP,
-			EnableSocks5:   body.EnableSocks5,
-			EntryACLMode:   body.EntryACLMode,
-			EntryACLRules:  body.EntryACLRules,
-			DestACLMode:    body.DestACLMode,
-			DestACLRules:   body.DestACLRules,
-			FlowLimit:      body.FlowLimitTotalBytes,
-			TimeLimit:      body.ExpireAt,
-			RateLimit:      webservice.ManagementRateLimitFromBps(body.RateLimitTotalBps),
-			MaxConnections: body.MaxConnections,
+			ClientID:        body.ClientID,
+			Port:            body.Port,
+			ServerIP:        body.ServerIP,
+			Mode:            body.Mode,
+			TargetType:      body.TargetType,
+			Target:          body.Target,
+			ProxyProtocol:   body.ProxyProtocol,
+			Balance:         body.Balance,
+			BalanceKey:      body.BalanceKey,
+			LocalProxy:      body.LocalProxy,
+			Auth:            body.Auth,
+			Remark:          body.Remark,
+			Labels:          nodeMutationLabelsValue(body.Labels),
+			LabelsSpecified: body.Labels != nil,
+			Password:        body.Password,
+			LocalPath:       body.LocalPath,
+			StripPre:        body.StripPre,
+			ForwardAuth:     body.ForwardAuth,
+			EnableHTTP:      body.EnableHTTP,
+			EnableSocks5:    body.EnableSocks5,
+			EntryACLMode:    body.EntryACLMode,
+			EntryACLRules:   body.EntryACLRules,
+			DestACLMode:     body.DestACLMode,
+			DestACLRules:    body.DestACLRules,
+			FlowLimit:       body.FlowLimitTotalBytes,
+			TimeLimit:       body.ExpireAt,
+			RateLimit:       webservice.ManagementRateLimitFromBps(body.RateLimitTotalBps),
+			MaxConnections:  body.MaxConnections,
 		},
 		ResetFlow: body.ResetFlow,
 	}))
@@ -0,0 +1,35 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	webservice "github.com/djylb/nps/web/service"
+)
+
+// NodeUsageSeries serves the recorded traffic of users, clients, tunnels or
+// hosts. Without an id every resource of the type visible to the caller is
+// returned.
+func (a *App) NodeUsageSeries(c Context) {
+	access := a.nodeActorAccessFromContext(c)
+	input := webservice.UsageSeriesInput{
+		Scope:      access.scope,
+		Resource:   requestString(c, "resource"),
+		Resolution: requestString(c, "resolution"),
+		From:       int64(requestIntValue(c, "from")),
+		To:         int64(requestIntValue(c, "to")),
+	}
+	if id := requestIntValue(c, "id"); id > 0 {
+		input.IDs = []int{id}
+	}
+	if input.Resource == "" {
+		respondMissingRequestField(c, "resource")
+		return
+	}
+	payload, err := a.Services.UsageSeries.Series(input)
+	if err != nil {
+		respondManagementError(c, nodeMutationErrorStatus(err), err)
+		return
+	}
+	respondManagementData(c, http.StatusOK, payload, managementResponseMeta(c, time.Now().Unix(), a.runtimeIdentity().ConfigEpoch()))
+}
@@ -2,6 +2,7 @@ package api
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
 	"strings"
 	"time"
@@ -29,35 +30,37 @@ type nodeResourceItemPayload struct {
 }
 
 type nodeUserResourcePayload struct {
-	ID                  int    `json:"id"`
-	Username            string `json:"username"`
-	Kind                string `json:"kind"`
-	ExternalPlatformID  string `json:"external_platform_id,omitempty"`
-	Hidden              bool   `json:"hidden"`
-	Status              int    `json:"status"`
-	ExpireAt            int64  `json:"expire_at"`
-	FlowLimitTotalBytes int64  `json:"flow_limit_total_bytes"`
-	TotalInBytes        int64  `json:"total_in_bytes"`
-	TotalOutBytes       int64  `json:"total_out_bytes"`
-	TotalBytes          int64  `json:"total_bytes"`
-	NowRateInBps        int64  `json:"now_rate_in_bps"`
-	NowRateOutBps       int64  `json:"now_rate_out_bps"`
-	NowRateTotalBps     int64  `json:"now_rate_total_bps"`
-	MaxClients          int    `json:"max_clients"`
-	MaxTunnels          int    `json:"max_tunnels"`
-	MaxHosts            int    `json:"max_hosts"`
-	MaxConnections      int    `json:"max_connections"`
-	RateLimitTotalBps   int    `json:"rate_limit_total_bps"`
-	EntryACLMode        int    `json:"entry_acl_mode"`
-	EntryACLRules       string `json:"entry_acl_rules,omitempty"`
-	DestACLMode         int    `json:"dest_acl_mode"`
-	DestACLRules        string `json:"dest_acl_rules,omitempty"`
-	Revision            int64  `json:"revision"`
-	UpdatedAt           int64  `json:"updated_at"`
-	ClientCount         int    `json:"client_count,omitempty"`
-	TunnelCount         int    `json:"tunnel_count,omitempty"`
-	HostCount           int    `json:"host_count,omitempty"`
-	ExpireAtText        string `json:"expire_at_text,omitempty"`
+	ID                  int               `json:"id"`
+	Username            string            `json:"username"`
+	Kind                string            `json:"kind"`
+	ExternalPlatformID  string            `json:"external_platform_id,omitempty"`
+	Hidden              bool              `json:"hidden"`
+	Status              int               `json:"status"`
+	ExpireAt            int64             `json:"expire_at"`
+	FlowLimitTotalBytes int64             `json:"flow_limit_total_bytes"`
+	TotalInBytes        int64             `json:"total_in_bytes"`
+	TotalOutBytes       int64             `json:"total_out_bytes"`
+	TotalBytes          int64             `json:"total_bytes"`
+	NowRateInBps        int64             `json:"now_rate_in_bps"`
+	NowRateOutBps       int64             `json:"now_rate_out_bps"`
+	NowRateTotalBps     int64             `json:"now_rate_total_bps"`
+	MaxClients          int               `json:"max_clients"`
+	MaxTunnels          int               `json:"max_tunnels"`
+	MaxHosts            int               `json:"max_hosts"`
+	MaxConnections      int               `json:"max_connections"`
+	RateLimitTotalBps   int               `json:"rate_limit_total_bps"`
+	EntryACLMode        int               `json:"entry_acl_mode"`
+	EntryACLRules       string            `json:"entry_acl_rules,omitempty"`
+	DestACLMode         int               `json:"dest_acl_mode"`
+	DestACLRules        string            `json:"dest_acl_rules,omitempty"`
+	WAFRules            string            `json:"waf_rules,omitempty"`
+	Labels              map[string]string `json:"labels,omitempty"`
+	Revision            int64             `json:"revision"`
+	UpdatedAt           int64             `json:"updated_at"`
+	ClientCount         int               `json:"client_count,omitempty"`
+	TunnelCount         int               `json:"tunnel_count,omitempty"`
+	HostCount           int               `json:"host_count,omitempty"`
+	ExpireAtText        string            `json:"expire_at_text,omitempty"`
 }
 
 type nodeListQuery struct {
@@ -69,6 +72,7 @@ type nodeListQuery struct {
 	Order    string
 	Mode     string
 	Host     string
+	Selector string
 }
 
 func (a *App) NodeUsers(c Context) {
@@ -236,6 +240,8 @@ func respondNodeResourceData(c Context, payload interface{}, err error) {
 		respondManagementError(c, nodeAccessErrorStatus(err), err)
 	case errors.Is(err, webservice.ErrUserNotFound), errors.Is(err, webservice.ErrClientNotFound), errors.Is(err, webservice.ErrTunnelNotFound), errors.Is(err, webservice.ErrHostNotFound):
 		respondManagementError(c, http.StatusNotFound, err)
+	case errors.Is(err, webservice.ErrInvalidLabels):
+		respondManagementError(c, http.StatusBadRequest, err)
 	case err != nil:
 		respondManagementError(c, http.StatusInternalServerError, err)
 	default:
@@ -298,6 +304,8 @@ func newNodeUserResource(user *file.User) nodeUserResourcePayload {
 		EntryACLRules:       user.EntryAclRules,
 		DestACLMode:         user.DestAclMode,
 		DestACLRules:        user.DestAclRules,
+		WAFRules:            user.WafRules,
+		Labels:              file.CloneLabels(user.Labels),
 		Revision:            user.Revision,
 		UpdatedAt:           user.UpdatedAt,
 	}
@@ -371,10 +379,21 @@ func nodeListQueryFromContext(c Context) nodeListQuery {
 		Order:    requestString(c, "order"),
 		Mode:     requestString(c, "mode"),
 		Host:     c.Host(),
+		Selector: requestString(c, "selector"),
 	}
 }
 
-func (q nodeListQuery) clientsInput(visibility webservice.ClientVisibility) webservice.ListClientsInput {
+// parseNodeLabelSelector reads the selector list query parameter, reporting
+// a malformed one as invalid labels so it maps to a 400 response.
+func parseNodeLabelSelector(raw string) (file.LabelSelector, error) {
+	selector, err := file.ParseLabelSelector(raw)
+	if err != nil {
+		return file.LabelSelector{}, fmt.Errorf("%w: %v", webservice.ErrInvalidLabels, err)
+	}
+	return selector, nil
+}
+
+func (q nodeListQuery) clientsInput(visibility webservice.ClientVisibility, selector file.LabelSelector) webservice.ListClientsInput {
 	return webservice.ListClientsInput{
 		Offset:     q.Offset,
 		Limit:      q.Limit,
@@ -382,11 +401,12 @@ func (q nodeListQuery) clientsInput(visibility webservice.ClientVisibility) webs
 		Sort:       q.Sort,
 		Order:      q.Order,
 		Host:       q.Host,
+		Selector:   selector,
 		Visibility: visibility,
 	}
 }
 
-func (q nodeListQuery) hostsInput(visibility webservice.ClientVisibility) webservice.HostListInput {
+func (q nodeListQuery) hostsInput(visibility webservice.ClientVisibility, selector file.LabelSelector) webservice.HostListInput {
 	return webservice.HostListInput{
 		Offset:     q.Offset,
 		Limit:      q.Limit,
@@ -394,11 +414,12 @@ func (q nodeListQuery) hostsInput(visibility webservice.ClientVisibility) webser
 		Search:     q.Search,
 		Sort:       q.Sort,
 		Order:      q.Order,
+		Selector:   selector,
 		Visibility: visibility,
 	}
 }
 
-func (q nodeListQuery) tunnelsInput(visibility webservice.ClientVisibility) webservice.TunnelListInput {
+func (q nodeListQuery) tunnelsInput(visibility webservice.ClientVisibility, selector file.LabelSelector) webservice.TunnelListInput {
 	return webservice.TunnelListInput{
 		Offset:     q.Offset,
 		Limit:      q.Limit,
@@ -407,6 +428,7 @@ func (q nodeListQuery) tunnelsInput(visibility webservice.ClientVisibility) webs
 		Search:     q.Search,
 		Sort:       q.Sort,
 		Order:      q.Order,
+		Selector:   selector,
 		Visibility: visibility,
 	}
 }
@@ -442,6 +464,8 @@ func (a *App) NodeCreateUser(c Context) {
 		EntryACLRules:         body.EntryACLRules,
 		DestACLMode:           body.DestACLMode,
 		DestACLRules:          body.DestACLRules,
+		WAFRules:              body.WAFRules,
+		Labels:                nodeMutationLabelsValue(body.Labels),
 	})
 	if err != nil {
 		respondNodeMutationData(c, nodeResourceMutationPayload{}, err)
@@ -483,6 +507,9 @@ func (a *App) NodeUpdateUser(c Context) {
 		EntryACLRules:         body.EntryACLRules,
 		DestACLMode:           body.DestACLMode,
 		DestACLRules:          body.DestACLRules,
+		WAFRules:              body.WAFRules,
+		Labels:                nodeMutationLabelsValue(body.Labels),
+		LabelsSpecified:       body.Labels != nil,
 	})
 	if err != nil {
 		respondNodeMutationData(c, nodeResourceMutationPayload{}, err)
@@ -0,0 +1,67 @@
+package routers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/djylb/nps/lib/file"
+	"github.com/gin-gonic/gin"
+)
+
+func TestInitNodeSystemApplyPlansAndConverges(t *testing.T) {
+	resetTestDB(t)
+	loadRecoveredNodeConfig(t, "run_mode=node\nnode_token=secret\nweb_username=admin\nweb_password=secret\n")
+	for _, client := range []*file.Client{
+		{Id: 7, VerifyKey: "keep", Remark: "old", Status: true, Cnf: &file.Config{}, Flow: &file.Flow{}},
+		{Id: 8, VerifyKey: "drop", Status: true, Cnf: &file.Config{}, Flow: &file.Flow{}},
+	} {
+		if err := file.GetDb().NewClient(client); err != nil {
+			t.Fatalf("NewClient(%d) error = %v", client.Id, err)
+		}
+	}
+	swapRecoveredNodeStore(t, file.NewLocalStore())
+
+	gin.SetMode(gin.TestMode)
+	handler := Init()
+	serve := func(body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/api/system/apply", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Node-Token", "secret")
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		return resp
+	}
+	state := `"state":{"clients":[{"verify_key":"keep","remark":"new"},{"verify_key":"added"}]}`
+
+	plan := serve(`{"dry_run":true,` + state + `}`)
+	if plan.Code != http.StatusOK {
+		t.Fatalf("dry run status = %d body=%s", plan.Code, plan.Body.String())
+	}
+	body := plan.Body.String()
+	if !strings.Contains(body, `"dry_run":true`) || !strings.Contains(body, `"fields":["remark"]`) ||
+		!strings.Contains(body, `"action":"delete","key":"drop"`) || !strings.Contains(body, `"status":"planned"`) {
+		t.Fatalf("dry run body = %s", body)
+	}
+	if client, _ := file.GetDb().GetClient(7); client.Remark != "old" {
+		t.Fatalf("dry run changed client 7 remark to %q", client.Remark)
+	}
+
+	applied := serve(`{` + state + `}`)
+	if applied.Code != http.StatusOK || !strings.Contains(applied.Body.String(), `"applied":3`) {
+		t.Fatalf("apply status = %d body=%s", applied.Code, applied.Body.String())
+	}
+	if client, _ := file.GetDb().GetClient(7); client.Remark != "new" {
+		t.Fatalf("client 7 remark = %q, want new", client.Remark)
+	}
+	if _, err := file.GetDb().GetClient(8); err == nil {
+		t.Fatal("client 8 should be deleted")
+	}
+
+	if resp := serve(`{"state":{"clients":[{"verify_key":"a"},{"verify_key":"a"}]}}`); resp.Code != http.StatusBadRequest ||
+		!strings.Contains(resp.Body.String(), "invalid_desired_state") {
+		t.Fatalf("duplicate key status = %d body=%s", resp.Code, resp.Body.String())
+	}
+}
@@ -0,0 +1,58 @@
+package routers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/djylb/nps/lib/file"
+	"github.com/gin-gonic/gin"
+)
+
+func TestNodeHostAuthGateFieldsAndToggle(t *testing.T) {
+	resetTestDB(t)
+	loadRecoveredNodeConfig(t, "run_mode=node\nnode_token=secret\nweb_username=admin\nweb_password=secret\n")
+	client := &file.Client{Id: 8, VerifyKey: "vk-8", Status: true, Cnf: &file.Config{}, Flow: &file.Flow{}}
+	if err := file.GetDb().NewClient(client); err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	swapRecoveredNodeStore(t, file.NewLocalStore())
+
+	gin.SetMode(gin.TestMode)
+	runtime := NewRuntime(nil)
+	if runtime.Err != nil {
+		t.Fatalf("NewRuntime() error = %v", runtime.Err)
+	}
+	defer runtime.Stop()
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Node-Token", "secret")
+		resp := httptest.NewRecorder()
+		runtime.Handler.ServeHTTP(resp, req)
+		return resp
+	}
+
+	resp := serve(http.MethodPost, "/api/hosts", `{"client_id":8,"host":"sso.example.com","target":"127.0.0.1:8080","auth_gate":true,"auth_gate_emails":"Alice@Example.com, @corp.example.com\n@corp.example.com","auth_gate_groups":"ops admins"}`)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("host create status = %d body=%s", resp.Code, resp.Body.String())
+	}
+	for _, want := range []string{`"auth_gate":true`, `"auth_gate_emails":"alice@example.com,@corp.example.com"`, `"auth_gate_groups":"ops,admins"`} {
+		if !strings.Contains(resp.Body.String(), want) {
+			t.Fatalf("host create body = %s, want %s", resp.Body.String(), want)
+		}
+	}
+	host, err := file.GetDb().GetHostById(1)
+	if err != nil || !host.AuthGate {
+		t.Fatalf("stored host = %+v, %v", host, err)
+	}
+
+	if resp := serve(http.MethodPost, "/api/hosts/1/actions/stop", `{"mode":"auth_gate"}`); resp.Code != http.StatusOK {
+		t.Fatalf("auth_gate stop status = %d body=%s", resp.Code, resp.Body.String())
+	}
+	if host, _ := file.GetDb().GetHostById(1); host.AuthGate || host.AuthGateGroups != "ops,admins" {
+		t.Fatalf("host after stop = AuthGate %v groups %q", host.AuthGate, host.AuthGateGroups)
+	}
+}
@@ -0,0 +1,46 @@
+package routers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/djylb/nps/lib/file"
+	"github.com/gin-gonic/gin"
+)
+
+func TestNodeHostBodyRewriteIsValidated(t *testing.T) {
+	resetTestDB(t)
+	loadRecoveredNodeConfig(t, "run_mode=node\nnode_token=secret\nweb_username=admin\nweb_password=secret\n")
+	client := &file.Client{Id: 8, VerifyKey: "vk-8", Status: true, Cnf: &file.Config{}, Flow: &file.Flow{}}
+	if err := file.GetDb().NewClient(client); err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	swapRecoveredNodeStore(t, file.NewLocalStore())
+
+	gin.SetMode(gin.TestMode)
+	runtime := NewRuntime(nil)
+	if runtime.Err != nil {
+		t.Fatalf("NewRuntime() error = %v", runtime.Err)
+	}
+	defer runtime.Stop()
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Node-Token", "secret")
+		resp := httptest.NewRecorder()
+		runtime.Handler.ServeHTTP(resp, req)
+		return resp
+	}
+
+	if resp := serve(http.MethodPost, "/api/hosts", `{"client_id":8,"host":"br.example.com","target":"127.0.0.1:8080","resp_body_rewrite":"~( => x"}`); resp.Code != http.StatusBadRequest ||
+		!strings.Contains(resp.Body.String(), "invalid_body_rewrite") || !strings.Contains(resp.Body.String(), "line 1") {
+		t.Fatalf("invalid rules status = %d body=%s", resp.Code, resp.Body.String())
+	}
+	resp := serve(http.MethodPost, "/api/hosts", `{"client_id":8,"host":"br.example.com","target":"127.0.0.1:8080","resp_body_rewrite":"\n http://localhost:8080 => ${scheme}://${http_host} \n","req_body_rewrite":"${scheme_host} => http://localhost:8080"}`)
+	if resp.Code != http.StatusOK || !strings.Contains(resp.Body.String(), `"resp_body_rewrite":"http://localhost:8080 =\u003e ${scheme}://${http_host}"`) {
+		t.Fatalf("host create status = %d body=%s", resp.Code, resp.Body.String())
+	}
+}
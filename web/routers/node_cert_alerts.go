@@ -0,0 +1,129 @@
+package routers
+
+import (
+	"strings"
+	"time"
+
+	"github.com/djylb/nps/lib/certinventory"
+	webapi "github.com/djylb/nps/web/api"
+	webservice "github.com/djylb/nps/web/service"
+)
+
+var nodeCertCheckInterval = time.Hour
+
+// StartNodeCertificateScheduler reports certificates that come close to
+// their expiry, once per threshold of node_cert_alert_days, and renewals the
+// certificate maintenance failed in the background.
+func StartNodeCertificateScheduler(state *State) func() {
+	if state == nil || state.App == nil || state.App.Services.Certificates == nil {
+		return func() {}
+	}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(nodeCertCheckInterval)
+		defer ticker.Stop()
+		alerts := newNodeQuotaAlertTracker(nodeCertAlertPersistencePath())
+		checkNodeCertificates(state, alerts, certinventory.Default(), time.Now())
+		for {
+			select {
+			case <-stop:
+				return
+			case now := <-ticker.C:
+				checkNodeCertificates(state, alerts, certinventory.Default(), now)
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+	}
+}
+
+// checkNodeCertificates keys expiry alerts by certificate id, which changes
+// with every renewal, so a renewed certificate is reported again as it ages.
+// Failed renewals are keyed by name and reported once per attempt.
+func checkNodeCertificates(state *State, alerts *nodeQuotaAlertTracker, inventory *certinventory.Inventory, now time.Time) {
+	days := state.CurrentConfig().Runtime.NodeCertAlertDays
+	items, err := state.App.Services.Certificates.List(webservice.CertificateListInput{
+		Scope: webservice.ResolveNodeAccessScope(webservice.Principal{Authenticated: true, Kind: "admin", IsAdmin: true}),
+	})
+	if err != nil {
+		return
+	}
+	changed := false
+	seen := make(map[string]struct{})
+	if len(days) > 0 {
+		for _, item := range items {
+			if item.NotAfter == 0 {
+				continue
+			}
+			key := "certificate:" + item.ID + ":" + nodeQuotaAlertExpire
+			seen[key] = struct{}{}
+			threshold, crossed := crossedExpireDays(days, item.NotAfter, now)
+			emit, dirty := alerts.observe(key, threshold, crossed, func(a, b int) bool { return a < b })
+			if emit {
+				emitNodeManagementEvent(state, nil, nodeCertExpireThresholdEvent(item, threshold, now))
+			}
+			changed = changed || dirty
+		}
+	}
+	for name, attempt := range inventory.Failures() {
+		if attempt.Requested {
+			continue
+		}
+		key := "renewal:" + name
+		seen[key] = struct{}{}
+		emit, dirty := alerts.observe(key, int(attempt.At), true, func(a, b int) bool { return a > b })
+		if emit {
+			emitNodeManagementEvent(state, nil, nodeCertRenewFailedEvent(name, attempt))
+		}
+		changed = changed || dirty
+	}
+	for key := range alerts.fired {
+		if _, ok := seen[key]; !ok {
+			delete(alerts.fired, key)
+			changed = true
+		}
+	}
+	if changed {
+		alerts.persist()
+	}
+}
+
+func nodeCertExpireThresholdEvent(item webservice.CertificatePayload, days int, now time.Time) webapi.Event {
+	hosts := make([]string, 0, len(item.Hosts))
+	for _, host := range item.Hosts {
+		hosts = append(hosts, host.Host)
+	}
+	return webapi.Event{
+		Name:     "certificate.expire_threshold",
+		Resource: "certificate",
+		Action:   "expire_threshold",
+		Fields: map[string]interface{}{
+			"id":             item.ID,
+			"source":         item.Source,
+			"hosts":          hosts,
+			"dns_names":      item.DNSNames,
+			"threshold_days": days,
+			"not_after":      item.NotAfter,
+			"expired":        item.NotAfter <= now.Unix(),
+		},
+	}
+}
+
+func nodeCertRenewFailedEvent(key string, attempt certinventory.Attempt) webapi.Event {
+	source, name, _ := strings.Cut(key, ":")
+	return webapi.Event{
+		Name:     "certificate.renew_failed",
+		Resource: "certificate",
+		Action:   "renew",
+		Fields: map[string]interface{}{
+			"source":       source,
+			"name":         name,
+			"error":        attempt.Error,
+			"attempted_at": attempt.At,
+		},
+	}
+}
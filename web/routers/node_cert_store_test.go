@@ -0,0 +1,152 @@
+package routers
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/djylb/nps/lib/file"
+	"github.com/gin-gonic/gin"
+)
+
+func storedCertTestPair(t *testing.T, serial int64, names ...string) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+}
+
+func TestNodeCertStoreSharedAcrossHosts(t *testing.T) {
+	root := resetTestDB(t)
+	loadRecoveredNodeConfig(t, "run_mode=node\nnode_token=secret\nweb_username=admin\nweb_password=secret\n")
+	store, err := file.OpenCertStore(filepath.Join(root, "conf", "certs.json"))
+	if err != nil {
+		t.Fatalf("OpenCertStore() error = %v", err)
+	}
+	previous := file.ReplaceCertStore(store)
+	t.Cleanup(func() { file.ReplaceCertStore(previous) })
+	client := &file.Client{Id: 8, VerifyKey: "vk-8", Status: true, Cnf: &file.Config{}, Flow: &file.Flow{}}
+	if err := file.GetDb().NewClient(client); err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	swapRecoveredNodeStore(t, file.NewLocalStore())
+
+	gin.SetMode(gin.TestMode)
+	runtime := NewRuntime(nil)
+	if runtime.Err != nil {
+		t.Fatalf("NewRuntime() error = %v", runtime.Err)
+	}
+	defer runtime.Stop()
+	serve := func(method, path string, body any) *httptest.ResponseRecorder {
+		t.Helper()
+		var payload string
+		if body != nil {
+			data, _ := json.Marshal(body)
+			payload = string(data)
+		}
+		req := httptest.NewRequest(method, path, strings.NewReader(payload))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Node-Token", "secret")
+		resp := httptest.NewRecorder()
+		runtime.Handler.ServeHTTP(resp, req)
+		return resp
+	}
+	expect := func(resp *httptest.ResponseRecorder, status int, code string) {
+		t.Helper()
+		if resp.Code != status || !strings.Contains(resp.Body.String(), code) {
+			t.Fatalf("status = %d body=%s, want %d %s", resp.Code, resp.Body.String(), status, code)
+		}
+	}
+
+	certV1, keyV1 := storedCertTestPair(t, 1, "*.example.com", "example.com")
+	expect(serve(http.MethodPost, "/api/cert-store", map[string]any{"name": "wildcard", "cert_file": certV1, "key_file": keyV1}), http.StatusOK, `"name":"wildcard"`)
+	_, otherKey := storedCertTestPair(t, 2, "*.example.com")
+	expect(serve(http.MethodPost, "/api/cert-store", map[string]any{"name": "broken", "cert_file": certV1, "key_file": otherKey}), http.StatusBadRequest, "invalid_stored_certificate")
+	expect(serve(http.MethodPost, "/api/cert-store", map[string]any{"name": "WILDCARD", "cert_file": certV1, "key_file": keyV1}), http.StatusConflict, "stored_certificate_exists")
+
+	hostIDs := make([]int, 0, 2)
+	for _, name := range []string{"a.example.com", "b.example.com"} {
+		resp := serve(http.MethodPost, "/api/hosts", map[string]any{"client_id": 8, "host": name, "target": "127.0.0.1:8080", "cert_id": 1})
+		var created struct {
+			Data struct {
+				ID int `json:"id"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(resp.Body.Bytes(), &created); err != nil || resp.Code != http.StatusOK || created.Data.ID == 0 {
+			t.Fatalf("host create status = %d body=%s err=%v", resp.Code, resp.Body.String(), err)
+		}
+		hostIDs = append(hostIDs, created.Data.ID)
+	}
+	expect(serve(http.MethodPost, "/api/hosts", map[string]any{"client_id": 8, "host": "shop.example.org", "target": "127.0.0.1:8080", "cert_id": 1}), http.StatusBadRequest, "certificate_san_mismatch")
+	expect(serve(http.MethodPost, "/api/hosts", map[string]any{"client_id": 8, "host": "c.example.com", "target": "127.0.0.1:8080", "cert_id": 9}), http.StatusNotFound, "stored_certificate_not_found")
+	for _, id := range hostIDs {
+		if host, err := file.GetDb().GetHostById(id); err != nil || host.CertID != 1 || host.CertFile != certV1 || host.KeyFile != keyV1 {
+			t.Fatalf("host %d = %+v, %v, want the stored certificate", id, host, err)
+		}
+	}
+	expect(serve(http.MethodGet, "/api/hosts/cert-suggestion?host=new.example.com", nil), http.StatusOK, `"stored_cert_id":1`)
+
+	narrowCert, narrowKey := storedCertTestPair(t, 3, "a.example.com")
+	expect(serve(http.MethodPost, "/api/cert-store/1/actions/update", map[string]any{"name": "wildcard", "cert_file": narrowCert, "key_file": narrowKey}), http.StatusBadRequest, "certificate_san_mismatch")
+	if stored, err := store.Get(1); err != nil || stored.CertFile != certV1 {
+		t.Fatalf("stored certificate after rejected update = %+v, %v", stored, err)
+	}
+
+	certV2, keyV2 := storedCertTestPair(t, 4, "*.example.com")
+	expect(serve(http.MethodPost, "/api/cert-store/1/actions/update", map[string]any{"name": "wildcard", "remark": "renewed", "cert_file": certV2, "key_file": keyV2}), http.StatusOK, `"serial":"4"`)
+	for _, id := range hostIDs {
+		if host, err := file.GetDb().GetHostById(id); err != nil || host.CertFile != certV2 || host.KeyFile != keyV2 {
+			t.Fatalf("host %d after update = %+v, %v, want the renewed certificate", id, host, err)
+		}
+	}
+
+	resp := serve(http.MethodGet, "/api/cert-store", nil)
+	var listed struct {
+		Data []struct {
+			ID     int    `json:"id"`
+			Remark string `json:"remark"`
+			Hosts  []struct {
+				Host string `json:"host"`
+			} `json:"hosts"`
+			KeyFile string `json:"key_file"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(resp.Body.Bytes(), &listed); err != nil || len(listed.Data) != 1 || listed.Data[0].Remark != "renewed" ||
+		len(listed.Data[0].Hosts) != 2 || listed.Data[0].KeyFile != "" {
+		t.Fatalf("list status = %d body=%s err=%v", resp.Code, resp.Body.String(), err)
+	}
+
+	expect(serve(http.MethodPost, "/api/cert-store/1/actions/delete", nil), http.StatusConflict, "stored_certificate_in_use")
+	for _, id := range hostIDs {
+		expect(serve(http.MethodPost, "/api/hosts/"+strconv.Itoa(id)+"/actions/delete", nil), http.StatusOK, "")
+	}
+	expect(serve(http.MethodPost, "/api/cert-store/1/actions/delete", nil), http.StatusOK, `"action":"delete"`)
+	expect(serve(http.MethodGet, "/api/cert-store/1", nil), http.StatusNotFound, "stored_certificate_not_found")
+}
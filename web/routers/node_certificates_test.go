@@ -0,0 +1,167 @@
+package routers
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/djylb/nps/lib/certinventory"
+	"github.com/djylb/nps/lib/file"
+	"github.com/gin-gonic/gin"
+)
+
+type routerCertRuntimeStub struct {
+	reloaded []string
+}
+
+func (s *routerCertRuntimeStub) ACMECertificate(string) (*x509.Certificate, bool) { return nil, false }
+
+func (s *routerCertRuntimeStub) RenewACME(context.Context, string) error {
+	return errors.New("acme unavailable")
+}
+
+func (s *routerCertRuntimeStub) ReloadManual(hash string) { s.reloaded = append(s.reloaded, hash) }
+
+func TestNodeCertificateInventoryRenewAndAlerts(t *testing.T) {
+	resetTestDB(t)
+	loadRecoveredNodeConfig(t, "run_mode=node\nnode_token=secret\nweb_username=admin\nweb_password=secret\n")
+	client := &file.Client{Id: 8, VerifyKey: "vk-8", Status: true, Cnf: &file.Config{}, Flow: &file.Flow{}}
+	if err := file.GetDb().NewClient(client); err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	swapRecoveredNodeStore(t, file.NewLocalStore())
+
+	gin.SetMode(gin.TestMode)
+	runtime := NewRuntime(nil)
+	if runtime.Err != nil {
+		t.Fatalf("NewRuntime() error = %v", runtime.Err)
+	}
+	defer runtime.Stop()
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Node-Token", "secret")
+		resp := httptest.NewRecorder()
+		runtime.Handler.ServeHTTP(resp, req)
+		return resp
+	}
+
+	now := time.Now()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(42),
+		Subject:      pkix.Name{CommonName: "shop.example.com"},
+		DNSNames:     []string{"shop.example.com", "www.shop.example.com"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(5*24*time.Hour + time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	for _, host := range []string{"shop.example.com", "www.shop.example.com"} {
+		body, _ := json.Marshal(map[string]any{
+			"client_id": 8,
+			"host":      host,
+			"target":    "127.0.0.1:8080",
+			"cert_file": string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+			"key_file":  string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})),
+		})
+		if resp := serve(http.MethodPost, "/api/hosts", string(body)); resp.Code != http.StatusOK {
+			t.Fatalf("host create status = %d body=%s", resp.Code, resp.Body.String())
+		}
+	}
+	if resp := serve(http.MethodPost, "/api/hosts", `{"client_id":8,"host":"plain.example.com","target":"127.0.0.1:8080","scheme":"http"}`); resp.Code != http.StatusOK {
+		t.Fatalf("http host create status = %d body=%s", resp.Code, resp.Body.String())
+	}
+
+	resp := serve(http.MethodGet, "/api/certificates", "")
+	var listed struct {
+		Data []struct {
+			ID       string   `json:"id"`
+			Source   string   `json:"source"`
+			Issuer   string   `json:"issuer"`
+			DNSNames []string `json:"dns_names"`
+			Serial   string   `json:"serial"`
+			DaysLeft int      `json:"days_left"`
+			Hosts    []struct {
+				Host     string `json:"host"`
+				ClientID int    `json:"client_id"`
+			} `json:"hosts"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(resp.Body.Bytes(), &listed); err != nil || resp.Code != http.StatusOK || len(listed.Data) != 1 {
+		t.Fatalf("list status = %d body=%s err=%v", resp.Code, resp.Body.String(), err)
+	}
+	item := listed.Data[0]
+	if item.Source != "manual" || item.Serial != "2A" || item.DaysLeft != 5 || len(item.DNSNames) != 2 || len(item.Hosts) != 2 ||
+		item.Hosts[0].ClientID != 8 || !strings.Contains(item.Issuer, "shop.example.com") {
+		t.Fatalf("certificate = %+v", item)
+	}
+
+	if resp := serve(http.MethodPost, "/api/certificates/actions/renew", `{"id":"`+item.ID+`"}`); resp.Code != http.StatusServiceUnavailable ||
+		!strings.Contains(resp.Body.String(), "certificate_renew_unavailable") {
+		t.Fatalf("renew without https status = %d body=%s", resp.Code, resp.Body.String())
+	}
+	if resp := serve(http.MethodPost, "/api/certificates/actions/renew", `{"id":"missing"}`); resp.Code != http.StatusNotFound {
+		t.Fatalf("renew missing status = %d body=%s", resp.Code, resp.Body.String())
+	}
+	stub := &routerCertRuntimeStub{}
+	certinventory.Default().SetRuntime(stub, nil)
+	defer certinventory.Default().SetRuntime(nil, stub)
+	resp = serve(http.MethodPost, "/api/certificates/actions/renew", `{"id":"`+item.ID+`"}`)
+	if resp.Code != http.StatusOK || len(stub.reloaded) != 1 || !strings.Contains(resp.Body.String(), `"last_renewal_at":`) {
+		t.Fatalf("renew status = %d body=%s reloaded=%v", resp.Code, resp.Body.String(), stub.reloaded)
+	}
+
+	count := func(name string) int {
+		total := 0
+		for _, event := range runtime.State.NodeEventLog.Query(0, 0, nil).Items {
+			if event.Name == name {
+				total++
+			}
+		}
+		return total
+	}
+	if got := count("certificate.renewed"); got != 1 {
+		t.Fatalf("certificate.renewed events = %d, want one", got)
+	}
+
+	inventory := certinventory.New()
+	inventory.Record(certinventory.ACMEKey("auto.example.com"), now, errors.New("rate limited"))
+	alerts := newNodeQuotaAlertTracker(nodeCertAlertPersistencePath())
+	checkNodeCertificates(runtime.State, alerts, inventory, now)
+	checkNodeCertificates(runtime.State, alerts, inventory, now)
+	if got := count("certificate.expire_threshold"); got != 1 {
+		t.Fatalf("expire events = %d, want one", got)
+	}
+	if got := count("certificate.renew_failed"); got != 1 {
+		t.Fatalf("renew_failed events = %d, want one", got)
+	}
+	checkNodeCertificates(runtime.State, alerts, inventory, now.Add(5*24*time.Hour))
+	inventory.Record(certinventory.ACMEKey("auto.example.com"), now.Add(time.Hour), errors.New("rate limited"))
+	checkNodeCertificates(runtime.State, alerts, inventory, now.Add(5*24*time.Hour))
+	if got := count("certificate.expire_threshold"); got != 2 {
+		t.Fatalf("expire events one day before = %d, want two", got)
+	}
+	if got := count("certificate.renew_failed"); got != 2 {
+		t.Fatalf("renew_failed events after another failure = %d, want two", got)
+	}
+}
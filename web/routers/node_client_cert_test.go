@@ -0,0 +1,94 @@
+package routers
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/djylb/nps/lib/file"
+	"github.com/gin-gonic/gin"
+)
+
+func TestNodeHostClientCertIsValidated(t *testing.T) {
+	resetTestDB(t)
+	loadRecoveredNodeConfig(t, "run_mode=node\nnode_token=secret\nweb_username=admin\nweb_password=secret\n")
+	client := &file.Client{Id: 8, VerifyKey: "vk-8", Status: true, Cnf: &file.Config{}, Flow: &file.Flow{}}
+	if err := file.GetDb().NewClient(client); err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	swapRecoveredNodeStore(t, file.NewLocalStore())
+
+	gin.SetMode(gin.TestMode)
+	runtime := NewRuntime(nil)
+	if runtime.Err != nil {
+		t.Fatalf("NewRuntime() error = %v", runtime.Err)
+	}
+	defer runtime.Stop()
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Node-Token", "secret")
+		resp := httptest.NewRecorder()
+		runtime.Handler.ServeHTTP(resp, req)
+		return resp
+	}
+
+	if resp := serve(http.MethodPost, "/api/hosts", `{"client_id":8,"host":"admin.example.com","target":"127.0.0.1:8080","client_cert_mode":"require"}`); resp.Code != http.StatusBadRequest ||
+		!strings.Contains(resp.Body.String(), "invalid_client_cert") {
+		t.Fatalf("mode without a CA status = %d body=%s", resp.Code, resp.Body.String())
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Device CA"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create CA: %v", err)
+	}
+	body, _ := json.Marshal(map[string]any{
+		"client_id":            8,
+		"host":                 "admin.example.com",
+		"target":               "127.0.0.1:8080",
+		"client_cert_ca":       string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		"client_cert_mode":     "Optional",
+		"client_cert_subjects": "alice@example.com=alice",
+	})
+	resp := serve(http.MethodPost, "/api/hosts", string(body))
+	if resp.Code != http.StatusOK || !strings.Contains(resp.Body.String(), `"client_cert_mode":"optional"`) {
+		t.Fatalf("host create status = %d body=%s", resp.Code, resp.Body.String())
+	}
+	var created struct {
+		Data struct {
+			ID int `json:"id"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(resp.Body.Bytes(), &created); err != nil || created.Data.ID == 0 {
+		t.Fatalf("host create body=%s err=%v", resp.Body.String(), err)
+	}
+	if resp := serve(http.MethodPost, fmt.Sprintf("/api/hosts/%d/actions/update", created.Data.ID), `{"client_id":8,"host":"admin.example.com","target":"127.0.0.1:8080","client_cert_mode":"strict","client_cert_ca":"ca.pem"}`); resp.Code != http.StatusBadRequest ||
+		!strings.Contains(resp.Body.String(), "invalid_client_cert") {
+		t.Fatalf("unknown mode status = %d body=%s", resp.Code, resp.Body.String())
+	}
+}
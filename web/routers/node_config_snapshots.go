@@ -0,0 +1,256 @@
+package routers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/djylb/nps/lib/file"
+	"github.com/djylb/nps/lib/logs"
+	webapi "github.com/djylb/nps/web/api"
+	"github.com/djylb/nps/web/framework"
+	webservice "github.com/djylb/nps/web/service"
+	"github.com/gin-gonic/gin"
+)
+
+type nodeConfigSnapshotsResponse struct {
+	Items []file.ConfigArchiveInfo `json:"items"`
+	Total int                      `json:"total"`
+}
+
+type nodeConfigSnapshotRestoreResponse struct {
+	Status      int                    `json:"status"`
+	Msg         string                 `json:"msg"`
+	ConfigEpoch string                 `json:"config_epoch,omitempty"`
+	Snapshot    file.ConfigArchiveInfo `json:"snapshot"`
+	Backup      string                 `json:"backup,omitempty"`
+}
+
+type nodeConfigSnapshotResult struct {
+	payload     interface{}
+	configEpoch string
+}
+
+func requireNodeConfigArchiver(state *State, actor *webapi.Actor) (*file.ConfigArchiver, int, error) {
+	if state == nil || state.App == nil {
+		return nil, http.StatusInternalServerError, errors.New("node runtime is unavailable")
+	}
+	scope := actorNodeScopeWithAuthz(state.Authorization(), actor)
+	if !scope.CanExportConfig() {
+		return nil, http.StatusForbidden, webservice.ErrForbidden
+	}
+	archiver := file.CurrentConfigArchiver()
+	if archiver == nil {
+		return nil, http.StatusNotImplemented, file.ErrConfigArchiveDisabled
+	}
+	return archiver, http.StatusOK, nil
+}
+
+func executeNodeConfigSnapshotList(state *State, actor *webapi.Actor) (nodeConfigSnapshotsResponse, int, error) {
+	archiver, status, err := requireNodeConfigArchiver(state, actor)
+	if err != nil {
+		return nodeConfigSnapshotsResponse{}, status, err
+	}
+	items, err := archiver.List()
+	if err != nil {
+		return nodeConfigSnapshotsResponse{}, http.StatusInternalServerError, err
+	}
+	if items == nil {
+		items = []file.ConfigArchiveInfo{}
+	}
+	return nodeConfigSnapshotsResponse{Items: items, Total: len(items)}, http.StatusOK, nil
+}
+
+func executeNodeConfigSnapshotCreate(ctx context.Context, state *State, actor *webapi.Actor, metadata webapi.RequestMetadata) (file.ConfigArchiveInfo, int, error) {
+	archiver, status, err := requireNodeConfigArchiver(state, actor)
+	if err != nil {
+		return file.ConfigArchiveInfo{}, status, err
+	}
+	info, err := archiver.Create("manual")
+	if err != nil {
+		return file.ConfigArchiveInfo{}, http.StatusInternalServerError, err
+	}
+	recordConfigSnapshotEvent(ctx, state, actor, metadata, "node.config.snapshot_created", "snapshot", info, "")
+	return info, http.StatusOK, nil
+}
+
+// executeNodeConfigSnapshotRestore replaces the node config with a stored
+// snapshot. The current config is archived first as a "pre-restore" snapshot
+// so the restore itself can be undone from the same list.
+func executeNodeConfigSnapshotRestore(ctx context.Context, state *State, actor *webapi.Actor, metadata webapi.RequestMetadata, raw []byte) (nodeConfigSnapshotRestoreResponse, int, error) {
+	archiver, status, err := requireNodeConfigArchiver(state, actor)
+	if err != nil {
+		return nodeConfigSnapshotRestoreResponse{}, status, err
+	}
+	name, err := decodeNodeConfigSnapshotName(raw)
+	if err != nil {
+		return nodeConfigSnapshotRestoreResponse{}, http.StatusBadRequest, err
+	}
+	snapshot, info, err := archiver.Load(name)
+	if err != nil {
+		if errors.Is(err, file.ErrConfigArchiveNotFound) {
+			return nodeConfigSnapshotRestoreResponse{}, http.StatusNotFound, err
+		}
+		return nodeConfigSnapshotRestoreResponse{}, http.StatusInternalServerError, err
+	}
+	backup, err := archiver.Create("pre-restore")
+	if err != nil {
+		logs.Warn("archive config before snapshot restore error: %v", err)
+	}
+	configEpoch, status, err := replaceNodeConfigSnapshot(state, snapshot, "snapshot")
+	if err != nil {
+		return nodeConfigSnapshotRestoreResponse{}, status, err
+	}
+	recordConfigSnapshotEvent(ctx, state, actor, metadata, "node.config.snapshot_restored", "restore", info, configEpoch)
+
+	return nodeConfigSnapshotRestoreResponse{
+		Status:      1,
+		Msg:         "config snapshot restore success",
+		ConfigEpoch: configEpoch,
+		Snapshot:    info,
+		Backup:      backup.Name,
+	}, http.StatusOK, nil
+}
+
+func decodeNodeConfigSnapshotName(raw []byte) (string, error) {
+	var body struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(bytes.TrimSpace(raw), &body); err != nil {
+		return "", err
+	}
+	name := strings.TrimSpace(body.Name)
+	if name == "" {
+		return "", errors.New("snapshot name is required")
+	}
+	return name, nil
+}
+
+func recordConfigSnapshotEvent(ctx context.Context, state *State, actor *webapi.Actor, metadata webapi.RequestMetadata, name, action string, info file.ConfigArchiveInfo, configEpoch string) {
+	if state == nil || state.App == nil || state.App.Hooks == nil {
+		return
+	}
+	fields := map[string]interface{}{
+		"snapshot":   info.Name,
+		"created_at": info.CreatedAt,
+	}
+	if configEpoch != "" {
+		fields["config_epoch"] = configEpoch
+	}
+	_ = state.App.Hooks.OnManagementEvent(resolveNodeEmitContext(ctx, func() context.Context {
+		if state != nil {
+			return state.BaseContext()
+		}
+		return context.Background()
+	}), webapi.Event{
+		Name:     name,
+		Resource: "node",
+		Action:   action,
+		Actor:    cloneActor(actor),
+		Metadata: metadata,
+		Fields:   fields,
+	})
+}
+
+func nodeConfigSnapshotListHTTPHandler(state *State) gin.HandlerFunc {
+	return nodeConfigSnapshotHTTPHandler(state, "config_snapshot_list", func(c *gin.Context) (nodeConfigSnapshotResult, int, error) {
+		payload, status, err := executeNodeConfigSnapshotList(state, currentActor(c))
+		return nodeConfigSnapshotResult{payload: payload, configEpoch: state.RuntimeIdentity().ConfigEpoch()}, status, err
+	})
+}
+
+func nodeConfigSnapshotCreateHTTPHandler(state *State) gin.HandlerFunc {
+	return nodeConfigSnapshotHTTPHandler(state, "config_snapshot_create", func(c *gin.Context) (nodeConfigSnapshotResult, int, error) {
+		payload, status, err := executeNodeConfigSnapshotCreate(c.Request.Context(), state, currentActor(c), currentRequestMetadata(c))
+		return nodeConfigSnapshotResult{payload: payload, configEpoch: state.RuntimeIdentity().ConfigEpoch()}, status, err
+	})
+}
+
+func nodeConfigSnapshotRestoreHTTPHandler(state *State) gin.HandlerFunc {
+	return nodeConfigSnapshotHTTPHandler(state, "config_snapshot_restore", func(c *gin.Context) (nodeConfigSnapshotResult, int, error) {
+		payload, status, err := executeNodeConfigSnapshotRestore(
+			c.Request.Context(),
+			state,
+			currentActor(c),
+			currentRequestMetadata(c),
+			framework.RequestRawBodyView(c),
+		)
+		return nodeConfigSnapshotResult{payload: payload, configEpoch: payload.ConfigEpoch}, status, err
+	})
+}
+
+func nodeConfigSnapshotHTTPHandler(state *State, operation string, run func(*gin.Context) (nodeConfigSnapshotResult, int, error)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		startedAt := time.Now()
+		operationID := currentNodeHTTPOperationID(c)
+		setNodeHTTPOperationHeader(c, operationID)
+		result, status, err := run(c)
+		recordNodeOperation(
+			state,
+			currentActor(c),
+			currentRequestMetadata(c),
+			operationID,
+			operation,
+			startedAt,
+			1,
+			nodeOperationSuccessCount(status),
+			nodeOperationErrorCount(status),
+			[]string{canonicalNodeOperationPath(state, c.Request.URL.Path)},
+		)
+		state.RuntimeStatus().NoteOperation(operation, err)
+		if err != nil {
+			c.JSON(status, webapi.ManagementErrorResponseForStatus(status, err))
+			return
+		}
+		c.JSON(status, webapi.ManagementDataResponse{
+			Data: result.payload,
+			Meta: webapi.ManagementResponseMetaForRequest(currentRequestMetadata(c), time.Now().Unix(), result.configEpoch),
+		})
+		if operation == "config_snapshot_restore" {
+			maybeInvalidateRealtimeSessions(state, result.configEpoch)
+		}
+	}
+}
+
+func dispatchNodeWSConfigSnapshotList(request *nodeWSDispatchRequest) nodeWSFrame {
+	operation := startNodeWSOperation(request.state, request.ctx, "config_snapshot_list", []string{canonicalNodeOperationPath(request.state, request.frame.Path)})
+	payload, status, err := executeNodeConfigSnapshotList(request.state, request.actor)
+	operation.record(status, err)
+	if err != nil {
+		writeWSManagementErrorResponse(request.ctx, status, err)
+		return buildNodeWSResponseFrame(request.response, request.ctx)
+	}
+	response := buildNodeWSManagementDataFrame(request.response, request.metadata, request.state.RuntimeIdentity().ConfigEpoch(), time.Now().Unix(), payload)
+	operation.setFrameHeader(&response)
+	return response
+}
+
+func dispatchNodeWSConfigSnapshotCreate(request *nodeWSDispatchRequest) nodeWSFrame {
+	operation := startNodeWSOperation(request.state, request.ctx, "config_snapshot_create", []string{canonicalNodeOperationPath(request.state, request.frame.Path)})
+	payload, status, err := executeNodeConfigSnapshotCreate(request.ctx.BaseContext(), request.state, request.actor, request.metadata)
+	operation.record(status, err)
+	if err != nil {
+		writeWSManagementErrorResponse(request.ctx, status, err)
+		return buildNodeWSResponseFrame(request.response, request.ctx)
+	}
+	response := buildNodeWSManagementDataFrame(request.response, request.metadata, request.state.RuntimeIdentity().ConfigEpoch(), time.Now().Unix(), payload)
+	operation.setFrameHeader(&response)
+	return response
+}
+
+func dispatchNodeWSConfigSnapshotRestore(request *nodeWSDispatchRequest) nodeWSFrame {
+	operation := startNodeWSOperation(request.state, request.ctx, "config_snapshot_restore", []string{canonicalNodeOperationPath(request.state, request.frame.Path)})
+	payload, status, err := executeNodeConfigSnapshotRestore(request.ctx.BaseContext(), request.state, request.actor, request.metadata, request.frame.Body)
+	operation.record(status, err)
+	if err != nil {
+		writeWSManagementErrorResponse(request.ctx, status, err)
+		return buildNodeWSResponseFrame(request.response, request.ctx)
+	}
+	response := buildNodeWSManagementDataFrame(request.response, request.metadata, payload.ConfigEpoch, time.Now().Unix(), payload)
+	operation.setFrameHeader(&response)
+	return response
+}
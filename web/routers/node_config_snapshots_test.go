@@ -0,0 +1,76 @@
+package routers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/djylb/nps/lib/file"
+	webapi "github.com/djylb/nps/web/api"
+)
+
+func enableTestConfigArchiver(t *testing.T) *file.ConfigArchiver {
+	t.Helper()
+	archiver := file.NewConfigArchiver(filepath.Join(t.TempDir(), "snapshots"), file.ConfigArchiveOptions{})
+	previous := file.ReplaceConfigArchiver(archiver)
+	t.Cleanup(func() { file.ReplaceConfigArchiver(previous) })
+	return archiver
+}
+
+func TestExecuteNodeConfigSnapshotListRequiresArchiver(t *testing.T) {
+	resetTestDB(t)
+	previous := file.ReplaceConfigArchiver(nil)
+	t.Cleanup(func() { file.ReplaceConfigArchiver(previous) })
+
+	state := NewState(nil)
+	t.Cleanup(state.Close)
+
+	_, status, err := executeNodeConfigSnapshotList(state, webapi.AdminActor("admin"))
+	if !errors.Is(err, file.ErrConfigArchiveDisabled) || status != http.StatusNotImplemented {
+		t.Fatalf("executeNodeConfigSnapshotList() = %d, %v, want 501 ErrConfigArchiveDisabled", status, err)
+	}
+}
+
+func TestExecuteNodeConfigSnapshotRestoreImportsArchive(t *testing.T) {
+	resetTestDB(t)
+	enableTestConfigArchiver(t)
+	if err := file.GetDb().NewUser(&file.User{Id: 5, Username: "before", Password: "secret", Status: 1, TotalFlow: &file.Flow{}}); err != nil {
+		t.Fatalf("NewUser() error = %v", err)
+	}
+
+	state := NewState(nil)
+	t.Cleanup(state.Close)
+	storage := &stubConfigImportStorage{snapshot: &file.ConfigSnapshot{Global: &file.Glob{}}}
+	state.App.Services.NodeStorage = storage
+
+	created, status, err := executeNodeConfigSnapshotCreate(context.Background(), state, webapi.AdminActor("admin"), webapi.RequestMetadata{})
+	if err != nil || status != http.StatusOK || created.Users != 1 {
+		t.Fatalf("executeNodeConfigSnapshotCreate() = %+v, %d, %v", created, status, err)
+	}
+	list, _, err := executeNodeConfigSnapshotList(state, webapi.AdminActor("admin"))
+	if err != nil || list.Total != 1 || list.Items[0].Name != created.Name {
+		t.Fatalf("executeNodeConfigSnapshotList() = %+v, %v, want the created snapshot", list, err)
+	}
+
+	body := []byte(`{"name":"` + created.Name + `"}`)
+	payload, status, err := executeNodeConfigSnapshotRestore(context.Background(), state, webapi.AdminActor("admin"), webapi.RequestMetadata{}, body)
+	if err != nil || status != http.StatusOK {
+		t.Fatalf("executeNodeConfigSnapshotRestore() = %d, %v", status, err)
+	}
+	if payload.Status != 1 || payload.ConfigEpoch == "" || payload.Snapshot.Name != created.Name || payload.Backup == "" {
+		t.Fatalf("unexpected payload = %+v", payload)
+	}
+	if len(storage.importedSnapshots) != 1 {
+		t.Fatalf("ImportSnapshot() call count = %d, want 1", len(storage.importedSnapshots))
+	}
+	if restored := storage.importedSnapshots[0]; len(restored.Users) != 1 || restored.Users[0].Username != "before" {
+		t.Fatalf("restored users = %+v, want the archived user", restored.Users)
+	}
+
+	_, status, err = executeNodeConfigSnapshotRestore(context.Background(), state, webapi.AdminActor("admin"), webapi.RequestMetadata{}, []byte(`{"name":"snapshot-missing.tar.gz"}`))
+	if !errors.Is(err, file.ErrConfigArchiveNotFound) || status != http.StatusNotFound {
+		t.Fatalf("executeNodeConfigSnapshotRestore(missing) = %d, %v, want 404 ErrConfigArchiveNotFound", status, err)
+	}
+}
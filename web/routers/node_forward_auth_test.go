@@ -0,0 +1,51 @@
+package routers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/djylb/nps/lib/file"
+	"github.com/gin-gonic/gin"
+)
+
+func TestNodeForwardAuthEndpointIsValidated(t *testing.T) {
+	resetTestDB(t)
+	loadRecoveredNodeConfig(t, "run_mode=node\nnode_token=secret\nweb_username=admin\nweb_password=secret\n")
+	client := &file.Client{Id: 8, VerifyKey: "vk-8", Status: true, Cnf: &file.Config{}, Flow: &file.Flow{}}
+	if err := file.GetDb().NewClient(client); err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	swapRecoveredNodeStore(t, file.NewLocalStore())
+
+	gin.SetMode(gin.TestMode)
+	runtime := NewRuntime(nil)
+	if runtime.Err != nil {
+		t.Fatalf("NewRuntime() error = %v", runtime.Err)
+	}
+	defer runtime.Stop()
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Node-Token", "secret")
+		resp := httptest.NewRecorder()
+		runtime.Handler.ServeHTTP(resp, req)
+		return resp
+	}
+
+	if resp := serve(http.MethodPost, "/api/hosts", `{"client_id":8,"host":"fa.example.com","target":"127.0.0.1:8080","forward_auth":"authelia:9091/api/verify"}`); resp.Code != http.StatusBadRequest ||
+		!strings.Contains(resp.Body.String(), "invalid_forward_auth") {
+		t.Fatalf("invalid host endpoint status = %d body=%s", resp.Code, resp.Body.String())
+	}
+	resp := serve(http.MethodPost, "/api/hosts", `{"client_id":8,"host":"fa.example.com","target":"127.0.0.1:8080","forward_auth":" http://authelia:9091/api/verify ","forward_auth_headers":"remote-user, remote-groups"}`)
+	if resp.Code != http.StatusOK || !strings.Contains(resp.Body.String(), `"forward_auth":"http://authelia:9091/api/verify"`) ||
+		!strings.Contains(resp.Body.String(), `"forward_auth_headers":"Remote-User,Remote-Groups"`) {
+		t.Fatalf("host create status = %d body=%s", resp.Code, resp.Body.String())
+	}
+	if resp := serve(http.MethodPost, "/api/tunnels", `{"client_id":8,"mode":"mixProxy","port":0,"forward_auth":"ftp://auth"}`); resp.Code != http.StatusBadRequest ||
+		!strings.Contains(resp.Body.String(), "invalid_forward_auth") {
+		t.Fatalf("invalid tunnel endpoint status = %d body=%s", resp.Code, resp.Body.String())
+	}
+}
@@ -0,0 +1,80 @@
+package routers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/djylb/nps/lib/file"
+	"github.com/gin-gonic/gin"
+)
+
+func TestInitNodeResourceHistoryRecordsActorAndReverts(t *testing.T) {
+	resetTestDB(t)
+	loadRecoveredNodeConfig(t, "run_mode=node\nnode_token=secret\nweb_username=admin\nweb_password=secret\n")
+	history, err := file.OpenRevisionHistory(filepath.Join(t.TempDir(), "history"), file.RevisionHistoryOptions{})
+	if err != nil {
+		t.Fatalf("OpenRevisionHistory() error = %v", err)
+	}
+	previous := file.ReplaceRevisionHistory(history)
+	t.Cleanup(func() { file.ReplaceRevisionHistory(previous) })
+	if err := file.GetDb().NewClient(&file.Client{Id: 7, VerifyKey: "vk-7", Remark: "before", Revision: 1, Status: true, Cnf: &file.Config{}, Flow: &file.Flow{}}); err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	if err := history.RecordClient(mustGetRouterTestClient(t, 7)); err != nil {
+		t.Fatalf("RecordClient() error = %v", err)
+	}
+	swapRecoveredNodeStore(t, file.NewLocalStore())
+
+	gin.SetMode(gin.TestMode)
+	handler := Init()
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Node-Token", "secret")
+		req.Header.Set("X-Request-ID", "req-history")
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		return resp
+	}
+
+	if resp := serve(http.MethodPost, "/api/clients/7/actions/update", `{"verify_key":"vk-7","remark":"after"}`); resp.Code != http.StatusOK {
+		t.Fatalf("update status = %d body=%s", resp.Code, resp.Body.String())
+	}
+	resp := serve(http.MethodGet, "/api/clients/7/history", "")
+	if resp.Code != http.StatusOK {
+		t.Fatalf("history status = %d body=%s", resp.Code, resp.Body.String())
+	}
+	body := resp.Body.String()
+	if !strings.Contains(body, `"action":"update"`) || !strings.Contains(body, `"request_id":"req-history"`) ||
+		!strings.Contains(body, `{"field":"Remark","before":"before","after":"after"}`) {
+		t.Fatalf("history body = %s", body)
+	}
+	if strings.Count(body, `"source":"node-api"`) != 1 {
+		t.Fatalf("revision 1 was written outside the request and must stay unattributed: %s", body)
+	}
+
+	resp = serve(http.MethodPost, "/api/clients/7/actions/revert", `{"revision":1}`)
+	if resp.Code != http.StatusOK || !strings.Contains(resp.Body.String(), `"from_revision":1`) {
+		t.Fatalf("revert status = %d body=%s", resp.Code, resp.Body.String())
+	}
+	if client := mustGetRouterTestClient(t, 7); client.Remark != "before" {
+		t.Fatalf("client remark after revert = %q, want before", client.Remark)
+	}
+	if resp := serve(http.MethodPost, "/api/clients/7/actions/revert", `{"revision":99}`); resp.Code != http.StatusNotFound ||
+		!strings.Contains(resp.Body.String(), "revision_not_found") {
+		t.Fatalf("missing revision status = %d body=%s", resp.Code, resp.Body.String())
+	}
+}
+
+func mustGetRouterTestClient(t *testing.T, id int) *file.Client {
+	t.Helper()
+	client, err := file.GetDb().GetClient(id)
+	if err != nil {
+		t.Fatalf("GetClient(%d) error = %v", id, err)
+	}
+	return client
+}
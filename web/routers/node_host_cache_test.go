@@ -0,0 +1,84 @@
+package routers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/djylb/nps/lib/edgecache"
+	"github.com/djylb/nps/lib/file"
+	"github.com/gin-gonic/gin"
+)
+
+func TestNodePurgeHostCacheRemovesPrefixAndEmitsEvent(t *testing.T) {
+	resetTestDB(t)
+	loadRecoveredNodeConfig(t, "run_mode=node\nnode_token=secret\nweb_username=admin\nweb_password=secret\n")
+	client := &file.Client{Id: 7, VerifyKey: "vk-7", Status: true, Cnf: &file.Config{}, Flow: &file.Flow{}}
+	if err := file.GetDb().NewClient(client); err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	host := &file.Host{Id: 41, Host: "static.example.com", Location: "/", Scheme: "http", Client: client, Flow: &file.Flow{}, Cache: true,
+		Target: &file.Target{TargetStr: "127.0.0.1:8080"}}
+	if err := file.GetDb().NewHost(host); err != nil {
+		t.Fatalf("NewHost() error = %v", err)
+	}
+	swapRecoveredNodeStore(t, file.NewLocalStore())
+
+	store := edgecache.Default()
+	store.Configure(edgecache.Options{MemoryBytes: 1 << 20})
+	t.Cleanup(func() {
+		store.ForgetHost(41)
+		store.Configure(edgecache.Options{})
+	})
+	for _, path := range []string{"/assets/app.js", "/assets/app.css", "/index.html"} {
+		store.Store("static.example.com"+path, nil, nil, &edgecache.Entry{
+			HostID: 41, Path: path, Status: http.StatusOK, Header: http.Header{}, Body: []byte(path), Expires: time.Now().Add(time.Minute),
+		})
+	}
+	store.RecordHit(41)
+	store.RecordMiss(41)
+
+	gin.SetMode(gin.TestMode)
+	runtime := NewRuntime(nil)
+	if runtime.Err != nil {
+		t.Fatalf("NewRuntime() error = %v", runtime.Err)
+	}
+	defer runtime.Stop()
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Node-Token", "secret")
+		resp := httptest.NewRecorder()
+		runtime.Handler.ServeHTTP(resp, req)
+		return resp
+	}
+
+	if resp := serve(http.MethodPost, "/api/hosts/41/actions/purge-cache", `{"path":"assets"}`); resp.Code != http.StatusBadRequest ||
+		!strings.Contains(resp.Body.String(), "invalid_cache_path") {
+		t.Fatalf("invalid purge status = %d body=%s", resp.Code, resp.Body.String())
+	}
+	resp := serve(http.MethodPost, "/api/hosts/41/actions/purge-cache", `{"path":"/assets/"}`)
+	if resp.Code != http.StatusOK || !strings.Contains(resp.Body.String(), `"purged":2`) {
+		t.Fatalf("purge status = %d body=%s", resp.Code, resp.Body.String())
+	}
+	if store.Lookup("static.example.com/assets/app.js", nil) != nil || store.Lookup("static.example.com/index.html", nil) == nil {
+		t.Fatal("purge did not remove exactly the /assets/ entries")
+	}
+	if resp := serve(http.MethodGet, "/api/hosts/41", ""); resp.Code != http.StatusOK ||
+		!strings.Contains(resp.Body.String(), `"cache_hits":1`) || !strings.Contains(resp.Body.String(), `"cache_misses":1`) {
+		t.Fatalf("host status = %d body=%s", resp.Code, resp.Body.String())
+	}
+
+	found := false
+	for _, event := range runtime.State.NodeEventLog.Query(0, 0, nil).Items {
+		if event.Name == "host.cache_purged" && event.Fields["id"] == 41 && event.Fields["purged"] == 2 {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatal("host.cache_purged event was not emitted")
+	}
+}
@@ -0,0 +1,66 @@
+package routers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/djylb/nps/lib/file"
+	"github.com/gin-gonic/gin"
+)
+
+func TestInitNodeClientLabelSelectorAndBulkUpdate(t *testing.T) {
+	resetTestDB(t)
+	loadRecoveredNodeConfig(t, "run_mode=node\nnode_token=secret\nweb_username=admin\nweb_password=secret\n")
+	for _, client := range []*file.Client{
+		{Id: 7, VerifyKey: "label-a", Remark: "staging-a", Status: true, Cnf: &file.Config{}, Flow: &file.Flow{}, Labels: map[string]string{"env": "staging"}},
+		{Id: 8, VerifyKey: "label-b", Remark: "prod-b", Status: true, Cnf: &file.Config{}, Flow: &file.Flow{}, Labels: map[string]string{"env": "prod"}},
+		{Id: 9, VerifyKey: "label-c", Remark: "staging-c", Status: true, Cnf: &file.Config{}, Flow: &file.Flow{}, Labels: map[string]string{"env": "staging"}},
+	} {
+		if err := file.GetDb().NewClient(client); err != nil {
+			t.Fatalf("NewClient(%d) error = %v", client.Id, err)
+		}
+	}
+	swapRecoveredNodeStore(t, file.NewLocalStore())
+
+	gin.SetMode(gin.TestMode)
+	handler := Init()
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Node-Token", "secret")
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		return resp
+	}
+
+	list := serve(http.MethodGet, "/api/clients?selector=env%3Dstaging", "")
+	if list.Code != http.StatusOK {
+		t.Fatalf("GET /api/clients status = %d body=%s", list.Code, list.Body.String())
+	}
+	body := list.Body.String()
+	if !strings.Contains(body, "staging-a") || !strings.Contains(body, "staging-c") || strings.Contains(body, "prod-b") ||
+		!strings.Contains(body, `"labels":{"env":"staging"}`) {
+		t.Fatalf("GET /api/clients?selector body = %s", body)
+	}
+
+	bulk := serve(http.MethodPost, "/api/clients/actions/bulk", `{"selector":"env=staging","action":"update","labels":{"team":"payments"}}`)
+	if bulk.Code != http.StatusOK {
+		t.Fatalf("POST /api/clients/actions/bulk status = %d body=%s", bulk.Code, bulk.Body.String())
+	}
+	if body := bulk.Body.String(); !strings.Contains(body, `"matched":[7,9]`) || !strings.Contains(body, `"succeeded":[7,9]`) {
+		t.Fatalf("POST /api/clients/actions/bulk body = %s", body)
+	}
+	if client, err := file.GetDb().GetClient(9); err != nil || client.Labels["team"] != "payments" || client.Labels["env"] != "staging" {
+		t.Fatalf("client 9 after bulk update = %+v, %v", client, err)
+	}
+
+	if resp := serve(http.MethodGet, "/api/clients?selector=env%3D%3D%3D", ""); resp.Code != http.StatusBadRequest {
+		t.Fatalf("GET /api/clients with bad selector status = %d body=%s", resp.Code, resp.Body.String())
+	}
+	if resp := serve(http.MethodPost, "/api/clients/actions/bulk", `{"action":"stop"}`); resp.Code != http.StatusBadRequest {
+		t.Fatalf("POST /api/clients/actions/bulk without selector status = %d body=%s", resp.Code, resp.Body.String())
+	}
+}
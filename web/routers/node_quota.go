@@ -0,0 +1,69 @@
+package routers
+
+import (
+	"time"
+
+	"github.com/djylb/nps/lib/file"
+	"github.com/djylb/nps/lib/logs"
+	webapi "github.com/djylb/nps/web/api"
+)
+
+var nodeQuotaCheckInterval = time.Minute
+
+// StartNodeQuotaScheduler resets the traffic of every resource whose quota
+// period has ended and reports each reset on the node event stream. Periods
+// that ended while the node was down are reset on start. After the resets it
+// reports the flow and expiry thresholds crossed since the last check.
+func StartNodeQuotaScheduler(state *State) func() {
+	if state == nil || state.App == nil || state.App.Services.QuotaPlans == nil {
+		return func() {}
+	}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(nodeQuotaCheckInterval)
+		defer ticker.Stop()
+		alerts := newNodeQuotaAlertTracker(nodeQuotaAlertPersistencePath())
+		runNodeQuotaResets(state, time.Now())
+		alerts.check(state, time.Now())
+		for {
+			select {
+			case <-stop:
+				return
+			case now := <-ticker.C:
+				runNodeQuotaResets(state, now)
+				alerts.check(state, now)
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+	}
+}
+
+func runNodeQuotaResets(state *State, now time.Time) {
+	for _, reset := range state.App.Services.QuotaPlans.ResetDue(now) {
+		logs.Info("quota period of %s %d reset, used in %d out %d bytes, carry %d bytes",
+			reset.Resource, reset.ID, reset.InBytes, reset.OutBytes, reset.CarryBytes)
+		emitNodeManagementEvent(state, nil, nodeQuotaResetEvent(reset))
+	}
+}
+
+func nodeQuotaResetEvent(reset file.QuotaReset) webapi.Event {
+	return webapi.Event{
+		Name:     reset.Resource + ".quota_reset",
+		Resource: reset.Resource,
+		Action:   "quota_reset",
+		Fields: map[string]interface{}{
+			"id":           reset.ID,
+			"period_start": reset.PeriodStart,
+			"next_reset":   reset.NextReset,
+			"in_bytes":     reset.InBytes,
+			"out_bytes":    reset.OutBytes,
+			"limit_bytes":  reset.LimitBytes,
+			"carry_bytes":  reset.CarryBytes,
+		},
+	}
+}
@@ -0,0 +1,187 @@
+package routers
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/djylb/nps/lib/file"
+	"github.com/djylb/nps/lib/logs"
+	webapi "github.com/djylb/nps/web/api"
+)
+
+const (
+	nodeQuotaAlertFlow   = "flow"
+	nodeQuotaAlertExpire = "expire"
+)
+
+type nodeQuotaAlertPersistedState struct {
+	Version int64          `json:"version,omitempty"`
+	Fired   map[string]int `json:"fired"`
+}
+
+// nodeQuotaAlertTracker remembers the most severe threshold already reported
+// for every resource, so each threshold is reported once. A resource falls
+// back below a threshold when its period resets, its traffic is cleared or
+// its limit is raised; the threshold is then armed again.
+type nodeQuotaAlertTracker struct {
+	path  string
+	fired map[string]int
+}
+
+func newNodeQuotaAlertTracker(path string) *nodeQuotaAlertTracker {
+	tracker := &nodeQuotaAlertTracker{
+		path:  path,
+		fired: make(map[string]int),
+	}
+	tracker.load()
+	return tracker
+}
+
+func (t *nodeQuotaAlertTracker) load() {
+	if t.path == "" {
+		return
+	}
+	var persisted nodeQuotaAlertPersistedState
+	if err := readNodeRuntimeState(t.path, &persisted); err != nil {
+		if !isIgnorableNodeRuntimeStateError(err) {
+			logs.Warn("load node quota alert state %s error: %v", t.path, err)
+		}
+		return
+	}
+	if !nodeRuntimeStateVersionSupported(int(persisted.Version)) {
+		logs.Warn("load node quota alert state %s error: unsupported version=%d", t.path, persisted.Version)
+		return
+	}
+	for key, threshold := range persisted.Fired {
+		t.fired[key] = threshold
+	}
+}
+
+func (t *nodeQuotaAlertTracker) persist() {
+	writeNodeRuntimeState(t.path, nodeQuotaAlertPersistedState{
+		Version: nodeRuntimeStateVersion,
+		Fired:   t.fired,
+	})
+}
+
+// observe records the threshold a resource has currently crossed and reports
+// whether it is more severe than the one reported before. crossed is false
+// when no threshold is crossed; worse orders two thresholds by severity.
+func (t *nodeQuotaAlertTracker) observe(key string, threshold int, crossed bool, worse func(a, b int) bool) (bool, bool) {
+	previous, fired := t.fired[key]
+	if !crossed {
+		if fired {
+			delete(t.fired, key)
+		}
+		return false, fired
+	}
+	if fired && !worse(threshold, previous) {
+		if threshold != previous {
+			t.fired[key] = threshold
+			return false, true
+		}
+		return false, false
+	}
+	t.fired[key] = threshold
+	return true, true
+}
+
+// check compares every limited resource with the configured thresholds and
+// emits one event per newly crossed threshold.
+func (t *nodeQuotaAlertTracker) check(state *State, now time.Time) {
+	cfg := state.CurrentConfig().Runtime
+	percents, days := cfg.NodeQuotaAlertPercents, cfg.NodeExpireAlertDays
+	if len(percents) == 0 && len(days) == 0 {
+		if len(t.fired) > 0 {
+			t.fired = make(map[string]int)
+			t.persist()
+		}
+		return
+	}
+	changed := false
+	seen := make(map[string]struct{})
+	for _, usage := range state.App.Services.QuotaPlans.Usages() {
+		prefix := usage.Resource + ":" + strconv.Itoa(usage.ID) + ":"
+		if usage.LimitBytes > 0 && len(percents) > 0 {
+			key := prefix + nodeQuotaAlertFlow
+			seen[key] = struct{}{}
+			threshold, crossed := crossedQuotaPercent(percents, usage.UsedBytes, usage.LimitBytes)
+			emit, dirty := t.observe(key, threshold, crossed, func(a, b int) bool { return a > b })
+			if emit {
+				emitNodeManagementEvent(state, nil, nodeQuotaThresholdEvent(usage, threshold))
+			}
+			changed = changed || dirty
+		}
+		if usage.ExpireAt > 0 && len(days) > 0 {
+			key := prefix + nodeQuotaAlertExpire
+			seen[key] = struct{}{}
+			threshold, crossed := crossedExpireDays(days, usage.ExpireAt, now)
+			emit, dirty := t.observe(key, threshold, crossed, func(a, b int) bool { return a < b })
+			if emit {
+				emitNodeManagementEvent(state, nil, nodeExpireThresholdEvent(usage, threshold, now))
+			}
+			changed = changed || dirty
+		}
+	}
+	for key := range t.fired {
+		if _, ok := seen[key]; !ok {
+			delete(t.fired, key)
+			changed = true
+		}
+	}
+	if changed {
+		t.persist()
+	}
+}
+
+// crossedQuotaPercent returns the highest percentage of limit that used has
+// reached. percents is sorted ascending.
+func crossedQuotaPercent(percents []int, used, limit int64) (int, bool) {
+	for i := len(percents) - 1; i >= 0; i-- {
+		if used*100 >= limit*int64(percents[i]) {
+			return percents[i], true
+		}
+	}
+	return 0, false
+}
+
+// crossedExpireDays returns the smallest number of days before expireAt that
+// now has passed. days is sorted ascending; 0 means the expiry itself.
+func crossedExpireDays(days []int, expireAt int64, now time.Time) (int, bool) {
+	left := expireAt - now.Unix()
+	for _, day := range days {
+		if left <= int64(day)*int64(24*time.Hour/time.Second) {
+			return day, true
+		}
+	}
+	return 0, false
+}
+
+func nodeQuotaThresholdEvent(usage file.QuotaUsage, percent int) webapi.Event {
+	return webapi.Event{
+		Name:     usage.Resource + ".quota_threshold",
+		Resource: usage.Resource,
+		Action:   "quota_threshold",
+		Fields: map[string]interface{}{
+			"id":                usage.ID,
+			"threshold_percent": percent,
+			"used_bytes":        usage.UsedBytes,
+			"limit_bytes":       usage.LimitBytes,
+			"remaining_bytes":   max(usage.LimitBytes-usage.UsedBytes, 0),
+		},
+	}
+}
+
+func nodeExpireThresholdEvent(usage file.QuotaUsage, days int, now time.Time) webapi.Event {
+	return webapi.Event{
+		Name:     usage.Resource + ".expire_threshold",
+		Resource: usage.Resource,
+		Action:   "expire_threshold",
+		Fields: map[string]interface{}{
+			"id":             usage.ID,
+			"threshold_days": days,
+			"expire_at":      usage.ExpireAt,
+			"expired":        usage.ExpireAt <= now.Unix(),
+		},
+	}
+}
@@ -0,0 +1,141 @@
+package routers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/djylb/nps/lib/file"
+	"github.com/gin-gonic/gin"
+)
+
+func TestNodeQuotaPlanResetsTrafficAndEmitsEvent(t *testing.T) {
+	resetTestDB(t)
+	loadRecoveredNodeConfig(t, "run_mode=node\nnode_token=secret\nweb_username=admin\nweb_password=secret\n")
+	if err := file.GetDb().NewClient(&file.Client{Id: 7, VerifyKey: "vk-7", Status: true, Cnf: &file.Config{}, Flow: &file.Flow{}, FlowLimit: 1 << 20}); err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	swapRecoveredNodeStore(t, file.NewLocalStore())
+
+	gin.SetMode(gin.TestMode)
+	runtime := NewRuntime(nil)
+	if runtime.Err != nil {
+		t.Fatalf("NewRuntime() error = %v", runtime.Err)
+	}
+	defer runtime.Stop()
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Node-Token", "secret")
+		resp := httptest.NewRecorder()
+		runtime.Handler.ServeHTTP(resp, req)
+		return resp
+	}
+
+	resp := serve(http.MethodPost, "/api/clients/7/actions/quota", `{"period":"daily","timezone":"UTC","rollover":true}`)
+	if resp.Code != http.StatusOK || !strings.Contains(resp.Body.String(), `"period":"daily"`) {
+		t.Fatalf("set quota status = %d body=%s", resp.Code, resp.Body.String())
+	}
+	if resp := serve(http.MethodPost, "/api/clients/7/actions/quota", `{"period":"weekly","day":9}`); resp.Code != http.StatusBadRequest ||
+		!strings.Contains(resp.Body.String(), "invalid_quota_plan") {
+		t.Fatalf("invalid quota status = %d body=%s", resp.Code, resp.Body.String())
+	}
+
+	client := mustGetRouterTestClient(t, 7)
+	if err := client.ObserveServiceTraffic(1024, 1024); err != nil {
+		t.Fatalf("ObserveServiceTraffic() error = %v", err)
+	}
+	if resp := serve(http.MethodGet, "/api/clients/7/quota", ""); resp.Code != http.StatusOK ||
+		!strings.Contains(resp.Body.String(), `"used_bytes":2048`) {
+		t.Fatalf("quota status = %d body=%s", resp.Code, resp.Body.String())
+	}
+
+	runNodeQuotaResets(runtime.State, time.Now().Add(24*time.Hour))
+	if _, _, total := client.TotalTrafficTotals(); total != 0 {
+		t.Fatalf("client traffic after reset = %d, want 0", total)
+	}
+	events := runtime.State.NodeEventLog.Query(0, 0, nil).Items
+	found := false
+	for _, event := range events {
+		if event.Name == "client.quota_reset" && event.Fields["id"] == 7 && event.Fields["carry_bytes"] == int64(1<<20-2048) {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("events = %+v, want client.quota_reset for client 7", events)
+	}
+}
+
+func TestNodeQuotaAlertsReportEachThresholdOnce(t *testing.T) {
+	resetTestDB(t)
+	loadRecoveredNodeConfig(t, "run_mode=node\nnode_token=secret\nnode_quota_alert_percents=80,100\nnode_expire_alert_days=7,1\n")
+	now := time.Now()
+	if err := file.GetDb().NewClient(&file.Client{Id: 7, VerifyKey: "vk-7", Status: true, Cnf: &file.Config{}, Flow: &file.Flow{}, FlowLimit: 1000}); err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	if err := file.GetDb().NewTask(&file.Tunnel{Id: 3, Port: 18080, Mode: "tcp", Status: true, Client: mustGetRouterTestClient(t, 7), Flow: &file.Flow{},
+		Target: &file.Target{TargetStr: "127.0.0.1:80"}, ExpireAt: now.Add(72 * time.Hour).Unix()}); err != nil {
+		t.Fatalf("NewTask() error = %v", err)
+	}
+	swapRecoveredNodeStore(t, file.NewLocalStore())
+
+	gin.SetMode(gin.TestMode)
+	runtime := NewRuntime(nil)
+	if runtime.Err != nil {
+		t.Fatalf("NewRuntime() error = %v", runtime.Err)
+	}
+	defer runtime.Stop()
+	tracker := newNodeQuotaAlertTracker(nodeQuotaAlertPersistencePath())
+	count := func(name string, match func(map[string]interface{}) bool) int {
+		total := 0
+		for _, event := range runtime.State.NodeEventLog.Query(0, 0, nil).Items {
+			if event.Name == name && match(event.Fields) {
+				total++
+			}
+		}
+		return total
+	}
+	client := mustGetRouterTestClient(t, 7)
+
+	if err := client.ObserveServiceTraffic(500, 350); err != nil {
+		t.Fatalf("ObserveServiceTraffic() error = %v", err)
+	}
+	tracker.check(runtime.State, now)
+	tracker.check(runtime.State, now)
+	if got := count("client.quota_threshold", func(fields map[string]interface{}) bool {
+		return fields["id"] == 7 && fields["threshold_percent"] == 80 && fields["used_bytes"] == int64(850)
+	}); got != 1 {
+		t.Fatalf("80%% events = %d, want exactly one", got)
+	}
+	if got := count("tunnel.expire_threshold", func(fields map[string]interface{}) bool {
+		return fields["id"] == 3 && fields["threshold_days"] == 7
+	}); got != 1 {
+		t.Fatalf("7 day events = %d, want exactly one", got)
+	}
+
+	// A reloaded tracker keeps what was already reported.
+	tracker = newNodeQuotaAlertTracker(nodeQuotaAlertPersistencePath())
+	_ = client.ObserveServiceTraffic(150, 0)
+	tracker.check(runtime.State, now.Add(60*time.Hour))
+	if got := count("client.quota_threshold", func(fields map[string]interface{}) bool { return fields["threshold_percent"] == 100 }); got != 1 {
+		t.Fatalf("100%% events = %d, want one", got)
+	}
+	if got := count("tunnel.expire_threshold", func(fields map[string]interface{}) bool { return fields["threshold_days"] == 1 }); got != 1 {
+		t.Fatalf("1 day events = %d, want one", got)
+	}
+	if got := count("client.quota_threshold", func(fields map[string]interface{}) bool { return fields["threshold_percent"] == 80 }); got != 1 {
+		t.Fatalf("80%% events after reload = %d, want still one", got)
+	}
+
+	// Clearing the traffic arms the thresholds again.
+	client.ResetTraffic()
+	tracker.check(runtime.State, now)
+	_ = client.ObserveServiceTraffic(900, 0)
+	tracker.check(runtime.State, now)
+	if got := count("client.quota_threshold", func(fields map[string]interface{}) bool { return fields["threshold_percent"] == 80 }); got != 2 {
+		t.Fatalf("80%% events after reset = %d, want two", got)
+	}
+}
@@ -0,0 +1,63 @@
+package routers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/djylb/nps/lib/file"
+	"github.com/gin-gonic/gin"
+)
+
+func TestInitNodeDeleteClientRouteMovesClientToTrash(t *testing.T) {
+	resetTestDB(t)
+	loadRecoveredNodeConfig(t, "run_mode=node\nnode_token=secret\nweb_username=admin\nweb_password=secret\n")
+	bin, err := file.OpenRecycleBin(filepath.Join(t.TempDir(), "trash.json"), file.RecycleBinOptions{Retention: time.Hour})
+	if err != nil {
+		t.Fatalf("OpenRecycleBin() error = %v", err)
+	}
+	previous := file.ReplaceRecycleBin(bin)
+	t.Cleanup(func() { file.ReplaceRecycleBin(previous) })
+	if err := file.GetDb().NewClient(&file.Client{Id: 7, VerifyKey: "trash-client", Remark: "edge", Status: true, Cnf: &file.Config{}, Flow: &file.Flow{}}); err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	swapRecoveredNodeStore(t, file.NewLocalStore())
+
+	gin.SetMode(gin.TestMode)
+	handler := Init()
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Node-Token", "secret")
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		if resp.Code != http.StatusOK {
+			t.Fatalf("%s %s status = %d, want 200 body=%s", method, path, resp.Code, resp.Body.String())
+		}
+		return resp
+	}
+
+	serve(http.MethodPost, "/api/clients/7/actions/delete", "{}")
+	if _, err := file.GetDb().GetClient(7); err == nil {
+		t.Fatal("deleted client should leave the live store")
+	}
+	list := serve(http.MethodGet, "/api/trash", "").Body.String()
+	if !strings.Contains(list, "\"total\":1") ||
+		!strings.Contains(list, "\"resource\":\"client\"") ||
+		!strings.Contains(list, "\"remark\":\"edge\"") ||
+		strings.Contains(list, "trash-client") {
+		t.Fatalf("GET /api/trash body = %s", list)
+	}
+
+	restored := serve(http.MethodPost, "/api/trash/actions/restore", `{"id":1}`).Body.String()
+	if !strings.Contains(restored, "\"action\":\"restore\"") || !strings.Contains(restored, "\"client_id\":7") {
+		t.Fatalf("POST /api/trash/actions/restore body = %s", restored)
+	}
+	if client, err := file.GetDb().GetClient(7); err != nil || client.VerifyKey != "trash-client" {
+		t.Fatalf("restored client = %+v, %v", client, err)
+	}
+}
@@ -0,0 +1,57 @@
+package routers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/djylb/nps/lib/file"
+	"github.com/gin-gonic/gin"
+)
+
+func TestInitNodeUsageSeriesReturnsRecordedTraffic(t *testing.T) {
+	resetTestDB(t)
+	loadRecoveredNodeConfig(t, "run_mode=node\nnode_token=secret\nweb_username=admin\nweb_password=secret\n")
+	if err := file.GetDb().NewClient(&file.Client{Id: 7, VerifyKey: "vk-7", Status: true, Cnf: &file.Config{}, Flow: &file.Flow{}}); err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	recorder := file.NewTrafficRecorder(filepath.Join(t.TempDir(), "usage"), file.TrafficSeriesOptions{})
+	previous := file.ReplaceTrafficRecorder(recorder)
+	t.Cleanup(func() { file.ReplaceTrafficRecorder(previous) })
+	if err := recorder.Sample(); err != nil {
+		t.Fatalf("Sample(baseline) error = %v", err)
+	}
+	if err := mustGetRouterTestClient(t, 7).ObserveServiceTraffic(4096, 1024); err != nil {
+		t.Fatalf("ObserveServiceTraffic() error = %v", err)
+	}
+	if err := recorder.Sample(); err != nil {
+		t.Fatalf("Sample() error = %v", err)
+	}
+	swapRecoveredNodeStore(t, file.NewLocalStore())
+
+	gin.SetMode(gin.TestMode)
+	handler := Init()
+	serve := func(path string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("X-Node-Token", "secret")
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		return resp
+	}
+
+	resp := serve("/api/usage/series?resource=client&id=7&resolution=minute")
+	if resp.Code != http.StatusOK {
+		t.Fatalf("usage series status = %d body=%s", resp.Code, resp.Body.String())
+	}
+	if body := resp.Body.String(); !strings.Contains(body, `"resolution":"minute"`) ||
+		!strings.Contains(body, `"totals":{"time":`) || !strings.Contains(body, `"in_bytes":4096,"out_bytes":1024,"total_bytes":5120`) {
+		t.Fatalf("usage series body = %s", body)
+	}
+	if resp := serve("/api/usage/series?resource=bucket"); resp.Code != http.StatusBadRequest ||
+		!strings.Contains(resp.Body.String(), "invalid_usage_query") {
+		t.Fatalf("invalid resource status = %d body=%s", resp.Code, resp.Body.String())
+	}
+}
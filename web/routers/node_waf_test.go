@@ -0,0 +1,61 @@
+package routers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/djylb/nps/lib/file"
+	"github.com/gin-gonic/gin"
+)
+
+func TestNodeWAFRulesAreValidatedAndKept(t *testing.T) {
+	resetTestDB(t)
+	loadRecoveredNodeConfig(t, "run_mode=node\nnode_token=secret\nweb_username=admin\nweb_password=secret\n")
+	client := &file.Client{Id: 8, VerifyKey: "vk-8", Status: true, Cnf: &file.Config{}, Flow: &file.Flow{}}
+	if err := file.GetDb().NewClient(client); err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	swapRecoveredNodeStore(t, file.NewLocalStore())
+
+	gin.SetMode(gin.TestMode)
+	runtime := NewRuntime(nil)
+	if runtime.Err != nil {
+		t.Fatalf("NewRuntime() error = %v", runtime.Err)
+	}
+	defer runtime.Stop()
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Node-Token", "secret")
+		resp := httptest.NewRecorder()
+		runtime.Handler.ServeHTTP(resp, req)
+		return resp
+	}
+
+	resp := serve(http.MethodPost, "/api/settings/global/actions/update", `{"entry_acl_mode":0,"waf_rules":"block path^=/.env\n\n  block path^=/wp-admin  "}`)
+	if resp.Code != http.StatusOK || !strings.Contains(resp.Body.String(), `"waf_rules":"block path^=/.env\nblock path^=/wp-admin"`) {
+		t.Fatalf("global update status = %d body=%s", resp.Code, resp.Body.String())
+	}
+	if resp := serve(http.MethodPost, "/api/settings/global/actions/update", `{"entry_acl_mode":0}`); resp.Code != http.StatusOK {
+		t.Fatalf("global ACL update status = %d body=%s", resp.Code, resp.Body.String())
+	}
+	if got := file.GetDb().GetGlobal().WafRules; got != "block path^=/.env\nblock path^=/wp-admin" {
+		t.Fatalf("global update without waf_rules changed them to %q", got)
+	}
+	if resp := serve(http.MethodPost, "/api/settings/global/actions/update", `{"entry_acl_mode":0,"waf_rules":"deny path^=/"}`); resp.Code != http.StatusBadRequest ||
+		!strings.Contains(resp.Body.String(), "invalid_waf_rules") {
+		t.Fatalf("invalid global rules status = %d body=%s", resp.Code, resp.Body.String())
+	}
+
+	if resp := serve(http.MethodPost, "/api/hosts", `{"client_id":8,"host":"waf.example.com","target":"127.0.0.1:8080","waf_rules":"block path~("}`); resp.Code != http.StatusBadRequest ||
+		!strings.Contains(resp.Body.String(), "invalid_waf_rules") || !strings.Contains(resp.Body.String(), "line 1") {
+		t.Fatalf("invalid host rules status = %d body=%s", resp.Code, resp.Body.String())
+	}
+	resp = serve(http.MethodPost, "/api/hosts", `{"client_id":8,"host":"waf.example.com","target":"127.0.0.1:8080","waf_rules":"challenge ua*=curl"}`)
+	if resp.Code != http.StatusOK || !strings.Contains(resp.Body.String(), `"waf_rules":"challenge ua*=curl"`) {
+		t.Fatalf("host create status = %d body=%s", resp.Code, resp.Body.String())
+	}
+}
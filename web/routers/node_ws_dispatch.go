@@ -15,6 +15,7 @@ import (
 	"time"
 
 	"github.com/djylb/nps/lib/file"
+	"github.com/djylb/nps/lib/logs"
 	"github.com/djylb/nps/lib/servercfg"
 	"github.com/djylb/nps/server"
 	webapi "github.com/djylb/nps/web/api"
@@ -44,6 +45,15 @@ type nodeConfigImportResponse struct {
 	ImportedAt  int64  `json:"imported_at,omitempty"`
 }
 
+type nodeConfigRestoreResponse struct {
+	Status       int    `json:"status"`
+	Msg          string `json:"msg"`
+	ConfigEpoch  string `json:"config_epoch,omitempty"`
+	RestoredTo   int64  `json:"restored_to"`
+	CheckpointAt int64  `json:"checkpoint_at"`
+	Changes      int    `json:"changes"`
+}
+
 type nodeConfigImportEnvelope struct {
 	Status int             `json:"status"`
 	Msg    string          `json:"msg"`
@@ -72,6 +82,10 @@ const (
 	nodeWSRouteChanges
 	nodeWSRouteCallbackQueue
 	nodeWSRouteConfigImport
+	nodeWSRouteConfigRestore
+	nodeWSRouteConfigSnapshots
+	nodeWSRouteConfigSnapshotCreate
+	nodeWSRouteConfigSnapshotRestore
 	nodeWSRouteBatch
 	nodeWSRouteCallbackQueueReplay
 	nodeWSRouteCallbackQueueClear
@@ -87,14 +101,18 @@ type nodeWSPrefixRequestRoute struct {
 }
 
 var nodeWSExactRequestRoutes = map[string]nodeWSManagedRouteKind{
-	"/system/changes":                 nodeWSRouteChanges,
-	"/callbacks/queue":                nodeWSRouteCallbackQueue,
-	"/system/import":                  nodeWSRouteConfigImport,
-	"/batch":                          nodeWSRouteBatch,
-	"/callbacks/queue/actions/replay": nodeWSRouteCallbackQueueReplay,
-	"/callbacks/queue/actions/clear":  nodeWSRouteCallbackQueueClear,
-	"/webhooks":                       nodeWSRouteWebhookCollection,
-	"/realtime/subscriptions":         nodeWSRouteRealtimeSubscriptionCollection,
+	"/system/changes":                   nodeWSRouteChanges,
+	"/callbacks/queue":                  nodeWSRouteCallbackQueue,
+	"/system/import":                    nodeWSRouteConfigImport,
+	"/system/restore":                   nodeWSRouteConfigRestore,
+	"/system/snapshots":                 nodeWSRouteConfigSnapshots,
+	"/system/snapshots/actions/create":  nodeWSRouteConfigSnapshotCreate,
+	"/system/snapshots/actions/restore": nodeWSRouteConfigSnapshotRestore,
+	"/batch":                            nodeWSRouteBatch,
+	"/callbacks/queue/actions/replay":   nodeWSRouteCallbackQueueReplay,
+	"/callbacks/queue/actions/clear":    nodeWSRouteCallbackQueueClear,
+	"/webhooks":                         nodeWSRouteWebhookCollection,
+	"/realtime/subscriptions":           nodeWSRouteRealtimeSubscriptionCollection,
 }
 
 var nodeWSPrefixRequestRoutes = []nodeWSPrefixRequestRoute{
@@ -366,6 +384,14 @@ func nodeWSManagedRouteKindForProtectedAction(spec webapi.ActionSpec) (nodeWSMan
 		return nodeWSRouteChanges, true
 	case "system/import":
 		return nodeWSRouteConfigImport, true
+	case "system/restore":
+		return nodeWSRouteConfigRestore, true
+	case "system_snapshots/list":
+		return nodeWSRouteConfigSnapshots, true
+	case "system_snapshots/create":
+		return nodeWSRouteConfigSnapshotCreate, true
+	case "system_snapshots/restore":
+		return nodeWSRouteConfigSnapshotRestore, true
 	case "callbacks_queue/list":
 		return nodeWSRouteCallbackQueue, true
 	case "callbacks_queue/replay":
@@ -443,6 +469,14 @@ func dispatchNodeWSManagedRouteKind(request *nodeWSDispatchRequest, kind nodeWSM
 		return dispatchNodeWSCallbackQueueRoute(request)
 	case nodeWSRouteConfigImport:
 		return dispatchNodeWSConfigImportRoute(request)
+	case nodeWSRouteConfigRestore:
+		return dispatchNodeWSConfigRestore(request)
+	case nodeWSRouteConfigSnapshots:
+		return dispatchNodeWSConfigSnapshotList(request)
+	case nodeWSRouteConfigSnapshotCreate:
+		return dispatchNodeWSConfigSnapshotCreate(request)
+	case nodeWSRouteConfigSnapshotRestore:
+		return dispatchNodeWSConfigSnapshotRestore(request)
 	case nodeWSRouteBatch:
 		return dispatchNodeWSBatchRoute(request)
 	case nodeWSRouteCallbackQueueReplay:
@@ -737,6 +771,19 @@ func dispatchNodeWSConfigImport(request *nodeWSDispatchRequest) nodeWSFrame {
 	return response
 }
 
+func dispatchNodeWSConfigRestore(request *nodeWSDispatchRequest) nodeWSFrame {
+	operation := startNodeWSOperation(request.state, request.ctx, "config_restore", []string{canonicalNodeOperationPath(request.state, request.frame.Path)})
+	payload, status, err := executeNodeConfigRestore(request.ctx.BaseContext(), request.state, request.actor, request.metadata, request.frame.Body)
+	operation.record(status, err)
+	if err != nil {
+		writeWSManagementErrorResponse(request.ctx, status, err)
+		return buildNodeWSResponseFrame(request.response, request.ctx)
+	}
+	response := buildNodeWSManagementDataFrame(request.response, request.metadata, payload.ConfigEpoch, time.Now().Unix(), payload)
+	operation.setFrameHeader(&response)
+	return response
+}
+
 func dispatchNodeWSCallbackQueueMutation(request *nodeWSDispatchRequest, action string) nodeWSFrame {
 	scope := actorNodeScopeWithAuthz(request.state.Authorization(), request.actor)
 	operationName := "callback_queue_" + strings.TrimSpace(action)
@@ -1477,6 +1524,44 @@ func nodeConfigImportHTTPHandler(state *State) gin.HandlerFunc {
 	}
 }
 
+func nodeConfigRestoreHTTPHandler(state *State) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		startedAt := time.Now()
+		operationID := currentNodeHTTPOperationID(c)
+		setNodeHTTPOperationHeader(c, operationID)
+		payload, status, err := executeNodeConfigRestore(
+			c.Request.Context(),
+			state,
+			currentActor(c),
+			currentRequestMetadata(c),
+			framework.RequestRawBodyView(c),
+		)
+		recordNodeOperation(
+			state,
+			currentActor(c),
+			currentRequestMetadata(c),
+			operationID,
+			"config_restore",
+			startedAt,
+			1,
+			nodeOperationSuccessCount(status),
+			nodeOperationErrorCount(status),
+			[]string{canonicalNodeOperationPath(state, c.Request.URL.Path)},
+		)
+		if err != nil {
+			state.RuntimeStatus().NoteOperation("config_restore", err)
+			c.JSON(status, webapi.ManagementErrorResponseForStatus(status, err))
+			return
+		}
+		state.RuntimeStatus().NoteOperation("config_restore", nil)
+		c.JSON(status, webapi.ManagementDataResponse{
+			Data: payload,
+			Meta: webapi.ManagementResponseMetaForRequest(currentRequestMetadata(c), time.Now().Unix(), payload.ConfigEpoch),
+		})
+		maybeInvalidateRealtimeSessions(state, payload.ConfigEpoch)
+	}
+}
+
 func executeNodeConfigImport(ctx context.Context, state *State, actor *webapi.Actor, metadata webapi.RequestMetadata, raw []byte) (nodeConfigImportResponse, int, error) {
 	if state == nil || state.App == nil {
 		return nodeConfigImportResponse{}, http.StatusInternalServerError, errors.New("node runtime is unavailable")
@@ -1489,10 +1574,67 @@ func executeNodeConfigImport(ctx context.Context, state *State, actor *webapi.Ac
 	if err != nil {
 		return nodeConfigImportResponse{}, http.StatusBadRequest, err
 	}
+	configEpoch, status, err := replaceNodeConfigSnapshot(state, snapshot, "import")
+	if err != nil {
+		return nodeConfigImportResponse{}, status, err
+	}
+	recordImportedConfigEvent(ctx, state, actor, metadata, configEpoch)
+
+	return nodeConfigImportResponse{
+		Status:      1,
+		Msg:         "config import success",
+		ConfigEpoch: configEpoch,
+		ImportedAt:  time.Now().Unix(),
+	}, http.StatusOK, nil
+}
+
+func executeNodeConfigRestore(ctx context.Context, state *State, actor *webapi.Actor, metadata webapi.RequestMetadata, raw []byte) (nodeConfigRestoreResponse, int, error) {
+	if state == nil || state.App == nil {
+		return nodeConfigRestoreResponse{}, http.StatusInternalServerError, errors.New("node runtime is unavailable")
+	}
+	scope := actorNodeScopeWithAuthz(state.Authorization(), actor)
+	if !scope.CanExportConfig() {
+		return nodeConfigRestoreResponse{}, http.StatusForbidden, webservice.ErrForbidden
+	}
+	journal := file.CurrentChangeJournal()
+	if journal == nil {
+		return nodeConfigRestoreResponse{}, http.StatusNotImplemented, file.ErrChangeJournalDisabled
+	}
+	point, err := decodeNodeConfigRestorePoint(raw)
+	if err != nil {
+		return nodeConfigRestoreResponse{}, http.StatusBadRequest, err
+	}
+	snapshot, replay, err := file.ReplayChangeJournal(journal.Dir(), point)
+	if err != nil {
+		if errors.Is(err, file.ErrRestorePointUnavailable) {
+			return nodeConfigRestoreResponse{}, http.StatusBadRequest, err
+		}
+		return nodeConfigRestoreResponse{}, http.StatusInternalServerError, err
+	}
+	configEpoch, status, err := replaceNodeConfigSnapshot(state, snapshot, "restore")
+	if err != nil {
+		return nodeConfigRestoreResponse{}, status, err
+	}
+	recordRestoredConfigEvent(ctx, state, actor, metadata, configEpoch, point)
+
+	return nodeConfigRestoreResponse{
+		Status:       1,
+		Msg:          "config restore success",
+		ConfigEpoch:  configEpoch,
+		RestoredTo:   point.Unix(),
+		CheckpointAt: replay.CheckpointAt / int64(time.Second),
+		Changes:      replay.Entries,
+	}, http.StatusOK, nil
+}
+
+// replaceNodeConfigSnapshot swaps the whole node config for snapshot with the
+// runtime stopped, rolling back to the previous config on failure. The new
+// state is checkpointed into the change journal under source.
+func replaceNodeConfigSnapshot(state *State, snapshot *file.ConfigSnapshot, source string) (string, int, error) {
 	storage := state.NodeStorage()
 	rollback, rollbackErr := currentNodeConfigSnapshot(storage)
 	if rollbackErr != nil {
-		return nodeConfigImportResponse{}, nodeConfigImportErrorStatus(rollbackErr), rollbackErr
+		return "", nodeConfigImportErrorStatus(rollbackErr), rollbackErr
 	}
 
 	stopRuntimePreserveStatus()
@@ -1507,21 +1649,33 @@ func executeNodeConfigImport(ctx context.Context, state *State, actor *webapi.Ac
 			_ = flushImportedConfigStorage(storage)
 		}
 		startRuntimeFromDB()
-		return nodeConfigImportResponse{}, nodeConfigImportErrorStatus(importErr), importErr
+		return "", nodeConfigImportErrorStatus(importErr), importErr
+	}
+	if err := file.CurrentChangeJournal().Checkpoint(source); err != nil {
+		logs.Warn("checkpoint change journal after config %s error: %v", source, err)
 	}
 
 	state.ResetProtocolState()
 	configEpoch := state.RuntimeIdentity().RotateConfigEpoch()
 	clearRuntimeCaches()
 	startRuntimeFromDB()
-	recordImportedConfigEvent(ctx, state, actor, metadata, configEpoch)
+	return configEpoch, http.StatusOK, nil
+}
 
-	return nodeConfigImportResponse{
-		Status:      1,
-		Msg:         "config import success",
-		ConfigEpoch: configEpoch,
-		ImportedAt:  time.Now().Unix(),
-	}, http.StatusOK, nil
+func decodeNodeConfigRestorePoint(raw []byte) (time.Time, error) {
+	var body struct {
+		At json.RawMessage `json:"at"`
+	}
+	if err := json.Unmarshal(bytes.TrimSpace(raw), &body); err != nil {
+		return time.Time{}, err
+	}
+	value := strings.TrimSpace(string(body.At))
+	if strings.HasPrefix(value, `"`) {
+		if err := json.Unmarshal(body.At, &value); err != nil {
+			return time.Time{}, err
+		}
+	}
+	return file.ParseRestorePoint(value)
 }
 
 func maybeInvalidateRealtimeSessionsAfterConfigImport(state *State, request nodeWSFrame, response nodeWSFrame) {
@@ -1554,7 +1708,7 @@ func isNodeConfigImportWSPath(state *State, rawPath string) bool {
 	if err != nil {
 		return false
 	}
-	return path == "/system/import"
+	return path == "/system/import" || path == "/system/restore" || path == "/system/snapshots/actions/restore"
 }
 
 func extractNodeConfigImportEpoch(body []byte) string {
@@ -1681,6 +1835,28 @@ func recordImportedConfigEvent(ctx context.Context, state *State, actor *webapi.
 	})
 }
 
+func recordRestoredConfigEvent(ctx context.Context, state *State, actor *webapi.Actor, metadata webapi.RequestMetadata, configEpoch string, point time.Time) {
+	if state == nil || state.App == nil || state.App.Hooks == nil {
+		return
+	}
+	_ = state.App.Hooks.OnManagementEvent(resolveNodeEmitContext(ctx, func() context.Context {
+		if state != nil {
+			return state.BaseContext()
+		}
+		return context.Background()
+	}), webapi.Event{
+		Name:     "node.config.restored",
+		Resource: "node",
+		Action:   "restore",
+		Actor:    cloneActor(actor),
+		Metadata: metadata,
+		Fields: map[string]interface{}{
+			"config_epoch": configEpoch,
+			"restored_to":  point.Unix(),
+		},
+	})
+}
+
 func flushImportedConfigStorage(storage webservice.NodeStorage) error {
 	if storage == nil {
 		return nil
@@ -5,8 +5,10 @@ import (
 	"encoding/json"
 	"errors"
 	"net/http"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/djylb/nps/lib/common"
 	"github.com/djylb/nps/lib/file"
@@ -1542,6 +1544,68 @@ func TestExecuteNodeConfigImportRollsBackAndFlushesRuntimeStorageOnFailure(t *te
 	}
 }
 
+func TestExecuteNodeConfigRestoreRequiresChangeJournal(t *testing.T) {
+	resetTestDB(t)
+	previous := file.ReplaceChangeJournal(nil)
+	t.Cleanup(func() { file.ReplaceChangeJournal(previous) })
+
+	state := NewState(nil)
+	t.Cleanup(state.Close)
+	storage := &stubConfigImportStorage{snapshot: &file.ConfigSnapshot{Global: &file.Glob{}}}
+	state.App.Services.NodeStorage = storage
+
+	_, status, err := executeNodeConfigRestore(context.Background(), state, webapi.AdminActor("admin"), webapi.RequestMetadata{}, []byte(`{"at":"1700000000"}`))
+	if !errors.Is(err, file.ErrChangeJournalDisabled) || status != http.StatusNotImplemented {
+		t.Fatalf("executeNodeConfigRestore() = %d, %v, want 501 ErrChangeJournalDisabled", status, err)
+	}
+	if len(storage.importedSnapshots) != 0 {
+		t.Fatalf("ImportSnapshot() call count = %d, want 0", len(storage.importedSnapshots))
+	}
+}
+
+func TestExecuteNodeConfigRestoreImportsJournalSnapshot(t *testing.T) {
+	resetTestDB(t)
+	if err := file.GetDb().NewUser(&file.User{Id: 5, Username: "before", Password: "secret", Status: 1, TotalFlow: &file.Flow{}}); err != nil {
+		t.Fatalf("NewUser() error = %v", err)
+	}
+	journal, err := file.OpenChangeJournal(filepath.Join(t.TempDir(), "journal"), file.ChangeJournalOptions{})
+	if err != nil {
+		t.Fatalf("OpenChangeJournal() error = %v", err)
+	}
+	previous := file.ReplaceChangeJournal(journal)
+	t.Cleanup(func() {
+		file.ReplaceChangeJournal(previous)
+		_ = journal.Close()
+	})
+	restorePoint := time.Now().Add(time.Second)
+
+	state := NewState(nil)
+	t.Cleanup(state.Close)
+	storage := &stubConfigImportStorage{snapshot: &file.ConfigSnapshot{Global: &file.Glob{}}}
+	state.App.Services.NodeStorage = storage
+
+	body := []byte(`{"at":"` + restorePoint.Format(time.RFC3339Nano) + `"}`)
+	payload, status, err := executeNodeConfigRestore(context.Background(), state, webapi.AdminActor("admin"), webapi.RequestMetadata{}, body)
+	if err != nil || status != http.StatusOK {
+		t.Fatalf("executeNodeConfigRestore() = %d, %v", status, err)
+	}
+	if payload.Status != 1 || payload.ConfigEpoch == "" || payload.RestoredTo != restorePoint.Unix() {
+		t.Fatalf("unexpected payload = %+v", payload)
+	}
+	if len(storage.importedSnapshots) != 1 {
+		t.Fatalf("ImportSnapshot() call count = %d, want 1", len(storage.importedSnapshots))
+	}
+	restored := storage.importedSnapshots[0]
+	if len(restored.Users) != 1 || restored.Users[0].Username != "before" {
+		t.Fatalf("restored users = %+v, want the journaled user", restored.Users)
+	}
+
+	_, status, err = executeNodeConfigRestore(context.Background(), state, webapi.AdminActor("admin"), webapi.RequestMetadata{}, []byte(`{"at":1}`))
+	if !errors.Is(err, file.ErrRestorePointUnavailable) || status != http.StatusBadRequest {
+		t.Fatalf("executeNodeConfigRestore(before journal) = %d, %v, want 400 ErrRestorePointUnavailable", status, err)
+	}
+}
+
 func TestExecuteNodeConfigImportRequiresRollbackSnapshot(t *testing.T) {
 	resetTestDB(t)
 
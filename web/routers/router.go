@@ -96,7 +96,9 @@ func NewManagedRuntime(cfg *servercfg.Snapshot) *Runtime {
 	reverseStop := StartNodeReverseConnectors(runtime.State)
 	callbackStop := StartNodeCallbackDispatchers(runtime.State)
 	webhookStop := StartNodeWebhookDispatchers(runtime.State)
-	runtime.Stop = wrapManagedRuntimeStop(webhookStop, callbackStop, reverseStop, baseStop)
+	quotaStop := StartNodeQuotaScheduler(runtime.State)
+	certStop := StartNodeCertificateScheduler(runtime.State)
+	runtime.Stop = wrapManagedRuntimeStop(certStop, quotaStop, webhookStop, callbackStop, reverseStop, baseStop)
 	return runtime
 }
 
@@ -430,6 +432,14 @@ func nodeProtectedActionHTTPHandlerOverride(state *State, spec webapi.ActionSpec
 		return nodeChangesHTTPHandler(state)
 	case "system/import":
 		return nodeConfigImportHTTPHandler(state)
+	case "system/restore":
+		return nodeConfigRestoreHTTPHandler(state)
+	case "system_snapshots/list":
+		return nodeConfigSnapshotListHTTPHandler(state)
+	case "system_snapshots/create":
+		return nodeConfigSnapshotCreateHTTPHandler(state)
+	case "system_snapshots/restore":
+		return nodeConfigSnapshotRestoreHTTPHandler(state)
 	case "callbacks_queue/list":
 		return nodeCallbackQueueHTTPHandler(state)
 	case "callbacks_queue/replay":
@@ -572,7 +582,7 @@ func nodeProtectedActionScopeAllowed(spec webapi.ActionSpec) func(webservice.Nod
 		return func(scope webservice.NodeAccessScope) bool {
 			return scope.CanViewStatus()
 		}
-	case "system/usage_snapshot":
+	case "system/usage_snapshot", "usage/series":
 		return func(scope webservice.NodeAccessScope) bool {
 			return scope.CanViewUsage()
 		}
@@ -27,6 +27,8 @@ const (
 	nodeCallbackStateFile    = "node_callbacks_state.json"
 	nodeWebhookStateFile     = "node_webhooks_state.json"
 	nodeOperationsStateFile  = "node_operations_state.json"
+	nodeQuotaAlertStateFile  = "node_quota_alerts_state.json"
+	nodeCertAlertStateFile   = "node_cert_alerts_state.json"
 	nodeRuntimeStateVersion  = 1
 	nodeRuntimePersistDelay  = 50 * time.Millisecond
 )
@@ -882,6 +884,14 @@ func nodeOperationsPersistencePath() string {
 	return nodeRuntimeStatePath(nodeOperationsStateFile)
 }
 
+func nodeQuotaAlertPersistencePath() string {
+	return nodeRuntimeStatePath(nodeQuotaAlertStateFile)
+}
+
+func nodeCertAlertPersistencePath() string {
+	return nodeRuntimeStatePath(nodeCertAlertStateFile)
+}
+
 func nodeRuntimeStatePath(name string) string {
 	name = strings.TrimSpace(name)
 	if name == "" {
@@ -1,9 +1,15 @@
 package service
 
 import (
+	"fmt"
 	"strings"
 
+	"github.com/djylb/nps/lib/acmedns"
+	"github.com/djylb/nps/lib/bodyrewrite"
 	"github.com/djylb/nps/lib/file"
+	"github.com/djylb/nps/lib/forwardauth"
+	"github.com/djylb/nps/lib/tlspolicy"
+	"github.com/djylb/nps/lib/waf"
 )
 
 func countNormalizedRuleLines(rules string) int {
@@ -39,6 +45,79 @@ func normalizeEntryACLInput(mode int, rules string) (int, string) {
 	return normalizedMode, normalizedRules
 }
 
+// normalizeForwardAuthInput validates a forward auth endpoint. The server
+// sends requests to it, so only administrators may change it; other users
+// keep the current one.
+func normalizeForwardAuthInput(endpoint, current string, isAdmin bool) (string, error) {
+	normalized, err := forwardauth.NormalizeEndpoint(endpoint)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidForwardAuth, err)
+	}
+	if normalized != current && !isAdmin {
+		return "", fmt.Errorf("%w: forward_auth can only be changed by an administrator", ErrForbidden)
+	}
+	return normalized, nil
+}
+
+func normalizeClientCertInput(mode, ca string) (string, error) {
+	normalized, err := file.ValidateClientCert(mode, ca)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidClientCert, err)
+	}
+	return normalized, nil
+}
+
+func normalizeBodyRewriteInput(reqRules, respRules string) (string, string, error) {
+	reqNormalized, err := bodyrewrite.Normalize(reqRules)
+	if err != nil {
+		return "", "", fmt.Errorf("%w: request: %v", ErrInvalidBodyRewrite, err)
+	}
+	respNormalized, err := bodyrewrite.Normalize(respRules)
+	if err != nil {
+		return "", "", fmt.Errorf("%w: response: %v", ErrInvalidBodyRewrite, err)
+	}
+	return reqNormalized, respNormalized, nil
+}
+
+// normalizeAutoSSLDNSInput validates the DNS-01 provider of a host. The
+// server sends webhooks and DNS updates to it, so only administrators may
+// name a provider; other users keep the current one or turn DNS-01 off.
+func normalizeAutoSSLDNSInput(spec, current string, isAdmin bool) (string, error) {
+	normalized, err := acmedns.Normalize(spec)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidAutoSSLDNS, err)
+	}
+	// Hooks run on the server, so only nps.conf may name them.
+	if acmedns.IsExec(normalized) {
+		return "", fmt.Errorf("%w: exec hooks can only be set with ssl_dns_provider", ErrInvalidAutoSSLDNS)
+	}
+	if !isAdmin && normalized != current && normalized != "" && normalized != acmedns.Off {
+		return "", fmt.Errorf("%w: auto_ssl_dns providers can only be set by an administrator", ErrForbidden)
+	}
+	return normalized, nil
+}
+
+func normalizeTLSPolicyInput(minVersion, cipherProfile, alpn, hsts string) (tlspolicy.Policy, error) {
+	policy, err := tlspolicy.Normalize(tlspolicy.Policy{
+		MinVersion:    minVersion,
+		CipherProfile: cipherProfile,
+		ALPN:          alpn,
+		HSTS:          hsts,
+	})
+	if err != nil {
+		return tlspolicy.Policy{}, fmt.Errorf("%w: %v", ErrInvalidTLSPolicy, err)
+	}
+	return policy, nil
+}
+
+func normalizeWAFRulesInput(rules string) (string, error) {
+	normalized, err := waf.Normalize(rules)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidWAFRules, err)
+	}
+	return normalized, nil
+}
+
 func normalizeDestinationACLInput(mode int, rules string) (int, string) {
 	normalizedMode := normalizeACLMode(mode)
 	normalizedRules := normalizeRules(rules)
@@ -0,0 +1,1454 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/djylb/nps/lib/common"
+	"github.com/djylb/nps/lib/crypt"
+	"github.com/djylb/nps/lib/file"
+	"github.com/djylb/nps/lib/forwardauth"
+	"github.com/djylb/nps/lib/servercfg"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	ApplyActionCreate = "create"
+	ApplyActionUpdate = "update"
+	ApplyActionDelete = "delete"
+
+	ApplyStatusPlanned = "planned"
+	ApplyStatusApplied = "applied"
+	ApplyStatusFailed  = "failed"
+	ApplyStatusSkipped = "skipped"
+)
+
+// ApplyService converges the node onto a DesiredState through the regular
+// user, client, tunnel, host and global services.
+type ApplyService interface {
+	Apply(ApplyInput) (ApplyResult, error)
+}
+
+type ApplyRepository interface {
+	RangeUsers(func(*file.User) bool)
+	RangeClients(func(*file.Client) bool)
+	RangeTunnels(func(*file.Tunnel) bool)
+	RangeHosts(func(*file.Host) bool)
+	GetGlobal() *file.Glob
+}
+
+type DefaultApplyService struct {
+	ConfigProvider func() *servercfg.Snapshot
+	Repo           ApplyRepository
+	Users          UserService
+	Clients        ClientService
+	Index          IndexService
+	Globals        GlobalService
+	Backend        Backend
+}
+
+// DesiredState is the document read by "nps apply" and POST /api/system/apply.
+// A section that is left out (or null) is not managed at all. A section that
+// is present, even as an empty list, is converged: records missing from it are
+// deleted. Users are matched by username, clients by verify_key, tunnels by
+// mode, server_ip and port (or mode and password for port-less secret/p2p
+// tunnels) and hosts by host, location and scheme.
+type DesiredState struct {
+	Global  *DesiredGlobal  `json:"global,omitempty"`
+	Users   []DesiredUser   `json:"users"`
+	Clients []DesiredClient `json:"clients"`
+	Tunnels []DesiredTunnel `json:"tunnels"`
+	Hosts   []DesiredHost   `json:"hosts"`
+}
+
+type DesiredGlobal struct {
+	EntryACLMode  int    `json:"entry_acl_mode"`
+	EntryACLRules string `json:"entry_acl_rules"`
+	WAFRules      string `json:"waf_rules,omitempty"`
+}
+
+// DesiredUser and the other Desired* records use the field names of the
+// management API write requests. Pointer fields are only compared and written
+// when set, so passwords and status can be left to the UI.
+type DesiredUser struct {
+	Username            string            `json:"username"`
+	Password            *string           `json:"password,omitempty"`
+	TOTPSecret          *string           `json:"totp_secret,omitempty"`
+	Status              *bool             `json:"status,omitempty"`
+	ExpireAt            string            `json:"expire_at"`
+	FlowLimitTotalBytes int64             `json:"flow_limit_total_bytes"`
+	MaxClients          int               `json:"max_clients"`
+	MaxTunnels          int               `json:"max_tunnels"`
+	MaxHosts            int               `json:"max_hosts"`
+	MaxConnections      int               `json:"max_connections"`
+	RateLimitTotalBps   int               `json:"rate_limit_total_bps"`
+	EntryACLMode        int               `json:"entry_acl_mode"`
+	EntryACLRules       string            `json:"entry_acl_rules"`
+	DestACLMode         int               `json:"dest_acl_mode"`
+	DestACLRules        string            `json:"dest_acl_rules"`
+	WAFRules            string            `json:"waf_rules,omitempty"`
+	Labels              map[string]string `json:"labels,omitempty"`
+}
+
+type DesiredClient struct {
+	VerifyKey           string            `json:"verify_key"`
+	Owner               string            `json:"owner,omitempty"`
+	Managers            []string          `json:"managers,omitempty"`
+	Status              *bool             `json:"status,omitempty"`
+	Remark              string            `json:"remark"`
+	Labels              map[string]string `json:"labels,omitempty"`
+	Username            string            `json:"username"`
+	Password            *string           `json:"password,omitempty"`
+	Compress            bool              `json:"compress"`
+	Crypt               bool              `json:"crypt"`
+	ConfigConnAllow     bool              `json:"config_conn_allow"`
+	RateLimitTotalBps   int               `json:"rate_limit_total_bps"`
+	MaxConnections      int               `json:"max_connections"`
+	MaxTunnelNum        int               `json:"max_tunnel_num"`
+	FlowLimitTotalBytes int64             `json:"flow_limit_total_bytes"`
+	ExpireAt            string            `json:"expire_at"`
+	EntryACLMode        int               `json:"entry_acl_mode"`
+	EntryACLRules       string            `json:"entry_acl_rules"`
+}
+
+type DesiredTunnel struct {
+	Client              string            `json:"client"`
+	Mode                string            `json:"mode"`
+	ServerIP            string            `json:"server_ip"`
+	Port                int               `json:"port"`
+	Status              *bool             `json:"status,omitempty"`
+	TargetType          string            `json:"target_type"`
+	Target              string            `json:"target"`
+	ProxyProtocol       int               `json:"proxy_protocol"`
+	Balance             string            `json:"balance,omitempty"`
+	BalanceKey          string            `json:"balance_key,omitempty"`
+	LocalProxy          bool              `json:"local_proxy"`
+	Auth                string            `json:"auth"`
+	Remark              string            `json:"remark"`
+	Labels              map[string]string `json:"labels,omitempty"`
+	Password            string            `json:"password"`
+	LocalPath           string            `json:"local_path"`
+	StripPre            string            `json:"strip_pre"`
+	ForwardAuth         string            `json:"forward_auth"`
+	EnableHTTP          bool              `json:"enable_http"`
+	EnableSocks5        bool              `json:"enable_socks5"`
+	EntryACLMode        int               `json:"entry_acl_mode"`
+	EntryACLRules       string            `json:"entry_acl_rules"`
+	DestACLMode         int               `json:"dest_acl_mode"`
+	DestACLRules        string            `json:"dest_acl_rules"`
+	FlowLimitTotalBytes int64             `json:"flow_limit_total_bytes"`
+	ExpireAt            string            `json:"expire_at"`
+	RateLimitTotalBps   int               `json:"rate_limit_total_bps"`
+	MaxConnections      int               `json:"max_connections"`
+}
+
+type DesiredHost struct {
+	Client              string            `json:"client"`
+	Host                string            `json:"host"`
+	Location            string            `json:"location"`
+	Scheme              string            `json:"scheme"`
+	Status              *bool             `json:"status,omitempty"`
+	Target              string            `json:"target"`
+	ProxyProtocol       int               `json:"proxy_protocol"`
+	Balance             string            `json:"balance,omitempty"`
+	BalanceKey          string            `json:"balance_key,omitempty"`
+	LocalProxy          bool              `json:"local_proxy"`
+	Auth                string            `json:"auth"`
+	Header              string            `json:"header"`
+	RespHeader          string            `json:"resp_header"`
+	HostChange          string            `json:"host_change"`
+	Remark              string            `json:"remark"`
+	Labels              map[string]string `json:"labels,omitempty"`
+	PathRewrite         string            `json:"path_rewrite"`
+	RedirectURL         string            `json:"redirect_url"`
+	FlowLimitTotalBytes int64             `json:"flow_limit_total_bytes"`
+	ExpireAt            string            `json:"expire_at"`
+	RateLimitTotalBps   int               `json:"rate_limit_total_bps"`
+	MaxConnections      int               `json:"max_connections"`
+	EntryACLMode        int               `json:"entry_acl_mode"`
+	EntryACLRules       string            `json:"entry_acl_rules"`
+	HTTPSJustProxy      bool              `json:"https_just_proxy"`
+	TLSOffload          bool              `json:"tls_offload"`
+	AutoSSL             bool              `json:"auto_ssl"`
+	KeyFile             string            `json:"key_file"`
+	CertFile            string            `json:"cert_file"`
+	AutoHTTPS           bool              `json:"auto_https"`
+	AutoCORS            bool              `json:"auto_cors"`
+	RespCompress        bool              `json:"resp_compress"`
+	RespCompressTypes   string            `json:"resp_compress_types,omitempty"`
+	RespCompressMin     int               `json:"resp_compress_min,omitempty"`
+	Cache               bool              `json:"cache"`
+	CacheTTL            int               `json:"cache_ttl,omitempty"`
+	ReqLimit            int               `json:"req_limit"`
+	ReqLimitWindow      int               `json:"req_limit_window,omitempty"`
+	ReqLimitKey         string            `json:"req_limit_key,omitempty"`
+	ReqLimitPaths       string            `json:"req_limit_paths,omitempty"`
+	ForwardAuth         string            `json:"forward_auth,omitempty"`
+	ForwardAuthHeaders  string            `json:"forward_auth_headers,omitempty"`
+	TLSMinVersion       string            `json:"tls_min_version,omitempty"`
+	TLSCipherProfile    string            `json:"tls_cipher_profile,omitempty"`
+	TLSALPN             string            `json:"tls_alpn,omitempty"`
+	HSTS                string            `json:"hsts,omitempty"`
+	CustomDomain        bool              `json:"custom_domain,omitempty"`
+	CertID              int               `json:"cert_id,omitempty"`
+	AutoSSLDNS          string            `json:"auto_ssl_dns,omitempty"`
+	ReqBodyRewrite      string            `json:"req_body_rewrite,omitempty"`
+	RespBodyRewrite     string            `json:"resp_body_rewrite,omitempty"`
+	ClientCertCA        string            `json:"client_cert_ca,omitempty"`
+	ClientCertMode      string            `json:"client_cert_mode,omitempty"`
+	ClientCertSubjects  string            `json:"client_cert_subjects,omitempty"`
+	AuthGate            bool              `json:"auth_gate"`
+	AuthGateEmails      string            `json:"auth_gate_emails,omitempty"`
+	AuthGateGroups      string            `json:"auth_gate_groups,omitempty"`
+	WAFRules            string            `json:"waf_rules,omitempty"`
+	CompatMode          bool              `json:"compat_mode"`
+	TargetIsHTTPS       bool              `json:"target_is_https"`
+}
+
+type ApplyInput struct {
+	State  DesiredState
+	DryRun bool
+}
+
+// ApplyChange is one step of the plan. Fields lists the names of the fields
+// an update rewrites; values are never echoed because some of them are
+// secrets.
+type ApplyChange struct {
+	Resource string   `json:"resource"`
+	Action   string   `json:"action"`
+	Key      string   `json:"key"`
+	ID       int      `json:"id,omitempty"`
+	Fields   []string `json:"fields,omitempty"`
+	Status   string   `json:"status"`
+	Error    string   `json:"error,omitempty"`
+	// Revisions lists the revisions the change wrote, in order.
+	Revisions []int64 `json:"-"`
+	run       func(*ApplyChange) error
+}
+
+// wrote records a revision the change wrote.
+func (c *ApplyChange) wrote(revision int64) {
+	if revision > 0 {
+		c.Revisions = append(c.Revisions, revision)
+	}
+}
+
+type ApplyResult struct {
+	DryRun    bool
+	Changes   []ApplyChange
+	Unchanged int
+	Applied   int
+	Failed    int
+}
+
+// ParseDesiredState reads a YAML or JSON document. Unknown fields are
+// rejected so that a typo does not silently reset a setting.
+func ParseDesiredState(data []byte) (DesiredState, error) {
+	var raw interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return DesiredState{}, fmt.Errorf("%w: %v", ErrDesiredStateInvalid, err)
+	}
+	if raw == nil {
+		return DesiredState{}, fmt.Errorf("%w: document is empty", ErrDesiredStateInvalid)
+	}
+	encoded, err := json.Marshal(raw)
+	if err != nil {
+		return DesiredState{}, fmt.Errorf("%w: %v", ErrDesiredStateInvalid, err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.DisallowUnknownFields()
+	var state DesiredState
+	if err := decoder.Decode(&state); err != nil {
+		return DesiredState{}, fmt.Errorf("%w: %v", ErrDesiredStateInvalid, err)
+	}
+	return state, nil
+}
+
+// Apply plans the changes needed to reach input.State and, unless DryRun is
+// set, runs them in dependency order: global settings, users and clients
+// first, then tunnel and host removals before their creations so that a
+// moved port or domain is free again, and finally client and user removals.
+// Updates carry the revision seen while planning, so a record edited in the
+// meantime fails with ErrRevisionConflict instead of being overwritten. The
+// run stops at the first failure; later changes are reported as skipped.
+func (s DefaultApplyService) Apply(input ApplyInput) (ApplyResult, error) {
+	plan, err := s.plan(input.State)
+	if err != nil {
+		return ApplyResult{}, err
+	}
+	result := ApplyResult{DryRun: input.DryRun, Unchanged: plan.unchanged}
+	result.Changes = plan.changes
+	for i := range result.Changes {
+		change := &result.Changes[i]
+		switch {
+		case input.DryRun:
+			change.Status = ApplyStatusPlanned
+		case result.Failed > 0:
+			change.Status = ApplyStatusSkipped
+		default:
+			if err := change.run(change); err != nil {
+				change.Status = ApplyStatusFailed
+				change.Error = err.Error()
+				result.Failed++
+				continue
+			}
+			change.Status = ApplyStatusApplied
+			result.Applied++
+		}
+	}
+	return result, nil
+}
+
+type applyPlan struct {
+	changes   []ApplyChange
+	unchanged int
+	users     map[string]int
+	clients   map[string]int
+}
+
+type applyPhase struct {
+	resource string
+	changes  []ApplyChange
+}
+
+func (s DefaultApplyService) plan(state DesiredState) (*applyPlan, error) {
+	repo := s.repo()
+	plan := &applyPlan{users: make(map[string]int), clients: make(map[string]int)}
+	usernames := make(map[int]string)
+	var liveUsers []*file.User
+	repo.RangeUsers(func(user *file.User) bool {
+		if user == nil {
+			return true
+		}
+		usernames[user.Id] = user.Username
+		if !user.Hidden && user.Kind != "platform_service" {
+			liveUsers = append(liveUsers, user)
+			plan.users[user.Username] = user.Id
+		}
+		return true
+	})
+	var liveClients []*file.Client
+	repo.RangeClients(func(client *file.Client) bool {
+		if client != nil && !client.NoStore && !isReservedRuntimeClient(client) {
+			liveClients = append(liveClients, client)
+			plan.clients[client.VerifyKey] = client.Id
+		}
+		return true
+	})
+
+	finalUsers := keySet(plan.users)
+	if state.Users != nil {
+		finalUsers = make(map[string]struct{}, len(state.Users))
+		for _, user := range state.Users {
+			finalUsers[strings.TrimSpace(user.Username)] = struct{}{}
+		}
+	}
+	finalClients := keySet(plan.clients)
+	if state.Clients != nil {
+		finalClients = make(map[string]struct{}, len(state.Clients))
+		for _, client := range state.Clients {
+			finalClients[strings.TrimSpace(client.VerifyKey)] = struct{}{}
+		}
+	}
+
+	var global, users, clients, tunnelsUp, hostsUp applyPhase
+	var tunnelsDown, hostsDown, clientsDown, usersDown applyPhase
+	if state.Global != nil {
+		changes, err := s.planGlobal(repo.GetGlobal(), *state.Global, plan)
+		if err != nil {
+			return nil, err
+		}
+		global.changes = changes
+	}
+	if state.Users != nil {
+		up, down, err := s.planUsers(state.Users, liveUsers, plan)
+		if err != nil {
+			return nil, err
+		}
+		users.changes, usersDown.changes = up, down
+	}
+	if state.Clients != nil {
+		up, down, err := s.planClients(state.Clients, liveClients, usernames, finalUsers, plan)
+		if err != nil {
+			return nil, err
+		}
+		clients.changes, clientsDown.changes = up, down
+	}
+	if state.Tunnels != nil {
+		up, down, err := s.planTunnels(state.Tunnels, finalClients, plan)
+		if err != nil {
+			return nil, err
+		}
+		tunnelsUp.changes, tunnelsDown.changes = up, down
+	}
+	if state.Hosts != nil {
+		up, down, err := s.planHosts(state.Hosts, finalClients, plan)
+		if err != nil {
+			return nil, err
+		}
+		hostsUp.changes, hostsDown.changes = up, down
+	}
+	for _, phase := range []applyPhase{global, users, clients, hostsDown, tunnelsDown, tunnelsUp, hostsUp, clientsDown, usersDown} {
+		plan.changes = append(plan.changes, phase.changes...)
+	}
+	return plan, nil
+}
+
+func (s DefaultApplyService) planGlobal(current *file.Glob, desired DesiredGlobal, plan *applyPlan) ([]ApplyChange, error) {
+	desired.EntryACLMode, desired.EntryACLRules = normalizeEntryACLInput(desired.EntryACLMode, desired.EntryACLRules)
+	wafRules, err := normalizeWAFRulesInput(desired.WAFRules)
+	if err != nil {
+		return nil, fmt.Errorf("%w: global: %v", ErrDesiredStateInvalid, err)
+	}
+	desired.WAFRules = wafRules
+	live := DesiredGlobal{}
+	if current != nil {
+		live.EntryACLMode, live.EntryACLRules = normalizeEntryACLInput(current.EntryAclMode, current.EntryAclRules)
+		live.WAFRules = current.WafRules
+	}
+	fields := changedDesiredFields(desired, live)
+	if len(fields) == 0 {
+		plan.unchanged++
+		return nil, nil
+	}
+	globals := s.globals()
+	return []ApplyChange{{
+		Resource: "global", Action: ApplyActionUpdate, Key: "global", Fields: fields,
+		run: func(*ApplyChange) error {
+			return globals.Save(SaveGlobalInput{EntryACLMode: desired.EntryACLMode, EntryACLRules: desired.EntryACLRules, WAFRules: &desired.WAFRules})
+		},
+	}}, nil
+}
+
+func (s DefaultApplyService) planUsers(desired []DesiredUser, live []*file.User, plan *applyPlan) ([]ApplyChange, []ApplyChange, error) {
+	byName := make(map[string]*file.User, len(live))
+	for _, user := range live {
+		byName[user.Username] = user
+	}
+	users := s.users()
+	reserved := s.config().Web.Username
+	seen := make(map[string]struct{}, len(desired))
+	var up []ApplyChange
+	for _, item := range desired {
+		spec, err := normalizeDesiredUser(item)
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, dup := seen[spec.Username]; dup {
+			return nil, nil, fmt.Errorf("%w: user %q is listed twice", ErrDesiredStateInvalid, spec.Username)
+		}
+		seen[spec.Username] = struct{}{}
+		current := byName[spec.Username]
+		if current == nil {
+			status := spec.Status == nil || *spec.Status
+			up = append(up, ApplyChange{
+				Resource: "user", Action: ApplyActionCreate, Key: spec.Username,
+				run: func(change *ApplyChange) error {
+					result, err := users.Add(AddUserInput{
+						ReservedAdminUsername: reserved,
+						Username:              spec.Username,
+						Password:              stringValue(spec.Password),
+						TOTPSecret:            stringValue(spec.TOTPSecret),
+						Status:                status,
+						ExpireAt:              spec.ExpireAt,
+						FlowLimit:             spec.FlowLimitTotalBytes,
+						MaxClients:            spec.MaxClients,
+						MaxTunnels:            spec.MaxTunnels,
+						MaxHosts:              spec.MaxHosts,
+						MaxConnections:        spec.MaxConnections,
+						RateLimit:             ManagementRateLimitFromBps(spec.RateLimitTotalBps),
+						EntryACLMode:          spec.EntryACLMode,
+						EntryACLRules:         spec.EntryACLRules,
+						DestACLMode:           spec.DestACLMode,
+						DestACLRules:          spec.DestACLRules,
+						WAFRules:              spec.WAFRules,
+						Labels:                spec.Labels,
+					})
+					if err != nil {
+						return err
+					}
+					change.ID = result.ID
+					change.wrote(userRevision(result.User))
+					plan.users[spec.Username] = result.ID
+					return nil
+				},
+			})
+			continue
+		}
+		fields := changedDesiredFields(spec, desiredUserFromRecord(current))
+		if len(fields) == 0 {
+			plan.unchanged++
+			continue
+		}
+		id, revision := current.Id, current.Revision
+		up = append(up, ApplyChange{
+			Resource: "user", Action: ApplyActionUpdate, Key: spec.Username, ID: id, Fields: fields,
+			run: func(change *ApplyChange) error {
+				result, err := users.Edit(EditUserInput{
+					ID:                    id,
+					ReservedAdminUsername: reserved,
+					ExpectedRevision:      revision,
+					Username:              spec.Username,
+					Password:              stringValue(spec.Password),
+					PasswordProvided:      spec.Password != nil,
+					TOTPSecret:            stringValue(spec.TOTPSecret),
+					TOTPSecretProvided:    spec.TOTPSecret != nil,
+					Status:                spec.Status != nil && *spec.Status,
+					StatusProvided:        spec.Status != nil,
+					ExpireAt:              spec.ExpireAt,
+					FlowLimit:             spec.FlowLimitTotalBytes,
+					MaxClients:            spec.MaxClients,
+					MaxTunnels:            spec.MaxTunnels,
+					MaxHosts:              spec.MaxHosts,
+					MaxConnections:        spec.MaxConnections,
+					RateLimit:             ManagementRateLimitFromBps(spec.RateLimitTotalBps),
+					EntryACLMode:          spec.EntryACLMode,
+					EntryACLRules:         spec.EntryACLRules,
+					DestACLMode:           spec.DestACLMode,
+					DestACLRules:          spec.DestACLRules,
+					WAFRules:              spec.WAFRules,
+					Labels:                spec.Labels,
+					LabelsSpecified:       true,
+				})
+				change.wrote(userRevision(result.User))
+				return err
+			},
+		})
+	}
+	var down []ApplyChange
+	for _, user := range live {
+		if _, keep := seen[user.Username]; keep {
+			continue
+		}
+		id := user.Id
+		down = append(down, ApplyChange{
+			Resource: "user", Action: ApplyActionDelete, Key: user.Username, ID: id,
+			run: func(change *ApplyChange) error {
+				result, err := users.Delete(id)
+				change.wrote(userRevision(result.User))
+				return err
+			},
+		})
+	}
+	sortApplyChanges(down)
+	return up, down, nil
+}
+
+func (s DefaultApplyService) planClients(desired []DesiredClient, live []*file.Client, usernames map[int]string, finalUsers map[string]struct{}, plan *applyPlan) ([]ApplyChange, []ApplyChange, error) {
+	byKey := make(map[string]*file.Client, len(live))
+	for _, client := range live {
+		byKey[client.VerifyKey] = client
+	}
+	clients := s.clients()
+	reserved := s.config().Web.Username
+	seen := make(map[string]struct{}, len(desired))
+	var up []ApplyChange
+	for _, item := range desired {
+		spec, err := normalizeDesiredClient(item)
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, dup := seen[spec.VerifyKey]; dup {
+			return nil, nil, fmt.Errorf("%w: client %q is listed twice", ErrDesiredStateInvalid, spec.VerifyKey)
+		}
+		seen[spec.VerifyKey] = struct{}{}
+		for _, username := range append([]string{spec.Owner}, spec.Managers...) {
+			if _, ok := finalUsers[username]; username != "" && !ok {
+				return nil, nil, fmt.Errorf("%w: client %q refers to unknown user %q", ErrDesiredStateInvalid, spec.VerifyKey, username)
+			}
+		}
+		resolveUsers := func() (int, []int) {
+			managers := make([]int, 0, len(spec.Managers))
+			for _, username := range spec.Managers {
+				managers = append(managers, plan.users[username])
+			}
+			return plan.users[spec.Owner], managers
+		}
+		current := byKey[spec.VerifyKey]
+		if current == nil {
+			up = append(up, ApplyChange{
+				Resource: "client", Action: ApplyActionCreate, Key: spec.VerifyKey,
+				run: func(change *ApplyChange) error {
+					ownerID, managerIDs := resolveUsers()
+					result, err := clients.Add(AddClientInput{
+						ReservedAdminUsername: reserved,
+						UserID:                ownerID,
+						OwnerSpecified:        spec.Owner != "",
+						ManageUserBinding:     true,
+						AllowManagerUserIDs:   true,
+						ManagerUserIDs:        managerIDs,
+						VKey:                  spec.VerifyKey,
+						Remark:                spec.Remark,
+						Labels:                spec.Labels,
+						User:                  spec.Username,
+						Password:              stringValue(spec.Password),
+						Compress:              spec.Compress,
+						Crypt:                 spec.Crypt,
+						ConfigConnAllow:       spec.ConfigConnAllow,
+						RateLimit:             ManagementRateLimitFromBps(spec.RateLimitTotalBps),
+						MaxConn:               spec.MaxConnections,
+						MaxTunnelNum:          spec.MaxTunnelNum,
+						FlowLimit:             spec.FlowLimitTotalBytes,
+						TimeLimit:             spec.ExpireAt,
+						EntryACLMode:          spec.EntryACLMode,
+						EntryACLRules:         spec.EntryACLRules,
+					})
+					if err != nil {
+						return err
+					}
+					change.ID = result.ID
+					change.wrote(clientRevision(result.Client))
+					plan.clients[spec.VerifyKey] = result.ID
+					if spec.Status != nil && !*spec.Status {
+						result, err = clients.ChangeStatus(result.ID, false)
+						change.wrote(clientRevision(result.Client))
+					}
+					return err
+				},
+			})
+			continue
+		}
+		liveSpec := desiredClientFromRecord(current, usernames)
+		fields := changedDesiredFields(spec, liveSpec)
+		if len(fields) == 0 {
+			plan.unchanged++
+			continue
+		}
+		id, revision := current.Id, current.Revision
+		editNeeded := len(fields) > 1 || fields[0] != "status"
+		up = append(up, ApplyChange{
+			Resource: "client", Action: ApplyActionUpdate, Key: spec.VerifyKey, ID: id, Fields: fields,
+			run: func(change *ApplyChange) error {
+				if editNeeded {
+					ownerID, managerIDs := resolveUsers()
+					result, err := clients.Edit(EditClientInput{
+						ID:                      id,
+						ExpectedRevision:        revision,
+						IsAdmin:                 true,
+						ReservedAdminUsername:   reserved,
+						UserID:                  ownerID,
+						OwnerSpecified:          true,
+						ManageUserBinding:       true,
+						AllowManagerUserIDs:     true,
+						ManagerUserIDsSpecified: true,
+						ManagerUserIDs:          managerIDs,
+						VKey:                    spec.VerifyKey,
+						Remark:                  spec.Remark,
+						Labels:                  spec.Labels,
+						LabelsSpecified:         true,
+						User:                    spec.Username,
+						Password:                stringValue(spec.Password),
+						PasswordProvided:        spec.Password != nil,
+						Compress:                spec.Compress,
+						Crypt:                   spec.Crypt,
+						ConfigConnAllow:         spec.ConfigConnAllow,
+						RateLimit:               ManagementRateLimitFromBps(spec.RateLimitTotalBps),
+						MaxConn:                 spec.MaxConnections,
+						MaxTunnelNum:            spec.MaxTunnelNum,
+						FlowLimit:               spec.FlowLimitTotalBytes,
+						TimeLimit:               spec.ExpireAt,
+						EntryACLMode:            spec.EntryACLMode,
+						EntryACLRules:           spec.EntryACLRules,
+					})
+					if err != nil {
+						return err
+					}
+					change.wrote(clientRevision(result.Client))
+				}
+				if spec.Status != nil && *spec.Status != *liveSpec.Status {
+					result, err := clients.ChangeStatus(id, *spec.Status)
+					change.wrote(clientRevision(result.Client))
+					return err
+				}
+				return nil
+			},
+		})
+	}
+	var down []ApplyChange
+	for _, client := range live {
+		if _, keep := seen[client.VerifyKey]; keep {
+			continue
+		}
+		id := client.Id
+		down = append(down, ApplyChange{
+			Resource: "client", Action: ApplyActionDelete, Key: client.VerifyKey, ID: id,
+			run: func(change *ApplyChange) error {
+				result, err := clients.Delete(id)
+				change.wrote(clientRevision(result.Client))
+				return err
+			},
+		})
+	}
+	sortApplyChanges(down)
+	return up, down, nil
+}
+
+func (s DefaultApplyService) planTunnels(desired []DesiredTunnel, finalClients map[string]struct{}, plan *applyPlan) ([]ApplyChange, []ApplyChange, error) {
+	byKey := make(map[string]*file.Tunnel)
+	s.repo().RangeTunnels(func(tunnel *file.Tunnel) bool {
+		if tunnel != nil && !tunnel.NoStore && tunnel.Client != nil && !isReservedRuntimeClient(tunnel.Client) {
+			byKey[tunnelApplyKey(tunnel.Mode, tunnel.ServerIp, tunnel.Port, tunnel.Password)] = tunnel
+		}
+		return true
+	})
+	index := s.index()
+	allowLocal := s.config().Feature.AllowLocalProxy
+	seen := make(map[string]struct{}, len(desired))
+	var up []ApplyChange
+	for _, item := range desired {
+		spec, err := normalizeDesiredTunnel(item, allowLocal)
+		if err != nil {
+			return nil, nil, err
+		}
+		if spec.Port <= 0 && spec.Password == "" {
+			return nil, nil, fmt.Errorf("%w: %s tunnel of client %q needs a port or a password", ErrDesiredStateInvalid, spec.Mode, spec.Client)
+		}
+		key := tunnelApplyKey(spec.Mode, spec.ServerIP, spec.Port, spec.Password)
+		if _, dup := seen[key]; dup {
+			return nil, nil, fmt.Errorf("%w: tunnel %q is listed twice", ErrDesiredStateInvalid, key)
+		}
+		seen[key] = struct{}{}
+		if _, ok := finalClients[spec.Client]; !ok {
+			return nil, nil, fmt.Errorf("%w: tunnel %q refers to unknown client %q", ErrDesiredStateInvalid, key, spec.Client)
+		}
+		request := func(clientID int) TunnelWriteRequest {
+			return TunnelWriteRequest{
+				ClientID:       clientID,
+				Port:           spec.Port,
+				ServerIP:       spec.ServerIP,
+				Mode:           spec.Mode,
+				TargetType:     spec.TargetType,
+				Target:         spec.Target,
+				ProxyProtocol:  spec.ProxyProtocol,
+				Balance:        spec.Balance,
+				BalanceKey:     spec.BalanceKey,
+				LocalProxy:     spec.LocalProxy,
+				Auth:           spec.Auth,
+				Remark:         spec.Remark,
+				Labels:         spec.Labels,
+				Password:       spec.Password,
+				LocalPath:      spec.LocalPath,
+				StripPre:       spec.StripPre,
+				ForwardAuth:    spec.ForwardAuth,
+				EnableHTTP:     spec.EnableHTTP,
+				EnableSocks5:   spec.EnableSocks5,
+				EntryACLMode:   spec.EntryACLMode,
+				EntryACLRules:  spec.EntryACLRules,
+				DestACLMode:    spec.DestACLMode,
+				DestACLRules:   spec.DestACLRules,
+				FlowLimit:      spec.FlowLimitTotalBytes,
+				TimeLimit:      spec.ExpireAt,
+				RateLimit:      ManagementRateLimitFromBps(spec.RateLimitTotalBps),
+				MaxConnections: spec.MaxConnections,
+			}
+		}
+		current := byKey[key]
+		if current == nil {
+			up = append(up, ApplyChange{
+				Resource: "tunnel", Action: ApplyActionCreate, Key: key,
+				run: func(change *ApplyChange) error {
+					write := request(plan.clients[spec.Client])
+					result, err := index.AddTunnel(BuildAddTunnelInput(adminIndexMutationContext(allowLocal), AddTunnelRequest{TunnelWriteRequest: write}))
+					if err != nil {
+						return err
+					}
+					change.ID = result.ID
+					change.wrote(tunnelRevision(result.Tunnel))
+					if spec.Status != nil && !*spec.Status {
+						result, err = index.StopTunnel(result.ID, "")
+						change.wrote(tunnelRevision(result.Tunnel))
+					}
+					return err
+				},
+			})
+			continue
+		}
+		liveSpec := desiredTunnelFromRecord(current)
+		fields := changedDesiredFields(spec, liveSpec)
+		if len(fields) == 0 {
+			plan.unchanged++
+			continue
+		}
+		id, revision := current.Id, current.Revision
+		editNeeded := len(fields) > 1 || fields[0] != "status"
+		status := *liveSpec.Status
+		if spec.Status != nil {
+			status = *spec.Status
+		}
+		up = append(up, ApplyChange{
+			Resource: "tunnel", Action: ApplyActionUpdate, Key: key, ID: id, Fields: fields,
+			run: func(change *ApplyChange) error {
+				if editNeeded {
+					write := request(plan.clients[spec.Client])
+					write.LabelsSpecified = true
+					// EditTunnel restarts the tunnel, so a stopped one is
+					// stopped again below.
+					result, err := index.EditTunnel(BuildEditTunnelInput(adminIndexMutationContext(allowLocal), EditTunnelRequest{
+						ID:                 id,
+						ExpectedRevision:   revision,
+						TunnelWriteRequest: write,
+					}))
+					if err != nil {
+						return err
+					}
+					change.wrote(tunnelRevision(result.Tunnel))
+				}
+				var result TunnelMutation
+				var err error
+				if !status {
+					result, err = index.StopTunnel(id, "")
+				} else if !editNeeded {
+					result, err = index.StartTunnel(id, "")
+				}
+				change.wrote(tunnelRevision(result.Tunnel))
+				return err
+			},
+		})
+	}
+	var down []ApplyChange
+	for key, tunnel := range byKey {
+		if _, keep := seen[key]; keep {
+			continue
+		}
+		id := tunnel.Id
+		down = append(down, ApplyChange{
+			Resource: "tunnel", Action: ApplyActionDelete, Key: key, ID: id,
+			run: func(change *ApplyChange) error {
+				result, err := index.DeleteTunnel(id)
+				change.wrote(tunnelRevision(result.Tunnel))
+				return err
+			},
+		})
+	}
+	sortApplyChanges(down)
+	return up, down, nil
+}
+
+func (s DefaultApplyService) planHosts(desired []DesiredHost, finalClients map[string]struct{}, plan *applyPlan) ([]ApplyChange, []ApplyChange, error) {
+	byKey := make(map[string]*file.Host)
+	s.repo().RangeHosts(func(host *file.Host) bool {
+		if host != nil && !host.NoStore && host.Client != nil && !isReservedRuntimeClient(host.Client) {
+			byKey[hostApplyKey(host.Host, host.Location, host.Scheme)] = host
+		}
+		return true
+	})
+	index := s.index()
+	allowLocal := s.config().Feature.AllowLocalProxy
+	seen := make(map[string]struct{}, len(desired))
+	var up []ApplyChange
+	for _, item := range desired {
+		spec, err := normalizeDesiredHost(item, allowLocal)
+		if err != nil {
+			return nil, nil, err
+		}
+		key := hostApplyKey(spec.Host, spec.Location, spec.Scheme)
+		if _, dup := seen[key]; dup {
+			return nil, nil, fmt.Errorf("%w: host %q is listed twice", ErrDesiredStateInvalid, key)
+		}
+		seen[key] = struct{}{}
+		if _, ok := finalClients[spec.Client]; !ok {
+			return nil, nil, fmt.Errorf("%w: host %q refers to unknown client %q", ErrDesiredStateInvalid, key, spec.Client)
+		}
+		request := func(clientID int) HostWriteRequest {
+			return HostWriteRequest{
+				ClientID:           clientID,
+				Host:               spec.Host,
+				Target:             spec.Target,
+				ProxyProtocol:      spec.ProxyProtocol,
+				Balance:            spec.Balance,
+				BalanceKey:         spec.BalanceKey,
+				LocalProxy:         spec.LocalProxy,
+				Auth:               spec.Auth,
+				Header:             spec.Header,
+				RespHeader:         spec.RespHeader,
+				HostChange:         spec.HostChange,
+				Remark:             spec.Remark,
+				Labels:             spec.Labels,
+				Location:           spec.Location,
+				PathRewrite:        spec.PathRewrite,
+				RedirectURL:        spec.RedirectURL,
+				FlowLimit:          spec.FlowLimitTotalBytes,
+				TimeLimit:          spec.ExpireAt,
+				RateLimit:          ManagementRateLimitFromBps(spec.RateLimitTotalBps),
+				MaxConnections:     spec.MaxConnections,
+				EntryACLMode:       spec.EntryACLMode,
+				EntryACLRules:      spec.EntryACLRules,
+				Scheme:             spec.Scheme,
+				HTTPSJustProxy:     spec.HTTPSJustProxy,
+				TLSOffload:         spec.TLSOffload,
+				AutoSSL:            spec.AutoSSL,
+				KeyFile:            spec.KeyFile,
+				CertFile:           spec.CertFile,
+				AutoHTTPS:          spec.AutoHTTPS,
+				AutoCORS:           spec.AutoCORS,
+				RespCompress:       spec.RespCompress,
+				RespCompressTypes:  spec.RespCompressTypes,
+				RespCompressMin:    spec.RespCompressMin,
+				Cache:              spec.Cache,
+				CacheTTL:           spec.CacheTTL,
+				ReqLimit:           spec.ReqLimit,
+				ReqLimitWindow:     spec.ReqLimitWindow,
+				ReqLimitKey:        spec.ReqLimitKey,
+				ReqLimitPaths:      spec.ReqLimitPaths,
+				ForwardAuth:        spec.ForwardAuth,
+				ForwardAuthHeaders: spec.ForwardAuthHeaders,
+				TLSMinVersion:      spec.TLSMinVersion,
+				TLSCipherProfile:   spec.TLSCipherProfile,
+				TLSALPN:            spec.TLSALPN,
+				HSTS:               spec.HSTS,
+				CustomDomain:       spec.CustomDomain,
+				CertID:             spec.CertID,
+				AutoSSLDNS:         spec.AutoSSLDNS,
+				ReqBodyRewrite:     spec.ReqBodyRewrite,
+				RespBodyRewrite:    spec.RespBodyRewrite,
+				ClientCertCA:       spec.ClientCertCA,
+				ClientCertMode:     spec.ClientCertMode,
+				ClientCertSubjects: spec.ClientCertSubjects,
+				AuthGate:           spec.AuthGate,
+				AuthGateEmails:     spec.AuthGateEmails,
+				AuthGateGroups:     spec.AuthGateGroups,
+				WAFRules:           spec.WAFRules,
+				CompatMode:         spec.CompatMode,
+				TargetIsHTTPS:      spec.TargetIsHTTPS,
+			}
+		}
+		current := byKey[key]
+		if current == nil {
+			up = append(up, ApplyChange{
+				Resource: "host", Action: ApplyActionCreate, Key: key,
+				run: func(change *ApplyChange) error {
+					write := request(plan.clients[spec.Client])
+					result, err := index.AddHost(BuildAddHostInput(adminIndexMutationContext(allowLocal), AddHostRequest{HostWriteRequest: write}))
+					if err != nil {
+						return err
+					}
+					change.ID = result.ID
+					change.wrote(hostRevision(result.Host))
+					if spec.Status != nil && !*spec.Status {
+						result, err = index.StopHost(result.ID, "")
+						change.wrote(hostRevision(result.Host))
+					}
+					return err
+				},
+			})
+			continue
+		}
+		liveSpec := desiredHostFromRecord(current)
+		fields := changedDesiredFields(spec, liveSpec)
+		if len(fields) == 0 {
+			plan.unchanged++
+			continue
+		}
+		id, revision := current.Id, current.Revision
+		editNeeded := len(fields) > 1 || fields[0] != "status"
+		up = append(up, ApplyChange{
+			Resource: "host", Action: ApplyActionUpdate, Key: key, ID: id, Fields: fields,
+			run: func(change *ApplyChange) error {
+				if editNeeded {
+					write := request(plan.clients[spec.Client])
+					write.LabelsSpecified = true
+					result, err := index.EditHost(BuildEditHostInput(adminIndexMutationContext(allowLocal), EditHostRequest{
+						ID:               id,
+						ExpectedRevision: revision,
+						HostWriteRequest: write,
+					}))
+					if err != nil {
+						return err
+					}
+					change.wrote(hostRevision(result.Host))
+				}
+				if spec.Status == nil || *spec.Status == *liveSpec.Status {
+					return nil
+				}
+				var result HostMutation
+				var err error
+				if *spec.Status {
+					result, err = index.StartHost(id, "")
+				} else {
+					result, err = index.StopHost(id, "")
+				}
+				change.wrote(hostRevision(result.Host))
+				return err
+			},
+		})
+	}
+	var down []ApplyChange
+	for key, host := range byKey {
+		if _, keep := seen[key]; keep {
+			continue
+		}
+		id := host.Id
+		down = append(down, ApplyChange{
+			Resource: "host", Action: ApplyActionDelete, Key: key, ID: id,
+			run: func(change *ApplyChange) error {
+				result, err := index.DeleteHost(id)
+				change.wrote(hostRevision(result.Host))
+				return err
+			},
+		})
+	}
+	sortApplyChanges(down)
+	return up, down, nil
+}
+
+func normalizeDesiredUser(user DesiredUser) (DesiredUser, error) {
+	user.Username = strings.TrimSpace(user.Username)
+	if user.Username == "" {
+		return user, fmt.Errorf("%w: user without username", ErrDesiredStateInvalid)
+	}
+	if user.Password != nil {
+		password := strings.TrimSpace(*user.Password)
+		user.Password = &password
+	}
+	if user.TOTPSecret != nil {
+		secret, err := normalizeUserTOTPSecret(*user.TOTPSecret)
+		if err != nil {
+			return user, fmt.Errorf("%w: user %q: %v", ErrDesiredStateInvalid, user.Username, err)
+		}
+		user.TOTPSecret = &secret
+	}
+	user.ExpireAt = normalizeApplyExpireAt(user.ExpireAt)
+	user.FlowLimitTotalBytes = normalizeClientFlowLimit(user.FlowLimitTotalBytes)
+	user.RateLimitTotalBps = ManagementRateLimitToBps(ManagementRateLimitFromBps(user.RateLimitTotalBps))
+	user.EntryACLMode, user.EntryACLRules = normalizeEntryACLInput(user.EntryACLMode, user.EntryACLRules)
+	user.DestACLMode, user.DestACLRules = normalizeDestinationACLInput(user.DestACLMode, user.DestACLRules)
+	labels, err := normalizeLabelsInput(user.Labels)
+	if err != nil {
+		return user, fmt.Errorf("%w: user %q: %v", ErrDesiredStateInvalid, user.Username, err)
+	}
+	user.Labels = labels
+	if user.WAFRules, err = normalizeWAFRulesInput(user.WAFRules); err != nil {
+		return user, fmt.Errorf("%w: user %q: %v", ErrDesiredStateInvalid, user.Username, err)
+	}
+	return user, nil
+}
+
+func desiredUserFromRecord(user *file.User) DesiredUser {
+	status := user.Status != 0
+	entryMode, entryRules := normalizeEntryACLInput(user.EntryAclMode, user.EntryAclRules)
+	destMode, destRules := normalizeDestinationACLInput(user.DestAclMode, user.DestAclRules)
+	return DesiredUser{
+		Username:            user.Username,
+		Password:            &user.Password,
+		TOTPSecret:          &user.TOTPSecret,
+		Status:              &status,
+		ExpireAt:            formatUserExpireAt(user.ExpireAt),
+		FlowLimitTotalBytes: user.FlowLimit,
+		MaxClients:          user.MaxClients,
+		MaxTunnels:          user.MaxTunnels,
+		MaxHosts:            user.MaxHosts,
+		MaxConnections:      user.MaxConnections,
+		RateLimitTotalBps:   ManagementRateLimitToBps(user.RateLimit),
+		EntryACLMode:        entryMode,
+		EntryACLRules:       entryRules,
+		DestACLMode:         destMode,
+		DestACLRules:        destRules,
+		WAFRules:            user.WafRules,
+		Labels:              file.CloneLabels(user.Labels),
+	}
+}
+
+func normalizeDesiredClient(client DesiredClient) (DesiredClient, error) {
+	client.VerifyKey = strings.TrimSpace(client.VerifyKey)
+	if client.VerifyKey == "" {
+		return client, fmt.Errorf("%w: client without verify_key", ErrDesiredStateInvalid)
+	}
+	client.Owner = strings.TrimSpace(client.Owner)
+	client.Managers = normalizeApplyManagers(client.Managers, client.Owner)
+	client.ExpireAt = normalizeApplyExpireAt(client.ExpireAt)
+	client.FlowLimitTotalBytes = normalizeClientFlowLimit(client.FlowLimitTotalBytes)
+	if client.Owner != "" {
+		// Owned clients inherit their lifecycle from the owner.
+		client.ExpireAt = ""
+		client.FlowLimitTotalBytes = 0
+	}
+	client.RateLimitTotalBps = ManagementRateLimitToBps(ManagementRateLimitFromBps(client.RateLimitTotalBps))
+	client.EntryACLMode, client.EntryACLRules = normalizeEntryACLInput(client.EntryACLMode, client.EntryACLRules)
+	labels, err := normalizeLabelsInput(client.Labels)
+	if err != nil {
+		return client, fmt.Errorf("%w: client %q: %v", ErrDesiredStateInvalid, client.VerifyKey, err)
+	}
+	client.Labels = labels
+	return client, nil
+}
+
+func desiredClientFromRecord(client *file.Client, usernames map[int]string) DesiredClient {
+	status := client.Status
+	spec := DesiredClient{
+		VerifyKey:           client.VerifyKey,
+		Owner:               usernames[client.OwnerID()],
+		Status:              &status,
+		Remark:              client.Remark,
+		Labels:              file.CloneLabels(client.Labels),
+		ConfigConnAllow:     client.ConfigConnAllow,
+		RateLimitTotalBps:   ManagementRateLimitToBps(client.RateLimit),
+		MaxConnections:      client.MaxConn,
+		MaxTunnelNum:        client.MaxTunnelNum,
+		FlowLimitTotalBytes: client.FlowLimit,
+		ExpireAt:            formatUserExpireAt(client.ExpireAt),
+	}
+	spec.EntryACLMode, spec.EntryACLRules = normalizeEntryACLInput(client.EntryAclMode, client.EntryAclRules)
+	managers := make([]string, 0, len(client.ManagerUserIDs))
+	for _, id := range client.ManagerUserIDs {
+		if username, ok := usernames[id]; ok {
+			managers = append(managers, username)
+		}
+	}
+	spec.Managers = normalizeApplyManagers(managers, spec.Owner)
+	password := ""
+	if client.Cnf != nil {
+		spec.Username = client.Cnf.U
+		password = client.Cnf.P
+		spec.Compress = client.Cnf.Compress
+		spec.Crypt = client.Cnf.Crypt
+	}
+	spec.Password = &password
+	return spec
+}
+
+func normalizeDesiredTunnel(tunnel DesiredTunnel, allowLocal bool) (DesiredTunnel, error) {
+	tunnel.Client = strings.TrimSpace(tunnel.Client)
+	tunnel.Mode = strings.TrimSpace(tunnel.Mode)
+	tunnel.ServerIP = strings.TrimSpace(tunnel.ServerIP)
+	if tunnel.Client == "" || tunnel.Mode == "" {
+		return tunnel, fmt.Errorf("%w: tunnel needs client and mode", ErrDesiredStateInvalid)
+	}
+	if tunnel.TargetType != common.CONN_TCP && tunnel.TargetType != common.CONN_UDP {
+		tunnel.TargetType = common.CONN_ALL
+	}
+	tunnel.Target = sanitizeBridgeTarget(tunnel.Target, true, "")
+	tunnel.LocalProxy = tunnel.LocalProxy && allowLocal
+	tunnel.Balance, tunnel.BalanceKey = file.NormalizeTargetBalance(tunnel.Balance, tunnel.BalanceKey)
+	tunnel.EntryACLMode, tunnel.EntryACLRules = normalizeEntryACLInput(tunnel.EntryACLMode, tunnel.EntryACLRules)
+	tunnel.DestACLMode, tunnel.DestACLRules = normalizeDestinationACLInput(tunnel.DestACLMode, tunnel.DestACLRules)
+	tunnel.ExpireAt = normalizeApplyExpireAt(tunnel.ExpireAt)
+	tunnel.FlowLimitTotalBytes = normalizeClientFlowLimit(tunnel.FlowLimitTotalBytes)
+	tunnel.RateLimitTotalBps = ManagementRateLimitToBps(ManagementRateLimitFromBps(tunnel.RateLimitTotalBps))
+	labels, err := normalizeLabelsInput(tunnel.Labels)
+	if err != nil {
+		return tunnel, fmt.Errorf("%w: %s tunnel of client %q: %v", ErrDesiredStateInvalid, tunnel.Mode, tunnel.Client, err)
+	}
+	tunnel.Labels = labels
+	if tunnel.ForwardAuth, err = normalizeForwardAuthInput(tunnel.ForwardAuth, "", true); err != nil {
+		return tunnel, fmt.Errorf("%w: %s tunnel of client %q: %v", ErrDesiredStateInvalid, tunnel.Mode, tunnel.Client, err)
+	}
+	return tunnel, nil
+}
+
+func desiredTunnelFromRecord(tunnel *file.Tunnel) DesiredTunnel {
+	status := tunnel.Status
+	spec := DesiredTunnel{
+		Client:            tunnel.Client.VerifyKey,
+		Mode:              tunnel.Mode,
+		ServerIP:          tunnel.ServerIp,
+		Port:              tunnel.Port,
+		Status:            &status,
+		TargetType:        tunnel.TargetType,
+		Remark:            tunnel.Remark,
+		Labels:            file.CloneLabels(tunnel.Labels),
+		Password:          tunnel.Password,
+		LocalPath:         tunnel.LocalPath,
+		StripPre:          tunnel.StripPre,
+		ForwardAuth:       tunnel.ForwardAuth,
+		EnableHTTP:        tunnel.HttpProxy,
+		EnableSocks5:      tunnel.Socks5Proxy,
+		RateLimitTotalBps: ManagementRateLimitToBps(tunnel.RateLimit),
+		MaxConnections:    tunnel.MaxConn,
+	}
+	if tunnel.Target != nil {
+		spec.Target = tunnel.Target.TargetStr
+		spec.ProxyProtocol = tunnel.Target.ProxyProtocol
+		spec.Balance = tunnel.Target.Balance
+		spec.BalanceKey = tunnel.Target.BalanceKey
+		spec.LocalProxy = tunnel.Target.LocalProxy
+	}
+	if tunnel.UserAuth != nil {
+		spec.Auth = tunnel.UserAuth.Content
+	}
+	spec.FlowLimitTotalBytes, spec.ExpireAt = legacyFlowLimits(tunnel.Flow)
+	spec.EntryACLMode, spec.EntryACLRules = normalizeEntryACLInput(tunnel.EntryAclMode, tunnel.EntryAclRules)
+	spec.DestACLMode, spec.DestACLRules = normalizeDestinationACLInput(tunnel.DestAclMode, tunnel.DestAclRules)
+	return spec
+}
+
+func normalizeDesiredHost(host DesiredHost, allowLocal bool) (DesiredHost, error) {
+	host.Client = strings.TrimSpace(host.Client)
+	host.Host = strings.TrimSpace(host.Host)
+	if host.Client == "" || host.Host == "" {
+		return host, fmt.Errorf("%w: host needs client and host", ErrDesiredStateInvalid)
+	}
+	host.Location = normalizeApplyLocation(host.Location)
+	host.Scheme = normalizeScheme(host.Scheme)
+	host.Target = sanitizeBridgeTarget(host.Target, true, "")
+	host.LocalProxy = host.LocalProxy && allowLocal
+	host.Balance, host.BalanceKey = file.NormalizeTargetBalance(host.Balance, host.BalanceKey)
+	host.RespCompressTypes = file.NormalizeRespCompressTypes(host.RespCompressTypes)
+	host.RespCompressMin = max(host.RespCompressMin, 0)
+	host.CacheTTL = max(host.CacheTTL, 0)
+	host.ReqLimit = max(host.ReqLimit, 0)
+	host.ReqLimitWindow = max(host.ReqLimitWindow, 0)
+	host.ReqLimitKey = file.NormalizeReqLimitKey(host.ReqLimitKey)
+	host.ReqLimitPaths = file.NormalizeReqLimitPaths(host.ReqLimitPaths)
+	host.AuthGateEmails = file.NormalizeAuthGateEmails(host.AuthGateEmails)
+	host.AuthGateGroups = file.NormalizeAuthGateGroups(host.AuthGateGroups)
+	host.EntryACLMode, host.EntryACLRules = normalizeEntryACLInput(host.EntryACLMode, host.EntryACLRules)
+	host.ExpireAt = normalizeApplyExpireAt(host.ExpireAt)
+	host.FlowLimitTotalBytes = normalizeClientFlowLimit(host.FlowLimitTotalBytes)
+	host.RateLimitTotalBps = ManagementRateLimitToBps(ManagementRateLimitFromBps(host.RateLimitTotalBps))
+	labels, err := normalizeLabelsInput(host.Labels)
+	if err != nil {
+		return host, fmt.Errorf("%w: host %q: %v", ErrDesiredStateInvalid, host.Host, err)
+	}
+	host.Labels = labels
+	if host.WAFRules, err = normalizeWAFRulesInput(host.WAFRules); err != nil {
+		return host, fmt.Errorf("%w: host %q: %v", ErrDesiredStateInvalid, host.Host, err)
+	}
+	if host.ForwardAuth, err = normalizeForwardAuthInput(host.ForwardAuth, "", true); err != nil {
+		return host, fmt.Errorf("%w: host %q: %v", ErrDesiredStateInvalid, host.Host, err)
+	}
+	host.ForwardAuthHeaders = forwardauth.NormalizeHeaders(host.ForwardAuthHeaders)
+	if host.ClientCertMode, err = normalizeClientCertInput(host.ClientCertMode, host.ClientCertCA); err != nil {
+		return host, fmt.Errorf("%w: host %q: %v", ErrDesiredStateInvalid, host.Host, err)
+	}
+	if host.ReqBodyRewrite, host.RespBodyRewrite, err = normalizeBodyRewriteInput(host.ReqBodyRewrite, host.RespBodyRewrite); err != nil {
+		return host, fmt.Errorf("%w: host %q: %v", ErrDesiredStateInvalid, host.Host, err)
+	}
+	if host.AutoSSLDNS, err = normalizeAutoSSLDNSInput(host.AutoSSLDNS, "", true); err != nil {
+		return host, fmt.Errorf("%w: host %q: %v", ErrDesiredStateInvalid, host.Host, err)
+	}
+	tlsPolicy, err := normalizeTLSPolicyInput(host.TLSMinVersion, host.TLSCipherProfile, host.TLSALPN, host.HSTS)
+	if err != nil {
+		return host, fmt.Errorf("%w: host %q: %v", ErrDesiredStateInvalid, host.Host, err)
+	}
+	host.TLSMinVersion, host.TLSCipherProfile, host.TLSALPN, host.HSTS = tlsPolicy.MinVersion, tlsPolicy.CipherProfile, tlsPolicy.ALPN, tlsPolicy.HSTS
+	host.CertID = max(host.CertID, 0)
+	if host.CertFile, host.KeyFile, err = resolveHostCertInput(host.CertID, host.CertID, host.Host, host.CertFile, host.KeyFile, true); err != nil {
+		return host, fmt.Errorf("%w: host %q: %v", ErrDesiredStateInvalid, host.Host, err)
+	}
+	return host, nil
+}
+
+func desiredHostFromRecord(host *file.Host) DesiredHost {
+	status := !host.IsClose
+	spec := DesiredHost{
+		Client:             host.Client.VerifyKey,
+		Host:               host.Host,
+		Location:           normalizeApplyLocation(host.Location),
+		Scheme:             normalizeScheme(host.Scheme),
+		Status:             &status,
+		Header:             host.HeaderChange,
+		RespHeader:         host.RespHeaderChange,
+		HostChange:         host.HostChange,
+		Remark:             host.Remark,
+		Labels:             file.CloneLabels(host.Labels),
+		PathRewrite:        host.PathRewrite,
+		RedirectURL:        host.RedirectURL,
+		RateLimitTotalBps:  ManagementRateLimitToBps(host.RateLimit),
+		MaxConnections:     host.MaxConn,
+		HTTPSJustProxy:     host.HttpsJustProxy,
+		TLSOffload:         host.TlsOffload,
+		AutoSSL:            host.AutoSSL,
+		KeyFile:            host.KeyFile,
+		CertFile:           host.CertFile,
+		AutoHTTPS:          host.AutoHttps,
+		AutoCORS:           host.AutoCORS,
+		RespCompress:       host.RespCompress,
+		RespCompressTypes:  host.RespCompressTypes,
+		RespCompressMin:    host.RespCompressMin,
+		Cache:              host.Cache,
+		CacheTTL:           host.CacheTTL,
+		ReqLimit:           host.ReqLimit,
+		ReqLimitWindow:     host.ReqLimitWindow,
+		ReqLimitKey:        host.ReqLimitKey,
+		ReqLimitPaths:      host.ReqLimitPaths,
+		ForwardAuth:        host.ForwardAuth,
+		ForwardAuthHeaders: host.ForwardAuthHeaders,
+		TLSMinVersion:      host.TLSMinVersion,
+		TLSCipherProfile:   host.TLSCipherProfile,
+		TLSALPN:            host.TLSALPN,
+		HSTS:               host.HSTS,
+		CustomDomain:       host.CustomDomain,
+		CertID:             host.CertID,
+		AutoSSLDNS:         host.AutoSSLDNS,
+		ReqBodyRewrite:     host.ReqBodyRewrite,
+		RespBodyRewrite:    host.RespBodyRewrite,
+		ClientCertCA:       host.ClientCertCA,
+		ClientCertMode:     host.ClientCertMode,
+		ClientCertSubjects: host.ClientCertSubjects,
+		AuthGate:           host.AuthGate,
+		AuthGateEmails:     host.AuthGateEmails,
+		AuthGateGroups:     host.AuthGateGroups,
+		WAFRules:           host.WafRules,
+		CompatMode:         host.CompatMode,
+		TargetIsHTTPS:      host.TargetIsHttps,
+	}
+	if host.Target != nil {
+		spec.Target = host.Target.TargetStr
+		spec.ProxyProtocol = host.Target.ProxyProtocol
+		spec.Balance = host.Target.Balance
+		spec.BalanceKey = host.Target.BalanceKey
+		spec.LocalProxy = host.Target.LocalProxy
+	}
+	if host.UserAuth != nil {
+		spec.Auth = host.UserAuth.Content
+	}
+	spec.FlowLimitTotalBytes, spec.ExpireAt = legacyFlowLimits(host.Flow)
+	spec.EntryACLMode, spec.EntryACLRules = normalizeEntryACLInput(host.EntryAclMode, host.EntryAclRules)
+	return spec
+}
+
+// changedDesiredFields returns the json names of the fields that differ.
+// Nil pointer fields in desired are unmanaged and never reported.
+func changedDesiredFields(desired, live interface{}) []string {
+	desiredValue := reflect.ValueOf(desired)
+	liveValue := reflect.ValueOf(live)
+	fieldType := desiredValue.Type()
+	var fields []string
+	for i := 0; i < fieldType.NumField(); i++ {
+		field := desiredValue.Field(i)
+		if field.Kind() == reflect.Pointer && field.IsNil() {
+			continue
+		}
+		if reflect.DeepEqual(field.Interface(), liveValue.Field(i).Interface()) {
+			continue
+		}
+		name, _, _ := strings.Cut(fieldType.Field(i).Tag.Get("json"), ",")
+		fields = append(fields, name)
+	}
+	return fields
+}
+
+// legacyFlowLimits reads the limits back from the fields the tunnel and host
+// write paths store them in, so that applying the same document twice does
+// not report a change.
+func legacyFlowLimits(flow *file.Flow) (int64, string) {
+	if flow == nil {
+		return 0, ""
+	}
+	expireAt := int64(0)
+	if !flow.TimeLimit.IsZero() {
+		expireAt = flow.TimeLimit.Unix()
+	}
+	return flow.FlowLimit, formatUserExpireAt(expireAt)
+}
+
+func tunnelApplyKey(mode, serverIP string, port int, password string) string {
+	if port > 0 {
+		return mode + " " + serverIP + ":" + strconv.Itoa(port)
+	}
+	// Port-less tunnels are found by their password; only a digest of it
+	// goes into plans and logs.
+	return mode + " #" + crypt.Md5(password)[:8]
+}
+
+func hostApplyKey(host, location, scheme string) string {
+	key := strings.TrimSpace(host) + normalizeApplyLocation(location)
+	if scheme = normalizeScheme(scheme); scheme != "all" {
+		key = scheme + "://" + key
+	}
+	return key
+}
+
+func normalizeApplyLocation(location string) string {
+	location = strings.TrimSpace(location)
+	if location == "" {
+		return "/"
+	}
+	return location
+}
+
+func normalizeApplyExpireAt(value string) string {
+	return formatUserExpireAt(parseUserExpireAt(value))
+}
+
+func normalizeApplyManagers(managers []string, owner string) []string {
+	seen := make(map[string]struct{}, len(managers))
+	normalized := make([]string, 0, len(managers))
+	for _, username := range managers {
+		username = strings.TrimSpace(username)
+		if _, dup := seen[username]; dup || username == "" || username == owner {
+			continue
+		}
+		seen[username] = struct{}{}
+		normalized = append(normalized, username)
+	}
+	if len(normalized) == 0 {
+		return nil
+	}
+	sort.Strings(normalized)
+	return normalized
+}
+
+func adminIndexMutationContext(allowLocal bool) IndexMutationContext {
+	return IndexMutationContext{
+		Principal:       Principal{Authenticated: true, Kind: "admin", IsAdmin: true, Permissions: []string{PermissionAll}},
+		AllowLocalProxy: allowLocal,
+		AllowUserLocal:  allowLocal,
+	}
+}
+
+func sortApplyChanges(changes []ApplyChange) {
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Key < changes[j].Key })
+}
+
+func keySet(values map[string]int) map[string]struct{} {
+	set := make(map[string]struct{}, len(values))
+	for key := range values {
+		set[key] = struct{}{}
+	}
+	return set
+}
+
+func stringValue(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
+
+func (s DefaultApplyService) repo() ApplyRepository {
+	if !isNilServiceValue(s.Repo) {
+		return s.Repo
+	}
+	if !isNilServiceValue(s.Backend.Repository) {
+		return s.Backend.Repository
+	}
+	return DefaultBackend().Repository
+}
+
+func (s DefaultApplyService) config() *servercfg.Snapshot {
+	return servercfg.ResolveProvider(s.ConfigProvider)
+}
+
+func (s DefaultApplyService) users() UserService {
+	if !isNilServiceValue(s.Users) {
+		return s.Users
+	}
+	return DefaultUserService{ConfigProvider: s.ConfigProvider, Backend: s.Backend}
+}
+
+func (s DefaultApplyService) clients() ClientService {
+	if !isNilServiceValue(s.Clients) {
+		return s.Clients
+	}
+	return DefaultClientService{ConfigProvider: s.ConfigProvider, Backend: s.Backend}
+}
+
+func (s DefaultApplyService) index() IndexService {
+	if !isNilServiceValue(s.Index) {
+		return s.Index
+	}
+	return DefaultIndexService{Backend: s.Backend, QuotaStore: DefaultQuotaStore{}}
+}
+
+func (s DefaultApplyService) globals() GlobalService {
+	if !isNilServiceValue(s.Globals) {
+		return s.Globals
+	}
+	return DefaultGlobalService{Backend: s.Backend}
+}
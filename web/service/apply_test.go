@@ -0,0 +1,186 @@
+package service
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/djylb/nps/lib/file"
+	"github.com/djylb/nps/lib/servercfg"
+)
+
+const applyTestDocument = `
+users:
+  - username: alice
+    password: secret
+    max_tunnels: 5
+    labels: {team: payments}
+clients:
+  - verify_key: edge-1
+    owner: alice
+    remark: edge
+    labels: {env: staging}
+tunnels:
+  - client: edge-1
+    mode: tcp
+    port: 18080
+    target: 127.0.0.1:8080
+    remark: web
+  - client: edge-1
+    mode: udp
+    port: 18081
+    target: 127.0.0.1:53
+    status: false
+hosts:
+  - client: edge-1
+    host: app.example.com
+    target: 127.0.0.1:3000
+`
+
+func newApplyTestService() DefaultApplyService {
+	runtime := stubRuntime{
+		stopTunnel:  func(id int) error { return file.GetDb().UpdateTaskStatus(id, false) },
+		startTunnel: func(id int) error { return file.GetDb().UpdateTaskStatus(id, true) },
+	}
+	backend := Backend{Repository: defaultRepository{}, Runtime: runtime}
+	return DefaultApplyService{
+		ConfigProvider: func() *servercfg.Snapshot { return &servercfg.Snapshot{} },
+		Backend:        backend,
+	}
+}
+
+func TestDefaultApplyServiceConvergesAndIsIdempotent(t *testing.T) {
+	resetBackendTestDB(t)
+	state, err := ParseDesiredState([]byte(applyTestDocument))
+	if err != nil {
+		t.Fatalf("ParseDesiredState() error = %v", err)
+	}
+	service := newApplyTestService()
+
+	plan, err := service.Apply(ApplyInput{State: state, DryRun: true})
+	if err != nil {
+		t.Fatalf("Apply(dry run) error = %v", err)
+	}
+	if len(plan.Changes) != 5 || plan.Applied != 0 {
+		t.Fatalf("Apply(dry run) = %+v, want 5 planned creates", plan)
+	}
+	for _, change := range plan.Changes {
+		if change.Action != ApplyActionCreate || change.Status != ApplyStatusPlanned {
+			t.Fatalf("dry run change = %+v", change)
+		}
+	}
+	if count := countApplyTestTunnels(); count != 0 {
+		t.Fatalf("dry run created %d tunnels", count)
+	}
+
+	result, err := service.Apply(ApplyInput{State: state})
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if result.Applied != 5 || result.Failed != 0 {
+		t.Fatalf("Apply() = %+v", result)
+	}
+	user, err := file.GetDb().GetUserByUsername("alice")
+	if err != nil || user.MaxTunnels != 5 {
+		t.Fatalf("user alice = %+v, %v", user, err)
+	}
+	client, err := file.GetDb().GetClientByVerifyKey("edge-1")
+	if err != nil || client.OwnerID() != user.Id || client.Labels["env"] != "staging" {
+		t.Fatalf("client edge-1 = %+v, %v", client, err)
+	}
+	file.GetDb().JsonDb.Tasks.Range(func(_, value interface{}) bool {
+		if tunnel := value.(*file.Tunnel); tunnel.Mode == "udp" && tunnel.Status {
+			t.Errorf("udp tunnel should be stopped")
+		}
+		return true
+	})
+
+	again, err := service.Apply(ApplyInput{State: state})
+	if err != nil {
+		t.Fatalf("second Apply() error = %v", err)
+	}
+	if len(again.Changes) != 0 || again.Unchanged != 5 {
+		t.Fatalf("second Apply() = %+v, want no changes", again)
+	}
+
+	state.Tunnels = state.Tunnels[:1]
+	state.Tunnels[0].Remark = "web v2"
+	state.Hosts = []DesiredHost{}
+	result, err = service.Apply(ApplyInput{State: state})
+	if err != nil {
+		t.Fatalf("third Apply() error = %v", err)
+	}
+	var got []string
+	for _, change := range result.Changes {
+		got = append(got, change.Resource+" "+change.Action)
+	}
+	if want := []string{"host delete", "tunnel delete", "tunnel update"}; !reflect.DeepEqual(got, want) || result.Failed != 0 {
+		t.Fatalf("third Apply() = %v (%+v), want %v", got, result, want)
+	}
+	if !reflect.DeepEqual(result.Changes[2].Fields, []string{"remark"}) {
+		t.Fatalf("tunnel update fields = %v", result.Changes[2].Fields)
+	}
+	if count := countApplyTestTunnels(); count != 1 {
+		t.Fatalf("tunnels after apply = %d, want 1", count)
+	}
+}
+
+func TestDefaultApplyServiceUpdatesCarryPlannedRevision(t *testing.T) {
+	resetBackendTestDB(t)
+	service := newApplyTestService()
+	state, _ := ParseDesiredState([]byte(applyTestDocument))
+	if _, err := service.Apply(ApplyInput{State: state}); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+
+	state.Users[0].MaxTunnels = 9
+	state.Clients[0].Remark = "edge v2"
+	plan, err := service.plan(state)
+	if err != nil {
+		t.Fatalf("plan() error = %v", err)
+	}
+	user, _ := file.GetDb().GetUserByUsername("alice")
+	if _, err := (DefaultUserService{Backend: service.Backend}).Edit(EditUserInput{
+		ID:         user.Id,
+		Username:   "alice",
+		Password:   "secret",
+		MaxTunnels: 7,
+	}); err != nil {
+		t.Fatalf("concurrent Edit() error = %v", err)
+	}
+	if len(plan.changes) != 2 {
+		t.Fatalf("plan = %+v, want user and client updates", plan.changes)
+	}
+	if err := plan.changes[0].run(&plan.changes[0]); !errors.Is(err, ErrRevisionConflict) {
+		t.Fatalf("stale user update error = %v, want ErrRevisionConflict", err)
+	}
+}
+
+func TestParseDesiredStateRejectsInvalidDocuments(t *testing.T) {
+	resetBackendTestDB(t)
+	service := newApplyTestService()
+	for name, document := range map[string]string{
+		"unknown field":  "users:\n  - username: alice\n    pasword: x\n",
+		"duplicate user": "users:\n  - username: alice\n  - username: alice\n",
+		"unknown owner":  "users: []\nclients:\n  - verify_key: a\n    owner: bob\n",
+		"unknown client": "tunnels:\n  - client: missing\n    mode: tcp\n    port: 1\n",
+		"no tunnel key":  "clients:\n  - verify_key: a\ntunnels:\n  - client: a\n    mode: secret\n",
+	} {
+		state, err := ParseDesiredState([]byte(document))
+		if err == nil {
+			_, err = service.Apply(ApplyInput{State: state, DryRun: true})
+		}
+		if !errors.Is(err, ErrDesiredStateInvalid) {
+			t.Errorf("%s: error = %v, want ErrDesiredStateInvalid", name, err)
+		}
+	}
+}
+
+func countApplyTestTunnels() int {
+	count := 0
+	file.GetDb().JsonDb.Tasks.Range(func(_, _ interface{}) bool {
+		count++
+		return true
+	})
+	return count
+}
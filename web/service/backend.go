@@ -330,7 +330,14 @@ func (defaultRuntime) DisconnectClient(id int) {
 }
 
 func (defaultRuntime) DeleteClientResources(id int) {
+	tunnelIDs, hostIDs := journalResourceIDsForClient(id)
 	server.DelTunnelAndHostByClientId(id, false)
+	for _, tunnelID := range tunnelIDs {
+		journalTunnel(tunnelID)
+	}
+	for _, hostID := range hostIDs {
+		journalHost(hostID)
+	}
 }
 
 func (defaultRuntime) GenerateTunnelPort(tunnel *file.Tunnel) int {
@@ -358,11 +365,19 @@ func (defaultRuntime) AddTunnel(tunnel *file.Tunnel) error {
 }
 
 func (defaultRuntime) StopTunnel(id int) error {
-	return server.StopServer(id)
+	if err := server.StopServer(id); err != nil {
+		return err
+	}
+	journalTunnel(id)
+	return nil
 }
 
 func (defaultRuntime) StartTunnel(id int) error {
-	return server.StartTask(id)
+	if err := server.StartTask(id); err != nil {
+		return err
+	}
+	journalTunnel(id)
+	return nil
 }
 
 func (defaultRuntime) DeleteTunnel(id int) error {
@@ -0,0 +1,370 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/djylb/nps/lib/certinventory"
+	"github.com/djylb/nps/lib/common"
+	"github.com/djylb/nps/lib/crypt"
+	"github.com/djylb/nps/lib/file"
+)
+
+// CertStoreService manages the named certificates of the certificate store.
+// Hosts reference them by Host.CertID; saving a certificate writes its new
+// content into every host that references it.
+type CertStoreService interface {
+	List() ([]StoredCertPayload, error)
+	Get(int) (StoredCertPayload, error)
+	Create(SaveStoredCertInput) (StoredCertPayload, error)
+	Update(SaveStoredCertInput) (StoredCertPayload, error)
+	Delete(int) (StoredCertPayload, error)
+	Match(string) (StoredCertPayload, bool)
+}
+
+type CertStoreRepository interface {
+	RangeHosts(func(*file.Host) bool)
+	GetHost(int) (*file.Host, error)
+	SaveHost(*file.Host, string) error
+}
+
+type CertStoreRuntime interface {
+	RemoveHostCache(int)
+}
+
+type DefaultCertStoreService struct {
+	Repo    CertStoreRepository
+	Runtime CertStoreRuntime
+	Backend Backend
+	// Store defaults to file.CurrentCertStore().
+	Store *file.CertStore
+	Now   func() time.Time
+}
+
+// SaveStoredCertInput creates a certificate when ID is 0 and replaces
+// certificate ID otherwise. CertFile and KeyFile take PEM content or a path
+// on the server, which is read once and stored as content.
+type SaveStoredCertInput struct {
+	ID       int
+	Name     string
+	Remark   string
+	CertFile string
+	KeyFile  string
+}
+
+// StoredCertPayload describes a stored certificate and the hosts that use
+// it. The private key is never returned; CertFile is only set by Get.
+type StoredCertPayload struct {
+	ID        int    `json:"id"`
+	Name      string `json:"name"`
+	Remark    string `json:"remark,omitempty"`
+	CreatedAt int64  `json:"created_at"`
+	UpdatedAt int64  `json:"updated_at"`
+	certinventory.Info
+	DaysLeft int               `json:"days_left"`
+	Expired  bool              `json:"expired"`
+	Error    string            `json:"error,omitempty"`
+	Hosts    []CertificateHost `json:"hosts"`
+	CertFile string            `json:"cert_file,omitempty"`
+	// Revisions holds the host revisions an update wrote.
+	Revisions []file.RevisionRef `json:"-"`
+}
+
+func (s DefaultCertStoreService) List() ([]StoredCertPayload, error) {
+	certs, err := s.store().List()
+	if err != nil {
+		return nil, mapCertStoreError(err)
+	}
+	hosts := s.referencingHosts(0)
+	items := make([]StoredCertPayload, 0, len(certs))
+	for _, cert := range certs {
+		items = append(items, s.payload(cert, hosts[cert.ID]))
+	}
+	return items, nil
+}
+
+func (s DefaultCertStoreService) Get(id int) (StoredCertPayload, error) {
+	cert, err := s.store().Get(id)
+	if err != nil {
+		return StoredCertPayload{}, mapCertStoreError(err)
+	}
+	payload := s.payload(cert, s.referencingHosts(id)[id])
+	payload.CertFile = cert.CertFile
+	return payload, nil
+}
+
+func (s DefaultCertStoreService) Create(input SaveStoredCertInput) (StoredCertPayload, error) {
+	cert, _, err := normalizeStoredCertInput(input)
+	if err != nil {
+		return StoredCertPayload{}, err
+	}
+	created, err := s.store().Create(cert)
+	if err != nil {
+		return StoredCertPayload{}, mapCertStoreError(err)
+	}
+	return s.payload(created, nil), nil
+}
+
+// Update replaces a stored certificate after checking that it still covers
+// the domain of every host that references it, then writes the new content
+// into those hosts. A certificate that would leave a host uncovered is
+// rejected as a whole, so no host is left serving a mismatched certificate.
+func (s DefaultCertStoreService) Update(input SaveStoredCertInput) (StoredCertPayload, error) {
+	store := s.store()
+	if _, err := store.Get(input.ID); err != nil {
+		return StoredCertPayload{}, mapCertStoreError(err)
+	}
+	cert, domains, err := normalizeStoredCertInput(input)
+	if err != nil {
+		return StoredCertPayload{}, err
+	}
+	hosts := s.referencingHosts(input.ID)[input.ID]
+	for _, host := range hosts {
+		if !certDomainsCoverHostRule(domains, host.Host) {
+			return StoredCertPayload{}, storedCertMismatch(host.Host, domains)
+		}
+	}
+	updated, err := store.Update(cert)
+	if err != nil {
+		return StoredCertPayload{}, mapCertStoreError(err)
+	}
+	revisions, err := s.syncHosts(updated, hosts)
+	if err != nil {
+		return StoredCertPayload{}, err
+	}
+	payload := s.payload(updated, hosts)
+	payload.Revisions = revisions
+	return payload, nil
+}
+
+// Delete removes a certificate no host references any more.
+func (s DefaultCertStoreService) Delete(id int) (StoredCertPayload, error) {
+	store := s.store()
+	cert, err := store.Get(id)
+	if err != nil {
+		return StoredCertPayload{}, mapCertStoreError(err)
+	}
+	if hosts := s.referencingHosts(id)[id]; len(hosts) > 0 {
+		names := make([]string, 0, len(hosts))
+		for _, host := range hosts {
+			names = append(names, host.Host)
+		}
+		return StoredCertPayload{}, fmt.Errorf("%w: used by %s", ErrStoredCertificateInUse, strings.Join(names, ", "))
+	}
+	if _, err := store.Delete(id); err != nil {
+		return StoredCertPayload{}, mapCertStoreError(err)
+	}
+	return s.payload(cert, nil), nil
+}
+
+// Match returns the unexpired stored certificate covering hostRule that
+// expires last.
+func (s DefaultCertStoreService) Match(hostRule string) (StoredCertPayload, bool) {
+	certs, err := s.store().List()
+	if err != nil {
+		return StoredCertPayload{}, false
+	}
+	var best StoredCertPayload
+	found := false
+	for _, cert := range certs {
+		domains, err := common.LoadCertDomains(cert.CertFile, cert.KeyFile)
+		if err != nil || !certDomainsCoverHostRule(domains, hostRule) {
+			continue
+		}
+		payload := s.payload(cert, nil)
+		if payload.Error == "" && !payload.Expired && (!found || payload.NotAfter > best.NotAfter) {
+			best, found = payload, true
+		}
+	}
+	return best, found
+}
+
+func (s DefaultCertStoreService) syncHosts(cert file.StoredCert, hosts []CertificateHost) ([]file.RevisionRef, error) {
+	repo := s.repo()
+	certType := common.GetCertType(cert.CertFile)
+	certHash := crypt.FNV1a64(certType, cert.CertFile, cert.KeyFile)
+	var revisions []file.RevisionRef
+	for _, ref := range hosts {
+		host, err := repo.GetHost(ref.ID)
+		switch {
+		case err == nil:
+		case errors.Is(err, file.ErrHostNotFound):
+			continue
+		default:
+			return revisions, err
+		}
+		if host == nil || host.CertID != cert.ID {
+			continue
+		}
+		working := ensureDetachedHostMutation(repo, host)
+		working.CertFile = cert.CertFile
+		working.KeyFile = cert.KeyFile
+		working.CertType = certType
+		working.CertHash = certHash
+		working.TouchMeta()
+		if err := repo.SaveHost(working, ""); err != nil {
+			if errors.Is(err, file.ErrHostNotFound) {
+				continue
+			}
+			return revisions, err
+		}
+		revisions = append(revisions, file.RevisionRef{ID: working.Id, Revision: working.Revision})
+		s.runtime().RemoveHostCache(working.Id)
+	}
+	return revisions, nil
+}
+
+// referencingHosts groups the hosts by the stored certificate they use,
+// limited to certificate id when it is set.
+func (s DefaultCertStoreService) referencingHosts(id int) map[int][]CertificateHost {
+	refs := make(map[int][]CertificateHost)
+	s.repo().RangeHosts(func(host *file.Host) bool {
+		if host != nil && host.CertID > 0 && (id == 0 || host.CertID == id) {
+			refs[host.CertID] = append(refs[host.CertID], CertificateHost{ID: host.Id, Host: host.Host, ClientID: hostClientID(host), client: host.Client})
+		}
+		return true
+	})
+	for _, hosts := range refs {
+		sort.Slice(hosts, func(i, j int) bool { return hosts[i].ID < hosts[j].ID })
+	}
+	return refs
+}
+
+func (s DefaultCertStoreService) payload(cert file.StoredCert, hosts []CertificateHost) StoredCertPayload {
+	payload := StoredCertPayload{
+		ID:        cert.ID,
+		Name:      cert.Name,
+		Remark:    cert.Remark,
+		CreatedAt: cert.CreatedAt,
+		UpdatedAt: cert.UpdatedAt,
+		Hosts:     hosts,
+	}
+	if payload.Hosts == nil {
+		payload.Hosts = []CertificateHost{}
+	}
+	leaf, err := certinventory.ParsePEM([]byte(cert.CertFile))
+	if err != nil {
+		payload.Error = err.Error()
+		return payload
+	}
+	now := s.now()
+	payload.Info = certinventory.Describe(leaf)
+	payload.DaysLeft = certinventory.DaysLeft(payload.NotAfter, now)
+	payload.Expired = now.Unix() >= payload.NotAfter
+	return payload
+}
+
+// normalizeStoredCertInput reads the certificate and key, checks that they
+// form a pair and returns the names the certificate covers.
+func normalizeStoredCertInput(input SaveStoredCertInput) (file.StoredCert, []string, error) {
+	cert := file.StoredCert{
+		ID:     input.ID,
+		Name:   strings.TrimSpace(input.Name),
+		Remark: strings.TrimSpace(input.Remark),
+	}
+	if cert.Name == "" {
+		return cert, nil, fmt.Errorf("%w: name is required", ErrInvalidStoredCertificate)
+	}
+	var err error
+	if cert.CertFile, err = common.GetCertContent(input.CertFile, "CERTIFICATE"); err != nil {
+		return cert, nil, fmt.Errorf("%w: %v", ErrInvalidStoredCertificate, err)
+	}
+	if cert.KeyFile, err = common.GetCertContent(input.KeyFile, "PRIVATE"); err != nil {
+		return cert, nil, fmt.Errorf("%w: %v", ErrInvalidStoredCertificate, err)
+	}
+	if _, err := loadCertificatePair(cert.CertFile, cert.KeyFile); err != nil {
+		return cert, nil, fmt.Errorf("%w: %v", ErrInvalidStoredCertificate, err)
+	}
+	domains, err := common.LoadCertDomains(cert.CertFile, cert.KeyFile)
+	if err != nil || len(domains) == 0 {
+		return cert, nil, fmt.Errorf("%w: certificate has no domain names", ErrInvalidStoredCertificate)
+	}
+	return cert, domains, nil
+}
+
+// resolveHostCertInput returns the certificate content a host is saved
+// with: the content of stored certificate certID when it is set, otherwise
+// the certFile and keyFile given with the host. Only administrators may
+// point a host at a different stored certificate.
+func resolveHostCertInput(certID, currentID int, hostRule, certFile, keyFile string, isAdmin bool) (string, string, error) {
+	if certID <= 0 {
+		return certFile, keyFile, nil
+	}
+	if certID != currentID && !isAdmin {
+		return "", "", ErrForbidden
+	}
+	cert, err := storedCertForHost(file.CurrentCertStore(), certID, hostRule)
+	if err != nil {
+		return "", "", err
+	}
+	return cert.CertFile, cert.KeyFile, nil
+}
+
+func storedCertForHost(store *file.CertStore, id int, hostRule string) (file.StoredCert, error) {
+	cert, err := store.Get(id)
+	if err != nil {
+		return file.StoredCert{}, mapCertStoreError(err)
+	}
+	domains, err := common.LoadCertDomains(cert.CertFile, cert.KeyFile)
+	if err != nil {
+		return file.StoredCert{}, fmt.Errorf("%w: %v", ErrInvalidStoredCertificate, err)
+	}
+	if !certDomainsCoverHostRule(domains, hostRule) {
+		return file.StoredCert{}, storedCertMismatch(hostRule, domains)
+	}
+	return cert, nil
+}
+
+func storedCertMismatch(hostRule string, domains []string) error {
+	return fmt.Errorf("%w: %s is not covered by %s", ErrCertificateSANMismatch, hostRule, strings.Join(domains, ", "))
+}
+
+func mapCertStoreError(err error) error {
+	switch {
+	case errors.Is(err, file.ErrStoredCertNotFound):
+		return ErrStoredCertificateNotFound
+	case errors.Is(err, file.ErrStoredCertExists):
+		return ErrStoredCertificateExists
+	case errors.Is(err, file.ErrCertStoreDisabled):
+		return ErrCertStoreDisabled
+	default:
+		return err
+	}
+}
+
+func (s DefaultCertStoreService) store() *file.CertStore {
+	if s.Store != nil {
+		return s.Store
+	}
+	return file.CurrentCertStore()
+}
+
+func (s DefaultCertStoreService) repo() CertStoreRepository {
+	if !isNilServiceValue(s.Repo) {
+		return s.Repo
+	}
+	if !isNilServiceValue(s.Backend.Repository) {
+		return s.Backend.Repository
+	}
+	return DefaultBackend().Repository
+}
+
+func (s DefaultCertStoreService) runtime() CertStoreRuntime {
+	if !isNilServiceValue(s.Runtime) {
+		return s.Runtime
+	}
+	if !isNilServiceValue(s.Backend.Runtime) {
+		return s.Backend.Runtime
+	}
+	return DefaultBackend().Runtime
+}
+
+func (s DefaultCertStoreService) now() time.Time {
+	if s.Now != nil {
+		return s.Now()
+	}
+	return time.Now()
+}
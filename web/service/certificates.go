@@ -0,0 +1,326 @@
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/djylb/nps/lib/certinventory"
+	"github.com/djylb/nps/lib/common"
+	"github.com/djylb/nps/lib/crypt"
+	"github.com/djylb/nps/lib/file"
+	"github.com/djylb/nps/lib/servercfg"
+)
+
+// certificateRenewTimeout bounds one renew action, including the ACME order.
+const certificateRenewTimeout = 3 * time.Minute
+
+// CertificateService lists the certificates served by domain hosts and
+// renews them on demand.
+type CertificateService interface {
+	List(CertificateListInput) ([]CertificatePayload, error)
+	Renew(RenewCertificateInput) (CertificatePayload, error)
+}
+
+type CertificateRepository interface {
+	RangeHosts(func(*file.Host) bool)
+}
+
+type DefaultCertificateService struct {
+	Repo           CertificateRepository
+	Backend        Backend
+	ConfigProvider func() *servercfg.Snapshot
+	// Inventory defaults to the process-wide certificate inventory.
+	Inventory *certinventory.Inventory
+	Now       func() time.Time
+}
+
+type CertificateListInput struct {
+	Scope NodeAccessScope
+}
+
+type RenewCertificateInput struct {
+	Scope NodeAccessScope
+	ID    string
+}
+
+type CertificateHost struct {
+	ID       int    `json:"id"`
+	Host     string `json:"host"`
+	ClientID int    `json:"client_id"`
+
+	client *file.Client
+}
+
+// CertificatePayload is one certificate in use. Hosts sharing the same leaf
+// are listed together; a certificate that could not be read or was not
+// issued yet is listed per source with Error set.
+type CertificatePayload struct {
+	ID     string `json:"id"`
+	Source string `json:"source"`
+	certinventory.Info
+	DaysLeft         int               `json:"days_left"`
+	Expired          bool              `json:"expired"`
+	Error            string            `json:"error,omitempty"`
+	Hosts            []CertificateHost `json:"hosts"`
+	LastRenewalAt    int64             `json:"last_renewal_at,omitempty"`
+	LastRenewalError string            `json:"last_renewal_error,omitempty"`
+
+	renewKeys []string
+	certFile  string
+	keyFile   string
+	certHash  string
+}
+
+func (s DefaultCertificateService) List(input CertificateListInput) ([]CertificatePayload, error) {
+	entries := s.collect()
+	items := make([]CertificatePayload, 0, len(entries))
+	for _, entry := range entries {
+		if entry, ok := scopeCertificate(entry, input.Scope); ok {
+			items = append(items, entry)
+		}
+	}
+	return items, nil
+}
+
+// Renew asks the HTTPS entry for a new certificate. ACME certificates are
+// renewed now even when they are not due; manual and default certificates
+// are read from their files again, which is how an uploaded replacement
+// takes effect without waiting for the reload interval.
+func (s DefaultCertificateService) Renew(input RenewCertificateInput) (CertificatePayload, error) {
+	id := strings.TrimSpace(input.ID)
+	var found *CertificatePayload
+	for _, entry := range s.collect() {
+		if entry.ID == id {
+			found = &entry
+			break
+		}
+	}
+	if found == nil {
+		return CertificatePayload{}, ErrCertificateNotFound
+	}
+	entry, ok := scopeCertificate(*found, input.Scope)
+	if !ok {
+		return CertificatePayload{}, ErrForbidden
+	}
+
+	var err error
+	inventory := s.inventory()
+	switch entry.Source {
+	case certinventory.SourceACME:
+		ctx, cancel := context.WithTimeout(context.Background(), certificateRenewTimeout)
+		err = inventory.RenewACME(ctx, entry.Hosts[0].Host)
+		cancel()
+	default:
+		_, loadErr := loadCertificatePair(entry.certFile, entry.keyFile)
+		err = inventory.ReloadManual(entry.certHash, loadErr)
+	}
+	if errors.Is(err, certinventory.ErrUnavailable) {
+		return CertificatePayload{}, fmt.Errorf("%w: %v", ErrCertificateRenewUnavailable, err)
+	}
+
+	renewed := entry
+	if after, ok := s.find(id, entry); ok {
+		renewed = after
+	}
+	if err != nil {
+		return renewed, fmt.Errorf("%w: %v", ErrCertificateRenewFailed, err)
+	}
+	return renewed, nil
+}
+
+// find returns the entry again after a renewal, which changes the
+// fingerprint and so the id of a renewed certificate.
+func (s DefaultCertificateService) find(id string, before CertificatePayload) (CertificatePayload, bool) {
+	entries := s.collect()
+	for _, entry := range entries {
+		if entry.ID == id {
+			return entry, true
+		}
+	}
+	for _, entry := range entries {
+		if entry.Source == before.Source && len(entry.Hosts) > 0 && len(before.Hosts) > 0 && entry.Hosts[0].ID == before.Hosts[0].ID {
+			return entry, true
+		}
+	}
+	return CertificatePayload{}, false
+}
+
+// collect builds the inventory from the hosts that terminate TLS here.
+func (s DefaultCertificateService) collect() []CertificatePayload {
+	var hosts []*file.Host
+	s.repo().RangeHosts(func(host *file.Host) bool {
+		if host != nil && !host.IsClose && !host.HttpsJustProxy && !strings.EqualFold(strings.TrimSpace(host.Scheme), "http") {
+			hosts = append(hosts, host)
+		}
+		return true
+	})
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].Id < hosts[j].Id })
+
+	cfg := servercfg.ResolveProvider(s.ConfigProvider)
+	inventory := s.inventory()
+	now := s.now()
+	entries := make(map[string]*CertificatePayload)
+	order := make([]string, 0)
+	add := func(entry CertificatePayload, host *file.Host) {
+		current, ok := entries[entry.ID]
+		if !ok {
+			current = &entry
+			entries[entry.ID] = current
+			order = append(order, entry.ID)
+		} else {
+			for _, key := range entry.renewKeys {
+				if !slices.Contains(current.renewKeys, key) {
+					current.renewKeys = append(current.renewKeys, key)
+				}
+			}
+		}
+		current.Hosts = append(current.Hosts, CertificateHost{ID: host.Id, Host: host.Host, ClientID: hostClientID(host), client: host.Client})
+	}
+
+	for _, host := range hosts {
+		if host.AutoSSL {
+			entry := CertificatePayload{Source: certinventory.SourceACME, renewKeys: []string{certinventory.ACMEKey(host.Host)}}
+			if cert, ok := inventory.ACMECertificate(host.Host); ok {
+				entry.Info = certinventory.Describe(cert)
+				entry.ID = entry.Fingerprint
+				for _, name := range cert.DNSNames {
+					entry.renewKeys = append(entry.renewKeys, certinventory.ACMEKey(name))
+				}
+			} else {
+				entry.ID = certinventory.SourceACME + ":" + strings.ToLower(host.Host)
+				entry.Error = "certificate not issued yet"
+			}
+			add(entry, host)
+			continue
+		}
+
+		// Same choice as the HTTPS entry: the host's own pair, the pair of
+		// another host covering the name, then https_default_cert_file.
+		source := certinventory.SourceManual
+		certSource := host
+		if !certificateConfigured(host.CertFile, host.KeyFile) {
+			certSource = file.SelectReusableCertHost(host.Host, hosts, host.Id)
+		}
+		var certFile, keyFile, hash string
+		switch {
+		case certSource != nil:
+			certFile, keyFile, hash = certSource.CertFile, certSource.KeyFile, certSource.CertHash
+			if hash == "" {
+				hash = crypt.FNV1a64(common.GetCertType(certFile), certFile, keyFile)
+			}
+		case certificateConfigured(cfg.Proxy.SSL.DefaultCertFile, cfg.Proxy.SSL.DefaultKeyFile):
+			source = certinventory.SourceDefault
+			certFile, keyFile = cfg.Proxy.SSL.DefaultCertFile, cfg.Proxy.SSL.DefaultKeyFile
+			hash = crypt.FNV1a64("file", certFile, keyFile)
+		default:
+			continue
+		}
+		entry := CertificatePayload{
+			Source:    source,
+			renewKeys: []string{certinventory.ManualKey(hash)},
+			certFile:  certFile,
+			keyFile:   keyFile,
+			certHash:  hash,
+		}
+		if leaf, err := loadCertificateLeaf(certFile); err != nil {
+			entry.ID = source + ":" + hash
+			entry.Error = err.Error()
+		} else {
+			entry.Info = certinventory.Describe(leaf)
+			entry.ID = entry.Fingerprint
+		}
+		add(entry, host)
+	}
+
+	items := make([]CertificatePayload, 0, len(order))
+	for _, id := range order {
+		entry := entries[id]
+		if entry.NotAfter != 0 {
+			entry.DaysLeft = certinventory.DaysLeft(entry.NotAfter, now)
+			entry.Expired = now.Unix() >= entry.NotAfter
+		}
+		for _, key := range entry.renewKeys {
+			if attempt, ok := inventory.LastAttempt(key); ok && attempt.At > entry.LastRenewalAt {
+				entry.LastRenewalAt = attempt.At
+				entry.LastRenewalError = attempt.Error
+			}
+		}
+		items = append(items, *entry)
+	}
+	return items
+}
+
+// scopeCertificate keeps the hosts the scope may see and reports whether
+// any is left.
+func scopeCertificate(entry CertificatePayload, scope NodeAccessScope) (CertificatePayload, bool) {
+	hosts := make([]CertificateHost, 0, len(entry.Hosts))
+	for _, host := range entry.Hosts {
+		if scope.AllowsClient(host.client) {
+			hosts = append(hosts, host)
+		}
+	}
+	entry.Hosts = hosts
+	return entry, len(hosts) > 0
+}
+
+func hostClientID(host *file.Host) int {
+	if host.Client == nil {
+		return 0
+	}
+	return host.Client.Id
+}
+
+func certificateConfigured(certFile, keyFile string) bool {
+	return strings.TrimSpace(certFile) != "" && strings.TrimSpace(keyFile) != ""
+}
+
+func loadCertificateLeaf(certFile string) (*x509.Certificate, error) {
+	content, err := common.GetCertContent(certFile, "CERTIFICATE")
+	if err != nil {
+		return nil, err
+	}
+	return certinventory.ParsePEM([]byte(content))
+}
+
+func loadCertificatePair(certFile, keyFile string) (tls.Certificate, error) {
+	certContent, err := common.GetCertContent(certFile, "CERTIFICATE")
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	keyContent, err := common.GetCertContent(keyFile, "PRIVATE")
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.X509KeyPair([]byte(certContent), []byte(keyContent))
+}
+
+func (s DefaultCertificateService) repo() CertificateRepository {
+	if !isNilServiceValue(s.Repo) {
+		return s.Repo
+	}
+	if !isNilServiceValue(s.Backend.Repository) {
+		return s.Backend.Repository
+	}
+	return DefaultBackend().Repository
+}
+
+func (s DefaultCertificateService) inventory() *certinventory.Inventory {
+	if s.Inventory != nil {
+		return s.Inventory
+	}
+	return certinventory.Default()
+}
+
+func (s DefaultCertificateService) now() time.Time {
+	if s.Now != nil {
+		return s.Now()
+	}
+	return time.Now()
+}
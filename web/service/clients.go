@@ -47,6 +47,7 @@ type ListClientsInput struct {
 	Sort       string
 	Order      string
 	Host       string
+	Selector   file.LabelSelector
 	Visibility ClientVisibility
 }
 
@@ -74,6 +75,7 @@ type AddClientInput struct {
 	ManagerUserIDs        []int
 	VKey                  string
 	Remark                string
+	Labels                map[string]string
 	User                  string
 	Password              string
 	Compress              bool
@@ -105,6 +107,8 @@ type EditClientInput struct {
 	ManagerUserIDs          []int
 	VKey                    string
 	Remark                  string
+	Labels                  map[string]string
+	LabelsSpecified         bool
 	User                    string
 	Password                string
 	PasswordProvided        bool
@@ -142,6 +146,8 @@ type ClientWriteRequest struct {
 	ManagerUserIDs          []int
 	VKey                    string
 	Remark                  string
+	Labels                  map[string]string
+	LabelsSpecified         bool
 	User                    string
 	Password                string
 	PasswordProvided        bool
@@ -186,7 +192,7 @@ type clientIDScope struct {
 }
 
 func (s DefaultClientService) List(input ListClientsInput) ListClientsResult {
-	rows, count := s.repo().ListVisibleClients(input)
+	rows, count := listClientsBySelector(s.repo(), input)
 	bridge := BestBridge(s.config(), input.Host)
 	port, _ := strconv.Atoi(bridge.Port)
 	return ListClientsResult{
@@ -202,11 +208,16 @@ func (s DefaultClientService) List(input ListClientsInput) ListClientsResult {
 func (s DefaultClientService) Add(input AddClientInput) (ClientMutation, error) {
 	id := s.repo().NextClientID()
 	entryACLMode, entryACLRules := normalizeEntryACLInput(input.EntryACLMode, input.EntryACLRules)
+	labels, err := normalizeLabelsInput(input.Labels)
+	if err != nil {
+		return ClientMutation{}, err
+	}
 	client := &file.Client{
 		VerifyKey: input.VKey,
 		Id:        id,
 		Status:    true,
 		Remark:    input.Remark,
+		Labels:    labels,
 		Cnf: &file.Config{
 			U:        input.User,
 			P:        input.Password,
@@ -306,6 +317,13 @@ func (s DefaultClientService) Edit(input EditClientInput) (ClientMutation, error
 	}
 
 	working.Remark = input.Remark
+	if input.LabelsSpecified {
+		labels, err := normalizeLabelsInput(input.Labels)
+		if err != nil {
+			return ClientMutation{}, err
+		}
+		working.Labels = labels
+	}
 	working.Cnf.U = input.User
 	if input.PasswordProvided {
 		working.Cnf.P = input.Password
@@ -420,7 +438,12 @@ func (s DefaultClientService) Delete(id int) (ClientMutation, error) {
 		return ClientMutation{}, ErrForbidden
 	}
 	working := ensureDetachedClientSnapshot(repo, client)
+	trashID, err := trashClient(repo, working)
+	if err != nil {
+		return ClientMutation{}, err
+	}
 	if err := repo.DeleteClient(id); err != nil {
+		discardTrashEntry(trashID)
 		return ClientMutation{}, mapClientServiceError(err)
 	}
 	s.runtime().DisconnectClient(id)
@@ -457,6 +480,7 @@ func BuildAddClientInput(ctx ClientMutationContext, request AddClientRequest) Ad
 		ManagerUserIDs:        append([]int(nil), request.ManagerUserIDs...),
 		VKey:                  request.VKey,
 		Remark:                request.Remark,
+		Labels:                request.Labels,
 		User:                  request.User,
 		Password:              request.Password,
 		Compress:              request.Compress,
@@ -514,6 +538,8 @@ func BuildEditClientInput(ctx ClientMutationContext, request EditClientRequest)
 		ManagerUserIDs:          append([]int(nil), request.ManagerUserIDs...),
 		VKey:                    request.VKey,
 		Remark:                  request.Remark,
+		Labels:                  request.Labels,
+		LabelsSpecified:         request.LabelsSpecified,
 		User:                    request.User,
 		Password:                request.Password,
 		PasswordProvided:        request.PasswordProvided,
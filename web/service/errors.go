@@ -48,6 +48,34 @@ var (
 	ErrStandaloneTokenInvalid      = errors.New("invalid standalone token")
 	ErrStandaloneTokenExpired      = errors.New("standalone token expired")
 	ErrStandaloneTokenUnavailable  = errors.New("standalone token is unavailable")
+	ErrTrashDisabled               = errors.New("recycle bin is disabled")
+	ErrTrashEntryNotFound          = errors.New("trash entry not found")
+	ErrTrashRestoreTaken           = errors.New("resource id is already in use")
+	ErrInvalidLabels               = errors.New("invalid labels")
+	ErrLabelSelectorRequired       = errors.New("label selector is required")
+	ErrBulkActionUnsupported       = errors.New("unsupported bulk action")
+	ErrDesiredStateInvalid         = errors.New("invalid desired state")
+	ErrHistoryDisabled             = errors.New("revision history is disabled")
+	ErrRevisionNotFound            = errors.New("revision not found")
+	ErrUsageSeriesDisabled         = errors.New("traffic series are disabled")
+	ErrUsageQueryInvalid           = errors.New("invalid usage series query")
+	ErrQuotaPlanInvalid            = errors.New("invalid quota plan")
+	ErrInvalidHostCachePath        = errors.New("invalid host cache path")
+	ErrInvalidWAFRules             = errors.New("invalid waf rules")
+	ErrInvalidForwardAuth          = errors.New("invalid forward auth endpoint")
+	ErrInvalidClientCert           = errors.New("invalid client certificate settings")
+	ErrInvalidBodyRewrite          = errors.New("invalid body rewrite rules")
+	ErrInvalidAutoSSLDNS           = errors.New("invalid auto ssl dns provider")
+	ErrInvalidTLSPolicy            = errors.New("invalid tls policy")
+	ErrCertificateNotFound         = errors.New("certificate not found")
+	ErrCertificateRenewUnavailable = errors.New("certificate renewal is unavailable")
+	ErrCertificateRenewFailed      = errors.New("certificate renewal failed")
+	ErrCertStoreDisabled           = errors.New("certificate store is disabled")
+	ErrStoredCertificateNotFound   = errors.New("stored certificate not found")
+	ErrStoredCertificateExists     = errors.New("stored certificate name already exists")
+	ErrStoredCertificateInUse      = errors.New("stored certificate is used by hosts")
+	ErrInvalidStoredCertificate    = errors.New("invalid stored certificate")
+	ErrCertificateSANMismatch      = errors.New("certificate does not cover host")
 )
 
 func mapClientServiceError(err error) error {
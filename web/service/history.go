@@ -0,0 +1,409 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/djylb/nps/lib/file"
+	"github.com/djylb/nps/lib/logs"
+)
+
+type HistoryService interface {
+	List(resource string, id int) ([]HistoryRevision, error)
+	Revert(RevertRevisionInput) (RevertRevisionResult, error)
+	Attribute(resource string, refs []file.RevisionRef, attribution file.RevisionAttribution)
+}
+
+type HistoryRepository interface {
+	GetUser(int) (*file.User, error)
+	SaveUser(*file.User) error
+	GetClient(int) (*file.Client, error)
+	SaveClient(*file.Client) error
+	VerifyVKey(string, int) bool
+	GetTunnel(int) (*file.Tunnel, error)
+	SaveTunnel(*file.Tunnel) error
+	GetHost(int) (*file.Host, error)
+	SaveHost(*file.Host, string) error
+	HostExists(*file.Host) bool
+}
+
+type HistoryRuntime interface {
+	DisconnectClient(int)
+	TunnelPortAvailable(*file.Tunnel) bool
+	StopTunnel(int) error
+	StartTunnel(int) error
+	RemoveHostCache(int)
+}
+
+type DefaultHistoryService struct {
+	Repo    HistoryRepository
+	Runtime HistoryRuntime
+	Backend Backend
+}
+
+// HistoryRevision is one kept revision with the fields it changed compared
+// to the revision kept before it. The oldest kept revision is compared to an
+// empty record, so it lists every field that was set.
+type HistoryRevision struct {
+	file.RevisionEntry
+	Changes []file.RevisionFieldChange `json:"changes"`
+}
+
+type RevertRevisionInput struct {
+	Resource         string
+	ID               int
+	Revision         int64
+	ExpectedRevision int64
+}
+
+type RevertRevisionResult struct {
+	Resource string
+	ID       int
+	From     int64
+	Revision int64
+}
+
+// List returns the kept revisions of a record, newest first.
+func (s DefaultHistoryService) List(resource string, id int) ([]HistoryRevision, error) {
+	history := file.CurrentRevisionHistory()
+	if history == nil {
+		return nil, ErrHistoryDisabled
+	}
+	entries, err := history.List(resource, id)
+	if err != nil {
+		return nil, err
+	}
+	revisions := make([]HistoryRevision, 0, len(entries))
+	var previous json.RawMessage
+	for _, entry := range entries {
+		revision := HistoryRevision{RevisionEntry: entry, Changes: []file.RevisionFieldChange{}}
+		if !entry.Deleted {
+			changes, err := file.DiffRevisionRecords(previous, entry.Record)
+			if err != nil {
+				return nil, err
+			}
+			revision.Changes = changes
+			previous = entry.Record
+		}
+		revision.Record = nil
+		revisions = append(revisions, revision)
+	}
+	for i, j := 0, len(revisions)-1; i < j; i, j = i+1, j-1 {
+		revisions[i], revisions[j] = revisions[j], revisions[i]
+	}
+	return revisions, nil
+}
+
+// Revert writes the configuration a record had at an earlier revision back
+// as a new revision. Traffic counters and connection state stay as they are
+// now, and deleted records have to come back through the recycle bin first.
+func (s DefaultHistoryService) Revert(input RevertRevisionInput) (RevertRevisionResult, error) {
+	history := file.CurrentRevisionHistory()
+	if history == nil {
+		return RevertRevisionResult{}, ErrHistoryDisabled
+	}
+	entry, err := history.Get(input.Resource, input.ID, input.Revision)
+	if err != nil {
+		if errors.Is(err, file.ErrRevisionNotFound) {
+			return RevertRevisionResult{}, ErrRevisionNotFound
+		}
+		return RevertRevisionResult{}, err
+	}
+	var revision int64
+	switch input.Resource {
+	case file.JournalResourceUser:
+		revision, err = s.revertUser(input, entry.Record)
+	case file.JournalResourceClient:
+		revision, err = s.revertClient(input, entry.Record)
+	case file.JournalResourceTunnel:
+		revision, err = s.revertTunnel(input, entry.Record)
+	case file.JournalResourceHost:
+		revision, err = s.revertHost(input, entry.Record)
+	default:
+		err = fmt.Errorf("unsupported history resource %q", input.Resource)
+	}
+	if err != nil {
+		return RevertRevisionResult{}, err
+	}
+	return RevertRevisionResult{Resource: input.Resource, ID: input.ID, From: input.Revision, Revision: revision}, nil
+}
+
+// Attribute records who made the change that produced each revision in
+// refs. Failures are logged; the change itself already succeeded.
+func (s DefaultHistoryService) Attribute(resource string, refs []file.RevisionRef, attribution file.RevisionAttribution) {
+	history := file.CurrentRevisionHistory()
+	if history == nil {
+		return
+	}
+	for _, ref := range refs {
+		if err := history.Attribute(resource, ref, attribution); err != nil {
+			logs.Warn("attribute revision history %s %d error: %v", resource, ref.ID, err)
+		}
+	}
+}
+
+// The helpers below read the revision a mutation result was written at.
+
+func userRevision(user *file.User) int64 {
+	if user == nil {
+		return 0
+	}
+	return user.Revision
+}
+
+func clientRevision(client *file.Client) int64 {
+	if client == nil {
+		return 0
+	}
+	return client.Revision
+}
+
+func tunnelRevision(tunnel *file.Tunnel) int64 {
+	if tunnel == nil {
+		return 0
+	}
+	return tunnel.Revision
+}
+
+func hostRevision(host *file.Host) int64 {
+	if host == nil {
+		return 0
+	}
+	return host.Revision
+}
+
+func (s DefaultHistoryService) revertUser(input RevertRevisionInput, record json.RawMessage) (int64, error) {
+	repo := s.repo()
+	current, err := repo.GetUser(input.ID)
+	if err != nil {
+		return 0, mapUserServiceError(err)
+	}
+	if current == nil {
+		return 0, ErrUserNotFound
+	}
+	if isManagedServiceUser(current) {
+		return 0, ErrForbidden
+	}
+	working := new(file.User)
+	if err := json.Unmarshal(record, working); err != nil {
+		return 0, err
+	}
+	working.Id = current.Id
+	working.Revision = current.Revision
+	working.TotalFlow = current.TotalFlow
+	working.TotalTraffic = current.TotalTraffic
+	working.QuotaPeriod = revertQuotaPeriod(current.QuotaPeriod, working.QuotaPeriod)
+	working.EnsureTotalFlow()
+	working.TouchMeta()
+	working.ExpectedRevision = expectedRevertRevision(input, current.Revision)
+	if err := repo.SaveUser(working); err != nil {
+		return 0, mapUserServiceError(err)
+	}
+	if err := (DefaultUserService{Backend: s.Backend}).disconnectUserClientsIfLimited(working); err != nil {
+		return 0, err
+	}
+	return working.Revision, nil
+}
+
+func (s DefaultHistoryService) revertClient(input RevertRevisionInput, record json.RawMessage) (int64, error) {
+	repo := s.repo()
+	current, err := repo.GetClient(input.ID)
+	if err != nil {
+		return 0, mapClientServiceError(err)
+	}
+	if current == nil {
+		return 0, ErrClientNotFound
+	}
+	if isReservedRuntimeClient(current) {
+		return 0, ErrForbidden
+	}
+	working := new(file.Client)
+	if err := json.Unmarshal(record, working); err != nil {
+		return 0, err
+	}
+	if ownerID := working.OwnerID(); ownerID > 0 {
+		if _, err := repo.GetUser(ownerID); err != nil {
+			return 0, mapUserServiceError(err)
+		}
+	}
+	managers := working.ManagerUserIDs[:0]
+	for _, userID := range working.ManagerUserIDs {
+		if _, err := repo.GetUser(userID); err == nil {
+			managers = append(managers, userID)
+		}
+	}
+	working.ManagerUserIDs = managers
+	if !repo.VerifyVKey(working.VerifyKey, current.Id) {
+		return 0, ErrClientVKeyDuplicate
+	}
+	working.Id = current.Id
+	working.Revision = current.Revision
+	working.Flow = revertFlow(current.Flow, working.Flow)
+	working.ExportFlow = current.ExportFlow
+	working.InletFlow = current.InletFlow
+	working.BridgeTraffic = current.BridgeTraffic
+	working.ServiceTraffic = current.ServiceTraffic
+	working.QuotaPeriod = revertQuotaPeriod(current.QuotaPeriod, working.QuotaPeriod)
+	working.IsConnect = current.IsConnect
+	working.NowConn = current.NowConn
+	working.Addr = current.Addr
+	working.LocalAddr = current.LocalAddr
+	working.Version = current.Version
+	working.LastOnlineTime = current.LastOnlineTime
+	working.TouchMeta("", "", "")
+	working.ExpectedRevision = expectedRevertRevision(input, current.Revision)
+	if err := repo.SaveClient(working); err != nil {
+		return 0, mapClientServiceError(err)
+	}
+	if !working.Status || working.VerifyKey != current.VerifyKey {
+		s.runtime().DisconnectClient(working.Id)
+	}
+	return working.Revision, nil
+}
+
+func (s DefaultHistoryService) revertTunnel(input RevertRevisionInput, record json.RawMessage) (int64, error) {
+	repo := s.repo()
+	current, err := repo.GetTunnel(input.ID)
+	if err != nil {
+		return 0, mapTunnelNotFound(err)
+	}
+	if current == nil {
+		return 0, ErrTunnelNotFound
+	}
+	working := new(file.Tunnel)
+	if err := json.Unmarshal(record, working); err != nil {
+		return 0, err
+	}
+	if working.Client == nil {
+		return 0, ErrClientNotFound
+	}
+	if client, err := repo.GetClient(working.Client.Id); err != nil || client == nil {
+		return 0, ErrClientNotFound
+	}
+	if working.Port != current.Port || working.Mode != current.Mode || working.Socks5Proxy != current.Socks5Proxy {
+		if !s.runtime().TunnelPortAvailable(working) {
+			return 0, ErrPortUnavailable
+		}
+	}
+	working.Id = current.Id
+	working.Revision = current.Revision
+	working.Flow = revertFlow(current.Flow, working.Flow)
+	working.ServiceTraffic = current.ServiceTraffic
+	working.QuotaPeriod = revertQuotaPeriod(current.QuotaPeriod, working.QuotaPeriod)
+	working.NowConn = current.NowConn
+	working.RunStatus = current.RunStatus
+	working.TouchMeta()
+	working.ExpectedRevision = expectedRevertRevision(input, current.Revision)
+	if err := repo.SaveTunnel(working); err != nil {
+		if errors.Is(err, file.ErrRevisionConflict) {
+			return 0, ErrRevisionConflict
+		}
+		return 0, mapTunnelNotFound(err)
+	}
+	if err := s.runtime().StopTunnel(working.Id); err != nil && !isTaskNotRunning(err) {
+		return 0, mapTunnelNotFound(err)
+	}
+	if working.Status {
+		if err := s.runtime().StartTunnel(working.Id); err != nil {
+			return 0, mapTunnelNotFound(normalizeRuntimeError(err))
+		}
+	}
+	return working.Revision, nil
+}
+
+func (s DefaultHistoryService) revertHost(input RevertRevisionInput, record json.RawMessage) (int64, error) {
+	repo := s.repo()
+	current, err := repo.GetHost(input.ID)
+	if err != nil {
+		return 0, mapHostNotFound(err)
+	}
+	if current == nil {
+		return 0, ErrHostNotFound
+	}
+	working := new(file.Host)
+	if err := json.Unmarshal(record, working); err != nil {
+		return 0, err
+	}
+	if working.Client == nil {
+		return 0, ErrClientNotFound
+	}
+	if client, err := repo.GetClient(working.Client.Id); err != nil || client == nil {
+		return 0, ErrClientNotFound
+	}
+	working.Id = current.Id
+	if working.Host != current.Host || working.Location != current.Location || working.Scheme != current.Scheme {
+		if repo.HostExists(working) {
+			return 0, ErrHostExists
+		}
+	}
+	working.Revision = current.Revision
+	working.Flow = revertFlow(current.Flow, working.Flow)
+	working.ServiceTraffic = current.ServiceTraffic
+	working.QuotaPeriod = revertQuotaPeriod(current.QuotaPeriod, working.QuotaPeriod)
+	working.NowConn = current.NowConn
+	working.TouchMeta()
+	working.ExpectedRevision = expectedRevertRevision(input, current.Revision)
+	if err := repo.SaveHost(working, current.Host); err != nil {
+		if errors.Is(err, file.ErrRevisionConflict) {
+			return 0, ErrRevisionConflict
+		}
+		return 0, mapHostNotFound(err)
+	}
+	s.runtime().RemoveHostCache(working.Id)
+	return working.Revision, nil
+}
+
+// revertFlow keeps the live traffic counters and takes the limits from the
+// reverted revision.
+func revertFlow(current, stored *file.Flow) *file.Flow {
+	flow := &file.Flow{}
+	if current != nil {
+		flow.ExportFlow = current.ExportFlow
+		flow.InletFlow = current.InletFlow
+	}
+	if stored != nil {
+		flow.FlowLimit = stored.FlowLimit
+		flow.TimeLimit = stored.TimeLimit
+	}
+	return flow
+}
+
+// revertQuotaPeriod takes the plan from the reverted revision and keeps the
+// running period, so a revert neither resets traffic nor loses the carry.
+func revertQuotaPeriod(current, stored *file.QuotaPeriod) *file.QuotaPeriod {
+	if stored == nil {
+		return nil
+	}
+	plan := file.CloneQuotaPeriod(stored)
+	plan.Carry = 0
+	return continueQuotaPeriod(current, plan, time.Now())
+}
+
+func expectedRevertRevision(input RevertRevisionInput, current int64) int64 {
+	if input.ExpectedRevision > 0 {
+		return input.ExpectedRevision
+	}
+	return current
+}
+
+func (s DefaultHistoryService) repo() HistoryRepository {
+	if !isNilServiceValue(s.Repo) {
+		return s.Repo
+	}
+	if !isNilServiceValue(s.Backend.Repository) {
+		return s.Backend.Repository
+	}
+	return DefaultBackend().Repository
+}
+
+func (s DefaultHistoryService) runtime() HistoryRuntime {
+	if !isNilServiceValue(s.Runtime) {
+		return s.Runtime
+	}
+	if !isNilServiceValue(s.Backend.Runtime) {
+		return s.Backend.Runtime
+	}
+	return DefaultBackend().Runtime
+}
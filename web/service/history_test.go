@@ -0,0 +1,72 @@
+package service
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/djylb/nps/lib/file"
+)
+
+func enableTestRevisionHistory(t *testing.T) *file.RevisionHistory {
+	t.Helper()
+	history, err := file.OpenRevisionHistory(filepath.Join(t.TempDir(), "history"), file.RevisionHistoryOptions{})
+	if err != nil {
+		t.Fatalf("OpenRevisionHistory() error = %v", err)
+	}
+	previous := file.ReplaceRevisionHistory(history)
+	t.Cleanup(func() { file.ReplaceRevisionHistory(previous) })
+	return history
+}
+
+func TestDefaultHistoryServiceRevertsHostTargetAndKeepsTraffic(t *testing.T) {
+	resetBackendTestDB(t)
+	enableTestRevisionHistory(t)
+	repo := defaultRepository{}
+	if err := repo.CreateClient(&file.Client{Id: 2, VerifyKey: "vk", Status: true, Cnf: &file.Config{}, Flow: &file.Flow{}}); err != nil {
+		t.Fatalf("CreateClient() error = %v", err)
+	}
+	client, _ := repo.GetClient(2)
+	if err := repo.CreateHost(&file.Host{Id: 5, Host: "app.example.com", Revision: 1, Client: client, Flow: &file.Flow{}, Target: &file.Target{TargetStr: "10.0.0.1:80"}}); err != nil {
+		t.Fatalf("CreateHost() error = %v", err)
+	}
+	created, _ := repo.GetHost(5)
+	firstRevision := created.Revision
+
+	edited, _ := repo.GetHost(5)
+	edited.Target = &file.Target{TargetStr: "10.0.0.9:80"}
+	edited.TouchMeta()
+	if err := repo.SaveHost(edited, edited.Host); err != nil {
+		t.Fatalf("SaveHost() error = %v", err)
+	}
+	live, _ := file.GetDb().GetHostById(5)
+	live.Flow.Add(100, 200)
+
+	removed := 0
+	service := DefaultHistoryService{Backend: Backend{Repository: repo, Runtime: stubRuntime{removeHostCache: func(int) { removed++ }}}}
+	revisions, err := service.List(file.JournalResourceHost, 5)
+	if err != nil || len(revisions) != 2 {
+		t.Fatalf("List() = %+v, %v, want 2 revisions", revisions, err)
+	}
+	if changes := revisions[0].Changes; len(changes) != 1 || changes[0].Field != "Target.TargetStr" || changes[0].Before != "10.0.0.1:80" {
+		t.Fatalf("newest revision changes = %+v", changes)
+	}
+
+	if _, err := service.Revert(RevertRevisionInput{Resource: file.JournalResourceHost, ID: 5, Revision: firstRevision, ExpectedRevision: firstRevision}); !errors.Is(err, ErrRevisionConflict) {
+		t.Fatalf("Revert(stale expected revision) error = %v, want ErrRevisionConflict", err)
+	}
+	result, err := service.Revert(RevertRevisionInput{Resource: file.JournalResourceHost, ID: 5, Revision: firstRevision})
+	if err != nil {
+		t.Fatalf("Revert() error = %v", err)
+	}
+	host, _ := file.GetDb().GetHostById(5)
+	if host.Target.TargetStr != "10.0.0.1:80" || host.Revision != result.Revision || result.Revision != edited.Revision+1 {
+		t.Fatalf("reverted host target=%q revision=%d, result %+v", host.Target.TargetStr, host.Revision, result)
+	}
+	if host.Flow.InletFlow != 100 || host.Flow.ExportFlow != 200 || removed != 1 {
+		t.Fatalf("reverted host flow = %d/%d, cache removals = %d", host.Flow.InletFlow, host.Flow.ExportFlow, removed)
+	}
+	if _, err := service.Revert(RevertRevisionInput{Resource: file.JournalResourceHost, ID: 5, Revision: 42}); !errors.Is(err, ErrRevisionNotFound) {
+		t.Fatalf("Revert(missing revision) error = %v, want ErrRevisionNotFound", err)
+	}
+}
@@ -0,0 +1,84 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/djylb/nps/lib/edgecache"
+	"github.com/djylb/nps/lib/file"
+)
+
+// HostCacheService manages the edge response cache of domain hosts.
+type HostCacheService interface {
+	Purge(PurgeHostCacheInput) (HostCachePurge, error)
+}
+
+type HostCacheRepository interface {
+	GetHost(int) (*file.Host, error)
+}
+
+type DefaultHostCacheService struct {
+	Repo    HostCacheRepository
+	Backend Backend
+	// Store defaults to the process-wide edge cache.
+	Store *edgecache.Store
+}
+
+// PurgeHostCacheInput removes the cached responses of a host whose path
+// starts with Path. An empty Path removes all of them.
+type PurgeHostCacheInput struct {
+	Scope  NodeAccessScope
+	HostID int
+	Path   string
+}
+
+type HostCachePurge struct {
+	HostID int    `json:"host_id"`
+	Path   string `json:"path"`
+	Purged int    `json:"purged"`
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+}
+
+func (s DefaultHostCacheService) Purge(input PurgeHostCacheInput) (HostCachePurge, error) {
+	path := strings.TrimSpace(input.Path)
+	if path != "" && !strings.HasPrefix(path, "/") {
+		return HostCachePurge{}, fmt.Errorf("%w: path must start with /", ErrInvalidHostCachePath)
+	}
+	host, err := s.repo().GetHost(input.HostID)
+	if err != nil {
+		return HostCachePurge{}, mapHostNotFound(err)
+	}
+	if host == nil {
+		return HostCachePurge{}, ErrHostNotFound
+	}
+	if !input.Scope.AllowsClient(host.Client) {
+		return HostCachePurge{}, ErrForbidden
+	}
+	store := s.store()
+	stats := store.Stats(host.Id)
+	return HostCachePurge{
+		HostID: host.Id,
+		Path:   path,
+		Purged: store.Purge(host.Id, path),
+		Hits:   stats.Hits,
+		Misses: stats.Misses,
+	}, nil
+}
+
+func (s DefaultHostCacheService) repo() HostCacheRepository {
+	if !isNilServiceValue(s.Repo) {
+		return s.Repo
+	}
+	if !isNilServiceValue(s.Backend.Repository) {
+		return s.Backend.Repository
+	}
+	return DefaultBackend().Repository
+}
+
+func (s DefaultHostCacheService) store() *edgecache.Store {
+	if s.Store != nil {
+		return s.Store
+	}
+	return edgecache.Default()
+}
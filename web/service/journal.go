@@ -0,0 +1,145 @@
+package service
+
+import (
+	"github.com/djylb/nps/lib/file"
+	"github.com/djylb/nps/lib/logs"
+)
+
+// The journal helpers below run after a repository or runtime call has
+// succeeded. They re-read the stored record so the journal carries the
+// Revision/UpdatedAt the store assigned, and record a delete when the record
+// is gone. The same re-read feeds the per-record revision history. Journal
+// and history failures are logged and never fail the mutation itself.
+
+func journalEnabled() bool {
+	return file.CurrentChangeJournal() != nil || file.CurrentRevisionHistory() != nil
+}
+
+func logJournalError(resource string, id int, err error) {
+	if err != nil {
+		logs.Warn("append change journal %s %d error: %v", resource, id, err)
+	}
+}
+
+func logHistoryError(resource string, id int, err error) {
+	if err != nil {
+		logs.Warn("record revision history %s %d error: %v", resource, id, err)
+	}
+}
+
+// journalDelete records that a resource is gone in both the journal and the
+// revision history.
+func journalDelete(journal *file.ChangeJournal, history *file.RevisionHistory, resource string, id int) {
+	if journal != nil {
+		logJournalError(resource, id, journal.RecordDelete(resource, id))
+	}
+	if history != nil {
+		logHistoryError(resource, id, history.RecordDelete(resource, id))
+	}
+}
+
+func journalUser(id int) {
+	journal, history := file.CurrentChangeJournal(), file.CurrentRevisionHistory()
+	if (journal == nil && history == nil) || id <= 0 {
+		return
+	}
+	if user, err := file.GetDb().GetUser(id); err == nil && user != nil {
+		if journal != nil {
+			logJournalError(file.JournalResourceUser, id, journal.RecordUser(user))
+		}
+		if history != nil {
+			logHistoryError(file.JournalResourceUser, id, history.RecordUser(user))
+		}
+		return
+	}
+	journalDelete(journal, history, file.JournalResourceUser, id)
+}
+
+func journalClient(id int) {
+	journal, history := file.CurrentChangeJournal(), file.CurrentRevisionHistory()
+	if (journal == nil && history == nil) || id <= 0 {
+		return
+	}
+	if client, err := file.GetDb().GetClient(id); err == nil && client != nil {
+		if journal != nil {
+			logJournalError(file.JournalResourceClient, id, journal.RecordClient(client))
+		}
+		if history != nil {
+			logHistoryError(file.JournalResourceClient, id, history.RecordClient(client))
+		}
+		return
+	}
+	journalDelete(journal, history, file.JournalResourceClient, id)
+}
+
+func journalTunnel(id int) {
+	journal, history := file.CurrentChangeJournal(), file.CurrentRevisionHistory()
+	if (journal == nil && history == nil) || id <= 0 {
+		return
+	}
+	if tunnel, err := file.GetDb().GetTask(id); err == nil && tunnel != nil {
+		if journal != nil {
+			logJournalError(file.JournalResourceTunnel, id, journal.RecordTunnel(tunnel))
+		}
+		if history != nil {
+			logHistoryError(file.JournalResourceTunnel, id, history.RecordTunnel(tunnel))
+		}
+		return
+	}
+	journalDelete(journal, history, file.JournalResourceTunnel, id)
+}
+
+func journalHost(id int) {
+	journal, history := file.CurrentChangeJournal(), file.CurrentRevisionHistory()
+	if (journal == nil && history == nil) || id <= 0 {
+		return
+	}
+	if host, err := file.GetDb().GetHostById(id); err == nil && host != nil {
+		if journal != nil {
+			logJournalError(file.JournalResourceHost, id, journal.RecordHost(host))
+		}
+		if history != nil {
+			logHistoryError(file.JournalResourceHost, id, history.RecordHost(host))
+		}
+		return
+	}
+	journalDelete(journal, history, file.JournalResourceHost, id)
+}
+
+func journalGlobal() {
+	journal := file.CurrentChangeJournal()
+	if journal == nil {
+		return
+	}
+	logJournalError(file.JournalResourceGlobal, 0, journal.RecordGlobal(file.GetDb().GetGlobal()))
+}
+
+// journalClientIDsForUser lists the clients whose owner or manager references
+// point at userID, so a user delete can journal the detached clients too.
+func journalClientIDsForUser(userID int) []int {
+	if !journalEnabled() || userID <= 0 {
+		return nil
+	}
+	db := file.GetDb()
+	ids := append([]int(nil), db.GetClientIDsByUserId(userID)...)
+	return append(ids, db.GetAllManagedClientIDsByUserId(userID)...)
+}
+
+// journalResourceIDsForClient lists the tunnels and hosts owned by clientID
+// before a cascading runtime cleanup removes them.
+func journalResourceIDsForClient(clientID int) ([]int, []int) {
+	if !journalEnabled() || clientID <= 0 {
+		return nil, nil
+	}
+	db := file.GetDb()
+	var tunnelIDs, hostIDs []int
+	db.RangeTunnelsByClientID(clientID, func(tunnel *file.Tunnel) bool {
+		tunnelIDs = append(tunnelIDs, tunnel.Id)
+		return true
+	})
+	db.RangeHostsByClientID(clientID, func(host *file.Host) bool {
+		hostIDs = append(hostIDs, host.Id)
+		return true
+	})
+	return tunnelIDs, hostIDs
+}
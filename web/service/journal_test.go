@@ -0,0 +1,78 @@
+package service
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/djylb/nps/lib/file"
+)
+
+func enableTestChangeJournal(t *testing.T) *file.ChangeJournal {
+	t.Helper()
+	journal, err := file.OpenChangeJournal(filepath.Join(t.TempDir(), "journal"), file.ChangeJournalOptions{})
+	if err != nil {
+		t.Fatalf("OpenChangeJournal() error = %v", err)
+	}
+	previous := file.ReplaceChangeJournal(journal)
+	t.Cleanup(func() {
+		file.ReplaceChangeJournal(previous)
+		_ = journal.Close()
+	})
+	return journal
+}
+
+func TestDefaultRepositoryJournalsMutationsForReplay(t *testing.T) {
+	resetBackendTestDB(t)
+	journal := enableTestChangeJournal(t)
+	repo := defaultRepository{}
+
+	if err := repo.CreateUser(&file.User{Id: 1, Username: "tenant", Password: "secret", Status: 1, TotalFlow: &file.Flow{}}); err != nil {
+		t.Fatalf("CreateUser() error = %v", err)
+	}
+	if err := repo.CreateClient(&file.Client{Id: 2, OwnerUserID: 1, VerifyKey: "vk", Status: true, Cnf: &file.Config{}, Flow: &file.Flow{}}); err != nil {
+		t.Fatalf("CreateClient() error = %v", err)
+	}
+	client, err := repo.GetClient(2)
+	if err != nil {
+		t.Fatalf("GetClient() error = %v", err)
+	}
+	client.Remark = "edited"
+	client.TouchMeta("", "", "")
+	if err := repo.SaveClient(client); err != nil {
+		t.Fatalf("SaveClient() error = %v", err)
+	}
+	if err := repo.SaveGlobal(&file.Glob{EntryAclMode: file.AclBlacklist, EntryAclRules: "10.0.0.1"}); err != nil {
+		t.Fatalf("SaveGlobal() error = %v", err)
+	}
+	beforeDelete := time.Now()
+	time.Sleep(time.Millisecond)
+	if err := repo.DeleteClient(2); err != nil {
+		t.Fatalf("DeleteClient() error = %v", err)
+	}
+
+	snapshot, result, err := file.ReplayChangeJournal(journal.Dir(), beforeDelete)
+	if err != nil {
+		t.Fatalf("ReplayChangeJournal() error = %v", err)
+	}
+	if result.Entries != 4 {
+		t.Fatalf("replayed entries = %d, want 4", result.Entries)
+	}
+	if len(snapshot.Users) != 1 || snapshot.Users[0].Username != "tenant" {
+		t.Fatalf("replayed users = %+v, want tenant", snapshot.Users)
+	}
+	if len(snapshot.Clients) != 1 || snapshot.Clients[0].Remark != "edited" || snapshot.Clients[0].Revision != client.Revision {
+		t.Fatalf("replayed clients = %+v, want edited client at revision %d", snapshot.Clients, client.Revision)
+	}
+	if snapshot.Global == nil || snapshot.Global.EntryAclRules != "10.0.0.1" {
+		t.Fatalf("replayed global = %+v, want saved rules", snapshot.Global)
+	}
+
+	snapshot, _, err = file.ReplayChangeJournal(journal.Dir(), time.Now())
+	if err != nil {
+		t.Fatalf("ReplayChangeJournal(now) error = %v", err)
+	}
+	if len(snapshot.Clients) != 0 {
+		t.Fatalf("replayed clients after delete = %+v, want none", snapshot.Clients)
+	}
+}
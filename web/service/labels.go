@@ -0,0 +1,328 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/djylb/nps/lib/file"
+)
+
+const (
+	BulkResourceClients = "clients"
+	BulkResourceTunnels = "tunnels"
+	BulkResourceHosts   = "hosts"
+
+	BulkActionStart  = "start"
+	BulkActionStop   = "stop"
+	BulkActionDelete = "delete"
+	BulkActionUpdate = "update"
+)
+
+// LabelService runs one action against every client, tunnel or host whose
+// labels match a selector.
+type LabelService interface {
+	Bulk(BulkActionInput) (BulkActionResult, error)
+}
+
+type LabelRepository interface {
+	RangeClients(func(*file.Client) bool)
+	RangeTunnels(func(*file.Tunnel) bool)
+	RangeHosts(func(*file.Host) bool)
+	GetClient(int) (*file.Client, error)
+	SaveClient(*file.Client) error
+	GetTunnel(int) (*file.Tunnel, error)
+	SaveTunnel(*file.Tunnel) error
+	GetHost(int) (*file.Host, error)
+	SaveHost(*file.Host, string) error
+}
+
+type DefaultLabelService struct {
+	Repo    LabelRepository
+	Clients ClientService
+	Index   IndexService
+	Backend Backend
+}
+
+// BulkActionInput selects the records to act on. Update merges SetLabels into
+// the labels of each record and then drops RemoveLabels; start, stop and
+// delete behave like the single-record actions.
+type BulkActionInput struct {
+	Resource     string
+	Action       string
+	Selector     file.LabelSelector
+	SetLabels    map[string]string
+	RemoveLabels []string
+}
+
+type BulkActionFailure struct {
+	ID    int    `json:"id"`
+	Error string `json:"error"`
+}
+
+// BulkActionResult lists what matched and how each record fared. Revisions
+// holds the revision written for each succeeded record.
+type BulkActionResult struct {
+	Resource  string
+	Action    string
+	Matched   []int
+	Succeeded []int
+	Revisions []file.RevisionRef
+	Failed    []BulkActionFailure
+}
+
+func (s DefaultLabelService) Bulk(input BulkActionInput) (BulkActionResult, error) {
+	if input.Selector.Empty() {
+		return BulkActionResult{}, ErrLabelSelectorRequired
+	}
+	action := strings.ToLower(strings.TrimSpace(input.Action))
+	resource := strings.ToLower(strings.TrimSpace(input.Resource))
+	var apply func(int) (int64, error)
+	switch action {
+	case BulkActionStart, BulkActionStop, BulkActionDelete:
+		var err error
+		if apply, err = s.lifecycleAction(resource, action); err != nil {
+			return BulkActionResult{}, err
+		}
+	case BulkActionUpdate:
+		set, err := normalizeLabelsInput(input.SetLabels)
+		if err != nil {
+			return BulkActionResult{}, err
+		}
+		if len(set) == 0 && len(input.RemoveLabels) == 0 {
+			return BulkActionResult{}, fmt.Errorf("%w: nothing to update", ErrInvalidLabels)
+		}
+		if apply, err = s.labelUpdate(resource, set, input.RemoveLabels); err != nil {
+			return BulkActionResult{}, err
+		}
+	default:
+		return BulkActionResult{}, fmt.Errorf("%w %q", ErrBulkActionUnsupported, input.Action)
+	}
+
+	result := BulkActionResult{Resource: resource, Action: action, Matched: s.matchingIDs(resource, input.Selector)}
+	for _, id := range result.Matched {
+		revision, err := apply(id)
+		if err != nil {
+			result.Failed = append(result.Failed, BulkActionFailure{ID: id, Error: err.Error()})
+			continue
+		}
+		result.Succeeded = append(result.Succeeded, id)
+		result.Revisions = append(result.Revisions, file.RevisionRef{ID: id, Revision: revision})
+	}
+	return result, nil
+}
+
+func (s DefaultLabelService) lifecycleAction(resource, action string) (func(int) (int64, error), error) {
+	switch resource {
+	case BulkResourceClients:
+		clients := s.clients()
+		switch action {
+		case BulkActionStart, BulkActionStop:
+			return func(id int) (int64, error) {
+				result, err := clients.ChangeStatus(id, action == BulkActionStart)
+				return clientRevision(result.Client), err
+			}, nil
+		default:
+			return func(id int) (int64, error) {
+				result, err := clients.Delete(id)
+				return clientRevision(result.Client), err
+			}, nil
+		}
+	case BulkResourceTunnels:
+		index := s.index()
+		switch action {
+		case BulkActionStart:
+			return func(id int) (int64, error) {
+				result, err := index.StartTunnel(id, "")
+				return tunnelRevision(result.Tunnel), err
+			}, nil
+		case BulkActionStop:
+			return func(id int) (int64, error) {
+				result, err := index.StopTunnel(id, "")
+				return tunnelRevision(result.Tunnel), err
+			}, nil
+		default:
+			return func(id int) (int64, error) {
+				result, err := index.DeleteTunnel(id)
+				return tunnelRevision(result.Tunnel), err
+			}, nil
+		}
+	case BulkResourceHosts:
+		index := s.index()
+		switch action {
+		case BulkActionStart:
+			return func(id int) (int64, error) {
+				result, err := index.StartHost(id, "")
+				return hostRevision(result.Host), err
+			}, nil
+		case BulkActionStop:
+			return func(id int) (int64, error) {
+				result, err := index.StopHost(id, "")
+				return hostRevision(result.Host), err
+			}, nil
+		default:
+			return func(id int) (int64, error) {
+				result, err := index.DeleteHost(id)
+				return hostRevision(result.Host), err
+			}, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: resource %q", ErrBulkActionUnsupported, resource)
+}
+
+func (s DefaultLabelService) labelUpdate(resource string, set map[string]string, remove []string) (func(int) (int64, error), error) {
+	repo := s.repo()
+	switch resource {
+	case BulkResourceClients:
+		return func(id int) (int64, error) {
+			client, err := repo.GetClient(id)
+			if err != nil {
+				return 0, mapClientServiceError(err)
+			}
+			if client == nil {
+				return 0, ErrClientNotFound
+			}
+			working := ensureDetachedClientSnapshot(repo, client)
+			if working.Labels, err = mergeLabels(working.Labels, set, remove); err != nil {
+				return 0, err
+			}
+			working.TouchMeta("", "", "")
+			return working.Revision, mapClientServiceError(repo.SaveClient(working))
+		}, nil
+	case BulkResourceTunnels:
+		return func(id int) (int64, error) {
+			tunnel, err := repo.GetTunnel(id)
+			if err != nil {
+				return 0, mapTunnelNotFound(err)
+			}
+			if tunnel == nil {
+				return 0, ErrTunnelNotFound
+			}
+			working := ensureDetachedTunnelMutation(repo, tunnel)
+			if working.Labels, err = mergeLabels(working.Labels, set, remove); err != nil {
+				return 0, err
+			}
+			working.TouchMeta()
+			return working.Revision, mapTunnelNotFound(repo.SaveTunnel(working))
+		}, nil
+	case BulkResourceHosts:
+		return func(id int) (int64, error) {
+			host, err := repo.GetHost(id)
+			if err != nil {
+				return 0, mapHostNotFound(err)
+			}
+			if host == nil {
+				return 0, ErrHostNotFound
+			}
+			working := ensureDetachedHostMutation(repo, host)
+			if working.Labels, err = mergeLabels(working.Labels, set, remove); err != nil {
+				return 0, err
+			}
+			working.TouchMeta()
+			return working.Revision, mapHostNotFound(repo.SaveHost(working, ""))
+		}, nil
+	}
+	return nil, fmt.Errorf("%w: resource %q", ErrBulkActionUnsupported, resource)
+}
+
+func (s DefaultLabelService) matchingIDs(resource string, selector file.LabelSelector) []int {
+	repo := s.repo()
+	var ids []int
+	switch resource {
+	case BulkResourceClients:
+		repo.RangeClients(func(client *file.Client) bool {
+			if !isReservedRuntimeClient(client) && selector.Matches(client.Labels) {
+				ids = append(ids, client.Id)
+			}
+			return true
+		})
+	case BulkResourceTunnels:
+		repo.RangeTunnels(func(tunnel *file.Tunnel) bool {
+			if selector.Matches(tunnel.Labels) {
+				ids = append(ids, tunnel.Id)
+			}
+			return true
+		})
+	case BulkResourceHosts:
+		repo.RangeHosts(func(host *file.Host) bool {
+			if selector.Matches(host.Labels) {
+				ids = append(ids, host.Id)
+			}
+			return true
+		})
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+func (s DefaultLabelService) repo() LabelRepository {
+	if !isNilServiceValue(s.Repo) {
+		return s.Repo
+	}
+	if !isNilServiceValue(s.Backend.Repository) {
+		return s.Backend.Repository
+	}
+	return DefaultBackend().Repository
+}
+
+func (s DefaultLabelService) clients() ClientService {
+	if !isNilServiceValue(s.Clients) {
+		return s.Clients
+	}
+	return DefaultClientService{Backend: s.Backend}
+}
+
+func (s DefaultLabelService) index() IndexService {
+	if !isNilServiceValue(s.Index) {
+		return s.Index
+	}
+	return DefaultIndexService{Backend: s.Backend, QuotaStore: DefaultQuotaStore{}}
+}
+
+func normalizeLabelsInput(labels map[string]string) (map[string]string, error) {
+	normalized, err := file.NormalizeLabels(labels)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidLabels, err)
+	}
+	return normalized, nil
+}
+
+func mergeLabels(current, set map[string]string, remove []string) (map[string]string, error) {
+	merged := file.CloneLabels(current)
+	if merged == nil {
+		merged = make(map[string]string, len(set))
+	}
+	for key, value := range set {
+		merged[key] = value
+	}
+	for _, key := range remove {
+		delete(merged, strings.TrimSpace(key))
+	}
+	return normalizeLabelsInput(merged)
+}
+
+// selectLabeledWindow keeps the rows whose labels match selector and returns
+// the requested page along with the number of matches.
+func selectLabeledWindow[T any](rows []T, selector file.LabelSelector, labels func(T) map[string]string, offset, limit int) ([]T, int) {
+	matched := make([]T, 0, len(rows))
+	for _, row := range rows {
+		if selector.Matches(labels(row)) {
+			matched = append(matched, row)
+		}
+	}
+	start, end, ok := sliceWindowBounds(len(matched), offset, limit)
+	if !ok {
+		return nil, len(matched)
+	}
+	return matched[start:end], len(matched)
+}
+
+func listClientsBySelector(repo ClientRepository, input ListClientsInput) ([]*file.Client, int) {
+	if input.Selector.Empty() {
+		return repo.ListVisibleClients(input)
+	}
+	offset, limit := input.Offset, input.Limit
+	input.Offset, input.Limit = 0, 0
+	rows, _ := repo.ListVisibleClients(input)
+	return selectLabeledWindow(rows, input.Selector, func(client *file.Client) map[string]string { return client.Labels }, offset, limit)
+}
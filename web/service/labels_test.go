@@ -0,0 +1,103 @@
+package service
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/djylb/nps/lib/file"
+)
+
+func TestListTunnelsFiltersBySelectorBeforePaging(t *testing.T) {
+	rows := []*file.Tunnel{
+		{Id: 1, Labels: map[string]string{"env": "staging"}},
+		{Id: 2, Labels: map[string]string{"env": "prod"}},
+		{Id: 3, Labels: map[string]string{"env": "staging", "team": "payments"}},
+		{Id: 4},
+		{Id: 5, Labels: map[string]string{"env": "staging"}},
+	}
+	var gotOffset, gotLimit int
+	service := DefaultIndexService{Runtime: stubRuntime{
+		listTunnels: func(offset, limit int, _ string, _ int, _, _, _ string) ([]*file.Tunnel, int) {
+			gotOffset, gotLimit = offset, limit
+			return rows, len(rows)
+		},
+	}}
+	selector, err := file.ParseLabelSelector("env=staging")
+	if err != nil {
+		t.Fatalf("ParseLabelSelector() error = %v", err)
+	}
+
+	page, total := service.ListTunnels(TunnelListInput{Offset: 1, Limit: 1, Selector: selector})
+	if gotOffset != 0 || gotLimit != 0 {
+		t.Fatalf("runtime list window = %d/%d, want the full list before filtering", gotOffset, gotLimit)
+	}
+	if total != 3 || len(page) != 1 || page[0].Id != 3 {
+		t.Fatalf("ListTunnels() = %+v, %d, want tunnel 3 of 3 matches", page, total)
+	}
+}
+
+func TestDefaultLabelServiceBulkUpdatesAndStopsMatchingTunnels(t *testing.T) {
+	resetBackendTestDB(t)
+	db := file.GetDb()
+	client := &file.Client{Id: 1, VerifyKey: "vk", Status: true, Cnf: &file.Config{}, Flow: &file.Flow{}}
+	if err := db.NewClient(client); err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	for _, tunnel := range []*file.Tunnel{
+		{Id: 2, Port: 18082, Mode: "tcp", Status: true, Client: client, Flow: &file.Flow{}, Target: &file.Target{TargetStr: "127.0.0.1:80"}, Labels: map[string]string{"env": "staging", "old": "x"}},
+		{Id: 3, Port: 18083, Mode: "tcp", Status: true, Client: client, Flow: &file.Flow{}, Target: &file.Target{TargetStr: "127.0.0.1:80"}, Labels: map[string]string{"env": "prod"}},
+		{Id: 4, Port: 18084, Mode: "tcp", Status: true, Client: client, Flow: &file.Flow{}, Target: &file.Target{TargetStr: "127.0.0.1:80"}, Labels: map[string]string{"env": "staging"}},
+	} {
+		if err := db.NewTask(tunnel); err != nil {
+			t.Fatalf("NewTask(%d) error = %v", tunnel.Id, err)
+		}
+	}
+
+	var stopped []int
+	runtime := stubRuntime{stopTunnel: func(id int) error {
+		stopped = append(stopped, id)
+		return nil
+	}}
+	service := DefaultLabelService{
+		Repo:  defaultRepository{},
+		Index: DefaultIndexService{Repo: defaultRepository{}, Runtime: runtime},
+	}
+	selector, _ := file.ParseLabelSelector("env=staging")
+
+	result, err := service.Bulk(BulkActionInput{
+		Resource:     BulkResourceTunnels,
+		Action:       BulkActionUpdate,
+		Selector:     selector,
+		SetLabels:    map[string]string{"team": "payments"},
+		RemoveLabels: []string{"old"},
+	})
+	if err != nil {
+		t.Fatalf("Bulk(update) error = %v", err)
+	}
+	if !reflect.DeepEqual(result.Succeeded, []int{2, 4}) || len(result.Failed) != 0 {
+		t.Fatalf("Bulk(update) = %+v", result)
+	}
+	tunnel, _ := db.GetTask(2)
+	if !reflect.DeepEqual(tunnel.Labels, map[string]string{"env": "staging", "team": "payments"}) {
+		t.Fatalf("tunnel 2 labels = %v", tunnel.Labels)
+	}
+	if tunnel, _ := db.GetTask(3); len(tunnel.Labels) != 1 {
+		t.Fatalf("tunnel 3 labels = %v, want untouched", tunnel.Labels)
+	}
+
+	teamSelector, _ := file.ParseLabelSelector("team=payments")
+	if result, err = service.Bulk(BulkActionInput{Resource: BulkResourceTunnels, Action: BulkActionStop, Selector: teamSelector}); err != nil {
+		t.Fatalf("Bulk(stop) error = %v", err)
+	}
+	if !reflect.DeepEqual(stopped, []int{2, 4}) || !reflect.DeepEqual(result.Matched, []int{2, 4}) {
+		t.Fatalf("Bulk(stop) = %+v, stopped %v", result, stopped)
+	}
+
+	if _, err := service.Bulk(BulkActionInput{Resource: BulkResourceTunnels, Action: BulkActionDelete}); !errors.Is(err, ErrLabelSelectorRequired) {
+		t.Fatalf("Bulk(no selector) error = %v, want ErrLabelSelectorRequired", err)
+	}
+	if _, err := service.Bulk(BulkActionInput{Resource: BulkResourceTunnels, Action: BulkActionUpdate, Selector: selector, SetLabels: map[string]string{"bad key": "x"}}); !errors.Is(err, ErrInvalidLabels) {
+		t.Fatalf("Bulk(invalid labels) error = %v, want ErrInvalidLabels", err)
+	}
+}
@@ -64,6 +64,8 @@ type DefaultGlobalService struct {
 type SaveGlobalInput struct {
 	EntryACLMode  int
 	EntryACLRules string
+	// WAFRules keeps the current global firewall rules when nil.
+	WAFRules *string
 }
 
 type DefaultLoginPolicy struct {
@@ -94,9 +96,20 @@ func SharedLoginPolicy() *DefaultLoginPolicy {
 
 func (s DefaultGlobalService) Save(input SaveGlobalInput) error {
 	entryACLMode, entryACLRules := normalizeEntryACLInput(input.EntryACLMode, input.EntryACLRules)
+	wafRules := ""
+	if input.WAFRules != nil {
+		normalized, err := normalizeWAFRulesInput(*input.WAFRules)
+		if err != nil {
+			return err
+		}
+		wafRules = normalized
+	} else if current := s.repo().GetGlobal(); current != nil {
+		wafRules = current.WafRules
+	}
 	return s.repo().SaveGlobal(&file.Glob{
 		EntryAclMode:  entryACLMode,
 		EntryAclRules: entryACLRules,
+		WafRules:      wafRules,
 	})
 }
 
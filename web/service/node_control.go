@@ -1145,38 +1145,39 @@ type NodeUsageUserPayload struct {
 }
 
 type NodeUsageClientPayload struct {
-	ID                  int    `json:"id"`
-	VerifyKey           string `json:"verify_key"`
-	Remark              string `json:"remark"`
-	OwnerUserID         int    `json:"owner_user_id"`
-	ManagerUserIDs      []int  `json:"manager_user_ids,omitempty"`
-	SourceType          string `json:"source_type,omitempty"`
-	SourcePlatformID    string `json:"source_platform_id,omitempty"`
-	SourceActorID       string `json:"source_actor_id,omitempty"`
-	Status              bool   `json:"status"`
-	IsConnect           bool   `json:"is_connect"`
-	ExpireAt            int64  `json:"expire_at"`
-	FlowLimitTotalBytes int64  `json:"flow_limit_total_bytes"`
-	RateLimitTotalBps   int    `json:"rate_limit_total_bps"`
-	MaxConnections      int    `json:"max_connections"`
-	MaxTunnelNum        int    `json:"max_tunnel_num"`
-	ConfigConnAllow     bool   `json:"config_conn_allow"`
-	Revision            int64  `json:"revision"`
-	UpdatedAt           int64  `json:"updated_at"`
-	BridgeInBytes       int64  `json:"bridge_in_bytes"`
-	BridgeOutBytes      int64  `json:"bridge_out_bytes"`
-	BridgeTotalBytes    int64  `json:"bridge_total_bytes"`
-	ServiceInBytes      int64  `json:"service_in_bytes"`
-	ServiceOutBytes     int64  `json:"service_out_bytes"`
-	ServiceTotalBytes   int64  `json:"service_total_bytes"`
-	TotalInBytes        int64  `json:"total_in_bytes"`
-	TotalOutBytes       int64  `json:"total_out_bytes"`
-	TotalBytes          int64  `json:"total_bytes"`
-	TunnelCount         int    `json:"tunnel_count"`
-	HostCount           int    `json:"host_count"`
-	EntryACLRuleCount   int    `json:"entry_acl_rule_count"`
-	CreateTime          string `json:"create_time,omitempty"`
-	LastOnlineTime      string `json:"last_online_time,omitempty"`
+	ID                  int               `json:"id"`
+	VerifyKey           string            `json:"verify_key"`
+	Remark              string            `json:"remark"`
+	Labels              map[string]string `json:"labels,omitempty"`
+	OwnerUserID         int               `json:"owner_user_id"`
+	ManagerUserIDs      []int             `json:"manager_user_ids,omitempty"`
+	SourceType          string            `json:"source_type,omitempty"`
+	SourcePlatformID    string            `json:"source_platform_id,omitempty"`
+	SourceActorID       string            `json:"source_actor_id,omitempty"`
+	Status              bool              `json:"status"`
+	IsConnect           bool              `json:"is_connect"`
+	ExpireAt            int64             `json:"expire_at"`
+	FlowLimitTotalBytes int64             `json:"flow_limit_total_bytes"`
+	RateLimitTotalBps   int               `json:"rate_limit_total_bps"`
+	MaxConnections      int               `json:"max_connections"`
+	MaxTunnelNum        int               `json:"max_tunnel_num"`
+	ConfigConnAllow     bool              `json:"config_conn_allow"`
+	Revision            int64             `json:"revision"`
+	UpdatedAt           int64             `json:"updated_at"`
+	BridgeInBytes       int64             `json:"bridge_in_bytes"`
+	BridgeOutBytes      int64             `json:"bridge_out_bytes"`
+	BridgeTotalBytes    int64             `json:"bridge_total_bytes"`
+	ServiceInBytes      int64             `json:"service_in_bytes"`
+	ServiceOutBytes     int64             `json:"service_out_bytes"`
+	ServiceTotalBytes   int64             `json:"service_total_bytes"`
+	TotalInBytes        int64             `json:"total_in_bytes"`
+	TotalOutBytes       int64             `json:"total_out_bytes"`
+	TotalBytes          int64             `json:"total_bytes"`
+	TunnelCount         int               `json:"tunnel_count"`
+	HostCount           int               `json:"host_count"`
+	EntryACLRuleCount   int               `json:"entry_acl_rule_count"`
+	CreateTime          string            `json:"create_time,omitempty"`
+	LastOnlineTime      string            `json:"last_online_time,omitempty"`
 }
 
 type NodeCallbackQueueItemPayload struct {
@@ -1499,6 +1500,7 @@ func (s DefaultNodeControlService) buildUsageSnapshotPayload(input NodeUsageSnap
 		payload := NodeUsageClientPayload{
 			ID:                  client.Id,
 			Remark:              client.Remark,
+			Labels:              file.CloneLabels(client.Labels),
 			OwnerUserID:         ownerID,
 			SourceType:          client.SourceType,
 			SourcePlatformID:    client.SourcePlatformID,
@@ -355,14 +355,24 @@ func (DefaultNodeStorage) SaveUser(user *file.User) error {
 	if file.GlobalStore == nil {
 		return ErrStoreNotInitialized
 	}
-	return file.GlobalStore.UpdateUser(user)
+	if err := file.GlobalStore.UpdateUser(user); err != nil {
+		return err
+	}
+	journalUser(user.Id)
+	return nil
 }
 
 func (DefaultNodeStorage) SaveClient(client *file.Client) error {
 	if file.GlobalStore == nil {
 		return ErrStoreNotInitialized
 	}
-	return file.GlobalStore.UpdateClient(client)
+	if err := file.GlobalStore.UpdateClient(client); err != nil {
+		return err
+	}
+	if client != nil {
+		journalClient(client.Id)
+	}
+	return nil
 }
 
 func (DefaultNodeStorage) ResolveClient(target NodeClientTarget) (*file.Client, error) {
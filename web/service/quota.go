@@ -0,0 +1,287 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/djylb/nps/lib/file"
+)
+
+// QuotaPlanService manages the recurring quota periods of users, clients,
+// tunnels and hosts.
+type QuotaPlanService interface {
+	Get(QuotaPlanInput) (QuotaPlanPayload, error)
+	Set(SetQuotaPlanInput) (QuotaPlanPayload, error)
+	ResetDue(time.Time) []file.QuotaReset
+	Usages() []file.QuotaUsage
+}
+
+type QuotaPlanRepository interface {
+	GetUser(int) (*file.User, error)
+	SaveUser(*file.User) error
+	GetClient(int) (*file.Client, error)
+	SaveClient(*file.Client) error
+	GetTunnel(int) (*file.Tunnel, error)
+	SaveTunnel(*file.Tunnel) error
+	GetHost(int) (*file.Host, error)
+	SaveHost(*file.Host, string) error
+}
+
+type DefaultQuotaPlanService struct {
+	Repo    QuotaPlanRepository
+	Backend Backend
+}
+
+type QuotaPlanInput struct {
+	Scope    NodeAccessScope
+	Resource string
+	ID       int
+}
+
+// SetQuotaPlanInput replaces the plan of one resource. An empty Period
+// removes the plan; the flow limit itself is left alone either way.
+type SetQuotaPlanInput struct {
+	Scope    NodeAccessScope
+	Resource string
+	ID       int
+	Period   string
+	Day      int
+	Timezone string
+	Rollover bool
+}
+
+// QuotaPlanPayload is the plan of a resource together with the running
+// period. LimitBytes includes the carried allowance.
+type QuotaPlanPayload struct {
+	Resource    string `json:"resource"`
+	ID          int    `json:"id"`
+	Revision    int64  `json:"revision"`
+	Period      string `json:"period"`
+	Day         int    `json:"day"`
+	Timezone    string `json:"timezone"`
+	Rollover    bool   `json:"rollover"`
+	CarryBytes  int64  `json:"carry_bytes"`
+	PeriodStart int64  `json:"period_start"`
+	NextReset   int64  `json:"next_reset"`
+	LimitBytes  int64  `json:"limit_bytes"`
+	UsedBytes   int64  `json:"used_bytes"`
+}
+
+func (s DefaultQuotaPlanService) Get(input QuotaPlanInput) (QuotaPlanPayload, error) {
+	resource := strings.ToLower(strings.TrimSpace(input.Resource))
+	repo := s.repo()
+	switch resource {
+	case file.JournalResourceUser:
+		user, err := repo.GetUser(input.ID)
+		if err != nil {
+			return QuotaPlanPayload{}, mapUserServiceError(err)
+		}
+		if user == nil {
+			return QuotaPlanPayload{}, ErrUserNotFound
+		}
+		if !input.Scope.AllowsUser(user) {
+			return QuotaPlanPayload{}, ErrForbidden
+		}
+		return userQuotaPlanPayload(user, time.Now()), nil
+	case file.JournalResourceClient:
+		client, err := repo.GetClient(input.ID)
+		if err != nil {
+			return QuotaPlanPayload{}, mapClientServiceError(err)
+		}
+		if client == nil {
+			return QuotaPlanPayload{}, ErrClientNotFound
+		}
+		if isReservedRuntimeClient(client) || !input.Scope.AllowsClient(client) {
+			return QuotaPlanPayload{}, ErrForbidden
+		}
+		return clientQuotaPlanPayload(client, time.Now()), nil
+	case file.JournalResourceTunnel:
+		tunnel, err := repo.GetTunnel(input.ID)
+		if err != nil {
+			return QuotaPlanPayload{}, mapTunnelNotFound(err)
+		}
+		if tunnel == nil {
+			return QuotaPlanPayload{}, ErrTunnelNotFound
+		}
+		if !input.Scope.AllowsClient(tunnel.Client) {
+			return QuotaPlanPayload{}, ErrForbidden
+		}
+		return tunnelQuotaPlanPayload(tunnel, time.Now()), nil
+	case file.JournalResourceHost:
+		host, err := repo.GetHost(input.ID)
+		if err != nil {
+			return QuotaPlanPayload{}, mapHostNotFound(err)
+		}
+		if host == nil {
+			return QuotaPlanPayload{}, ErrHostNotFound
+		}
+		if !input.Scope.AllowsClient(host.Client) {
+			return QuotaPlanPayload{}, ErrForbidden
+		}
+		return hostQuotaPlanPayload(host, time.Now()), nil
+	}
+	return QuotaPlanPayload{}, fmt.Errorf("%w: resource %q", ErrQuotaPlanInvalid, input.Resource)
+}
+
+func (s DefaultQuotaPlanService) Set(input SetQuotaPlanInput) (QuotaPlanPayload, error) {
+	if !input.Scope.IsFullAccess() {
+		return QuotaPlanPayload{}, ErrForbidden
+	}
+	plan, err := file.NormalizeQuotaPeriod(&file.QuotaPeriod{
+		Period:   input.Period,
+		Day:      input.Day,
+		Timezone: input.Timezone,
+		Rollover: input.Rollover,
+	})
+	if err != nil {
+		return QuotaPlanPayload{}, fmt.Errorf("%w: %v", ErrQuotaPlanInvalid, err)
+	}
+	now := time.Now()
+	resource := strings.ToLower(strings.TrimSpace(input.Resource))
+	repo := s.repo()
+	switch resource {
+	case file.JournalResourceUser:
+		user, err := repo.GetUser(input.ID)
+		if err != nil {
+			return QuotaPlanPayload{}, mapUserServiceError(err)
+		}
+		if user == nil {
+			return QuotaPlanPayload{}, ErrUserNotFound
+		}
+		if isManagedServiceUser(user) {
+			return QuotaPlanPayload{}, ErrForbidden
+		}
+		working := ensureDetachedUserSnapshot(repo, user)
+		working.QuotaPeriod = continueQuotaPeriod(working.QuotaPeriod, plan, now)
+		working.TouchMeta()
+		if err := repo.SaveUser(working); err != nil {
+			return QuotaPlanPayload{}, mapUserServiceError(err)
+		}
+		return userQuotaPlanPayload(working, now), nil
+	case file.JournalResourceClient:
+		client, err := repo.GetClient(input.ID)
+		if err != nil {
+			return QuotaPlanPayload{}, mapClientServiceError(err)
+		}
+		if client == nil {
+			return QuotaPlanPayload{}, ErrClientNotFound
+		}
+		if isReservedRuntimeClient(client) {
+			return QuotaPlanPayload{}, ErrForbidden
+		}
+		working := ensureDetachedClientSnapshot(repo, client)
+		working.QuotaPeriod = continueQuotaPeriod(working.QuotaPeriod, plan, now)
+		working.TouchMeta("", "", "")
+		if err := repo.SaveClient(working); err != nil {
+			return QuotaPlanPayload{}, mapClientServiceError(err)
+		}
+		return clientQuotaPlanPayload(working, now), nil
+	case file.JournalResourceTunnel:
+		tunnel, err := repo.GetTunnel(input.ID)
+		if err != nil {
+			return QuotaPlanPayload{}, mapTunnelNotFound(err)
+		}
+		if tunnel == nil {
+			return QuotaPlanPayload{}, ErrTunnelNotFound
+		}
+		working := ensureDetachedTunnelMutation(repo, tunnel)
+		working.QuotaPeriod = continueQuotaPeriod(working.QuotaPeriod, plan, now)
+		working.TouchMeta()
+		if err := repo.SaveTunnel(working); err != nil {
+			return QuotaPlanPayload{}, mapTunnelNotFound(err)
+		}
+		return tunnelQuotaPlanPayload(working, now), nil
+	case file.JournalResourceHost:
+		host, err := repo.GetHost(input.ID)
+		if err != nil {
+			return QuotaPlanPayload{}, mapHostNotFound(err)
+		}
+		if host == nil {
+			return QuotaPlanPayload{}, ErrHostNotFound
+		}
+		working := ensureDetachedHostMutation(repo, host)
+		working.QuotaPeriod = continueQuotaPeriod(working.QuotaPeriod, plan, now)
+		working.TouchMeta()
+		if err := repo.SaveHost(working, ""); err != nil {
+			return QuotaPlanPayload{}, mapHostNotFound(err)
+		}
+		return hostQuotaPlanPayload(working, now), nil
+	}
+	return QuotaPlanPayload{}, fmt.Errorf("%w: resource %q", ErrQuotaPlanInvalid, input.Resource)
+}
+
+// ResetDue starts a new period for every resource whose period has ended.
+// The stored records are reset in place, so traffic accounted while the reset
+// runs is carried into the new period instead of being lost.
+func (s DefaultQuotaPlanService) ResetDue(now time.Time) []file.QuotaReset {
+	return file.GetDb().ResetDueQuotaPeriods(now)
+}
+
+// Usages reports the live flow and time budget of every limited resource.
+func (s DefaultQuotaPlanService) Usages() []file.QuotaUsage {
+	return file.GetDb().QuotaUsages()
+}
+
+func (s DefaultQuotaPlanService) repo() QuotaPlanRepository {
+	if !isNilServiceValue(s.Repo) {
+		return s.Repo
+	}
+	if !isNilServiceValue(s.Backend.Repository) {
+		return s.Backend.Repository
+	}
+	return DefaultBackend().Repository
+}
+
+// continueQuotaPeriod applies a new plan. The running period goes on when
+// the boundaries stay the same; otherwise the new plan starts counting from
+// its current period without resetting the traffic already used in it.
+func continueQuotaPeriod(current, plan *file.QuotaPeriod, now time.Time) *file.QuotaPeriod {
+	if plan == nil {
+		return nil
+	}
+	plan.LastReset = plan.PeriodStart(now).Unix()
+	if current != nil && current.Period == plan.Period && current.Day == plan.Day && current.Timezone == plan.Timezone {
+		plan.LastReset = current.LastReset
+		if plan.Rollover {
+			plan.Carry = current.CarryBytes()
+		}
+	}
+	return plan
+}
+
+func newQuotaPlanPayload(resource string, id int, revision int64, plan *file.QuotaPeriod, limit, used int64, now time.Time) QuotaPlanPayload {
+	payload := QuotaPlanPayload{Resource: resource, ID: id, Revision: revision, LimitBytes: limit, UsedBytes: used}
+	if plan == nil {
+		return payload
+	}
+	start := plan.PeriodStart(now)
+	payload.Period = plan.Period
+	payload.Day = plan.Day
+	payload.Timezone = plan.Timezone
+	payload.Rollover = plan.Rollover
+	payload.CarryBytes = plan.CarryBytes()
+	payload.PeriodStart = start.Unix()
+	payload.NextReset = plan.NextReset(start).Unix()
+	return payload
+}
+
+func userQuotaPlanPayload(user *file.User, now time.Time) QuotaPlanPayload {
+	_, _, used := user.TotalTrafficTotals()
+	return newQuotaPlanPayload(file.JournalResourceUser, user.Id, user.Revision, user.QuotaPeriod, user.QuotaLimitBytes(), used, now)
+}
+
+func clientQuotaPlanPayload(client *file.Client, now time.Time) QuotaPlanPayload {
+	_, _, used := client.TotalTrafficTotals()
+	return newQuotaPlanPayload(file.JournalResourceClient, client.Id, client.Revision, client.QuotaPeriod, client.QuotaLimitBytes(), used, now)
+}
+
+func tunnelQuotaPlanPayload(tunnel *file.Tunnel, now time.Time) QuotaPlanPayload {
+	_, _, used := tunnel.ServiceTrafficTotals()
+	return newQuotaPlanPayload(file.JournalResourceTunnel, tunnel.Id, tunnel.Revision, tunnel.QuotaPeriod, tunnel.QuotaLimitBytes(), used, now)
+}
+
+func hostQuotaPlanPayload(host *file.Host, now time.Time) QuotaPlanPayload {
+	_, _, used := host.ServiceTrafficTotals()
+	return newQuotaPlanPayload(file.JournalResourceHost, host.Id, host.Revision, host.QuotaPeriod, host.QuotaLimitBytes(), used, now)
+}
@@ -0,0 +1,56 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/djylb/nps/lib/file"
+)
+
+func TestDefaultQuotaPlanServiceSetsAndKeepsRunningPeriod(t *testing.T) {
+	resetBackendTestDB(t)
+	repo := defaultRepository{}
+	if err := repo.CreateClient(&file.Client{Id: 2, VerifyKey: "vk-2", Status: true, Cnf: &file.Config{}, Flow: &file.Flow{}, FlowLimit: 4096}); err != nil {
+		t.Fatalf("CreateClient() error = %v", err)
+	}
+	service := DefaultQuotaPlanService{Repo: repo}
+	full := ResolveNodeAccessScope(Principal{Authenticated: true, Kind: "admin", IsAdmin: true})
+	scoped := ResolveNodeAccessScope(Principal{Authenticated: true, Kind: "client", ClientIDs: []int{2}})
+
+	if _, err := service.Set(SetQuotaPlanInput{Scope: scoped, Resource: file.JournalResourceClient, ID: 2, Period: file.QuotaPeriodDaily}); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("Set(client scope) error = %v, want ErrForbidden", err)
+	}
+	if _, err := service.Set(SetQuotaPlanInput{Scope: full, Resource: file.JournalResourceClient, ID: 2, Period: "hourly"}); !errors.Is(err, ErrQuotaPlanInvalid) {
+		t.Fatalf("Set(hourly) error = %v, want ErrQuotaPlanInvalid", err)
+	}
+	payload, err := service.Set(SetQuotaPlanInput{Scope: full, Resource: file.JournalResourceClient, ID: 2, Period: "Monthly", Day: 31, Rollover: true})
+	if err != nil || payload.Period != file.QuotaPeriodMonthly || payload.Day != 31 || payload.LimitBytes != 4096 ||
+		payload.NextReset <= payload.PeriodStart {
+		t.Fatalf("Set(monthly) = %+v, %v", payload, err)
+	}
+	stored, _ := file.GetDb().GetClient(2)
+	if stored.QuotaPeriod == nil || stored.QuotaPeriod.LastReset != payload.PeriodStart {
+		t.Fatalf("stored plan = %+v, want the current period marked as started", stored.QuotaPeriod)
+	}
+	if resets := service.ResetDue(time.Now()); len(resets) != 0 {
+		t.Fatalf("ResetDue() inside the period = %+v, want none", resets)
+	}
+
+	// Saving the same boundaries again keeps the running period and its carry.
+	stored.QuotaPeriod.Carry = 100
+	if _, err := service.Set(SetQuotaPlanInput{Scope: full, Resource: file.JournalResourceClient, ID: 2, Period: file.QuotaPeriodMonthly, Day: 31, Rollover: true}); err != nil {
+		t.Fatalf("Set(same plan) error = %v", err)
+	}
+	got, err := service.Get(QuotaPlanInput{Scope: scoped, Resource: file.JournalResourceClient, ID: 2})
+	if err != nil || got.CarryBytes != 100 || got.LimitBytes != 4196 {
+		t.Fatalf("Get(client scope) = %+v, %v, want carry 100 on top of the limit", got, err)
+	}
+
+	if _, err := service.Set(SetQuotaPlanInput{Scope: full, Resource: file.JournalResourceClient, ID: 2}); err != nil {
+		t.Fatalf("Set(clear) error = %v", err)
+	}
+	if stored, _ := file.GetDb().GetClient(2); stored.QuotaPeriod != nil {
+		t.Fatalf("plan after clear = %+v, want nil", stored.QuotaPeriod)
+	}
+}
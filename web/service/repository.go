@@ -57,6 +57,7 @@ func cloneGlobal(glob *file.Glob) *file.Glob {
 	return &file.Glob{
 		EntryAclMode:  glob.EntryAclMode,
 		EntryAclRules: glob.EntryAclRules,
+		WafRules:      glob.WafRules,
 	}
 }
 
@@ -155,7 +156,11 @@ func (defaultRepository) NextHostID() int {
 }
 
 func (defaultRepository) CreateUser(user *file.User) error {
-	return file.GetDb().NewUser(user)
+	if err := file.GetDb().NewUser(user); err != nil {
+		return err
+	}
+	journalUser(user.Id)
+	return nil
 }
 
 func (defaultRepository) GetUser(id int) (*file.User, error) {
@@ -187,11 +192,23 @@ func (defaultRepository) SupportsGetUserByExternalPlatformID() bool {
 }
 
 func (defaultRepository) SaveUser(user *file.User) error {
-	return file.GetDb().UpdateUser(user)
+	if err := file.GetDb().UpdateUser(user); err != nil {
+		return err
+	}
+	journalUser(user.Id)
+	return nil
 }
 
 func (defaultRepository) DeleteUser(id int) error {
-	return file.GetDb().DelUser(id)
+	clientIDs := journalClientIDsForUser(id)
+	if err := file.GetDb().DelUser(id); err != nil {
+		return err
+	}
+	journalUser(id)
+	for _, clientID := range clientIDs {
+		journalClient(clientID)
+	}
+	return nil
 }
 
 func (defaultRepository) SupportsDeleteUserCascadeClientRefs() bool {
@@ -203,7 +220,11 @@ func (defaultRepository) SupportsDetachedSnapshots() bool {
 }
 
 func (defaultRepository) CreateClient(client *file.Client) error {
-	return file.GetDb().NewClient(client)
+	if err := file.GetDb().NewClient(client); err != nil {
+		return err
+	}
+	journalClient(client.Id)
+	return nil
 }
 
 func (defaultRepository) GetClient(id int) (*file.Client, error) {
@@ -318,7 +339,11 @@ func (defaultRepository) SaveClient(client *file.Client) error {
 	if client == nil {
 		return errors.New("client is nil")
 	}
-	return file.GetDb().UpdateClient(client)
+	if err := file.GetDb().UpdateClient(client); err != nil {
+		return err
+	}
+	journalClient(client.Id)
+	return nil
 }
 
 func (defaultRepository) PersistClients() error {
@@ -331,7 +356,11 @@ func (defaultRepository) WithDeferredPersistence(run func() error) error {
 }
 
 func (defaultRepository) DeleteClient(id int) error {
-	return file.GetDb().DelClient(id)
+	if err := file.GetDb().DelClient(id); err != nil {
+		return err
+	}
+	journalClient(id)
+	return nil
 }
 
 func (defaultRepository) VerifyUserName(username string, exceptID int) bool {
@@ -348,7 +377,11 @@ func (defaultRepository) ReplaceClientVKeyIndex(oldKey, newKey string, clientID
 }
 
 func (defaultRepository) CreateTunnel(tunnel *file.Tunnel) error {
-	return file.GetDb().NewTask(tunnel)
+	if err := file.GetDb().NewTask(tunnel); err != nil {
+		return err
+	}
+	journalTunnel(tunnel.Id)
+	return nil
 }
 
 func (defaultRepository) GetTunnel(id int) (*file.Tunnel, error) {
@@ -364,15 +397,27 @@ func (defaultRepository) GetTunnel(id int) (*file.Tunnel, error) {
 
 func (defaultRepository) SaveTunnel(tunnel *file.Tunnel) error {
 	file.InitializeTunnelRuntime(tunnel)
-	return file.GetDb().UpdateTask(tunnel)
+	if err := file.GetDb().UpdateTask(tunnel); err != nil {
+		return err
+	}
+	journalTunnel(tunnel.Id)
+	return nil
 }
 
 func (defaultRepository) DeleteTunnelRecord(id int) error {
-	return file.GetDb().DelTask(id)
+	if err := file.GetDb().DelTask(id); err != nil {
+		return err
+	}
+	journalTunnel(id)
+	return nil
 }
 
 func (defaultRepository) CreateHost(host *file.Host) error {
-	return file.GetDb().NewHost(host)
+	if err := file.GetDb().NewHost(host); err != nil {
+		return err
+	}
+	journalHost(host.Id)
+	return nil
 }
 
 func (defaultRepository) GetHost(id int) (*file.Host, error) {
@@ -390,7 +435,11 @@ func (defaultRepository) SaveHost(host *file.Host, _ string) error {
 	if host == nil {
 		return errors.New("host is nil")
 	}
-	return file.GetDb().UpdateHost(host)
+	if err := file.GetDb().UpdateHost(host); err != nil {
+		return err
+	}
+	journalHost(host.Id)
+	return nil
 }
 
 func (defaultRepository) PersistHosts() error {
@@ -399,7 +448,11 @@ func (defaultRepository) PersistHosts() error {
 }
 
 func (defaultRepository) DeleteHostRecord(id int) error {
-	return file.GetDb().DelHost(id)
+	if err := file.GetDb().DelHost(id); err != nil {
+		return err
+	}
+	journalHost(id)
+	return nil
 }
 
 func (defaultRepository) HostExists(host *file.Host) bool {
@@ -411,7 +464,11 @@ func (defaultRepository) GetGlobal() *file.Glob {
 }
 
 func (defaultRepository) SaveGlobal(glob *file.Glob) error {
-	return file.GetDb().SaveGlobal(cloneGlobal(glob))
+	if err := file.GetDb().SaveGlobal(cloneGlobal(glob)); err != nil {
+		return err
+	}
+	journalGlobal()
+	return nil
 }
 
 func (defaultRepository) ClientOwnsTunnel(clientID, tunnelID int) bool {
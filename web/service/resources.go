@@ -8,7 +8,9 @@ import (
 
 	"github.com/djylb/nps/lib/common"
 	"github.com/djylb/nps/lib/crypt"
+	"github.com/djylb/nps/lib/edgecache"
 	"github.com/djylb/nps/lib/file"
+	"github.com/djylb/nps/lib/forwardauth"
 )
 
 type IndexService interface {
@@ -93,6 +95,7 @@ type TunnelListInput struct {
 	Search     string
 	Sort       string
 	Order      string
+	Selector   file.LabelSelector
 	Visibility ClientVisibility
 }
 
@@ -103,6 +106,7 @@ type HostListInput struct {
 	Search     string
 	Sort       string
 	Order      string
+	Selector   file.LabelSelector
 	Visibility ClientVisibility
 }
 
@@ -129,12 +133,16 @@ type AddTunnelInput struct {
 	TargetType     string
 	Target         string
 	ProxyProtocol  int
+	Balance        string
+	BalanceKey     string
 	LocalProxy     bool
 	Auth           string
 	Remark         string
+	Labels         map[string]string
 	Password       string
 	LocalPath      string
 	StripPre       string
+	ForwardAuth    string
 	EnableHTTP     bool
 	EnableSocks5   bool
 	EntryACLMode   int
@@ -159,12 +167,17 @@ type EditTunnelInput struct {
 	TargetType       string
 	Target           string
 	ProxyProtocol    int
+	Balance          string
+	BalanceKey       string
 	LocalProxy       bool
 	Auth             string
 	Remark           string
+	Labels           map[string]string
+	LabelsSpecified  bool
 	Password         string
 	LocalPath        string
 	StripPre         string
+	ForwardAuth      string
 	EnableHTTP       bool
 	EnableSocks5     bool
 	EntryACLMode     int
@@ -179,37 +192,67 @@ type EditTunnelInput struct {
 }
 
 type AddHostInput struct {
-	IsAdmin        bool
-	AllowUserLocal bool
-	ClientID       int
-	Host           string
-	Target         string
-	ProxyProtocol  int
-	LocalProxy     bool
-	Auth           string
-	Header         string
-	RespHeader     string
-	HostChange     string
-	Remark         string
-	Location       string
-	PathRewrite    string
-	RedirectURL    string
-	FlowLimit      int64
-	TimeLimit      string
-	RateLimit      int
-	MaxConnections int
-	EntryACLMode   int
-	EntryACLRules  string
-	Scheme         string
-	HTTPSJustProxy bool
-	TLSOffload     bool
-	AutoSSL        bool
-	KeyFile        string
-	CertFile       string
-	AutoHTTPS      bool
-	AutoCORS       bool
-	CompatMode     bool
-	TargetIsHTTPS  bool
+	IsAdmin            bool
+	AllowUserLocal     bool
+	ClientID           int
+	Host               string
+	Target             string
+	ProxyProtocol      int
+	Balance            string
+	BalanceKey         string
+	LocalProxy         bool
+	Auth               string
+	Header             string
+	RespHeader         string
+	HostChange         string
+	Remark             string
+	Labels             map[string]string
+	Location           string
+	PathRewrite        string
+	RedirectURL        string
+	FlowLimit          int64
+	TimeLimit          string
+	RateLimit          int
+	MaxConnections     int
+	EntryACLMode       int
+	EntryACLRules      string
+	Scheme             string
+	HTTPSJustProxy     bool
+	TLSOffload         bool
+	AutoSSL            bool
+	KeyFile            string
+	CertFile           string
+	AutoHTTPS          bool
+	AutoCORS           bool
+	RespCompress       bool
+	RespCompressTypes  string
+	RespCompressMin    int
+	Cache              bool
+	CacheTTL           int
+	ReqLimit           int
+	ReqLimitWindow     int
+	ReqLimitKey        string
+	ReqLimitPaths      string
+	ForwardAuth        string
+	ForwardAuthHeaders string
+	TLSMinVersion      string
+	TLSCipherProfile   string
+	TLSALPN            string
+	HSTS               string
+	CustomDomain       bool
+	CertID             int
+	AutoSSLDNS         string
+	ReqBodyRewrite     string
+	RespBodyRewrite    string
+	ClientCertCA       string
+	ClientCertMode     string
+	ClientCertSubjects string
+	AuthGate           bool
+	AuthGateEmails     string
+	AuthGateGroups     string
+	WAFRules           string
+	CompatMode         bool
+	TargetIsHTTPS      bool
 }
 
 type EditHostInput struct {
@@ -221,12 +264,16 @@ type EditHostInput struct {
 	Host                    string
 	Target                  string
 	ProxyProtocol           int
+	Balance                 string
+	BalanceKey              string
 	LocalProxy              bool
 	Auth                    string
 	Header                  string
 	RespHeader              string
 	HostChange              string
 	Remark                  string
+	Labels                  map[string]string
+	LabelsSpecified         bool
 	Location                string
 	PathRewrite             string
 	RedirectURL             string
@@ -245,6 +292,33 @@ type EditHostInput struct {
 	CertFile                string
 	AutoHTTPS               bool
 	AutoCORS                bool
+	RespCompress            bool
+	RespCompressTypes       string
+	RespCompressMin         int
+	Cache                   bool
+	CacheTTL                int
+	ReqLimit                int
+	ReqLimitWindow          int
+	ReqLimitKey             string
+	ReqLimitPaths           string
+	ForwardAuth             string
+	ForwardAuthHeaders      string
+	TLSMinVersion           string
+	TLSCipherProfile        string
+	TLSALPN                 string
+	HSTS                    string
+	CustomDomain            bool
+	CertID                  int
+	AutoSSLDNS              string
+	ReqBodyRewrite          string
+	RespBodyRewrite         string
+	ClientCertCA            string
+	ClientCertMode          string
+	ClientCertSubjects      string
+	AuthGate                bool
+	AuthGateEmails          string
+	AuthGateGroups          string
+	WAFRules                string
 	CompatMode              bool
 	TargetIsHTTPS           bool
 	SyncCertToMatchingHosts bool
@@ -259,29 +333,34 @@ type IndexMutationContext struct {
 }
 
 type TunnelWriteRequest struct {
-	ClientID       int
-	Port           int
-	ServerIP       string
-	Mode           string
-	TargetType     string
-	Target         string
-	ProxyProtocol  int
-	LocalProxy     bool
-	Auth           string
-	Remark         string
-	Password       string
-	LocalPath      string
-	StripPre       string
-	EnableHTTP     bool
-	EnableSocks5   bool
-	EntryACLMode   int
-	EntryACLRules  string
-	DestACLMode    int
-	DestACLRules   string
-	FlowLimit      int64
-	TimeLimit      string
-	RateLimit      int
-	MaxConnections int
+	ClientID        int
+	Port            int
+	ServerIP        string
+	Mode            string
+	TargetType      string
+	Target          string
+	ProxyProtocol   int
+	Balance         string
+	BalanceKey      string
+	LocalProxy      bool
+	Auth            string
+	Remark          string
+	Labels          map[string]string
+	LabelsSpecified bool
+	Password        string
+	LocalPath       string
+	StripPre        string
+	ForwardAuth     string
+	EnableHTTP      bool
+	EnableSocks5    bool
+	EntryACLMode    int
+	EntryACLRules   string
+	DestACLMode     int
+	DestACLRules    string
+	FlowLimit       int64
+	TimeLimit       string
+	RateLimit       int
+	MaxConnections  int
 }
 
 type AddTunnelRequest struct {
@@ -296,35 +375,66 @@ type EditTunnelRequest struct {
 }
 
 type HostWriteRequest struct {
-	ClientID       int
-	Host           string
-	Target         string
-	ProxyProtocol  int
-	LocalProxy     bool
-	Auth           string
-	Header         string
-	RespHeader     string
-	HostChange     string
-	Remark         string
-	Location       string
-	PathRewrite    string
-	RedirectURL    string
-	FlowLimit      int64
-	TimeLimit      string
-	RateLimit      int
-	MaxConnections int
-	EntryACLMode   int
-	EntryACLRules  string
-	Scheme         string
-	HTTPSJustProxy bool
-	TLSOffload     bool
-	AutoSSL        bool
-	KeyFile        string
-	CertFile       string
-	AutoHTTPS      bool
-	AutoCORS       bool
-	CompatMode     bool
-	TargetIsHTTPS  bool
+	ClientID           int
+	Host               string
+	Target             string
+	ProxyProtocol      int
+	Balance            string
+	BalanceKey         string
+	LocalProxy         bool
+	Auth               string
+	Header             string
+	RespHeader         string
+	HostChange         string
+	Remark             string
+	Labels             map[string]string
+	LabelsSpecified    bool
+	Location           string
+	PathRewrite        string
+	RedirectURL        string
+	FlowLimit          int64
+	TimeLimit          string
+	RateLimit          int
+	MaxConnections     int
+	EntryACLMode       int
+	EntryACLRules      string
+	Scheme             string
+	HTTPSJustProxy     bool
+	TLSOffload         bool
+	AutoSSL            bool
+	KeyFile            string
+	CertFile           string
+	AutoHTTPS          bool
+	AutoCORS           bool
+	RespCompress       bool
+	RespCompressTypes  string
+	RespCompressMin    int
+	Cache              bool
+	CacheTTL           int
+	ReqLimit           int
+	ReqLimitWindow     int
+	ReqLimitKey        string
+	ReqLimitPaths      string
+	ForwardAuth        string
+	ForwardAuthHeaders string
+	TLSMinVersion      string
+	TLSCipherProfile   string
+	TLSALPN            string
+	HSTS               string
+	CustomDomain       bool
+	CertID             int
+	AutoSSLDNS         string
+	ReqBodyRewrite     string
+	RespBodyRewrite    string
+	ClientCertCA       string
+	ClientCertMode     string
+	ClientCertSubjects string
+	AuthGate           bool
+	AuthGateEmails     string
+	AuthGateGroups     string
+	WAFRules           string
+	CompatMode         bool
+	TargetIsHTTPS      bool
 }
 
 type AddHostRequest struct {
@@ -352,12 +462,16 @@ func BuildAddTunnelInput(ctx IndexMutationContext, request AddTunnelRequest) Add
 		TargetType:     request.TargetType,
 		Target:         request.Target,
 		ProxyProtocol:  request.ProxyProtocol,
+		Balance:        request.Balance,
+		BalanceKey:     request.BalanceKey,
 		LocalProxy:     request.LocalProxy,
 		Auth:           request.Auth,
 		Remark:         request.Remark,
+		Labels:         request.Labels,
 		Password:       request.Password,
 		LocalPath:      request.LocalPath,
 		StripPre:       request.StripPre,
+		ForwardAuth:    request.ForwardAuth,
 		EnableHTTP:     request.EnableHTTP,
 		EnableSocks5:   request.EnableSocks5,
 		EntryACLMode:   request.EntryACLMode,
@@ -385,12 +499,17 @@ func BuildEditTunnelInput(ctx IndexMutationContext, request EditTunnelRequest) E
 		TargetType:       request.TargetType,
 		Target:           request.Target,
 		ProxyProtocol:    request.ProxyProtocol,
+		Balance:          request.Balance,
+		BalanceKey:       request.BalanceKey,
 		LocalProxy:       request.LocalProxy,
 		Auth:             request.Auth,
 		Remark:           request.Remark,
+		Labels:           request.Labels,
+		LabelsSpecified:  request.LabelsSpecified,
 		Password:         request.Password,
 		LocalPath:        request.LocalPath,
 		StripPre:         request.StripPre,
+		ForwardAuth:      request.ForwardAuth,
 		EnableHTTP:       request.EnableHTTP,
 		EnableSocks5:     request.EnableSocks5,
 		EntryACLMode:     request.EntryACLMode,
@@ -408,37 +527,67 @@ func BuildEditTunnelInput(ctx IndexMutationContext, request EditTunnelRequest) E
 func BuildAddHostInput(ctx IndexMutationContext, request AddHostRequest) AddHostInput {
 	policy := resolveIndexMutationPolicy(ctx)
 	return AddHostInput{
-		IsAdmin:        policy.isAdmin,
-		AllowUserLocal: policy.allowUserLocal,
-		ClientID:       request.ClientID,
-		Host:           request.Host,
-		Target:         request.Target,
-		ProxyProtocol:  request.ProxyProtocol,
-		LocalProxy:     request.LocalProxy,
-		Auth:           request.Auth,
-		Header:         request.Header,
-		RespHeader:     request.RespHeader,
-		HostChange:     request.HostChange,
-		Remark:         request.Remark,
-		Location:       request.Location,
-		PathRewrite:    request.PathRewrite,
-		RedirectURL:    request.RedirectURL,
-		FlowLimit:      request.FlowLimit,
-		TimeLimit:      request.TimeLimit,
-		RateLimit:      request.RateLimit,
-		MaxConnections: request.MaxConnections,
-		EntryACLMode:   request.EntryACLMode,
-		EntryACLRules:  request.EntryACLRules,
-		Scheme:         request.Scheme,
-		HTTPSJustProxy: request.HTTPSJustProxy,
-		TLSOffload:     request.TLSOffload,
-		AutoSSL:        request.AutoSSL,
-		KeyFile:        request.KeyFile,
-		CertFile:       request.CertFile,
-		AutoHTTPS:      request.AutoHTTPS,
-		AutoCORS:       request.AutoCORS,
-		CompatMode:     request.CompatMode,
-		TargetIsHTTPS:  request.TargetIsHTTPS,
+		IsAdmin:            policy.isAdmin,
+		AllowUserLocal:     policy.allowUserLocal,
+		ClientID:           request.ClientID,
+		Host:               request.Host,
+		Target:             request.Target,
+		ProxyProtocol:      request.ProxyProtocol,
+		Balance:            request.Balance,
+		BalanceKey:         request.BalanceKey,
+		LocalProxy:         request.LocalProxy,
+		Auth:               request.Auth,
+		Header:             request.Header,
+		RespHeader:         request.RespHeader,
+		HostChange:         request.HostChange,
+		Remark:             request.Remark,
+		Labels:             request.Labels,
+		Location:           request.Location,
+		PathRewrite:        request.PathRewrite,
+		RedirectURL:        request.RedirectURL,
+		FlowLimit:          request.FlowLimit,
+		TimeLimit:          request.TimeLimit,
+		RateLimit:          request.RateLimit,
+		MaxConnections:     request.MaxConnections,
+		EntryACLMode:       request.EntryACLMode,
+		EntryACLRules:      request.EntryACLRules,
+		Scheme:             request.Scheme,
+		HTTPSJustProxy:     request.HTTPSJustProxy,
+		TLSOffload:         request.TLSOffload,
+		AutoSSL:            request.AutoSSL,
+		KeyFile:            request.KeyFile,
+		CertFile:           request.CertFile,
+		AutoHTTPS:          request.AutoHTTPS,
+		AutoCORS:           request.AutoCORS,
+		RespCompress:       request.RespCompress,
+		RespCompressTypes:  request.RespCompressTypes,
+		RespCompressMin:    request.RespCompressMin,
+		Cache:              request.Cache,
+		CacheTTL:           request.CacheTTL,
+		ReqLimit:           request.ReqLimit,
+		ReqLimitWindow:     request.ReqLimitWindow,
+		ReqLimitKey:        request.ReqLimitKey,
+		ReqLimitPaths:      request.ReqLimitPaths,
+		ForwardAuth:        request.ForwardAuth,
+		ForwardAuthHeaders: request.ForwardAuthHeaders,
+		TLSMinVersion:      request.TLSMinVersion,
+		TLSCipherProfile:   request.TLSCipherProfile,
+		TLSALPN:            request.TLSALPN,
+		HSTS:               request.HSTS,
+		CustomDomain:       request.CustomDomain,
+		CertID:             request.CertID,
+		AutoSSLDNS:         request.AutoSSLDNS,
+		ReqBodyRewrite:     request.ReqBodyRewrite,
+		RespBodyRewrite:    request.RespBodyRewrite,
+		ClientCertCA:       request.ClientCertCA,
+		ClientCertMode:     request.ClientCertMode,
+		ClientCertSubjects: request.ClientCertSubjects,
+		AuthGate:           request.AuthGate,
+		AuthGateEmails:     request.AuthGateEmails,
+		AuthGateGroups:     request.AuthGateGroups,
+		WAFRules:           request.WAFRules,
+		CompatMode:         request.CompatMode,
+		TargetIsHTTPS:      request.TargetIsHTTPS,
 	}
 }
 
@@ -453,12 +602,16 @@ func BuildEditHostInput(ctx IndexMutationContext, request EditHostRequest) EditH
 		Host:                    request.Host,
 		Target:                  request.Target,
 		ProxyProtocol:           request.ProxyProtocol,
+		Balance:                 request.Balance,
+		BalanceKey:              request.BalanceKey,
 		LocalProxy:              request.LocalProxy,
 		Auth:                    request.Auth,
 		Header:                  request.Header,
 		RespHeader:              request.RespHeader,
 		HostChange:              request.HostChange,
 		Remark:                  request.Remark,
+		Labels:                  request.Labels,
+		LabelsSpecified:         request.LabelsSpecified,
 		Location:                request.Location,
 		PathRewrite:             request.PathRewrite,
 		RedirectURL:             request.RedirectURL,
@@ -477,6 +630,33 @@ func BuildEditHostInput(ctx IndexMutationContext, request EditHostRequest) EditH
 		CertFile:                request.CertFile,
 		AutoHTTPS:               request.AutoHTTPS,
 		AutoCORS:                request.AutoCORS,
+		RespCompress:            request.RespCompress,
+		RespCompressTypes:       request.RespCompressTypes,
+		RespCompressMin:         request.RespCompressMin,
+		Cache:                   request.Cache,
+		CacheTTL:                request.CacheTTL,
+		ReqLimit:                request.ReqLimit,
+		ReqLimitWindow:          request.ReqLimitWindow,
+		ReqLimitKey:             request.ReqLimitKey,
+		ReqLimitPaths:           request.ReqLimitPaths,
+		ForwardAuth:             request.ForwardAuth,
+		ForwardAuthHeaders:      request.ForwardAuthHeaders,
+		TLSMinVersion:           request.TLSMinVersion,
+		TLSCipherProfile:        request.TLSCipherProfile,
+		TLSALPN:                 request.TLSALPN,
+		HSTS:                    request.HSTS,
+		CustomDomain:            request.CustomDomain,
+		CertID:                  request.CertID,
+		AutoSSLDNS:              request.AutoSSLDNS,
+		ReqBodyRewrite:          request.ReqBodyRewrite,
+		RespBodyRewrite:         request.RespBodyRewrite,
+		ClientCertCA:            request.ClientCertCA,
+		ClientCertMode:          request.ClientCertMode,
+		ClientCertSubjects:      request.ClientCertSubjects,
+		AuthGate:                request.AuthGate,
+		AuthGateEmails:          request.AuthGateEmails,
+		AuthGateGroups:          request.AuthGateGroups,
+		WAFRules:                request.WAFRules,
 		CompatMode:              request.CompatMode,
 		TargetIsHTTPS:           request.TargetIsHTTPS,
 		SyncCertToMatchingHosts: request.SyncCertToMatchingHosts,
@@ -503,6 +683,16 @@ func (s DefaultIndexService) DashboardData(force bool) map[string]interface{} {
 }
 
 func (s DefaultIndexService) ListTunnels(input TunnelListInput) ([]*file.Tunnel, int) {
+	if input.Selector.Empty() {
+		return s.listTunnels(input)
+	}
+	offset, limit := input.Offset, input.Limit
+	input.Offset, input.Limit = 0, 0
+	rows, _ := s.listTunnels(input)
+	return selectLabeledWindow(rows, input.Selector, func(tunnel *file.Tunnel) map[string]string { return tunnel.Labels }, offset, limit)
+}
+
+func (s DefaultIndexService) listTunnels(input TunnelListInput) ([]*file.Tunnel, int) {
 	if !hasVisibilityScope(input.Visibility) || input.Visibility.IsAdmin {
 		rows, count := s.runtime().ListTunnels(input.Offset, input.Limit, input.Type, input.ClientID, input.Search, input.Sort, input.Order)
 		return cloneTunnelSnapshotList(rows), count
@@ -531,6 +721,16 @@ func hasVisibilityScope(visibility ClientVisibility) bool {
 }
 
 func (s DefaultIndexService) ListHosts(input HostListInput) ([]*file.Host, int) {
+	if input.Selector.Empty() {
+		return s.listHosts(input)
+	}
+	offset, limit := input.Offset, input.Limit
+	input.Offset, input.Limit = 0, 0
+	rows, _ := s.listHosts(input)
+	return selectLabeledWindow(rows, input.Selector, func(host *file.Host) map[string]string { return host.Labels }, offset, limit)
+}
+
+func (s DefaultIndexService) listHosts(input HostListInput) ([]*file.Host, int) {
 	if !hasVisibilityScope(input.Visibility) || input.Visibility.IsAdmin {
 		rows, count := s.runtime().ListHosts(input.Offset, input.Limit, input.ClientID, input.Search, input.Sort, input.Order)
 		return cloneHostSnapshotList(rows), count
@@ -557,6 +757,14 @@ func (s DefaultIndexService) ListHosts(input HostListInput) ([]*file.Host, int)
 func (s DefaultIndexService) AddTunnel(input AddTunnelInput) (TunnelMutation, error) {
 	id := s.repo().NextTunnelID()
 	entryACLMode, entryACLRules := normalizeEntryACLInput(input.EntryACLMode, input.EntryACLRules)
+	labels, err := normalizeLabelsInput(input.Labels)
+	if err != nil {
+		return TunnelMutation{}, err
+	}
+	forwardAuth, err := normalizeForwardAuthInput(input.ForwardAuth, "", input.IsAdmin)
+	if err != nil {
+		return TunnelMutation{}, err
+	}
 	tunnel := &file.Tunnel{
 		Port:       input.Port,
 		ServerIp:   input.ServerIP,
@@ -565,15 +773,19 @@ func (s DefaultIndexService) AddTunnel(input AddTunnelInput) (TunnelMutation, er
 		Target: &file.Target{
 			TargetStr:     sanitizeBridgeTarget(input.Target, input.IsAdmin, ""),
 			ProxyProtocol: input.ProxyProtocol,
+			Balance:       input.Balance,
+			BalanceKey:    input.BalanceKey,
 			LocalProxy:    localProxyEnabled(input.ClientID, input.LocalProxy, input.AllowUserLocal),
 		},
 		UserAuth:      file.NewMultiAccount(input.Auth),
 		Id:            id,
 		Status:        true,
 		Remark:        input.Remark,
+		Labels:        labels,
 		Password:      input.Password,
 		LocalPath:     input.LocalPath,
 		StripPre:      input.StripPre,
+		ForwardAuth:   forwardAuth,
 		HttpProxy:     input.EnableHTTP,
 		Socks5Proxy:   input.EnableSocks5,
 		EntryAclMode:  entryACLMode,
@@ -698,17 +910,31 @@ func (s DefaultIndexService) EditTunnel(input EditTunnelInput) (TunnelMutation,
 	working.Target = &file.Target{
 		TargetStr:     sanitizeBridgeTarget(input.Target, input.IsAdmin, targetFallback),
 		ProxyProtocol: input.ProxyProtocol,
+		Balance:       input.Balance,
+		BalanceKey:    input.BalanceKey,
 		LocalProxy:    localProxyEnabled(effectiveClientID, input.LocalProxy, input.AllowUserLocal),
 	}
 	working.UserAuth = file.NewMultiAccount(input.Auth)
 	working.Password = input.Password
 	working.LocalPath = input.LocalPath
 	working.StripPre = input.StripPre
+	forwardAuth, err := normalizeForwardAuthInput(input.ForwardAuth, working.ForwardAuth, input.IsAdmin)
+	if err != nil {
+		return TunnelMutation{}, err
+	}
+	working.ForwardAuth = forwardAuth
 	working.HttpProxy = input.EnableHTTP
 	working.Socks5Proxy = input.EnableSocks5
 	working.EntryAclMode, working.EntryAclRules = normalizeEntryACLInput(input.EntryACLMode, input.EntryACLRules)
 	working.DestAclMode, working.DestAclRules = normalizeDestinationACLInput(input.DestACLMode, input.DestACLRules)
 	working.Remark = input.Remark
+	if input.LabelsSpecified {
+		labels, err := normalizeLabelsInput(input.Labels)
+		if err != nil {
+			return TunnelMutation{}, err
+		}
+		working.Labels = labels
+	}
 	working.Flow.FlowLimit = input.FlowLimit
 	working.Flow.TimeLimit = common.GetTimeNoErrByStr(input.TimeLimit)
 	working.RateLimit = input.RateLimit
@@ -763,12 +989,18 @@ func (s DefaultIndexService) DeleteTunnel(id int) (TunnelMutation, error) {
 	if tunnel, err := repo.GetTunnel(id); err == nil && tunnel != nil {
 		deleted = ensureDetachedTunnelMutation(repo, tunnel)
 	}
+	trashID, err := trashTunnel(deleted)
+	if err != nil {
+		return TunnelMutation{}, err
+	}
 	if err := s.runtime().DeleteTunnel(id); err != nil {
 		if !isTaskNotRunning(err) {
+			discardTrashEntry(trashID)
 			return TunnelMutation{}, err
 		}
 	}
 	if err := repo.DeleteTunnelRecord(id); err != nil {
+		discardTrashEntry(trashID)
 		if errors.Is(err, file.ErrTaskNotFound) {
 			return TunnelMutation{}, ErrTunnelNotFound
 		}
@@ -894,13 +1126,19 @@ func (s DefaultIndexService) DeleteHost(id int) (HostMutation, error) {
 	if host, err := repo.GetHost(id); err == nil && host != nil {
 		deleted = ensureDetachedHostMutation(repo, host)
 	}
+	trashID, err := trashHost(deleted)
+	if err != nil {
+		return HostMutation{}, err
+	}
 	if err := repo.DeleteHostRecord(id); err != nil {
+		discardTrashEntry(trashID)
 		if errors.Is(err, file.ErrHostNotFound) {
 			return HostMutation{}, ErrHostNotFound
 		}
 		return HostMutation{}, err
 	}
 	s.runtime().RemoveHostCache(id)
+	edgecache.Default().ForgetHost(id)
 	if deleted == nil {
 		return HostMutation{ID: id}, nil
 	}
@@ -1009,6 +1247,18 @@ func changeHostStatusWithRepo(repo IndexRepository, id int, name, action string)
 		if err := applyBoolAction(&working.AutoCORS, action); err != nil {
 			return HostMutation{}, err
 		}
+	case "resp_compress":
+		if err := applyBoolAction(&working.RespCompress, action); err != nil {
+			return HostMutation{}, err
+		}
+	case "cache":
+		if err := applyBoolAction(&working.Cache, action); err != nil {
+			return HostMutation{}, err
+		}
+	case "auth_gate":
+		if err := applyBoolAction(&working.AuthGate, action); err != nil {
+			return HostMutation{}, err
+		}
 	case "compat_mode":
 		if err := applyBoolAction(&working.CompatMode, action); err != nil {
 			return HostMutation{}, err
@@ -1033,12 +1283,47 @@ func changeHostStatusWithRepo(repo IndexRepository, id int, name, action string)
 func (s DefaultIndexService) AddHost(input AddHostInput) (HostMutation, error) {
 	id := s.repo().NextHostID()
 	entryACLMode, entryACLRules := normalizeEntryACLInput(input.EntryACLMode, input.EntryACLRules)
+	labels, err := normalizeLabelsInput(input.Labels)
+	if err != nil {
+		return HostMutation{}, err
+	}
+	wafRules, err := normalizeWAFRulesInput(input.WAFRules)
+	if err != nil {
+		return HostMutation{}, err
+	}
+	forwardAuth, err := normalizeForwardAuthInput(input.ForwardAuth, "", input.IsAdmin)
+	if err != nil {
+		return HostMutation{}, err
+	}
+	clientCertMode, err := normalizeClientCertInput(input.ClientCertMode, input.ClientCertCA)
+	if err != nil {
+		return HostMutation{}, err
+	}
+	reqBodyRewrite, respBodyRewrite, err := normalizeBodyRewriteInput(input.ReqBodyRewrite, input.RespBodyRewrite)
+	if err != nil {
+		return HostMutation{}, err
+	}
+	autoSSLDNS, err := normalizeAutoSSLDNSInput(input.AutoSSLDNS, "", input.IsAdmin)
+	if err != nil {
+		return HostMutation{}, err
+	}
+	tlsPolicy, err := normalizeTLSPolicyInput(input.TLSMinVersion, input.TLSCipherProfile, input.TLSALPN, input.HSTS)
+	if err != nil {
+		return HostMutation{}, err
+	}
+	certID := max(input.CertID, 0)
+	certFile, keyFile, err := resolveHostCertInput(certID, 0, input.Host, input.CertFile, input.KeyFile, input.IsAdmin)
+	if err != nil {
+		return HostMutation{}, err
+	}
 	host := &file.Host{
 		Id:   id,
 		Host: input.Host,
 		Target: &file.Target{
 			TargetStr:     sanitizeBridgeTarget(input.Target, input.IsAdmin, ""),
 			ProxyProtocol: input.ProxyProtocol,
+			Balance:       input.Balance,
+			BalanceKey:    input.BalanceKey,
 			LocalProxy:    localProxyEnabled(input.ClientID, input.LocalProxy, input.AllowUserLocal),
 		},
 		UserAuth:         file.NewMultiAccount(input.Auth),
@@ -1046,6 +1331,7 @@ func (s DefaultIndexService) AddHost(input AddHostInput) (HostMutation, error) {
 		RespHeaderChange: input.RespHeader,
 		HostChange:       input.HostChange,
 		Remark:           input.Remark,
+		Labels:           labels,
 		Location:         input.Location,
 		PathRewrite:      input.PathRewrite,
 		RedirectURL:      input.RedirectURL,
@@ -1055,18 +1341,45 @@ func (s DefaultIndexService) AddHost(input AddHostInput) (HostMutation, error) {
 			FlowLimit: input.FlowLimit,
 			TimeLimit: common.GetTimeNoErrByStr(input.TimeLimit),
 		},
-		RateLimit:      input.RateLimit,
-		MaxConn:        input.MaxConnections,
-		Scheme:         normalizeScheme(input.Scheme),
-		HttpsJustProxy: input.HTTPSJustProxy,
-		TlsOffload:     input.TLSOffload,
-		AutoSSL:        input.AutoSSL,
-		KeyFile:        input.KeyFile,
-		CertFile:       input.CertFile,
-		AutoHttps:      input.AutoHTTPS,
-		AutoCORS:       input.AutoCORS,
-		CompatMode:     input.CompatMode,
-		TargetIsHttps:  input.TargetIsHTTPS,
+		RateLimit:          input.RateLimit,
+		MaxConn:            input.MaxConnections,
+		Scheme:             normalizeScheme(input.Scheme),
+		HttpsJustProxy:     input.HTTPSJustProxy,
+		TlsOffload:         input.TLSOffload,
+		AutoSSL:            input.AutoSSL,
+		AutoSSLDNS:         autoSSLDNS,
+		CustomDomain:       input.CustomDomain,
+		TLSMinVersion:      tlsPolicy.MinVersion,
+		TLSCipherProfile:   tlsPolicy.CipherProfile,
+		TLSALPN:            tlsPolicy.ALPN,
+		HSTS:               tlsPolicy.HSTS,
+		KeyFile:            keyFile,
+		CertFile:           certFile,
+		CertID:             certID,
+		AutoHttps:          input.AutoHTTPS,
+		AutoCORS:           input.AutoCORS,
+		RespCompress:       input.RespCompress,
+		RespCompressTypes:  input.RespCompressTypes,
+		RespCompressMin:    input.RespCompressMin,
+		Cache:              input.Cache,
+		CacheTTL:           input.CacheTTL,
+		ReqLimit:           input.ReqLimit,
+		ReqLimitWindow:     input.ReqLimitWindow,
+		ReqLimitKey:        input.ReqLimitKey,
+		ReqLimitPaths:      input.ReqLimitPaths,
+		ForwardAuth:        forwardAuth,
+		ForwardAuthHeaders: forwardauth.NormalizeHeaders(input.ForwardAuthHeaders),
+		ClientCertCA:       input.ClientCertCA,
+		ClientCertMode:     clientCertMode,
+		ClientCertSubjects: input.ClientCertSubjects,
+		ReqBodyRewrite:     reqBodyRewrite,
+		RespBodyRewrite:    respBodyRewrite,
+		AuthGate:           input.AuthGate,
+		AuthGateEmails:     input.AuthGateEmails,
+		AuthGateGroups:     input.AuthGateGroups,
+		WafRules:           wafRules,
+		CompatMode:         input.CompatMode,
+		TargetIsHttps:      input.TargetIsHTTPS,
 	}
 	host.TouchMeta()
 
@@ -1143,6 +1456,8 @@ func (s DefaultIndexService) EditHost(input EditHostInput) (HostMutation, error)
 	working.Target = &file.Target{
 		TargetStr:     sanitizeBridgeTarget(input.Target, input.IsAdmin, targetFallback),
 		ProxyProtocol: input.ProxyProtocol,
+		Balance:       input.Balance,
+		BalanceKey:    input.BalanceKey,
 		LocalProxy:    localProxyEnabled(effectiveClientID, input.LocalProxy, input.AllowUserLocal),
 	}
 	working.UserAuth = file.NewMultiAccount(input.Auth)
@@ -1150,16 +1465,33 @@ func (s DefaultIndexService) EditHost(input EditHostInput) (HostMutation, error)
 	working.RespHeaderChange = input.RespHeader
 	working.HostChange = input.HostChange
 	working.Remark = input.Remark
+	if input.LabelsSpecified {
+		labels, err := normalizeLabelsInput(input.Labels)
+		if err != nil {
+			return HostMutation{}, err
+		}
+		working.Labels = labels
+	}
 	working.Location = input.Location
 	working.PathRewrite = input.PathRewrite
 	working.RedirectURL = input.RedirectURL
 	working.EntryAclMode, working.EntryAclRules = normalizeEntryACLInput(input.EntryACLMode, input.EntryACLRules)
+	wafRules, err := normalizeWAFRulesInput(input.WAFRules)
+	if err != nil {
+		return HostMutation{}, err
+	}
+	working.WafRules = wafRules
 	working.Scheme = scheme
 	working.HttpsJustProxy = input.HTTPSJustProxy
 	working.TlsOffload = input.TLSOffload
 	working.AutoSSL = input.AutoSSL
-	working.KeyFile = input.KeyFile
-	working.CertFile = input.CertFile
+	working.CustomDomain = input.CustomDomain
+	certID := max(input.CertID, 0)
+	working.CertFile, working.KeyFile, err = resolveHostCertInput(certID, working.CertID, input.Host, input.CertFile, input.KeyFile, input.IsAdmin)
+	if err != nil {
+		return HostMutation{}, err
+	}
+	working.CertID = certID
 	working.Flow.FlowLimit = input.FlowLimit
 	working.Flow.TimeLimit = common.GetTimeNoErrByStr(input.TimeLimit)
 	working.RateLimit = input.RateLimit
@@ -1170,6 +1502,50 @@ func (s DefaultIndexService) EditHost(input EditHostInput) (HostMutation, error)
 	}
 	working.AutoHttps = input.AutoHTTPS
 	working.AutoCORS = input.AutoCORS
+	working.RespCompress = input.RespCompress
+	working.RespCompressTypes = input.RespCompressTypes
+	working.RespCompressMin = input.RespCompressMin
+	working.Cache = input.Cache
+	working.CacheTTL = input.CacheTTL
+	working.ReqLimit = input.ReqLimit
+	working.ReqLimitWindow = input.ReqLimitWindow
+	working.ReqLimitKey = input.ReqLimitKey
+	working.ReqLimitPaths = input.ReqLimitPaths
+	forwardAuth, err := normalizeForwardAuthInput(input.ForwardAuth, working.ForwardAuth, input.IsAdmin)
+	if err != nil {
+		return HostMutation{}, err
+	}
+	working.ForwardAuth = forwardAuth
+	working.ForwardAuthHeaders = forwardauth.NormalizeHeaders(input.ForwardAuthHeaders)
+	clientCertMode, err := normalizeClientCertInput(input.ClientCertMode, input.ClientCertCA)
+	if err != nil {
+		return HostMutation{}, err
+	}
+	working.ClientCertCA = input.ClientCertCA
+	working.ClientCertMode = clientCertMode
+	working.ClientCertSubjects = input.ClientCertSubjects
+	reqBodyRewrite, respBodyRewrite, err := normalizeBodyRewriteInput(input.ReqBodyRewrite, input.RespBodyRewrite)
+	if err != nil {
+		return HostMutation{}, err
+	}
+	working.ReqBodyRewrite = reqBodyRewrite
+	working.RespBodyRewrite = respBodyRewrite
+	autoSSLDNS, err := normalizeAutoSSLDNSInput(input.AutoSSLDNS, working.AutoSSLDNS, input.IsAdmin)
+	if err != nil {
+		return HostMutation{}, err
+	}
+	working.AutoSSLDNS = autoSSLDNS
+	tlsPolicy, err := normalizeTLSPolicyInput(input.TLSMinVersion, input.TLSCipherProfile, input.TLSALPN, input.HSTS)
+	if err != nil {
+		return HostMutation{}, err
+	}
+	working.TLSMinVersion = tlsPolicy.MinVersion
+	working.TLSCipherProfile = tlsPolicy.CipherProfile
+	working.TLSALPN = tlsPolicy.ALPN
+	working.HSTS = tlsPolicy.HSTS
+	working.AuthGate = input.AuthGate
+	working.AuthGateEmails = input.AuthGateEmails
+	working.AuthGateGroups = input.AuthGateGroups
 	working.CompatMode = input.CompatMode
 	working.TargetIsHttps = input.TargetIsHTTPS
 
@@ -1184,6 +1560,7 @@ func (s DefaultIndexService) EditHost(input EditHostInput) (HostMutation, error)
 		return HostMutation{}, mapHostNotFound(err)
 	}
 	s.runtime().RemoveHostCache(input.ID)
+	edgecache.Default().Purge(input.ID, "")
 	if err := s.syncCertToMatchingHosts(input, working); err != nil {
 		return HostMutation{}, err
 	}
@@ -1334,7 +1711,8 @@ func (s DefaultIndexService) syncCertToMatchingHosts(input EditHostInput, curren
 		default:
 			return err
 		}
-		if candidate == nil || !hostEligibleForCertSync(candidate) || !certDomainsCoverHostRule(domains, candidate.Host) {
+		// Hosts bound to a stored certificate follow the certificate store.
+		if candidate == nil || candidate.CertID > 0 || !hostEligibleForCertSync(candidate) || !certDomainsCoverHostRule(domains, candidate.Host) {
 			continue
 		}
 
@@ -866,6 +866,108 @@ func TestDefaultIndexServiceEditHostDoesNotMutateLiveHostOnSaveError(t *testing.
 	}
 }
 
+func TestDefaultIndexServiceEditHostKeepsForwardAuthForNonAdmins(t *testing.T) {
+	client := &file.Client{Id: 8, UserId: 9, Cnf: &file.Config{}, Flow: &file.Flow{}}
+	service := DefaultIndexService{
+		Backend: Backend{
+			Repository: stubRepository{
+				getHost: func(id int) (*file.Host, error) {
+					return &file.Host{
+						Id:          id,
+						Host:        "demo.example.com",
+						ForwardAuth: "https://auth.example.com/verify",
+						Client:      client,
+						Flow:        &file.Flow{},
+						Target:      &file.Target{TargetStr: "127.0.0.1:8080"},
+					}, nil
+				},
+				getClient: func(int) (*file.Client, error) {
+					return client, nil
+				},
+				saveHost: func(*file.Host, string) error {
+					return errors.New("save failed")
+				},
+			},
+			Runtime: stubRuntime{},
+		},
+	}
+	edit := func(forwardAuth string, isAdmin bool) error {
+		_, err := service.EditHost(EditHostInput{
+			ID:          28,
+			ClientID:    client.Id,
+			Host:        "demo.example.com",
+			Target:      "127.0.0.1:8080",
+			ForwardAuth: forwardAuth,
+			IsAdmin:     isAdmin,
+		})
+		return err
+	}
+
+	for _, endpoint := range []string{"http://169.254.169.254/latest/meta-data/", ""} {
+		if err := edit(endpoint, false); !errors.Is(err, ErrForbidden) {
+			t.Fatalf("EditHost() by a user with forward_auth %q error = %v, want ErrForbidden", endpoint, err)
+		}
+	}
+	if err := edit("https://auth.example.com/verify", false); err == nil || err.Error() != "save failed" {
+		t.Fatalf("EditHost() by a user keeping forward_auth error = %v, want save failed", err)
+	}
+	if err := edit("https://other.example.com/verify", true); err == nil || err.Error() != "save failed" {
+		t.Fatalf("EditHost() by an admin changing forward_auth error = %v, want save failed", err)
+	}
+}
+
+func TestDefaultIndexServiceEditHostLimitsAutoSSLDNSProvidersToAdmins(t *testing.T) {
+	client := &file.Client{Id: 8, UserId: 9, Cnf: &file.Config{}, Flow: &file.Flow{}}
+	service := DefaultIndexService{
+		Backend: Backend{
+			Repository: stubRepository{
+				getHost: func(id int) (*file.Host, error) {
+					return &file.Host{
+						Id:         id,
+						Host:       "demo.example.com",
+						AutoSSLDNS: "https://dns.example.com/hook",
+						Client:     client,
+						Flow:       &file.Flow{},
+						Target:     &file.Target{TargetStr: "127.0.0.1:8080"},
+					}, nil
+				},
+				getClient: func(int) (*file.Client, error) {
+					return client, nil
+				},
+				saveHost: func(*file.Host, string) error {
+					return errors.New("save failed")
+				},
+			},
+			Runtime: stubRuntime{},
+		},
+	}
+	edit := func(spec string, isAdmin bool) error {
+		_, err := service.EditHost(EditHostInput{
+			ID:         28,
+			ClientID:   client.Id,
+			Host:       "demo.example.com",
+			Target:     "127.0.0.1:8080",
+			AutoSSLDNS: spec,
+			IsAdmin:    isAdmin,
+		})
+		return err
+	}
+
+	for _, spec := range []string{"http://169.254.169.254/dns", "rfc2136://10.0.0.53:53?key=k"} {
+		if err := edit(spec, false); !errors.Is(err, ErrForbidden) {
+			t.Fatalf("EditHost() by a user with auto_ssl_dns %q error = %v, want ErrForbidden", spec, err)
+		}
+		if err := edit(spec, true); err == nil || err.Error() != "save failed" {
+			t.Fatalf("EditHost() by an admin with auto_ssl_dns %q error = %v, want save failed", spec, err)
+		}
+	}
+	for _, spec := range []string{"https://dns.example.com/hook", "off", ""} {
+		if err := edit(spec, false); err == nil || err.Error() != "save failed" {
+			t.Fatalf("EditHost() by a user with auto_ssl_dns %q error = %v, want save failed", spec, err)
+		}
+	}
+}
+
 func TestDefaultIndexServiceEditHostPropagatesUnexpectedClientLookupError(t *testing.T) {
 	errWant := errors.New("repository unavailable")
 	service := DefaultIndexService{
@@ -27,6 +27,15 @@ type Services struct {
 	Users                           UserService
 	Globals                         GlobalService
 	Index                           IndexService
+	Trash                           TrashService
+	History                         HistoryService
+	UsageSeries                     UsageSeriesService
+	QuotaPlans                      QuotaPlanService
+	HostCache                       HostCacheService
+	Certificates                    CertificateService
+	CertStore                       CertStoreService
+	Labels                          LabelService
+	Apply                           ApplyService
 }
 
 func BindDefaultServices(services Services, configProvider func() *servercfg.Snapshot) Services {
@@ -54,6 +63,15 @@ func BindDefaultServices(services Services, configProvider func() *servercfg.Sna
 	services.Users = bindUserService(services.Users, configProvider, repo, runtime, backend)
 	services.Globals = bindGlobalService(services.Globals, services.LoginPolicy, repo, backend)
 	services.Index = bindIndexService(services.Index, repo, runtime, backend)
+	services.Trash = bindTrashService(services.Trash, repo, runtime, backend)
+	services.History = bindHistoryService(services.History, repo, runtime, backend)
+	services.UsageSeries = bindUsageSeriesService(services.UsageSeries, repo, backend)
+	services.QuotaPlans = bindQuotaPlanService(services.QuotaPlans, repo, backend)
+	services.HostCache = bindHostCacheService(services.HostCache, repo, backend)
+	services.Certificates = bindCertificateService(services.Certificates, configProvider, repo, backend)
+	services.CertStore = bindCertStoreService(services.CertStore, repo, runtime, backend)
+	services.Labels = bindLabelService(services.Labels, services.Clients, services.Index, repo, backend)
+	services.Apply = bindApplyService(services.Apply, configProvider, services.Users, services.Clients, services.Index, services.Globals, repo, backend)
 	services.ManagementPlatforms = bindManagementPlatformStore(services.ManagementPlatforms, repo, backend)
 	services.NodeControl = bindNodeControlService(services.NodeControl, services.System, services.Authz, repo, runtime, backend)
 	return services
@@ -84,6 +102,15 @@ func MergeServices(base, overrides Services) Services {
 	mergeOptionalService(&merged.Users, overrides.Users)
 	mergeOptionalService(&merged.Globals, overrides.Globals)
 	mergeOptionalService(&merged.Index, overrides.Index)
+	mergeOptionalService(&merged.Trash, overrides.Trash)
+	mergeOptionalService(&merged.History, overrides.History)
+	mergeOptionalService(&merged.UsageSeries, overrides.UsageSeries)
+	mergeOptionalService(&merged.QuotaPlans, overrides.QuotaPlans)
+	mergeOptionalService(&merged.HostCache, overrides.HostCache)
+	mergeOptionalService(&merged.Certificates, overrides.Certificates)
+	mergeOptionalService(&merged.CertStore, overrides.CertStore)
+	mergeOptionalService(&merged.Labels, overrides.Labels)
+	mergeOptionalService(&merged.Apply, overrides.Apply)
 	return merged
 }
 
@@ -494,6 +521,280 @@ func bindManagementPlatformStore(service ManagementPlatformStore, repo Repositor
 	}
 }
 
+func bindTrashService(service TrashService, repo Repository, runtime Runtime, backend Backend) TrashService {
+	if isNilServiceValue(service) {
+		return DefaultTrashService{Repo: repo, Runtime: runtime, Backend: backend}
+	}
+	switch current := service.(type) {
+	case DefaultTrashService:
+		if current.Repo == nil {
+			current.Repo = repo
+		}
+		if current.Runtime == nil {
+			current.Runtime = runtime
+		}
+		current.Backend = backend
+		return current
+	case *DefaultTrashService:
+		if current == nil {
+			current = &DefaultTrashService{}
+		}
+		if current.Repo == nil {
+			current.Repo = repo
+		}
+		if current.Runtime == nil {
+			current.Runtime = runtime
+		}
+		current.Backend = backend
+		return current
+	default:
+		return service
+	}
+}
+
+func bindHistoryService(service HistoryService, repo Repository, runtime Runtime, backend Backend) HistoryService {
+	if isNilServiceValue(service) {
+		return DefaultHistoryService{Repo: repo, Runtime: runtime, Backend: backend}
+	}
+	switch current := service.(type) {
+	case DefaultHistoryService:
+		if current.Repo == nil {
+			current.Repo = repo
+		}
+		if current.Runtime == nil {
+			current.Runtime = runtime
+		}
+		current.Backend = backend
+		return current
+	case *DefaultHistoryService:
+		if current == nil {
+			current = &DefaultHistoryService{}
+		}
+		if current.Repo == nil {
+			current.Repo = repo
+		}
+		if current.Runtime == nil {
+			current.Runtime = runtime
+		}
+		current.Backend = backend
+		return current
+	default:
+		return service
+	}
+}
+
+func bindUsageSeriesService(service UsageSeriesService, repo Repository, backend Backend) UsageSeriesService {
+	if isNilServiceValue(service) {
+		return DefaultUsageSeriesService{Repo: repo, Backend: backend}
+	}
+	switch current := service.(type) {
+	case DefaultUsageSeriesService:
+		if current.Repo == nil {
+			current.Repo = repo
+		}
+		current.Backend = backend
+		return current
+	case *DefaultUsageSeriesService:
+		if current == nil {
+			current = &DefaultUsageSeriesService{}
+		}
+		if current.Repo == nil {
+			current.Repo = repo
+		}
+		current.Backend = backend
+		return current
+	default:
+		return service
+	}
+}
+
+func bindQuotaPlanService(service QuotaPlanService, repo Repository, backend Backend) QuotaPlanService {
+	if isNilServiceValue(service) {
+		return DefaultQuotaPlanService{Repo: repo, Backend: backend}
+	}
+	switch current := service.(type) {
+	case DefaultQuotaPlanService:
+		if current.Repo == nil {
+			current.Repo = repo
+		}
+		current.Backend = backend
+		return current
+	case *DefaultQuotaPlanService:
+		if current == nil {
+			current = &DefaultQuotaPlanService{}
+		}
+		if current.Repo == nil {
+			current.Repo = repo
+		}
+		current.Backend = backend
+		return current
+	default:
+		return service
+	}
+}
+
+func bindCertificateService(service CertificateService, configProvider func() *servercfg.Snapshot, repo Repository, backend Backend) CertificateService {
+	if isNilServiceValue(service) {
+		return DefaultCertificateService{Repo: repo, Backend: backend, ConfigProvider: configProvider}
+	}
+	switch current := service.(type) {
+	case DefaultCertificateService:
+		if current.Repo == nil {
+			current.Repo = repo
+		}
+		current.Backend = backend
+		current.ConfigProvider = configProvider
+		return current
+	case *DefaultCertificateService:
+		if current == nil {
+			current = &DefaultCertificateService{}
+		}
+		if current.Repo == nil {
+			current.Repo = repo
+		}
+		current.Backend = backend
+		current.ConfigProvider = configProvider
+		return current
+	default:
+		return service
+	}
+}
+
+func bindCertStoreService(service CertStoreService, repo Repository, runtime Runtime, backend Backend) CertStoreService {
+	if isNilServiceValue(service) {
+		return DefaultCertStoreService{Repo: repo, Runtime: runtime, Backend: backend}
+	}
+	switch current := service.(type) {
+	case DefaultCertStoreService:
+		if current.Repo == nil {
+			current.Repo = repo
+		}
+		if current.Runtime == nil {
+			current.Runtime = runtime
+		}
+		current.Backend = backend
+		return current
+	case *DefaultCertStoreService:
+		if current == nil {
+			current = &DefaultCertStoreService{}
+		}
+		if current.Repo == nil {
+			current.Repo = repo
+		}
+		if current.Runtime == nil {
+			current.Runtime = runtime
+		}
+		current.Backend = backend
+		return current
+	default:
+		return service
+	}
+}
+
+func bindHostCacheService(service HostCacheService, repo Repository, backend Backend) HostCacheService {
+	if isNilServiceValue(service) {
+		return DefaultHostCacheService{Repo: repo, Backend: backend}
+	}
+	switch current := service.(type) {
+	case DefaultHostCacheService:
+		if current.Repo == nil {
+			current.Repo = repo
+		}
+		current.Backend = backend
+		return current
+	case *DefaultHostCacheService:
+		if current == nil {
+			current = &DefaultHostCacheService{}
+		}
+		if current.Repo == nil {
+			current.Repo = repo
+		}
+		current.Backend = backend
+		return current
+	default:
+		return service
+	}
+}
+
+func bindLabelService(service LabelService, clients ClientService, index IndexService, repo Repository, backend Backend) LabelService {
+	if isNilServiceValue(service) {
+		return DefaultLabelService{Repo: repo, Clients: clients, Index: index, Backend: backend}
+	}
+	switch current := service.(type) {
+	case DefaultLabelService:
+		if current.Repo == nil {
+			current.Repo = repo
+		}
+		if current.Clients == nil {
+			current.Clients = clients
+		}
+		if current.Index == nil {
+			current.Index = index
+		}
+		current.Backend = backend
+		return current
+	case *DefaultLabelService:
+		if current == nil {
+			current = &DefaultLabelService{}
+		}
+		if current.Repo == nil {
+			current.Repo = repo
+		}
+		if current.Clients == nil {
+			current.Clients = clients
+		}
+		if current.Index == nil {
+			current.Index = index
+		}
+		current.Backend = backend
+		return current
+	default:
+		return service
+	}
+}
+
+func bindApplyService(service ApplyService, configProvider func() *servercfg.Snapshot, users UserService, clients ClientService, index IndexService, globals GlobalService, repo Repository, backend Backend) ApplyService {
+	bind := func(current *DefaultApplyService) {
+		if current.ConfigProvider == nil {
+			current.ConfigProvider = configProvider
+		}
+		if current.Repo == nil {
+			current.Repo = repo
+		}
+		if current.Users == nil {
+			current.Users = users
+		}
+		if current.Clients == nil {
+			current.Clients = clients
+		}
+		if current.Index == nil {
+			current.Index = index
+		}
+		if current.Globals == nil {
+			current.Globals = globals
+		}
+		current.Backend = backend
+	}
+	if isNilServiceValue(service) {
+		current := DefaultApplyService{}
+		bind(&current)
+		return current
+	}
+	switch current := service.(type) {
+	case DefaultApplyService:
+		bind(&current)
+		return current
+	case *DefaultApplyService:
+		if current == nil {
+			current = &DefaultApplyService{}
+		}
+		bind(current)
+		return current
+	default:
+		return service
+	}
+}
+
 func bindNodeControlService(service NodeControlService, system SystemService, authz AuthorizationService, repo Repository, runtime Runtime, backend Backend) NodeControlService {
 	if isNilServiceValue(service) {
 		current := DefaultNodeControlService{}
@@ -145,10 +145,12 @@ func cloneClientSnapshotForMutation(client *file.Client) *file.Client {
 		Addr:             client.Addr,
 		LocalAddr:        client.LocalAddr,
 		Remark:           client.Remark,
+		Labels:           file.CloneLabels(client.Labels),
 		Status:           client.Status,
 		IsConnect:        client.IsConnect,
 		ExpireAt:         client.ExpireAt,
 		FlowLimit:        client.FlowLimit,
+		QuotaPeriod:      file.CloneQuotaPeriod(client.QuotaPeriod),
 		RateLimit:        client.RateLimit,
 		Flow:             cloneClientFlow(client.Flow),
 		Rate:             client.Rate.Clone(),
@@ -193,6 +195,7 @@ func cloneAttachedOwnerUser(user *file.User) *file.User {
 		Status:             user.Status,
 		ExpireAt:           user.ExpireAt,
 		FlowLimit:          user.FlowLimit,
+		QuotaPeriod:        file.CloneQuotaPeriod(user.QuotaPeriod),
 		TotalFlow:          cloneClientFlow(user.TotalFlow),
 		TotalTraffic:       cloneTrafficStats(user.TotalTraffic),
 		MaxClients:         user.MaxClients,
@@ -204,6 +207,8 @@ func cloneAttachedOwnerUser(user *file.User) *file.User {
 		EntryAclRules:      user.EntryAclRules,
 		DestAclMode:        user.DestAclMode,
 		DestAclRules:       user.DestAclRules,
+		WafRules:           user.WafRules,
+		Labels:             file.CloneLabels(user.Labels),
 		Revision:           user.Revision,
 		UpdatedAt:          user.UpdatedAt,
 		NowConn:            user.NowConn,
@@ -233,6 +238,7 @@ func cloneUserForMutation(user *file.User) *file.User {
 		Status:             user.Status,
 		ExpireAt:           user.ExpireAt,
 		FlowLimit:          user.FlowLimit,
+		QuotaPeriod:        file.CloneQuotaPeriod(user.QuotaPeriod),
 		TotalFlow:          cloneClientFlow(user.TotalFlow),
 		TotalTraffic:       cloneTrafficStats(user.TotalTraffic),
 		MaxClients:         user.MaxClients,
@@ -244,6 +250,8 @@ func cloneUserForMutation(user *file.User) *file.User {
 		EntryAclRules:      user.EntryAclRules,
 		DestAclMode:        user.DestAclMode,
 		DestAclRules:       user.DestAclRules,
+		WafRules:           user.WafRules,
+		Labels:             file.CloneLabels(user.Labels),
 		Revision:           user.Revision,
 		UpdatedAt:          user.UpdatedAt,
 		NowConn:            user.NowConn,
@@ -275,6 +283,7 @@ func cloneTunnelForMutation(tunnel *file.Tunnel) *file.Tunnel {
 		Ports:          tunnel.Ports,
 		ExpireAt:       tunnel.ExpireAt,
 		FlowLimit:      tunnel.FlowLimit,
+		QuotaPeriod:    file.CloneQuotaPeriod(tunnel.QuotaPeriod),
 		RateLimit:      tunnel.RateLimit,
 		Flow:           cloneClientFlow(tunnel.Flow),
 		Rate:           tunnel.Rate.Clone(),
@@ -284,6 +293,7 @@ func cloneTunnelForMutation(tunnel *file.Tunnel) *file.Tunnel {
 		NowConn:        tunnel.NowConn,
 		Password:       tunnel.Password,
 		Remark:         tunnel.Remark,
+		Labels:         file.CloneLabels(tunnel.Labels),
 		TargetAddr:     tunnel.TargetAddr,
 		TargetType:     tunnel.TargetType,
 		EntryAclMode:   tunnel.EntryAclMode,
@@ -297,6 +307,7 @@ func cloneTunnelForMutation(tunnel *file.Tunnel) *file.Tunnel {
 		Socks5Proxy:    tunnel.Socks5Proxy,
 		LocalPath:      tunnel.LocalPath,
 		StripPre:       tunnel.StripPre,
+		ForwardAuth:    tunnel.ForwardAuth,
 		ReadOnly:       tunnel.ReadOnly,
 		Target:         cloneTargetForMutation(tunnel.Target),
 		UserAuth:       cloneMultiAccountForMutation(tunnel.UserAuth),
@@ -332,46 +343,76 @@ func cloneHostForMutation(host *file.Host) *file.Host {
 	}
 	host.RLock()
 	cloned := &file.Host{
-		Id:               host.Id,
-		Revision:         host.Revision,
-		UpdatedAt:        host.UpdatedAt,
-		Host:             host.Host,
-		HeaderChange:     host.HeaderChange,
-		RespHeaderChange: host.RespHeaderChange,
-		HostChange:       host.HostChange,
-		Location:         host.Location,
-		PathRewrite:      host.PathRewrite,
-		Remark:           host.Remark,
-		Scheme:           host.Scheme,
-		RedirectURL:      host.RedirectURL,
-		HttpsJustProxy:   host.HttpsJustProxy,
-		TlsOffload:       host.TlsOffload,
-		AutoSSL:          host.AutoSSL,
-		CertType:         host.CertType,
-		CertHash:         host.CertHash,
-		CertFile:         host.CertFile,
-		KeyFile:          host.KeyFile,
-		NoStore:          host.NoStore,
-		IsClose:          host.IsClose,
-		AutoHttps:        host.AutoHttps,
-		AutoCORS:         host.AutoCORS,
-		CompatMode:       host.CompatMode,
-		ExpireAt:         host.ExpireAt,
-		FlowLimit:        host.FlowLimit,
-		RateLimit:        host.RateLimit,
-		Flow:             cloneClientFlow(host.Flow),
-		Rate:             host.Rate.Clone(),
-		ServiceTraffic:   cloneTrafficStats(host.ServiceTraffic),
-		ServiceMeter:     host.ServiceMeter.Clone(),
-		MaxConn:          host.MaxConn,
-		NowConn:          host.NowConn,
-		Client:           host.Client,
-		EntryAclMode:     host.EntryAclMode,
-		EntryAclRules:    host.EntryAclRules,
-		TargetIsHttps:    host.TargetIsHttps,
-		Target:           cloneTargetForMutation(host.Target),
-		UserAuth:         cloneMultiAccountForMutation(host.UserAuth),
-		MultiAccount:     cloneMultiAccountForMutation(host.MultiAccount),
+		Id:                 host.Id,
+		Revision:           host.Revision,
+		UpdatedAt:          host.UpdatedAt,
+		Host:               host.Host,
+		HeaderChange:       host.HeaderChange,
+		RespHeaderChange:   host.RespHeaderChange,
+		HostChange:         host.HostChange,
+		Location:           host.Location,
+		PathRewrite:        host.PathRewrite,
+		Remark:             host.Remark,
+		Labels:             file.CloneLabels(host.Labels),
+		Scheme:             host.Scheme,
+		RedirectURL:        host.RedirectURL,
+		HttpsJustProxy:     host.HttpsJustProxy,
+		TlsOffload:         host.TlsOffload,
+		AutoSSL:            host.AutoSSL,
+		CertType:           host.CertType,
+		CertHash:           host.CertHash,
+		CertFile:           host.CertFile,
+		KeyFile:            host.KeyFile,
+		NoStore:            host.NoStore,
+		IsClose:            host.IsClose,
+		AutoHttps:          host.AutoHttps,
+		AutoCORS:           host.AutoCORS,
+		RespCompress:       host.RespCompress,
+		RespCompressTypes:  host.RespCompressTypes,
+		RespCompressMin:    host.RespCompressMin,
+		Cache:              host.Cache,
+		CacheTTL:           host.CacheTTL,
+		ReqLimit:           host.ReqLimit,
+		ReqLimitWindow:     host.ReqLimitWindow,
+		ReqLimitKey:        host.ReqLimitKey,
+		ReqLimitPaths:      host.ReqLimitPaths,
+		ForwardAuth:        host.ForwardAuth,
+		ForwardAuthHeaders: host.ForwardAuthHeaders,
+		TLSMinVersion:      host.TLSMinVersion,
+		TLSCipherProfile:   host.TLSCipherProfile,
+		TLSALPN:            host.TLSALPN,
+		HSTS:               host.HSTS,
+		CustomDomain:       host.CustomDomain,
+		CertID:             host.CertID,
+		AutoSSLDNS:         host.AutoSSLDNS,
+		ReqBodyRewrite:     host.ReqBodyRewrite,
+		RespBodyRewrite:    host.RespBodyRewrite,
+		ClientCertCA:       host.ClientCertCA,
+		ClientCertMode:     host.ClientCertMode,
+		ClientCertSubjects: host.ClientCertSubjects,
+		AuthGate:           host.AuthGate,
+		AuthGateEmails:     host.AuthGateEmails,
+		AuthGateGroups:     host.AuthGateGroups,
+		WafRules:           host.WafRules,
+		ReqLimiter:         host.ReqLimiter.Clone(),
+		CompatMode:         host.CompatMode,
+		ExpireAt:           host.ExpireAt,
+		FlowLimit:          host.FlowLimit,
+		QuotaPeriod:        file.CloneQuotaPeriod(host.QuotaPeriod),
+		RateLimit:          host.RateLimit,
+		Flow:               cloneClientFlow(host.Flow),
+		Rate:               host.Rate.Clone(),
+		ServiceTraffic:     cloneTrafficStats(host.ServiceTraffic),
+		ServiceMeter:       host.ServiceMeter.Clone(),
+		MaxConn:            host.MaxConn,
+		NowConn:            host.NowConn,
+		Client:             host.Client,
+		EntryAclMode:       host.EntryAclMode,
+		EntryAclRules:      host.EntryAclRules,
+		TargetIsHttps:      host.TargetIsHttps,
+		Target:             cloneTargetForMutation(host.Target),
+		UserAuth:           cloneMultiAccountForMutation(host.UserAuth),
+		MultiAccount:       cloneMultiAccountForMutation(host.MultiAccount),
 	}
 	host.RUnlock()
 	cloneHealthForMutation(&cloned.Health, &host.Health)
@@ -0,0 +1,370 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/djylb/nps/lib/file"
+	"github.com/djylb/nps/lib/logs"
+)
+
+type TrashService interface {
+	List() ([]file.TrashEntry, error)
+	Restore(int) (TrashRestoreResult, error)
+	Purge(int) (file.TrashEntry, error)
+}
+
+type TrashRepository interface {
+	GetUser(int) (*file.User, error)
+	GetClient(int) (*file.Client, error)
+	CreateClient(*file.Client) error
+	VerifyVKey(string, int) bool
+	GetTunnel(int) (*file.Tunnel, error)
+	CreateTunnel(*file.Tunnel) error
+	DeleteTunnelRecord(int) error
+	GetHost(int) (*file.Host, error)
+	CreateHost(*file.Host) error
+	HostExists(*file.Host) bool
+}
+
+type TrashRuntime interface {
+	AddTunnel(*file.Tunnel) error
+	RemoveHostCache(int)
+}
+
+type DefaultTrashService struct {
+	Repo    TrashRepository
+	Runtime TrashRuntime
+	Backend Backend
+}
+
+// TrashRestoreSkip reports an owned tunnel or host that could not be brought
+// back with its client. It stays in the recycle bin as its own entry.
+type TrashRestoreSkip struct {
+	Resource   string `json:"resource"`
+	ResourceID int    `json:"resource_id"`
+	TrashID    int    `json:"trash_id,omitempty"`
+	Reason     string `json:"reason"`
+}
+
+// TrashRestoreResult lists what came back. Revisions holds the revision
+// each restored record was written at, keyed by journal resource.
+type TrashRestoreResult struct {
+	Entry     file.TrashEntry
+	ClientID  int
+	TunnelIDs []int
+	HostIDs   []int
+	Revisions map[string][]file.RevisionRef
+	Skipped   []TrashRestoreSkip
+}
+
+func (r *TrashRestoreResult) restored(resource string, id int, revision int64) {
+	if r.Revisions == nil {
+		r.Revisions = make(map[string][]file.RevisionRef)
+	}
+	r.Revisions[resource] = append(r.Revisions[resource], file.RevisionRef{ID: id, Revision: revision})
+}
+
+func (s DefaultTrashService) List() ([]file.TrashEntry, error) {
+	bin := file.CurrentRecycleBin()
+	if bin == nil {
+		return nil, ErrTrashDisabled
+	}
+	return bin.List()
+}
+
+// Restore recreates the records held by a trash entry with their original
+// ids and removes the entry. A client entry restores the client first, then
+// its tunnels and hosts, including ones deleted separately beforehand;
+// children that conflict with live records are kept in the bin and reported
+// in Skipped instead of failing the whole restore.
+func (s DefaultTrashService) Restore(id int) (TrashRestoreResult, error) {
+	bin := file.CurrentRecycleBin()
+	if bin == nil {
+		return TrashRestoreResult{}, ErrTrashDisabled
+	}
+	entry, err := bin.Get(id)
+	if err != nil {
+		return TrashRestoreResult{}, mapTrashServiceError(err)
+	}
+	result := TrashRestoreResult{Entry: entry}
+	switch entry.Resource {
+	case file.TrashResourceClient:
+		if err := s.restoreClient(entry.Client); err != nil {
+			return TrashRestoreResult{}, err
+		}
+		result.ClientID = entry.Client.Id
+		result.restored(file.JournalResourceClient, entry.Client.Id, entry.Client.Revision)
+	case file.TrashResourceTunnel, file.TrashResourceHost:
+		if err := s.requireLiveClient(entry); err != nil {
+			return TrashRestoreResult{}, err
+		}
+	default:
+		return TrashRestoreResult{}, fmt.Errorf("unsupported trash resource %q", entry.Resource)
+	}
+
+	single := entry.Resource != file.TrashResourceClient
+	for _, tunnel := range entry.Tunnels {
+		if err := s.restoreTunnel(tunnel); err != nil {
+			if single {
+				return TrashRestoreResult{}, err
+			}
+			result.Skipped = append(result.Skipped, trashSkip(bin, file.TrashResourceTunnel, tunnel.Id, err, func() (file.TrashEntry, error) {
+				return bin.TrashTunnel(tunnel)
+			}))
+			continue
+		}
+		result.TunnelIDs = append(result.TunnelIDs, tunnel.Id)
+		result.restored(file.JournalResourceTunnel, tunnel.Id, tunnel.Revision)
+	}
+	for _, host := range entry.Hosts {
+		if err := s.restoreHost(host); err != nil {
+			if single {
+				return TrashRestoreResult{}, err
+			}
+			result.Skipped = append(result.Skipped, trashSkip(bin, file.TrashResourceHost, host.Id, err, func() (file.TrashEntry, error) {
+				return bin.TrashHost(host)
+			}))
+			continue
+		}
+		result.HostIDs = append(result.HostIDs, host.Id)
+		result.restored(file.JournalResourceHost, host.Id, host.Revision)
+	}
+	if _, err := bin.Remove(id); err != nil {
+		logs.Warn("remove restored trash entry %d error: %v", id, err)
+	}
+	if !single {
+		s.restoreClientEntries(bin, entry.Client.Id, &result)
+	}
+	return result, nil
+}
+
+// restoreClientEntries brings back tunnels and hosts that were deleted on
+// their own before their client was, since those entries cannot be restored
+// while the client is gone.
+func (s DefaultTrashService) restoreClientEntries(bin *file.RecycleBin, clientID int, result *TrashRestoreResult) {
+	items, err := bin.List()
+	if err != nil {
+		logs.Warn("list recycle bin for client %d error: %v", clientID, err)
+		return
+	}
+	for _, item := range items {
+		for _, tunnel := range item.Tunnels {
+			if item.Resource != file.TrashResourceTunnel || tunnel.Client == nil || tunnel.Client.Id != clientID {
+				continue
+			}
+			if err := s.restoreTunnel(tunnel); err != nil {
+				result.Skipped = append(result.Skipped, TrashRestoreSkip{Resource: item.Resource, ResourceID: tunnel.Id, TrashID: item.ID, Reason: err.Error()})
+				continue
+			}
+			result.TunnelIDs = append(result.TunnelIDs, tunnel.Id)
+			result.restored(file.JournalResourceTunnel, tunnel.Id, tunnel.Revision)
+			_, _ = bin.Remove(item.ID)
+		}
+		for _, host := range item.Hosts {
+			if item.Resource != file.TrashResourceHost || host.Client == nil || host.Client.Id != clientID {
+				continue
+			}
+			if err := s.restoreHost(host); err != nil {
+				result.Skipped = append(result.Skipped, TrashRestoreSkip{Resource: item.Resource, ResourceID: host.Id, TrashID: item.ID, Reason: err.Error()})
+				continue
+			}
+			result.HostIDs = append(result.HostIDs, host.Id)
+			result.restored(file.JournalResourceHost, host.Id, host.Revision)
+			_, _ = bin.Remove(item.ID)
+		}
+	}
+}
+
+func (s DefaultTrashService) Purge(id int) (file.TrashEntry, error) {
+	bin := file.CurrentRecycleBin()
+	if bin == nil {
+		return file.TrashEntry{}, ErrTrashDisabled
+	}
+	entry, err := bin.Remove(id)
+	if err != nil {
+		return file.TrashEntry{}, mapTrashServiceError(err)
+	}
+	return entry, nil
+}
+
+func (s DefaultTrashService) restoreClient(client *file.Client) error {
+	if client == nil {
+		return ErrClientNotFound
+	}
+	repo := s.repo()
+	if existing, err := repo.GetClient(client.Id); err == nil && existing != nil {
+		return ErrTrashRestoreTaken
+	}
+	if ownerID := client.OwnerID(); ownerID > 0 {
+		if _, err := repo.GetUser(ownerID); err != nil {
+			return mapUserServiceError(err)
+		}
+	}
+	managers := client.ManagerUserIDs[:0]
+	for _, userID := range client.ManagerUserIDs {
+		if _, err := repo.GetUser(userID); err == nil {
+			managers = append(managers, userID)
+		}
+	}
+	client.ManagerUserIDs = managers
+	if !repo.VerifyVKey(client.VerifyKey, client.Id) {
+		return ErrClientVKeyDuplicate
+	}
+	client.IsConnect = false
+	client.NowConn = 0
+	return mapClientServiceError(repo.CreateClient(client))
+}
+
+func (s DefaultTrashService) requireLiveClient(entry file.TrashEntry) error {
+	clientID := 0
+	switch {
+	case len(entry.Tunnels) > 0 && entry.Tunnels[0].Client != nil:
+		clientID = entry.Tunnels[0].Client.Id
+	case len(entry.Hosts) > 0 && entry.Hosts[0].Client != nil:
+		clientID = entry.Hosts[0].Client.Id
+	}
+	if client, err := s.repo().GetClient(clientID); err != nil || client == nil {
+		return ErrClientNotFound
+	}
+	return nil
+}
+
+func (s DefaultTrashService) restoreTunnel(tunnel *file.Tunnel) error {
+	if tunnel == nil || tunnel.Client == nil {
+		return ErrClientNotFound
+	}
+	repo := s.repo()
+	if existing, err := repo.GetTunnel(tunnel.Id); err == nil && existing != nil {
+		return ErrTrashRestoreTaken
+	}
+	tunnel.RunStatus = false
+	tunnel.NowConn = 0
+	if err := repo.CreateTunnel(tunnel); err != nil {
+		return mapClientServiceError(err)
+	}
+	if !tunnel.Status {
+		return nil
+	}
+	if err := s.runtime().AddTunnel(tunnel); err != nil {
+		if rollbackErr := repo.DeleteTunnelRecord(tunnel.Id); rollbackErr != nil {
+			logs.Error("rollback restored tunnel %d error: %v", tunnel.Id, rollbackErr)
+		}
+		return fmt.Errorf("%w: %v", ErrPortUnavailable, err)
+	}
+	return nil
+}
+
+func (s DefaultTrashService) restoreHost(host *file.Host) error {
+	if host == nil || host.Client == nil {
+		return ErrClientNotFound
+	}
+	repo := s.repo()
+	if existing, err := repo.GetHost(host.Id); err == nil && existing != nil {
+		return ErrTrashRestoreTaken
+	}
+	if repo.HostExists(host) {
+		return ErrHostExists
+	}
+	host.NowConn = 0
+	if err := repo.CreateHost(host); err != nil {
+		return mapClientServiceError(err)
+	}
+	s.runtime().RemoveHostCache(host.Id)
+	return nil
+}
+
+func (s DefaultTrashService) repo() TrashRepository {
+	if !isNilServiceValue(s.Repo) {
+		return s.Repo
+	}
+	if !isNilServiceValue(s.Backend.Repository) {
+		return s.Backend.Repository
+	}
+	return DefaultBackend().Repository
+}
+
+func (s DefaultTrashService) runtime() TrashRuntime {
+	if !isNilServiceValue(s.Runtime) {
+		return s.Runtime
+	}
+	if !isNilServiceValue(s.Backend.Runtime) {
+		return s.Backend.Runtime
+	}
+	return DefaultBackend().Runtime
+}
+
+func trashSkip(bin *file.RecycleBin, resource string, id int, reason error, keep func() (file.TrashEntry, error)) TrashRestoreSkip {
+	skip := TrashRestoreSkip{Resource: resource, ResourceID: id, Reason: reason.Error()}
+	if kept, err := keep(); err != nil {
+		logs.Warn("keep unrestored %s %d in recycle bin error: %v", resource, id, err)
+	} else {
+		skip.TrashID = kept.ID
+	}
+	return skip
+}
+
+func mapTrashServiceError(err error) error {
+	switch {
+	case errors.Is(err, file.ErrTrashEntryNotFound):
+		return ErrTrashEntryNotFound
+	case errors.Is(err, file.ErrRecycleBinDisabled):
+		return ErrTrashDisabled
+	default:
+		return err
+	}
+}
+
+// trashClient moves a client and the tunnels and hosts it owns into the
+// recycle bin before they are deleted. It returns the entry id, or 0 when
+// the recycle bin is disabled and the delete is permanent.
+func trashClient(repo ClientRepository, client *file.Client) (int, error) {
+	bin := file.CurrentRecycleBin()
+	if bin == nil || client == nil {
+		return 0, nil
+	}
+	var tunnels []*file.Tunnel
+	repo.RangeTunnels(func(tunnel *file.Tunnel) bool {
+		if tunnel.Client != nil && tunnel.Client.Id == client.Id {
+			tunnels = append(tunnels, tunnel)
+		}
+		return true
+	})
+	var hosts []*file.Host
+	repo.RangeHosts(func(host *file.Host) bool {
+		if host.Client != nil && host.Client.Id == client.Id {
+			hosts = append(hosts, host)
+		}
+		return true
+	})
+	entry, err := bin.TrashClient(client, tunnels, hosts)
+	return entry.ID, err
+}
+
+func trashTunnel(tunnel *file.Tunnel) (int, error) {
+	bin := file.CurrentRecycleBin()
+	if bin == nil || tunnel == nil {
+		return 0, nil
+	}
+	entry, err := bin.TrashTunnel(tunnel)
+	return entry.ID, err
+}
+
+func trashHost(host *file.Host) (int, error) {
+	bin := file.CurrentRecycleBin()
+	if bin == nil || host == nil {
+		return 0, nil
+	}
+	entry, err := bin.TrashHost(host)
+	return entry.ID, err
+}
+
+// discardTrashEntry drops an entry written for a delete that did not happen.
+func discardTrashEntry(id int) {
+	if id == 0 {
+		return
+	}
+	if _, err := file.CurrentRecycleBin().Remove(id); err != nil {
+		logs.Warn("discard trash entry %d error: %v", id, err)
+	}
+}
@@ -0,0 +1,182 @@
+package service
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/djylb/nps/lib/file"
+)
+
+func enableTestRecycleBin(t *testing.T) *file.RecycleBin {
+	t.Helper()
+	bin, err := file.OpenRecycleBin(filepath.Join(t.TempDir(), "trash.json"), file.RecycleBinOptions{Retention: time.Hour})
+	if err != nil {
+		t.Fatalf("OpenRecycleBin() error = %v", err)
+	}
+	previous := file.ReplaceRecycleBin(bin)
+	t.Cleanup(func() { file.ReplaceRecycleBin(previous) })
+	return bin
+}
+
+func trashTestRuntime(started *[]int) stubRuntime {
+	return stubRuntime{
+		addTunnel: func(tunnel *file.Tunnel) error {
+			*started = append(*started, tunnel.Id)
+			return nil
+		},
+		deleteClientResources: func(clientID int) {
+			db := file.GetDb()
+			db.RangeTasks(func(tunnel *file.Tunnel) bool {
+				if tunnel.Client != nil && tunnel.Client.Id == clientID {
+					_ = db.DelTask(tunnel.Id)
+				}
+				return true
+			})
+			db.RangeHosts(func(host *file.Host) bool {
+				if host.Client != nil && host.Client.Id == clientID {
+					_ = db.DelHost(host.Id)
+				}
+				return true
+			})
+		},
+	}
+}
+
+func TestDeletedClientRestoresWithItsTunnelsAndHosts(t *testing.T) {
+	resetBackendTestDB(t)
+	enableTestRecycleBin(t)
+	db := file.GetDb()
+	for _, user := range []*file.User{
+		{Id: 1, Username: "owner", Password: "secret", Status: 1, TotalFlow: &file.Flow{}},
+		{Id: 2, Username: "manager", Password: "secret", Status: 1, TotalFlow: &file.Flow{}},
+	} {
+		if err := db.NewUser(user); err != nil {
+			t.Fatalf("NewUser(%d) error = %v", user.Id, err)
+		}
+	}
+	client := &file.Client{Id: 3, OwnerUserID: 1, ManagerUserIDs: []int{2}, VerifyKey: "vk", Status: true, Cnf: &file.Config{}, Flow: &file.Flow{}, EntryAclMode: file.AclBlacklist, EntryAclRules: "10.0.0.1"}
+	if err := db.NewClient(client); err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	if err := db.NewTask(&file.Tunnel{Id: 4, Port: 18080, Mode: "tcp", Status: true, Client: client, Flow: &file.Flow{}, Target: &file.Target{TargetStr: "127.0.0.1:80"}}); err != nil {
+		t.Fatalf("NewTask() error = %v", err)
+	}
+	if err := db.NewHost(&file.Host{Id: 5, Host: "a.example.com", Location: "/", Scheme: "all", Client: client, Flow: &file.Flow{}, Target: &file.Target{TargetStr: "127.0.0.1:80"}}); err != nil {
+		t.Fatalf("NewHost() error = %v", err)
+	}
+
+	var started []int
+	runtime := trashTestRuntime(&started)
+	index := DefaultIndexService{Repo: defaultRepository{}, Runtime: runtime}
+	if _, err := index.DeleteTunnel(4); err != nil {
+		t.Fatalf("DeleteTunnel() error = %v", err)
+	}
+	clients := DefaultClientService{Repo: defaultRepository{}, Runtime: runtime}
+	if _, err := clients.Delete(3); err == nil {
+		t.Fatal("Delete() should keep refusing clients that still own hosts")
+	}
+	if _, err := index.DeleteHost(5); err != nil {
+		t.Fatalf("DeleteHost() error = %v", err)
+	}
+	if _, err := clients.Delete(3); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := db.GetClient(3); err == nil {
+		t.Fatal("Delete() should remove the client from the live store")
+	}
+	if _, err := db.GetTask(4); err == nil {
+		t.Fatal("Delete() should remove owned tunnels from the live store")
+	}
+
+	trash := DefaultTrashService{Repo: defaultRepository{}, Runtime: runtime}
+	items, err := trash.List()
+	if err != nil || len(items) != 3 || items[2].Resource != file.TrashResourceClient {
+		t.Fatalf("List() = %+v, %v, want tunnel, host and client entries", items, err)
+	}
+	if _, err := trash.Restore(items[0].ID); !errors.Is(err, ErrClientNotFound) {
+		t.Fatalf("Restore(tunnel without client) error = %v, want ErrClientNotFound", err)
+	}
+
+	result, err := trash.Restore(items[2].ID)
+	if err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	if result.ClientID != 3 || len(result.TunnelIDs) != 1 || len(result.HostIDs) != 1 || len(result.Skipped) != 0 {
+		t.Fatalf("Restore() = %+v", result)
+	}
+	restored, err := db.GetClient(3)
+	if err != nil {
+		t.Fatalf("GetClient(restored) error = %v", err)
+	}
+	if restored.VerifyKey != "vk" || len(restored.ManagerUserIDs) != 1 || restored.ManagerUserIDs[0] != 2 || restored.EntryAclRules != "10.0.0.1" {
+		t.Fatalf("restored client = %+v, want managers and ACL kept", restored)
+	}
+	if tunnel, err := db.GetTask(4); err != nil || tunnel.Client.Id != 3 || tunnel.Port != 18080 {
+		t.Fatalf("restored tunnel = %+v, %v", tunnel, err)
+	}
+	if host, err := db.GetHostById(5); err != nil || host.Client.Id != 3 {
+		t.Fatalf("restored host = %+v, %v", host, err)
+	}
+	if len(started) != 1 || started[0] != 4 {
+		t.Fatalf("started tunnels = %v, want the enabled tunnel restarted", started)
+	}
+	if items, _ = trash.List(); len(items) != 0 {
+		t.Fatalf("List() after restore = %+v, want empty", items)
+	}
+}
+
+func TestDefaultTrashServiceKeepsConflictingHostAndPurges(t *testing.T) {
+	resetBackendTestDB(t)
+	enableTestRecycleBin(t)
+	db := file.GetDb()
+	client := &file.Client{Id: 1, VerifyKey: "vk", Status: true, Cnf: &file.Config{}, Flow: &file.Flow{}}
+	if err := db.NewClient(client); err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	if err := db.NewHost(&file.Host{Id: 2, Host: "a.example.com", Location: "/", Scheme: "all", Client: client, Flow: &file.Flow{}, Target: &file.Target{TargetStr: "127.0.0.1:80"}}); err != nil {
+		t.Fatalf("NewHost() error = %v", err)
+	}
+
+	var started []int
+	runtime := trashTestRuntime(&started)
+	index := DefaultIndexService{Repo: defaultRepository{}, Runtime: runtime}
+	if _, err := index.DeleteHost(2); err != nil {
+		t.Fatalf("DeleteHost() error = %v", err)
+	}
+	if err := db.NewHost(&file.Host{Id: 3, Host: "a.example.com", Location: "/", Scheme: "all", Client: client, Flow: &file.Flow{}, Target: &file.Target{TargetStr: "127.0.0.1:81"}}); err != nil {
+		t.Fatalf("NewHost(replacement) error = %v", err)
+	}
+
+	trash := DefaultTrashService{Repo: defaultRepository{}, Runtime: runtime}
+	items, _ := trash.List()
+	if len(items) != 1 || items[0].Resource != file.TrashResourceHost {
+		t.Fatalf("List() = %+v, want the deleted host", items)
+	}
+	if _, err := trash.Restore(items[0].ID); !errors.Is(err, ErrHostExists) {
+		t.Fatalf("Restore(conflict) error = %v, want ErrHostExists", err)
+	}
+	if _, err := trash.Purge(items[0].ID); err != nil {
+		t.Fatalf("Purge() error = %v", err)
+	}
+	if _, err := trash.Restore(items[0].ID); !errors.Is(err, ErrTrashEntryNotFound) {
+		t.Fatalf("Restore(purged) error = %v, want ErrTrashEntryNotFound", err)
+	}
+}
+
+func TestDeleteIsPermanentWithoutRecycleBin(t *testing.T) {
+	resetBackendTestDB(t)
+	previous := file.ReplaceRecycleBin(nil)
+	t.Cleanup(func() { file.ReplaceRecycleBin(previous) })
+	if err := file.GetDb().NewClient(&file.Client{Id: 1, VerifyKey: "vk", Status: true, Cnf: &file.Config{}, Flow: &file.Flow{}}); err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+
+	if _, err := (DefaultClientService{Repo: defaultRepository{}, Runtime: stubRuntime{}}).Delete(1); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := (DefaultTrashService{}).List(); !errors.Is(err, ErrTrashDisabled) {
+		t.Fatalf("List() error = %v, want ErrTrashDisabled", err)
+	}
+}
@@ -0,0 +1,194 @@
+package service
+
+import (
+	"strings"
+	"time"
+
+	"github.com/djylb/nps/lib/file"
+)
+
+type UsageSeriesService interface {
+	Series(UsageSeriesInput) (UsageSeriesPayload, error)
+}
+
+type UsageSeriesRepository interface {
+	GetUser(int) (*file.User, error)
+	GetClient(int) (*file.Client, error)
+	GetTunnel(int) (*file.Tunnel, error)
+	GetHost(int) (*file.Host, error)
+}
+
+type DefaultUsageSeriesService struct {
+	Repo    UsageSeriesRepository
+	Backend Backend
+}
+
+// UsageSeriesInput selects the traffic of one resource type. Resolution
+// defaults to hour; To defaults to now and From to one hour, day or month
+// before To for minute, hour and day points.
+type UsageSeriesInput struct {
+	Scope      NodeAccessScope
+	Resource   string
+	IDs        []int
+	Resolution string
+	From       int64
+	To         int64
+}
+
+type UsageSeriesPayload struct {
+	Resource   string            `json:"resource"`
+	Resolution string            `json:"resolution"`
+	From       int64             `json:"from"`
+	To         int64             `json:"to"`
+	Items      []UsageSeriesItem `json:"items"`
+}
+
+type UsageSeriesItem struct {
+	Resource string             `json:"resource"`
+	ID       int                `json:"id"`
+	Points   []UsageSeriesPoint `json:"points"`
+	Totals   UsageSeriesPoint   `json:"totals"`
+}
+
+// UsageSeriesPoint is the traffic of one bucket starting at Time. In totals
+// Time is the first bucket and MaxConnections the peak of all buckets.
+type UsageSeriesPoint struct {
+	Time           int64 `json:"time"`
+	InBytes        int64 `json:"in_bytes"`
+	OutBytes       int64 `json:"out_bytes"`
+	TotalBytes     int64 `json:"total_bytes"`
+	MaxConnections int32 `json:"max_connections"`
+}
+
+var usageSeriesDefaultSpans = map[string]time.Duration{
+	file.TrafficResolutionMinute: time.Hour,
+	file.TrafficResolutionHour:   24 * time.Hour,
+	file.TrafficResolutionDay:    31 * 24 * time.Hour,
+}
+
+func (s DefaultUsageSeriesService) Series(input UsageSeriesInput) (UsageSeriesPayload, error) {
+	if !input.Scope.CanViewUsage() {
+		return UsageSeriesPayload{}, ErrForbidden
+	}
+	input.Resource = strings.ToLower(strings.TrimSpace(input.Resource))
+	input.Resolution = strings.ToLower(strings.TrimSpace(input.Resolution))
+	if input.Resolution == "" {
+		input.Resolution = file.TrafficResolutionHour
+	}
+	span, ok := usageSeriesDefaultSpans[input.Resolution]
+	if !ok {
+		return UsageSeriesPayload{}, ErrUsageQueryInvalid
+	}
+	switch input.Resource {
+	case file.JournalResourceUser, file.JournalResourceClient, file.JournalResourceTunnel, file.JournalResourceHost:
+	default:
+		return UsageSeriesPayload{}, ErrUsageQueryInvalid
+	}
+	if input.To <= 0 {
+		input.To = time.Now().Unix()
+	}
+	if input.From <= 0 {
+		input.From = input.To - int64(span/time.Second)
+	}
+	if input.From >= input.To {
+		return UsageSeriesPayload{}, ErrUsageQueryInvalid
+	}
+	recorder := file.CurrentTrafficRecorder()
+	if recorder == nil {
+		return UsageSeriesPayload{}, ErrUsageSeriesDisabled
+	}
+	for _, id := range input.IDs {
+		if !s.allows(input.Scope, input.Resource, id) {
+			return UsageSeriesPayload{}, ErrForbidden
+		}
+	}
+
+	points, err := recorder.Query(file.TrafficSeriesQuery{
+		Resolution: input.Resolution,
+		Resource:   input.Resource,
+		IDs:        input.IDs,
+		From:       input.From,
+		To:         input.To,
+	})
+	if err != nil {
+		return UsageSeriesPayload{}, err
+	}
+	payload := UsageSeriesPayload{
+		Resource:   input.Resource,
+		Resolution: input.Resolution,
+		From:       input.From,
+		To:         input.To,
+		Items:      []UsageSeriesItem{},
+	}
+	// Without ids every recorded resource is listed; scoped callers only see
+	// the ones they can access today.
+	allowed := make(map[int]bool)
+	for _, point := range points {
+		if len(input.IDs) == 0 {
+			if _, checked := allowed[point.ID]; !checked {
+				allowed[point.ID] = s.allows(input.Scope, input.Resource, point.ID)
+			}
+			if !allowed[point.ID] {
+				continue
+			}
+		}
+		if count := len(payload.Items); count == 0 || payload.Items[count-1].ID != point.ID {
+			payload.Items = append(payload.Items, UsageSeriesItem{
+				Resource: input.Resource,
+				ID:       point.ID,
+				Points:   []UsageSeriesPoint{},
+				Totals:   UsageSeriesPoint{Time: point.Time},
+			})
+		}
+		item := &payload.Items[len(payload.Items)-1]
+		item.Points = append(item.Points, UsageSeriesPoint{
+			Time:           point.Time,
+			InBytes:        point.InBytes,
+			OutBytes:       point.OutBytes,
+			TotalBytes:     point.InBytes + point.OutBytes,
+			MaxConnections: point.Connections,
+		})
+		item.Totals.InBytes += point.InBytes
+		item.Totals.OutBytes += point.OutBytes
+		item.Totals.TotalBytes += point.InBytes + point.OutBytes
+		if point.Connections > item.Totals.MaxConnections {
+			item.Totals.MaxConnections = point.Connections
+		}
+	}
+	return payload, nil
+}
+
+// allows reports whether scope may read the usage of a resource. Full access
+// also covers ids that no longer exist; tunnels and hosts follow their client.
+func (s DefaultUsageSeriesService) allows(scope NodeAccessScope, resource string, id int) bool {
+	if scope.IsFullAccess() {
+		return true
+	}
+	repo := s.repo()
+	switch resource {
+	case file.JournalResourceUser:
+		user, err := repo.GetUser(id)
+		return err == nil && scope.AllowsUser(user)
+	case file.JournalResourceClient:
+		client, err := repo.GetClient(id)
+		return err == nil && scope.AllowsClient(client)
+	case file.JournalResourceTunnel:
+		tunnel, err := repo.GetTunnel(id)
+		return err == nil && tunnel != nil && scope.AllowsClient(tunnel.Client)
+	case file.JournalResourceHost:
+		host, err := repo.GetHost(id)
+		return err == nil && host != nil && scope.AllowsClient(host.Client)
+	default:
+		return false
+	}
+}
+
+func (s DefaultUsageSeriesService) repo() UsageSeriesRepository {
+	if !isNilServiceValue(s.Repo) {
+		return s.Repo
+	}
+	if !isNilServiceValue(s.Backend.Repository) {
+		return s.Backend.Repository
+	}
+	return DefaultBackend().Repository
+}
@@ -0,0 +1,61 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/djylb/nps/lib/file"
+)
+
+func TestDefaultUsageSeriesServiceScopesClientCallers(t *testing.T) {
+	resetBackendTestDB(t)
+	repo := defaultRepository{}
+	for _, id := range []int{2, 3} {
+		if err := repo.CreateClient(&file.Client{Id: id, VerifyKey: fmt.Sprintf("vk-%d", id), Status: true, Cnf: &file.Config{}, Flow: &file.Flow{}}); err != nil {
+			t.Fatalf("CreateClient(%d) error = %v", id, err)
+		}
+	}
+	service := DefaultUsageSeriesService{Repo: repo}
+	full := ResolveNodeAccessScope(Principal{Authenticated: true, Kind: "admin", IsAdmin: true})
+	if _, err := service.Series(UsageSeriesInput{Scope: full, Resource: file.JournalResourceClient}); !errors.Is(err, ErrUsageSeriesDisabled) {
+		t.Fatalf("Series() without recorder error = %v, want ErrUsageSeriesDisabled", err)
+	}
+
+	recorder := file.NewTrafficRecorder(filepath.Join(t.TempDir(), "usage"), file.TrafficSeriesOptions{})
+	previous := file.ReplaceTrafficRecorder(recorder)
+	t.Cleanup(func() { file.ReplaceTrafficRecorder(previous) })
+	if err := recorder.Sample(); err != nil {
+		t.Fatalf("Sample(baseline) error = %v", err)
+	}
+	for _, id := range []int{2, 3} {
+		client, _ := file.GetDb().GetClient(id)
+		if err := client.ObserveServiceTraffic(int64(id)*100, int64(id)); err != nil {
+			t.Fatalf("ObserveServiceTraffic() error = %v", err)
+		}
+	}
+	if err := recorder.Sample(); err != nil {
+		t.Fatalf("Sample() error = %v", err)
+	}
+
+	payload, err := service.Series(UsageSeriesInput{Scope: full, Resource: "CLIENT"})
+	if err != nil || payload.Resolution != file.TrafficResolutionHour || len(payload.Items) != 2 {
+		t.Fatalf("Series(full) = %+v, %v, want both clients at hour resolution", payload, err)
+	}
+	if totals := payload.Items[1].Totals; payload.Items[1].ID != 3 || totals.InBytes != 300 || totals.TotalBytes != 303 {
+		t.Fatalf("client 3 totals = %+v", totals)
+	}
+
+	scoped := ResolveNodeAccessScope(Principal{Authenticated: true, Kind: "client", ClientIDs: []int{2}})
+	payload, err = service.Series(UsageSeriesInput{Scope: scoped, Resource: file.JournalResourceClient, Resolution: file.TrafficResolutionMinute})
+	if err != nil || len(payload.Items) != 1 || payload.Items[0].ID != 2 || len(payload.Items[0].Points) != 1 {
+		t.Fatalf("Series(client scope) = %+v, %v, want only client 2", payload, err)
+	}
+	if _, err := service.Series(UsageSeriesInput{Scope: scoped, Resource: file.JournalResourceClient, IDs: []int{3}}); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("Series(foreign id) error = %v, want ErrForbidden", err)
+	}
+	if _, err := service.Series(UsageSeriesInput{Scope: full, Resource: file.JournalResourceClient, Resolution: "week"}); !errors.Is(err, ErrUsageQueryInvalid) {
+		t.Fatalf("Series(bad resolution) error = %v, want ErrUsageQueryInvalid", err)
+	}
+}
@@ -62,6 +62,8 @@ type AddUserInput struct {
 	EntryACLRules         string
 	DestACLMode           int
 	DestACLRules          string
+	WAFRules              string
+	Labels                map[string]string
 }
 
 type EditUserInput struct {
@@ -87,6 +89,9 @@ type EditUserInput struct {
 	EntryACLRules         string
 	DestACLMode           int
 	DestACLRules          string
+	WAFRules              string
+	Labels                map[string]string
+	LabelsSpecified       bool
 }
 
 type UserListRow struct {
@@ -203,6 +208,12 @@ func (s DefaultUserService) Add(input AddUserInput) (UserMutation, error) {
 	}
 	user.EntryAclMode, user.EntryAclRules = normalizeEntryACLInput(input.EntryACLMode, input.EntryACLRules)
 	user.DestAclMode, user.DestAclRules = normalizeDestinationACLInput(input.DestACLMode, input.DestACLRules)
+	if user.WafRules, err = normalizeWAFRulesInput(input.WAFRules); err != nil {
+		return UserMutation{}, err
+	}
+	if user.Labels, err = normalizeLabelsInput(input.Labels); err != nil {
+		return UserMutation{}, err
+	}
 	user.TouchMeta()
 	if err := s.repo().CreateUser(user); err != nil {
 		return UserMutation{}, err
@@ -267,6 +278,14 @@ func (s DefaultUserService) Edit(input EditUserInput) (UserMutation, error) {
 	working.RateLimit = normalizeNonNegative(input.RateLimit)
 	working.EntryAclMode, working.EntryAclRules = normalizeEntryACLInput(input.EntryACLMode, input.EntryACLRules)
 	working.DestAclMode, working.DestAclRules = normalizeDestinationACLInput(input.DestACLMode, input.DestACLRules)
+	if working.WafRules, err = normalizeWAFRulesInput(input.WAFRules); err != nil {
+		return UserMutation{}, err
+	}
+	if input.LabelsSpecified {
+		if working.Labels, err = normalizeLabelsInput(input.Labels); err != nil {
+			return UserMutation{}, err
+		}
+	}
 	working.EnsureTotalFlow()
 	if input.ResetFlow {
 		working.ResetTotalTraffic()